@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/TBD54566975/did-dht/pkg/archive"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+var (
+	restoreFrom     string
+	restoreTo       string
+	restoreSnapshot string
+)
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().StringVar(&restoreFrom, "from", "", "archive uri to restore from, e.g. s3://bucket/prefix")
+	restoreCmd.Flags().StringVar(&restoreTo, "to", "", "storage uri to restore into, e.g. bolt://diddht.db")
+	restoreCmd.Flags().StringVar(&restoreSnapshot, "snapshot", "", "key of the snapshot to restore (default is the latest)")
+	_ = restoreCmd.MarkFlagRequired("from")
+	_ = restoreCmd.MarkFlagRequired("to")
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a gateway's records from an archived snapshot",
+	Long: `Restore writes every record and record version in an archived snapshot into a gateway's storage.
+Records already in the storage are overwritten with their archived versions.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bucket, err := archive.OpenBucket(restoreFrom)
+		if err != nil {
+			logrus.WithError(err).Error("failed to open archive")
+			return err
+		}
+		db, err := storage.NewStorage(restoreTo)
+		if err != nil {
+			logrus.WithError(err).Error("failed to open storage")
+			return err
+		}
+		defer db.Close()
+
+		count, err := archive.Restore(context.Background(), bucket, restoreSnapshot, db)
+		if err != nil {
+			logrus.WithError(err).WithField("restored", count).Error("failed to restore snapshot")
+			return err
+		}
+		fmt.Printf("Restored %d records\n", count)
+		return nil
+	},
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/client"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/keymgmt"
+)
+
+const (
+	defaultGateway = "https://diddht.tbddev.org"
+	// requestTimeout bounds each command's requests to the gateways
+	requestTimeout = 30 * time.Second
+)
+
+var (
+	gateways []string
+	keyDir   string
+
+	docControllers          []string
+	docAlsoKnownAs          []string
+	docServices             []string
+	docTypes                []int
+	docAuthoritativeGateway []string
+
+	createPublish    bool
+	createSupersedes string
+
+	inspectFile string
+
+	resolveLocal   bool
+	bootstrapPeers []string
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{createCmd, resolveCmd, publishCmd, updateCmd, deactivateCmd, inspectPacketCmd} {
+		rootCmd.AddCommand(cmd)
+		cmd.Flags().StringSliceVar(&gateways, "gateway", []string{defaultGateway}, "base urls of the gateways to call, tried in order")
+	}
+	for _, cmd := range []*cobra.Command{createCmd, publishCmd, updateCmd, deactivateCmd} {
+		cmd.Flags().StringVar(&keyDir, "key-dir", "", "directory of the key files of your dids (default is $HOME/.diddht/keys)")
+	}
+	for _, cmd := range []*cobra.Command{createCmd, updateCmd} {
+		cmd.Flags().StringSliceVar(&docControllers, "controller", nil, "dids controlling the did")
+		cmd.Flags().StringSliceVar(&docAlsoKnownAs, "also-known-as", nil, "alternative identifiers of the did, as absolute uris")
+		cmd.Flags().StringArrayVar(&docServices, "service", nil, "service of the did as id,type,endpoint, e.g. dwn,DecentralizedWebNode,https://dwn.example")
+		cmd.Flags().IntSliceVar(&docTypes, "type", nil, "indexed types of the did, e.g. 1 for Organization")
+		cmd.Flags().StringSliceVar(&docAuthoritativeGateway, "authoritative-gateway", nil, "fully qualified domain names of the did's authoritative gateways")
+	}
+	for _, cmd := range []*cobra.Command{resolveCmd, inspectPacketCmd} {
+		cmd.Flags().BoolVar(&resolveLocal, "local", false, "resolve from the dht through a node of our own rather than a gateway")
+		cmd.Flags().StringSliceVar(&bootstrapPeers, "bootstrap-peer", nil, "host:port of a peer the --local node joins the dht through (default is the gateway's bootstrap peers)")
+	}
+	createCmd.Flags().BoolVar(&createPublish, "publish", true, "publish the did once created")
+	createCmd.Flags().StringVar(&createSupersedes, "supersedes", "", "did this one replaces, whose key file signs over the new did")
+	inspectPacketCmd.Flags().StringVar(&inspectFile, "file", "", "file holding a dns packet, or - for stdin, to inspect instead of a did's record")
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a did:dht DID",
+	Long: `Create generates the identity key of a new DID, saves it to a key file with the DID's document, and publishes the
+document through a gateway. To rotate to a new identity key, create a DID superseding the old one with --supersedes.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := keymgmt.GenerateKey()
+		if err != nil {
+			logrus.WithError(err).Error("failed to generate identity key")
+			return err
+		}
+		file := keyFile{Key: key}
+		if err = file.apply(cmd); err != nil {
+			return err
+		}
+		if createSupersedes != "" {
+			if _, err = readKeyFile(createSupersedes); err != nil {
+				logrus.WithError(err).Error("failed to read key file of the superseded did")
+				return err
+			}
+			file.Supersedes = createSupersedes
+		}
+		put, err := file.sign()
+		if err != nil {
+			return err
+		}
+		path, err := file.write()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s, with its key saved to %s\n", key.DID(), path)
+		if !createPublish {
+			return nil
+		}
+		return publish(key.DID(), put)
+	},
+}
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve <did>",
+	Short: "Resolve a did:dht DID",
+	Long: `Resolve prints a DID's document, its types, authoritative gateways, and previous DID, after verifying the
+gateway served a record signed by the DID's identity key. With --local, the DID is resolved from the DHT through an
+ephemeral node of our own, trusting no gateway.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resolution, err := resolveDID(args[0])
+		if err != nil {
+			return err
+		}
+		return printJSON(struct {
+			Document    didsdk.Document `json:"didDocument"`
+			Seq         int64           `json:"seq"`
+			Types       []int           `json:"types,omitempty"`
+			Gateways    []string        `json:"gateways,omitempty"`
+			PreviousDID string          `json:"previousDid,omitempty"`
+			Deactivated bool            `json:"deactivated,omitempty"`
+			Gateway     string          `json:"resolvedBy,omitempty"`
+		}{
+			Document:    resolution.Document,
+			Seq:         resolution.Record.SequenceNumber,
+			Types:       resolution.Types,
+			Gateways:    resolution.Gateways,
+			PreviousDID: resolution.PreviousDID,
+			Deactivated: resolution.Deactivated,
+			Gateway:     resolution.Gateway,
+		})
+	},
+}
+
+var publishCmd = &cobra.Command{
+	Use:   "publish <did>",
+	Short: "Publish a did:dht DID's saved document",
+	Long: `Publish signs the document saved in a DID's key file as a new version and publishes it through a gateway, such as
+after creating the DID with --publish=false, or to publish it again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := readKeyFile(args[0])
+		if err != nil {
+			logrus.WithError(err).Error("failed to read key file")
+			return err
+		}
+		put, err := file.sign()
+		if err != nil {
+			return err
+		}
+		if _, err = file.write(); err != nil {
+			return err
+		}
+		return publish(file.Key.DID(), put)
+	},
+}
+
+var updateCmd = &cobra.Command{
+	Use:   "update <did>",
+	Short: "Update a did:dht DID's document",
+	Long: `Update replaces the properties of a DID's saved document given by flags, leaving the others as they are, and
+publishes the document as a new version. Pass a flag with an empty value, such as --service "", to remove a property.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := readKeyFile(args[0])
+		if err != nil {
+			logrus.WithError(err).Error("failed to read key file")
+			return err
+		}
+		if err = file.apply(cmd); err != nil {
+			return err
+		}
+		put, err := file.sign()
+		if err != nil {
+			return err
+		}
+		if _, err = file.write(); err != nil {
+			return err
+		}
+		return publish(file.Key.DID(), put)
+	},
+}
+
+var deactivateCmd = &cobra.Command{
+	Use:   "deactivate <did>",
+	Short: "Deactivate a did:dht DID",
+	Long:  `Deactivate publishes the record deactivating a DID. A deactivated DID can't be published again.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := readKeyFile(args[0])
+		if err != nil {
+			logrus.WithError(err).Error("failed to read key file")
+			return err
+		}
+		put, err := file.Key.Deactivate()
+		if err != nil {
+			logrus.WithError(err).Error("failed to sign deactivation")
+			return err
+		}
+		if _, err = file.write(); err != nil {
+			return err
+		}
+		return publish(file.Key.DID(), put)
+	},
+}
+
+var inspectPacketCmd = &cobra.Command{
+	Use:   "inspect-packet [did]",
+	Short: "Print the DNS records of a did:dht DID's packet",
+	Long: `Inspect-packet prints the DNS records of the packet publishing a DID, as resolved through a gateway or, with
+--local, from the DHT, with its size against the 1000 byte limit of a record. With --file, the packet in the file is
+inspected instead.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var packet []byte
+		switch {
+		case inspectFile != "":
+			in := io.Reader(os.Stdin)
+			if inspectFile != "-" {
+				f, err := os.Open(inspectFile)
+				if err != nil {
+					logrus.WithError(err).Error("failed to open packet")
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+			var err error
+			if packet, err = io.ReadAll(in); err != nil {
+				logrus.WithError(err).Error("failed to read packet")
+				return err
+			}
+		case len(args) == 1:
+			resolution, err := resolveDID(args[0])
+			if err != nil {
+				return err
+			}
+			packet = resolution.Record.Value
+			resolvedBy := resolution.Gateway
+			if resolvedBy == "" {
+				resolvedBy = "the dht"
+			}
+			fmt.Printf(";; seq %d, resolved by %s\n", resolution.Record.SequenceNumber, resolvedBy)
+		default:
+			return fmt.Errorf("a did or --file is required")
+		}
+
+		msg := new(dns.Msg)
+		if err := msg.Unpack(packet); err != nil {
+			logrus.WithError(err).Error("failed to unpack dns packet")
+			return err
+		}
+		size, err := dht.EstimateSize(*msg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf(";; %d bytes of the 1000 a record holds, %d records\n", size, len(msg.Answer))
+		for _, rr := range msg.Answer {
+			fmt.Println(rr.String())
+		}
+		return nil
+	},
+}
+
+// keyFile is a DID's identity key and its document's properties, saved so the DID can be updated
+type keyFile struct {
+	Key         *keymgmt.Key     `json:"key"`
+	Controller  []string         `json:"controller,omitempty"`
+	AlsoKnownAs []string         `json:"alsoKnownAs,omitempty"`
+	Services    []didsdk.Service `json:"services,omitempty"`
+	Types       []int            `json:"types,omitempty"`
+	Gateways    []string         `json:"gateways,omitempty"`
+	// Supersedes is the DID this one replaces, whose key file signs over this DID
+	Supersedes string `json:"supersedes,omitempty"`
+}
+
+// apply sets the document's properties given by the command's flags
+func (f *keyFile) apply(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	if flags.Changed("controller") {
+		f.Controller = nonEmpty(docControllers)
+	}
+	if flags.Changed("also-known-as") {
+		f.AlsoKnownAs = nonEmpty(docAlsoKnownAs)
+	}
+	if flags.Changed("service") {
+		f.Services = nil
+		for _, service := range nonEmpty(docServices) {
+			parts := strings.SplitN(service, ",", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("service %q must be id,type,endpoint", service)
+			}
+			f.Services = append(f.Services, didsdk.Service{ID: parts[0], Type: parts[1], ServiceEndpoint: parts[2]})
+		}
+	}
+	if flags.Changed("type") {
+		f.Types = docTypes
+	}
+	if flags.Changed("authoritative-gateway") {
+		f.Gateways = nonEmpty(docAuthoritativeGateway)
+	}
+	return nil
+}
+
+// sign signs the saved document as the DID's next version
+func (f *keyFile) sign() (*bep44.Put, error) {
+	opts := keymgmt.Options{
+		Controller:  f.Controller,
+		AlsoKnownAs: f.AlsoKnownAs,
+		Services:    f.Services,
+		Types:       f.Types,
+		Gateways:    f.Gateways,
+	}
+	if f.Supersedes != "" {
+		previous, err := readKeyFile(f.Supersedes)
+		if err != nil {
+			logrus.WithError(err).Error("failed to read key file of the superseded did")
+			return nil, err
+		}
+		opts.Supersedes = previous.Key
+	}
+	put, err := f.Key.Publish(opts)
+	if err != nil {
+		logrus.WithError(err).Error("failed to sign did document")
+		return nil, err
+	}
+	return put, nil
+}
+
+// write saves the key file, readable only by the user
+func (f *keyFile) write() (string, error) {
+	path, err := keyFilePath(f.Key.DID())
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logrus.WithError(err).Error("failed to create key directory")
+		return "", err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		logrus.WithError(err).Error("failed to write key file")
+		return "", err
+	}
+	return path, nil
+}
+
+// readKeyFile reads the key file of a DID
+func readKeyFile(id string) (*keyFile, error) {
+	path, err := keyFilePath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	if f.Key == nil || f.Key.DID() != id {
+		return nil, fmt.Errorf("key file %s isn't the key of %s", path, id)
+	}
+	return &f, nil
+}
+
+// keyFilePath returns the path of a DID's key file, named by the DID's suffix in the key directory
+func keyFilePath(id string) (string, error) {
+	if !did.DHT(id).IsValid() {
+		return "", fmt.Errorf("invalid did: %s", id)
+	}
+	suffix, _ := did.DHT(id).Suffix()
+	dir := keyDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".diddht", "keys")
+	}
+	return filepath.Join(dir, suffix+".json"), nil
+}
+
+// resolveDID resolves a DID through the gateways, or from the DHT through a node of our own with --local
+func resolveDID(id string) (*client.Resolution, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var resolution *client.Resolution
+	var err error
+	if resolveLocal {
+		var r *client.LocalResolver
+		if r, err = client.StartLocalResolver(client.LocalConfig{BootstrapPeers: bootstrapPeers}); err != nil {
+			logrus.WithError(err).Error("failed to start dht node")
+			return nil, err
+		}
+		defer r.Close()
+		resolution, err = r.Resolve(ctx, id)
+	} else {
+		var c *client.Client
+		if c, err = client.New(gateways, client.Config{}); err != nil {
+			return nil, err
+		}
+		resolution, err = c.Resolve(ctx, id)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("failed to resolve did")
+		return nil, err
+	}
+	return resolution, nil
+}
+
+// publish publishes a DID's signed record through the first gateway to accept it
+func publish(id string, put *bep44.Put) error {
+	c, err := client.New(gateways, client.Config{})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err = c.Publish(ctx, *put); err != nil {
+		logrus.WithError(err).Error("failed to publish did")
+		return err
+	}
+	fmt.Printf("Published %s at seq %d\n", id, put.Seq)
+	return nil
+}
+
+// nonEmpty drops the empty values of a flag, so passing an empty value clears a property
+func nonEmpty(values []string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != "" {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func printJSON(v any) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
@@ -68,7 +68,7 @@ var identityAddCmd = &cobra.Command{
 		}
 
 		// start dht
-		d, err := dht.NewDHT(config.GetDefaultBootstrapPeers())
+		d, err := dht.NewDHT(config.GetDefaultConfig().DHTConfig, config.ProxyConfig{})
 		if err != nil {
 			logrus.WithError(err).Error("failed to create dht")
 			return err
@@ -157,7 +157,7 @@ var identityGetCmd = &cobra.Command{
 		// fall back to dht if not found in diddht file
 
 		// start dht
-		d, err := dht.NewDHT(config.GetDefaultBootstrapPeers())
+		d, err := dht.NewDHT(config.GetDefaultConfig().DHTConfig, config.ProxyConfig{})
 		if err != nil {
 			logrus.WithError(err).Error("failed to create dht")
 			return err
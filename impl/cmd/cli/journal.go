@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/TBD54566975/did-dht/pkg/journal"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+var (
+	journalTo    string
+	journalUntil string
+	journalID    string
+)
+
+func init() {
+	rootCmd.AddCommand(journalCmd)
+	journalCmd.AddCommand(journalReplayCmd)
+	journalCmd.AddCommand(journalLogCmd)
+
+	journalReplayCmd.Flags().StringVar(&journalTo, "to", "", "storage uri to replay into, e.g. bolt://diddht.db")
+	journalReplayCmd.Flags().StringVar(&journalUntil, "until", "", "RFC 3339 time to replay up to (default is the whole journal)")
+	_ = journalReplayCmd.MarkFlagRequired("to")
+
+	journalLogCmd.Flags().StringVar(&journalID, "id", "", "only show entries for this record id")
+}
+
+var journalCmd = &cobra.Command{
+	Use:   "journal",
+	Short: "Inspect and replay a gateway's journal of accepted records",
+}
+
+var journalReplayCmd = &cobra.Command{
+	Use:   "replay <journal>",
+	Short: "Rebuild a gateway's storage from its journal",
+	Long: `Replay writes every record in the journal, in the order the gateway accepted them, into a gateway's storage.
+With --until, only the records accepted up to that time are replayed, restoring the storage as it was then.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var until time.Time
+		if journalUntil != "" {
+			var err error
+			if until, err = time.Parse(time.RFC3339, journalUntil); err != nil {
+				logrus.WithError(err).Error("invalid --until time")
+				return err
+			}
+		}
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			logrus.WithError(err).Error("failed to open journal")
+			return err
+		}
+		defer file.Close()
+		db, err := storage.NewStorage(journalTo)
+		if err != nil {
+			logrus.WithError(err).Error("failed to open storage")
+			return err
+		}
+		defer db.Close()
+
+		count, err := journal.Replay(context.Background(), file, db, until)
+		if err != nil {
+			logrus.WithError(err).WithField("replayed", count).Error("failed to replay journal")
+			return err
+		}
+		fmt.Printf("Replayed %d records\n", count)
+		return nil
+	},
+}
+
+var journalLogCmd = &cobra.Command{
+	Use:   "log <journal>",
+	Short: "Show the records a gateway accepted",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := os.Open(args[0])
+		if err != nil {
+			logrus.WithError(err).Error("failed to open journal")
+			return err
+		}
+		defer file.Close()
+
+		return journal.Read(file, func(entry journal.Entry) error {
+			if journalID == "" || entry.ID == journalID {
+				fmt.Printf("%s %s seq=%d\n", entry.Time.Format(time.RFC3339Nano), entry.ID, entry.Seq)
+			}
+			return nil
+		})
+	},
+}
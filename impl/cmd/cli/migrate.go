@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+var (
+	migrateFrom       string
+	migrateTo         string
+	migrateCheckpoint string
+)
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "storage uri to migrate from, e.g. bolt://diddht.db")
+	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "storage uri to migrate to, e.g. postgres://...")
+	migrateCmd.Flags().StringVar(&migrateCheckpoint, "checkpoint", "diddht-migrate.json", "file recording progress, so a stopped migration resumes where it left off")
+	_ = migrateCmd.MarkFlagRequired("from")
+	_ = migrateCmd.MarkFlagRequired("to")
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Copy a gateway's records between storage backends",
+	Long: `Migrate copies every record, with its history, and every failed record from one storage backend to another.
+Progress is saved to the checkpoint file after each page of records; running the same command again resumes from it.
+The checkpoint file is removed once the migration completes.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var checkpoint storage.MigrateCheckpoint
+		if saved, err := os.ReadFile(migrateCheckpoint); err == nil {
+			if err = json.Unmarshal(saved, &checkpoint); err != nil {
+				logrus.WithError(err).Error("failed to read checkpoint")
+				return err
+			}
+			fmt.Printf("Resuming migration from %s\n", migrateCheckpoint)
+		} else if !os.IsNotExist(err) {
+			logrus.WithError(err).Error("failed to read checkpoint")
+			return err
+		}
+
+		src, err := storage.NewStorage(migrateFrom)
+		if err != nil {
+			logrus.WithError(err).Error("failed to open source storage")
+			return err
+		}
+		defer src.Close()
+		dst, err := storage.NewStorage(migrateTo)
+		if err != nil {
+			logrus.WithError(err).Error("failed to open destination storage")
+			return err
+		}
+		defer dst.Close()
+
+		var saveErr error
+		err = storage.Migrate(context.Background(), src, dst, checkpoint, func(p storage.MigrateProgress) {
+			fmt.Printf("Copied %d of %d records\n", p.Records, p.Total)
+			saved, err := json.Marshal(p.MigrateCheckpoint)
+			if err == nil {
+				err = os.WriteFile(migrateCheckpoint, saved, 0o600)
+			}
+			if err != nil && saveErr == nil {
+				saveErr = err
+				logrus.WithError(err).Warn("failed to save checkpoint, the migration can't be resumed")
+			}
+		})
+		if err != nil {
+			logrus.WithError(err).Error("failed to migrate storage")
+			return err
+		}
+		if err = os.Remove(migrateCheckpoint); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).Warn("failed to remove checkpoint")
+		}
+		fmt.Println("Migration complete")
+		return nil
+	},
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/TBD54566975/did-dht/pkg/pkarr"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+var (
+	exportFrom string
+	exportOut  string
+	importTo   string
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+
+	exportCmd.Flags().StringVar(&exportFrom, "from", "", "storage uri to export from, e.g. bolt://diddht.db")
+	exportCmd.Flags().StringVar(&exportOut, "out", "", "file to write the dump to (default is stdout)")
+	_ = exportCmd.MarkFlagRequired("from")
+
+	importCmd.Flags().StringVar(&importTo, "to", "", "storage uri to import into, e.g. bolt://diddht.db")
+	_ = importCmd.MarkFlagRequired("to")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Dump a gateway's records in the Pkarr relay format",
+	Long: `Export writes every record in a gateway's storage as a Pkarr relay dump, in the format documented in pkg/pkarr.
+Salted records can't be represented in Pkarr and are skipped.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := storage.NewStorage(exportFrom)
+		if err != nil {
+			logrus.WithError(err).Error("failed to open storage")
+			return err
+		}
+		defer db.Close()
+
+		out := io.Writer(os.Stdout)
+		if exportOut != "" {
+			file, err := os.Create(exportOut)
+			if err != nil {
+				logrus.WithError(err).Error("failed to create dump")
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+
+		exported, skipped, err := pkarr.Export(context.Background(), db, out)
+		if err != nil {
+			logrus.WithError(err).WithField("exported", exported).Error("failed to export records")
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d records, skipped %d salted records\n", exported, skipped)
+		return nil
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <dump>",
+	Short: "Load records from a Pkarr relay dump into a gateway's storage",
+	Long: `Import writes every record in a Pkarr relay dump, such as one written by export, into a gateway's storage.
+Each record's signature is verified, and stored records newer than those in the dump are kept. Pass - to read the
+dump from stdin.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in := io.Reader(os.Stdin)
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				logrus.WithError(err).Error("failed to open dump")
+				return err
+			}
+			defer file.Close()
+			in = file
+		}
+		db, err := storage.NewStorage(importTo)
+		if err != nil {
+			logrus.WithError(err).Error("failed to open storage")
+			return err
+		}
+		defer db.Close()
+
+		count, err := pkarr.Import(context.Background(), in, db)
+		if err != nil {
+			logrus.WithError(err).WithField("imported", count).Error("failed to import records")
+			return err
+		}
+		fmt.Printf("Imported %d records\n", count)
+		return nil
+	},
+}
@@ -14,10 +14,19 @@ import (
 	"github.com/TBD54566975/did-dht/config"
 	int "github.com/TBD54566975/did-dht/internal/util"
 	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/proxy"
 	"github.com/TBD54566975/did-dht/pkg/server"
 	"github.com/TBD54566975/did-dht/pkg/telemetry"
 )
 
+// defaultShutdownTimeout is how long shutting down waits for requests and background work in flight, unless
+// configured otherwise
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	// relayTimeout bounds each request to a Pkarr relay in the proxy's relay mode
+	relayTimeout = 30 * time.Second
+)
+
 // main godoc
 //
 //	@title			The DID DHT Service
@@ -27,9 +36,26 @@ import (
 //	@contact.email	tbd-developer@squareup.com
 //	@license.name	Apache 2.0
 //	@license.url	http://www.apache.org/licenses/LICENSE-2.0.html
+//
+//	@securityDefinitions.apikey	AdminToken
+//	@in							header
+//	@name						Authorization
+//	@description				Admin token configured by the ADMIN_TOKEN environment variable, sent as "Bearer <token>"
+//
+//	@securityDefinitions.apikey	PeeringSecret
+//	@in							header
+//	@name						Authorization
+//	@description				Peering secret configured by the PEERING_SECRET environment variable, sent as "Bearer <secret>"
+//
+//	@securityDefinitions.apikey	OwnerSignature
+//	@in							header
+//	@name						Signature
+//	@description				HTTP Message Signature by the DID's identity key, labelled diddht, over the request's @method and @path
 func main() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetReportCaller(true)
+	// tag entries logged while serving a request with its ID
+	logrus.AddHook(&int.RequestIDHook{})
 	logrus.WithField("version", config.Version).Info("starting up")
 
 	if err := run(); err != nil {
@@ -54,14 +80,14 @@ func run() error {
 	}
 
 	// set up telemetry
-	if cfg.ServerConfig.Telemetry {
+	if cfg.TracesExporter() != config.ExporterNone {
 		// add trace hook to logrus
 		logrus.AddHook(&int.TraceHook{})
-		if err = telemetry.SetupTelemetry(ctx); err != nil {
-			logrus.WithContext(ctx).WithError(err).Fatal("error initializing telemetry")
-		}
-		defer telemetry.Shutdown(ctx)
 	}
+	if err = telemetry.SetupTelemetry(ctx, *cfg); err != nil {
+		logrus.WithContext(ctx).WithError(err).Fatal("error initializing telemetry")
+	}
+	defer telemetry.Shutdown(ctx)
 
 	// set up logger
 	configureLogger(cfg.Log.Level)
@@ -71,40 +97,87 @@ func run() error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	d, err := dht.NewDHT(cfg.DHTConfig.BootstrapPeers)
+	d, err := newDHTClient(cfg)
 	if err != nil {
 		return util.LoggingCtxErrorMsg(ctx, err, "failed to instantiate dht")
 	}
 
+	// the server closes the dht, saving its routing state, when it shuts down
 	s, err := server.NewServer(cfg, shutdown, d)
 	if err != nil {
+		d.Close()
 		return util.LoggingCtxErrorMsg(ctx, err, "could not start http services")
 	}
 
 	serverErrors := make(chan error, 1)
 	go func() {
 		logrus.WithContext(ctx).WithField("listen_address", s.Addr).Info("starting listener")
+		if cfg.ServerConfig.GRPCPort != 0 {
+			logrus.WithContext(ctx).WithField("listen_address", s.GRPCAddr).Info("starting grpc listener")
+		}
 		serverErrors <- s.ListenAndServe()
 	}()
 
-	select {
-	case err = <-serverErrors:
-		return errors.Wrap(err, "server error")
-	case sig := <-shutdown:
-		logrus.WithContext(ctx).WithField("signal", sig.String()).Info("shutdown signal received")
-
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	// reload the bootstrap peers on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	for {
+		select {
+		case err = <-serverErrors:
+			_ = s.Close()
+			return errors.Wrap(err, "server error")
+		case <-reload:
+			node, ok := d.(*dht.DHT)
+			if !ok {
+				logrus.WithContext(ctx).Info("not reloading bootstrap peers, since no dht node is running")
+				continue
+			}
+			logrus.WithContext(ctx).Info("reloading bootstrap peers")
+			if err = node.RefreshBootstrapPeers(ctx); err != nil {
+				logrus.WithContext(ctx).WithError(err).Error("failed to reload bootstrap peers")
+			}
+		case sig := <-shutdown:
+			logrus.WithContext(ctx).WithField("signal", sig.String()).Info("shutdown signal received")
 
-		if err = s.Shutdown(ctx); err != nil {
-			if err = s.Close(); err != nil {
-				return err
+			timeout := time.Duration(cfg.ServerConfig.ShutdownTimeoutSeconds) * time.Second
+			if timeout == 0 {
+				timeout = defaultShutdownTimeout
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if err = s.Shutdown(ctx); err != nil {
+				if closeErr := s.Close(); closeErr != nil {
+					return closeErr
+				}
+				return errors.Wrap(err, "main: failed to stop server gracefully")
 			}
-			return errors.Wrap(err, "main: failed to stop server gracefully")
+			logrus.WithContext(ctx).Info("shut down gracefully")
+			return nil
 		}
 	}
+}
 
-	return nil
+// newDHTClient starts the gateway's DHT node, or returns a client of Pkarr relays in the proxy's relay mode
+func newDHTClient(cfg *config.Config) (dht.Client, error) {
+	mode, err := proxy.DHTMode(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if mode == config.ProxyDHTRelay {
+		client, err := proxy.HTTPClient(cfg.Proxy, relayTimeout)
+		if err != nil {
+			return nil, err
+		}
+		logrus.WithField("relays", cfg.Proxy.Relays).Info("resolving and publishing through pkarr relays")
+		return dht.NewRelay(cfg.Proxy.Relays, client), nil
+	}
+	d, err := dht.NewDHT(cfg.DHTConfig, cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
 // configureLogger configures the logger
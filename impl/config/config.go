@@ -29,6 +29,24 @@ const (
 	BootstrapPeers EnvironmentVariable = "BOOTSTRAP_PEERS"
 	StorageURI     EnvironmentVariable = "STORAGE_URI"
 	LogLevel       EnvironmentVariable = "LOG_LEVEL"
+	// StorageEncryptionKey A base64 encoded 16, 24, or 32 byte AES key encrypting bolt storage at rest. Kept out of
+	// the config file so it can be injected from a secrets manager or KMS.
+	StorageEncryptionKey EnvironmentVariable = "STORAGE_ENCRYPTION_KEY"
+	// AdminToken The bearer token authenticating calls to the admin endpoints. Kept out of the config file, like the
+	// storage encryption key.
+	AdminToken EnvironmentVariable = "ADMIN_TOKEN"
+	// RetentionSecret The secret retention challenges are derived from. Gateway replicas sharing it issue the same
+	// challenges; without it, each gateway derives its challenges from a random secret.
+	RetentionSecret EnvironmentVariable = "RETENTION_SECRET"
+	// ResponseSigningKey A base64 encoded 32 byte ed25519 seed the gateway signs its resolution responses with. Without
+	// it, responses aren't signed.
+	ResponseSigningKey EnvironmentVariable = "RESPONSE_SIGNING_KEY"
+	// GatewayIdentityKey A base64 encoded 32 byte ed25519 seed, the identity key of the DID the gateway announces
+	// itself under. Required to announce the gateway.
+	GatewayIdentityKey EnvironmentVariable = "GATEWAY_IDENTITY_KEY"
+	// PeeringSecret The bearer token peer gateways authenticate with, shared by every gateway that peers with another.
+	// Required to peer.
+	PeeringSecret EnvironmentVariable = "PEERING_SECRET"
 )
 
 type (
@@ -41,9 +59,25 @@ func (e EnvironmentVariable) String() string {
 }
 
 type Config struct {
-	Log          LogConfig        `toml:"log"`
-	ServerConfig ServerConfig     `toml:"server"`
-	DHTConfig    DHTServiceConfig `toml:"dht"`
+	Log           LogConfig        `toml:"log"`
+	ServerConfig  ServerConfig     `toml:"server"`
+	DHTConfig     DHTServiceConfig `toml:"dht"`
+	PeeringConfig PeeringConfig    `toml:"peering"`
+	ArchiveConfig ArchiveConfig    `toml:"archive"`
+	JournalConfig JournalConfig    `toml:"journal"`
+	GCConfig      GCConfig         `toml:"gc"`
+	RateLimit     RateLimitConfig  `toml:"rate_limit"`
+	Retention     RetentionConfig  `toml:"retention"`
+	CORS          CORSConfig       `toml:"cors"`
+	Readiness     ReadinessConfig  `toml:"readiness"`
+	Registry      RegistryConfig   `toml:"registry"`
+	Telemetry     TelemetryConfig  `toml:"telemetry"`
+	Proxy         ProxyConfig      `toml:"proxy"`
+	Abuse         AbuseConfig      `toml:"abuse"`
+	Access        AccessConfig     `toml:"access"`
+
+	// Path is the file the config was loaded from, which it is reloaded from; empty for the default config
+	Path string `toml:"-"`
 }
 
 type ServerConfig struct {
@@ -53,13 +87,387 @@ type ServerConfig struct {
 	BaseURL     string      `toml:"base_url"`
 	StorageURI  string      `toml:"storage_uri"`
 	Telemetry   bool        `toml:"telemetry"`
+	// DebugEndpoints exposes gateway-internal endpoints such as /debug/dht; keep them off public listeners
+	DebugEndpoints bool `toml:"debug_endpoints"`
+	// MetricsEndpoint serves the gateway's metrics at /metrics for Prometheus to scrape; keep it off public listeners
+	MetricsEndpoint bool `toml:"metrics_endpoint"`
+	// AdminEndpoints exposes the operator endpoints under /admin, which are called with the ADMIN_TOKEN bearer token
+	// or a client certificate issued by one of the AdminClientCAFile CAs; keep them off public listeners
+	AdminEndpoints bool `toml:"admin_endpoints"`
+	// TLSCertFile and TLSKeyFile are the PEM encoded certificate and key to serve the API over TLS with; empty serves
+	// plain HTTP
+	TLSCertFile string `toml:"tls_cert_file"`
+	TLSKeyFile  string `toml:"tls_key_file"`
+	// ACMEDomains are the domains to obtain the API's certificate for from an ACME CA such as Let's Encrypt, instead of
+	// from TLSCertFile. The CA validates the domains over TLS-ALPN-01, so the API must be reachable on port 443.
+	ACMEDomains []string `toml:"acme_domains"`
+	// ACMEEmail is the contact address the CA sends notices about the certificate to; optional
+	ACMEEmail string `toml:"acme_email"`
+	// ACMECacheDir is the directory the certificates and ACME account key are kept in across restarts; required with
+	// ACMEDomains, since CAs rate limit issuing certificates
+	ACMECacheDir string `toml:"acme_cache_dir"`
+	// ACMEDirectoryURL is the directory URL of the ACME CA; Let's Encrypt if empty
+	ACMEDirectoryURL string `toml:"acme_directory_url"`
+	// AdminClientCAFile holds the PEM encoded CAs whose client certificates may call the admin endpoints; requires TLS
+	AdminClientCAFile string `toml:"admin_client_ca_file"`
+	// AdminRequireClientCert only admits admin requests with a client certificate issued by one of the
+	// AdminClientCAFile CAs, refusing the ADMIN_TOKEN
+	AdminRequireClientCert bool `toml:"admin_require_client_cert"`
+	// TrustedProxies are the addresses and CIDRs of the reverse proxies and load balancers whose ClientIPHeaders give
+	// the client IP; any other client's IP is its connection's address
+	TrustedProxies []string `toml:"trusted_proxies"`
+	// ClientIPHeaders are the headers trusted proxies give the client IP in, checked in order; X-Forwarded-For and
+	// X-Real-IP if empty
+	ClientIPHeaders []string `toml:"client_ip_headers"`
+	// GRPCPort is the port the gRPC API is served on, on the API host, with the same TLS as the HTTP API; zero
+	// disables the gRPC API
+	GRPCPort int `toml:"grpc_port"`
+	// ShutdownTimeoutSeconds is how long shutting down waits for requests and background work in flight, such as the
+	// republish batch in progress, before abandoning them; 30 seconds if zero
+	ShutdownTimeoutSeconds int `toml:"shutdown_timeout_seconds"`
+	// MaxPutBodyBytes is the largest request body a record is published with over HTTP, 1072 bytes if zero: a 64
+	// byte signature, 8 byte sequence number, and a value of up to BEP44's 1000 bytes
+	MaxPutBodyBytes int64 `toml:"max_put_body_bytes"`
+	// PkarrRelay serves GET and PUT /{id} as a Pkarr relay does, so Pkarr clients can use the gateway as their relay:
+	// with Last-Modified and If-Modified-Since, puts answered with 204, and Pkarr's status codes for conflicting puts.
+	// Pkarr clients can't solve retention challenges, so it can't be enabled along with them.
+	PkarrRelay bool `toml:"pkarr_relay"`
 }
 
 type DHTServiceConfig struct {
-	BootstrapPeers   []string `toml:"bootstrap_peers"`
-	RepublishCRON    string   `toml:"republish_cron"`
-	CacheTTLSeconds  int      `toml:"cache_ttl_seconds"`
-	CacheSizeLimitMB int      `toml:"cache_size_limit_mb"`
+	// ListenAddrs are the UDP addresses to run a DHT server on; gets are raced across every server
+	ListenAddrs []string `toml:"listen_addrs"`
+	// AddressFamily is the IP address family the DHT servers listen and traverse over: dual, ipv4, or ipv6; dual if
+	// empty. Dual servers listening on a wildcard address reach nodes of both families.
+	AddressFamily  string   `toml:"address_family"`
+	BootstrapPeers []string `toml:"bootstrap_peers"`
+	// BootstrapPeersFile is a file of additional bootstrap peers, one host:port per line, reloaded on refresh
+	BootstrapPeersFile string `toml:"bootstrap_peers_file"`
+	// BootstrapPeersURL serves additional bootstrap peers in the same format as BootstrapPeersFile
+	BootstrapPeersURL string `toml:"bootstrap_peers_url"`
+	// BootstrapRefreshSeconds is how often the bootstrap peers file and URL are reloaded; zero disables reloading
+	BootstrapRefreshSeconds int `toml:"bootstrap_refresh_seconds"`
+	// MinRoutingTableNodes is the routing table size below which the DHT re-bootstraps; zero disables re-bootstrapping
+	MinRoutingTableNodes int `toml:"min_routing_table_nodes"`
+	// PortMapping maps the listen ports on the local gateway with NAT-PMP or UPnP, for nodes running behind NAT
+	PortMapping bool `toml:"port_mapping"`
+	// StateDir is where each server's node ID and routing table are saved on shutdown and restored on start, so the
+	// node rejoins the DHT warm after a restart; empty disables persistence
+	StateDir string `toml:"state_dir"`
+	// PublicIP is the node's public address, used for its secure node ID; detected from the gateway when unset
+	PublicIP string `toml:"public_ip"`
+
+	// RepublishIntervalSeconds is how long after it was last published or republished each stored record is
+	// republished to the DHT, 3 hours if zero. Records are republished as they come due, spreading the work across the
+	// interval.
+	RepublishIntervalSeconds int `toml:"republish_interval_seconds"`
+	// RepublishWorkers is the number of republish puts in flight at once, 64 if zero
+	RepublishWorkers int `toml:"republish_workers"`
+	// RepublishPutsPerSecond is the most republish puts started each second, bounding the load republishing puts on
+	// the DHT and the gateway's uplink; zero is no limit
+	RepublishPutsPerSecond int `toml:"republish_puts_per_second"`
+	// RepublishMaxRecordsPerCycle is the most records republished in one cycle of the republisher, which runs every
+	// 10 seconds; zero is no cap beyond spreading the records across the interval
+	RepublishMaxRecordsPerCycle int `toml:"republish_max_records_per_cycle"`
+	// CacheTTLSeconds is how long a resolved record is served from the cache before it is refreshed from the DHT, 10
+	// minutes if zero
+	CacheTTLSeconds int `toml:"cache_ttl_seconds"`
+	// CacheStaleSeconds is how long past its TTL a record is still served from the cache, while it is refreshed from
+	// the DHT in the background; zero refreshes records before serving them once their TTL passes
+	CacheStaleSeconds int `toml:"cache_stale_seconds"`
+	// CacheSizeLimitMB is how much the in-memory cache holds before evicting the least recently resolved records, 1000
+	// MB if zero
+	CacheSizeLimitMB int `toml:"cache_size_limit_mb"`
+	// CacheColdPath is the bolt file the records evicted from the in-memory cache spill over to, so they're served
+	// from disk rather than looked up again until they expire; empty drops evicted records. Not supported with
+	// CacheRedisURI.
+	CacheColdPath string `toml:"cache_cold_path"`
+	// CacheRedisURI is the redis:// or rediss:// URI of the database that gateway replicas share their cache through;
+	// empty keeps the cache in memory
+	CacheRedisURI string `toml:"cache_redis_uri"`
+	// CacheRefreshTopN is how many of the most resolved records are refreshed from the DHT in the background before
+	// their cached copies go stale, so they're always served from the cache; zero disables it
+	CacheRefreshTopN int `toml:"cache_refresh_top_n"`
+	// CacheRefreshIntervalSeconds is how often the most resolved records are refreshed, a tenth of the cache TTL if
+	// zero
+	CacheRefreshIntervalSeconds int `toml:"cache_refresh_interval_seconds"`
+	// ResolutionStrategy is the order records missing from the cache are looked up in: dht-first, storage-first,
+	// race, or cache-only; dht-first if empty. Requests can choose another with the Resolution-Strategy header.
+	ResolutionStrategy string `toml:"resolution_strategy"`
+	// NotFoundCacheSeconds is how long a record found neither on the DHT nor in storage is reported not found without
+	// looking it up again, sparing the DHT repeated traversals for IDs that don't exist; zero disables it
+	NotFoundCacheSeconds int `toml:"not_found_cache_seconds"`
+	// RepublishOnReadSeconds is how long a record can sit in the cache before resolving it also republishes it to
+	// the DHT, keeping popular records alive while their publisher is offline; zero disables it
+	RepublishOnReadSeconds int             `toml:"republish_on_read_seconds"`
+	Traversal              TraversalConfig `toml:"traversal"`
+	// HealthProbeIntervalSeconds is how often a canary record is put to and read from the DHT; zero disables probing
+	HealthProbeIntervalSeconds int `toml:"health_probe_interval_seconds"`
+}
+
+// TraversalConfig tunes the get/put traversals made against the DHT; zero values fall back to library defaults
+type TraversalConfig struct {
+	Alpha               int `toml:"alpha"`
+	K                   int `toml:"k"`
+	QueryTimeoutSeconds int `toml:"query_timeout_seconds"`
+	// InitialQueryTimeoutMillis is how long a node first gets to answer; only the closest nodes are then waited on
+	// for the full query timeout. Zero gives every node the full query timeout.
+	InitialQueryTimeoutMillis int `toml:"initial_query_timeout_millis"`
+	StallTimeoutSeconds       int `toml:"stall_timeout_seconds"`
+	// PutQuorum is the minimum number of nodes that must acknowledge a put; zero disables the check
+	PutQuorum int `toml:"put_quorum"`
+	// LatestWindowMillis is how long a freshness-verified get keeps collecting responses after the first result
+	LatestWindowMillis int `toml:"latest_window_millis"`
+	// QueriesPerSecond limits outbound get and put queries, allowing bursts of up to QueryBurst; negative disables it
+	QueriesPerSecond float64        `toml:"queries_per_second"`
+	QueryBurst       int            `toml:"query_burst"`
+	GetRetry         GetRetryConfig `toml:"get_retry"`
+}
+
+// GetRetryConfig controls retrying gets that stall without finding a value, re-bootstrapping before each retry
+type GetRetryConfig struct {
+	// Attempts is the number of retries after the first get; zero disables retries
+	Attempts             int     `toml:"attempts"`
+	InitialBackoffMillis int     `toml:"initial_backoff_millis"`
+	MaxBackoffMillis     int     `toml:"max_backoff_millis"`
+	Jitter               float64 `toml:"jitter"`
+}
+
+// PeeringConfig configures the gateways that newly published records are sent to, so records propagate between
+// gateways independently of the DHT
+type PeeringConfig struct {
+	// Peers are the base URLs of peer gateways; empty disables sending records
+	Peers []string `toml:"peers"`
+	// BatchSize is the most records sent to a peer in one request
+	BatchSize int `toml:"batch_size"`
+	// FlushIntervalMillis is how long records wait to fill a batch before being sent
+	FlushIntervalMillis int `toml:"flush_interval_millis"`
+}
+
+// ArchiveConfig configures periodic snapshots of every stored record and its history to object storage
+type ArchiveConfig struct {
+	// URI is where snapshots are written: s3://bucket/prefix for S3-compatible storage or file:///path for a local
+	// directory; empty disables archiving
+	URI string `toml:"uri"`
+	// CRON is when snapshots are taken
+	CRON string `toml:"cron"`
+}
+
+// JournalConfig configures the append-only journal of every record the gateway accepts
+type JournalConfig struct {
+	// Path is the journal file; empty disables journaling
+	Path string `toml:"path"`
+	// Sync flushes each entry to disk before the record is accepted
+	Sync bool `toml:"sync"`
+}
+
+// GCConfig configures collecting stored records that are no longer published or resolved
+type GCConfig struct {
+	// RetentionHours is how long a record is kept after it was last published or resolved; zero disables collection
+	RetentionHours int `toml:"retention_hours"`
+	// CRON is when stale records are collected
+	CRON string `toml:"cron"`
+	// RetainedDIDs are never collected, along with every salted record under their keys
+	RetainedDIDs []string `toml:"retained_dids"`
+}
+
+// RateLimitConfig configures the token buckets metering publishing and resolving, per client IP and per DID.
+// Publishes and resolutions are metered in separate buckets.
+type RateLimitConfig struct {
+	// RedisURI is the redis:// or rediss:// URI of the database that gateway replicas share buckets through; empty
+	// keeps the buckets in memory
+	RedisURI string `toml:"redis_uri"`
+	// TrustedProxies are trusted along with the server's trusted proxies.
+	//
+	// Deprecated: set the server's TrustedProxies, which apply whether or not requests are rate limited.
+	TrustedProxies []string `toml:"trusted_proxies"`
+	// IP limits the requests of each client IP
+	IP RateLimit `toml:"ip"`
+	// DID limits the requests for each DID, across every client
+	DID RateLimit `toml:"did"`
+	// APIKeys meters the requests of clients presenting an API key against the key's own limits instead of their IP's
+	APIKeys APIKeysConfig `toml:"api_keys"`
+}
+
+// APIKeysConfig configures the API keys minted through the admin API, which clients present in the X-API-Key header
+// for tiered access to a hosted gateway
+type APIKeysConfig struct {
+	// Enabled accepts API keys, metering the requests made with each key by the key's limits and publish quota
+	Enabled bool `toml:"enabled"`
+	// Required rejects the requests made without an API key
+	Required bool `toml:"required"`
+}
+
+// RateLimit is the refill rate and size of a token bucket
+type RateLimit struct {
+	// RequestsPerSecond is how many requests the bucket refills with each second; zero disables the limit
+	RequestsPerSecond float64 `toml:"requests_per_second"`
+	// Burst is how many requests the bucket holds
+	Burst int `toml:"burst"`
+}
+
+// RetentionConfig configures the retention challenges that publishers must solve, doing proof of work, for the
+// gateway to accept their records
+type RetentionConfig struct {
+	// Difficulty is the number of leading zero bits a solution must have, at least 26; zero disables challenges
+	Difficulty int `toml:"difficulty"`
+	// ChallengeExpirySeconds is how often a new challenge is issued, every 10 minutes if zero; solutions against a
+	// challenge are accepted for one more window after the next is issued
+	ChallengeExpirySeconds int `toml:"challenge_expiry_seconds"`
+}
+
+// CORSConfig configures the CORS headers that let browser-based wallets call the gateway directly from web apps.
+// Empty lists allow any origin, the API's methods, and any header.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to call the gateway, such as https://wallet.example.com; an origin may
+	// have one wildcard, as in https://*.example.com, and "*" allows any origin
+	AllowedOrigins []string `toml:"allowed_origins"`
+	// AllowedMethods are the methods cross-origin requests may use
+	AllowedMethods []string `toml:"allowed_methods"`
+	// AllowedHeaders are the request headers cross-origin requests may set, or "*" for any
+	AllowedHeaders []string `toml:"allowed_headers"`
+	// MaxAgeSeconds is how long browsers may cache a preflight response; zero leaves it to the browser
+	MaxAgeSeconds int `toml:"max_age_seconds"`
+}
+
+// ReadinessConfig sets when the gateway reports it isn't ready for traffic, so orchestrators stop routing requests to
+// it. Storage that can't be read from always makes it unready.
+type ReadinessConfig struct {
+	// MinRoutingTableNodes is the fewest nodes the DHT's routing table holds while ready; 1 if zero
+	MinRoutingTableNodes int `toml:"min_routing_table_nodes"`
+	// MaxRepublishLagSeconds is how far behind schedule the republisher may fall while ready; 3 hours if zero
+	MaxRepublishLagSeconds int `toml:"max_republish_lag_seconds"`
+}
+
+// RegistryConfig configures gateway discovery: the DID the gateway announces itself under, and the other gateways it
+// lists at GET /gateways so clients can find alternatives to it
+type RegistryConfig struct {
+	// Announce publishes a DID for the gateway, keyed by the GATEWAY_IDENTITY_KEY, whose document names the base URL as
+	// a gateway service and designates the gateway authoritative for the DID
+	Announce bool `toml:"announce"`
+	// Gateways are the DIDs of known gateways, each listed with the endpoints of the gateway services its document names
+	Gateways []string `toml:"gateways"`
+}
+
+// the ways DHT traffic is sent through a proxy
+const (
+	// ProxyDHTUDP relays the DHT node's UDP traffic through the proxy with SOCKS5 UDP ASSOCIATE
+	ProxyDHTUDP = "udp"
+	// ProxyDHTRelay runs no DHT node, resolving and publishing records through Pkarr relays over HTTP through the
+	// proxy instead, for proxies such as Tor that carry TCP only
+	ProxyDHTRelay = "relay"
+)
+
+// ProxyConfig routes the gateway's outbound traffic through a SOCKS5 proxy, such as Tor, for deployments that mustn't
+// reveal their address to DHT nodes and peers. Traffic to the local gateway for port mapping and to the telemetry
+// collector isn't proxied.
+type ProxyConfig struct {
+	// URL is the socks5:// or socks5h:// URL of the proxy, with any username and password as its user info; empty
+	// sends traffic directly
+	URL string `toml:"url"`
+	// DHTMode is how DHT traffic is sent through the proxy: udp or relay; udp if empty
+	DHTMode string `toml:"dht_mode"`
+	// Relays are the base URLs of the Pkarr relays records are resolved from and published to in relay mode
+	Relays []string `toml:"relays"`
+}
+
+// AbuseConfig configures the abuse filter scoring the records published to the gateway, which protects public gateways
+// from spam. Each scorer scores a record from 0 to 1, and the record is acted on by the sum of its scores. Records from
+// peers and deactivations aren't scored.
+type AbuseConfig struct {
+	// Scorers are the names of the scorers records are scored by: prefix_rate, entropy, endpoints, or any registered
+	// with abuse.Register; empty disables the filter
+	Scorers []string `toml:"scorers"`
+	// FlagScore is the score at which a record is published but listed for review; zero never flags records
+	FlagScore float64 `toml:"flag_score"`
+	// ShadowScore is the score at which a record is held for review rather than published, though its publisher is
+	// told it was accepted; zero never holds records
+	ShadowScore float64 `toml:"shadow_score"`
+	// RejectScore is the score at which a record is rejected; zero never rejects records
+	RejectScore float64 `toml:"reject_score"`
+	// ReviewQueueSize is the most records listed for review, the oldest dropped to make room; 1000 if zero
+	ReviewQueueSize int `toml:"review_queue_size"`
+	// PrefixLength is how many characters of the z-base-32 key the prefix_rate scorer groups records by; 4 if zero
+	PrefixLength int `toml:"prefix_length"`
+	// PrefixPutsPerMinute is how many records with the same key prefix prefix_rate allows a minute before scoring them,
+	// scoring 1 at twice as many; 30 if zero
+	PrefixPutsPerMinute int `toml:"prefix_puts_per_minute"`
+	// EntropyBitsPerByte is the Shannon entropy of a record's TXT data the entropy scorer allows before scoring it,
+	// scoring 1 at 8 bits per byte; 6.5 if zero, above the 6 bits of base64 encoded keys
+	EntropyBitsPerByte float64 `toml:"entropy_bits_per_byte"`
+	// BlockedEndpoints are the hosts, along with their subdomains, whose service endpoints the endpoints scorer scores 1
+	BlockedEndpoints []string `toml:"blocked_endpoints"`
+}
+
+// AccessConfig configures the lists of DIDs and client IPs the gateway refuses, or in allowlist mode the only ones it
+// serves. Denied DIDs are neither published nor resolved, from any source; denied IPs get no API. The lists are
+// reloaded with the rest of the config.
+type AccessConfig struct {
+	// DeniedDIDs are the DIDs, or their z-base-32 encoded suffixes, the gateway doesn't publish or resolve
+	DeniedDIDs []string `toml:"denied_dids"`
+	// DeniedCIDRs are the addresses and CIDRs of the clients the gateway doesn't serve
+	DeniedCIDRs []string `toml:"denied_cidrs"`
+	// Allowlist serves only the allowed DIDs and clients, less any denied. An empty allowed list leaves its kind
+	// unrestricted, so DIDs can be allowlisted alone, but at least one must be set.
+	Allowlist bool `toml:"allowlist"`
+	// AllowedDIDs are the DIDs, or their z-base-32 encoded suffixes, the gateway publishes and resolves in allowlist mode
+	AllowedDIDs []string `toml:"allowed_dids"`
+	// AllowedCIDRs are the addresses and CIDRs of the clients the gateway serves in allowlist mode
+	AllowedCIDRs []string `toml:"allowed_cidrs"`
+}
+
+// the exporters traces and metrics can be sent with
+const (
+	ExporterNone     = "none"
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterOTLPHTTP = "otlp-http"
+	ExporterStdout   = "stdout"
+	// ExporterPrometheus serves metrics at /metrics for Prometheus to scrape rather than pushing them anywhere
+	ExporterPrometheus = "prometheus"
+)
+
+// TelemetryConfig sets where the gateway's traces and metrics are exported. Exports are bounded by a timeout and
+// failures are logged, so a collector that is down never holds up the gateway.
+type TelemetryConfig struct {
+	// Traces is the exporter traces are sent with: otlp-grpc, otlp-http, stdout, or none. Empty is otlp-http with
+	// server.telemetry set, and none otherwise.
+	Traces string `toml:"traces"`
+	// Metrics is the exporter metrics are sent with: otlp-grpc, otlp-http, stdout, prometheus, or none. Empty is
+	// otlp-http with server.telemetry set, and none otherwise. server.metrics_endpoint serves metrics for Prometheus
+	// alongside any other exporter.
+	Metrics string `toml:"metrics"`
+	// Endpoint is the host:port of the OTLP collector; empty uses OTEL_EXPORTER_OTLP_ENDPOINT, or localhost on the
+	// protocol's default port
+	Endpoint string `toml:"endpoint"`
+	// Insecure sends OTLP without TLS
+	Insecure bool `toml:"insecure"`
+	// ExportTimeoutSeconds bounds each export to the collector; 10 if zero
+	ExportTimeoutSeconds int `toml:"export_timeout_seconds"`
+}
+
+// TracesExporter returns the exporter traces are sent with, defaulting it from server.telemetry
+func (c Config) TracesExporter() string {
+	return c.exporter(c.Telemetry.Traces)
+}
+
+// MetricsExporter returns the exporter metrics are sent with, defaulting it from server.telemetry
+func (c Config) MetricsExporter() string {
+	return c.exporter(c.Telemetry.Metrics)
+}
+
+func (c Config) exporter(name string) string {
+	if name != "" {
+		return name
+	}
+	if c.ServerConfig.Telemetry {
+		return ExporterOTLPHTTP
+	}
+	return ExporterNone
+}
+
+// ServesMetrics reports whether the gateway serves its metrics at /metrics for Prometheus to scrape
+func (c Config) ServesMetrics() bool {
+	return c.ServerConfig.MetricsEndpoint || c.MetricsExporter() == ExporterPrometheus
 }
 
 type LogConfig struct {
@@ -77,10 +485,64 @@ func GetDefaultConfig() Config {
 			Telemetry:   false,
 		},
 		DHTConfig: DHTServiceConfig{
-			BootstrapPeers:   GetDefaultBootstrapPeers(),
-			RepublishCRON:    "0 */3 * * *",
-			CacheTTLSeconds:  600,
-			CacheSizeLimitMB: 1000,
+			ListenAddrs:                 []string{"0.0.0.0:6881"},
+			BootstrapPeers:              GetDefaultBootstrapPeers(),
+			BootstrapRefreshSeconds:     600,
+			MinRoutingTableNodes:        8,
+			StateDir:                    "dht-state",
+			RepublishIntervalSeconds:    10800,
+			RepublishWorkers:            64,
+			CacheTTLSeconds:             600,
+			CacheStaleSeconds:           600,
+			CacheSizeLimitMB:            1000,
+			NotFoundCacheSeconds:        60,
+			CacheRefreshTopN:            1000,
+			CacheRefreshIntervalSeconds: 60,
+			RepublishOnReadSeconds:      300,
+			Traversal: TraversalConfig{
+				Alpha:                     15,
+				K:                         8,
+				QueryTimeoutSeconds:       8,
+				InitialQueryTimeoutMillis: 1500,
+				LatestWindowMillis:        2000,
+				QueriesPerSecond:          100,
+				QueryBurst:                500,
+				GetRetry: GetRetryConfig{
+					Attempts:             2,
+					InitialBackoffMillis: 500,
+					MaxBackoffMillis:     5000,
+					Jitter:               0.2,
+				},
+			},
+			HealthProbeIntervalSeconds: 300,
+		},
+		PeeringConfig: PeeringConfig{
+			BatchSize:           100,
+			FlushIntervalMillis: 1000,
+		},
+		ArchiveConfig: ArchiveConfig{
+			CRON: "0 3 * * *",
+		},
+		JournalConfig: JournalConfig{
+			Sync: true,
+		},
+		GCConfig: GCConfig{
+			CRON: "0 4 * * *",
+		},
+		Retention: RetentionConfig{
+			ChallengeExpirySeconds: 600,
+		},
+		CORS: CORSConfig{
+			MaxAgeSeconds: 43200,
+		},
+		Abuse: AbuseConfig{
+			FlagScore:           0.5,
+			ShadowScore:         1,
+			RejectScore:         2,
+			ReviewQueueSize:     1000,
+			PrefixLength:        4,
+			PrefixPutsPerMinute: 30,
+			EntropyBitsPerByte:  6.5,
 		},
 		Log: LogConfig{
 			Level: logrus.DebugLevel.String(),
@@ -108,6 +570,7 @@ func LoadConfig(path string) (*Config, error) {
 		if err = loadTOMLConfig(path, &cfg); err != nil {
 			return nil, errors.Wrap(err, "load toml config")
 		}
+		cfg.Path = path
 	}
 
 	if err = applyEnvVariables(&cfg); err != nil {
@@ -129,11 +592,23 @@ func checkValidConfigPath(path string) (bool, error) {
 	return defaultConfig, nil
 }
 
+// removedKeys maps config keys that are no longer read to the keys replacing them, so a config still setting one is
+// rejected rather than silently ignored
+var removedKeys = map[string]string{
+	"dht.republish_cron": "dht.republish_interval_seconds",
+}
+
 func loadTOMLConfig(path string, cfg *Config) error {
 	// load from TOML file
-	if _, err := toml.DecodeFile(path, cfg); err != nil {
+	md, err := toml.DecodeFile(path, cfg)
+	if err != nil {
 		return errors.Wrapf(err, "could not load config: %s", path)
 	}
+	for _, key := range md.Undecoded() {
+		if replacement, removed := removedKeys[key.String()]; removed {
+			return fmt.Errorf("config key %q in %s has been removed, use %q instead", key, path, replacement)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,847 @@
+package dht
+
+// Copied from https://github.com/anacrolix/dht/blob/master/exts/getput/getput.go and modified
+// to return signature data and stream intermediate results
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/bep44"
+	k_nearest_nodes "github.com/anacrolix/dht/v2/k-nearest-nodes"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/dht/v2/traversal"
+	"github.com/anacrolix/dht/v2/types"
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// FullGetResult is the result of a BEP44 get, including the signature data needed to republish the record
+type FullGetResult struct {
+	Seq     int64
+	V       bencode.Bytes
+	Sig     [64]byte
+	Mutable bool
+	// ConflictDetected is set when nodes returned different values signed with the same sequence number. The value
+	// returned is the first one seen, but a fork like this can mean the record's key has been compromised.
+	ConflictDetected bool
+	// Confirmations is the number of nodes that returned the value at its sequence number. A node queried through
+	// more than one server is counted once for each.
+	Confirmations int
+}
+
+// merge folds another mutable result for the same target into r, keeping the higher sequence number, counting the
+// confirmations of the same value, and flagging a conflict when both carry the same sequence number but different
+// values. It returns true if this merge detected a new conflict.
+func (r *FullGetResult) merge(other FullGetResult) bool {
+	switch {
+	case other.Seq > r.Seq:
+		*r = other
+	case other.Seq == r.Seq && !bytes.Equal(other.V, r.V):
+		newConflict := !r.ConflictDetected
+		r.ConflictDetected = true
+		return newConflict
+	case other.Seq == r.Seq:
+		r.Confirmations += other.Confirmations
+		r.ConflictDetected = r.ConflictDetected || other.ConflictDetected
+	}
+	return false
+}
+
+// TraversalConfig controls how aggressively get and put traversals query the DHT
+type TraversalConfig struct {
+	// Alpha is the number of node queries in flight at once
+	Alpha int
+	// K is the number of closest nodes tracked by the traversal, which are the nodes a put is sent to
+	K int
+	// QueryTimeout bounds each individual node query
+	QueryTimeout time.Duration
+	// InitialQueryTimeout is how long a node is first given to answer a get query. Nodes that don't answer in time
+	// are only waited on for the rest of QueryTimeout if they are among the K closest known to the target. Zero, or
+	// a value no shorter than QueryTimeout, gives every node the full QueryTimeout.
+	InitialQueryTimeout time.Duration
+	// StallTimeout bounds how long to wait for a traversal to stall on its own before treating it as stalled.
+	// Zero waits until the traversal runs out of nodes to query.
+	StallTimeout time.Duration
+	// PutQuorum is the minimum number of nodes that must acknowledge a put for it to succeed. When the K closest
+	// nodes don't reach the quorum the put is retried against the next closest nodes found. Zero disables the check.
+	PutQuorum int
+	// GetRetry controls how a get that stalls without finding a value is retried
+	GetRetry RetryPolicy
+	// LatestWindow is how long GetLatest keeps collecting responses after the first verified result
+	LatestWindow time.Duration
+	// Limiter is a token bucket every outbound get and put query waits on. It should be shared by every traversal
+	// so the limit applies to the node as a whole. Nil leaves queries to the server's own send limiter.
+	Limiter *rate.Limiter
+	// NodeFilter, if set, leaves out of a server's traversals the nodes it rejects for that server, such as nodes of
+	// an address family the server's socket can't reach. Nil traverses every node the library accepts.
+	NodeFilter func(s *dht.Server, addr krpc.NodeAddr) bool
+}
+
+// RetryPolicy controls how Get retries a traversal that stalls without finding a value. Each retry waits for an
+// exponentially growing backoff, re-bootstraps, and runs the traversal again.
+type RetryPolicy struct {
+	// Attempts is the number of retries after the first traversal. Zero disables retries.
+	Attempts int
+	// InitialBackoff is the wait before the first retry, doubling for each retry after it
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries. Zero leaves the backoff uncapped.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each backoff, from 0 to 1, that is randomized
+	Jitter float64
+	// Rebootstrap is called with the server being queried before each retry. Nil bootstraps the server from its
+	// routing table or starting nodes.
+	Rebootstrap func(ctx context.Context, s *dht.Server) error
+}
+
+// backoff returns how long to wait before the given retry, counting from zero
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	b := p.InitialBackoff
+	for i := 0; i < retry && (p.MaxBackoff <= 0 || b < p.MaxBackoff); i++ {
+		b *= 2
+	}
+	if p.MaxBackoff > 0 && b > p.MaxBackoff {
+		b = p.MaxBackoff
+	}
+	if p.Jitter > 0 && b > 0 {
+		jitter := time.Duration(p.Jitter * float64(b))
+		b = b - jitter + rand.N(2*jitter+1)
+	}
+	return b
+}
+
+var (
+	// ErrNotFound is returned when a get traversal runs out of nodes to query without finding a value
+	ErrNotFound = errors.New("value not found")
+	// ErrStalled is returned when a traversal is cut short by its stall timeout or context before it could finish.
+	// Unlike ErrNotFound it says nothing about whether the value exists.
+	ErrStalled = errors.New("dht traversal stalled")
+	// ErrAllPutsFailed is returned when a put traversal finds nodes but none of them accept the put
+	ErrAllPutsFailed = errors.New("all puts failed")
+)
+
+// stalled wraps a context error in ErrStalled, keeping the original error matchable
+func stalled(err error) error {
+	return fmt.Errorf("%w: %w", ErrStalled, err)
+}
+
+// DefaultTraversalConfig returns the traversal parameters used when none are configured
+func DefaultTraversalConfig() TraversalConfig {
+	return TraversalConfig{
+		Alpha:        15,
+		K:            8,
+		QueryTimeout: 8 * time.Second,
+		LatestWindow: 2 * time.Second,
+	}
+}
+
+// waitForQuery blocks until the limiter allows another query, returning the rate limiting to query the server with.
+// Queries that have waited on the limiter skip the server's send limiter on their first send so they aren't counted
+// twice.
+func (c TraversalConfig) waitForQuery(ctx context.Context) (dht.QueryRateLimiting, error) {
+	if c.Limiter == nil {
+		return dht.QueryRateLimiting{}, nil
+	}
+	if err := c.Limiter.Wait(ctx); err != nil {
+		return dht.QueryRateLimiting{}, err
+	}
+	return dht.QueryRateLimiting{NotFirst: true}, nil
+}
+
+// withDefaults fills in any unset values from the default traversal config
+func (c TraversalConfig) withDefaults() TraversalConfig {
+	defaults := DefaultTraversalConfig()
+	if c.Alpha <= 0 {
+		c.Alpha = defaults.Alpha
+	}
+	if c.K <= 0 {
+		c.K = defaults.K
+	}
+	if c.QueryTimeout <= 0 {
+		c.QueryTimeout = defaults.QueryTimeout
+	}
+	if c.LatestWindow <= 0 {
+		c.LatestWindow = defaults.LatestWindow
+	}
+	return c
+}
+
+// stallTimeout returns a channel that fires once the stall timeout has elapsed, and a function to release it.
+// A nil channel is returned when no stall timeout is configured, which blocks forever in a select.
+func (c TraversalConfig) stallTimeout() (<-chan time.Time, func()) {
+	if c.StallTimeout <= 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(c.StallTimeout)
+	return timer.C, func() { timer.Stop() }
+}
+
+// startGetTraversal starts a get traversal towards the target. Each node query is traced as a child of the span in
+// ctx, which the caller ends once it is done with the traversal.
+func startGetTraversal(ctx context.Context, target bep44.Target, s *dht.Server, seq *int64, salt []byte, cfg TraversalConfig) (vChan chan FullGetResult, op *traversal.Operation, err error) {
+	parent := trace.SpanFromContext(ctx)
+	timeouts := newQueryTimeouts(target, cfg)
+	vChan = make(chan FullGetResult)
+	op = traversal.Start(traversal.OperationInput{
+		Alpha:  cfg.Alpha,
+		K:      cfg.K,
+		Target: target,
+		DoQuery: func(ctx context.Context, addr krpc.NodeAddr) traversal.QueryResult {
+			logger := log.ContextLogger(ctx)
+			span := startQuerySpan(ctx, parent, "getput.GetQuery", addr)
+			defer span.End()
+
+			// don't let a single unresponsive node hold up the traversal
+			res := timeouts.query(ctx, addr, func(ctx context.Context) dht.QueryResult {
+				rl, err := cfg.waitForQuery(ctx)
+				if err != nil {
+					return dht.QueryResult{Err: err}
+				}
+				return s.Get(ctx, dht.NewAddr(addr.UDP()), target, seq, rl)
+			})
+			if err := res.ToError(); err != nil {
+				span.RecordError(err)
+				if !errors.Is(err, context.Canceled) && !timedOut(err) {
+					logger.Levelf(log.Debug, "error querying %v: %v", addr, err)
+				}
+			}
+			span.SetAttributes(attrResponded.Bool(res.Reply.R != nil))
+			if r := res.Reply.R; r != nil {
+				if r.Seq != nil {
+					span.SetAttributes(attrSeq.Int64(*r.Seq))
+				}
+				rv := r.V
+				bv := rv
+				if sha1.Sum(bv) == target {
+					select {
+					case vChan <- FullGetResult{
+						V:             rv,
+						Sig:           r.Sig,
+						Mutable:       false,
+						Confirmations: 1,
+					}:
+					case <-ctx.Done():
+					}
+				} else if r.Seq != nil && sha1.Sum(append(r.K[:], salt...)) == target && bep44.Verify(r.K[:], salt, *r.Seq, bv, r.Sig[:]) {
+					select {
+					case vChan <- FullGetResult{
+						Seq:           *r.Seq,
+						V:             rv,
+						Sig:           r.Sig,
+						Mutable:       true,
+						Confirmations: 1,
+					}:
+					case <-ctx.Done():
+					}
+				} else if rv != nil {
+					logger.Levelf(log.Debug, "get response item hash didn't match target: %q", rv)
+				}
+			}
+			tqr := res.TraversalQueryResult(addr)
+			timeouts.learn(tqr.Nodes...)
+			timeouts.learn(tqr.Nodes6...)
+			// Filter replies from nodes that don't have a string token. This doesn't look prettier
+			// with generics. "The token value should be a short binary string." ¯\_(ツ)_/¯ (BEP 5).
+			tqr.ClosestData, _ = tqr.ClosestData.(string)
+			if tqr.ClosestData == nil {
+				tqr.ResponseFrom = nil
+			}
+			return tqr
+		},
+		NodeFilter: func(node types.AddrMaybeId) bool {
+			if !s.TraversalNodeFilter(node) {
+				return false
+			}
+			return cfg.NodeFilter == nil || cfg.NodeFilter(s, node.Addr.ToNodeAddr())
+		},
+	})
+	nodes, err := s.TraversalStartingNodes()
+	timeouts.learnStarting(nodes)
+	op.AddNodes(nodes)
+	return
+}
+
+// Get performs a BEP44 get traversal, blocking until the traversal stalls, and returns the result with the
+// highest sequence number seen. A traversal that stalls without a value is retried according to cfg.GetRetry.
+func Get(ctx context.Context, target bep44.Target, s *dht.Server, seq *int64, salt []byte, cfg TraversalConfig) (ret FullGetResult, stats *traversal.Stats, err error) {
+	ctx, span := startSpan(ctx, "getput.Get", target)
+	attempts := 1
+	defer func() {
+		attrs := []attribute.KeyValue{attrAttempts.Int(attempts)}
+		if err == nil && ret.Mutable {
+			attrs = append(attrs, attrSeq.Int64(ret.Seq))
+		}
+		endSpan(span, stats, "", err, attrs...)
+	}()
+
+	cfg = cfg.withDefaults()
+	ret, stats, err = getOnce(ctx, target, s, seq, salt, cfg)
+	for retry := 0; retry < cfg.GetRetry.Attempts && retryable(ctx, err); retry++ {
+		backoff := cfg.GetRetry.backoff(retry)
+		log.ContextLogger(ctx).Levelf(log.Debug, "get stalled without a value, retrying in %v", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ret, stats, stalled(ctx.Err())
+		}
+
+		rebootstrap := cfg.GetRetry.Rebootstrap
+		if rebootstrap == nil {
+			rebootstrap = func(ctx context.Context, s *dht.Server) error {
+				_, err := s.BootstrapContext(ctx)
+				return err
+			}
+		}
+		if bErr := rebootstrap(ctx, s); bErr != nil {
+			log.ContextLogger(ctx).Levelf(log.Debug, "failed to re-bootstrap before retrying get: %v", bErr)
+		}
+		attempts++
+		ret, stats, err = getOnce(ctx, target, s, seq, salt, cfg)
+	}
+	return ret, stats, err
+}
+
+// retryable returns whether a get that failed with err is worth retrying
+func retryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && (errors.Is(err, ErrNotFound) || errors.Is(err, ErrStalled))
+}
+
+// GetParallel runs Get on each of the given servers at once and returns the freshest result found by any of them,
+// along with the combined stats of every traversal. An error is only returned when no server finds a value.
+// Querying through servers with independent routing tables keeps gets working when one table is poisoned or
+// partitioned from the rest of the network.
+func GetParallel(ctx context.Context, target bep44.Target, servers []*dht.Server, seq *int64, salt []byte, cfg TraversalConfig) (FullGetResult, *traversal.Stats, error) {
+	if len(servers) == 1 {
+		return Get(ctx, target, servers[0], seq, salt, cfg)
+	}
+
+	type result struct {
+		res   FullGetResult
+		stats *traversal.Stats
+		err   error
+	}
+	results := make([]result, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, stats, err := Get(ctx, target, s, seq, salt, cfg)
+			results[i] = result{res: res, stats: stats, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var (
+		best     FullGetResult
+		found    bool
+		stats    traversal.Stats
+		firstErr error
+	)
+	for _, r := range results {
+		if r.stats != nil {
+			stats.NumAddrsTried += r.stats.NumAddrsTried
+			stats.NumResponses += r.stats.NumResponses
+		}
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		switch {
+		case !found:
+			best = r.res
+			found = true
+		case r.res.Mutable:
+			if best.merge(r.res) {
+				recordConflict(ctx, target, best.Seq)
+			}
+		}
+	}
+	if !found {
+		return best, &stats, firstErr
+	}
+	return best, &stats, nil
+}
+
+func getOnce(ctx context.Context, target bep44.Target, s *dht.Server, seq *int64, salt []byte, cfg TraversalConfig) (ret FullGetResult, stats *traversal.Stats, err error) {
+	ctx, span := startSpan(ctx, "getput.GetTraversal", target)
+	var (
+		reason  string
+		results int
+	)
+	defer func() {
+		attrs := []attribute.KeyValue{attrResults.Int(results)}
+		if results > 0 && ret.Mutable {
+			attrs = append(attrs, attrSeq.Int64(ret.Seq), attrConflict.Bool(ret.ConflictDetected))
+		}
+		endSpan(span, stats, reason, err, attrs...)
+		recordTraversal(ctx, traversalGet, stats)
+	}()
+
+	vChan, op, err := startGetTraversal(ctx, target, s, seq, salt, cfg)
+	if err != nil {
+		return
+	}
+	stallTimeout, release := cfg.stallTimeout()
+	defer release()
+	ret.Seq = math.MinInt64
+receiveResults:
+	select {
+	case <-op.Stalled():
+		reason = stallReasonExhausted
+		if results == 0 {
+			err = ErrNotFound
+		}
+	case <-stallTimeout:
+		reason = stallReasonTimeout
+		if results == 0 {
+			err = ErrStalled
+		}
+	case v := <-vChan:
+		log.ContextLogger(ctx).Levelf(log.Debug, "received %#v", v)
+		results++
+		if !v.Mutable {
+			reason = stallReasonImmutable
+			ret = v
+			break
+		}
+		if ret.merge(v) {
+			recordConflict(ctx, target, ret.Seq)
+		}
+		goto receiveResults
+	case <-ctx.Done():
+		reason = stallReasonContext
+		err = stalled(ctx.Err())
+	}
+	op.Stop()
+	stats = op.Stats()
+	return
+}
+
+// GetStream performs a BEP44 get traversal, sending each verified result to the returned channel as soon as it
+// supersedes every result sent before it. For mutable items only results with a strictly higher sequence number
+// than the last one sent are forwarded; an immutable item is sent once and ends the traversal. The channel is closed
+// when the traversal stalls or the context is done, after which the returned function reports the traversal's stats.
+func GetStream(ctx context.Context, target bep44.Target, s *dht.Server, seq *int64, salt []byte, cfg TraversalConfig) (<-chan FullGetResult, func() *traversal.Stats, error) {
+	ctx, span := startSpan(ctx, "getput.GetStream", target)
+	cfg = cfg.withDefaults()
+	vChan, op, err := startGetTraversal(ctx, target, s, seq, salt, cfg)
+	if err != nil {
+		endSpan(span, nil, "", err)
+		return nil, nil, err
+	}
+
+	results := make(chan FullGetResult)
+	done := make(chan struct{})
+	var stats *traversal.Stats
+	go func() {
+		var (
+			reason string
+			sent   int
+		)
+		latest := int64(math.MinInt64)
+		defer close(done)
+		defer close(results)
+		defer func() {
+			op.Stop()
+			stats = op.Stats()
+			attrs := []attribute.KeyValue{attrResults.Int(sent)}
+			if sent > 0 && latest != math.MinInt64 {
+				attrs = append(attrs, attrSeq.Int64(latest))
+			}
+			endSpan(span, stats, reason, nil, attrs...)
+			recordTraversal(ctx, traversalGetStream, stats)
+		}()
+
+		stallTimeout, release := cfg.stallTimeout()
+		defer release()
+
+		for {
+			select {
+			case <-op.Stalled():
+				reason = stallReasonExhausted
+				return
+			case <-stallTimeout:
+				reason = stallReasonTimeout
+				return
+			case <-ctx.Done():
+				reason = stallReasonContext
+				return
+			case v := <-vChan:
+				log.ContextLogger(ctx).Levelf(log.Debug, "received %#v", v)
+				if v.Mutable && v.Seq <= latest {
+					continue
+				}
+				select {
+				case results <- v:
+				case <-ctx.Done():
+					reason = stallReasonContext
+					return
+				}
+				sent++
+				if !v.Mutable {
+					reason = stallReasonImmutable
+					return
+				}
+				latest = v.Seq
+			}
+		}
+	}()
+
+	return results, func() *traversal.Stats {
+		<-done
+		return stats
+	}, nil
+}
+
+// LatestResult is the freshest result of a get, along with how many nodes reported it
+type LatestResult struct {
+	FullGetResult
+	// Reports is the number of nodes that returned this exact record
+	Reports int
+}
+
+// GetLatest performs a BEP44 get traversal that keeps collecting responses for cfg.LatestWindow after the first
+// verified result, rather than returning as soon as one is found. It returns the result with the highest sequence
+// number and the number of nodes that reported it, which callers can use as a confidence signal. The traversal
+// ends early if it stalls before the window closes.
+func GetLatest(ctx context.Context, target bep44.Target, s *dht.Server, seq *int64, salt []byte, cfg TraversalConfig) (ret LatestResult, stats *traversal.Stats, err error) {
+	ctx, span := startSpan(ctx, "getput.GetLatest", target)
+	var reason string
+	defer func() {
+		attrs := []attribute.KeyValue{attrResults.Int(ret.Reports)}
+		if ret.Reports > 0 {
+			attrs = append(attrs, attrSeq.Int64(ret.Seq), attrConflict.Bool(ret.ConflictDetected))
+		}
+		endSpan(span, stats, reason, err, attrs...)
+		recordTraversal(ctx, traversalGetLatest, stats)
+	}()
+
+	cfg = cfg.withDefaults()
+	vChan, op, err := startGetTraversal(ctx, target, s, seq, salt, cfg)
+	if err != nil {
+		return
+	}
+	defer func() {
+		op.Stop()
+		stats = op.Stats()
+	}()
+
+	stallTimeout, release := cfg.stallTimeout()
+	defer release()
+	// the window only starts once the first result arrives
+	var windowClosed <-chan time.Time
+	for {
+		notFound := ErrNotFound
+		select {
+		case <-op.Stalled():
+			reason = stallReasonExhausted
+		case <-stallTimeout:
+			reason = stallReasonTimeout
+			notFound = ErrStalled
+		case <-windowClosed:
+			reason = stallReasonWindow
+		case v := <-vChan:
+			switch {
+			case ret.Reports == 0:
+				timer := time.NewTimer(cfg.LatestWindow)
+				defer timer.Stop()
+				windowClosed = timer.C
+				fallthrough
+			case v.Seq > ret.Seq:
+				ret = LatestResult{FullGetResult: v, Reports: 1}
+			case v.Seq == ret.Seq && bytes.Equal(v.V, ret.V):
+				ret.Reports++
+			case v.Seq == ret.Seq && !ret.ConflictDetected:
+				ret.ConflictDetected = true
+				recordConflict(ctx, target, ret.Seq)
+			}
+			continue
+		case <-ctx.Done():
+			reason = stallReasonContext
+			err = stalled(ctx.Err())
+			return
+		}
+		if ret.Reports == 0 {
+			err = notFound
+		}
+		return
+	}
+}
+
+// ErrQuorumNotMet is returned when fewer nodes than the configured put quorum acknowledged a put
+var ErrQuorumNotMet = errors.New("put quorum not met")
+
+// SeqToPut returns the put message to send given the highest sequence number observed during the get traversal
+type SeqToPut func(seq int64) bep44.Put
+
+// NodePutResult is the outcome of putting an item to a single node
+type NodePutResult struct {
+	Addr  krpc.NodeAddrPort
+	Token string
+	Err   error
+}
+
+// Succeeded returns true if the node acknowledged the put
+func (r NodePutResult) Succeeded() bool {
+	return r.Err == nil
+}
+
+// PutReport describes the outcome of a put for each of the nodes it was sent to
+type PutReport struct {
+	Nodes []NodePutResult
+	Stats *traversal.Stats
+	// NewestSeq is the sequence number of the newest item the traversed nodes held before the put, or 0 if none did
+	NewestSeq int64
+}
+
+// Succeeded returns the number of nodes that acknowledged the put
+func (r *PutReport) Succeeded() int {
+	if r == nil {
+		return 0
+	}
+	var n int
+	for _, node := range r.Nodes {
+		if node.Succeeded() {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of nodes that did not acknowledge the put
+func (r *PutReport) Failed() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.Nodes) - r.Succeeded()
+}
+
+// Put performs a get traversal to find the closest nodes to the target and then puts the item to each of them,
+// returning a report of which nodes acknowledged the put
+func Put(ctx context.Context, target krpc.ID, s *dht.Server, salt []byte, seqToPut SeqToPut, cfg TraversalConfig) (report *PutReport, err error) {
+	ctx, span := startSpan(ctx, "getput.Put", target)
+	var (
+		reason string
+		put    bep44.Put
+	)
+	defer func() {
+		var stats *traversal.Stats
+		var attrs []attribute.KeyValue
+		if report != nil {
+			stats = report.Stats
+			attrs = append(attrs, attrAcked.Int(report.Succeeded()), attrPutFailed.Int(report.Failed()), attrSeq.Int64(put.Seq))
+		}
+		endSpan(span, stats, reason, err, attrs...)
+		recordTraversal(ctx, traversalPut, stats)
+	}()
+
+	cfg = cfg.withDefaults()
+	logger := log.ContextLogger(ctx)
+
+	// when a quorum is required track spare nodes beyond the K closest to fall back to
+	traversalCfg := cfg
+	if cfg.PutQuorum > 0 {
+		traversalCfg.K = cfg.K + max(cfg.K, cfg.PutQuorum)
+	}
+	vChan, op, err := startGetTraversal(ctx, target, s,
+		// When we do a get traversal for a put, we don't care what seq the peers have?
+		nil,
+		// This is duplicated with the put, but we need it to filter responses for autoSeq.
+		salt,
+		traversalCfg)
+	if err != nil {
+		return
+	}
+	stallTimeout, release := cfg.stallTimeout()
+	defer release()
+	var autoSeq int64
+	// track the newest mutable item seen so the put can be made compare-and-swap aware
+	var existing *FullGetResult
+notDone:
+	select {
+	case v := <-vChan:
+		if v.Mutable && v.Seq > autoSeq {
+			autoSeq = v.Seq
+			existing = &v
+		}
+		goto notDone
+	case <-op.Stalled():
+		reason = stallReasonExhausted
+	case <-stallTimeout:
+		reason = stallReasonTimeout
+	case <-ctx.Done():
+		reason = stallReasonContext
+		err = stalled(ctx.Err())
+	}
+	op.Stop()
+
+	// candidates are ordered closest first
+	var candidates []k_nearest_nodes.Elem
+	op.Closest().Range(func(elem k_nearest_nodes.Elem) {
+		candidates = append(candidates, elem)
+	})
+
+	report = &PutReport{NewestSeq: autoSeq}
+	put = casPut(seqToPut(autoSeq), existing)
+	next := min(cfg.K, len(candidates))
+	report.Nodes = putToNodes(ctx, s, put, cfg, candidates[:next])
+	for cfg.PutQuorum > 0 && report.Succeeded() < cfg.PutQuorum && next < len(candidates) && ctx.Err() == nil {
+		end := min(next+cfg.PutQuorum-report.Succeeded(), len(candidates))
+		logger.Levelf(log.Debug, "put quorum not met with %d acks, retrying with %d more nodes", report.Succeeded(), end-next)
+		report.Nodes = append(report.Nodes, putToNodes(ctx, s, put, cfg, candidates[next:end])...)
+		next = end
+	}
+	report.Stats = op.Stats()
+	if err == nil && len(report.Nodes) > 0 && report.Succeeded() == 0 {
+		err = errors.Wrapf(ErrAllPutsFailed, "none of %d nodes acknowledged", len(report.Nodes))
+	}
+	if err == nil && cfg.PutQuorum > 0 && report.Succeeded() < cfg.PutQuorum {
+		err = errors.Wrapf(ErrQuorumNotMet, "%d of %d required nodes acknowledged", report.Succeeded(), cfg.PutQuorum)
+	}
+	return
+}
+
+// casPut adapts the put to the newest item already on the DHT. If the existing item holds the same value it is
+// republished as-is, keeping its sequence number and signature, rather than bumping the sequence number. Otherwise
+// the CAS field is set to the existing sequence number so the put only replaces the item that was observed.
+func casPut(put bep44.Put, existing *FullGetResult) bep44.Put {
+	if existing == nil || !put.IsMutable() {
+		return put
+	}
+	v, err := bencode.Marshal(put.V)
+	if err != nil {
+		return put
+	}
+	if bytes.Equal(existing.V, v) {
+		put.Seq = existing.Seq
+		put.Sig = existing.Sig
+		put.Cas = 0
+		return put
+	}
+	if put.Seq > existing.Seq {
+		put.Cas = existing.Seq
+	}
+	return put
+}
+
+// putToNodes concurrently puts the item to each of the given nodes and returns the result for each
+func putToNodes(ctx context.Context, s *dht.Server, put bep44.Put, cfg TraversalConfig, nodes []k_nearest_nodes.Elem) []NodePutResult {
+	logger := log.ContextLogger(ctx)
+	parent := trace.SpanFromContext(ctx)
+	results := make([]NodePutResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, elem := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			span := startQuerySpan(ctx, parent, "getput.PutQuery", elem.Addr)
+			defer span.End()
+
+			// This is enforced by startGetTraversal.
+			token := elem.Data.(string)
+			rl, err := cfg.waitForQuery(ctx)
+			if err != nil {
+				span.RecordError(err)
+				results[i] = NodePutResult{Addr: elem.Addr, Token: token, Err: err}
+				return
+			}
+			res := s.Put(ctx, dht.NewAddr(elem.Addr.UDP()), put, token, rl)
+			err = res.ToError()
+			span.SetAttributes(attrAcked.Bool(err == nil))
+			if err != nil {
+				span.RecordError(err)
+				logger.Levelf(log.Warning, "error putting to %v [token=%q]: %v", elem.Addr, token, err)
+			} else {
+				logger.Levelf(log.Debug, "put to %v [token=%q]", elem.Addr, token)
+			}
+			results[i] = NodePutResult{Addr: elem.Addr, Token: token, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// PutManyConfig bounds the work done by a batch of puts
+type PutManyConfig struct {
+	// Workers is the number of put traversals run at once
+	Workers int
+	// Limiter, if set, is waited on before each put traversal starts
+	Limiter *rate.Limiter
+	// Timeout, if set, bounds each individual put
+	Timeout time.Duration
+}
+
+// PutManyResult is the outcome of a single put in a batch
+type PutManyResult struct {
+	Report *PutReport
+	Err    error
+}
+
+// PutMany puts each of the given items to the DHT using a fixed pool of workers, so that large batches don't
+// spawn an unbounded number of concurrent traversals. Results are returned in the same order as the puts.
+func PutMany(ctx context.Context, s *dht.Server, puts []bep44.Put, cfg TraversalConfig, batch PutManyConfig) []PutManyResult {
+	results := make([]PutManyResult, len(puts))
+	workers := batch.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(puts)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = putOne(ctx, s, puts[i], cfg, batch)
+			}
+		}()
+	}
+
+feed:
+	for i := range puts {
+		if batch.Limiter != nil {
+			if err := batch.Limiter.Wait(ctx); err != nil {
+				for j := i; j < len(puts); j++ {
+					results[j].Err = err
+				}
+				break feed
+			}
+		}
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			for j := i; j < len(puts); j++ {
+				results[j].Err = ctx.Err()
+			}
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+func putOne(ctx context.Context, s *dht.Server, put bep44.Put, cfg TraversalConfig, batch PutManyConfig) PutManyResult {
+	if batch.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, batch.Timeout)
+		defer cancel()
+	}
+	report, err := Put(ctx, put.Target(), s, put.Salt, func(int64) bep44.Put { return put }, cfg)
+	return PutManyResult{Report: report, Err: err}
+}
@@ -0,0 +1,404 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestTraversalConfigDefaults(t *testing.T) {
+	t.Run("zero config uses defaults", func(t *testing.T) {
+		cfg := TraversalConfig{}.withDefaults()
+		assert.Equal(t, DefaultTraversalConfig(), cfg)
+	})
+
+	t.Run("set values are kept", func(t *testing.T) {
+		cfg := TraversalConfig{Alpha: 3, QueryTimeout: time.Second, StallTimeout: 5 * time.Second}.withDefaults()
+		assert.Equal(t, 3, cfg.Alpha)
+		assert.Equal(t, DefaultTraversalConfig().K, cfg.K)
+		assert.Equal(t, time.Second, cfg.QueryTimeout)
+		assert.Equal(t, 5*time.Second, cfg.StallTimeout)
+	})
+
+	t.Run("no stall timeout never fires", func(t *testing.T) {
+		timeout, release := TraversalConfig{}.stallTimeout()
+		defer release()
+		assert.Nil(t, timeout)
+	})
+
+	t.Run("stall timeout fires", func(t *testing.T) {
+		timeout, release := TraversalConfig{StallTimeout: time.Millisecond}.stallTimeout()
+		defer release()
+		select {
+		case <-timeout:
+		case <-time.After(time.Second):
+			t.Fatal("stall timeout did not fire")
+		}
+	})
+}
+
+func TestPutQuorum(t *testing.T) {
+	s := newTestServer(t)
+	put := newTestPut(t, "hello quorum")
+
+	t.Run("quorum met", func(t *testing.T) {
+		report, err := Put(context.Background(), put.Target(), s, nil, func(int64) bep44.Put { return put }, TraversalConfig{PutQuorum: 1})
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, report.Succeeded(), 1)
+	})
+
+	t.Run("quorum not met", func(t *testing.T) {
+		report, err := Put(context.Background(), put.Target(), s, nil, func(int64) bep44.Put { return put }, TraversalConfig{PutQuorum: 5})
+		assert.ErrorIs(t, err, ErrQuorumNotMet)
+		require.NotNil(t, report)
+		assert.Less(t, report.Succeeded(), 5)
+	})
+}
+
+func TestQueryLimiter(t *testing.T) {
+	s := newTestServer(t)
+	put := newTestPut(t, "hello limiter")
+
+	t.Run("queries take tokens", func(t *testing.T) {
+		limiter := rate.NewLimiter(rate.Every(time.Hour), 100)
+		cfg := TraversalConfig{Limiter: limiter}
+		_, err := Put(context.Background(), put.Target(), s, nil, func(int64) bep44.Put { return put }, cfg)
+		require.NoError(t, err)
+		assert.Less(t, limiter.Tokens(), float64(100))
+	})
+
+	t.Run("exhausted limiter blocks queries", func(t *testing.T) {
+		limiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+		require.True(t, limiter.Allow())
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		report, err := Put(ctx, put.Target(), s, nil, func(int64) bep44.Put { return put }, TraversalConfig{Limiter: limiter, PutQuorum: 1})
+		assert.Error(t, err)
+		if report != nil {
+			assert.Zero(t, report.Succeeded())
+		}
+	})
+}
+
+// newTestServer returns a dht server listening on localhost that bootstraps from the given peers, or itself if none
+func newTestServer(t *testing.T, peers ...dht.Addr) *dht.Server {
+	c := dht.NewDefaultServerConfig()
+	c.WaitToReply = true
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	require.NoError(t, err)
+	c.Conn = conn
+	if len(peers) == 0 {
+		peers = []dht.Addr{dht.NewAddr(conn.LocalAddr())}
+	}
+	c.StartingNodes = func() ([]dht.Addr, error) { return peers, nil }
+
+	s, err := dht.NewServer(c)
+	require.NoError(t, err)
+	_, err = s.Bootstrap()
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newTestPut returns a signed mutable put for the given value
+func newTestPut(t *testing.T, v string) bep44.Put {
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	put := bep44.Put{
+		V:   []byte(v),
+		K:   (*[32]byte)(pubKey),
+		Seq: time.Now().Unix(),
+	}
+	put.Sign(privKey)
+	return put
+}
+
+func TestPutCAS(t *testing.T) {
+	s := newTestServer(t)
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	newPut := func(v string, seq int64) bep44.Put {
+		put := bep44.Put{V: []byte(v), K: (*[32]byte)(pubKey), Seq: seq}
+		put.Sign(privKey)
+		return put
+	}
+
+	putAndGet := func(put bep44.Put) FullGetResult {
+		_, err := Put(context.Background(), put.Target(), s, nil, func(int64) bep44.Put { return put }, TraversalConfig{})
+		require.NoError(t, err)
+		got, _, err := Get(context.Background(), put.Target(), s, nil, nil, TraversalConfig{})
+		require.NoError(t, err)
+		return got
+	}
+
+	first := newPut("hello cas", 1)
+	got := putAndGet(first)
+	assert.Equal(t, int64(1), got.Seq)
+
+	// the same value with a higher seq republishes the existing item
+	got = putAndGet(newPut("hello cas", 2))
+	assert.Equal(t, int64(1), got.Seq)
+	assert.Equal(t, first.Sig, got.Sig)
+
+	// a new value replaces it
+	got = putAndGet(newPut("goodbye cas", 3))
+	assert.Equal(t, int64(3), got.Seq)
+
+	t.Run("cas set from existing seq", func(t *testing.T) {
+		existing := &FullGetResult{Seq: 3, V: bencode.MustMarshal([]byte("goodbye cas")), Mutable: true}
+		put := casPut(newPut("hello again", 4), existing)
+		assert.Equal(t, int64(4), put.Seq)
+		assert.Equal(t, int64(3), put.Cas)
+
+		put = casPut(newPut("hello again", 4), nil)
+		assert.Zero(t, put.Cas)
+	})
+}
+
+func TestPutMany(t *testing.T) {
+	s := newTestServer(t)
+
+	var puts []bep44.Put
+	for range 5 {
+		puts = append(puts, newTestPut(t, "hello many"))
+	}
+
+	t.Run("all puts succeed", func(t *testing.T) {
+		limiter := rate.NewLimiter(rate.Inf, 0)
+		results := PutMany(context.Background(), s, puts, TraversalConfig{}, PutManyConfig{Workers: 2, Limiter: limiter, Timeout: 5 * time.Second})
+		require.Len(t, results, len(puts))
+		for i, res := range results {
+			require.NoError(t, res.Err)
+			require.NotNil(t, res.Report)
+
+			got, _, err := Get(context.Background(), puts[i].Target(), s, nil, nil, TraversalConfig{})
+			require.NoError(t, err)
+			assert.Equal(t, puts[i].Seq, got.Seq)
+		}
+	})
+
+	t.Run("cancelled context fails every put", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		results := PutMany(ctx, s, puts, TraversalConfig{}, PutManyConfig{Workers: 2})
+		require.Len(t, results, len(puts))
+		for _, res := range results {
+			assert.Error(t, res.Err)
+		}
+	})
+}
+
+func TestGetRetry(t *testing.T) {
+	t.Run("backoff doubles up to the max", func(t *testing.T) {
+		p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+		assert.Equal(t, 100*time.Millisecond, p.backoff(0))
+		assert.Equal(t, 200*time.Millisecond, p.backoff(1))
+		assert.Equal(t, 300*time.Millisecond, p.backoff(2))
+		assert.Equal(t, 300*time.Millisecond, p.backoff(10))
+	})
+
+	t.Run("backoff jitter stays in range", func(t *testing.T) {
+		p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			b := p.backoff(0)
+			assert.GreaterOrEqual(t, b, 50*time.Millisecond)
+			assert.LessOrEqual(t, b, 150*time.Millisecond)
+		}
+	})
+
+	t.Run("missing value is retried after re-bootstrapping", func(t *testing.T) {
+		s := newTestServer(t)
+		put := newTestPut(t, "never put")
+
+		rebootstraps := 0
+		cfg := TraversalConfig{GetRetry: RetryPolicy{
+			Attempts:       2,
+			InitialBackoff: time.Millisecond,
+			Rebootstrap: func(context.Context, *dht.Server) error {
+				rebootstraps++
+				return nil
+			},
+		}}
+		_, _, err := Get(context.Background(), put.Target(), s, nil, nil, cfg)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, 2, rebootstraps)
+	})
+
+	t.Run("found value is not retried", func(t *testing.T) {
+		s := newTestServer(t)
+		put := newTestPut(t, "hello")
+		_, err := Put(context.Background(), put.Target(), s, nil, func(int64) bep44.Put { return put }, TraversalConfig{})
+		require.NoError(t, err)
+
+		rebootstraps := 0
+		cfg := TraversalConfig{GetRetry: RetryPolicy{
+			Attempts: 2,
+			Rebootstrap: func(context.Context, *dht.Server) error {
+				rebootstraps++
+				return nil
+			},
+		}}
+		res, _, err := Get(context.Background(), put.Target(), s, nil, nil, cfg)
+		require.NoError(t, err)
+		assert.Equal(t, put.Seq, res.Seq)
+		assert.Zero(t, rebootstraps)
+	})
+}
+
+func TestGetParallel(t *testing.T) {
+	ctx := context.Background()
+	stale, fresh, empty := newTestServer(t), newTestServer(t), newTestServer(t)
+
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	putWithSeq := func(s *dht.Server, seq int64) bep44.Put {
+		put := bep44.Put{V: []byte("hello"), K: (*[32]byte)(pubKey), Seq: seq}
+		put.Sign(privKey)
+		_, err := Put(ctx, put.Target(), s, nil, func(int64) bep44.Put { return put }, TraversalConfig{})
+		require.NoError(t, err)
+		return put
+	}
+	putWithSeq(stale, 1)
+	put := putWithSeq(fresh, 2)
+
+	t.Run("freshest result wins", func(t *testing.T) {
+		res, stats, err := GetParallel(ctx, put.Target(), []*dht.Server{stale, empty, fresh}, nil, nil, TraversalConfig{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), res.Seq)
+		assert.GreaterOrEqual(t, res.Confirmations, 1)
+		require.NotNil(t, stats)
+		assert.GreaterOrEqual(t, stats.NumResponses, uint32(3))
+	})
+
+	t.Run("error when no server finds a value", func(t *testing.T) {
+		missing := newTestPut(t, "missing")
+		_, _, err := GetParallel(ctx, missing.Target(), []*dht.Server{stale, fresh}, nil, nil, TraversalConfig{})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("same seq with different values is a conflict", func(t *testing.T) {
+		res, _, err := GetParallel(ctx, put.Target(), []*dht.Server{stale, fresh}, nil, nil, TraversalConfig{})
+		require.NoError(t, err)
+		assert.False(t, res.ConflictDetected)
+
+		forked := bep44.Put{V: []byte("hijacked"), K: (*[32]byte)(pubKey), Seq: 2}
+		forked.Sign(privKey)
+		_, err = Put(ctx, forked.Target(), stale, nil, func(int64) bep44.Put { return forked }, TraversalConfig{})
+		require.NoError(t, err)
+
+		res, _, err = GetParallel(ctx, put.Target(), []*dht.Server{stale, fresh}, nil, nil, TraversalConfig{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), res.Seq)
+		assert.True(t, res.ConflictDetected)
+	})
+}
+
+func TestFullGetResultMerge(t *testing.T) {
+	first := FullGetResult{Seq: 1, V: bencode.Bytes("a"), Mutable: true, Confirmations: 1}
+
+	res := first
+	assert.False(t, res.merge(FullGetResult{Seq: 1, V: bencode.Bytes("a"), Mutable: true, Confirmations: 1}))
+	assert.False(t, res.ConflictDetected)
+	assert.Equal(t, 2, res.Confirmations)
+
+	assert.True(t, res.merge(FullGetResult{Seq: 1, V: bencode.Bytes("b"), Mutable: true, Confirmations: 1}))
+	assert.True(t, res.ConflictDetected)
+	assert.Equal(t, first.V, res.V)
+	// a conflicting value doesn't confirm the record
+	assert.Equal(t, 2, res.Confirmations)
+	// the same conflict is only reported once
+	assert.False(t, res.merge(FullGetResult{Seq: 1, V: bencode.Bytes("c"), Mutable: true, Confirmations: 1}))
+
+	// a newer sequence number supersedes the conflict
+	assert.False(t, res.merge(FullGetResult{Seq: 2, V: bencode.Bytes("d"), Mutable: true, Confirmations: 1}))
+	assert.False(t, res.ConflictDetected)
+	assert.Equal(t, int64(2), res.Seq)
+	assert.Equal(t, 1, res.Confirmations)
+}
+
+func TestGetLatest(t *testing.T) {
+	ctx := context.Background()
+	first := newTestServer(t)
+	for i := 0; i < 3; i++ {
+		newTestServer(t, dht.NewAddr(first.Addr()))
+	}
+
+	put := newTestPut(t, "hello")
+	_, err := Put(ctx, put.Target(), first, nil, func(int64) bep44.Put { return put }, TraversalConfig{})
+	require.NoError(t, err)
+
+	t.Run("counts every node reporting the latest record", func(t *testing.T) {
+		res, _, err := GetLatest(ctx, put.Target(), first, nil, nil, TraversalConfig{LatestWindow: time.Second})
+		require.NoError(t, err)
+		assert.Equal(t, put.Seq, res.Seq)
+		assert.Greater(t, res.Reports, 1)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		missing := newTestPut(t, "missing")
+		_, _, err := GetLatest(ctx, missing.Target(), first, nil, nil, TraversalConfig{})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestQueryTimeouts(t *testing.T) {
+	var target krpc.ID
+	node := func(b byte, port int) krpc.NodeInfo {
+		var id krpc.ID
+		id[0] = b
+		return krpc.NodeInfo{ID: id, Addr: krpc.NodeAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}}
+	}
+	nearest, near, far := node(0x01, 1), node(0x02, 2), node(0x80, 3)
+	unknown := krpc.NodeAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4}
+
+	cfg := TraversalConfig{K: 2, QueryTimeout: time.Second, InitialQueryTimeout: 50 * time.Millisecond}
+	timeouts := newQueryTimeouts(target, cfg)
+	timeouts.learn(far, near, nearest)
+
+	t.Run("only the k closest nodes are close", func(t *testing.T) {
+		assert.True(t, timeouts.isClose(nearest.Addr))
+		assert.True(t, timeouts.isClose(near.Addr))
+		assert.False(t, timeouts.isClose(far.Addr))
+		assert.False(t, timeouts.isClose(unknown))
+	})
+
+	// unresponsive answers only once it has been waited on for longer than the initial timeout
+	unresponsive := func(ctx context.Context) dht.QueryResult {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			return dht.QueryResult{Reply: krpc.Msg{R: &krpc.Return{}}}
+		case <-ctx.Done():
+			return dht.QueryResult{Err: ctx.Err()}
+		}
+	}
+
+	t.Run("close nodes are escalated", func(t *testing.T) {
+		res := timeouts.query(context.Background(), near.Addr, unresponsive)
+		assert.NoError(t, res.ToError())
+	})
+
+	t.Run("distant nodes are not escalated", func(t *testing.T) {
+		start := time.Now()
+		res := timeouts.query(context.Background(), far.Addr, unresponsive)
+		assert.ErrorIs(t, res.ToError(), context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("disabled gives every node the full timeout", func(t *testing.T) {
+		cfg := cfg
+		cfg.InitialQueryTimeout = 0
+		res := newQueryTimeouts(target, cfg).query(context.Background(), far.Addr, unresponsive)
+		assert.NoError(t, res.ToError())
+	})
+}
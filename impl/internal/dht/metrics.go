@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/dht/v2/traversal"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+var (
+	conflictsOnce sync.Once
+	conflicts     metric.Int64Counter
+
+	traversalsOnce sync.Once
+	traversals     metric.Int64Histogram
+)
+
+const (
+	traversalGet       = "get"
+	traversalGetStream = "get_stream"
+	traversalGetLatest = "get_latest"
+	traversalPut       = "put"
+)
+
+// recordConflict counts and logs a get that saw different values published under the same sequence number. Only
+// the holder of the private key can sign a mutable item, so a fork like this means the key was used by more than one
+// party, which is a sign the record's key may have been compromised.
+func recordConflict(ctx context.Context, target bep44.Target, seq int64) {
+	conflictsOnce.Do(func() {
+		var err error
+		conflicts, err = telemetry.GetMeter().Int64Counter("dht.get.seq_conflicts",
+			metric.WithDescription("gets that saw different values for the same sequence number"))
+		if err != nil {
+			logrus.WithError(err).Error("failed to create seq conflict counter")
+			conflicts = noop.Int64Counter{}
+		}
+	})
+	conflicts.Add(ctx, 1)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"target": hex.EncodeToString(target[:]),
+		"seq":    seq,
+	}).Warn("dht returned conflicting values for the same sequence number")
+}
+
+// recordTraversal records how many nodes a traversal of the given operation contacted
+func recordTraversal(ctx context.Context, operation string, stats *traversal.Stats) {
+	if stats == nil {
+		return
+	}
+	traversalsOnce.Do(func() {
+		var err error
+		traversals, err = telemetry.GetMeter().Int64Histogram("dht.traversal.nodes_contacted",
+			metric.WithDescription("nodes contacted per dht traversal"),
+			metric.WithExplicitBucketBoundaries(8, 16, 32, 64, 128, 256, 512, 1024))
+		if err != nil {
+			logrus.WithError(err).Error("failed to create traversal histogram")
+			traversals = noop.Int64Histogram{}
+		}
+	})
+	traversals.Record(ctx, int64(stats.NumAddrsTried), metric.WithAttributes(attribute.String("operation", operation)))
+}
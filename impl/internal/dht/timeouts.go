@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/int160"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/dht/v2/types"
+	"github.com/pkg/errors"
+)
+
+// queryTimeouts escalates node query timeouts during a traversal. Every node is first given the short initial
+// timeout, and only nodes among the K closest to the target known so far get a second attempt with the rest of the
+// full timeout. Distant nodes are plentiful and interchangeable, so waiting on them mostly adds tail latency, while
+// the closest nodes are the ones likely to hold the value.
+type queryTimeouts struct {
+	cfg    TraversalConfig
+	target int160.T
+
+	mu sync.Mutex
+	// ids maps the address of every node learned during the traversal to its node ID
+	ids map[string]int160.T
+	// closest holds the distances to the target of the K closest known nodes, ascending
+	closest []int160.T
+}
+
+func newQueryTimeouts(target krpc.ID, cfg TraversalConfig) *queryTimeouts {
+	return &queryTimeouts{
+		cfg:    cfg,
+		target: target.Int160(),
+		ids:    make(map[string]int160.T),
+	}
+}
+
+// adaptive returns whether queries start with a shorter timeout than the full query timeout
+func (q *queryTimeouts) adaptive() bool {
+	return q.cfg.InitialQueryTimeout > 0 && q.cfg.InitialQueryTimeout < q.cfg.QueryTimeout
+}
+
+// learnStarting records the IDs of the traversal's starting nodes, where known
+func (q *queryTimeouts) learnStarting(nodes []types.AddrMaybeId) {
+	for _, n := range nodes {
+		if n.Id.Ok {
+			q.learnID(n.Addr.ToNodeAddr().String(), n.Id.Value)
+		}
+	}
+}
+
+// learn records the IDs of nodes returned by a query
+func (q *queryTimeouts) learn(nodes ...krpc.NodeInfo) {
+	for _, n := range nodes {
+		q.learnID(n.Addr.String(), n.ID.Int160())
+	}
+}
+
+func (q *queryTimeouts) learnID(addr string, id int160.T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.ids[addr]; ok {
+		return
+	}
+	q.ids[addr] = id
+
+	d := id.Distance(q.target)
+	i := sort.Search(len(q.closest), func(i int) bool { return d.Cmp(q.closest[i]) < 0 })
+	if i >= q.cfg.K {
+		return
+	}
+	q.closest = append(q.closest, int160.T{})
+	copy(q.closest[i+1:], q.closest[i:])
+	q.closest[i] = d
+	if len(q.closest) > q.cfg.K {
+		q.closest = q.closest[:q.cfg.K]
+	}
+}
+
+// isClose returns whether the node at addr is one of the K closest to the target known so far. Nodes whose ID
+// hasn't been learned are treated as distant.
+func (q *queryTimeouts) isClose(addr krpc.NodeAddr) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id, ok := q.ids[addr.String()]
+	if !ok {
+		return false
+	}
+	if len(q.closest) < q.cfg.K {
+		return true
+	}
+	return id.Distance(q.target).Cmp(q.closest[len(q.closest)-1]) <= 0
+}
+
+// query runs the given node query with the initial timeout, escalating to the rest of the full timeout if the node
+// didn't answer in time and is one of the closest known nodes
+func (q *queryTimeouts) query(ctx context.Context, addr krpc.NodeAddr, do func(context.Context) dht.QueryResult) dht.QueryResult {
+	if !q.adaptive() {
+		ctx, cancel := context.WithTimeout(ctx, q.cfg.QueryTimeout)
+		defer cancel()
+		return do(ctx)
+	}
+
+	initialCtx, cancel := context.WithTimeout(ctx, q.cfg.InitialQueryTimeout)
+	res := do(initialCtx)
+	cancel()
+	if !timedOut(res.ToError()) || ctx.Err() != nil || !q.isClose(addr) {
+		return res
+	}
+	escalatedCtx, cancel := context.WithTimeout(ctx, q.cfg.QueryTimeout-q.cfg.InitialQueryTimeout)
+	defer cancel()
+	return do(escalatedCtx)
+}
+
+// timedOut returns whether a query failed because the node didn't answer in time
+func timedOut(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, dht.TransactionTimeout)
+}
@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/dht/v2/traversal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// Attributes recorded on get and put spans
+const (
+	attrTarget      = attribute.Key("dht.target")
+	attrNode        = attribute.Key("dht.node")
+	attrNodesTried  = attribute.Key("dht.nodes_tried")
+	attrResponses   = attribute.Key("dht.responses")
+	attrSeq         = attribute.Key("dht.seq")
+	attrResults     = attribute.Key("dht.results")
+	attrStallReason = attribute.Key("dht.stall_reason")
+	attrAttempts    = attribute.Key("dht.attempts")
+	attrResponded   = attribute.Key("dht.responded")
+	attrAcked       = attribute.Key("dht.acked")
+	attrPutFailed   = attribute.Key("dht.put_failed")
+	attrConflict    = attribute.Key("dht.conflict")
+)
+
+// Reasons a traversal finished, recorded as attrStallReason
+const (
+	// stallReasonExhausted means the traversal ran out of nodes to query
+	stallReasonExhausted = "exhausted"
+	// stallReasonTimeout means the traversal hit its configured stall timeout
+	stallReasonTimeout = "stall_timeout"
+	// stallReasonWindow means the GetLatest window closed after the first result
+	stallReasonWindow = "window_closed"
+	// stallReasonImmutable means an immutable value was found, which ends the traversal
+	stallReasonImmutable = "immutable_found"
+	// stallReasonContext means the caller's context was canceled or timed out
+	stallReasonContext = "context_done"
+)
+
+// startSpan starts a span for a get or put against the given target
+func startSpan(ctx context.Context, name string, target bep44.Target) (context.Context, trace.Span) {
+	return telemetry.GetTracer().Start(ctx, name, trace.WithAttributes(attrTarget.String(hex.EncodeToString(target[:]))))
+}
+
+// endSpan records the outcome of a traversal on the span and ends it
+func endSpan(span trace.Span, stats *traversal.Stats, reason string, err error, attrs ...attribute.KeyValue) {
+	if stats != nil {
+		attrs = append(attrs, attrNodesTried.Int64(int64(stats.NumAddrsTried)), attrResponses.Int64(int64(stats.NumResponses)))
+	}
+	if reason != "" {
+		attrs = append(attrs, attrStallReason.String(reason))
+	}
+	span.SetAttributes(attrs...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startQuerySpan starts a span for a single node query as a child of the traversal's span. Queries run on the
+// traversal's own context, so the parent is passed explicitly. Nothing is recorded when the parent isn't sampled,
+// since a traversal can make hundreds of queries.
+func startQuerySpan(ctx context.Context, parent trace.Span, name string, addr fmt.Stringer) trace.Span {
+	if !parent.IsRecording() {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := telemetry.GetTracer().Start(trace.ContextWithSpan(ctx, parent), name,
+		trace.WithAttributes(attrNode.String(addr.String())))
+	return span
+}
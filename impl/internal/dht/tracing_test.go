@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	s := newTestServer(t)
+	put := newTestPut(t, "hello tracing")
+	_, err := Put(context.Background(), put.Target(), s, nil, func(int64) bep44.Put { return put }, TraversalConfig{})
+	require.NoError(t, err)
+	_, _, err = Get(context.Background(), put.Target(), s, nil, nil, TraversalConfig{})
+	require.NoError(t, err)
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	attrs := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	require.Len(t, spans["getput.Put"], 1)
+	putAttrs := attrs(spans["getput.Put"][0])
+	assert.Equal(t, stallReasonExhausted, putAttrs[attrStallReason].AsString())
+	assert.Positive(t, putAttrs[attrAcked].AsInt64())
+	assert.Equal(t, put.Seq, putAttrs[attrSeq].AsInt64())
+	require.NotEmpty(t, spans["getput.PutQuery"])
+
+	require.Len(t, spans["getput.Get"], 1)
+	getAttrs := attrs(spans["getput.Get"][0])
+	assert.Equal(t, int64(1), getAttrs[attrAttempts].AsInt64())
+	assert.Equal(t, put.Seq, getAttrs[attrSeq].AsInt64())
+
+	require.Len(t, spans["getput.GetTraversal"], 1)
+	traversal := spans["getput.GetTraversal"][0]
+	assert.Equal(t, spans["getput.Get"][0].SpanContext().SpanID(), traversal.Parent().SpanID())
+	assert.Positive(t, attrs(traversal)[attrNodesTried].AsInt64())
+
+	// queries are children of the traversal that made them
+	var children int
+	for _, query := range spans["getput.GetQuery"] {
+		if query.Parent().SpanID() == traversal.SpanContext().SpanID() {
+			children++
+		}
+	}
+	assert.Positive(t, children)
+}
@@ -2,6 +2,7 @@ package did
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/binary"
 	"io"
 	"net/http"
@@ -61,7 +62,8 @@ func (c *GatewayClient) GetDIDDocument(id string) (*DIDDHTDocument, error) {
 	return d.FromDNSPacket(msg)
 }
 
-// PutDocument puts a bep44.Put message to a did:dht Gateway
+// PutDocument puts a bep44.Put message to a did:dht Gateway. A salted put is addressed by the suffix followed by
+// '.' and the base64url encoded salt.
 func (c *GatewayClient) PutDocument(id string, put bep44.Put) error {
 	d := DHT(id)
 	if !d.IsValid() {
@@ -71,6 +73,9 @@ func (c *GatewayClient) PutDocument(id string, put bep44.Put) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to get suffix")
 	}
+	if len(put.Salt) > 0 {
+		suffix += "." + base64.RawURLEncoding.EncodeToString(put.Salt)
+	}
 
 	// prepare request as sig:seq:v
 	var seqBuf [8]byte
@@ -0,0 +1,134 @@
+package did
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"testing"
+
+	"github.com/TBD54566975/ssi-sdk/crypto/jwx"
+	"github.com/TBD54566975/ssi-sdk/did"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceVector is one of the spec's test vectors https://did-dht.com/#test-vectors, loaded from the files
+// testdata holds for it:
+//
+//	vector-N-public-key-jwk-1.json  the identity key
+//	vector-N-did-document.json      the DID document
+//	vector-N-dns-records.json       the DNS records the document is encoded as
+//	vector-N-dns-options.json       the types, gateways, and previous DID encoded with it, if any
+type conformanceVector struct {
+	Name        string
+	IdentityKey jwx.PublicKeyJWK
+	Document    did.Document
+	Records     []testVectorDNSRecord
+	Options     struct {
+		Types       []TypeIndex            `json:"types,omitempty"`
+		Gateways    []AuthoritativeGateway `json:"gateways,omitempty"`
+		PreviousDID *PreviousDID           `json:"previousDid,omitempty"`
+	}
+}
+
+var conformanceVectorDocument = regexp.MustCompile(`^(vector-\d+)-did-document\.json$`)
+
+// loadConformanceVectors loads every test vector in testdata, so a vector added from the spec is run without
+// changing any code
+func loadConformanceVectors(t *testing.T) []conformanceVector {
+	t.Helper()
+	files, err := testData.ReadDir("testdata")
+	require.NoError(t, err)
+
+	var vectors []conformanceVector
+	for _, file := range files {
+		match := conformanceVectorDocument.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+		vector := conformanceVector{Name: match[1]}
+		retrieveTestVectorAs(t, vector.Name+"-public-key-jwk-1.json", &vector.IdentityKey)
+		retrieveTestVectorAs(t, vector.Name+"-did-document.json", &vector.Document)
+		retrieveTestVectorAs(t, vector.Name+"-dns-records.json", &vector.Records)
+		if _, err = fs.Stat(testData, "testdata/"+vector.Name+"-dns-options.json"); err == nil {
+			retrieveTestVectorAs(t, vector.Name+"-dns-options.json", &vector.Options)
+		}
+		vectors = append(vectors, vector)
+	}
+	require.NotEmpty(t, vectors, "no test vectors in testdata")
+	return vectors
+}
+
+// packet returns the vector's DNS records as a DNS packet
+func (v conformanceVector) packet(t *testing.T) *dns.Msg {
+	t.Helper()
+	msg := &dns.Msg{MsgHdr: dns.MsgHdr{Response: true, Authoritative: true}}
+	for _, record := range v.Records {
+		hdr := dns.RR_Header{Name: record.Name, Class: dns.ClassINET, Ttl: uint32(record.TTL)}
+		switch record.RecordType {
+		case "TXT":
+			hdr.Rrtype = dns.TypeTXT
+			msg.Answer = append(msg.Answer, &dns.TXT{Hdr: hdr, Txt: record.Record})
+		case "NS":
+			require.Len(t, record.Record, 1)
+			hdr.Rrtype = dns.TypeNS
+			msg.Answer = append(msg.Answer, &dns.NS{Hdr: hdr, Ns: record.Record[0]})
+		default:
+			require.Fail(t, fmt.Sprintf("unsupported record type %s in %s", record.RecordType, v.Name))
+		}
+	}
+	return msg
+}
+
+// TestConformance runs the spec's test vectors against the identifier codec, the DNS encoder, and the DNS decoder
+func TestConformance(t *testing.T) {
+	for _, vector := range loadConformanceVectors(t) {
+		t.Run(vector.Name, func(t *testing.T) {
+			pubKey, err := vector.IdentityKey.ToPublicKey()
+			require.NoError(t, err)
+			identityKey, ok := pubKey.(ed25519.PublicKey)
+			require.True(t, ok, "identity key is not an Ed25519 key")
+			didID := DHT(vector.Document.ID)
+
+			t.Run("identifier", func(t *testing.T) {
+				assert.Equal(t, vector.Document.ID, GetDIDDHTIdentifier(identityKey))
+				decoded, err := didID.IdentityKey()
+				require.NoError(t, err)
+				assert.Equal(t, identityKey, decoded)
+			})
+
+			t.Run("encode", func(t *testing.T) {
+				packet, err := didID.ToDNSPacket(vector.Document, vector.Options.Types, vector.Options.Gateways, vector.Options.PreviousDID)
+				require.NoError(t, err)
+				assert.ElementsMatch(t, recordStrings(vector.packet(t)), recordStrings(packet))
+			})
+
+			t.Run("decode", func(t *testing.T) {
+				decoded, err := didID.FromDNSPacket(vector.packet(t))
+				require.NoError(t, err)
+
+				expectedDocJSON, err := json.Marshal(vector.Document)
+				require.NoError(t, err)
+				decodedDocJSON, err := json.Marshal(decoded.Doc)
+				require.NoError(t, err)
+				assert.JSONEq(t, string(expectedDocJSON), string(decodedDocJSON))
+				assert.Equal(t, vector.Options.Types, decoded.Types)
+				assert.Equal(t, vector.Options.Gateways, decoded.Gateways)
+				assert.Equal(t, vector.Options.PreviousDID, decoded.PreviousDID)
+			})
+		})
+	}
+}
+
+// recordStrings returns a packet's records in presentation format, to compare packets whatever order their records
+// are in
+func recordStrings(msg *dns.Msg) []string {
+	records := make([]string, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		records = append(records, rr.String())
+	}
+	return records
+}
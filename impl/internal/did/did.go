@@ -1,16 +1,21 @@
 package did
 
 import (
+	"crypto/ecdsa"
 	"crypto/ed25519"
 	"encoding/base64"
 	"fmt"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/TBD54566975/ssi-sdk/crypto"
 	"github.com/TBD54566975/ssi-sdk/crypto/jwx"
 	"github.com/TBD54566975/ssi-sdk/cryptosuite"
 	"github.com/TBD54566975/ssi-sdk/did"
+	"github.com/goccy/go-json"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
@@ -46,6 +51,12 @@ const (
 	FinancialInstitution   TypeIndex = 7
 )
 
+// IsRegistered reports whether the type is in the spec's indexed types registry
+// https://did-dht.com/registry/#indexed-types
+func (t TypeIndex) IsRegistered() bool {
+	return t >= Discoverable && t <= FinancialInstitution
+}
+
 func (d DHT) IsValid() bool {
 	suffix, err := d.Suffix()
 	if err != nil {
@@ -125,6 +136,11 @@ func CreateDIDDHTDID(pubKey ed25519.PublicKey, opts CreateDIDDHTOpts) (*did.Docu
 
 	var controller any
 	if len(opts.Controller) != 0 {
+		for _, c := range opts.Controller {
+			if !IsDID(c) {
+				return nil, fmt.Errorf("controller %s is not a DID", c)
+			}
+		}
 		if len(opts.Controller) == 1 {
 			// if there's only one controller, set it to the first controller
 			controller = opts.Controller[0]
@@ -133,15 +149,15 @@ func CreateDIDDHTDID(pubKey ed25519.PublicKey, opts CreateDIDDHTOpts) (*did.Docu
 			controller = opts.Controller
 		}
 	}
+	// alsoKnownAs is always a set of URIs https://www.w3.org/TR/did-core/#also-known-as
 	var aka any
 	if len(opts.AlsoKnownAs) != 0 {
-		if len(opts.AlsoKnownAs) == 1 {
-			// if there's only one aka, set it to the first aka
-			aka = opts.AlsoKnownAs[0]
-		} else {
-			// if there's more than one aka, set it to the list of akas
-			aka = opts.AlsoKnownAs
+		for _, a := range opts.AlsoKnownAs {
+			if !IsAbsoluteURI(a) || strings.Contains(a, ",") {
+				return nil, fmt.Errorf("alsoKnownAs %s is not an absolute URI without commas", a)
+			}
 		}
+		aka = opts.AlsoKnownAs
 	}
 
 	var vms []did.VerificationMethod
@@ -159,11 +175,19 @@ func CreateDIDDHTDID(pubKey ed25519.PublicKey, opts CreateDIDDHTOpts) (*did.Docu
 			if seenIDs[vm.VerificationMethod.ID] {
 				return nil, fmt.Errorf("verification method id %s is not unique", vm.VerificationMethod.ID)
 			}
-			if vm.VerificationMethod.Type != cryptosuite.JSONWebKeyType {
+			if vm.VerificationMethod.Type != cryptosuite.JSONWebKeyType && vm.VerificationMethod.Type != cryptosuite.MultikeyType {
 				return nil, fmt.Errorf("verification method type %s is not supported", vm.VerificationMethod.Type)
 			}
-			if vm.VerificationMethod.PublicKeyJWK == nil {
-				return nil, fmt.Errorf("verification method public key jwk is required")
+			// keys given as multikeys are represented as JWKs, as they are once resolved
+			pubKeyJWK, err := verificationMethodJWK(vm.VerificationMethod)
+			if err != nil {
+				return nil, err
+			}
+			vm.VerificationMethod.Type = cryptosuite.JSONWebKeyType
+			vm.VerificationMethod.PublicKeyJWK = pubKeyJWK
+			vm.VerificationMethod.PublicKeyMultibase = ""
+			if pubKeyJWK.CRV == crypto.X25519.String() && slices.ContainsFunc(vm.Purposes, func(p did.PublicKeyPurpose) bool { return p != did.KeyAgreement }) {
+				return nil, fmt.Errorf("X25519 verification method %s can only be used for key agreement", vm.VerificationMethod.ID)
 			}
 
 			// mark as seen
@@ -263,16 +287,36 @@ func GetDIDDHTIdentifier(pubKey []byte) string {
 	return strings.Join([]string{Prefix, zbase32.EncodeToString(pubKey)}, ":")
 }
 
-// ToDNSPacket converts a DID DHT Document to a DNS packet with an optional list of types to include
+// ToDNSPacket converts a DID DHT Document to a DNS packet with an optional list of types to include. The packet is
+// canonical: documents differing only in how they write the same content, such as relative or absolute IDs, a
+// single value or a list of one, or the order of verification relationships, types, and gateways, pack to the same
+// bytes, so republishing an unchanged document needn't bump its sequence number. Verification methods, services,
+// controllers, and alsoKnownAs entries keep the order the document gives them, which resolution returns.
 func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []AuthoritativeGateway, previousDID *PreviousDID) (*dns.Msg, error) {
+	return d.toDNSPacket(doc, types, gateways, previousDID, false)
+}
+
+// ToCompactDNSPacket converts a DID DHT Document to a DNS packet like ToDNSPacket, as small as the spec allows, to
+// help larger documents fit BEP44's 1000 byte limit. Record names are compressed, so the _did. label they share is
+// written once. Any resolver decodes the packet to the same document.
+func (d DHT) ToCompactDNSPacket(doc did.Document, types []TypeIndex, gateways []AuthoritativeGateway, previousDID *PreviousDID) (*dns.Msg, error) {
+	return d.toDNSPacket(doc, types, gateways, previousDID, true)
+}
+
+func (d DHT) toDNSPacket(doc did.Document, types []TypeIndex, gateways []AuthoritativeGateway, previousDID *PreviousDID, compact bool) (*dns.Msg, error) {
 	var records []dns.RR
 	var rootRecord []string
-	keyLookup := make(map[string]string)
+	// the index of each verification method's key record, by its ID
+	keyLookup := make(map[string]int)
 
 	suffix, err := d.Suffix()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get suffix while decoding DNS packet")
 	}
+	identityKey, err := d.IdentityKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get identity key while encoding DNS packet")
+	}
 
 	// handle the previous DID if it's present
 	if previousDID != nil {
@@ -296,65 +340,63 @@ func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []Authori
 	rootRecord = append(rootRecord, fmt.Sprintf("v=%d", Version))
 
 	// build controller and aka records
-	if doc.Controller != nil {
-		var controllerTxt string
-		switch c := doc.Controller.(type) {
-		case string:
-			controllerTxt = c
-		case []string:
-			controllerTxt = strings.Join(c, ",")
-		}
-		controllerAnswer := dns.TXT{
-			Hdr: dns.RR_Header{
-				Name:   "_cnt._did.",
-				Rrtype: dns.TypeTXT,
-				Class:  dns.ClassINET,
-				Ttl:    7200,
-			},
-			Txt: chunkTextRecord(controllerTxt),
+	for _, property := range []struct {
+		name   string
+		record string
+		value  any
+	}{
+		{"controller", "_cnt._did.", doc.Controller},
+		{"alsoKnownAs", "_aka._did.", doc.AlsoKnownAs},
+	} {
+		values, err := propertyValues(property.name, property.value)
+		if err != nil {
+			return nil, err
 		}
-		records = append(records, &controllerAnswer)
-	}
-	if doc.AlsoKnownAs != nil {
-		var akaTxt string
-		switch a := doc.AlsoKnownAs.(type) {
-		case string:
-			akaTxt = a
-		case []string:
-			akaTxt = strings.Join(a, ",")
+		if len(values) == 0 {
+			continue
 		}
-		akaAnswer := dns.TXT{
+		records = append(records, &dns.TXT{
 			Hdr: dns.RR_Header{
-				Name:   "_aka._did.",
+				Name:   property.record,
 				Rrtype: dns.TypeTXT,
 				Class:  dns.ClassINET,
 				Ttl:    7200,
 			},
-			Txt: chunkTextRecord(akaTxt),
-		}
-		records = append(records, &akaAnswer)
+			Txt: chunkTextRecord(strings.Join(values, ",")),
+		})
 	}
 
-	// add all gateways
-	for _, gateway := range gateways {
-		gatewayAnswer := dns.TXT{
+	// add all gateways, which are a set
+	gateways = slices.Clone(gateways)
+	slices.Sort(gateways)
+	for _, gateway := range slices.Compact(gateways) {
+		if _, ok := dns.IsDomainName(string(gateway)); !ok || !dns.IsFqdn(string(gateway)) {
+			return nil, fmt.Errorf("gateway %s is not a fully qualified domain name", gateway)
+		}
+		records = append(records, &dns.NS{
 			Hdr: dns.RR_Header{
 				Name:   fmt.Sprintf("_did.%s.", suffix),
 				Rrtype: dns.TypeNS,
 				Class:  dns.ClassINET,
 				Ttl:    7200,
 			},
-			Txt: chunkTextRecord(string(gateway)),
-		}
-		records = append(records, &gatewayAnswer)
+			Ns: string(gateway),
+		})
 	}
 
 	// build all key records
 	var vmIDs []string
 	for i, vm := range doc.VerificationMethod {
 		recordIdentifier := fmt.Sprintf("k%d", i)
-		keyLookup[vm.ID] = recordIdentifier
+		unqualifiedVMID, ok := fragment(doc.ID, vm.ID)
+		if !ok {
+			return nil, fmt.Errorf("verification method id %s is not an id of the document a record can hold", vm.ID)
+		}
+		keyLookup[unqualifiedVMID] = i
 
+		if vm.PublicKeyJWK, err = verificationMethodJWK(vm); err != nil {
+			return nil, err
+		}
 		keyType := keyTypeForJWK(*vm.PublicKeyJWK)
 		if keyType < 0 {
 			return nil, fmt.Errorf("unsupported key type given alg: %s", vm.PublicKeyJWK.ALG)
@@ -365,6 +407,10 @@ func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []Authori
 		if err != nil {
 			return nil, err
 		}
+		//nolint:staticcheck
+		if ecKey, ok := pubKey.(ecdsa.PublicKey); ok && !ecKey.Curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return nil, fmt.Errorf("public key of verification method %s is not a point on its curve", vm.ID)
+		}
 
 		// as per the spec's guidance DNS representations use compressed keys, so we must marshal them as such
 		pubKeyBytes, err := crypto.PubKeyToBytes(pubKey, crypto.ECDSAMarshalCompressed)
@@ -380,15 +426,23 @@ func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []Authori
 			return nil, fmt.Errorf("failed to calculate JWK thumbprint: %v", err)
 		}
 
-		// only include the id if it's not the JWK thumbprint
-		unqualifiedVMID := strings.TrimPrefix(vm.ID, doc.ID+"#")
-		if unqualifiedVMID != thumbprint {
+		// resolvers give the identity key id 0, so no other verification method can hold it
+		isIdentityKey := identityKey.Equal(pubKey)
+		if isIdentityKey != (unqualifiedVMID == "0") {
+			return nil, fmt.Errorf("verification method id 0 must be the identity key, instead it is %s", vm.ID)
+		}
+
+		// only include the id if it's not the JWK thumbprint or the identity key's, which resolvers infer from the key
+		if unqualifiedVMID != thumbprint && !isIdentityKey {
 			txtRecord += fmt.Sprintf("id=%s;", unqualifiedVMID)
 		}
 		txtRecord += fmt.Sprintf("t=%d;k=%s", keyType, base64.RawURLEncoding.EncodeToString(pubKeyBytes))
 
 		// only include the alg if it's not the default alg for the key type
 		forKeyType := algIsDefaultForJWK(*vm.PublicKeyJWK)
+		if strings.Contains(vm.PublicKeyJWK.ALG+vm.Controller, ";") {
+			return nil, fmt.Errorf("alg and controller of verification method %s cannot contain ';'", vm.ID)
+		}
 		if !forKeyType {
 			txtRecord += fmt.Sprintf(";a=%s", vm.PublicKeyJWK.ALG)
 		}
@@ -425,17 +479,36 @@ func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []Authori
 	var svcIDs []string
 	for i, service := range doc.Services {
 		recordIdentifier := fmt.Sprintf("s%d", i)
-		sID := service.ID
-		if strings.Contains(sID, "#") {
-			sID = sID[strings.LastIndex(service.ID, "#")+1:]
+		sID, ok := fragment(doc.ID, service.ID)
+		if !ok {
+			return nil, fmt.Errorf("service id %s is not an id of the document a record can hold", service.ID)
+		}
+		if strings.Contains(service.Type, ";") {
+			return nil, fmt.Errorf("type of service %s cannot contain ';'", sID)
 		}
 
-		svcTxt := fmt.Sprintf("id=%s;t=%s;se=%s", sID, service.Type, parseServiceData(service.ServiceEndpoint))
-		if service.Sig != nil {
-			svcTxt += fmt.Sprintf(";sig=%s", parseServiceData(service.Sig))
+		endpointKey, endpoint, err := serviceEndpointData(service.ServiceEndpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode endpoint of service %s", sID)
 		}
-		if service.Enc != nil {
-			svcTxt += fmt.Sprintf(";enc=%s", parseServiceData(service.Enc))
+		svcTxt := fmt.Sprintf("id=%s;t=%s;%s=%s", sID, service.Type, endpointKey, endpoint)
+		for _, property := range []struct {
+			name  string
+			value any
+		}{
+			{"sig", service.Sig},
+			{"enc", service.Enc},
+		} {
+			values, err := propertyValues(property.name, property.value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to encode service %s", sID)
+			}
+			if slices.ContainsFunc(values, func(v string) bool { return strings.Contains(v, ";") }) {
+				return nil, fmt.Errorf("%s of service %s cannot contain ';'", property.name, sID)
+			}
+			if len(values) != 0 {
+				svcTxt += fmt.Sprintf(";%s=%s", property.name, strings.Join(values, ","))
+			}
 		}
 		serviceRecord := dns.TXT{
 			Hdr: dns.RR_Header{
@@ -452,44 +525,39 @@ func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []Authori
 	}
 
 	// add verification relationships to the root record
-	var authIDs []string
-	for _, auth := range doc.Authentication {
-		authIDs = append(authIDs, keyLookup[auth.(string)])
-	}
-	if len(authIDs) != 0 {
-		rootRecord = append(rootRecord, fmt.Sprintf("auth=%s", strings.Join(authIDs, ",")))
-	}
-
-	var assertionIDs []string
-	for _, assertion := range doc.AssertionMethod {
-		assertionIDs = append(assertionIDs, keyLookup[assertion.(string)])
-	}
-	if len(assertionIDs) != 0 {
-		rootRecord = append(rootRecord, fmt.Sprintf("asm=%s", strings.Join(assertionIDs, ",")))
-	}
-
-	var keyAgreementIDs []string
-	for _, keyAgreement := range doc.KeyAgreement {
-		keyAgreementIDs = append(keyAgreementIDs, keyLookup[keyAgreement.(string)])
-	}
-	if len(keyAgreementIDs) != 0 {
-		rootRecord = append(rootRecord, fmt.Sprintf("agm=%s", strings.Join(keyAgreementIDs, ",")))
-	}
-
-	var capabilityInvocationIDs []string
-	for _, capabilityInvocation := range doc.CapabilityInvocation {
-		capabilityInvocationIDs = append(capabilityInvocationIDs, keyLookup[capabilityInvocation.(string)])
-	}
-	if len(capabilityInvocationIDs) != 0 {
-		rootRecord = append(rootRecord, fmt.Sprintf("inv=%s", strings.Join(capabilityInvocationIDs, ",")))
-	}
-
-	var capabilityDelegationIDs []string
-	for _, capabilityDelegation := range doc.CapabilityDelegation {
-		capabilityDelegationIDs = append(capabilityDelegationIDs, keyLookup[capabilityDelegation.(string)])
-	}
-	if len(capabilityDelegationIDs) != 0 {
-		rootRecord = append(rootRecord, fmt.Sprintf("del=%s", strings.Join(capabilityDelegationIDs, ",")))
+	for _, relationship := range []struct {
+		name string
+		key  string
+		refs []did.VerificationMethodSet
+	}{
+		{"authentication", "auth", doc.Authentication},
+		{"assertionMethod", "asm", doc.AssertionMethod},
+		{"keyAgreement", "agm", doc.KeyAgreement},
+		{"capabilityInvocation", "inv", doc.CapabilityInvocation},
+		{"capabilityDelegation", "del", doc.CapabilityDelegation},
+	} {
+		// relationships are sets, written in the order of the verification methods
+		var keyIndexes []int
+		for _, ref := range relationship.refs {
+			id, ok := ref.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must reference verification methods by id", relationship.name)
+			}
+			unqualifiedID, _ := fragment(doc.ID, id)
+			keyIndex, ok := keyLookup[unqualifiedID]
+			if !ok {
+				return nil, fmt.Errorf("%s references no verification method: %s", relationship.name, id)
+			}
+			keyIndexes = append(keyIndexes, keyIndex)
+		}
+		slices.Sort(keyIndexes)
+		var recordIDs []string
+		for _, keyIndex := range slices.Compact(keyIndexes) {
+			recordIDs = append(recordIDs, fmt.Sprintf("k%d", keyIndex))
+		}
+		if len(recordIDs) != 0 {
+			rootRecord = append(rootRecord, fmt.Sprintf("%s=%s", relationship.key, strings.Join(recordIDs, ",")))
+		}
 	}
 
 	// add services to the root record
@@ -505,14 +573,22 @@ func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []Authori
 			Class:  dns.ClassINET,
 			Ttl:    7200,
 		},
-		Txt: []string{strings.Join(rootRecord, ";")},
+		Txt: chunkTextRecord(strings.Join(rootRecord, ";")),
 	}
 	records = append(records, &rootAnswer)
 
-	// add types record
+	// add types record, which is a set
 	if len(types) != 0 {
+		types = slices.Clone(types)
+		slices.Sort(types)
 		var typesStr []string
 		for _, t := range types {
+			if !t.IsRegistered() {
+				return nil, fmt.Errorf("type %d is not registered", t)
+			}
+			if slices.Contains(types[:len(typesStr)], t) {
+				return nil, fmt.Errorf("duplicate type %d", t)
+			}
 			typesStr = append(typesStr, strconv.Itoa(int(t)))
 		}
 		typesAnswer := dns.TXT{
@@ -534,32 +610,96 @@ func (d DHT) ToDNSPacket(doc did.Document, types []TypeIndex, gateways []Authori
 			Response:      true,
 			Authoritative: true,
 		},
-		Answer: records,
+		Compress: compact,
+		Answer:   records,
 	}, nil
 }
 
-// make a best-effort to parse a service endpoints and other service data which we expect as either a single string
-// value or an array of strings
-func parseServiceData(serviceEndpoint any) string {
-	switch se := serviceEndpoint.(type) {
+// DeactivationDNSPacket returns the DNS packet that deactivates a DID: a packet with no records. Publishing it
+// under the DID's identity key with a higher sequence number than the current document deactivates the DID.
+func DeactivationDNSPacket() *dns.Msg {
+	return &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Id:            0,
+			Response:      true,
+			Authoritative: true,
+		},
+	}
+}
+
+// propertyValues returns a document property that's a string or a set of strings, such as controller or
+// alsoKnownAs, as the values of its comma separated TXT record
+func propertyValues(name string, value any) ([]string, error) {
+	var values []string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
 	case string:
-		return se
+		values = []string{v}
 	case []string:
-		if len(se) == 1 {
-			return se[0]
-		}
-		return strings.Join(se, ",")
+		values = v
 	case []any:
-		if len(se) == 1 {
-			return fmt.Sprintf("%v", se[0])
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a string or a list of strings", name)
+			}
+			values = append(values, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a string or a list of strings", name)
+	}
+	for _, v := range values {
+		if v == "" || strings.Contains(v, ",") {
+			return nil, fmt.Errorf("%s value %q cannot be empty or contain commas", name, v)
 		}
-		var result []string
+	}
+	return values, nil
+}
+
+// serviceEndpointData returns the property of a service record holding the service's endpoint, and its value: se
+// for a URI or a list of URIs, joined by commas, and sej for any other endpoint, such as a map or a list holding
+// maps, as the unpadded base64url encoding of its JSON. URIs holding a ',' or ';' are encoded as JSON too, as they
+// would be split apart.
+func serviceEndpointData(serviceEndpoint any) (string, string, error) {
+	var uris []string
+	switch se := serviceEndpoint.(type) {
+	case string:
+		uris = []string{se}
+	case []string:
+		uris = se
+	case []any:
 		for _, v := range se {
-			result = append(result, fmt.Sprintf("%v", v))
+			if uri, ok := v.(string); ok {
+				uris = append(uris, uri)
+			}
+		}
+		if len(uris) != len(se) {
+			uris = nil
 		}
-		return strings.Join(result, ",")
 	}
-	return ""
+	if len(uris) > 0 && !slices.ContainsFunc(uris, func(uri string) bool { return strings.ContainsAny(uri, ",;") }) {
+		return "se", strings.Join(uris, ","), nil
+	}
+
+	endpointJSON, err := json.Marshal(serviceEndpoint)
+	if err != nil {
+		return "", "", err
+	}
+	return "sej", base64.RawURLEncoding.EncodeToString(endpointJSON), nil
+}
+
+// decodeServiceEndpoint decodes the value of a service record's sej property: a map, a list, or a URI
+func decodeServiceEndpoint(encoded string) (any, error) {
+	endpointJSON, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "endpoint is not base64url encoded")
+	}
+	var serviceEndpoint any
+	if err = json.Unmarshal(endpointJSON, &serviceEndpoint); err != nil {
+		return nil, errors.Wrap(err, "endpoint is not json")
+	}
+	return serviceEndpoint, nil
 }
 
 // DIDDHTDocument is a DID DHT Document along with additional metadata the DID supports
@@ -568,6 +708,9 @@ type DIDDHTDocument struct {
 	Types       []TypeIndex            `json:"types,omitempty"`
 	Gateways    []AuthoritativeGateway `json:"gateways,omitempty"`
 	PreviousDID *PreviousDID           `json:"previousDid,omitempty"`
+	// Deactivated is the DID document metadata property set when the DID has been deactivated, in which case the
+	// document holds only its ID
+	Deactivated bool `json:"deactivated,omitempty"`
 }
 
 // FromDNSPacket converts a DNS packet to a DID DHT Document
@@ -583,6 +726,10 @@ func (d DHT) FromDNSPacket(msg *dns.Msg) (*DIDDHTDocument, error) {
 		return nil, errors.Wrap(err, "failed to get identity key while decoding DNS packet")
 	}
 
+	if len(msg.Question) == 0 && len(msg.Answer) == 0 && len(msg.Ns) == 0 && len(msg.Extra) == 0 {
+		return &DIDDHTDocument{Doc: doc, Deactivated: true}, nil
+	}
+
 	suffix, err := d.Suffix()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get suffix while decoding DNS packet")
@@ -595,6 +742,9 @@ func (d DHT) FromDNSPacket(msg *dns.Msg) (*DIDDHTDocument, error) {
 	// track the previous DID
 	var previousDID *PreviousDID
 	keyLookup := make(map[string]string)
+	// the root record, read once the key records it references are
+	var rootRecord string
+	var seenRoot bool
 	for _, rr := range msg.Answer {
 		switch record := rr.(type) {
 		case *dns.TXT:
@@ -686,11 +836,16 @@ func (d DHT) FromDNSPacket(msg *dns.Msg) (*DIDDHTDocument, error) {
 				data := parseTxtData(unchunkedTextRecord)
 				sID := data["id"]
 				serviceType := data["t"]
-				serviceEndpoint := data["se"]
 				service := did.Service{
-					ID:              didID + "#" + sID,
-					Type:            serviceType,
-					ServiceEndpoint: strings.Split(serviceEndpoint, ","),
+					ID:   didID + "#" + sID,
+					Type: serviceType,
+				}
+				if encoded, ok := data["sej"]; ok {
+					if service.ServiceEndpoint, err = decodeServiceEndpoint(encoded); err != nil {
+						return nil, errors.Wrapf(err, "invalid endpoint of service %s", sID)
+					}
+				} else {
+					service.ServiceEndpoint = strings.Split(data["se"], ",")
 				}
 				if data["sig"] != "" {
 					if strings.Contains(data["sig"], ",") {
@@ -712,6 +867,9 @@ func (d DHT) FromDNSPacket(msg *dns.Msg) (*DIDDHTDocument, error) {
 				if record.Txt[0] == "" {
 					return nil, fmt.Errorf("types record is empty")
 				}
+				if types != nil {
+					return nil, fmt.Errorf("more than one types record")
+				}
 				unchunkedTextRecord := unchunkTextRecord(record.Txt)
 				typesStr := strings.Split(strings.TrimPrefix(unchunkedTextRecord, "id="), ",")
 				for _, t := range typesStr {
@@ -721,12 +879,6 @@ func (d DHT) FromDNSPacket(msg *dns.Msg) (*DIDDHTDocument, error) {
 					}
 					types = append(types, TypeIndex(tInt))
 				}
-			} else if record.Hdr.Name == fmt.Sprintf("_did.%s.", suffix) && record.Hdr.Rrtype == dns.TypeNS {
-				if record.Txt[0] == "" {
-					return nil, fmt.Errorf("gateway record is empty")
-				}
-				unchunkedTextRecord := unchunkTextRecord(record.Txt)
-				gateways = append(gateways, AuthoritativeGateway(unchunkedTextRecord))
 			} else if record.Hdr.Name == "_prv._did." && record.Hdr.Rrtype == dns.TypeTXT {
 				unchunkedTextRecord := unchunkTextRecord(record.Txt)
 				data := parseTxtData(unchunkedTextRecord)
@@ -738,53 +890,62 @@ func (d DHT) FromDNSPacket(msg *dns.Msg) (*DIDDHTDocument, error) {
 				if err = ValidatePreviousDIDSignatureValid(d, *previousDID); err != nil {
 					return nil, err
 				}
-			} else if record.Hdr.Name == fmt.Sprintf("_did.%s.", suffix) && record.Hdr.Rrtype == dns.TypeTXT {
-				unchunkedTextRecord := unchunkTextRecord(record.Txt)
-				rootItems := strings.Split(unchunkedTextRecord, ";")
+			} else if record.Hdr.Name == fmt.Sprintf("_did.%s.", suffix) {
+				rootRecord = unchunkTextRecord(record.Txt)
+				seenRoot = true
+			}
+		case *dns.NS:
+			if record.Hdr.Name == fmt.Sprintf("_did.%s.", suffix) {
+				gateways = append(gateways, AuthoritativeGateway(record.Ns))
+			}
+		}
+	}
 
-				seenVersion := false
-				for _, item := range rootItems {
-					kv := strings.Split(item, "=")
-					if len(kv) != 2 {
-						continue
-					}
+	// the root record references key records by name, so it's read once every key record is, whatever their order
+	if seenRoot {
+		rootItems := strings.Split(rootRecord, ";")
 
-					key, values := kv[0], kv[1]
-					valueItems := strings.Split(values, ",")
-
-					switch key {
-					case "v":
-						if len(valueItems) != 1 || valueItems[0] != strconv.Itoa(Version) {
-							return nil, fmt.Errorf("invalid version: %s", values)
-						}
-						seenVersion = true
-					case "auth":
-						for _, valueItem := range valueItems {
-							doc.Authentication = append(doc.Authentication, doc.ID+"#"+keyLookup[valueItem])
-						}
-					case "asm":
-						for _, valueItem := range valueItems {
-							doc.AssertionMethod = append(doc.AssertionMethod, doc.ID+"#"+keyLookup[valueItem])
-						}
-					case "agm":
-						for _, valueItem := range valueItems {
-							doc.KeyAgreement = append(doc.KeyAgreement, doc.ID+"#"+keyLookup[valueItem])
-						}
-					case "inv":
-						for _, valueItem := range valueItems {
-							doc.CapabilityInvocation = append(doc.CapabilityInvocation, doc.ID+"#"+keyLookup[valueItem])
-						}
-					case "del":
-						for _, valueItem := range valueItems {
-							doc.CapabilityDelegation = append(doc.CapabilityDelegation, doc.ID+"#"+keyLookup[valueItem])
-						}
-					}
+		seenVersion := false
+		for _, item := range rootItems {
+			kv := strings.Split(item, "=")
+			if len(kv) != 2 {
+				continue
+			}
+
+			key, values := kv[0], kv[1]
+			valueItems := strings.Split(values, ",")
+
+			switch key {
+			case "v":
+				if len(valueItems) != 1 || valueItems[0] != strconv.Itoa(Version) {
+					return nil, fmt.Errorf("invalid version: %s", values)
+				}
+				seenVersion = true
+			case "auth":
+				for _, valueItem := range valueItems {
+					doc.Authentication = append(doc.Authentication, doc.ID+"#"+keyLookup[valueItem])
+				}
+			case "asm":
+				for _, valueItem := range valueItems {
+					doc.AssertionMethod = append(doc.AssertionMethod, doc.ID+"#"+keyLookup[valueItem])
+				}
+			case "agm":
+				for _, valueItem := range valueItems {
+					doc.KeyAgreement = append(doc.KeyAgreement, doc.ID+"#"+keyLookup[valueItem])
+				}
+			case "inv":
+				for _, valueItem := range valueItems {
+					doc.CapabilityInvocation = append(doc.CapabilityInvocation, doc.ID+"#"+keyLookup[valueItem])
 				}
-				if !seenVersion {
-					return nil, fmt.Errorf("root record missing version identifier")
+			case "del":
+				for _, valueItem := range valueItems {
+					doc.CapabilityDelegation = append(doc.CapabilityDelegation, doc.ID+"#"+keyLookup[valueItem])
 				}
 			}
 		}
+		if !seenVersion {
+			return nil, fmt.Errorf("root record missing version identifier")
+		}
 	}
 
 	return &DIDDHTDocument{
@@ -810,6 +971,9 @@ func CreatePreviousDIDRecord(previousDIDPrivateKey ed25519.PrivateKey, previousD
 
 // ValidatePreviousDIDSignatureValid validates the signature of the previous DID over the current DID
 func ValidatePreviousDIDSignatureValid(currentDID DHT, previousDID PreviousDID) error {
+	if previousDID.PreviousDID == currentDID {
+		return fmt.Errorf("the previous DID cannot be the DID itself: %s", currentDID)
+	}
 	identityKey, err := currentDID.IdentityKey()
 	if err != nil {
 		return errors.Wrapf(err, "failed to get identity key from the current DID: %s", currentDID)
@@ -828,13 +992,38 @@ func ValidatePreviousDIDSignatureValid(currentDID DHT, previousDID PreviousDID)
 	return nil
 }
 
+// fragment returns the fragment of one of the document's IDs, given as a DID URL of the document, such as
+// did:dht:abc#key-1, a relative reference, such as #key-1, or a bare fragment, such as key-1. It reports false for an
+// ID of another DID, or a fragment a record can't hold.
+func fragment(docID, id string) (string, bool) {
+	if f, ok := strings.CutPrefix(id, docID+"#"); ok {
+		id = f
+	} else {
+		id = strings.TrimPrefix(id, "#")
+	}
+	return id, id != "" && !strings.ContainsAny(id, "#;")
+}
+
+// IsDID reports whether s is a DID of any method https://www.w3.org/TR/did-core/#did-syntax
+func IsDID(s string) bool {
+	parts := strings.SplitN(s, ":", 3)
+	return len(parts) == 3 && parts[0] == "did" && parts[1] != "" && parts[2] != ""
+}
+
+// IsAbsoluteURI reports whether s is a URI with a scheme, such as https://example.com or a DID
+func IsAbsoluteURI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && (u.Host != "" || u.Opaque != "")
+}
+
+// parseTxtData parses the key=value pairs of a record, separated by ';'. Values may hold '=', as URIs with queries
+// do.
 func parseTxtData(data string) map[string]string {
 	pairs := strings.Split(data, ";")
 	result := make(map[string]string)
 	for _, pair := range pairs {
-		kv := strings.Split(pair, "=")
-		if len(kv) == 2 {
-			result[kv[0]] = kv[1]
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			result[k] = v
 		}
 	}
 	return result
@@ -925,23 +1114,87 @@ func keyTypeForJWK(jwk jwx.PublicKeyJWK) int {
 	return -1
 }
 
-// chunkTextRecord splits a text record into chunks of 255 characters, taking into account multi-byte characters
+// verificationMethodJWK returns the public key of a verification method as a JWK with its alg set, converting a
+// multikey's multibase encoded key https://www.w3.org/TR/controller-document/#multikey
+func verificationMethodJWK(vm did.VerificationMethod) (*jwx.PublicKeyJWK, error) {
+	if vm.PublicKeyJWK != nil {
+		return vm.PublicKeyJWK, nil
+	}
+	if vm.PublicKeyMultibase == "" {
+		return nil, fmt.Errorf("verification method %s needs a public key jwk or multibase encoded key", vm.ID)
+	}
+
+	pubKeyBytes, _, keyType, err := did.DecodeMultibaseEncodedKey(vm.PublicKeyMultibase)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode multibase encoded key of verification method %s", vm.ID)
+	}
+	// multikeys hold elliptic curve keys compressed, as DNS representations do
+	pubKey, err := crypto.BytesToPubKey(pubKeyBytes, keyType, crypto.ECDSAUnmarshalCompressed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s key of verification method %s", keyType, vm.ID)
+	}
+	pubKeyJWK, err := jwx.PublicKeyToPublicKeyJWK(nil, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if pubKeyJWK.ALG = defaultAlgForJWK(*pubKeyJWK); pubKeyJWK.ALG == "" {
+		return nil, fmt.Errorf("unsupported key type of verification method %s: %s", vm.ID, keyType)
+	}
+	return pubKeyJWK, nil
+}
+
+// chunkTextRecord splits a text record into the character strings of a TXT record, of at most 255 bytes each without
+// splitting a multi-byte character. Strings are escaped as miekg/dns expects: a '"' or '\\' is escaped with a '\\',
+// and an unprintable byte is written as \DDD.
 func chunkTextRecord(record string) []string {
 	var chunks []string
-	runeArray := []rune(record) // Convert to rune slice to properly handle multi-byte characters
-	for len(runeArray) > 0 {
-		if len(runeArray) <= 255 {
-			chunks = append(chunks, string(runeArray))
-			break
+	for len(record) > 0 {
+		n := min(len(record), 255)
+		for n < len(record) && !utf8.RuneStart(record[n]) {
+			n--
 		}
-
-		chunks = append(chunks, string(runeArray[:255]))
-		runeArray = runeArray[255:]
+		chunks = append(chunks, escapeTextString(record[:n]))
+		record = record[n:]
 	}
 	return chunks
 }
 
-// unchunkTextRecord joins chunks of a text record
+// escapeTextString escapes a TXT record's character string
+func escapeTextString(s string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '"' || b == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteByte(b)
+		case b < ' ' || b > '~':
+			escaped.WriteString(fmt.Sprintf("\\%03d", b))
+		default:
+			escaped.WriteByte(b)
+		}
+	}
+	return escaped.String()
+}
+
+// unchunkTextRecord joins the escaped character strings of a TXT record
 func unchunkTextRecord(chunks []string) string {
-	return strings.Join(chunks, "")
+	var record strings.Builder
+	for _, chunk := range chunks {
+		for i := 0; i < len(chunk); i++ {
+			if chunk[i] != '\\' || i+1 == len(chunk) {
+				record.WriteByte(chunk[i])
+				continue
+			}
+			i++
+			if i+3 <= len(chunk) {
+				if ddd, err := strconv.ParseUint(chunk[i:i+3], 10, 8); err == nil {
+					record.WriteByte(byte(ddd))
+					i += 2
+					continue
+				}
+			}
+			record.WriteByte(chunk[i])
+		}
+	}
+	return record.String()
 }
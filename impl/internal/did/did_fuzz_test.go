@@ -0,0 +1,163 @@
+package did
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/TBD54566975/ssi-sdk/crypto"
+	"github.com/TBD54566975/ssi-sdk/did"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// FuzzDNSPacketRoundTrip encodes DID documents to DNS packets and decodes them again, failing if a document the
+// encoder accepts doesn't decode to the same document. Documents it can't represent must be refused, not mangled.
+// The seed corpus is the spec's test vectors and the documents in testdata/documents. Run it with
+//
+//	go test -tags jwx_es256k -run '^$' -fuzz FuzzDNSPacketRoundTrip ./internal/did
+func FuzzDNSPacketRoundTrip(f *testing.F) {
+	for _, vector := range []string{vector1DIDDocument, vector2DIDDocument, vector3DIDDocument} {
+		docJSON, err := getTestData(vector)
+		require.NoError(f, err)
+		f.Add(docJSON)
+	}
+	documents, err := testData.ReadDir("testdata/documents")
+	require.NoError(f, err)
+	for _, document := range documents {
+		docJSON, err := getTestData("documents/" + document.Name())
+		require.NoError(f, err)
+		f.Add(docJSON)
+	}
+
+	f.Fuzz(func(t *testing.T, docJSON []byte) {
+		var doc did.Document
+		if err := json.Unmarshal(docJSON, &doc); err != nil {
+			return
+		}
+		didID := DHT(doc.ID)
+		if !didID.IsValid() {
+			return
+		}
+
+		for _, encode := range []func(did.Document, []TypeIndex, []AuthoritativeGateway, *PreviousDID) (*dns.Msg, error){
+			didID.ToDNSPacket, didID.ToCompactDNSPacket,
+		} {
+			packet, err := encode(doc, nil, nil, nil)
+			if err != nil {
+				continue
+			}
+			packed, err := packet.Pack()
+			require.NoError(t, err)
+			msg := new(dns.Msg)
+			require.NoError(t, msg.Unpack(packed))
+			decoded, err := didID.FromDNSPacket(msg)
+			require.NoError(t, err)
+			assert.Equal(t, encodedProperties(doc), encodedProperties(decoded.Doc))
+		}
+	})
+}
+
+// encodedProperties returns the properties of a document that DNS packets represent, in one form for the
+// equivalent ways a document can write them, such as a relative or an absolute verification method ID, a single
+// service endpoint or a list of one, or verification relationships in any order
+func encodedProperties(doc did.Document) map[string]any {
+	qualify := func(id string) string {
+		switch {
+		case strings.HasPrefix(id, doc.ID+"#"):
+			return id
+		case strings.HasPrefix(id, "#"):
+			return doc.ID + id
+		default:
+			return doc.ID + "#" + id
+		}
+	}
+	properties := map[string]any{
+		"id":          doc.ID,
+		"controller":  normalizedJSON(doc.Controller),
+		"alsoKnownAs": normalizedJSON(doc.AlsoKnownAs),
+	}
+
+	var methods []map[string]any
+	for _, vm := range doc.VerificationMethod {
+		jwk, err := verificationMethodJWK(vm)
+		if err != nil {
+			methods = append(methods, map[string]any{"error": err.Error()})
+			continue
+		}
+		alg := jwk.ALG
+		if alg == "" {
+			alg = defaultAlgForJWK(*jwk)
+		}
+		// compare keys as bytes, as base64url can write the same bytes more than one way
+		var keyBytes []byte
+		if pubKey, err := jwk.ToPublicKey(); err == nil {
+			keyBytes, _ = crypto.PubKeyToBytes(pubKey, crypto.ECDSAMarshalCompressed)
+		}
+		controller := vm.Controller
+		if controller == "" {
+			controller = doc.ID
+		}
+		methods = append(methods, map[string]any{
+			"id":         qualify(vm.ID),
+			"controller": controller,
+			"key":        []string{jwk.KTY, jwk.CRV, base64.RawURLEncoding.EncodeToString(keyBytes), alg},
+		})
+	}
+	properties["verificationMethod"] = methods
+
+	for name, relationship := range map[string][]did.VerificationMethodSet{
+		"authentication":       doc.Authentication,
+		"assertionMethod":      doc.AssertionMethod,
+		"keyAgreement":         doc.KeyAgreement,
+		"capabilityInvocation": doc.CapabilityInvocation,
+		"capabilityDelegation": doc.CapabilityDelegation,
+	} {
+		var refs []string
+		for _, ref := range relationship {
+			refs = append(refs, qualify(fmt.Sprint(ref)))
+		}
+		slices.Sort(refs)
+		properties[name] = slices.Compact(refs)
+	}
+
+	var services []map[string]any
+	for _, service := range doc.Services {
+		services = append(services, map[string]any{
+			"id":              qualify(service.ID),
+			"type":            service.Type,
+			"serviceEndpoint": normalizedJSON(service.ServiceEndpoint),
+			"sig":             normalizedJSON(service.Sig),
+			"enc":             normalizedJSON(service.Enc),
+		})
+	}
+	properties["service"] = services
+	return properties
+}
+
+// normalizedJSON returns a property as it reads once marshaled and unmarshaled, with a single value as a list of one
+// and an empty list as no value
+func normalizedJSON(value any) any {
+	if value == nil {
+		return nil
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return err.Error()
+	}
+	var normalized any
+	if err = json.Unmarshal(valueJSON, &normalized); err != nil {
+		return err.Error()
+	}
+	switch list, ok := normalized.([]any); {
+	case !ok:
+		return []any{normalized}
+	case len(list) == 0:
+		return nil
+	}
+	return normalized
+}
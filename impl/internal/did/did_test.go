@@ -2,7 +2,10 @@ package did
 
 import (
 	"crypto/ed25519"
+	"encoding/binary"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/TBD54566975/ssi-sdk/crypto"
@@ -10,6 +13,8 @@ import (
 	"github.com/TBD54566975/ssi-sdk/cryptosuite"
 	"github.com/TBD54566975/ssi-sdk/did"
 	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/mr-tron/base58"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -167,6 +172,222 @@ func TestToDNSPacket(t *testing.T) {
 		require.Equal(t, didDHTDoc.Gateways, []AuthoritativeGateway{"gateway1.example-did-dht-gateway.com."})
 
 		assert.EqualValues(t, *doc, didDHTDoc.Doc)
+
+		// gateways are NS records, which must survive the wire
+		packed, err := packet.Pack()
+		require.NoError(t, err)
+		var unpacked dns.Msg
+		require.NoError(t, unpacked.Unpack(packed))
+		didDHTDoc, err = didID.FromDNSPacket(&unpacked)
+		require.NoError(t, err)
+		require.Equal(t, didDHTDoc.Gateways, []AuthoritativeGateway{"gateway1.example-did-dht-gateway.com."})
+
+		_, err = didID.ToDNSPacket(*doc, nil, []AuthoritativeGateway{"gateway1.example-did-dht-gateway.com"}, nil)
+		assert.ErrorContains(t, err, "is not a fully qualified domain name")
+	})
+
+	t.Run("doc with invalid types", func(t *testing.T) {
+		_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{})
+		require.NoError(t, err)
+
+		didID := DHT(doc.ID)
+		_, err = didID.ToDNSPacket(*doc, []TypeIndex{Organization, 8}, nil, nil)
+		assert.ErrorContains(t, err, "type 8 is not registered")
+		_, err = didID.ToDNSPacket(*doc, []TypeIndex{Organization, Organization}, nil, nil)
+		assert.ErrorContains(t, err, "duplicate type 1")
+
+		// a did has at most one types record
+		packet, err := didID.ToDNSPacket(*doc, []TypeIndex{Organization}, nil, nil)
+		require.NoError(t, err)
+		packet.Answer = append(packet.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: "_typ._did.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 7200},
+			Txt: []string{"id=7"},
+		})
+		_, err = didID.FromDNSPacket(packet)
+		assert.ErrorContains(t, err, "more than one types record")
+	})
+
+	t.Run("semantically identical docs - test to dns packet is canonical", func(t *testing.T) {
+		var secpJWK jwx.PublicKeyJWK
+		retrieveTestVectorAs(t, vector2PublicKeyJWK2, &secpJWK)
+		_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{
+			VerificationMethods: []VerificationMethod{
+				{
+					VerificationMethod: did.VerificationMethod{ID: "sig", Type: cryptosuite.JSONWebKeyType, PublicKeyJWK: &secpJWK},
+					Purposes:           []did.PublicKeyPurpose{did.Authentication, did.AssertionMethod},
+				},
+			},
+			Services: []did.Service{
+				{ID: "dwn", Type: "DecentralizedWebNode", ServiceEndpoint: map[string]any{"nodes": []any{"https://dwn.example.com"}, "enc": "#enc"}},
+				{ID: "domain", Type: "LinkedDomains", ServiceEndpoint: "https://example.com"},
+			},
+		})
+		require.NoError(t, err)
+		types := []TypeIndex{Organization, Discoverable}
+		gateways := []AuthoritativeGateway{"gateway2.example.com.", "gateway1.example.com."}
+
+		// the same document, written with relative IDs, lists of one, and reordered sets
+		same := *doc
+		same.VerificationMethod = slices.Clone(doc.VerificationMethod)
+		same.VerificationMethod[1].ID = "#sig"
+		same.Authentication = []did.VerificationMethodSet{"#sig", doc.ID + "#0", "#sig"}
+		same.AssertionMethod = []did.VerificationMethodSet{"sig", "0"}
+		same.Services = []did.Service{
+			{ID: "#dwn", Type: "DecentralizedWebNode", ServiceEndpoint: map[string]any{"enc": "#enc", "nodes": []string{"https://dwn.example.com"}}},
+			{ID: doc.ID + "#domain", Type: "LinkedDomains", ServiceEndpoint: []any{"https://example.com"}},
+		}
+
+		didID := DHT(doc.ID)
+		for _, encode := range []func(did.Document, []TypeIndex, []AuthoritativeGateway, *PreviousDID) (*dns.Msg, error){
+			didID.ToDNSPacket, didID.ToCompactDNSPacket,
+		} {
+			packet, err := encode(*doc, types, gateways, nil)
+			require.NoError(t, err)
+			packed, err := packet.Pack()
+			require.NoError(t, err)
+
+			samePacket, err := encode(same, []TypeIndex{Discoverable, Organization}, []AuthoritativeGateway{"gateway1.example.com.", "gateway2.example.com.", "gateway1.example.com."}, nil)
+			require.NoError(t, err)
+			samePacked, err := samePacket.Pack()
+			require.NoError(t, err)
+			assert.Equal(t, packed, samePacked)
+		}
+	})
+
+	t.Run("doc the dns encoding can't represent", func(t *testing.T) {
+		_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{
+			Services: []did.Service{{ID: "dwn", Type: "DecentralizedWebNode", ServiceEndpoint: "https://dwn.example.com"}},
+		})
+		require.NoError(t, err)
+		didID := DHT(doc.ID)
+
+		for _, test := range []struct {
+			name   string
+			modify func(doc *did.Document)
+			err    string
+		}{
+			{
+				name:   "service of another did",
+				modify: func(doc *did.Document) { doc.Services[0].ID = "did:example:abcd#dwn" },
+				err:    "service id did:example:abcd#dwn is not an id of the document a record can hold",
+			},
+			{
+				name:   "service type with a separator",
+				modify: func(doc *did.Document) { doc.Services[0].Type = "DWN;v=1" },
+				err:    "type of service dwn cannot contain ';'",
+			},
+			{
+				name:   "sig with a separator",
+				modify: func(doc *did.Document) { doc.Services[0].Sig = []string{"#sig,1"} },
+				err:    `failed to encode service dwn: sig value "#sig,1" cannot be empty or contain commas`,
+			},
+			{
+				name:   "identity key under another id",
+				modify: func(doc *did.Document) { doc.VerificationMethod[0].ID = doc.ID + "#key-1" },
+				err:    "verification method id 0 must be the identity key, instead it is " + doc.ID + "#key-1",
+			},
+			{
+				name:   "embedded verification method",
+				modify: func(doc *did.Document) { doc.Authentication = []did.VerificationMethodSet{doc.VerificationMethod[0]} },
+				err:    "authentication must reference verification methods by id",
+			},
+			{
+				name:   "reference to a missing verification method",
+				modify: func(doc *did.Document) { doc.AssertionMethod = append(doc.AssertionMethod, "#missing") },
+				err:    "assertionMethod references no verification method: #missing",
+			},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				modified := *doc
+				modified.VerificationMethod = slices.Clone(doc.VerificationMethod)
+				modified.Services = slices.Clone(doc.Services)
+				test.modify(&modified)
+				_, err := didID.ToDNSPacket(modified, nil, nil, nil)
+				assert.EqualError(t, err, test.err)
+			})
+		}
+	})
+
+	t.Run("doc with characters dns escapes - test to dns packet round trip", func(t *testing.T) {
+		_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{
+			Services: []did.Service{{
+				ID:              "profile",
+				Type:            "Profil\"é\\Service",
+				ServiceEndpoint: "https://例え.jp/" + strings.Repeat("プロフィール", 20),
+			}},
+		})
+		require.NoError(t, err)
+
+		didID := DHT(doc.ID)
+		packet, err := didID.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		packed, err := packet.Pack()
+		require.NoError(t, err)
+		unpacked := new(dns.Msg)
+		require.NoError(t, unpacked.Unpack(packed))
+
+		decoded, err := didID.FromDNSPacket(unpacked)
+		require.NoError(t, err)
+		assert.Equal(t, doc.Services[0].Type, decoded.Doc.Services[0].Type)
+		assert.Equal(t, []string{doc.Services[0].ServiceEndpoint.(string)}, decoded.Doc.Services[0].ServiceEndpoint)
+	})
+
+	t.Run("doc with structured service endpoints - test to dns packet round trip", func(t *testing.T) {
+		_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{
+			Services: []did.Service{
+				{
+					ID:   "dwn",
+					Type: "DecentralizedWebNode",
+					ServiceEndpoint: map[string]any{
+						"nodes": []any{"https://dwn.example.com", "https://dwn2.example.com"},
+						"enc":   "#enc",
+						"sig":   "#sig",
+					},
+				},
+				{
+					ID:              "hub",
+					Type:            "MessagingService",
+					ServiceEndpoint: []any{"https://example.com/hub", map[string]any{"uri": "https://example.com/hub2", "accept": []any{"didcomm/v2"}}},
+				},
+				{
+					ID:              "search",
+					Type:            "LinkedDomains",
+					ServiceEndpoint: []string{"https://example.com/search?q=a,b", "https://example.com/?a=b"},
+				},
+				{
+					ID:              "web",
+					Type:            "LinkedDomains",
+					ServiceEndpoint: []string{"https://example.com/?a=b"},
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		didID := DHT(doc.ID)
+		packet, err := didID.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+
+		// only endpoints that aren't plain uris are encoded as json
+		var serviceRecords []string
+		for _, rr := range packet.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && strings.HasPrefix(txt.Hdr.Name, "_s") {
+				serviceRecords = append(serviceRecords, strings.Join(txt.Txt, ""))
+			}
+		}
+		require.Len(t, serviceRecords, 4)
+		assert.Contains(t, serviceRecords[0], ";sej=")
+		assert.Contains(t, serviceRecords[1], ";sej=")
+		assert.Contains(t, serviceRecords[2], ";sej=")
+		assert.Equal(t, "id=web;t=LinkedDomains;se=https://example.com/?a=b", serviceRecords[3])
+
+		didDHTDoc, err := didID.FromDNSPacket(packet)
+		require.NoError(t, err)
+
+		docJSON, err := json.Marshal(doc)
+		require.NoError(t, err)
+		decodedJSON, err := json.Marshal(didDHTDoc.Doc)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(docJSON), string(decodedJSON))
 	})
 
 	t.Run("doc with multiple keys and services - test to dns packet round trip", func(t *testing.T) {
@@ -228,6 +449,96 @@ func TestToDNSPacket(t *testing.T) {
 	})
 }
 
+func TestKeyTypes(t *testing.T) {
+	secpKey, _, err := crypto.GenerateSECP256k1Key()
+	require.NoError(t, err)
+	p256Key, _, err := crypto.GenerateP256Key()
+	require.NoError(t, err)
+	x25519Key, _, err := crypto.GenerateX25519Key()
+	require.NoError(t, err)
+
+	tests := []struct {
+		keyType crypto.KeyType
+		pubKey  any
+		purpose did.PublicKeyPurpose
+		alg     string
+	}{
+		{crypto.SECP256k1, secpKey, did.AssertionMethod, string(crypto.ES256K)},
+		{crypto.P256, p256Key, did.Authentication, string(crypto.ES256)},
+		{crypto.X25519, x25519Key, did.KeyAgreement, string(crypto.ECDHESA256KW)},
+	}
+	for _, test := range tests {
+		pubKeyJWK, err := jwx.PublicKeyToPublicKeyJWK(nil, test.pubKey)
+		require.NoError(t, err)
+		pubKeyBytes, err := crypto.PubKeyToBytes(test.pubKey, crypto.ECDSAMarshalCompressed)
+		require.NoError(t, err)
+		// a multikey is the base58btc multibase encoding of the key's multicodec varint and its compressed bytes
+		codec, err := did.KeyTypeToMultiCodec(test.keyType)
+		require.NoError(t, err)
+		multibase := "z" + base58.Encode(append(binary.AppendUvarint(nil, uint64(codec)), pubKeyBytes...))
+
+		for name, vm := range map[string]did.VerificationMethod{
+			"jwk":      {ID: "key-1", Type: cryptosuite.JSONWebKeyType, PublicKeyJWK: pubKeyJWK},
+			"multikey": {ID: "key-1", Type: cryptosuite.MultikeyType, PublicKeyMultibase: multibase},
+		} {
+			t.Run(string(test.keyType)+" as "+name, func(t *testing.T) {
+				_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{
+					VerificationMethods: []VerificationMethod{{VerificationMethod: vm, Purposes: []did.PublicKeyPurpose{test.purpose}}},
+				})
+				require.NoError(t, err)
+
+				// multikeys are created as the JWKs they resolve to
+				created := doc.VerificationMethod[1]
+				assert.Equal(t, cryptosuite.JSONWebKeyType, created.Type)
+				assert.Empty(t, created.PublicKeyMultibase)
+				assert.Equal(t, pubKeyJWK.X, created.PublicKeyJWK.X)
+				assert.Equal(t, pubKeyJWK.Y, created.PublicKeyJWK.Y)
+
+				packet, err := DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+				require.NoError(t, err)
+				decoded, err := DHT(doc.ID).FromDNSPacket(packet)
+				require.NoError(t, err)
+				resolved := decoded.Doc.VerificationMethod[1]
+				assert.Equal(t, doc.ID+"#key-1", resolved.ID)
+				assert.Equal(t, created.PublicKeyJWK.ALG, resolved.PublicKeyJWK.ALG)
+				if vm.Type == cryptosuite.MultikeyType {
+					assert.Equal(t, test.alg, resolved.PublicKeyJWK.ALG)
+				}
+				assert.Equal(t, pubKeyJWK.X, resolved.PublicKeyJWK.X)
+				assert.Equal(t, pubKeyJWK.Y, resolved.PublicKeyJWK.Y)
+
+				docJSON, err := json.Marshal(doc)
+				require.NoError(t, err)
+				decodedJSON, err := json.Marshal(decoded.Doc)
+				require.NoError(t, err)
+				assert.JSONEq(t, string(docJSON), string(decodedJSON))
+			})
+		}
+	}
+
+	t.Run("x25519 keys only agree keys", func(t *testing.T) {
+		pubKeyJWK, err := jwx.PublicKeyToPublicKeyJWK(nil, x25519Key)
+		require.NoError(t, err)
+		_, _, err = GenerateDIDDHT(CreateDIDDHTOpts{
+			VerificationMethods: []VerificationMethod{{
+				VerificationMethod: did.VerificationMethod{ID: "key-1", Type: cryptosuite.JSONWebKeyType, PublicKeyJWK: pubKeyJWK},
+				Purposes:           []did.PublicKeyPurpose{did.KeyAgreement, did.Authentication},
+			}},
+		})
+		assert.ErrorContains(t, err, "can only be used for key agreement")
+	})
+
+	t.Run("unsupported multikeys", func(t *testing.T) {
+		_, _, err := GenerateDIDDHT(CreateDIDDHTOpts{
+			VerificationMethods: []VerificationMethod{{
+				VerificationMethod: did.VerificationMethod{ID: "key-1", Type: cryptosuite.MultikeyType, PublicKeyMultibase: "zNotAKey"},
+				Purposes:           []did.PublicKeyPurpose{did.AssertionMethod},
+			}},
+		})
+		assert.ErrorContains(t, err, "failed to decode multibase encoded key of verification method key-1")
+	})
+}
+
 func TestDIDDHTFeatures(t *testing.T) {
 	t.Run("DHT.Method()", func(t *testing.T) {
 		privKey, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{})
@@ -274,6 +585,41 @@ func TestDIDDHTFeatures(t *testing.T) {
 		assert.NotEmpty(t, doc)
 	})
 
+	t.Run("controller and alsoKnownAs round trip", func(t *testing.T) {
+		doc, err := CreateDIDDHTDID(pubKey.(ed25519.PublicKey), CreateDIDDHTOpts{
+			Controller:  []string{"did:example:abcd"},
+			AlsoKnownAs: []string{"https://example.com/alice"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "did:example:abcd", doc.Controller)
+		assert.Equal(t, []string{"https://example.com/alice"}, doc.AlsoKnownAs)
+
+		didID := DHT(doc.ID)
+		packet, err := didID.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		decoded, err := didID.FromDNSPacket(packet)
+		require.NoError(t, err)
+		assert.Equal(t, doc.Controller, decoded.Doc.Controller)
+		assert.Equal(t, doc.AlsoKnownAs, decoded.Doc.AlsoKnownAs)
+
+		// documents read from JSON hold lists of any
+		doc.Controller = []any{"did:example:abcd", "did:example:ijkl"}
+		doc.AlsoKnownAs = []any{"did:example:efgh", "https://example.com/alice"}
+		packet, err = didID.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		decoded, err = didID.FromDNSPacket(packet)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"did:example:abcd", "did:example:ijkl"}, decoded.Doc.Controller)
+		assert.Equal(t, []string{"did:example:efgh", "https://example.com/alice"}, decoded.Doc.AlsoKnownAs)
+
+		doc.AlsoKnownAs = []any{"https://example.com/?a=b,c"}
+		_, err = didID.ToDNSPacket(*doc, nil, nil, nil)
+		assert.ErrorContains(t, err, "cannot be empty or contain commas")
+		doc.AlsoKnownAs = []any{5}
+		_, err = didID.ToDNSPacket(*doc, nil, nil, nil)
+		assert.EqualError(t, err, "alsoKnownAs must be a string or a list of strings")
+	})
+
 	t.Run("verification method without ID", func(t *testing.T) {
 		doc, err := CreateDIDDHTDID(pubKey.(ed25519.PublicKey), CreateDIDDHTOpts{
 			Controller:  []string{"did:example:abcd"},
@@ -437,6 +783,12 @@ func TestDIDDHTFeatures(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "failed to get identity key from the current DID")
 
+		// a DID can't supersede itself
+		selfSigned, err := CreatePreviousDIDRecord(previousPrivKey, previousDIDDHT, previousDIDDHT)
+		require.NoError(t, err)
+		err = ValidatePreviousDIDSignatureValid(previousDIDDHT, *selfSigned)
+		assert.ErrorContains(t, err, "the previous DID cannot be the DID itself")
+
 		// validate previous DID signature with wrong signature
 		previousDID.Signature = "wrong signature"
 		err = ValidatePreviousDIDSignatureValid(currentDIDDHT, *previousDID)
@@ -455,6 +807,20 @@ func TestCreationFailures(t *testing.T) {
 	var secpJWK jwx.PublicKeyJWK
 	retrieveTestVectorAs(t, vector2PublicKeyJWK2, &secpJWK)
 
+	t.Run("invalid controller and alsoKnownAs", func(t *testing.T) {
+		doc, err := CreateDIDDHTDID(pubKey.(ed25519.PublicKey), CreateDIDDHTOpts{
+			Controller: []string{"did:example:abcd", "alice"},
+		})
+		assert.EqualError(t, err, "controller alice is not a DID")
+		assert.Nil(t, doc)
+
+		doc, err = CreateDIDDHTDID(pubKey.(ed25519.PublicKey), CreateDIDDHTOpts{
+			AlsoKnownAs: []string{"example.com"},
+		})
+		assert.EqualError(t, err, "alsoKnownAs example.com is not an absolute URI without commas")
+		assert.Nil(t, doc)
+	})
+
 	t.Run("verification method id 0", func(t *testing.T) {
 		doc, err := CreateDIDDHTDID(pubKey.(ed25519.PublicKey), CreateDIDDHTOpts{
 			Controller:  []string{"did:example:abcd"},
@@ -562,7 +928,7 @@ func TestCreationFailures(t *testing.T) {
 				},
 			},
 		})
-		assert.EqualError(t, err, "verification method public key jwk is required")
+		assert.EqualError(t, err, "verification method sig needs a public key jwk or multibase encoded key")
 		assert.Nil(t, doc)
 	})
 
@@ -593,3 +959,20 @@ func TestCreationFailures(t *testing.T) {
 	})
 
 }
+
+func TestDeactivation(t *testing.T) {
+	_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	didDHTDoc, err := DHT(doc.ID).FromDNSPacket(DeactivationDNSPacket())
+	require.NoError(t, err)
+	assert.True(t, didDHTDoc.Deactivated)
+	assert.Equal(t, doc.ID, didDHTDoc.Doc.ID)
+	assert.Empty(t, didDHTDoc.Doc.VerificationMethod)
+
+	packet, err := DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	didDHTDoc, err = DHT(doc.ID).FromDNSPacket(packet)
+	require.NoError(t, err)
+	assert.False(t, didDHTDoc.Deactivated)
+}
@@ -17,13 +17,6 @@ import (
 
 // TestVectors from the spec https://did-dht.com/#test-vectors
 func TestVectors(t *testing.T) {
-	type testVectorDNSRecord struct {
-		Name       string   `json:"name"`
-		RecordType string   `json:"type"`
-		TTL        int      `json:"ttl"`
-		Record     []string `json:"rdata"`
-	}
-
 	// https://did-dht.com/#vector-1
 	t.Run("test vector 1", func(t *testing.T) {
 		var pubKeyJWK jwx.PublicKeyJWK
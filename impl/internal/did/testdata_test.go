@@ -29,6 +29,14 @@ const (
 	vector3DNSRecords    string = "vector-3-dns-records.json"
 )
 
+// testVectorDNSRecord is a DNS record as the spec's test vectors write them
+type testVectorDNSRecord struct {
+	Name       string   `json:"name"`
+	RecordType string   `json:"type"`
+	TTL        int      `json:"ttl"`
+	Record     []string `json:"rdata"`
+}
+
 func getTestData(fileName string) ([]byte, error) {
 	return testData.ReadFile("testdata/" + fileName)
 }
@@ -0,0 +1,145 @@
+package did
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/TBD54566975/ssi-sdk/did"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ServiceParam is the DID URL parameter selecting a service https://www.w3.org/TR/did-core/#did-parameters
+	ServiceParam = "service"
+	// RelativeRefParam is the DID URL parameter giving a reference relative to the selected service's endpoint
+	RelativeRefParam = "relativeRef"
+)
+
+// ErrNotDereferenced is returned when a DID URL references nothing in the DID's document
+var ErrNotDereferenced = errors.New("did url does not reference anything in the did document")
+
+// URL is a did:dht DID URL: a DID, optionally followed by a query and a fragment https://www.w3.org/TR/did-core/#did-url-syntax
+type URL struct {
+	DID      DHT
+	Query    url.Values
+	Fragment string
+}
+
+// ParseURL parses a did:dht DID URL. DID DHT documents have no paths, so DID URLs with paths are rejected, as are
+// parameters other than service and relativeRef.
+func ParseURL(s string) (*URL, error) {
+	rest, fragment, _ := strings.Cut(s, "#")
+	rest, rawQuery, _ := strings.Cut(rest, "?")
+	if strings.Contains(rest, "/") {
+		return nil, fmt.Errorf("did:dht urls have no paths: %s", s)
+	}
+	if d := DHT(rest); !strings.HasPrefix(rest, Prefix+":") || !d.IsValid() {
+		return nil, fmt.Errorf("invalid did:dht did: %s", rest)
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid did url query")
+	}
+	for param := range query {
+		if param != ServiceParam && param != RelativeRefParam {
+			return nil, fmt.Errorf("unsupported did url parameter: %s", param)
+		}
+	}
+	if query.Has(RelativeRefParam) && !query.Has(ServiceParam) {
+		return nil, fmt.Errorf("the %s parameter requires the %s parameter", RelativeRefParam, ServiceParam)
+	}
+	return &URL{DID: DHT(rest), Query: query, Fragment: fragment}, nil
+}
+
+// IsDID reports whether the URL is just the DID, so it dereferences to the whole DID document
+func (u URL) IsDID() bool {
+	return len(u.Query) == 0 && u.Fragment == ""
+}
+
+// Dereferenced is the resource a DID URL dereferences to; exactly one field is set
+type Dereferenced struct {
+	VerificationMethod *did.VerificationMethod
+	Service            *did.Service
+	// ServiceEndpoint is the endpoint URL of the service selected by the service parameter, with any relativeRef
+	// resolved against it and the URL's fragment appended
+	ServiceEndpoint string
+}
+
+// Dereference returns the resource the URL references in the DID's document, following
+// https://w3c-ccg.github.io/did-resolution/#dereferencing-algorithm: the service parameter selects a service's
+// endpoint, and otherwise the fragment selects a verification method or service by ID. It returns
+// ErrNotDereferenced if the document has no such resource.
+func (u URL) Dereference(doc did.Document) (*Dereferenced, error) {
+	if u.Query.Has(ServiceParam) {
+		service := findService(doc, u.Query.Get(ServiceParam))
+		if service == nil {
+			return nil, errors.Wrapf(ErrNotDereferenced, "no service %s", u.Query.Get(ServiceParam))
+		}
+		endpoint, err := serviceEndpointURL(*service)
+		if err != nil {
+			return nil, err
+		}
+		if relativeRef := u.Query.Get(RelativeRefParam); relativeRef != "" {
+			ref, err := url.Parse(relativeRef)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid relativeRef")
+			}
+			endpoint = endpoint.ResolveReference(ref)
+		}
+		if u.Fragment != "" {
+			endpoint.Fragment = u.Fragment
+		}
+		return &Dereferenced{ServiceEndpoint: endpoint.String()}, nil
+	}
+
+	for i, vm := range doc.VerificationMethod {
+		if matchesFragment(vm.ID, u.Fragment) {
+			return &Dereferenced{VerificationMethod: &doc.VerificationMethod[i]}, nil
+		}
+	}
+	if service := findService(doc, u.Fragment); service != nil {
+		return &Dereferenced{Service: service}, nil
+	}
+	return nil, errors.Wrapf(ErrNotDereferenced, "no verification method or service #%s", u.Fragment)
+}
+
+// findService returns the service in the document with the given ID fragment, or nil if there is none
+func findService(doc did.Document, fragment string) *did.Service {
+	for i, service := range doc.Services {
+		if matchesFragment(service.ID, fragment) {
+			return &doc.Services[i]
+		}
+	}
+	return nil
+}
+
+// matchesFragment reports whether a verification method or service ID, which is either absolute (did:dht:...#0) or
+// relative (#0), has the given fragment
+func matchesFragment(id, fragment string) bool {
+	_, idFragment, ok := strings.Cut(id, "#")
+	return ok && fragment != "" && idFragment == fragment
+}
+
+// serviceEndpointURL returns the first endpoint URL of the service. Decoded DID DHT documents hold endpoints as lists
+// of strings, while documents built in code may hold a single string.
+func serviceEndpointURL(service did.Service) (*url.URL, error) {
+	var endpoint string
+	switch se := service.ServiceEndpoint.(type) {
+	case string:
+		endpoint = se
+	case []string:
+		if len(se) > 0 {
+			endpoint = se[0]
+		}
+	case []any:
+		if len(se) > 0 {
+			endpoint, _ = se[0].(string)
+		}
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("service %s has no endpoint url", service.ID)
+	}
+	return url.Parse(endpoint)
+}
@@ -0,0 +1,85 @@
+package did
+
+import (
+	"testing"
+
+	"github.com/TBD54566975/ssi-sdk/did"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	u, err := ParseURL(doc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DHT(doc.ID), u.DID)
+	assert.True(t, u.IsDID())
+
+	u, err = ParseURL(doc.ID + "?service=hub&relativeRef=%2Fpath#frag")
+	require.NoError(t, err)
+	assert.Equal(t, "hub", u.Query.Get(ServiceParam))
+	assert.Equal(t, "/path", u.Query.Get(RelativeRefParam))
+	assert.Equal(t, "frag", u.Fragment)
+	assert.False(t, u.IsDID())
+
+	for _, bad := range []string{
+		"did:example:123",
+		"did:dht:invalid",
+		doc.ID + "/path",
+		doc.ID + "?versionId=1",
+		doc.ID + "?relativeRef=%2Fpath",
+		doc.ID + "?service=%zz",
+	} {
+		_, err = ParseURL(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestDereference(t *testing.T) {
+	_, doc, err := GenerateDIDDHT(CreateDIDDHTOpts{
+		Services: []did.Service{
+			{ID: "hub", Type: "DecentralizedWebNode", ServiceEndpoint: "https://example.com/hub/"},
+			{ID: "vcs", Type: "VerifiableCredentialService", ServiceEndpoint: []string{"https://example.com/vc/"}},
+		},
+	})
+	require.NoError(t, err)
+
+	dereference := func(t *testing.T, suffix string) (*Dereferenced, error) {
+		u, err := ParseURL(doc.ID + suffix)
+		require.NoError(t, err)
+		return u.Dereference(*doc)
+	}
+
+	t.Run("fragment selects a verification method", func(t *testing.T) {
+		got, err := dereference(t, "#0")
+		require.NoError(t, err)
+		require.NotNil(t, got.VerificationMethod)
+		assert.Equal(t, doc.ID+"#0", got.VerificationMethod.ID)
+	})
+
+	t.Run("fragment selects a service", func(t *testing.T) {
+		got, err := dereference(t, "#vcs")
+		require.NoError(t, err)
+		require.NotNil(t, got.Service)
+		assert.Equal(t, "VerifiableCredentialService", got.Service.Type)
+	})
+
+	t.Run("service selects an endpoint", func(t *testing.T) {
+		got, err := dereference(t, "?service=hub")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/hub/", got.ServiceEndpoint)
+
+		got, err = dereference(t, "?service=vcs&relativeRef=credentials%3Fid%3D1#proof")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/vc/credentials?id=1#proof", got.ServiceEndpoint)
+	})
+
+	t.Run("missing references", func(t *testing.T) {
+		for _, suffix := range []string{"#missing", "?service=missing"} {
+			_, err := dereference(t, suffix)
+			assert.ErrorIs(t, err, ErrNotDereferenced, suffix)
+		}
+	})
+}
@@ -0,0 +1,341 @@
+// Package openapi converts the Swagger 2.0 document swag generates from the handlers' annotations to OpenAPI 3, which
+// client SDK generators expect. swag's own OpenAPI 3 output describes every response as JSON, misdescribing the
+// gateway's binary records, so the conversion keeps each operation's content types.
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the OpenAPI version of converted documents
+const Version = "3.0.3"
+
+// binaryTypes are the content types whose bodies are raw bytes, described as binary strings in OpenAPI 3
+var binaryTypes = []string{"application/octet-stream"}
+
+// errorTypes are the content types of error responses, which the gateway always reports as JSON, even for operations
+// producing binary records
+var errorTypes = []string{"application/json"}
+
+// Convert converts a Swagger 2.0 document, in YAML or JSON, to an OpenAPI 3 document in YAML
+func Convert(swagger []byte) ([]byte, error) {
+	var doc map[string]any
+	if err := yaml.Unmarshal(swagger, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse swagger document")
+	}
+	if version, _ := doc["swagger"].(string); version != "2.0" {
+		return nil, fmt.Errorf("expected a swagger 2.0 document, got version %q", version)
+	}
+
+	out := map[string]any{"openapi": Version}
+	for _, key := range []string{"info", "tags", "security", "externalDocs"} {
+		if v, ok := doc[key]; ok {
+			out[key] = v
+		}
+	}
+	if servers := servers(doc); len(servers) > 0 {
+		out["servers"] = servers
+	}
+
+	consumes, produces := mediaTypes(doc["consumes"]), mediaTypes(doc["produces"])
+	paths := make(map[string]any)
+	for path, item := range asMap(doc["paths"]) {
+		converted := make(map[string]any)
+		for method, op := range asMap(item) {
+			if method == "parameters" {
+				continue
+			}
+			operation, err := convertOperation(asMap(op), consumes, produces)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to convert %s %s", strings.ToUpper(method), path)
+			}
+			converted[method] = operation
+		}
+		paths[path] = converted
+	}
+	out["paths"] = paths
+
+	components := make(map[string]any)
+	if definitions := asMap(doc["definitions"]); len(definitions) > 0 {
+		schemas := make(map[string]any, len(definitions))
+		for name, schema := range definitions {
+			schemas[name] = convertSchema(schema, false)
+		}
+		components["schemas"] = schemas
+	}
+	if securityDefinitions := asMap(doc["securityDefinitions"]); len(securityDefinitions) > 0 {
+		schemes := make(map[string]any, len(securityDefinitions))
+		for name, scheme := range securityDefinitions {
+			schemes[name] = convertSecurityScheme(asMap(scheme))
+		}
+		components["securitySchemes"] = schemes
+	}
+	if len(components) > 0 {
+		out["components"] = components
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return nil, errors.Wrap(err, "failed to encode openapi document")
+	}
+	if err := enc.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to encode openapi document")
+	}
+	return buf.Bytes(), nil
+}
+
+// servers returns the server the document's host, base path, and schemes describe, if any
+func servers(doc map[string]any) []any {
+	host, _ := doc["host"].(string)
+	basePath, _ := doc["basePath"].(string)
+	if host == "" && basePath == "" {
+		return nil
+	}
+	if host == "" {
+		return []any{map[string]any{"url": basePath}}
+	}
+	schemes := mediaTypes(doc["schemes"])
+	if len(schemes) == 0 {
+		schemes = []string{"https"}
+	}
+	var urls []any
+	for _, scheme := range schemes {
+		urls = append(urls, map[string]any{"url": scheme + "://" + host + basePath})
+	}
+	return urls
+}
+
+func convertOperation(op map[string]any, consumes, produces []string) (map[string]any, error) {
+	if c := mediaTypes(op["consumes"]); len(c) > 0 {
+		consumes = c
+	}
+	if p := mediaTypes(op["produces"]); len(p) > 0 {
+		produces = p
+	}
+	if len(consumes) == 0 {
+		consumes = []string{"application/json"}
+	}
+	if len(produces) == 0 {
+		produces = []string{"application/json"}
+	}
+
+	out := make(map[string]any)
+	for key, v := range op {
+		switch key {
+		case "consumes", "produces", "parameters", "responses":
+		default:
+			out[key] = v
+		}
+	}
+
+	var parameters []any
+	var formProperties map[string]any
+	var formRequired []any
+	for _, p := range asSlice(op["parameters"]) {
+		param := asMap(p)
+		switch param["in"] {
+		case "body":
+			body := map[string]any{"content": content(param["schema"], consumes)}
+			if description, ok := param["description"]; ok {
+				body["description"] = description
+			}
+			if required, ok := param["required"]; ok {
+				body["required"] = required
+			}
+			out["requestBody"] = body
+		case "formData":
+			if formProperties == nil {
+				formProperties = make(map[string]any)
+			}
+			name, _ := param["name"].(string)
+			formProperties[name] = convertSchema(parameterSchema(param), false)
+			if required, _ := param["required"].(bool); required {
+				formRequired = append(formRequired, name)
+			}
+		default:
+			converted := make(map[string]any)
+			for _, key := range []string{"name", "in", "description", "required", "allowEmptyValue"} {
+				if v, ok := param[key]; ok {
+					converted[key] = v
+				}
+			}
+			converted["schema"] = convertSchema(parameterSchema(param), false)
+			if param["collectionFormat"] == "multi" {
+				converted["explode"] = true
+			}
+			parameters = append(parameters, converted)
+		}
+	}
+	if len(parameters) > 0 {
+		out["parameters"] = parameters
+	}
+	if formProperties != nil {
+		schema := map[string]any{"type": "object", "properties": formProperties}
+		if len(formRequired) > 0 {
+			schema["required"] = formRequired
+		}
+		formTypes := slices.DeleteFunc(slices.Clone(consumes), func(t string) bool {
+			return t != "multipart/form-data" && t != "application/x-www-form-urlencoded"
+		})
+		if len(formTypes) == 0 {
+			formTypes = []string{"application/x-www-form-urlencoded"}
+		}
+		body := map[string]any{"content": make(map[string]any)}
+		for _, t := range formTypes {
+			body["content"].(map[string]any)[t] = map[string]any{"schema": schema}
+		}
+		out["requestBody"] = body
+	}
+
+	responses := make(map[string]any)
+	for code, r := range asMap(op["responses"]) {
+		response := asMap(r)
+		converted := map[string]any{"description": response["description"]}
+		if schema, ok := response["schema"]; ok {
+			types := produces
+			if strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5") {
+				types = errorTypes
+			}
+			converted["content"] = content(schema, types)
+		}
+		if headers := asMap(response["headers"]); len(headers) > 0 {
+			convertedHeaders := make(map[string]any, len(headers))
+			for name, h := range headers {
+				header := asMap(h)
+				convertedHeader := map[string]any{"schema": convertSchema(parameterSchema(header), false)}
+				if description, ok := header["description"]; ok {
+					convertedHeader["description"] = description
+				}
+				convertedHeaders[name] = convertedHeader
+			}
+			converted["headers"] = convertedHeaders
+		}
+		responses[code] = converted
+	}
+	if len(responses) == 0 {
+		return nil, errors.New("operation has no responses")
+	}
+	out["responses"] = responses
+	return out, nil
+}
+
+// content describes a body of the schema in each of the content types
+func content(schema any, types []string) map[string]any {
+	out := make(map[string]any, len(types))
+	for _, t := range types {
+		out[t] = map[string]any{"schema": convertSchema(schema, slices.Contains(binaryTypes, t))}
+	}
+	return out
+}
+
+// parameterSchema returns the schema of a non-body parameter or header, whose schema keywords Swagger 2.0 inlines
+func parameterSchema(param map[string]any) map[string]any {
+	schema := make(map[string]any)
+	for _, key := range []string{"type", "format", "items", "enum", "default", "minimum", "maximum", "pattern",
+		"minLength", "maxLength", "minItems", "maxItems", "uniqueItems", "exclusiveMinimum", "exclusiveMaximum"} {
+		if v, ok := param[key]; ok {
+			schema[key] = v
+		}
+	}
+	return schema
+}
+
+// convertSchema converts a schema, rewriting references to definitions as references to component schemas. Files,
+// and byte arrays in binary bodies, become binary strings.
+func convertSchema(schema any, binary bool) any {
+	m, ok := schema.(map[string]any)
+	if !ok {
+		return schema
+	}
+	if m["type"] == "file" || (binary && m["type"] == "array" && asMap(m["items"])["type"] == "integer") {
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+
+	out := make(map[string]any, len(m))
+	for key, v := range m {
+		switch key {
+		case "$ref":
+			ref, _ := v.(string)
+			out[key] = strings.Replace(ref, "#/definitions/", "#/components/schemas/", 1)
+		case "x-nullable":
+			out["nullable"] = v
+		case "properties", "definitions":
+			properties := make(map[string]any)
+			for name, p := range asMap(v) {
+				properties[name] = convertSchema(p, false)
+			}
+			out[key] = properties
+		case "items", "additionalProperties", "not":
+			out[key] = convertSchema(v, false)
+		case "allOf", "anyOf", "oneOf":
+			var schemas []any
+			for _, s := range asSlice(v) {
+				schemas = append(schemas, convertSchema(s, false))
+			}
+			out[key] = schemas
+		default:
+			out[key] = v
+		}
+	}
+	return out
+}
+
+func convertSecurityScheme(scheme map[string]any) map[string]any {
+	out := make(map[string]any)
+	if description, ok := scheme["description"]; ok {
+		out["description"] = description
+	}
+	switch scheme["type"] {
+	case "basic":
+		out["type"], out["scheme"] = "http", "basic"
+	case "apiKey":
+		out["type"], out["in"], out["name"] = "apiKey", scheme["in"], scheme["name"]
+	case "oauth2":
+		flow := map[string]any{"scopes": scheme["scopes"]}
+		if flow["scopes"] == nil {
+			flow["scopes"] = map[string]any{}
+		}
+		for _, key := range []string{"authorizationUrl", "tokenUrl"} {
+			if v, ok := scheme[key]; ok {
+				flow[key] = v
+			}
+		}
+		flows := map[string]string{
+			"implicit":    "implicit",
+			"password":    "password",
+			"application": "clientCredentials",
+			"accessCode":  "authorizationCode",
+		}
+		flowName, _ := scheme["flow"].(string)
+		out["type"], out["flows"] = "oauth2", map[string]any{flows[flowName]: flow}
+	}
+	return out
+}
+
+func mediaTypes(v any) []string {
+	var types []string
+	for _, t := range asSlice(v) {
+		if s, ok := t.(string); ok {
+			types = append(types, s)
+		}
+	}
+	return types
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func asSlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
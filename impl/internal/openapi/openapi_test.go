@@ -0,0 +1,126 @@
+package openapi
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConvert(t *testing.T) {
+	swagger := `
+swagger: "2.0"
+info:
+  title: Test
+paths:
+  /{id}:
+    get:
+      produces:
+      - application/octet-stream
+      parameters:
+      - in: path
+        name: id
+        required: true
+        type: string
+      - in: query
+        name: pageSize
+        type: integer
+      responses:
+        "200":
+          description: OK
+          schema:
+            type: array
+            items:
+              type: integer
+          headers:
+            Retry-After:
+              type: integer
+        "404":
+          description: Not found
+          schema:
+            type: string
+    put:
+      consumes:
+      - application/json
+      parameters:
+      - in: body
+        name: request
+        required: true
+        schema:
+          $ref: '#/definitions/Record'
+      responses:
+        "200":
+          description: OK
+definitions:
+  Record:
+    type: object
+    properties:
+      sig:
+        type: array
+        items:
+          type: integer
+      next:
+        $ref: '#/definitions/Record'
+securityDefinitions:
+  Token:
+    type: apiKey
+    in: header
+    name: Authorization
+`
+	converted, err := Convert([]byte(swagger))
+	require.NoError(t, err)
+
+	var doc map[string]any
+	require.NoError(t, yaml.Unmarshal(converted, &doc))
+	assert.Equal(t, Version, doc["openapi"])
+	assert.Equal(t, map[string]any{"title": "Test"}, doc["info"])
+
+	get := asMap(asMap(asMap(doc["paths"])["/{id}"])["get"])
+	assert.Equal(t, []any{
+		map[string]any{"in": "path", "name": "id", "required": true, "schema": map[string]any{"type": "string"}},
+		map[string]any{"in": "query", "name": "pageSize", "schema": map[string]any{"type": "integer"}},
+	}, get["parameters"])
+	ok := asMap(asMap(get["responses"])["200"])
+	assert.Equal(t, map[string]any{
+		"application/octet-stream": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+	}, ok["content"])
+	assert.Equal(t, map[string]any{"Retry-After": map[string]any{"schema": map[string]any{"type": "integer"}}}, ok["headers"])
+	assert.Equal(t, map[string]any{
+		"application/json": map[string]any{"schema": map[string]any{"type": "string"}},
+	}, asMap(asMap(get["responses"])["404"])["content"])
+
+	put := asMap(asMap(asMap(doc["paths"])["/{id}"])["put"])
+	assert.Equal(t, map[string]any{
+		"required": true,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Record"}},
+		},
+	}, put["requestBody"])
+	assert.NotContains(t, asMap(asMap(put["responses"])["200"]), "content")
+
+	components := asMap(doc["components"])
+	record := asMap(asMap(components["schemas"])["Record"])
+	// byte arrays outside binary bodies stay arrays, as they're base64 encoded in JSON
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"type": "integer"}}, asMap(record["properties"])["sig"])
+	assert.Equal(t, map[string]any{"$ref": "#/components/schemas/Record"}, asMap(record["properties"])["next"])
+	assert.Equal(t, map[string]any{"type": "apiKey", "in": "header", "name": "Authorization"},
+		asMap(components["securitySchemes"])["Token"])
+
+	_, err = Convert([]byte(`openapi: 3.0.3`))
+	assert.Error(t, err)
+}
+
+// TestDocsUpToDate checks the OpenAPI document served by the gateway was converted from the current Swagger document.
+// Run `mage spec` to regenerate both.
+func TestDocsUpToDate(t *testing.T) {
+	swagger, err := os.ReadFile("../../docs/swagger.yaml")
+	require.NoError(t, err)
+	openAPI, err := os.ReadFile("../../docs/openapi.yaml")
+	require.NoError(t, err)
+
+	converted, err := Convert(swagger)
+	require.NoError(t, err)
+	assert.Equal(t, string(converted), string(openAPI), "docs/openapi.yaml is out of date, run `mage spec`")
+}
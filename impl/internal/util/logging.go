@@ -1,6 +1,8 @@
 package util
 
 import (
+	"context"
+
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -31,3 +33,36 @@ func (h *TraceHook) Fire(entry *logrus.Entry) error {
 
 	return nil
 }
+
+// RequestIDField is the field of log entries holding the ID of the request they were logged while serving
+const RequestIDField = "request_id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context carrying the ID of the request it serves
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request the context serves, or an empty string if it doesn't serve one
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDHook is a logrus hook that adds the request ID of an entry's context to the entry
+type RequestIDHook struct{}
+
+func (h *RequestIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *RequestIDHook) Fire(entry *logrus.Entry) error {
+	if id := RequestID(entry.Context); id != "" {
+		entry.Data[RequestIDField] = id
+	}
+	return nil
+}
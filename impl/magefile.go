@@ -18,6 +18,8 @@ import (
 	"github.com/magefile/mage/sh"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/term"
+
+	"github.com/TBD54566975/did-dht/internal/openapi"
 )
 
 const (
@@ -106,7 +108,7 @@ func Lint() error {
 	return sh.Run("golangci-lint", "run")
 }
 
-// Spec generates an OpenAPI spec yaml based on code annotations.
+// Spec generates Swagger 2.0 and OpenAPI 3 spec yamls based on code annotations.
 func Spec() error {
 	swagCommand := "swag"
 	if err := installIfNotPresent(swagCommand, "github.com/swaggo/swag/v2/cmd/swag@v2.0.0-rc3"); err != nil {
@@ -125,7 +127,23 @@ func Spec() error {
 		return err
 	}
 
-	return sh.Run(swagCommand, "init", "-g", "cmd/main.go", "--overridesFile", "docs/overrides.swaggo", "--pd", "--parseInternal", "-ot", "yaml")
+	if err := sh.Run(swagCommand, "init", "-g", "cmd/main.go", "--overridesFile", "docs/overrides.swaggo", "--pd", "--parseInternal", "-ot", "yaml"); err != nil {
+		logrus.WithError(err).Error("failed to generate swagger docs")
+		return err
+	}
+
+	// swag generates Swagger 2.0, which is converted to the OpenAPI 3 document the service serves
+	swagger, err := os.ReadFile("docs/swagger.yaml")
+	if err != nil {
+		logrus.WithError(err).Error("failed to read swagger docs")
+		return err
+	}
+	openAPI, err := openapi.Convert(swagger)
+	if err != nil {
+		logrus.WithError(err).Error("failed to convert swagger docs to openapi")
+		return err
+	}
+	return os.WriteFile("docs/openapi.yaml", openAPI, 0644)
 }
 
 func ColorizeTestOutput(w io.Writer) io.Writer {
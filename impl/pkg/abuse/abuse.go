@@ -0,0 +1,268 @@
+// Package abuse scores the records published to a gateway for signs of spam and abuse, deciding whether each is
+// accepted, flagged for review, held for review, or rejected. Scorers are pluggable: register one under a name, and
+// list the name in the gateway's abuse config.
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// defaultReviewQueueSize is the most records listed for review when the config sets no limit
+const defaultReviewQueueSize = 1000
+
+// Action is what the filter does with a record
+type Action string
+
+// the actions the filter takes, from least to most severe
+const (
+	// ActionAccept publishes the record
+	ActionAccept Action = "accept"
+	// ActionFlag publishes the record, listing it for review
+	ActionFlag Action = "flag"
+	// ActionShadow holds the record for review rather than publishing it, though its publisher is told it was accepted
+	ActionShadow Action = "shadow"
+	// ActionReject rejects the record
+	ActionReject Action = "reject"
+)
+
+// Scorer scores how likely a record published to the gateway is abuse. Scorers are called concurrently.
+type Scorer interface {
+	// Score returns the record's score, from 0 for no sign of abuse to 1, and the reason for a score over 0
+	Score(ctx context.Context, record dht.BEP44Record) (float64, string)
+}
+
+// Factory returns a scorer configured by the abuse config
+type Factory func(cfg config.AbuseConfig) (Scorer, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+func init() {
+	Register("prefix_rate", newPrefixRate)
+	Register("entropy", newEntropy)
+	Register("endpoints", newEndpoints)
+}
+
+// Register makes a scorer available under the given name. Scorers outside this package register themselves from an
+// init function, so importing the scorer's package is enough to make its name available. Register panics if the name
+// is empty, the factory is nil, or a scorer is already registered under the name.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if name == "" {
+		panic("abuse: Register name is empty")
+	}
+	if factory == nil {
+		panic("abuse: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("abuse: Register called twice for scorer " + name)
+	}
+	factories[name] = factory
+}
+
+// Scorers returns the sorted names of the registered scorers
+func Scorers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns the factory of the scorer registered under the given name
+func lookup(name string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Verdict is the filter's decision on a record: the action taken, the record's total score, and the reasons each
+// scorer gave for its part of the score
+type Verdict struct {
+	Action  Action
+	Score   float64
+	Reasons []string
+}
+
+// Review is a record listed for review, because it was flagged or held
+type Review struct {
+	ID  string `json:"id"`
+	Seq int64  `json:"seq"`
+	// Action is flag for a record that was published, or shadow for one held until it's approved
+	Action  Action   `json:"action"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+	// Listed is when the record was listed for review
+	Listed time.Time `json:"listed"`
+	// Record is the record held until it's approved
+	Record dht.BEP44Record `json:"-"`
+}
+
+// Filter scores the records published to the gateway with the configured scorers, acting on their total score, and
+// keeps the queue of records flagged or held for review. The queue is in memory, so held records are lost when the
+// gateway restarts.
+type Filter struct {
+	names   []string
+	scorers []Scorer
+	cfg     config.AbuseConfig
+
+	mu sync.Mutex
+	// reviews holds the records listed for review by ID, and order their IDs, oldest listed first
+	reviews map[string]*Review
+	order   []string
+}
+
+// NewFilter returns a filter scoring records with the scorers named in the config, or nil if none are named
+func NewFilter(cfg config.AbuseConfig) (*Filter, error) {
+	if len(cfg.Scorers) == 0 {
+		return nil, nil
+	}
+	switch {
+	case cfg.ReviewQueueSize == 0:
+		cfg.ReviewQueueSize = defaultReviewQueueSize
+	case cfg.ReviewQueueSize < 0:
+		return nil, fmt.Errorf("invalid review queue size %d: must not be negative", cfg.ReviewQueueSize)
+	}
+	for _, threshold := range []float64{cfg.FlagScore, cfg.ShadowScore, cfg.RejectScore} {
+		if threshold < 0 {
+			return nil, fmt.Errorf("invalid abuse score threshold %g: must not be negative", threshold)
+		}
+	}
+
+	f := &Filter{cfg: cfg, reviews: make(map[string]*Review)}
+	for _, name := range cfg.Scorers {
+		factory, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown abuse scorer %q: registered scorers are %s", name, strings.Join(Scorers(), ", "))
+		}
+		scorer, err := factory(cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create abuse scorer %s", name)
+		}
+		f.names = append(f.names, name)
+		f.scorers = append(f.scorers, scorer)
+	}
+	return f, nil
+}
+
+// Check scores the record, listing it for review if it's flagged or held, and returns the filter's verdict
+func (f *Filter) Check(ctx context.Context, record dht.BEP44Record) Verdict {
+	verdict := Verdict{Action: ActionAccept}
+	for i, scorer := range f.scorers {
+		score, reason := scorer.Score(ctx, record)
+		score = min(max(score, 0), 1)
+		if score == 0 {
+			continue
+		}
+		verdict.Score += score
+		verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("%s %.2f: %s", f.names[i], score, reason))
+	}
+
+	switch {
+	case over(verdict.Score, f.cfg.RejectScore):
+		verdict.Action = ActionReject
+	case over(verdict.Score, f.cfg.ShadowScore):
+		verdict.Action = ActionShadow
+	case over(verdict.Score, f.cfg.FlagScore):
+		verdict.Action = ActionFlag
+	default:
+		return verdict
+	}
+	if verdict.Action != ActionReject {
+		f.list(record, verdict)
+	}
+	return verdict
+}
+
+// over reports whether the score reaches the threshold, which is never reached if zero
+func over(score, threshold float64) bool {
+	return threshold > 0 && score >= threshold
+}
+
+// list lists the record for review, replacing any earlier listing of the record and dropping the oldest listing if
+// the queue is full
+func (f *Filter) list(record dht.BEP44Record, verdict Verdict) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := record.ID()
+	if _, ok := f.reviews[id]; ok {
+		f.remove(id)
+	}
+	for len(f.order) >= f.cfg.ReviewQueueSize {
+		dropped := f.reviews[f.order[0]]
+		f.remove(dropped.ID)
+		logrus.WithField("record_id", dropped.ID).WithField("action", dropped.Action).Warn("review queue is full, dropped the oldest record listed")
+	}
+	f.reviews[id] = &Review{
+		ID:      id,
+		Seq:     record.SequenceNumber,
+		Action:  verdict.Action,
+		Score:   verdict.Score,
+		Reasons: verdict.Reasons,
+		Listed:  time.Now(),
+		Record:  record,
+	}
+	f.order = append(f.order, id)
+}
+
+// remove removes the listing of the record with the given ID; f.mu must be held
+func (f *Filter) remove(id string) {
+	delete(f.reviews, id)
+	if i := slices.Index(f.order, id); i >= 0 {
+		f.order = slices.Delete(f.order, i, i+1)
+	}
+}
+
+// Reviews returns the records listed for review, oldest listed first
+func (f *Filter) Reviews() []Review {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reviews := make([]Review, 0, len(f.order))
+	for _, id := range f.order {
+		reviews = append(reviews, *f.reviews[id])
+	}
+	return reviews
+}
+
+// Take removes the record with the given ID from the review queue, returning its listing and whether it was listed
+func (f *Filter) Take(id string) (Review, bool) {
+	if f == nil {
+		return Review{}, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	review, ok := f.reviews[id]
+	if !ok {
+		return Review{}, false
+	}
+	f.remove(id)
+	return *review, true
+}
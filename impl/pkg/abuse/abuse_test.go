@@ -0,0 +1,249 @@
+package abuse
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// fixedScorer scores every record the same
+type fixedScorer float64
+
+func (s fixedScorer) Score(context.Context, dht.BEP44Record) (float64, string) {
+	return float64(s), "fixed"
+}
+
+// didRecord returns the record publishing a new DID with the given services
+func didRecord(t *testing.T, services ...didsdk.Service) dht.BEP44Record {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{Services: services})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	put, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	return dht.RecordFromBEP44(put)
+}
+
+// txtRecord returns the record publishing a Pkarr packet with a TXT record of the given strings
+func txtRecord(t *testing.T, txt ...string) dht.BEP44Record {
+	_, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	msg := dns.Msg{Answer: []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: "_data.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 7200},
+		Txt: txt,
+	}}}
+	put, err := dht.CreateDNSPublishRequest(sk, msg)
+	require.NoError(t, err)
+	return dht.RecordFromBEP44(put)
+}
+
+func TestRegister(t *testing.T) {
+	assert.Subset(t, Scorers(), []string{"endpoints", "entropy", "prefix_rate"})
+
+	// scorers are registered for the life of the process, so the test only registers its own once
+	if !slices.Contains(Scorers(), "test_fixed") {
+		Register("test_fixed", func(config.AbuseConfig) (Scorer, error) { return fixedScorer(0.7), nil })
+	}
+	assert.Contains(t, Scorers(), "test_fixed")
+	assert.Panics(t, func() { Register("test_fixed", func(config.AbuseConfig) (Scorer, error) { return nil, nil }) })
+	assert.Panics(t, func() { Register("", func(config.AbuseConfig) (Scorer, error) { return nil, nil }) })
+	assert.Panics(t, func() { Register("test_nil", nil) })
+
+	f, err := NewFilter(config.AbuseConfig{Scorers: []string{"test_fixed"}, FlagScore: 0.5})
+	require.NoError(t, err)
+	verdict := f.Check(context.Background(), didRecord(t))
+	assert.Equal(t, ActionFlag, verdict.Action)
+	assert.Equal(t, []string{"test_fixed 0.70: fixed"}, verdict.Reasons)
+}
+
+func TestNewFilter(t *testing.T) {
+	f, err := NewFilter(config.AbuseConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, f)
+	assert.Empty(t, f.Reviews())
+
+	_, err = NewFilter(config.AbuseConfig{Scorers: []string{"crystal_ball"}})
+	assert.ErrorContains(t, err, `unknown abuse scorer "crystal_ball"`)
+
+	_, err = NewFilter(config.AbuseConfig{Scorers: []string{"entropy"}, EntropyBitsPerByte: 8})
+	assert.ErrorContains(t, err, "invalid entropy threshold")
+
+	_, err = NewFilter(config.AbuseConfig{Scorers: []string{"prefix_rate"}, PrefixLength: 53})
+	assert.ErrorContains(t, err, "invalid prefix length")
+
+	_, err = NewFilter(config.AbuseConfig{Scorers: []string{"entropy"}, RejectScore: -1})
+	assert.ErrorContains(t, err, "invalid abuse score threshold")
+}
+
+func TestFilter(t *testing.T) {
+	ctx := context.Background()
+	newFilter := func(score float64, queueSize int) *Filter {
+		return &Filter{
+			names:   []string{"fixed"},
+			scorers: []Scorer{fixedScorer(score)},
+			cfg:     config.AbuseConfig{FlagScore: 0.5, ShadowScore: 1, RejectScore: 2, ReviewQueueSize: queueSize},
+			reviews: make(map[string]*Review),
+		}
+	}
+
+	t.Run("actions", func(t *testing.T) {
+		tests := []struct {
+			score  float64
+			action Action
+		}{
+			{0, ActionAccept},
+			{0.4, ActionAccept},
+			{0.5, ActionFlag},
+			{1, ActionShadow},
+		}
+		for _, test := range tests {
+			assert.Equal(t, test.action, newFilter(test.score, 10).Check(ctx, didRecord(t)).Action, test.score)
+		}
+
+		// scores, each capped at 1, add up to a rejection
+		f := newFilter(1, 10)
+		f.names = append(f.names, "fixed again")
+		f.scorers = append(f.scorers, fixedScorer(5))
+		verdict := f.Check(ctx, didRecord(t))
+		assert.Equal(t, ActionReject, verdict.Action)
+		assert.Equal(t, 2.0, verdict.Score, "scores are capped at 1")
+		assert.Len(t, verdict.Reasons, 2)
+		assert.Empty(t, f.Reviews(), "rejected records aren't listed")
+	})
+
+	t.Run("zero thresholds never act", func(t *testing.T) {
+		f := newFilter(1, 10)
+		f.cfg = config.AbuseConfig{ReviewQueueSize: 10}
+		assert.Equal(t, ActionAccept, f.Check(ctx, didRecord(t)).Action)
+	})
+
+	t.Run("review queue", func(t *testing.T) {
+		f := newFilter(1, 2)
+		first, second, third := didRecord(t), didRecord(t), didRecord(t)
+		f.Check(ctx, first)
+		f.Check(ctx, second)
+
+		reviews := f.Reviews()
+		require.Len(t, reviews, 2)
+		assert.Equal(t, first.ID(), reviews[0].ID)
+		assert.Equal(t, ActionShadow, reviews[0].Action)
+		assert.Equal(t, first, reviews[0].Record)
+
+		// listing a record again moves it to the back, and a full queue drops the oldest
+		f.Check(ctx, first)
+		f.Check(ctx, third)
+		reviews = f.Reviews()
+		require.Len(t, reviews, 2)
+		assert.Equal(t, first.ID(), reviews[0].ID)
+		assert.Equal(t, third.ID(), reviews[1].ID)
+
+		review, ok := f.Take(first.ID())
+		require.True(t, ok)
+		assert.Equal(t, first.SequenceNumber, review.Seq)
+		_, ok = f.Take(first.ID())
+		assert.False(t, ok)
+		assert.Len(t, f.Reviews(), 1)
+	})
+}
+
+func TestPrefixRate(t *testing.T) {
+	ctx := context.Background()
+	scorer, err := newPrefixRate(config.AbuseConfig{PrefixPutsPerMinute: 2})
+	require.NoError(t, err)
+	s := scorer.(*prefixRate)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	record := didRecord(t)
+	for range 2 {
+		score, _ := s.Score(ctx, record)
+		assert.Zero(t, score)
+	}
+	score, reason := s.Score(ctx, record)
+	assert.Equal(t, 0.5, score)
+	assert.Contains(t, reason, "3 records with key prefix "+record.ID()[:4])
+	score, _ = s.Score(ctx, record)
+	assert.Equal(t, 1.0, score)
+
+	// records under other prefixes count separately
+	other := didRecord(t)
+	for other.ID()[:4] == record.ID()[:4] {
+		other = didRecord(t)
+	}
+	score, _ = s.Score(ctx, other)
+	assert.Zero(t, score)
+
+	// counts start over each minute
+	now = now.Add(time.Minute)
+	score, _ = s.Score(ctx, record)
+	assert.Zero(t, score)
+}
+
+func TestEntropy(t *testing.T) {
+	ctx := context.Background()
+	s, err := newEntropy(config.AbuseConfig{})
+	require.NoError(t, err)
+
+	score, _ := s.Score(ctx, didRecord(t, didsdk.Service{ID: "web", Type: "LinkedDomains", ServiceEndpoint: []string{"https://example.com"}}))
+	assert.Zero(t, score, "did documents are under the threshold")
+
+	score, _ = s.Score(ctx, txtRecord(t, "too short to measure"))
+	assert.Zero(t, score)
+
+	// TXT strings are held in presentation format, with bytes escaped as \DDD
+	var txt []string
+	for range 3 {
+		random := make([]byte, 200)
+		_, err = rand.Read(random)
+		require.NoError(t, err)
+		var escaped strings.Builder
+		for _, b := range random {
+			fmt.Fprintf(&escaped, "\\%03d", b)
+		}
+		txt = append(txt, escaped.String())
+	}
+	score, reason := s.Score(ctx, txtRecord(t, txt...))
+	assert.Greater(t, score, 0.5)
+	assert.Contains(t, reason, "bits of entropy per byte")
+}
+
+func TestEndpoints(t *testing.T) {
+	ctx := context.Background()
+	s, err := newEndpoints(config.AbuseConfig{BlockedEndpoints: []string{"Spam.example."}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		endpoints []string
+		score     float64
+	}{
+		{[]string{"https://example.com"}, 0},
+		{[]string{"https://spam.example/offer"}, 1},
+		{[]string{"https://example.com", "https://cdn.spam.example"}, 1},
+		{[]string{"https://notspam.example"}, 0},
+	}
+	for _, test := range tests {
+		record := didRecord(t, didsdk.Service{ID: "web", Type: "LinkedDomains", ServiceEndpoint: test.endpoints})
+		score, reason := s.Score(ctx, record)
+		assert.Equal(t, test.score, score, test.endpoints)
+		if test.score > 0 {
+			assert.Contains(t, reason, "names blocked host spam.example")
+		}
+	}
+
+	score, _ := s.Score(ctx, txtRecord(t, "https://spam.example"))
+	assert.Zero(t, score, "records that publish no did aren't scored")
+}
@@ -0,0 +1,253 @@
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/miekg/dns"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+const (
+	// defaultPrefixLength is how many characters of the z-base-32 key prefix_rate groups records by when the config
+	// sets none
+	defaultPrefixLength = 4
+	// defaultPrefixPutsPerMinute is how many records with the same key prefix prefix_rate allows a minute when the
+	// config sets no limit
+	defaultPrefixPutsPerMinute = 30
+	// keyLength is the length of a z-base-32 encoded key, the longest prefix
+	keyLength = 52
+	// prefixWindow is the window prefix_rate counts records over
+	prefixWindow = time.Minute
+
+	// defaultEntropyBitsPerByte is the entropy the entropy scorer allows when the config sets none, above the 6 bits per
+	// byte of the base64 encoded keys DID documents are made up of
+	defaultEntropyBitsPerByte = 6.5
+	// minEntropySample is the least TXT data the entropy scorer measures; shorter samples can't reach high entropy
+	minEntropySample = 128
+)
+
+// prefixRate scores records by how many records with the same key prefix were published in the current minute, to
+// catch campaigns grinding keys for a prefix or publishing one key over and over
+type prefixRate struct {
+	length int
+	limit  int
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+	now    func() time.Time
+}
+
+func newPrefixRate(cfg config.AbuseConfig) (Scorer, error) {
+	s := &prefixRate{
+		length: cfg.PrefixLength,
+		limit:  cfg.PrefixPutsPerMinute,
+		counts: make(map[string]int),
+		now:    time.Now,
+	}
+	if s.length == 0 {
+		s.length = defaultPrefixLength
+	}
+	if s.limit == 0 {
+		s.limit = defaultPrefixPutsPerMinute
+	}
+	if s.length < 0 || s.length > keyLength {
+		return nil, fmt.Errorf("invalid prefix length %d: must be between 1 and %d", s.length, keyLength)
+	}
+	if s.limit < 0 {
+		return nil, fmt.Errorf("invalid prefix puts per minute %d: must not be negative", s.limit)
+	}
+	return s, nil
+}
+
+// Score counts the record against its key prefix, scoring it by how far over the limit the prefix is, reaching 1 at
+// twice the limit
+func (s *prefixRate) Score(_ context.Context, record dht.BEP44Record) (float64, string) {
+	prefix := record.ID()[:s.length]
+
+	s.mu.Lock()
+	if window := s.now().Truncate(prefixWindow); !window.Equal(s.window) {
+		s.window = window
+		clear(s.counts)
+	}
+	s.counts[prefix]++
+	count := s.counts[prefix]
+	s.mu.Unlock()
+
+	if count <= s.limit {
+		return 0, ""
+	}
+	return float64(count-s.limit) / float64(s.limit), fmt.Sprintf("%d records with key prefix %s this minute", count, prefix)
+}
+
+// entropy scores records by the Shannon entropy of their TXT data, to catch records smuggling compressed or encrypted
+// payloads. DID documents are made up of base64 encoded keys and text, which stay under 6 bits per byte.
+type entropy struct {
+	threshold float64
+}
+
+func newEntropy(cfg config.AbuseConfig) (Scorer, error) {
+	threshold := cfg.EntropyBitsPerByte
+	if threshold == 0 {
+		threshold = defaultEntropyBitsPerByte
+	}
+	if threshold < 0 || threshold >= 8 {
+		return nil, fmt.Errorf("invalid entropy threshold %g bits per byte: must be between 0 and 8", threshold)
+	}
+	return &entropy{threshold: threshold}, nil
+}
+
+// Score scores the record by how far the entropy of its TXT data is over the threshold, reaching 1 at 8 bits per byte
+func (s *entropy) Score(_ context.Context, record dht.BEP44Record) (float64, string) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(record.Value); err != nil {
+		return 0, ""
+	}
+	var data []byte
+	for _, rr := range msg.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			for _, str := range txt.Txt {
+				data = appendUnescaped(data, str)
+			}
+		}
+	}
+	if len(data) < minEntropySample {
+		return 0, ""
+	}
+	bits := shannonEntropy(data)
+	if bits <= s.threshold {
+		return 0, ""
+	}
+	return (bits - s.threshold) / (8 - s.threshold), fmt.Sprintf("txt data has %.2f bits of entropy per byte", bits)
+}
+
+// appendUnescaped appends the bytes of a TXT string, which is held in presentation format with bytes escaped as \DDD
+// or \X
+func appendUnescaped(data []byte, str string) []byte {
+	for i := 0; i < len(str); i++ {
+		switch {
+		case str[i] != '\\' || i+1 == len(str):
+			data = append(data, str[i])
+		case i+3 < len(str) && isDigits(str[i+1:i+4]):
+			n, _ := strconv.Atoi(str[i+1 : i+4])
+			data = append(data, byte(n))
+			i += 3
+		default:
+			data = append(data, str[i+1])
+			i++
+		}
+	}
+	return data
+}
+
+// isDigits reports whether the string is made up of decimal digits
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// shannonEntropy returns the Shannon entropy of the data in bits per byte
+func shannonEntropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// endpoints scores DIDs naming a service endpoint on a blocked host 1, to catch documents advertising known spam and
+// phishing sites
+type endpoints struct {
+	blocked []string
+}
+
+func newEndpoints(cfg config.AbuseConfig) (Scorer, error) {
+	blocked := make([]string, 0, len(cfg.BlockedEndpoints))
+	for _, host := range cfg.BlockedEndpoints {
+		if host = strings.ToLower(strings.Trim(host, ". ")); host != "" {
+			blocked = append(blocked, host)
+		}
+	}
+	return &endpoints{blocked: blocked}, nil
+}
+
+// Score scores the record 1 if it publishes a DID with a service endpoint on a blocked host or its subdomains. Records
+// that publish no DID aren't scored.
+func (s *endpoints) Score(_ context.Context, record dht.BEP44Record) (float64, string) {
+	if len(s.blocked) == 0 || len(record.Salt) > 0 {
+		return 0, ""
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(record.Value); err != nil {
+		return 0, ""
+	}
+	doc, err := did.DHT(did.Prefix + ":" + record.ID()).FromDNSPacket(msg)
+	if err != nil {
+		return 0, ""
+	}
+	for _, service := range doc.Doc.Services {
+		for _, endpoint := range endpointURIs(service) {
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				continue
+			}
+			if host := s.blockedHost(strings.ToLower(u.Hostname())); host != "" {
+				return 1, fmt.Sprintf("service %s names blocked host %s", service.ID, host)
+			}
+		}
+	}
+	return 0, ""
+}
+
+// blockedHost returns the blocked host that the host is or is a subdomain of, or empty if it isn't blocked
+func (s *endpoints) blockedHost(host string) string {
+	for _, blocked := range s.blocked {
+		if host == blocked || strings.HasSuffix(host, "."+blocked) {
+			return blocked
+		}
+	}
+	return ""
+}
+
+// endpointURIs returns the URIs of a service's endpoint, which is a URI, a map, or a list of them. Maps aren't URIs.
+func endpointURIs(service didsdk.Service) []string {
+	switch se := service.ServiceEndpoint.(type) {
+	case string:
+		return []string{se}
+	case []string:
+		return se
+	case []any:
+		var uris []string
+		for _, e := range se {
+			if uri, ok := e.(string); ok {
+				uris = append(uris, uri)
+			}
+		}
+		return uris
+	default:
+		return nil
+	}
+}
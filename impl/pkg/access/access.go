@@ -0,0 +1,149 @@
+// Package access decides which clients and DIDs a gateway serves by its access lists: the DIDs and client IP ranges
+// it denies, and in allowlist mode the only ones it allows. Every blocked operation is logged as an audit entry.
+package access
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+)
+
+// List holds the gateway's access lists, which can be replaced with Reload while they're checked
+type List struct {
+	rules atomic.Pointer[rules]
+}
+
+// rules are the parsed access lists of a config. DIDs are held by their z-base-32 encoded suffixes.
+type rules struct {
+	deniedDIDs   map[string]struct{}
+	deniedCIDRs  []netip.Prefix
+	allowlist    bool
+	allowedDIDs  map[string]struct{}
+	allowedCIDRs []netip.Prefix
+}
+
+// NewList returns the access lists of the config
+func NewList(cfg config.AccessConfig) (*List, error) {
+	l := new(List)
+	if err := l.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload replaces the access lists with those of the config, leaving them as they were if the config is invalid
+func (l *List) Reload(cfg config.AccessConfig) error {
+	r := rules{allowlist: cfg.Allowlist}
+	var err error
+	if r.deniedDIDs, err = parseDIDs(cfg.DeniedDIDs); err != nil {
+		return errors.Wrap(err, "invalid denied dids")
+	}
+	if r.deniedCIDRs, err = parseCIDRs(cfg.DeniedCIDRs); err != nil {
+		return errors.Wrap(err, "invalid denied cidrs")
+	}
+	if r.allowedDIDs, err = parseDIDs(cfg.AllowedDIDs); err != nil {
+		return errors.Wrap(err, "invalid allowed dids")
+	}
+	if r.allowedCIDRs, err = parseCIDRs(cfg.AllowedCIDRs); err != nil {
+		return errors.Wrap(err, "invalid allowed cidrs")
+	}
+	if r.allowlist && len(r.allowedDIDs) == 0 && len(r.allowedCIDRs) == 0 {
+		return errors.New("allowlist mode requires allowed dids or allowed cidrs")
+	}
+	l.rules.Store(&r)
+	return nil
+}
+
+// parseDIDs returns the set of suffixes of the DIDs, which are given as DIDs or suffixes
+func parseDIDs(dids []string) (map[string]struct{}, error) {
+	suffixes := make(map[string]struct{}, len(dids))
+	for _, id := range dids {
+		id = strings.TrimSpace(id)
+		if !strings.HasPrefix(id, did.Prefix+":") {
+			id = did.Prefix + ":" + id
+		}
+		if !did.DHT(id).IsValid() {
+			return nil, fmt.Errorf("%q is not a did:dht did or suffix", id)
+		}
+		suffix, _ := did.DHT(id).Suffix()
+		suffixes[suffix] = struct{}{}
+	}
+	return suffixes, nil
+}
+
+// parseCIDRs returns the prefixes of the CIDRs, taking a bare address as the prefix of that address alone
+func parseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if addr, err := netip.ParseAddr(cidr); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or cidr", cidr)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// AllowIP reports whether the client with the given IP is served, logging an audit entry of the operation it's
+// refused if not. A client whose IP can't be parsed is only refused when clients are allowlisted.
+func (l *List) AllowIP(ctx context.Context, operation, ip string) bool {
+	r := l.rules.Load()
+	addr, err := netip.ParseAddr(ip)
+	addr = addr.Unmap()
+	for _, prefix := range r.deniedCIDRs {
+		if err == nil && prefix.Contains(addr) {
+			audit(ctx, operation, "ip", ip, "denied cidr "+prefix.String())
+			return false
+		}
+	}
+	if !r.allowlist || len(r.allowedCIDRs) == 0 {
+		return true
+	}
+	for _, prefix := range r.allowedCIDRs {
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	audit(ctx, operation, "ip", ip, "not in allowed cidrs")
+	return false
+}
+
+// AllowDID reports whether the DID with the given record ID, the z-base-32 encoded key optionally followed by '.' and
+// an encoded salt, is published and resolved, logging an audit entry of the operation it's refused if not. Salted
+// records are allowed or denied with the DID of their key.
+func (l *List) AllowDID(ctx context.Context, operation, id string) bool {
+	r := l.rules.Load()
+	suffix, _, _ := strings.Cut(id, ".")
+	if _, denied := r.deniedDIDs[suffix]; denied {
+		audit(ctx, operation, "record_id", id, "denied did")
+		return false
+	}
+	if !r.allowlist || len(r.allowedDIDs) == 0 {
+		return true
+	}
+	if _, allowed := r.allowedDIDs[suffix]; allowed {
+		return true
+	}
+	audit(ctx, operation, "record_id", id, "not in allowed dids")
+	return false
+}
+
+// audit logs an audit entry of an operation the access lists refused, and the rule refusing it
+func audit(ctx context.Context, operation, field, value, rule string) {
+	logrus.WithContext(ctx).WithField("audit", "access").WithField("operation", operation).WithField(field, value).
+		WithField("rule", rule).Warn("access lists refused operation")
+}
@@ -0,0 +1,128 @@
+package access
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+)
+
+// newDID returns the suffix of a new DID
+func newDID(t *testing.T) string {
+	_, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	suffix, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+	return suffix
+}
+
+func TestNewList(t *testing.T) {
+	l, err := NewList(config.AccessConfig{})
+	require.NoError(t, err)
+	assert.True(t, l.AllowIP(context.Background(), "GET /:id", "203.0.113.1"))
+	assert.True(t, l.AllowDID(context.Background(), "resolve", newDID(t)))
+
+	_, err = NewList(config.AccessConfig{DeniedDIDs: []string{"did:web:example.com"}})
+	assert.ErrorContains(t, err, "invalid denied dids")
+	_, err = NewList(config.AccessConfig{AllowedDIDs: []string{"notz32"}})
+	assert.ErrorContains(t, err, "invalid allowed dids")
+	_, err = NewList(config.AccessConfig{DeniedCIDRs: []string{"203.0.113.0/33"}})
+	assert.ErrorContains(t, err, "invalid denied cidrs")
+	_, err = NewList(config.AccessConfig{AllowedCIDRs: []string{"example.com"}})
+	assert.ErrorContains(t, err, "invalid allowed cidrs")
+	_, err = NewList(config.AccessConfig{Allowlist: true})
+	assert.ErrorContains(t, err, "allowlist mode requires allowed dids or allowed cidrs")
+}
+
+func TestAllowIP(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		cfg     config.AccessConfig
+		allowed map[string]bool
+	}{
+		{
+			name: "denylist",
+			cfg:  config.AccessConfig{DeniedCIDRs: []string{"203.0.113.0/24", "2001:db8::1"}},
+			allowed: map[string]bool{
+				"203.0.113.7":          false,
+				"::ffff:203.0.113.7":   false,
+				"198.51.100.1":         true,
+				"2001:db8::1":          false,
+				"2001:db8::2":          true,
+				"not an ip":            true,
+				"":                     true,
+				"2001:db8:0:0:0:0:0:1": false,
+			},
+		},
+		{
+			name: "allowlist",
+			cfg: config.AccessConfig{
+				Allowlist:    true,
+				AllowedCIDRs: []string{"10.0.0.0/8"},
+				DeniedCIDRs:  []string{"10.0.0.13"},
+			},
+			allowed: map[string]bool{
+				"10.1.2.3":     true,
+				"10.0.0.13":    false,
+				"198.51.100.1": false,
+				"not an ip":    false,
+			},
+		},
+		{
+			name: "allowlist of dids leaves clients unrestricted",
+			cfg:  config.AccessConfig{Allowlist: true, AllowedDIDs: []string{newDID(t)}},
+			allowed: map[string]bool{
+				"198.51.100.1": true,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := NewList(test.cfg)
+			require.NoError(t, err)
+			for ip, allowed := range test.allowed {
+				assert.Equal(t, allowed, l.AllowIP(ctx, "GET /:id", ip), ip)
+			}
+		})
+	}
+}
+
+func TestAllowDID(t *testing.T) {
+	ctx := context.Background()
+	denied, allowed, other := newDID(t), newDID(t), newDID(t)
+
+	l, err := NewList(config.AccessConfig{DeniedDIDs: []string{did.Prefix + ":" + denied}})
+	require.NoError(t, err)
+	assert.False(t, l.AllowDID(ctx, "publish", denied))
+	assert.False(t, l.AllowDID(ctx, "publish", denied+".c2FsdA"), "salted records go with the did of their key")
+	assert.True(t, l.AllowDID(ctx, "publish", other))
+
+	l, err = NewList(config.AccessConfig{Allowlist: true, AllowedDIDs: []string{allowed, denied}, DeniedDIDs: []string{denied}})
+	require.NoError(t, err)
+	assert.True(t, l.AllowDID(ctx, "resolve", allowed))
+	assert.False(t, l.AllowDID(ctx, "resolve", denied), "denied dids are denied even when allowed")
+	assert.False(t, l.AllowDID(ctx, "resolve", other))
+
+	l, err = NewList(config.AccessConfig{Allowlist: true, AllowedCIDRs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	assert.True(t, l.AllowDID(ctx, "resolve", other), "an allowlist of clients leaves dids unrestricted")
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	id := newDID(t)
+	l, err := NewList(config.AccessConfig{DeniedDIDs: []string{id}})
+	require.NoError(t, err)
+
+	assert.Error(t, l.Reload(config.AccessConfig{DeniedCIDRs: []string{"not a cidr"}}))
+	assert.False(t, l.AllowDID(ctx, "resolve", id), "invalid configs leave the lists as they were")
+
+	require.NoError(t, l.Reload(config.AccessConfig{DeniedCIDRs: []string{"203.0.113.0/24"}}))
+	assert.True(t, l.AllowDID(ctx, "resolve", id))
+	assert.False(t, l.AllowIP(ctx, "GET /:id", "203.0.113.1"))
+}
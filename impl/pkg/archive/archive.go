@@ -0,0 +1,290 @@
+// Package archive snapshots every stored record, along with its history, to object storage and restores snapshots
+// into a storage backend, so losing a gateway's database doesn't lose the records it retains.
+//
+// # Snapshot format
+//
+// Each snapshot is a gzip compressed JSON Lines object at snapshots/<UTC time as 20060102T150405Z>.jsonl.gz,
+// relative to the archive URI. The first line is a header:
+//
+//	{"format":"did-dht-snapshot","version":1,"createdAt":"2024-01-02T03:04:05Z"}
+//
+// and every following line is one record, with its stored versions oldest first:
+//
+//	{"record":{"k":"...","v":"...","sig":"...","seq":1704164645,"salt":"..."},"versions":[{"k":"...",...}]}
+//
+// The k, v, sig, and salt fields are unpadded base64url encoded; salt is omitted for unsalted records. Once a
+// snapshot is completely written, the object snapshots/latest is replaced with the snapshot's key, so a partially
+// written snapshot is never restored by default.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/config"
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+const (
+	// Format identifies snapshot objects in their header
+	Format = "did-dht-snapshot"
+	// Version is the version of the snapshot format written by this package
+	Version = 1
+
+	snapshotDir = "snapshots/"
+	// LatestKey is the key of the object holding the key of the newest complete snapshot
+	LatestKey = snapshotDir + "latest"
+
+	snapshotTimeFormat = "20060102T150405Z"
+	listPageSize       = 1000
+)
+
+var encoding = base64.RawURLEncoding
+
+// Header is the first line of a snapshot
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Entry is a line of a snapshot holding one record and its stored versions
+type Entry struct {
+	Record   Record   `json:"record"`
+	Versions []Record `json:"versions,omitempty"`
+}
+
+// Record is a BEP44 record as written in a snapshot
+type Record struct {
+	K    string `json:"k"`
+	V    string `json:"v"`
+	Sig  string `json:"sig"`
+	Seq  int64  `json:"seq"`
+	Salt string `json:"salt,omitempty"`
+}
+
+func encodeRecord(r dht.BEP44Record) Record {
+	return Record{
+		K:    encoding.EncodeToString(r.Key[:]),
+		V:    encoding.EncodeToString(r.Value),
+		Sig:  encoding.EncodeToString(r.Signature[:]),
+		Seq:  r.SequenceNumber,
+		Salt: encoding.EncodeToString(r.Salt),
+	}
+}
+
+// Decode returns the archived record, verifying its signature
+func (r Record) Decode() (*dht.BEP44Record, error) {
+	k, err := encoding.DecodeString(r.K)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 key field: %v", err)
+	}
+	v, err := encoding.DecodeString(r.V)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 value field: %v", err)
+	}
+	sig, err := encoding.DecodeString(r.Sig)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 sig field: %v", err)
+	}
+	salt, err := encoding.DecodeString(r.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 salt field: %v", err)
+	}
+	return dht.NewSaltedBEP44Record(k, v, sig, salt, r.Seq)
+}
+
+// Archiver periodically snapshots a gateway's storage to a bucket
+type Archiver struct {
+	bucket    Bucket
+	db        storage.Storage
+	scheduler dhtint.Scheduler
+}
+
+// NewArchiver returns an archiver snapshotting the given storage on the configured schedule, or nil if archiving
+// isn't configured
+func NewArchiver(cfg config.ArchiveConfig, db storage.Storage) (*Archiver, error) {
+	if cfg.URI == "" {
+		return nil, nil
+	}
+	bucket, err := OpenBucket(cfg.URI)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archiver{bucket: bucket, db: db, scheduler: dhtint.NewScheduler()}
+	if err = a.scheduler.Schedule(cfg.CRON, a.snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to schedule archiving")
+	}
+	return a, nil
+}
+
+// Close stops taking snapshots
+func (a *Archiver) Close() {
+	if a == nil {
+		return
+	}
+	a.scheduler.Stop()
+}
+
+func (a *Archiver) snapshot() {
+	ctx, span := telemetry.GetTracer().Start(context.Background(), "Archiver.snapshot")
+	defer span.End()
+
+	start := time.Now()
+	key, count, err := Snapshot(ctx, a.db, a.bucket)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("failed to archive records")
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"key":      key,
+		"records":  count,
+		"duration": time.Since(start),
+	}).Info("archived records")
+}
+
+// Snapshot writes every record in the storage, with its history, to a new snapshot in the bucket and marks it as the
+// latest. It returns the snapshot's key and the number of records written.
+func Snapshot(ctx context.Context, db storage.Storage, bucket Bucket) (string, int, error) {
+	now := time.Now().UTC()
+	key := snapshotDir + now.Format(snapshotTimeFormat) + ".jsonl.gz"
+
+	// stream the snapshot into the bucket as it is read from storage
+	pr, pw := io.Pipe()
+	count := make(chan int, 1)
+	go func() {
+		n, err := writeSnapshot(ctx, db, pw, now)
+		count <- n
+		_ = pw.CloseWithError(err)
+	}()
+	if err := bucket.Put(ctx, key, pr); err != nil {
+		_ = pr.CloseWithError(err)
+		<-count
+		return "", 0, errors.Wrapf(err, "failed to write snapshot %s", key)
+	}
+	n := <-count
+
+	if err := bucket.Put(ctx, LatestKey, strings.NewReader(key)); err != nil {
+		return "", 0, errors.Wrap(err, "failed to mark latest snapshot")
+	}
+	return key, n, nil
+}
+
+func writeSnapshot(ctx context.Context, db storage.Storage, w io.Writer, createdAt time.Time) (int, error) {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(Header{Format: Format, Version: Version, CreatedAt: createdAt}); err != nil {
+		return 0, err
+	}
+
+	var count int
+	var nextPageToken []byte
+	for {
+		records, next, err := db.ListRecords(ctx, nextPageToken, listPageSize)
+		if err != nil {
+			return count, errors.Wrap(err, "failed to list records")
+		}
+		for _, record := range records {
+			versions, err := db.ListRecordVersions(ctx, record.ID())
+			if err != nil {
+				return count, errors.Wrapf(err, "failed to list versions of record %s", record.ID())
+			}
+			entry := Entry{Record: encodeRecord(record)}
+			for _, version := range versions {
+				entry.Versions = append(entry.Versions, encodeRecord(version))
+			}
+			if err = enc.Encode(entry); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if next == nil {
+			break
+		}
+		nextPageToken = next
+	}
+	return count, gz.Close()
+}
+
+// Restore writes every record and version in a snapshot to the storage, returning the number of records restored.
+// An empty key restores the latest snapshot. Records already in the storage are overwritten.
+func Restore(ctx context.Context, bucket Bucket, key string, db storage.Storage) (int, error) {
+	if key == "" {
+		latest, err := bucket.Get(ctx, LatestKey)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to find the latest snapshot")
+		}
+		keyBytes, err := io.ReadAll(latest)
+		_ = latest.Close()
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to find the latest snapshot")
+		}
+		key = strings.TrimSpace(string(keyBytes))
+	}
+
+	object, err := bucket.Get(ctx, key)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open snapshot %s", key)
+	}
+	defer object.Close()
+	gz, err := gzip.NewReader(object)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read snapshot %s", key)
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	var header Header
+	if err = dec.Decode(&header); err != nil {
+		return 0, errors.Wrapf(err, "failed to read snapshot %s header", key)
+	}
+	if header.Format != Format || header.Version != Version {
+		return 0, fmt.Errorf("unsupported snapshot %s: format %q version %d", key, header.Format, header.Version)
+	}
+
+	var count int
+	for dec.More() {
+		var entry Entry
+		if err = dec.Decode(&entry); err != nil {
+			return count, errors.Wrapf(err, "failed to read snapshot %s", key)
+		}
+		if err = restoreEntry(ctx, db, entry); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func restoreEntry(ctx context.Context, db storage.Storage, entry Entry) error {
+	record, err := entry.Record.Decode()
+	if err != nil {
+		return errors.Wrap(err, "invalid archived record")
+	}
+	if err = db.WriteRecord(ctx, *record); err != nil {
+		return errors.Wrapf(err, "failed to restore record %s", record.ID())
+	}
+	for _, archived := range entry.Versions {
+		version, err := archived.Decode()
+		if err != nil {
+			return errors.Wrapf(err, "invalid archived version of record %s", record.ID())
+		}
+		if err = db.WriteRecordVersion(ctx, *version); err != nil {
+			return errors.Wrapf(err, "failed to restore version %d of record %s", version.SequenceNumber, record.ID())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+func newTestStorage(t *testing.T) storage.Storage {
+	db, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "diddht.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	src := newTestStorage(t)
+
+	// one record with two versions, and one without any history
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	first, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	second := *first
+	second.Seq++
+	second.Sign(sk)
+	require.NoError(t, src.WriteRecordVersion(ctx, dht.RecordFromBEP44(first)))
+	require.NoError(t, src.WriteRecordVersion(ctx, dht.RecordFromBEP44(&second)))
+	require.NoError(t, src.WriteRecord(ctx, dht.RecordFromBEP44(&second)))
+
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+	require.NoError(t, src.WriteRecord(ctx, dht.RecordFromBEP44(salted)))
+
+	bucket, err := OpenBucket("file://" + t.TempDir())
+	require.NoError(t, err)
+	key, count, err := Snapshot(ctx, src, bucket)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.True(t, strings.HasPrefix(key, "snapshots/"))
+
+	t.Run("restores the latest snapshot", func(t *testing.T) {
+		dst := newTestStorage(t)
+		restored, err := Restore(ctx, bucket, "", dst)
+		require.NoError(t, err)
+		assert.Equal(t, 2, restored)
+
+		got, err := dst.ReadRecord(ctx, dht.RecordFromBEP44(&second).ID())
+		require.NoError(t, err)
+		assert.Equal(t, second.Seq, got.SequenceNumber)
+
+		versions, err := dst.ListRecordVersions(ctx, got.ID())
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, first.Seq, versions[0].SequenceNumber)
+
+		got, err = dst.ReadRecord(ctx, dht.RecordFromBEP44(salted).ID())
+		require.NoError(t, err)
+		assert.Equal(t, []byte("salt"), got.Salt)
+	})
+
+	t.Run("restores a snapshot by key", func(t *testing.T) {
+		restored, err := Restore(ctx, bucket, key, newTestStorage(t))
+		require.NoError(t, err)
+		assert.Equal(t, 2, restored)
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := io.WriteString(gz, `{"format":"something-else","version":1}`+"\n")
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		require.NoError(t, bucket.Put(ctx, "snapshots/other.jsonl.gz", &buf))
+
+		_, err = Restore(ctx, bucket, "snapshots/other.jsonl.gz", newTestStorage(t))
+		assert.ErrorContains(t, err, `format "something-else"`)
+	})
+
+	t.Run("missing snapshot", func(t *testing.T) {
+		_, err := Restore(ctx, bucket, "snapshots/missing.jsonl.gz", newTestStorage(t))
+		assert.ErrorContains(t, err, "failed to open snapshot")
+	})
+}
+
+func TestOpenBucket(t *testing.T) {
+	_, err := OpenBucket("s3://bucket/prefix?endpoint=localhost:9000&insecure=true")
+	assert.NoError(t, err)
+
+	_, err = OpenBucket("s3:///prefix")
+	assert.ErrorContains(t, err, "must name a bucket")
+
+	_, err = OpenBucket("ftp://host/path")
+	assert.ErrorContains(t, err, "unsupported archive type ftp")
+}
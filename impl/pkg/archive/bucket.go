@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+const defaultS3Endpoint = "s3.amazonaws.com"
+
+// Bucket is the object storage snapshots are written to and restored from
+type Bucket interface {
+	// Put writes the object with the given key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the object with the given key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// OpenBucket opens the bucket at the given URI, which is either s3://bucket/prefix for S3-compatible object storage
+// or file:///path for a local directory.
+//
+// S3 credentials are read from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables. The optional
+// endpoint, region, and insecure query parameters select another S3-compatible service, such as
+// s3://bucket/prefix?endpoint=localhost:9000&insecure=true for a local MinIO server.
+func OpenBucket(uri string) (Bucket, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3":
+		return newS3Bucket(u)
+	case "file":
+		return fileBucket(u.Path), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive type %s (from uri %s)", u.Scheme, uri)
+	}
+}
+
+// s3Bucket stores objects under a prefix of an S3 bucket
+type s3Bucket struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Bucket(u *url.URL) (*s3Bucket, error) {
+	if u.Host == "" {
+		return nil, errors.New("s3 archive uri must name a bucket")
+	}
+	query := u.Query()
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = defaultS3Endpoint
+	}
+	var insecure bool
+	if rawInsecure := query.Get("insecure"); rawInsecure != "" {
+		var err error
+		if insecure, err = strconv.ParseBool(rawInsecure); err != nil {
+			return nil, errors.Wrapf(err, "invalid s3 insecure %q", rawInsecure)
+		}
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewEnvAWS(),
+		Secure: !insecure,
+		Region: query.Get("region"),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create s3 client")
+	}
+	return &s3Bucket{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+func (b *s3Bucket) Put(ctx context.Context, key string, r io.Reader) error {
+	// an unknown size streams the object as a multipart upload
+	_, err := b.client.PutObject(ctx, b.bucket, path.Join(b.prefix, key), r, -1, minio.PutObjectOptions{})
+	return err
+}
+
+func (b *s3Bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := b.client.GetObject(ctx, b.bucket, path.Join(b.prefix, key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// the request is only made once the object is read or stat'ed, so surface a missing object here
+	if _, err = object.Stat(); err != nil {
+		_ = object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
+// fileBucket stores objects as files under a local directory
+type fileBucket string
+
+func (b fileBucket) Put(_ context.Context, key string, r io.Reader) error {
+	name := filepath.Join(string(b), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a failed write never replaces an existing object
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-"+filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (b fileBucket) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(b), filepath.FromSlash(key)))
+}
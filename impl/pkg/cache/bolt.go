@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket holds the entries of a Bolt cache, each value prefixed with its expiry as big-endian Unix nanoseconds
+const boltBucket = "cache"
+
+// Bolt is a Cache keeping its entries in a bolt file, as the cold tier of a Tiered cache. Entries outlive restarts of
+// the gateway until they expire; expired entries are never returned, and are swept from the file as entries are set.
+type Bolt struct {
+	db *bolt.DB
+	// mu guards lastSweep
+	mu        sync.Mutex
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+var _ Cache = (*Bolt)(nil)
+
+// NewBolt returns a cache keeping its entries in the bolt file at the given path, which is created if it doesn't exist
+func NewBolt(path string) (*Bolt, error) {
+	if path == "" {
+		return nil, errors.New("path is required")
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(boltBucket))
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db, now: time.Now}, nil
+}
+
+func (b *Bolt) Get(_ context.Context, key string) ([]byte, error) {
+	value, _, err := b.get(key)
+	return value, err
+}
+
+// get returns the value cached under the key and when it expires, or nil if there is none or it has expired
+func (b *Bolt) get(key string) ([]byte, time.Time, error) {
+	var value []byte
+	var expires time.Time
+	err := b.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket([]byte(boltBucket)).Get([]byte(key))
+		if len(stored) < 8 {
+			return nil
+		}
+		expires = time.Unix(0, int64(binary.BigEndian.Uint64(stored)))
+		if b.now().Before(expires) {
+			value = append([]byte(nil), stored[8:]...)
+		}
+		return nil
+	})
+	return value, expires, err
+}
+
+func (b *Bolt) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.setEntries([]*entry{{key: key, value: value, expires: b.now().Add(ttl)}})
+}
+
+// setEntries caches each of the entries until it expires, in one transaction
+func (b *Bolt) setEntries(entries []*entry) error {
+	now := b.now()
+	b.mu.Lock()
+	sweep := now.Sub(b.lastSweep) >= sweepInterval
+	if sweep {
+		b.lastSweep = now
+	}
+	b.mu.Unlock()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(boltBucket))
+		if sweep {
+			if err := sweepBolt(bucket, now); err != nil {
+				return err
+			}
+		}
+		for _, e := range entries {
+			stored := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(e.value)), uint64(e.expires.UnixNano()))
+			if err := bucket.Put([]byte(e.key), append(stored, e.value...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) Delete(_ context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(boltBucket)).Delete([]byte(key))
+	})
+}
+
+// Len returns the number of entries in the cache, including expired entries that haven't been swept yet
+func (b *Bolt) Len() int {
+	var n int
+	_ = b.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte(boltBucket)).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// sweepBolt deletes the entries of the bucket that have expired
+func sweepBolt(bucket *bolt.Bucket, now time.Time) error {
+	var expired [][]byte
+	if err := bucket.ForEach(func(k, v []byte) error {
+		if len(v) < 8 || !now.Before(time.Unix(0, int64(binary.BigEndian.Uint64(v)))) {
+			expired = append(expired, k)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// Package cache holds resolved records, kept in an in-memory LRU for a single gateway, optionally spilling over to a
+// bolt file, or in redis for gateway replicas sharing their cache.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory cache drops its expired entries
+const sweepInterval = time.Minute
+
+// Cache holds values by key until they expire
+type Cache interface {
+	// Get returns the value cached under the key, or nil if there is none or it has expired
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set caches the value under the key until the TTL passes, replacing any value already cached under it
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete drops the value cached under the key; deleting a key that isn't cached is a no-op
+	Delete(ctx context.Context, key string) error
+	Close() error
+}
+
+// NewCache returns a cache kept in the redis database at the given redis:// or rediss:// URI, or an in-memory LRU
+// holding up to maxBytes of keys and values for an empty URI, where zero or less is no limit. With a cold path, the
+// in-memory LRU is the hot tier of a Tiered cache spilling over to a bolt file at the path.
+func NewCache(uri string, maxBytes int, coldPath string) (Cache, error) {
+	if uri == "" {
+		if coldPath == "" {
+			return NewMemory(maxBytes), nil
+		}
+		cold, err := NewBolt(coldPath)
+		if err != nil {
+			return nil, err
+		}
+		return NewTiered(NewMemory(maxBytes), cold), nil
+	}
+	if coldPath != "" {
+		return nil, errors.New("a cold tier is only supported for the in-memory cache")
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return NewRedis(uri)
+	default:
+		return nil, fmt.Errorf("unsupported cache scheme: %s", u.Scheme)
+	}
+}
+
+// Memory is a Cache holding its entries in memory, evicting the least recently used entries once its entries take up
+// more than its size limit
+type Memory struct {
+	mu sync.Mutex
+	// entries holds the element of each key in lru, which is ordered from most to least recently used
+	entries   map[string]*list.Element
+	lru       *list.List
+	size      int
+	maxBytes  int
+	lastSweep time.Time
+	now       func() time.Time
+	// spill is given the entries evicted to keep the cache under its size limit, once the cache is unlocked; nil
+	// drops them
+	spill func(ctx context.Context, evicted []*entry) error
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (e *entry) size() int {
+	return len(e.key) + len(e.value)
+}
+
+var _ Cache = (*Memory)(nil)
+
+// NewMemory returns an in-memory cache holding up to maxBytes of keys and values, where zero or less is no limit
+func NewMemory(maxBytes int) *Memory {
+	return &Memory{
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		maxBytes: maxBytes,
+		now:      time.Now,
+	}
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	e := elem.Value.(*entry)
+	if !m.now().Before(e.expires) {
+		m.remove(elem)
+		return nil, nil
+	}
+	m.lru.MoveToFront(elem)
+	return e.value, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	evicted, err := m.set(key, value, ttl)
+	if err != nil {
+		return err
+	}
+	if len(evicted) > 0 && m.spill != nil {
+		return m.spill(ctx, evicted)
+	}
+	return nil
+}
+
+// set caches the value under the key, returning the entries evicted to make room for it
+func (m *Memory) set(key string, value []byte, ttl time.Duration) ([]*entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now)
+		m.lastSweep = now
+	}
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	e := &entry{key: key, value: value, expires: now.Add(ttl)}
+	if m.maxBytes > 0 && e.size() > m.maxBytes {
+		return nil, fmt.Errorf("cache entry of %d bytes is larger than the cache", e.size())
+	}
+	m.entries[key] = m.lru.PushFront(e)
+	m.size += e.size()
+	var evicted []*entry
+	for m.maxBytes > 0 && m.size > m.maxBytes {
+		evicted = append(evicted, m.remove(m.lru.Back()))
+	}
+	return evicted, nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	return nil
+}
+
+// Len returns the number of entries in the cache, including expired entries that haven't been dropped yet
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lru.Len()
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// remove drops an entry from the cache, returning it
+func (m *Memory) remove(elem *list.Element) *entry {
+	e := m.lru.Remove(elem).(*entry)
+	delete(m.entries, e.key)
+	m.size -= e.size()
+	return e
+}
+
+// sweep drops the entries that have expired
+func (m *Memory) sweep(now time.Time) {
+	for elem := m.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if !now.Before(elem.Value.(*entry).expires) {
+			m.remove(elem)
+		}
+		elem = next
+	}
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(0)
+	now := time.Unix(1715578000, 0)
+	m.now = func() time.Time { return now }
+	testCache(t, m, func(d time.Duration) { now = now.Add(d) })
+
+	t.Run("evicts the least recently used entries", func(t *testing.T) {
+		ctx := context.Background()
+		// each entry takes up 10 bytes
+		m := NewMemory(30)
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, m.Set(ctx, key, []byte("012345678"), time.Minute))
+		}
+		got, err := m.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+
+		require.NoError(t, m.Set(ctx, "d", []byte("012345678"), time.Minute))
+		assert.Equal(t, 3, m.Len())
+		got, err = m.Get(ctx, "b")
+		require.NoError(t, err)
+		assert.Nil(t, got)
+		for _, key := range []string{"a", "c", "d"} {
+			got, err = m.Get(ctx, key)
+			require.NoError(t, err)
+			assert.NotNil(t, got, key)
+		}
+
+		assert.Error(t, m.Set(ctx, "e", make([]byte, 30), time.Minute))
+	})
+
+	t.Run("sweeps expired entries", func(t *testing.T) {
+		ctx := context.Background()
+		m := NewMemory(0)
+		now := time.Unix(1715578000, 0)
+		m.now = func() time.Time { return now }
+		require.NoError(t, m.Set(ctx, "a", []byte("value"), time.Second))
+		now = now.Add(sweepInterval)
+		require.NoError(t, m.Set(ctx, "b", []byte("value"), time.Minute))
+		assert.Equal(t, 1, m.Len())
+	})
+}
+
+func TestRedis(t *testing.T) {
+	uri := os.Getenv("TEST_REDIS")
+	if uri == "" {
+		t.SkipNow()
+	}
+	r, err := NewRedis(uri)
+	require.NoError(t, err)
+	defer r.Close()
+	testCache(t, r, time.Sleep)
+}
+
+func TestBolt(t *testing.T) {
+	b, err := NewBolt(filepath.Join(t.TempDir(), "cache.db"))
+	require.NoError(t, err)
+	defer b.Close()
+	now := time.Unix(1715578000, 0)
+	b.now = func() time.Time { return now }
+	testCache(t, b, func(d time.Duration) { now = now.Add(d) })
+
+	t.Run("sweeps expired entries", func(t *testing.T) {
+		ctx := context.Background()
+		require.NoError(t, b.Set(ctx, "a", []byte("value"), time.Second))
+		now = now.Add(sweepInterval)
+		require.NoError(t, b.Set(ctx, "b", []byte("value"), time.Minute))
+		assert.Equal(t, 1, b.Len())
+	})
+}
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+	newTiered := func(t *testing.T, maxBytes int) *Tiered {
+		cold, err := NewBolt(filepath.Join(t.TempDir(), "cache.db"))
+		require.NoError(t, err)
+		tiered := NewTiered(NewMemory(maxBytes), cold)
+		t.Cleanup(func() { tiered.Close() })
+		return tiered
+	}
+	testCache(t, newTiered(t, 0), time.Sleep)
+
+	t.Run("evicted entries spill over to the cold tier and are promoted when read", func(t *testing.T) {
+		// each entry takes up 10 bytes, so the hot tier holds two
+		tiered := newTiered(t, 20)
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, tiered.Set(ctx, key, []byte("value-"+key+"12"), time.Minute))
+		}
+		assert.Equal(t, 2, tiered.hot.Len())
+		assert.Equal(t, 1, tiered.cold.Len())
+
+		got, err := tiered.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value-a12"), got)
+		// a was promoted, evicting b
+		assert.Equal(t, 2, tiered.hot.Len())
+		assert.Equal(t, 1, tiered.cold.Len())
+		got, err = tiered.cold.Get(ctx, "b")
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+
+		got, err = tiered.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+		got, err = tiered.Get(ctx, "missing")
+		require.NoError(t, err)
+		assert.Nil(t, got)
+		assert.Equal(t, TierStats{HotHits: 1, ColdHits: 1, Misses: 1}, tiered.Stats())
+	})
+
+	t.Run("sets and deletes replace entries in the cold tier", func(t *testing.T) {
+		// each entry takes up 10 bytes, so the hot tier holds two
+		tiered := newTiered(t, 20)
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, tiered.Set(ctx, key, []byte("value-"+key+"12"), time.Minute))
+		}
+		require.NoError(t, tiered.Set(ctx, "a", []byte("newer-a12"), time.Minute))
+		assert.Equal(t, 1, tiered.cold.Len())
+		got, err := tiered.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("newer-a12"), got)
+
+		require.NoError(t, tiered.Delete(ctx, "b"))
+		got, err = tiered.Get(ctx, "b")
+		require.NoError(t, err)
+		assert.Nil(t, got)
+	})
+}
+
+func TestNewCache(t *testing.T) {
+	c, err := NewCache("", 0, "")
+	require.NoError(t, err)
+	assert.IsType(t, &Memory{}, c)
+
+	c, err = NewCache("", 0, filepath.Join(t.TempDir(), "cache.db"))
+	require.NoError(t, err)
+	assert.IsType(t, &Tiered{}, c)
+	require.NoError(t, c.Close())
+
+	_, err = NewCache("redis://localhost", 0, filepath.Join(t.TempDir(), "cache.db"))
+	assert.ErrorContains(t, err, "cold tier is only supported")
+
+	_, err = NewCache("memcached://localhost", 0, "")
+	assert.ErrorContains(t, err, "unsupported cache scheme")
+}
+
+// testCache runs a cache through sets, expiry, and deletes, waiting with wait
+func testCache(t *testing.T, c Cache, wait func(time.Duration)) {
+	ctx := context.Background()
+	key := t.Name() + time.Now().String()
+
+	got, err := c.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	require.NoError(t, c.Set(ctx, key, []byte("first"), time.Minute))
+	require.NoError(t, c.Set(ctx, key, []byte("second"), 100*time.Millisecond))
+	got, err = c.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), got)
+
+	wait(200 * time.Millisecond)
+	got, err = c.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	require.NoError(t, c.Set(ctx, key, []byte("third"), time.Minute))
+	require.NoError(t, c.Delete(ctx, key))
+	require.NoError(t, c.Delete(ctx, key))
+	got, err = c.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
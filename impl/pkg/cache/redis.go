@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// keyPrefix starts the key of each cached value
+const keyPrefix = "diddht:cache:"
+
+// Redis is a Cache keeping its entries in redis, so gateway replicas share them. Entries expire with redis key
+// expiry, and are evicted under the database's own memory policy.
+type Redis struct {
+	client *goredis.Client
+}
+
+var _ Cache = (*Redis)(nil)
+
+// NewRedis returns a cache keeping its entries in the redis database at the given redis:// or rediss:// URI
+func NewRedis(uri string) (*Redis, error) {
+	opts, err := goredis.ParseURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	client := goredis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("error connecting to redis: %v", err)
+	}
+	return &Redis{client: client}, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.client.Get(ctx, keyPrefix+key).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, keyPrefix+key, value, ttl).Err()
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, keyPrefix+key).Err()
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Tiered is a Cache keeping its hot entries in a Memory LRU and spilling the entries the LRU evicts over to a cold
+// Bolt tier, from which they're promoted back to the LRU when read. Each entry is in one tier at a time, so the memory
+// the cache takes is bounded by the LRU's size limit however many entries are cached.
+type Tiered struct {
+	hot  *Memory
+	cold *Bolt
+
+	hotHits  atomic.Int64
+	coldHits atomic.Int64
+	misses   atomic.Int64
+}
+
+// TierStats counts the lookups of a Tiered cache by the tier that answered them
+type TierStats struct {
+	HotHits  int64
+	ColdHits int64
+	Misses   int64
+}
+
+var _ Cache = (*Tiered)(nil)
+
+// NewTiered returns a cache with the given hot and cold tiers, which it takes ownership of
+func NewTiered(hot *Memory, cold *Bolt) *Tiered {
+	t := &Tiered{hot: hot, cold: cold}
+	hot.spill = t.spill
+	return t
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := t.hot.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		t.hotHits.Add(1)
+		return value, nil
+	}
+
+	value, expires, err := t.cold.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		t.misses.Add(1)
+		return nil, nil
+	}
+	t.coldHits.Add(1)
+
+	// promote the entry back to the hot tier, leaving it in the cold tier if it can't be moved
+	if err = t.cold.Delete(ctx, key); err != nil {
+		return value, nil
+	}
+	if err = t.hot.Set(ctx, key, value, expires.Sub(t.hot.now())); err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
+// Set caches the value in the hot tier, dropping any older value from the cold tier so it can't be served once the
+// hot entry expires
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := t.hot.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	cold, _, err := t.cold.get(key)
+	if err != nil || cold == nil {
+		return err
+	}
+	return t.cold.Delete(ctx, key)
+}
+
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	return errors.Join(t.hot.Delete(ctx, key), t.cold.Delete(ctx, key))
+}
+
+// Stats returns the counts of the cache's lookups by the tier that answered them
+func (t *Tiered) Stats() TierStats {
+	return TierStats{HotHits: t.hotHits.Load(), ColdHits: t.coldHits.Load(), Misses: t.misses.Load()}
+}
+
+func (t *Tiered) Close() error {
+	return errors.Join(t.hot.Close(), t.cold.Close())
+}
+
+// spill moves the entries evicted from the hot tier to the cold tier
+func (t *Tiered) spill(_ context.Context, evicted []*entry) error {
+	return t.cold.setEntries(evicted)
+}
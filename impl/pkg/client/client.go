@@ -0,0 +1,388 @@
+// Package client is a Go client for did:dht gateways. It resolves and publishes DIDs, lists DIDs by type, and watches
+// DIDs for updates over a gateway's HTTP API, retrying transient failures and failing over between gateways. Resolved
+// records are verified against the DID's identity key, so a gateway can't serve a record its owner didn't sign.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+const (
+	defaultAttempts     = 3
+	defaultRetryBackoff = 250 * time.Millisecond
+	defaultTimeout      = 10 * time.Second
+
+	// minRecordLength is the 64 byte signature and 8 byte sequence number preceding a record's value
+	minRecordLength = 72
+)
+
+var (
+	// ErrNotFound is returned when no gateway has the DID
+	ErrNotFound = errors.New("did not found")
+	// ErrInvalidRecord is returned when every gateway answering served a record that doesn't verify
+	ErrInvalidRecord = errors.New("gateway served an invalid record")
+)
+
+// StatusError is a gateway's response with an error status
+type StatusError struct {
+	Gateway    string
+	StatusCode int
+	// Message is the error the gateway gave, if any
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("gateway %s responded with status %d", e.Gateway, e.StatusCode)
+	}
+	return fmt.Sprintf("gateway %s responded with status %d: %s", e.Gateway, e.StatusCode, e.Message)
+}
+
+// transient returns whether the request may succeed if retried
+func (e *StatusError) transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// Config configures a client. The zero value of each field picks its default.
+type Config struct {
+	// HTTPClient sends the client's requests, http.DefaultClient by default
+	HTTPClient *http.Client
+	// Attempts is how many times a request is tried against each gateway while it fails transiently, 3 by default
+	Attempts int
+	// RetryBackoff is how long the client waits before retrying a request, doubling with each retry, 250ms by default
+	RetryBackoff time.Duration
+	// Timeout bounds each attempt of a request other than watching a DID, 10 seconds by default
+	Timeout time.Duration
+}
+
+// Client sends requests to a list of gateways, trying them in order
+type Client struct {
+	gateways     []string
+	http         *http.Client
+	attempts     int
+	retryBackoff time.Duration
+	timeout      time.Duration
+}
+
+// New returns a client for the gateways with the given base URLs, tried in the order given
+func New(gateways []string, cfg Config) (*Client, error) {
+	if len(gateways) == 0 {
+		return nil, errors.New("at least one gateway is required")
+	}
+	c := &Client{
+		gateways:     make([]string, 0, len(gateways)),
+		http:         cfg.HTTPClient,
+		attempts:     cfg.Attempts,
+		retryBackoff: cfg.RetryBackoff,
+		timeout:      cfg.Timeout,
+	}
+	for _, gateway := range gateways {
+		u, err := url.Parse(gateway)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid gateway url: %s", gateway)
+		}
+		c.gateways = append(c.gateways, strings.TrimSuffix(gateway, "/"))
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	if c.attempts <= 0 {
+		c.attempts = defaultAttempts
+	}
+	if c.retryBackoff <= 0 {
+		c.retryBackoff = defaultRetryBackoff
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+	return c, nil
+}
+
+// Resolution is a DID resolved from its record, whose signature was verified against the DID's identity key
+type Resolution struct {
+	DID      string
+	Document didsdk.Document
+	// Types are the indexed types of the DID
+	Types []int
+	// Gateways are the DID's authoritative gateways
+	Gateways []string
+	// PreviousDID is the DID this one supersedes, if any
+	PreviousDID string
+	Deactivated bool
+	// Record is the record the DID was resolved from
+	Record dht.BEP44Record
+	// Gateway is the base URL of the gateway that served the record
+	Gateway string
+}
+
+// Resolve resolves a DID. A gateway that doesn't have the DID, or serves a record that doesn't verify, is skipped for
+// the next; ErrNotFound is returned if none has it.
+func (c *Client) Resolve(ctx context.Context, id string) (*Resolution, error) {
+	suffix, err := did.DHT(id).Suffix()
+	if err != nil || !did.DHT(id).IsValid() {
+		return nil, fmt.Errorf("invalid did: %s", id)
+	}
+	key, _, err := dht.ParseRecordID(suffix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid did: %s", id)
+	}
+
+	var resolution *Resolution
+	err = c.each(ctx, func(ctx context.Context, gateway string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gateway+"/"+suffix, nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to create request")
+		}
+		body, err := c.send(gateway, req)
+		if err != nil {
+			return err
+		}
+		record, err := parseRecord(key, body)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidRecord, "%s from gateway %s", err, gateway)
+		}
+		resolution, err = resolve(id, *record)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidRecord, "%s from gateway %s", err, gateway)
+		}
+		resolution.Gateway = gateway
+		return nil
+	})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, errors.Wrap(ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resolution, nil
+}
+
+// parseRecord parses and verifies a record served as its signature, big-endian sequence number, and value
+func parseRecord(key []byte, body []byte) (*dht.BEP44Record, error) {
+	if len(body) < minRecordLength {
+		return nil, fmt.Errorf("record of %d bytes is too short", len(body))
+	}
+	seq := int64(binary.BigEndian.Uint64(body[64:minRecordLength]))
+	return dht.NewBEP44Record(key, body[minRecordLength:], body[:64], seq)
+}
+
+// resolve decodes the DID document a verified record publishes
+func resolve(id string, record dht.BEP44Record) (*Resolution, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(record.Value); err != nil {
+		return nil, errors.Wrap(err, "failed to unpack dns packet")
+	}
+	doc, err := did.DHT(id).FromDNSPacket(msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode did document")
+	}
+	resolution := Resolution{
+		DID:         id,
+		Document:    doc.Doc,
+		Deactivated: doc.Deactivated,
+		Record:      record,
+	}
+	for _, typ := range doc.Types {
+		resolution.Types = append(resolution.Types, int(typ))
+	}
+	for _, gateway := range doc.Gateways {
+		resolution.Gateways = append(resolution.Gateways, string(gateway))
+	}
+	if doc.PreviousDID != nil {
+		resolution.PreviousDID = string(doc.PreviousDID.PreviousDID)
+	}
+	return &resolution, nil
+}
+
+// Publish publishes a signed record through the first gateway that accepts it. A record the gateway rejects, as
+// invalid or older than the version it has, isn't sent to the other gateways.
+func (c *Client) Publish(ctx context.Context, put bep44.Put) error {
+	if put.K == nil {
+		return errors.New("record has no key")
+	}
+	v, ok := put.V.([]byte)
+	if !ok {
+		return errors.New("record value must be bytes")
+	}
+	id := dht.RecordID(put.K[:], put.Salt)
+
+	// sig:seq:v
+	body := make([]byte, 0, minRecordLength+len(v))
+	body = append(body, put.Sig[:]...)
+	body = binary.BigEndian.AppendUint64(body, uint64(put.Seq))
+	body = append(body, v...)
+	return c.each(ctx, func(ctx context.Context, gateway string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, gateway+"/"+id, bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "failed to create request")
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		_, err = c.send(gateway, req)
+		return err
+	})
+}
+
+// ListOptions selects a page of the DIDs of a type
+type ListOptions struct {
+	// PageSize is the most DIDs to list, up to 1000; the gateway lists 100 if zero
+	PageSize int
+	// PageToken continues the listing from the previous page, with the same UpdatedSince and Order
+	PageToken string
+	// UpdatedSince lists only DIDs updated after the given time, if not zero
+	UpdatedSince time.Time
+	// Order is the order to list DIDs in, the order they were stored by default
+	Order dht.TypeOrder
+}
+
+// Page is a page of the DIDs of a type
+type Page struct {
+	DIDs []string `json:"dids"`
+	// NextPageToken lists the next page; it is empty after the last page
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// ListByType lists a page of the DIDs of a type stored by the first gateway to answer
+func (c *Client) ListByType(ctx context.Context, typ int, opts ListOptions) (*Page, error) {
+	query := url.Values{}
+	if opts.PageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(opts.PageSize))
+	}
+	if opts.PageToken != "" {
+		query.Set("pageToken", opts.PageToken)
+	}
+	if !opts.UpdatedSince.IsZero() {
+		query.Set("updatedSince", opts.UpdatedSince.UTC().Format(time.RFC3339))
+	}
+	if opts.Order != "" {
+		query.Set("order", string(opts.Order))
+	}
+	path := "/dids/types/" + strconv.Itoa(typ)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var page Page
+	err := c.each(ctx, func(ctx context.Context, gateway string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gateway+path, nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to create request")
+		}
+		body, err := c.send(gateway, req)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(body, &page); err != nil {
+			return errors.Wrapf(err, "failed to decode page from gateway %s", gateway)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// each tries the request against each gateway in turn until one succeeds, retrying transient failures, and returns
+// the last error if none does. A gateway's definitive answer, such as rejecting the request, is returned at once.
+func (c *Client) each(ctx context.Context, try func(ctx context.Context, gateway string) error) error {
+	var err error
+	for _, gateway := range c.gateways {
+		if err = c.retry(ctx, gateway, try); !nextGateway(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// retry tries the request against a gateway until it succeeds, fails other than transiently, or runs out of attempts
+func (c *Client) retry(ctx context.Context, gateway string, try func(ctx context.Context, gateway string) error) error {
+	var err error
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err = try(attemptCtx, gateway)
+		cancel()
+		if err == nil || !transient(err) || attempt == c.attempts || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// transient returns whether a failed request may succeed if retried against the same gateway
+func transient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.transient()
+	}
+	return !errors.Is(err, ErrInvalidRecord) && !errors.Is(err, context.Canceled)
+}
+
+// nextGateway returns whether a failed request may succeed against another gateway
+func nextGateway(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.transient() || statusErr.StatusCode == http.StatusNotFound
+	}
+	return true
+}
+
+// send sends a request to a gateway, returning the body of a successful response, or a StatusError
+func (c *Client) send(gateway string, req *http.Request) ([]byte, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to reach gateway %s", gateway)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read response from gateway %s", gateway)
+	}
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return body, nil
+	}
+	return nil, &StatusError{Gateway: gateway, StatusCode: resp.StatusCode, Message: errorMessage(body)}
+}
+
+// errorMessage returns the error in the body of a gateway's error response, which is either a JSON string or an
+// object with an error property
+func errorMessage(body []byte) string {
+	var message string
+	if err := json.Unmarshal(body, &message); err == nil {
+		return message
+	}
+	var object struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &object); err == nil {
+		return object.Error
+	}
+	return ""
+}
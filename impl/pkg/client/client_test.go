@@ -0,0 +1,283 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
+	"github.com/TBD54566975/did-dht/pkg/server"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+func TestClient(t *testing.T) {
+	gateway := testGateway(t)
+	c, err := New([]string{gateway.URL}, Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	sk, doc, put := newDIDPut(t, 1)
+	require.NoError(t, c.Publish(ctx, *put))
+
+	resolution, err := c.Resolve(ctx, doc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, *doc, resolution.Document)
+	assert.Equal(t, []int{1}, resolution.Types)
+	assert.Equal(t, put.Seq, resolution.Record.SequenceNumber)
+	assert.Equal(t, gateway.URL, resolution.Gateway)
+
+	page, err := c.ListByType(ctx, 1, ListOptions{PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{doc.ID}, page.DIDs)
+	assert.Empty(t, page.NextPageToken)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	events, err := c.WatchDID(watchCtx, doc.ID)
+	require.NoError(t, err)
+	next := *put
+	next.Seq++
+	next.Sign(sk)
+	require.NoError(t, c.Publish(ctx, next))
+	select {
+	case event := <-events:
+		assert.Equal(t, dht.RecordID(put.K[:], nil), event.ID)
+		assert.Equal(t, next.Seq, event.Seq)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the new version")
+	}
+	cancel()
+	for range events {
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		_, other, _ := newDIDPut(t, 1)
+		_, err := c.Resolve(ctx, other.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		forged := *put
+		forged.Seq += 2
+		err := c.Publish(ctx, forged)
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusBadRequest, statusErr.StatusCode)
+	})
+}
+
+func TestFailover(t *testing.T) {
+	_, doc, put := newDIDPut(t, 0)
+	record := dht.RecordFromBEP44(put)
+	served := record.Response()
+
+	var unavailableCalls atomic.Int32
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unavailableCalls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	tampered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := servedBody(served)
+		body[len(body)-1] ^= 0xff
+		_, _ = w.Write(body)
+	}))
+	defer tampered.Close()
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer missing.Close()
+	var rejectedCalls atomic.Int32
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejectedCalls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "invalid record"}`))
+	}))
+	defer rejecting.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(servedBody(served))
+	}))
+	defer healthy.Close()
+
+	ctx := context.Background()
+	cfg := Config{RetryBackoff: time.Millisecond}
+
+	t.Run("skips unavailable and tampering gateways", func(t *testing.T) {
+		c, err := New([]string{unavailable.URL, tampered.URL, missing.URL, healthy.URL}, cfg)
+		require.NoError(t, err)
+		resolution, err := c.Resolve(ctx, doc.ID)
+		require.NoError(t, err)
+		assert.Equal(t, healthy.URL, resolution.Gateway)
+		assert.Equal(t, *doc, resolution.Document)
+		assert.EqualValues(t, defaultAttempts, unavailableCalls.Load())
+	})
+
+	t.Run("every record tampered with", func(t *testing.T) {
+		c, err := New([]string{tampered.URL}, cfg)
+		require.NoError(t, err)
+		_, err = c.Resolve(ctx, doc.ID)
+		assert.ErrorIs(t, err, ErrInvalidRecord)
+	})
+
+	t.Run("rejected publish isn't sent on", func(t *testing.T) {
+		c, err := New([]string{rejecting.URL, healthy.URL}, cfg)
+		require.NoError(t, err)
+		err = c.Publish(ctx, *put)
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, "invalid record", statusErr.Message)
+		assert.EqualValues(t, 1, rejectedCalls.Load())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := New(nil, cfg)
+		assert.Error(t, err)
+		_, err = New([]string{"diddht.example"}, cfg)
+		assert.Error(t, err)
+
+		c, err := New([]string{healthy.URL}, cfg)
+		require.NoError(t, err)
+		_, err = c.Resolve(ctx, "did:example:123")
+		assert.Error(t, err)
+		_, err = c.WatchDID(ctx, "did:dht:invalid")
+		assert.Error(t, err)
+	})
+}
+
+func TestLocalResolver(t *testing.T) {
+	ctx := context.Background()
+	_, doc, put := newDIDPut(t, 1)
+
+	t.Run("resolves from the dht", func(t *testing.T) {
+		simulator := dht.NewSimulator()
+		_, err := simulator.Put(ctx, *put)
+		require.NoError(t, err)
+		r := NewLocalResolver(simulator)
+		defer r.Close()
+
+		resolution, err := r.Resolve(ctx, doc.ID)
+		require.NoError(t, err)
+		assert.Equal(t, *doc, resolution.Document)
+		assert.Equal(t, []int{1}, resolution.Types)
+		assert.Equal(t, put.Seq, resolution.Record.SequenceNumber)
+		assert.Empty(t, resolution.Gateway)
+
+		_, other, _ := newDIDPut(t, 0)
+		_, err = r.Resolve(ctx, other.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = r.Resolve(ctx, "did:example:123")
+		assert.Error(t, err)
+	})
+
+	t.Run("record tampered with", func(t *testing.T) {
+		simulator := dht.NewSimulator()
+		_, err := simulator.Put(ctx, *put)
+		require.NoError(t, err)
+		r := NewLocalResolver(tamperingDHT{simulator})
+		_, err = r.Resolve(ctx, doc.ID)
+		assert.ErrorIs(t, err, ErrInvalidRecord)
+	})
+
+	t.Run("embedded node", func(t *testing.T) {
+		peer := dht.NewTestDHT(t)
+		defer peer.Close()
+		_, err := peer.Put(ctx, *put)
+		require.NoError(t, err)
+
+		r, err := StartLocalResolver(LocalConfig{
+			ListenAddr:     "127.0.0.1:0",
+			BootstrapPeers: []string{peer.Addr().String()},
+		})
+		require.NoError(t, err)
+		defer r.Close()
+		resolution, err := r.Resolve(ctx, doc.ID)
+		require.NoError(t, err)
+		assert.Equal(t, *doc, resolution.Document)
+	})
+}
+
+// tamperingDHT serves the records of a DHT with their values altered
+type tamperingDHT struct {
+	*dht.Simulator
+}
+
+func (d tamperingDHT) GetFull(ctx context.Context, key string) (*dhtint.FullGetResult, error) {
+	got, err := d.Simulator.GetFull(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	got.V = append(bencode.Bytes{}, got.V...)
+	got.V[len(got.V)-1] ^= 0xff
+	return got, nil
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"id:1\nevent:update\ndata:{\"id\":\"a\",\"seq\":1}\n\n" +
+		"event:other\ndata:{\"id\":\"b\",\"seq\":2}\n\n" +
+		"id: 3\nevent: update\ndata: {\"id\":\"a\",\ndata: \"seq\":3}\n\n"
+	var seqs []int64
+	require.NoError(t, readEvents(strings.NewReader(stream), func(event pubsub.Event) bool {
+		seqs = append(seqs, event.Seq)
+		return true
+	}))
+	assert.Equal(t, []int64{1, 3}, seqs)
+}
+
+// testGateway serves the DHT API of a gateway on a simulated DHT
+func testGateway(t *testing.T) *httptest.Server {
+	cfg := config.GetDefaultConfig()
+	db, err := storage.NewStorage("bolt://diddht-test-client.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove("diddht-test-client.db") })
+	svc, err := service.NewDHTService(&cfg, db, dht.NewSimulator())
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	require.NoError(t, server.DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+	gateway := httptest.NewServer(handler)
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+// newDIDPut generates a DID, of the given type if not zero, and its signed record
+func newDIDPut(t *testing.T, typ did.TypeIndex) (ed25519.PrivateKey, *didsdk.Document, *bep44.Put) {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	var types []did.TypeIndex
+	if typ != 0 {
+		types = []did.TypeIndex{typ}
+	}
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, types, nil, nil)
+	require.NoError(t, err)
+	put, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	return sk, doc, put
+}
+
+// servedBody returns a record as a gateway serves it: its signature, big-endian sequence number, and value
+func servedBody(r dht.BEP44Response) []byte {
+	body := append([]byte{}, r.Sig[:]...)
+	body = binary.BigEndian.AppendUint64(body, uint64(r.Seq))
+	return append(body, r.V...)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// LocalResolver resolves DIDs straight from the Mainline DHT, without any gateway, through a DHT node of its own.
+// Records are verified against the DID's identity key like those a gateway serves, so resolving needn't trust anyone
+// but the DID's owner.
+type LocalResolver struct {
+	dht dht.Client
+}
+
+// LocalConfig configures the ephemeral DHT node of a local resolver. The zero value of each field picks its default.
+type LocalConfig struct {
+	// ListenAddr is the UDP address the node listens on, a random port by default
+	ListenAddr string
+	// BootstrapPeers are the host:port addresses the node joins the DHT through, the gateway's defaults by default
+	BootstrapPeers []string
+	// Proxy is the socks5:// URL of a proxy supporting UDP the node's traffic is relayed through, none by default
+	Proxy string
+}
+
+// StartLocalResolver starts an ephemeral DHT node, which keeps no state and maps no ports, and returns a resolver
+// using it. Close the resolver to stop the node.
+func StartLocalResolver(cfg LocalConfig) (*LocalResolver, error) {
+	dhtConfig := config.GetDefaultConfig().DHTConfig
+	dhtConfig.ListenAddrs = []string{"0.0.0.0:0"}
+	if cfg.ListenAddr != "" {
+		dhtConfig.ListenAddrs = []string{cfg.ListenAddr}
+	}
+	if len(cfg.BootstrapPeers) > 0 {
+		dhtConfig.BootstrapPeers = cfg.BootstrapPeers
+	}
+	dhtConfig.StateDir = ""
+	dhtConfig.PortMapping = false
+	dhtConfig.HealthProbeIntervalSeconds = 0
+	d, err := dht.NewDHT(dhtConfig, config.ProxyConfig{URL: cfg.Proxy})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start dht node")
+	}
+	return NewLocalResolver(d), nil
+}
+
+// NewLocalResolver returns a resolver getting records from the given DHT client, which the resolver closes when it is
+// closed
+func NewLocalResolver(d dht.Client) *LocalResolver {
+	return &LocalResolver{dht: d}
+}
+
+// Resolve resolves a DID from the DHT, returning ErrNotFound if the DHT has no record of it and ErrInvalidRecord if
+// the record found doesn't verify. The resolution's Gateway is empty.
+func (r *LocalResolver) Resolve(ctx context.Context, id string) (*Resolution, error) {
+	suffix, err := did.DHT(id).Suffix()
+	if err != nil || !did.DHT(id).IsValid() {
+		return nil, fmt.Errorf("invalid did: %s", id)
+	}
+	key, _, err := dht.ParseRecordID(suffix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid did: %s", id)
+	}
+
+	got, err := r.dht.GetFull(ctx, suffix)
+	if errors.Is(err, dht.ErrNotFound) {
+		return nil, errors.Wrap(ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the value is got as the bencoded string it was put as
+	var value string
+	if err = bencode.Unmarshal(got.V, &value); err != nil {
+		return nil, errors.Wrapf(ErrInvalidRecord, "%s from the dht", err)
+	}
+	record, err := dht.NewBEP44Record(key, []byte(value), got.Sig[:], got.Seq)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidRecord, "%s from the dht", err)
+	}
+	resolution, err := resolve(id, *record)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidRecord, "%s from the dht", err)
+	}
+	return resolution, nil
+}
+
+// Close stops the resolver's DHT node
+func (r *LocalResolver) Close() {
+	r.dht.Close()
+}
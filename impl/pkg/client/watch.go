@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
+)
+
+// updateEvent is the name of the Server-Sent Events a gateway streams for a DID's new versions
+const updateEvent = "update"
+
+// WatchDID streams the DID's updates from the first gateway to accept the watch, each event a new version of the DID
+// the gateway saw. When the stream drops, the client reconnects, failing over between gateways, and skips versions it
+// already sent. The channel is closed once the context is done.
+func (c *Client) WatchDID(ctx context.Context, id string) (<-chan pubsub.Event, error) {
+	suffix, err := did.DHT(id).Suffix()
+	if err != nil || !did.DHT(id).IsValid() {
+		return nil, fmt.Errorf("invalid did: %s", id)
+	}
+	stream, err := c.watch(ctx, suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan pubsub.Event)
+	go func() {
+		defer close(events)
+		var last int64
+		for {
+			_ = readEvents(stream, func(event pubsub.Event) bool {
+				if event.Seq <= last {
+					return true
+				}
+				last = event.Seq
+				select {
+				case events <- event:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+			stream.Close()
+
+			// reconnect until a gateway accepts the watch again
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(c.retryBackoff):
+				}
+				if stream, err = c.watch(ctx, suffix); err == nil {
+					break
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// watch opens a stream of the events of the DID with the given suffix from the first gateway to accept it
+func (c *Client) watch(ctx context.Context, suffix string) (io.ReadCloser, error) {
+	var stream io.ReadCloser
+	err := c.each(ctx, func(_ context.Context, gateway string) error {
+		// the stream outlives the attempt, so is bound only by the watch's context
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gateway+"/dids/"+suffix+"/events", nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to create request")
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return errors.Wrapf(err, "failed to reach gateway %s", gateway)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return &StatusError{Gateway: gateway, StatusCode: resp.StatusCode, Message: errorMessage(body)}
+		}
+		stream = resp.Body
+		return nil
+	})
+	return stream, err
+}
+
+// readEvents reads the update events of a Server-Sent Events stream until it ends or emit returns false
+func readEvents(r io.Reader, emit func(pubsub.Event) bool) error {
+	scanner := bufio.NewScanner(r)
+	var name string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// a blank line dispatches the event
+			if name == updateEvent && len(data) > 0 {
+				var event pubsub.Event
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err == nil && !emit(event) {
+					return nil
+				}
+			}
+			name, data = "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// a comment, such as a keep-alive
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,302 @@
+package dht
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// routingTableCheckInterval is how often the routing table size is compared against the re-bootstrap threshold
+const routingTableCheckInterval = time.Minute
+
+// BootstrapSource supplies the host:port addresses of the nodes used to bootstrap the DHT
+type BootstrapSource interface {
+	BootstrapPeers(ctx context.Context) ([]string, error)
+}
+
+// StaticBootstrapSource is a fixed list of bootstrap peers
+type StaticBootstrapSource []string
+
+func (s StaticBootstrapSource) BootstrapPeers(context.Context) ([]string, error) {
+	return s, nil
+}
+
+// FileBootstrapSource reads bootstrap peers from a file with one host:port per line. Blank lines and lines
+// starting with '#' are ignored. The file is re-read on every refresh, so it can be edited while running.
+type FileBootstrapSource string
+
+func (s FileBootstrapSource) BootstrapPeers(context.Context) ([]string, error) {
+	f, err := os.Open(string(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open bootstrap peers file")
+	}
+	defer f.Close()
+	return parseBootstrapPeers(f)
+}
+
+// URLBootstrapSource fetches bootstrap peers from a remote URL serving the same format as FileBootstrapSource
+type URLBootstrapSource struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s URLBootstrapSource) BootstrapPeers(ctx context.Context) ([]string, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create bootstrap peers request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch bootstrap peers")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status fetching bootstrap peers: %s", resp.Status)
+	}
+	return parseBootstrapPeers(resp.Body)
+}
+
+func parseBootstrapPeers(r io.Reader) ([]string, error) {
+	var peers []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(line); err != nil {
+			return nil, errors.Wrapf(err, "invalid bootstrap peer %q", line)
+		}
+		peers = append(peers, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read bootstrap peers")
+	}
+	return peers, nil
+}
+
+// BootstrapSourcesFromConfig returns the bootstrap sources configured for the service, fetching any bootstrap peers
+// URL with the given client
+func BootstrapSourcesFromConfig(cfg config.DHTServiceConfig, client *http.Client) []BootstrapSource {
+	var sources []BootstrapSource
+	if len(cfg.BootstrapPeers) > 0 {
+		sources = append(sources, StaticBootstrapSource(cfg.BootstrapPeers))
+	}
+	if cfg.BootstrapPeersFile != "" {
+		sources = append(sources, FileBootstrapSource(cfg.BootstrapPeersFile))
+	}
+	if cfg.BootstrapPeersURL != "" {
+		sources = append(sources, URLBootstrapSource{URL: cfg.BootstrapPeersURL, Client: client})
+	}
+	return sources
+}
+
+// bootstrapper keeps the DHT's bootstrap peers up to date and re-bootstraps when the routing table runs low
+type bootstrapper struct {
+	d        *DHT
+	sources  []BootstrapSource
+	minNodes int
+	refresh  time.Duration
+
+	mu sync.RWMutex
+	// peers holds the last successfully loaded peers of each source, by index
+	peers [][]string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBootstrapper(d *DHT, sources []BootstrapSource, minNodes int, refresh time.Duration) *bootstrapper {
+	return &bootstrapper{
+		d:        d,
+		sources:  sources,
+		minNodes: minNodes,
+		refresh:  refresh,
+		peers:    make([][]string, len(sources)),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// load reloads every source, keeping the previous peers of any source that fails
+func (b *bootstrapper) load(ctx context.Context) error {
+	var errs []error
+	for i, source := range b.sources {
+		peers, err := source.BootstrapPeers(ctx)
+		if err != nil {
+			logrus.WithContext(ctx).WithError(err).Warn("failed to load bootstrap peers, keeping previous list")
+			errs = append(errs, err)
+			continue
+		}
+		b.mu.Lock()
+		b.peers[i] = peers
+		b.mu.Unlock()
+	}
+	if len(errs) == len(b.sources) && len(errs) > 0 {
+		return errors.Wrap(errs[0], "no bootstrap source could be loaded")
+	}
+	return nil
+}
+
+// current returns the de-duplicated union of the peers from every source
+func (b *bootstrapper) current() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	seen := make(map[string]struct{})
+	var peers []string
+	for _, sourcePeers := range b.peers {
+		for _, p := range sourcePeers {
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+func (b *bootstrapper) startingNodes() ([]dht.Addr, error) {
+	return dht.ResolveHostPorts(b.current())
+}
+
+func (b *bootstrapper) run() {
+	defer close(b.done)
+	check := time.NewTicker(routingTableCheckInterval)
+	defer check.Stop()
+	var refreshC <-chan time.Time
+	if b.refresh > 0 {
+		refresh := time.NewTicker(b.refresh)
+		defer refresh.Stop()
+		refreshC = refresh.C
+	}
+
+	for {
+		select {
+		case <-refreshC:
+			if err := b.load(context.Background()); err != nil {
+				logrus.WithError(err).Warn("failed to refresh bootstrap peers")
+			}
+			b.maybeRebootstrap(context.Background())
+		case <-check.C:
+			b.maybeRebootstrap(context.Background())
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *bootstrapper) close() {
+	close(b.stop)
+	<-b.done
+}
+
+// maybeRebootstrap re-bootstraps each of the DHT's servers whose routing table has fewer nodes than the configured
+// minimum
+func (b *bootstrapper) maybeRebootstrap(ctx context.Context) {
+	if b.minNodes <= 0 {
+		return
+	}
+	for _, s := range b.d.servers() {
+		numNodes := s.NumNodes()
+		if numNodes >= b.minNodes {
+			continue
+		}
+		logger := logrus.WithContext(ctx).WithField("addr", s.Addr().String())
+		logger.WithField("nodes", numNodes).Info("routing table below threshold, re-bootstrapping")
+		if err := rebootstrap(ctx, s, b.d.family, b.current()); err != nil {
+			logger.WithError(err).Warn("failed to re-bootstrap")
+		}
+	}
+}
+
+// StartBootstrapRefresh re-bootstraps from the given sources whenever the routing table has fewer than minNodes
+// nodes, reloading the sources at the refresh interval. A zero refresh only reloads the sources on demand through
+// RefreshBootstrapPeers, and a zero minNodes disables re-bootstrapping.
+func (d *DHT) StartBootstrapRefresh(ctx context.Context, sources []BootstrapSource, minNodes int, refresh time.Duration) error {
+	if d.bootstrapper != nil {
+		return errors.New("bootstrap refresh already started")
+	}
+	b := newBootstrapper(d, sources, minNodes, refresh)
+	if err := b.load(ctx); err != nil {
+		return err
+	}
+	d.bootstrapper = b
+	go b.run()
+	return nil
+}
+
+// RefreshBootstrapPeers reloads the bootstrap sources now, re-bootstrapping if the routing table is below threshold
+func (d *DHT) RefreshBootstrapPeers(ctx context.Context) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHT.RefreshBootstrapPeers")
+	defer span.End()
+
+	if d.bootstrapper == nil {
+		return errors.New("bootstrap refresh not started")
+	}
+	if err := d.bootstrapper.load(ctx); err != nil {
+		return err
+	}
+	d.bootstrapper.maybeRebootstrap(ctx)
+	return nil
+}
+
+// BootstrapPeers returns the bootstrap peers currently in use
+func (d *DHT) BootstrapPeers() []string {
+	if d.bootstrapper == nil {
+		return nil
+	}
+	return d.bootstrapper.current()
+}
+
+// rebootstrap pings the given peers the server can reach so they are added to its routing table, then bootstraps
+// from them. The library only falls back to its starting nodes when the routing table is empty, so the peers are added
+// explicitly.
+func rebootstrap(ctx context.Context, s *dht.Server, family AddressFamily, peers []string) error {
+	addrs, err := dht.ResolveHostPorts(peers)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve bootstrap peers")
+	}
+	var wg sync.WaitGroup
+	for _, addr := range family.reachable(s.Addr(), addrs) {
+		udpAddr, ok := addr.Raw().(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Ping(udpAddr)
+		}()
+	}
+	wg.Wait()
+
+	stats, err := s.BootstrapContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error bootstrapping")
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"addr":      s.Addr().String(),
+		"responses": stats.NumResponses,
+		"nodes":     s.NumNodes(),
+	}).Info("re-bootstrapped DHT")
+	return nil
+}
@@ -0,0 +1,86 @@
+package dht_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dhtclient "github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestBootstrapSources(t *testing.T) {
+	ctx := context.Background()
+	peers := "# comment\nrouter.example.com:6881\n\n127.0.0.1:6881 # local\n"
+
+	t.Run("static", func(t *testing.T) {
+		got, err := dhtclient.StaticBootstrapSource{"a:1", "b:2"}.BootstrapPeers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a:1", "b:2"}, got)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers")
+		require.NoError(t, os.WriteFile(path, []byte(peers), 0600))
+
+		got, err := dhtclient.FileBootstrapSource(path).BootstrapPeers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"router.example.com:6881", "127.0.0.1:6881"}, got)
+
+		require.NoError(t, os.WriteFile(path, []byte("not-a-peer\n"), 0600))
+		_, err = dhtclient.FileBootstrapSource(path).BootstrapPeers(ctx)
+		assert.ErrorContains(t, err, "invalid bootstrap peer")
+	})
+
+	t.Run("url", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/missing") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(peers))
+		}))
+		defer srv.Close()
+
+		got, err := dhtclient.URLBootstrapSource{URL: srv.URL + "/peers"}.BootstrapPeers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"router.example.com:6881", "127.0.0.1:6881"}, got)
+
+		_, err = dhtclient.URLBootstrapSource{URL: srv.URL + "/missing"}.BootstrapPeers(ctx)
+		assert.Error(t, err)
+	})
+}
+
+func TestRebootstrap(t *testing.T) {
+	ctx := context.Background()
+	d1 := dhtclient.NewTestDHT(t)
+	defer d1.Close()
+	d2 := dhtclient.NewTestDHT(t)
+	defer d2.Close()
+
+	path := filepath.Join(t.TempDir(), "peers")
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+
+	sources := []dhtclient.BootstrapSource{dhtclient.FileBootstrapSource(path)}
+	require.NoError(t, d2.StartBootstrapRefresh(ctx, sources, 1, time.Hour))
+	assert.Error(t, d2.StartBootstrapRefresh(ctx, sources, 1, time.Hour))
+	assert.Empty(t, d2.BootstrapPeers())
+
+	// the file is picked up on refresh, and the routing table is below threshold so d2 re-bootstraps from d1
+	require.NoError(t, os.WriteFile(path, []byte(d1.Addr().String()+"\n"), 0600))
+	require.NoError(t, d2.RefreshBootstrapPeers(ctx))
+	assert.Equal(t, []string{d1.Addr().String()}, d2.BootstrapPeers())
+	assert.GreaterOrEqual(t, d2.NumNodes(), 1)
+
+	// a source that fails keeps its previous peers
+	require.NoError(t, os.Remove(path))
+	assert.Error(t, d2.RefreshBootstrapPeers(ctx))
+	assert.Equal(t, []string{d1.Addr().String()}, d2.BootstrapPeers())
+}
@@ -4,14 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/url"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	errutil "github.com/TBD54566975/ssi-sdk/util"
 	"github.com/anacrolix/dht/v2"
 	"github.com/anacrolix/dht/v2/bep44"
-	"github.com/anacrolix/dht/v2/exts/getput"
-	"github.com/anacrolix/dht/v2/traversal"
+	"github.com/anacrolix/dht/v2/krpc"
 	"github.com/anacrolix/log"
 	"github.com/anacrolix/torrent/types/infohash"
 	"github.com/pkg/errors"
@@ -19,42 +21,313 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 
-	"github.com/TBD54566975/did-dht/internal/util"
+	"github.com/TBD54566975/did-dht/config"
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/pkg/proxy"
 	"github.com/TBD54566975/did-dht/pkg/telemetry"
 )
 
 // DHT is a wrapper around anacrolix/dht that implements the BEP-44 DHT protocol.
 type DHT struct {
 	*dht.Server
+	// extra are additional servers with their own sockets and routing tables that gets are raced across
+	extra        []*dht.Server
+	traversal    dhtint.TraversalConfig
+	prober       *prober
+	bootstrapper *bootstrapper
+	// family is the address family the servers listen on and traverse over
+	family      AddressFamily
+	portMapping *portMapping
+	// states persist each server's node ID and routing table, in the same order as servers(); nil if disabled
+	states    []serverState
+	closeOnce sync.Once
 }
 
-// NewDHT returns a new instance of DHT with the given bootstrap peers.
-func NewDHT(bootstrapPeers []string) (*DHT, error) {
-	logrus.WithField("bootstrap_peers", len(bootstrapPeers)).Info("initializing DHT")
+// NewDHT returns a new instance of DHT configured with the given bootstrap sources and traversal parameters.
+// A server is started for each listen address; the first is used for puts, and gets are raced across all of them.
+// With a proxy configured, each server's datagrams are relayed through the proxy rather than sent from its listen
+// address, and bootstrap peers are fetched through it.
+func NewDHT(cfg config.DHTServiceConfig, proxyCfg config.ProxyConfig) (*DHT, error) {
+	family, err := ParseAddressFamily(cfg.AddressFamily)
+	if err != nil {
+		return nil, errutil.LoggingErrorMsg(err, "invalid dht address family")
+	}
+	proxyURL, err := proxy.ParseURL(proxyCfg)
+	if err != nil {
+		return nil, errutil.LoggingErrorMsg(err, "invalid proxy")
+	}
+	client, err := proxy.HTTPClient(proxyCfg, bootstrapFetchTimeout)
+	if err != nil {
+		return nil, errutil.LoggingErrorMsg(err, "invalid proxy")
+	}
+	d := &DHT{traversal: TraversalConfigFromConfig(cfg.Traversal), family: family}
+	d.traversal.NodeFilter = func(s *dht.Server, addr krpc.NodeAddr) bool {
+		return family.reaches(s.Addr(), addr.IP)
+	}
+	b := newBootstrapper(d, BootstrapSourcesFromConfig(cfg, client), cfg.MinRoutingTableNodes,
+		time.Duration(cfg.BootstrapRefreshSeconds)*time.Second)
+	if err := b.load(context.Background()); err != nil {
+		return nil, errutil.LoggingErrorMsg(err, "failed to load bootstrap peers")
+	}
+	logrus.WithField("bootstrap_peers", len(b.current())).Info("initializing DHT")
+
+	listenAddrs := cfg.ListenAddrs
+	if len(listenAddrs) == 0 {
+		listenAddrs = []string{family.defaultListenAddr()}
+	}
+	if proxyURL != nil && cfg.PortMapping {
+		// mapping ports would announce the gateway's own address, which proxying is there to hide
+		logrus.Warn("not mapping dht ports while dht traffic is proxied")
+		cfg.PortMapping = false
+	}
+	publicIP, err := d.setUpNAT(cfg, listenAddrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range listenAddrs {
+		opts := serverOptions{
+			startingNodes: b.startingNodes,
+			family:        family,
+			proxy:         proxyURL,
+			publicIP:      publicIP,
+			limiter:       d.traversal.Limiter,
+		}
+		if cfg.StateDir != "" {
+			state := newServerState(cfg.StateDir, addr)
+			opts.state = &state
+			d.states = append(d.states, state)
+		}
+		s, err := newServer(addr, opts)
+		if err != nil {
+			d.closeServers()
+			if d.portMapping != nil {
+				d.portMapping.close()
+			}
+			return nil, err
+		}
+		if d.Server == nil {
+			d.Server = s
+		} else {
+			d.extra = append(d.extra, s)
+		}
+	}
+	d.bootstrapper = b
+	d.traversal.GetRetry.Rebootstrap = func(ctx context.Context, s *dht.Server) error {
+		return rebootstrap(ctx, s, family, b.current())
+	}
+	go b.run()
+	if cfg.HealthProbeIntervalSeconds > 0 {
+		if err := d.StartHealthProbe(time.Duration(cfg.HealthProbeIntervalSeconds) * time.Second); err != nil {
+			d.Close()
+			return nil, errutil.LoggingErrorMsg(err, "failed to start dht health probe")
+		}
+	}
+	return d, nil
+}
+
+const (
+	// bootstrapFetchTimeout bounds fetching the bootstrap peers from a URL
+	bootstrapFetchTimeout = 10 * time.Second
+	// defaultQueriesPerSecond and defaultQueryBurst limit outbound queries when the config leaves them unset
+	defaultQueriesPerSecond = 100
+	defaultQueryBurst       = 500
+)
+
+// setUpNAT returns the public IP to advertise, mapping the listen ports on the local gateway if configured to.
+// Port mapping failures are logged rather than returned, since the node may still be reachable without them.
+func (d *DHT) setUpNAT(cfg config.DHTServiceConfig, listenAddrs []string) (net.IP, error) {
+	var publicIP net.IP
+	if cfg.PublicIP != "" {
+		if publicIP = net.ParseIP(cfg.PublicIP); publicIP == nil {
+			return nil, errutil.LoggingNewErrorf("invalid public ip: %s", cfg.PublicIP)
+		}
+	}
+	if !cfg.PortMapping {
+		return publicIP, nil
+	}
 
+	var ports []int
+	for _, addr := range listenAddrs {
+		_, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errutil.LoggingErrorMsgf(err, "invalid listen address: %s", addr)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port == 0 {
+			logrus.WithField("addr", addr).Warn("not mapping listen address without a fixed port")
+			continue
+		}
+		ports = append(ports, port)
+	}
+	m, externalIP, err := newPortMapping(context.Background(), ports)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to map dht ports on the gateway; the node may be unreachable behind NAT, " +
+			"and puts may fail to reach enough peers")
+		return publicIP, nil
+	}
+	d.portMapping = m
+	if publicIP == nil {
+		publicIP = externalIP
+	}
+	return publicIP, nil
+}
+
+// serverOptions configures a DHT server started by newServer
+type serverOptions struct {
+	startingNodes dht.StartingNodesGetter
+	// family is the address family the server listens on; starting nodes it can't reach are skipped
+	family AddressFamily
+	// proxy, if set, is the SOCKS5 proxy the server's datagrams are relayed through
+	proxy *url.URL
+	// publicIP, if set, is used to derive a BEP-42 secure node ID
+	publicIP net.IP
+	// limiter is shared with the traversals as the server's send limiter
+	limiter *rate.Limiter
+	// state, if set, restores the server's node ID and routing table from a previous run
+	state *serverState
+}
+
+// newServer starts and bootstraps a DHT server listening on the given UDP address
+func newServer(addr string, opts serverOptions) (*dht.Server, error) {
 	c := dht.NewDefaultServerConfig()
 	c.Exp = time.Hour * 24
 	c.NoSecurity = false
-	conn, err := net.ListenPacket("udp", "0.0.0.0:6881")
+	conn, err := listen(addr, opts)
 	if err != nil {
-		return nil, errutil.LoggingErrorMsg(err, "failed to listen on udp port 6881")
+		return nil, err
 	}
 	c.Conn = conn
 	c.Logger = log.NewLogger().WithFilterLevel(log.Debug)
 	c.Logger.SetHandlers(logrusHandler{})
-	c.StartingNodes = func() ([]dht.Addr, error) { return dht.ResolveHostPorts(bootstrapPeers) }
-	// set up rate limiter - 100 requests per second, 500 requests burst
-	c.SendLimiter = rate.NewLimiter(100, 500)
+	c.DefaultWant = opts.family.wants()
+	c.StartingNodes = func() ([]dht.Addr, error) {
+		addrs, err := opts.startingNodes()
+		if err != nil {
+			return nil, err
+		}
+		return opts.family.reachable(conn.LocalAddr(), addrs), nil
+	}
+	c.PublicIP = opts.publicIP
+	c.SendLimiter = opts.limiter
+	if opts.state != nil {
+		if c.NodeId, err = opts.state.loadNodeID(opts.publicIP); err != nil {
+			logrus.WithError(err).WithField("addr", addr).Warn("failed to load saved node id, generating a new one")
+		}
+	}
 	s, err := dht.NewServer(c)
 	if err != nil {
+		_ = conn.Close()
 		return nil, errutil.LoggingErrorMsg(err, "failed to create dht server")
 	}
+	if opts.state != nil {
+		// a warm routing table lets bootstrapping start from known nodes rather than only the bootstrap peers
+		added, err := opts.state.loadNodes(s)
+		if err != nil {
+			logrus.WithError(err).WithField("addr", addr).Warn("failed to restore routing table")
+		} else if added > 0 {
+			logrus.WithField("addr", addr).WithField("nodes", added).Info("restored routing table from previous run")
+		}
+	}
 	if tried, err := s.Bootstrap(); err != nil {
+		s.Close()
 		return nil, errutil.LoggingErrorMsg(err, "error bootstrapping")
 	} else {
-		logrus.WithField("bootstrap_peers", tried.NumResponses).Info("bootstrapped DHT successfully")
+		logrus.WithField("addr", addr).WithField("bootstrap_peers", tried.NumResponses).Info("bootstrapped DHT successfully")
+	}
+	return s, nil
+}
+
+// listen returns the packet conn of a server listening on the given UDP address, or relaying through its proxy
+func listen(addr string, opts serverOptions) (net.PacketConn, error) {
+	if opts.proxy != nil {
+		conn, err := proxy.ListenPacket(context.Background(), opts.proxy)
+		if err != nil {
+			return nil, errutil.LoggingErrorMsg(err, fmt.Sprintf("failed to relay dht traffic through proxy %s", opts.proxy.Host))
+		}
+		logrus.WithField("addr", addr).WithField("proxy", opts.proxy.Host).Info("relaying dht traffic through proxy")
+		return conn, nil
+	}
+	conn, err := net.ListenPacket(opts.family.network(), addr)
+	if err != nil {
+		return nil, errutil.LoggingErrorMsg(err, fmt.Sprintf("failed to listen on %s address %s", opts.family.network(), addr))
+	}
+	return conn, nil
+}
+
+// servers returns every DHT server, starting with the primary
+func (d *DHT) servers() []*dht.Server {
+	return append([]*dht.Server{d.Server}, d.extra...)
+}
+
+func (d *DHT) closeServers() {
+	if d.Server != nil {
+		d.Server.Close()
+	}
+	for _, s := range d.extra {
+		s.Close()
+	}
+}
+
+// Close stops any background work, saves each server's node ID and routing table if configured to, and closes the
+// underlying DHT servers. Closing it again is a no-op.
+func (d *DHT) Close() {
+	d.closeOnce.Do(d.close)
+}
+
+func (d *DHT) close() {
+	if d.prober != nil {
+		d.prober.close()
+	}
+	if d.bootstrapper != nil {
+		d.bootstrapper.close()
+	}
+	if d.portMapping != nil {
+		d.portMapping.close()
+	}
+	d.saveState()
+	d.closeServers()
+}
+
+// saveState persists the node ID and routing table of each server
+func (d *DHT) saveState() {
+	for i, s := range d.servers() {
+		if i >= len(d.states) {
+			return
+		}
+		if err := d.states[i].save(s); err != nil {
+			logrus.WithError(err).WithField("addr", s.Addr().String()).Error("failed to save dht state")
+			continue
+		}
+		logrus.WithField("addr", s.Addr().String()).WithField("nodes", s.NumNodes()).Info("saved dht state")
+	}
+}
+
+// TraversalConfigFromConfig converts the traversal section of the service config into traversal parameters
+func TraversalConfigFromConfig(cfg config.TraversalConfig) dhtint.TraversalConfig {
+	qps, burst := cfg.QueriesPerSecond, cfg.QueryBurst
+	if qps == 0 {
+		qps, burst = defaultQueriesPerSecond, defaultQueryBurst
+	}
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if qps > 0 {
+		limiter = rate.NewLimiter(rate.Limit(qps), max(burst, 1))
+	}
+	return dhtint.TraversalConfig{
+		Alpha:               cfg.Alpha,
+		K:                   cfg.K,
+		QueryTimeout:        time.Duration(cfg.QueryTimeoutSeconds) * time.Second,
+		InitialQueryTimeout: time.Duration(cfg.InitialQueryTimeoutMillis) * time.Millisecond,
+		StallTimeout:        time.Duration(cfg.StallTimeoutSeconds) * time.Second,
+		PutQuorum:           cfg.PutQuorum,
+		LatestWindow:        time.Duration(cfg.LatestWindowMillis) * time.Millisecond,
+		Limiter:             limiter,
+		GetRetry: dhtint.RetryPolicy{
+			Attempts:       cfg.GetRetry.Attempts,
+			InitialBackoff: time.Duration(cfg.GetRetry.InitialBackoffMillis) * time.Millisecond,
+			MaxBackoff:     time.Duration(cfg.GetRetry.MaxBackoffMillis) * time.Millisecond,
+			Jitter:         cfg.GetRetry.Jitter,
+		},
 	}
-	return &DHT{Server: s}, nil
 }
 
 // NewTestDHT returns a new instance of DHT that does not make external connections
@@ -79,60 +352,179 @@ func NewTestDHT(t *testing.T, bootstrapPeers ...dht.Addr) *DHT {
 		t.Fatalf("failed to bootstrap: %v", err)
 	}
 
-	return &DHT{Server: s}
+	return &DHT{Server: s, traversal: dhtint.DefaultTraversalConfig()}
 }
 
-// Put puts the given BEP-44 value into the DHT and returns its z32-encoded key.
+// Put puts the given BEP-44 value into the DHT and returns its record ID: the z32-encoded key, followed by the
+// encoded salt if the value is salted.
 func (d *DHT) Put(ctx context.Context, request bep44.Put) (string, error) {
+	if _, err := d.PutWithReport(ctx, request); err != nil {
+		return "", err
+	}
+	return RecordID(request.K[:], request.Salt), nil
+}
+
+// PutWithReport puts the given BEP-44 value into the DHT and returns a report of which nodes acknowledged the put.
+func (d *DHT) PutWithReport(ctx context.Context, request bep44.Put) (*dhtint.PutReport, error) {
 	ctx, span := telemetry.GetTracer().Start(ctx, "DHT.Put")
 	defer span.End()
+	ctx = withRequestLogger(ctx)
+
+	if err := ValidatePut(request); err != nil {
+		return nil, errors.Wrap(err, "invalid put")
+	}
 
 	// Check if there are any nodes in the DHT
 	if len(d.Server.Nodes()) == 0 {
 		logrus.WithContext(ctx).Warn("no nodes available in the DHT for publishing")
 	}
 
-	key := util.Z32Encode(request.K[:])
-	t, err := getput.Put(ctx, request.Target(), d.Server, nil, func(int64) bep44.Put {
+	key := RecordID(request.K[:], request.Salt)
+	report, err := dhtint.Put(ctx, request.Target(), d.Server, request.Salt, func(int64) bep44.Put {
 		return request
-	})
-	if err = isPutSuccessful(key, t, err); err != nil {
+	}, d.traversal)
+	if err = isPutSuccessful(key, report, err); err != nil {
 		logrus.WithContext(ctx).WithField("key", key).Error("error putting key into dht")
-		return "", err
+		return report, err
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"key":       key,
+		"succeeded": report.Succeeded(),
+		"failed":    report.Failed(),
+	}).Debug("successfully put key into dht")
+	return report, nil
+}
+
+// PutMany puts each of the given BEP-44 values into the DHT, bounded by the given batch config, and returns the
+// result of each put in the same order as the requests.
+func (d *DHT) PutMany(ctx context.Context, requests []bep44.Put, batch dhtint.PutManyConfig) []dhtint.PutManyResult {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHT.PutMany")
+	defer span.End()
+	ctx = withRequestLogger(ctx)
+
+	// only start traversals for the puts that pass validation
+	results := make([]dhtint.PutManyResult, len(requests))
+	valid := make([]bep44.Put, 0, len(requests))
+	validIdx := make([]int, 0, len(requests))
+	for i, request := range requests {
+		if err := ValidatePut(request); err != nil {
+			results[i].Err = errors.Wrap(err, "invalid put")
+			continue
+		}
+		valid = append(valid, request)
+		validIdx = append(validIdx, i)
+	}
+	for j, res := range dhtint.PutMany(ctx, d.Server, valid, d.traversal, batch) {
+		i := validIdx[j]
+		results[i] = res
+		results[i].Err = isPutSuccessful(RecordID(requests[i].K[:], requests[i].Salt), res.Report, res.Err)
 	}
-	logrus.WithContext(ctx).WithField("key", key).Debug("successfully put key into dht")
-	return util.Z32Encode(request.K[:]), nil
+	return results
 }
 
 const successThreshold = 0.33
 
-func isPutSuccessful(key string, t *traversal.Stats, err error) error {
+func isPutSuccessful(key string, report *dhtint.PutReport, err error) error {
 	if err != nil {
-		return nil
+		return errors.Wrapf(err, "failed to put key[%s] into dht", key)
 	}
-	if t == nil {
+	if report == nil || report.Stats == nil {
 		return fmt.Errorf("failed to put key[%s] into dht: %v", key, err)
 	}
+	t := report.Stats
 	if float64(t.NumResponses)/float64(t.NumAddrsTried) < successThreshold {
 		return fmt.Errorf("failed to put key[%s] into dht, tried %d nodes, got %d responses", key, t.NumAddrsTried, t.NumResponses)
 	}
 	return nil
 }
 
+// target returns the DHT target and salt of the given record ID
+func target(key string) (bep44.Target, []byte, error) {
+	k, salt, err := ParseRecordID(key)
+	if err != nil {
+		return bep44.Target{}, nil, errors.Wrapf(err, "failed to decode key [%s]", key)
+	}
+	return infohash.HashBytes(append(k, salt...)), salt, nil
+}
+
 // GetFull returns the full BEP-44 result for the given key from the DHT, using our modified
 // implementation of getput.Get. It should ONLY be used when it's needed to get the signature
-// data for a record.
-func (d *DHT) GetFull(ctx context.Context, key string) (*getput.GetResult, error) {
+// data for a record. The key is a record ID, so it may carry a salt.
+func (d *DHT) GetFull(ctx context.Context, key string) (*dhtint.FullGetResult, error) {
 	ctx, span := telemetry.GetTracer().Start(ctx, "DHT.GetFull")
 	defer span.End()
+	ctx = withRequestLogger(ctx)
 
-	z32Decoded, err := util.Z32Decode(key)
+	t, salt, err := target(key)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to decode key [%s]", key)
+		return nil, err
 	}
-	res, t, err := getput.Get(ctx, infohash.HashBytes(z32Decoded), d.Server, nil, nil)
+	res, stats, err := dhtint.GetParallel(ctx, t, d.servers(), nil, salt, d.traversal)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get key[%s] from dht; tried %d nodes, got %d responses", key, t.NumAddrsTried, t.NumResponses)
+		if stats == nil {
+			return nil, errors.Wrapf(err, "failed to get key[%s] from dht", key)
+		}
+		return nil, errors.Wrapf(err, "failed to get key[%s] from dht; tried %d nodes, got %d responses", key, stats.NumAddrsTried, stats.NumResponses)
 	}
 	return &res, nil
 }
+
+// GetLatest returns the freshest BEP-44 result for the given key along with the number of nodes that reported it.
+// Unlike GetFull it waits for the configured window after the first result to hear from more nodes, so the count
+// can be used as a confidence signal that the record is the latest one published.
+func (d *DHT) GetLatest(ctx context.Context, key string) (*dhtint.LatestResult, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHT.GetLatest")
+	defer span.End()
+	ctx = withRequestLogger(ctx)
+
+	t, salt, err := target(key)
+	if err != nil {
+		return nil, err
+	}
+	res, stats, err := dhtint.GetLatest(ctx, t, d.Server, nil, salt, d.traversal)
+	if err != nil {
+		if stats == nil {
+			return nil, errors.Wrapf(err, "failed to get key[%s] from dht", key)
+		}
+		return nil, errors.Wrapf(err, "failed to get key[%s] from dht; tried %d nodes, got %d responses", key, stats.NumAddrsTried, stats.NumResponses)
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"key":     key,
+		"seq":     res.Seq,
+		"reports": res.Reports,
+	}).Debug("got latest record from dht")
+	return &res, nil
+}
+
+// GetFullStream returns a channel of full BEP-44 results for the given key as they are discovered in the DHT.
+// Each result sent has a higher sequence number than the one before it, so the first result can be used
+// right away while the traversal continues to look for a newer record. The channel is closed when the
+// traversal stalls or the context is done; callers that stop reading early must cancel the context.
+func (d *DHT) GetFullStream(ctx context.Context, key string) (<-chan dhtint.FullGetResult, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHT.GetFullStream")
+	ctx = withRequestLogger(ctx)
+
+	t, salt, err := target(key)
+	if err != nil {
+		span.End()
+		return nil, err
+	}
+	results, stats, err := dhtint.GetStream(ctx, t, d.Server, nil, salt, d.traversal)
+	if err != nil {
+		span.End()
+		return nil, errors.Wrapf(err, "failed to start get traversal for key[%s]", key)
+	}
+
+	// end the span once the traversal has finished
+	go func() {
+		defer span.End()
+		if t := stats(); t != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"key":       key,
+				"tried":     t.NumAddrsTried,
+				"responses": t.NumResponses,
+			}).Debug("finished streaming get from dht")
+		}
+	}()
+	return results, nil
+}
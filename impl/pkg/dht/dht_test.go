@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/anacrolix/dht/v2"
 	"github.com/anacrolix/dht/v2/bep44"
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/stretchr/testify/assert"
@@ -65,3 +66,161 @@ func TestKnownVector(t *testing.T) {
 	assert.Equal(t, "c1dc657a17f54ca51933b17b7370b87faae10c7edd560fd4baad543869e30e8154c510f4d0b0d94d1e683891b06a07cecd9f0be325fe8f8a0466fe38011b2d0a", hex.EncodeToString(put.Sig[:]))
 	assert.Equal(t, "796f7457532cd39697f4fccd1a2d7074e6c1f6c59e6ecf5dc16c8ecd6e3fea6c", hex.EncodeToString(put.K[:]))
 }
+
+func TestGetFullStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := dhtclient.NewTestDHT(t)
+	defer d.Close()
+
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+
+	put := &bep44.Put{
+		V:   []byte("hello stream"),
+		K:   (*[32]byte)(pubKey),
+		Seq: time.Now().UnixMilli() / 1000,
+	}
+	put.Sign(privKey)
+
+	id, err := d.Put(ctx, *put)
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	results, err := d.GetFullStream(ctx, id)
+	require.NoError(t, err)
+
+	var last int64
+	var got int
+	for res := range results {
+		assert.True(t, res.Mutable)
+		assert.Greater(t, res.Seq, last)
+		var payload string
+		require.NoError(t, bencode.Unmarshal(res.V, &payload))
+		assert.Equal(t, string(put.V.([]byte)), payload)
+		last = res.Seq
+		got++
+	}
+	assert.Equal(t, 1, got)
+	assert.Equal(t, put.Seq, last)
+
+	_, err = d.GetFullStream(ctx, "---")
+	assert.Error(t, err)
+}
+
+func TestPutWithReport(t *testing.T) {
+	ctx := context.Background()
+	d := dhtclient.NewTestDHT(t)
+	defer d.Close()
+
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+
+	put := &bep44.Put{
+		V:   []byte("hello report"),
+		K:   (*[32]byte)(pubKey),
+		Seq: time.Now().UnixMilli() / 1000,
+	}
+	put.Sign(privKey)
+
+	report, err := d.PutWithReport(ctx, *put)
+	require.NoError(t, err)
+	require.NotNil(t, report)
+	require.NotNil(t, report.Stats)
+	assert.NotEmpty(t, report.Nodes)
+	assert.Equal(t, len(report.Nodes), report.Succeeded()+report.Failed())
+	for _, node := range report.Nodes {
+		assert.NotEmpty(t, node.Token)
+		assert.True(t, node.Succeeded())
+	}
+}
+
+func TestHealthProbe(t *testing.T) {
+	d := dhtclient.NewTestDHT(t)
+	defer d.Close()
+
+	health := d.Health()
+	assert.False(t, health.Reachable)
+	assert.True(t, health.LastProbe.IsZero())
+
+	require.NoError(t, d.StartHealthProbe(time.Minute))
+	require.Error(t, d.StartHealthProbe(time.Minute))
+
+	require.Eventually(t, func() bool {
+		return !d.Health().LastProbe.IsZero()
+	}, 30*time.Second, 100*time.Millisecond)
+
+	health = d.Health()
+	assert.True(t, health.Reachable, health.LastError)
+	assert.Empty(t, health.LastError)
+	assert.GreaterOrEqual(t, health.AverageLatencyMillis, int64(0))
+}
+
+func TestRoutingTable(t *testing.T) {
+	d1 := dhtclient.NewTestDHT(t)
+	defer d1.Close()
+	d2 := dhtclient.NewTestDHT(t, dht.NewAddr(d1.Addr()))
+	defer d2.Close()
+
+	table, err := d2.RoutingTable()
+	require.NoError(t, err)
+	assert.Equal(t, d2.Addr().String(), table.Addr)
+	assert.Len(t, table.NodeID, 40)
+	assert.Equal(t, d2.NumNodes(), table.Nodes)
+
+	var addrs []string
+	for _, b := range table.Buckets {
+		for _, n := range b.Nodes {
+			addrs = append(addrs, n.Addr)
+		}
+	}
+	assert.Contains(t, addrs, d1.Addr().String())
+}
+
+func TestGetLatest(t *testing.T) {
+	ctx := context.Background()
+	d := dhtclient.NewTestDHT(t)
+	defer d.Close()
+
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+	put := bep44.Put{V: []byte("hello dht"), K: (*[32]byte)(pubKey), Seq: time.Now().Unix()}
+	put.Sign(privKey)
+
+	id, err := d.Put(ctx, put)
+	require.NoError(t, err)
+
+	res, err := d.GetLatest(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, put.Seq, res.Seq)
+	assert.Equal(t, 1, res.Reports)
+}
+
+func TestGetPutSalted(t *testing.T) {
+	ctx := context.Background()
+	d := dhtclient.NewTestDHT(t)
+	defer d.Close()
+
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+
+	put := func(v string, salt []byte) string {
+		p := bep44.Put{V: []byte(v), K: (*[32]byte)(pubKey), Salt: salt, Seq: time.Now().Unix()}
+		p.Sign(privKey)
+		id, err := d.Put(ctx, p)
+		require.NoError(t, err)
+		return id
+	}
+	unsaltedID := put("unsalted", nil)
+	saltedID := put("salted", []byte("salt"))
+	assert.Equal(t, unsaltedID+".c2FsdA", saltedID)
+
+	for id, want := range map[string]string{unsaltedID: "unsalted", saltedID: "salted"} {
+		got, err := d.GetFull(ctx, id)
+		require.NoError(t, err)
+		var payload string
+		require.NoError(t, bencode.Unmarshal(got.V, &payload))
+		assert.Equal(t, want, payload)
+	}
+}
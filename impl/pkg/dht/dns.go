@@ -2,13 +2,16 @@ package dht
 
 import (
 	"crypto/ed25519"
+	"strconv"
 	"time"
 
 	"github.com/TBD54566975/ssi-sdk/util"
 	"github.com/anacrolix/dht/v2/bep44"
-	"github.com/anacrolix/dht/v2/exts/getput"
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
 )
 
 // CreateDNSPublishRequest creates a put request for the given records. Requires a public/private keypair and
@@ -34,23 +37,54 @@ import (
 //		    }
 //		}
 func CreateDNSPublishRequest(privateKey ed25519.PrivateKey, msg dns.Msg) (*bep44.Put, error) {
+	return CreateSaltedDNSPublishRequest(privateKey, nil, msg)
+}
+
+// CreateSaltedDNSPublishRequest creates a put request for the given records like CreateDNSPublishRequest, under the
+// given salt. Each distinct salt addresses a separate record for the same key.
+func CreateSaltedDNSPublishRequest(privateKey ed25519.PrivateKey, salt []byte, msg dns.Msg) (*bep44.Put, error) {
+	if len(salt) > maxSaltLength {
+		return nil, util.LoggingNewError("salt too long")
+	}
 	packed, err := msg.Pack()
 	if err != nil {
 		return nil, util.LoggingErrorMsg(err, "failed to pack records")
 	}
+	if err = checkValueLength(bencodedLength(packed)); err != nil {
+		return nil, err
+	}
 	publicKey := privateKey.Public().(ed25519.PublicKey)
 	put := &bep44.Put{
 		V:   packed,
 		K:   (*[32]byte)(publicKey),
 		Seq: time.Now().UnixMilli() / 1000,
 	}
+	if len(salt) > 0 {
+		put.Salt = salt
+	}
 	put.Sign(privateKey)
 	return put, nil
 }
 
+// EstimateSize returns the size of a DNS packet as BEP44 limits it, the length of the packet bencoded as a record's
+// value, so a publisher can tell how close a document is to the 1000 byte limit before signing it. Packets over the
+// limit fail CreateDNSPublishRequest with a LimitError reporting how many bytes over they are.
+func EstimateSize(msg dns.Msg) (int, error) {
+	packed, err := msg.Pack()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to pack records")
+	}
+	return bencodedLength(packed), nil
+}
+
+// bencodedLength returns the length of v bencoded as a byte string, its length prefix followed by v
+func bencodedLength(v []byte) int {
+	return len(strconv.Itoa(len(v))) + 1 + len(v)
+}
+
 // ParseDNSGetResponse parses the response from a get request.
 // The response is expected to be a slice of DNS resource records.
-func ParseDNSGetResponse(response getput.GetResult) (*dns.Msg, error) {
+func ParseDNSGetResponse(response dhtint.FullGetResult) (*dns.Msg, error) {
 	var payload string
 	if err := bencode.Unmarshal(response.V, &payload); err != nil {
 		return nil, util.LoggingErrorMsg(err, "failed to unmarshal payload value")
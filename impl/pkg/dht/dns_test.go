@@ -2,12 +2,14 @@ package dht
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/TBD54566975/ssi-sdk/crypto"
 	"github.com/TBD54566975/ssi-sdk/crypto/jwx"
 	"github.com/TBD54566975/ssi-sdk/cryptosuite"
 	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/anacrolix/torrent/bencode"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -123,3 +125,61 @@ func TestGetPutDIDDHT(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, gotDoc)
 }
+
+func TestEstimateSize(t *testing.T) {
+	services := func(n int) []didsdk.Service {
+		var services []didsdk.Service
+		for i := range n {
+			services = append(services, didsdk.Service{
+				ID:              fmt.Sprintf("service-%d", i),
+				Type:            "LinkedDomains",
+				ServiceEndpoint: fmt.Sprintf("https://example.com/%d", i),
+			})
+		}
+		return services
+	}
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{Services: services(10)})
+	require.NoError(t, err)
+	didID := did.DHT(doc.ID)
+
+	packet, err := didID.ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	size, err := EstimateSize(*packet)
+	require.NoError(t, err)
+	put, err := CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	bv, err := bencode.Marshal(put.V)
+	require.NoError(t, err)
+	assert.Equal(t, len(bv), size)
+
+	// the compact packet is smaller and decodes to the same document
+	compact, err := didID.ToCompactDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	compactSize, err := EstimateSize(*compact)
+	require.NoError(t, err)
+	assert.Less(t, compactSize, size)
+	packed, err := compact.Pack()
+	require.NoError(t, err)
+	unpacked := new(dns.Msg)
+	require.NoError(t, unpacked.Unpack(packed))
+	decoded, err := didID.FromDNSPacket(unpacked)
+	require.NoError(t, err)
+	expected, err := didID.FromDNSPacket(packet)
+	require.NoError(t, err)
+	assert.Equal(t, expected, decoded)
+
+	// a document too large to publish reports how far over the limit it is
+	sk, doc, err = did.GenerateDIDDHT(did.CreateDIDDHTOpts{Services: services(30)})
+	require.NoError(t, err)
+	packet, err = did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	size, err = EstimateSize(*packet)
+	require.NoError(t, err)
+	_, err = CreateDNSPublishRequest(sk, *packet)
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	var limitErr *LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, size-1000, limitErr.Over())
+	assert.ErrorContains(t, err, fmt.Sprintf("%d over the 1000 byte limit", size-1000))
+}
@@ -0,0 +1,113 @@
+package dht
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+)
+
+// Errors returned by DHT operations. They are wrapped with context, so match them with errors.Is.
+var (
+	// ErrNotFound is returned when a get reaches the end of its traversal without finding the record
+	ErrNotFound = dhtint.ErrNotFound
+	// ErrStalled is returned when a get or put is cut short by a timeout before its traversal finishes
+	ErrStalled = dhtint.ErrStalled
+	// ErrAllPutsFailed is returned when none of the nodes a record was put to accepted it
+	ErrAllPutsFailed = dhtint.ErrAllPutsFailed
+	// ErrBadSignature is returned for a record whose signature does not verify against its key
+	ErrBadSignature = errors.New("signature is invalid")
+	// ErrValueTooLarge is returned for a record whose bencoded value is over BEP44's 1000 byte limit
+	ErrValueTooLarge = errors.New("bep44 record value too long")
+	// ErrInvalidDNSPacket is returned for a record whose value is not a DNS packet
+	ErrInvalidDNSPacket = errors.New("record value is not a valid dns packet")
+	// ErrInvalidTypes is returned for a record whose types record isn't of the form the DID DHT spec requires, or
+	// lists unregistered types
+	ErrInvalidTypes = errors.New("invalid types record")
+	// ErrTooManyRecords is returned for a record whose DNS packet has more of a record than the DID DHT spec allows a
+	// DID, such as two root records
+	ErrTooManyRecords = errors.New("dns packet has too many records")
+	// ErrInvalidDocument is returned for a record whose DNS packet doesn't decode to a DID document, or decodes to
+	// one breaking the rules of DID Core or the DID DHT spec
+	ErrInvalidDocument = errors.New("invalid did document")
+	// ErrDeactivated is returned for a record published for a DID that has been deactivated
+	ErrDeactivated = errors.New("did is deactivated")
+	// ErrSeqConflict is returned for a record published on the condition that the current version has an expected
+	// sequence number, when a newer version is stored or on the DHT, or another conditional publish of it is under way
+	ErrSeqConflict = errors.New("record has a newer version than expected")
+	// ErrInvalidRotation is returned for an update of a DID that rotates its verification methods in a way resolvers
+	// could mistake, such as giving a method's ID to another key, or removing methods before their replacements have
+	// been published for the grace period required
+	ErrInvalidRotation = errors.New("invalid key rotation")
+	// ErrInvalidPageToken is returned when listing from a page token the storage didn't issue for the listing
+	ErrInvalidPageToken = errors.New("invalid page token")
+	// ErrAbusive is returned for a record published to the gateway that the abuse filter scored over its rejection
+	// threshold
+	ErrAbusive = errors.New("record rejected as abusive")
+	// ErrDenied is returned for a record of a DID the gateway's access lists deny, which it neither publishes nor
+	// resolves
+	ErrDenied = errors.New("did is denied by the gateway")
+)
+
+// SeqConflictError is an ErrSeqConflict naming the sequence number a conditional publish expected the current version
+// of a record to have, and the newer one the gateway found
+type SeqConflictError struct {
+	ID       string
+	Expected int64
+	Current  int64
+}
+
+func (e *SeqConflictError) Error() string {
+	return fmt.Sprintf("%s: expected record %s to be at seq %d, but it is at seq %d", ErrSeqConflict, e.ID, e.Expected, e.Current)
+}
+
+func (e *SeqConflictError) Unwrap() error {
+	return ErrSeqConflict
+}
+
+// FieldError is a property of a DID document breaking one of the rules of DID Core or the DID DHT spec
+type FieldError struct {
+	// Field is the path of the property in the JSON DID document, such as service[0].serviceEndpoint[1]
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// DocumentError is an ErrInvalidDocument listing every property of the DID document that breaks a rule, so a
+// publisher can fix them all at once
+type DocumentError struct {
+	ID     string
+	Fields []FieldError
+}
+
+func (e *DocumentError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field+" "+f.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", ErrInvalidDocument, e.ID, strings.Join(fields, "; "))
+}
+
+func (e *DocumentError) Unwrap() error {
+	return ErrInvalidDocument
+}
+
+// RotationError is an ErrInvalidRotation listing every verification method of the updated DID document that breaks a
+// rule of the rotation
+type RotationError struct {
+	ID     string
+	Fields []FieldError
+}
+
+func (e *RotationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field+" "+f.Message)
+	}
+	return fmt.Sprintf("%s of %s: %s", ErrInvalidRotation, e.ID, strings.Join(fields, "; "))
+}
+
+func (e *RotationError) Unwrap() error {
+	return ErrInvalidRotation
+}
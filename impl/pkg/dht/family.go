@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+)
+
+// AddressFamily is the IP address family the DHT servers listen on and traverse the DHT over
+type AddressFamily string
+
+const (
+	// FamilyDual listens on IPv4 and IPv6 at once where the host supports it, querying nodes of either family.
+	// Servers listening on a specific address only query nodes of that address's family.
+	FamilyDual AddressFamily = "dual"
+	// FamilyIPv4 listens on and queries IPv4 only
+	FamilyIPv4 AddressFamily = "ipv4"
+	// FamilyIPv6 listens on and queries IPv6 only, for hosts without IPv4 connectivity
+	FamilyIPv6 AddressFamily = "ipv6"
+)
+
+// ParseAddressFamily returns the address family with the given name, FamilyDual if it's empty
+func ParseAddressFamily(name string) (AddressFamily, error) {
+	switch family := AddressFamily(name); family {
+	case "":
+		return FamilyDual, nil
+	case FamilyDual, FamilyIPv4, FamilyIPv6:
+		return family, nil
+	default:
+		return "", fmt.Errorf("unknown address family %q: must be one of %s, %s, or %s", name,
+			FamilyDual, FamilyIPv4, FamilyIPv6)
+	}
+}
+
+// network is the network servers of the family listen on. A wildcard address on "udp" is dual-stack, while "udp6"
+// sockets are IPv6 only.
+func (f AddressFamily) network() string {
+	switch f {
+	case FamilyIPv4:
+		return "udp4"
+	case FamilyIPv6:
+		return "udp6"
+	default:
+		return "udp"
+	}
+}
+
+// defaultListenAddr is the address a server of the family listens on when no listen addresses are configured
+func (f AddressFamily) defaultListenAddr() string {
+	if f == FamilyIPv4 {
+		return "0.0.0.0:6881"
+	}
+	return "[::]:6881"
+}
+
+// wants is the node address families the servers ask for in their queries, per BEP 32
+func (f AddressFamily) wants() []krpc.Want {
+	switch f {
+	case FamilyIPv4:
+		return []krpc.Want{krpc.WantNodes}
+	case FamilyIPv6:
+		return []krpc.Want{krpc.WantNodes6}
+	default:
+		return []krpc.Want{krpc.WantNodes, krpc.WantNodes6}
+	}
+}
+
+// reaches returns whether a server of the family listening on local can query a node at ip. Dual-stack sockets are
+// bound to the IPv6 wildcard; a socket bound to an IPv4 address, or to a specific IPv6 address, only reaches nodes of
+// its own family.
+func (f AddressFamily) reaches(local net.Addr, ip net.IP) bool {
+	isIPv4 := ip.To4() != nil
+	switch f {
+	case FamilyIPv4:
+		return isIPv4
+	case FamilyIPv6:
+		return !isIPv4
+	}
+	udp, ok := local.(*net.UDPAddr)
+	if !ok || len(udp.IP) == 0 || udp.IP.To4() == nil && udp.IP.IsUnspecified() {
+		return true
+	}
+	return (udp.IP.To4() != nil) == isIPv4
+}
+
+// reachable returns the addresses a server of the family listening on local can query
+func (f AddressFamily) reachable(local net.Addr, addrs []dht.Addr) []dht.Addr {
+	reachable := make([]dht.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		if f.reaches(local, addr.IP()) {
+			reachable = append(reachable, addr)
+		}
+	}
+	return reachable
+}
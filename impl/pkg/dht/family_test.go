@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/util"
+)
+
+func TestParseAddressFamily(t *testing.T) {
+	family, err := ParseAddressFamily("")
+	require.NoError(t, err)
+	assert.Equal(t, FamilyDual, family)
+
+	for _, name := range []string{"dual", "ipv4", "ipv6"} {
+		family, err = ParseAddressFamily(name)
+		require.NoError(t, err)
+		assert.Equal(t, AddressFamily(name), family)
+	}
+
+	_, err = ParseAddressFamily("ipv5")
+	assert.ErrorContains(t, err, `unknown address family "ipv5"`)
+}
+
+func TestAddressFamilyReaches(t *testing.T) {
+	ipv4, ipv6 := net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::7")
+	tests := []struct {
+		family      AddressFamily
+		local       string
+		ipv4, ipv6  bool
+		explanation string
+	}{
+		{FamilyDual, "[::]:6881", true, true, "dual-stack wildcard"},
+		{FamilyDual, "0.0.0.0:6881", true, false, "ipv4 wildcard"},
+		{FamilyDual, "192.0.2.1:6881", true, false, "ipv4 address"},
+		{FamilyDual, "[2001:db8::1]:6881", false, true, "ipv6 address"},
+		{FamilyIPv4, "0.0.0.0:6881", true, false, "ipv4 only"},
+		{FamilyIPv6, "[::]:6881", false, true, "ipv6 only"},
+	}
+	for _, test := range tests {
+		local, err := net.ResolveUDPAddr("udp", test.local)
+		require.NoError(t, err)
+		assert.Equal(t, test.ipv4, test.family.reaches(local, ipv4), test.explanation)
+		assert.Equal(t, test.ipv6, test.family.reaches(local, ipv6), test.explanation)
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	// the peer bootstraps from itself, so it needs its port up front
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	require.NoError(t, err)
+	peerAddr := conn.LocalAddr().String()
+	require.NoError(t, conn.Close())
+
+	newIPv6DHT := func(t *testing.T, listenAddr string) *DHT {
+		d, err := NewDHT(config.DHTServiceConfig{
+			AddressFamily:  "ipv6",
+			ListenAddrs:    []string{listenAddr},
+			BootstrapPeers: []string{peerAddr},
+		}, config.ProxyConfig{})
+		require.NoError(t, err)
+		t.Cleanup(d.Close)
+		return d
+	}
+	peer := newIPv6DHT(t, peerAddr)
+	d := newIPv6DHT(t, "[::1]:0")
+
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+	put := bep44.Put{V: []byte("hello ipv6"), K: (*[32]byte)(pubKey), Seq: time.Now().Unix()}
+	put.Sign(privKey)
+
+	ctx := context.Background()
+	report, err := d.PutWithReport(ctx, put)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.Nodes)
+	for _, node := range report.Nodes {
+		assert.Nil(t, node.Addr.UDP().IP.To4(), node.Addr.String())
+		assert.True(t, node.Succeeded())
+	}
+
+	id, err := d.Put(ctx, put)
+	require.NoError(t, err)
+	got, err := peer.GetFull(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, put.Seq, got.Seq)
+
+	t.Run("ipv4 servers can't listen on ipv6 addresses", func(t *testing.T) {
+		_, err := NewDHT(config.DHTServiceConfig{
+			AddressFamily:  "ipv4",
+			ListenAddrs:    []string{"[::1]:0"},
+			BootstrapPeers: []string{peerAddr},
+		}, config.ProxyConfig{})
+		assert.ErrorContains(t, err, "failed to listen on udp4 address")
+	})
+}
@@ -0,0 +1,223 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/TBD54566975/did-dht/internal/util"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+const (
+	// canaryValue is the value of the record the prober puts and gets back
+	canaryValue = "did-dht canary"
+	// latencyWindow is the number of recent probes averaged into the reported latency
+	latencyWindow = 10
+)
+
+// Health is a snapshot of the DHT's reachability as observed by the health prober
+type Health struct {
+	// Reachable is true if the most recent canary record was put to and read back from the DHT
+	Reachable bool `json:"reachable"`
+	// LastProbe is when the most recent probe finished
+	LastProbe time.Time `json:"lastProbe,omitempty"`
+	// LastError is the error from the most recent probe, if any
+	LastError string `json:"lastError,omitempty"`
+	// AverageLatencyMillis is the average put and get round trip over recent probes
+	AverageLatencyMillis int64 `json:"averageLatencyMillis"`
+	// RespondingNodes is the number of nodes that responded during the most recent probe
+	RespondingNodes int `json:"respondingNodes"`
+	// RoutingTableNodes is the number of nodes currently in the routing table
+	RoutingTableNodes int `json:"routingTableNodes"`
+}
+
+// prober periodically puts and gets a canary record to measure the health of the DHT
+type prober struct {
+	d        *DHT
+	key      ed25519.PrivateKey
+	interval time.Duration
+
+	mu        sync.RWMutex
+	health    Health
+	latencies []time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newProber(d *DHT, interval time.Duration) (*prober, error) {
+	_, key, err := util.GenerateKeypair()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate canary key")
+	}
+	return &prober{
+		d:        d,
+		key:      key,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// StartHealthProbe starts a background prober that checks the DHT's health at the given interval
+func (d *DHT) StartHealthProbe(interval time.Duration) error {
+	if d.prober != nil {
+		return errors.New("health probe already started")
+	}
+	p, err := newProber(d, interval)
+	if err != nil {
+		return err
+	}
+	if err = p.registerMetrics(); err != nil {
+		logrus.WithError(err).Warn("failed to register dht health metrics")
+	}
+	d.prober = p
+	go p.run()
+	return nil
+}
+
+// Health returns the most recent health of the DHT. Without a running prober only the routing table size is known.
+func (d *DHT) Health() Health {
+	if d.prober == nil {
+		return Health{RoutingTableNodes: d.NumNodes()}
+	}
+	return d.prober.snapshot()
+}
+
+func (p *prober) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.probe()
+	for {
+		select {
+		case <-ticker.C:
+			p.probe()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *prober) close() {
+	close(p.stop)
+	<-p.done
+}
+
+// probe puts a fresh canary record and reads it back, recording the outcome
+func (p *prober) probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+	defer cancel()
+
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHT.probe")
+	defer span.End()
+
+	start := time.Now()
+	responding, err := p.putAndGet(ctx)
+	latency := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.health.LastProbe = time.Now()
+	p.health.RoutingTableNodes = p.d.NumNodes()
+	p.health.RespondingNodes = responding
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Warn("dht health probe failed")
+		p.health.Reachable = false
+		p.health.LastError = err.Error()
+		return
+	}
+	p.health.Reachable = true
+	p.health.LastError = ""
+	p.latencies = append(p.latencies, latency)
+	if len(p.latencies) > latencyWindow {
+		p.latencies = p.latencies[1:]
+	}
+	var total time.Duration
+	for _, l := range p.latencies {
+		total += l
+	}
+	p.health.AverageLatencyMillis = (total / time.Duration(len(p.latencies))).Milliseconds()
+}
+
+func (p *prober) putAndGet(ctx context.Context) (int, error) {
+	put := bep44.Put{
+		V:   []byte(canaryValue),
+		K:   (*[32]byte)(p.key.Public().(ed25519.PublicKey)),
+		Seq: time.Now().UnixMilli(),
+	}
+	put.Sign(p.key)
+
+	report, err := p.d.PutWithReport(ctx, put)
+	responding := 0
+	if report != nil && report.Stats != nil {
+		responding = int(report.Stats.NumResponses)
+	}
+	if err != nil {
+		return responding, errors.Wrap(err, "failed to put canary record")
+	}
+
+	got, err := p.d.GetFull(ctx, util.Z32Encode(put.K[:]))
+	if err != nil {
+		return responding, errors.Wrap(err, "failed to get canary record")
+	}
+	var value string
+	if err = bencode.Unmarshal(got.V, &value); err != nil {
+		return responding, errors.Wrap(err, "failed to decode canary record")
+	}
+	if got.Seq != put.Seq || value != canaryValue {
+		return responding, errors.Errorf("got stale canary record with seq %d, expected %d", got.Seq, put.Seq)
+	}
+	return responding, nil
+}
+
+func (p *prober) snapshot() Health {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	h := p.health
+	h.RoutingTableNodes = p.d.NumNodes()
+	return h
+}
+
+// registerMetrics exposes the prober's observations as gauges
+func (p *prober) registerMetrics() error {
+	meter := telemetry.GetMeter()
+	reachable, err := meter.Int64ObservableGauge("dht.health.reachable", metric.WithDescription("1 if the dht canary record was put and read back"))
+	if err != nil {
+		return err
+	}
+	latency, err := meter.Int64ObservableGauge("dht.health.latency", metric.WithUnit("ms"), metric.WithDescription("average canary put and get latency"))
+	if err != nil {
+		return err
+	}
+	responding, err := meter.Int64ObservableGauge("dht.health.responding_nodes", metric.WithDescription("nodes that responded during the last probe"))
+	if err != nil {
+		return err
+	}
+	routing, err := meter.Int64ObservableGauge("dht.health.routing_table_nodes", metric.WithDescription("nodes in the routing table"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		h := p.snapshot()
+		var r int64
+		if h.Reachable {
+			r = 1
+		}
+		o.ObserveInt64(reachable, r)
+		o.ObserveInt64(latency, h.AverageLatencyMillis)
+		o.ObserveInt64(responding, int64(h.RespondingNodes))
+		o.ObserveInt64(routing, int64(h.RoutingTableNodes))
+		return nil
+	}, reachable, latency, responding, routing)
+	return err
+}
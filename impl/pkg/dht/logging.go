@@ -1,10 +1,13 @@
 package dht
 
 import (
+	"context"
 	"strings"
 
 	"github.com/anacrolix/log"
 	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/internal/util"
 )
 
 func init() {
@@ -12,12 +15,32 @@ func init() {
 	log.Default.Handlers = []log.Handler{logrusHandler{}}
 }
 
+// requestID is the ID of the request a traversal runs for, carried as a value of the messages it logs
+type requestID string
+
+// withRequestLogger returns a copy of the context whose traversal logger tags messages with the context's request
+// ID, if it has one
+func withRequestLogger(ctx context.Context) context.Context {
+	id := util.RequestID(ctx)
+	if id == "" {
+		return ctx
+	}
+	return log.ContextWithLogger(ctx, log.ContextLogger(ctx).WithValues(requestID(id)))
+}
+
 type logrusHandler struct{}
 
 // Handle implements the log.Handler interface for logrus.
 // It intentionally downgrades the log level to reduce verbosity.
 func (logrusHandler) Handle(record log.Record) {
 	entry := logrus.WithField("names", strings.Join(record.Names, "/"))
+	record.Values(func(v any) bool {
+		if id, ok := v.(requestID); ok {
+			entry = entry.WithField(util.RequestIDField, string(id))
+			return false
+		}
+		return true
+	})
 	msg := strings.Replace(record.Msg.String(), "\n", "\\n", -1)
 	entry.Debug(msg)
 }
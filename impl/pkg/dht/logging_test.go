@@ -0,0 +1,30 @@
+package dht
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anacrolix/log"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/util"
+)
+
+func TestTraversalLogsRequestID(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(level)
+
+	ctx := withRequestLogger(util.WithRequestID(context.Background(), "abc"))
+	log.ContextLogger(ctx).Levelf(log.Warning, "traversal stalled")
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, "abc", hook.LastEntry().Data[util.RequestIDField])
+
+	log.ContextLogger(withRequestLogger(context.Background())).Levelf(log.Warning, "traversal stalled")
+	assert.NotContains(t, hook.LastEntry().Data, util.RequestIDField)
+}
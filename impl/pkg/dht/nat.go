@@ -0,0 +1,479 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// portMappingLifetime is the lease requested for each port mapping; mappings are renewed at half this interval
+	portMappingLifetime = time.Hour
+	// portMappingDescription identifies the gateway's mappings in its admin interface
+	portMappingDescription = "did-dht"
+	natDiscoveryTimeout    = 5 * time.Second
+
+	natPMPPort = 5351
+	ssdpAddr   = "239.255.255.250:1900"
+)
+
+// PortMapper maps UDP ports on the local network's gateway so that DHT nodes behind NAT are reachable from outside
+type PortMapper interface {
+	// ExternalIP returns the gateway's public IP address
+	ExternalIP(ctx context.Context) (net.IP, error)
+	// MapPort maps the external UDP port to the same internal port for the given lifetime, returning the external
+	// port that was actually mapped
+	MapPort(ctx context.Context, port int, lifetime time.Duration) (int, error)
+	// UnmapPort removes a mapping previously made by MapPort
+	UnmapPort(ctx context.Context, port int) error
+}
+
+// DiscoverPortMapper finds a port mapper on the local gateway, preferring NAT-PMP and falling back to UPnP
+func DiscoverPortMapper(ctx context.Context) (PortMapper, error) {
+	ctx, cancel := context.WithTimeout(ctx, natDiscoveryTimeout)
+	defer cancel()
+
+	var errs []string
+	if gateway, err := defaultGateway(); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		m := NATPMPMapper{Gateway: gateway}
+		if _, err = m.ExternalIP(ctx); err == nil {
+			return m, nil
+		}
+		errs = append(errs, errors.Wrap(err, "nat-pmp").Error())
+	}
+	m, err := DiscoverUPnP(ctx)
+	if err == nil {
+		return m, nil
+	}
+	errs = append(errs, errors.Wrap(err, "upnp").Error())
+	return nil, errors.Errorf("no port mapper found on the local gateway: %s", strings.Join(errs, "; "))
+}
+
+// NATPMPMapper maps ports with NAT-PMP (RFC 6886)
+type NATPMPMapper struct {
+	Gateway net.IP
+	// Port is the gateway's NAT-PMP port, defaulting to 5351
+	Port int
+}
+
+func (m NATPMPMapper) ExternalIP(ctx context.Context) (net.IP, error) {
+	resp, err := m.call(ctx, []byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(resp[8:12]), nil
+}
+
+func (m NATPMPMapper) MapPort(ctx context.Context, port int, lifetime time.Duration) (int, error) {
+	req := make([]byte, 12)
+	req[1] = 1 // map udp
+	binary.BigEndian.PutUint16(req[4:6], uint16(port))
+	binary.BigEndian.PutUint16(req[6:8], uint16(port))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err := m.call(ctx, req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+func (m NATPMPMapper) UnmapPort(ctx context.Context, port int) error {
+	// a mapping request with a zero lifetime deletes the mapping
+	_, err := m.MapPort(ctx, port, 0)
+	return err
+}
+
+// call sends a NAT-PMP request to the gateway, retrying with a doubling timeout until the context is done
+func (m NATPMPMapper) call(ctx context.Context, req []byte, respLen int) ([]byte, error) {
+	port := m.Port
+	if port == 0 {
+		port = natPMPPort
+	}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: m.Gateway, Port: port})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial nat-pmp gateway")
+	}
+	defer conn.Close()
+
+	resp := make([]byte, 16)
+	for timeout := 250 * time.Millisecond; ; timeout *= 2 {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err = conn.Write(req); err != nil {
+			return nil, errors.Wrap(err, "failed to send nat-pmp request")
+		}
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = conn.SetReadDeadline(deadline)
+		n, err := conn.Read(resp)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to read nat-pmp response")
+		}
+		if n < respLen || resp[0] != 0 || resp[1] != req[1]|0x80 {
+			return nil, errors.New("malformed nat-pmp response")
+		}
+		if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+			return nil, errors.Errorf("nat-pmp request failed with result code %d", code)
+		}
+		return resp[:n], nil
+	}
+}
+
+// defaultGateway reads the IPv4 default gateway from the kernel routing table
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read routing table")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		// the gateway is stored in host (little endian) byte order
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, errors.New("no default gateway found")
+}
+
+// UPnPMapper maps ports through a UPnP Internet Gateway Device's WANIPConnection or WANPPPConnection service
+type UPnPMapper struct {
+	ControlURL  string
+	ServiceType string
+	// InternalIP is the address of this host on the gateway's network, used as the mapping's internal client
+	InternalIP net.IP
+	Client     *http.Client
+}
+
+// DiscoverUPnP finds an Internet Gateway Device with SSDP and returns a mapper for its WAN connection service
+func DiscoverUPnP(ctx context.Context) (*UPnPMapper, error) {
+	location, err := ssdpSearch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewUPnPMapper(ctx, location, http.DefaultClient)
+}
+
+// NewUPnPMapper reads the device description at location and returns a mapper for its WAN connection service
+func NewUPnPMapper(ctx context.Context, location string, client *http.Client) (*UPnPMapper, error) {
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upnp device location")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create upnp description request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch upnp device description")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status fetching upnp device description: %s", resp.Status)
+	}
+
+	var desc upnpDescription
+	if err = xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, errors.Wrap(err, "failed to decode upnp device description")
+	}
+	service := desc.Device.wanConnection()
+	if service == nil {
+		return nil, errors.New("upnp device has no wan connection service")
+	}
+	base := locationURL
+	if desc.URLBase != "" {
+		if base, err = url.Parse(desc.URLBase); err != nil {
+			return nil, errors.Wrap(err, "invalid upnp url base")
+		}
+	}
+	control, err := base.Parse(service.ControlURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upnp control url")
+	}
+
+	// find the local address used to reach the gateway
+	conn, err := net.Dial("udp", locationURL.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine internal address")
+	}
+	defer conn.Close()
+	return &UPnPMapper{
+		ControlURL:  control.String(),
+		ServiceType: service.ServiceType,
+		InternalIP:  conn.LocalAddr().(*net.UDPAddr).IP,
+		Client:      client,
+	}, nil
+}
+
+func (m *UPnPMapper) ExternalIP(ctx context.Context) (net.IP, error) {
+	var resp struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := m.soap(ctx, "GetExternalIPAddress", nil, &resp); err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(resp.IP))
+	if ip == nil {
+		return nil, errors.Errorf("gateway returned invalid external ip %q", resp.IP)
+	}
+	return ip, nil
+}
+
+func (m *UPnPMapper) MapPort(ctx context.Context, port int, lifetime time.Duration) (int, error) {
+	args := [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(port)},
+		{"NewProtocol", "UDP"},
+		{"NewInternalPort", strconv.Itoa(port)},
+		{"NewInternalClient", m.InternalIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", portMappingDescription},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	}
+	if err := m.soap(ctx, "AddPortMapping", args, nil); err != nil {
+		return 0, err
+	}
+	return port, nil
+}
+
+func (m *UPnPMapper) UnmapPort(ctx context.Context, port int) error {
+	args := [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(port)},
+		{"NewProtocol", "UDP"},
+	}
+	return m.soap(ctx, "DeletePortMapping", args, nil)
+}
+
+// soap invokes an action on the gateway's WAN connection service, decoding the response envelope into out if set
+func (m *UPnPMapper) soap(ctx context.Context, action string, args [][2]string, out any) error {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, m.ServiceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		_ = xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.ControlURL, &body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create upnp %s request", action)
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, m.ServiceType, action))
+	client := m.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "upnp %s request failed", action)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return errors.Wrapf(err, "failed to read upnp %s response", action)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Code        string `xml:"Body>Fault>detail>UPnPError>errorCode"`
+			Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+		}
+		if xml.Unmarshal(respBody, &fault) == nil && fault.Code != "" {
+			return errors.Errorf("upnp %s failed with error %s: %s", action, fault.Code, fault.Description)
+		}
+		return errors.Errorf("upnp %s failed: %s", action, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err = xml.Unmarshal(respBody, out); err != nil {
+		return errors.Wrapf(err, "failed to decode upnp %s response", action)
+	}
+	return nil
+}
+
+type upnpDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Services   []upnpService `xml:"serviceList>service"`
+	Devices    []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// wanConnection searches the device tree for a WANIPConnection or WANPPPConnection service
+func (d upnpDevice) wanConnection() *upnpService {
+	for i, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return &d.Services[i]
+		}
+	}
+	for _, child := range d.Devices {
+		if s := child.wanConnection(); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// ssdpSearch multicasts an SSDP search for an Internet Gateway Device and returns the first device location
+func ssdpSearch(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open ssdp socket")
+	}
+	defer conn.Close()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err = conn.WriteTo([]byte(search), dst); err != nil {
+		return "", errors.Wrap(err, "failed to send ssdp search")
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(natDiscoveryTimeout)
+	}
+	_ = conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", errors.Wrap(err, "no upnp gateway responded")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// portMapping keeps the DHT's listen ports mapped on the gateway until closed
+type portMapping struct {
+	mapper PortMapper
+	ports  []int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newPortMapping discovers the gateway's port mapper and maps each port, returning the gateway's external IP
+func newPortMapping(ctx context.Context, ports []int) (*portMapping, net.IP, error) {
+	mapper, err := DiscoverPortMapper(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	externalIP, err := mapper.ExternalIP(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get external ip from gateway")
+	}
+	m := &portMapping{
+		mapper: mapper,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, port := range ports {
+		external, err := mapper.MapPort(ctx, port, portMappingLifetime)
+		if err != nil {
+			m.unmap()
+			return nil, nil, errors.Wrapf(err, "failed to map udp port %d", port)
+		}
+		if external != port {
+			logrus.WithField("port", port).WithField("external_port", external).
+				Warn("gateway mapped dht port to a different external port; peers will see the external port")
+		}
+		m.ports = append(m.ports, port)
+	}
+	logrus.WithField("external_ip", externalIP.String()).WithField("ports", ports).Info("mapped dht ports on gateway")
+	go m.run()
+	return m, externalIP, nil
+}
+
+func (m *portMapping) run() {
+	defer close(m.done)
+	renew := time.NewTicker(portMappingLifetime / 2)
+	defer renew.Stop()
+
+	for {
+		select {
+		case <-renew.C:
+			for _, port := range m.ports {
+				ctx, cancel := context.WithTimeout(context.Background(), natDiscoveryTimeout)
+				if _, err := m.mapper.MapPort(ctx, port, portMappingLifetime); err != nil {
+					logrus.WithError(err).WithField("port", port).Warn("failed to renew dht port mapping")
+				}
+				cancel()
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *portMapping) unmap() {
+	for _, port := range m.ports {
+		ctx, cancel := context.WithTimeout(context.Background(), natDiscoveryTimeout)
+		if err := m.mapper.UnmapPort(ctx, port); err != nil {
+			logrus.WithError(err).WithField("port", port).Warn("failed to remove dht port mapping")
+		}
+		cancel()
+	}
+}
+
+func (m *portMapping) close() {
+	close(m.stop)
+	<-m.done
+	m.unmap()
+}
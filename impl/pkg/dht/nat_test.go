@@ -0,0 +1,128 @@
+package dht_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dhtclient "github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestNATPMPMapper(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// fake gateway answering external address and udp mapping requests
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			switch {
+			case n == 2 && buf[1] == 0:
+				resp := make([]byte, 12)
+				resp[1] = 128
+				copy(resp[8:], net.IPv4(203, 0, 113, 7).To4())
+				_, _ = conn.WriteTo(resp, addr)
+			case n == 12 && buf[1] == 1:
+				resp := make([]byte, 16)
+				resp[1] = 129
+				copy(resp[8:12], buf[4:8])
+				copy(resp[12:16], buf[8:12])
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := dhtclient.NATPMPMapper{Gateway: net.IPv4(127, 0, 0, 1), Port: conn.LocalAddr().(*net.UDPAddr).Port}
+
+	ip, err := m.ExternalIP(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip.String())
+
+	port, err := m.MapPort(ctx, 6881, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 6881, port)
+	assert.NoError(t, m.UnmapPort(ctx, 6881))
+}
+
+func TestUPnPMapper(t *testing.T) {
+	const serviceType = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	var actions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList><device>
+      <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+      <deviceList><device>
+        <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+        <serviceList><service>
+          <serviceType>` + serviceType + `</serviceType>
+          <controlURL>/ctl/IPConn</controlURL>
+        </service></serviceList>
+      </device></deviceList>
+    </device></deviceList>
+  </device>
+</root>`))
+			return
+		}
+
+		assert.Equal(t, "/ctl/IPConn", r.URL.Path)
+		action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+		actions = append(actions, strings.TrimPrefix(action, serviceType+"#"))
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.HasSuffix(action, "#GetExternalIPAddress"):
+			_, _ = w.Write([]byte(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="` + serviceType + `">
+<NewExternalIPAddress>198.51.100.4</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`))
+		case strings.HasSuffix(action, "#AddPortMapping"):
+			assert.Contains(t, string(body), "<NewExternalPort>6881</NewExternalPort>")
+			assert.Contains(t, string(body), "<NewProtocol>UDP</NewProtocol>")
+		case strings.HasSuffix(action, "#DeletePortMapping"):
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><detail>
+<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>714</errorCode>
+<errorDescription>NoSuchEntryInArray</errorDescription></UPnPError>
+</detail></s:Fault></s:Body></s:Envelope>`))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	m, err := dhtclient.NewUPnPMapper(ctx, srv.URL+"/rootDesc.xml", srv.Client())
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/ctl/IPConn", m.ControlURL)
+	assert.Equal(t, serviceType, m.ServiceType)
+
+	ip, err := m.ExternalIP(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.4", ip.String())
+
+	port, err := m.MapPort(ctx, 6881, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 6881, port)
+
+	err = m.UnmapPort(ctx, 6881)
+	assert.ErrorContains(t, err, "714: NoSuchEntryInArray")
+	assert.Equal(t, []string{"GetExternalIPAddress", "AddPortMapping", "DeletePortMapping"}, actions)
+}
@@ -6,11 +6,16 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/TBD54566975/ssi-sdk/util"
 	"github.com/anacrolix/dht/v2/bep44"
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
 	"github.com/tv42/zbase32"
 )
 
@@ -25,12 +30,60 @@ func (r BEP44Response) Equals(other BEP44Response) bool {
 	return r.Seq == other.Seq && bytes.Equal(r.V, other.V) && r.Sig == other.Sig
 }
 
+// EncodedValue returns the value as BEP44 puts it to the DHT, bencoded. The record's signature is over it, the
+// sequence number, and the salt, if any.
+func (r BEP44Response) EncodedValue() ([]byte, error) {
+	return bencode.Marshal(r.V)
+}
+
 // BEP44Record represents a record in the DHT
 type BEP44Record struct {
 	Value          []byte   `json:"v" validate:"required"`
 	Key            [32]byte `json:"k" validate:"required"`
 	Signature      [64]byte `json:"sig" validate:"required"`
 	SequenceNumber int64    `json:"seq" validate:"required"`
+	// Salt optionally derives a distinct record from the same key, up to 64 bytes
+	Salt []byte `json:"salt,omitempty"`
+}
+
+const (
+	// maxSaltLength is the largest salt BEP44 allows
+	maxSaltLength = 64
+	// saltSeparator separates the z-base-32 key from the base64url salt in a record ID. It appears in neither alphabet.
+	saltSeparator = "."
+)
+
+// RecordID returns the ID of the record for the given key and salt: the z-base-32 encoded key, followed by a '.'
+// and the base64url encoded salt when there is one
+func RecordID(k []byte, salt []byte) string {
+	id := zbase32.EncodeToString(k)
+	if len(salt) == 0 {
+		return id
+	}
+	return id + saltSeparator + base64.RawURLEncoding.EncodeToString(salt)
+}
+
+// ParseRecordID returns the key and salt of the given record ID, as produced by RecordID
+func ParseRecordID(id string) (k []byte, salt []byte, err error) {
+	encodedKey, encodedSalt, salted := strings.Cut(id, saltSeparator)
+	k, err = zbase32.DecodeString(encodedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid z-base-32 encoded key: %v", err)
+	}
+	if len(k) != 32 {
+		return nil, nil, fmt.Errorf("invalid z32 encoded ed25519 public key: %s", encodedKey)
+	}
+	if !salted {
+		return k, nil, nil
+	}
+	salt, err = base64.RawURLEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid base64url encoded salt: %v", err)
+	}
+	if len(salt) == 0 || len(salt) > maxSaltLength {
+		return nil, nil, fmt.Errorf("salt must be between 1 and %d bytes", maxSaltLength)
+	}
+	return k, salt, nil
 }
 
 // FailedRecord represents a record that failed to be written to the DHT
@@ -39,17 +92,103 @@ type FailedRecord struct {
 	Count int    `json:"count"`
 }
 
+// RepublishClaims is the outcome of claiming stored records to republish, so gateway replicas sharing storage split
+// republishing between them
+type RepublishClaims struct {
+	// Claimed are the IDs of the records claimed, which the claimant is to republish
+	Claimed []string
+	// Republished holds when the records that weren't claimed, as they were republished recently, were last
+	// republished, by ID
+	Republished map[string]time.Time
+	// Leased holds when the claims of other replicas on the records that weren't claimed expire, by ID
+	Leased map[string]time.Time
+}
+
+// RecordMetadata is what a gateway knows about a stored record beyond its contents, to tell dead records from hot ones
+type RecordMetadata struct {
+	// Created is when the record was first stored
+	Created time.Time `json:"created"`
+	// Updated is when the record was last written
+	Updated time.Time `json:"updated"`
+	// LastSeen is when the record was last published or resolved through the gateway
+	LastSeen time.Time `json:"lastSeen"`
+	// LastRepublished is when the gateway last republished the record to the DHT, or nil if it never has
+	LastRepublished *time.Time `json:"lastRepublished,omitempty"`
+	// Resolutions counts the times the record was resolved through the gateway
+	Resolutions int64 `json:"resolutions"`
+}
+
+// APIKey is a key that clients of a hosted gateway authenticate with, metering their requests and publishes against
+// the key's limits rather than their IP's
+type APIKey struct {
+	// ID identifies the key, and starts the key's token
+	ID string `json:"id"`
+	// Name says who or what the key was minted for
+	Name string `json:"name,omitempty"`
+	// Hash is the SHA-256 hash of the key's token; the token itself isn't stored
+	Hash []byte `json:"-"`
+	// RequestsPerSecond is how fast the key's request bucket refills, holding up to Burst requests; zero is no limit
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+	// PublishesPerDay is how many records the key publishes a day; zero is no quota
+	PublishesPerDay int `json:"publishesPerDay"`
+	// Created is when the key was minted
+	Created time.Time `json:"created"`
+}
+
+// TypeOrder is the order the records of a type are listed in
+type TypeOrder string
+
+const (
+	// OrderStored lists records in the storage's stable order, which is the order for the zero TypeOrder
+	OrderStored TypeOrder = "stored"
+	// OrderUpdated lists the least recently updated records first, so a client can sync a type incrementally
+	OrderUpdated TypeOrder = "updated"
+	// OrderUpdatedDesc lists the most recently updated records first
+	OrderUpdatedDesc TypeOrder = "-updated"
+)
+
+// IsValid returns whether the order is one the storage lists records in
+func (o TypeOrder) IsValid() bool {
+	switch o {
+	case "", OrderStored, OrderUpdated, OrderUpdatedDesc:
+		return true
+	default:
+		return false
+	}
+}
+
+// TypeQuery selects the records of a type to list, and the order to list them in
+type TypeQuery struct {
+	Type int
+	// UpdatedSince leaves out records last written before it, unless it is zero
+	UpdatedSince time.Time
+	Order        TypeOrder
+}
+
 // NewBEP44Record returns a new BEP44Record with the given key, value, signature, and sequence number
 func NewBEP44Record(k []byte, v []byte, sig []byte, seq int64) (*BEP44Record, error) {
+	return NewSaltedBEP44Record(k, v, sig, nil, seq)
+}
+
+// NewSaltedBEP44Record returns a new BEP44Record with the given key, value, signature, salt, and sequence number
+func NewSaltedBEP44Record(k []byte, v []byte, sig []byte, salt []byte, seq int64) (*BEP44Record, error) {
 	record := BEP44Record{SequenceNumber: seq}
 
+	if len(salt) > maxSaltLength {
+		return nil, errors.New("bep44 record salt too long")
+	}
+	if len(salt) > 0 {
+		record.Salt = salt
+	}
+
 	if len(k) != 32 {
 		return nil, errors.New("incorrect key length for bep44 record")
 	}
 	record.Key = [32]byte(k)
 
-	if len(v) > 1000 {
-		return nil, errors.New("bep44 record value too long")
+	if err := ValidateDNSPacket(v); err != nil {
+		return nil, err
 	}
 	record.Value = v
 
@@ -70,6 +209,9 @@ func (r BEP44Record) IsValid() error {
 	if err := util.IsValidStruct(r); err != nil {
 		return err
 	}
+	if err := ValidateDNSPacket(r.Value); err != nil {
+		return err
+	}
 
 	// validate the signature
 	bv, err := bencode.Marshal(r.Value)
@@ -77,12 +219,31 @@ func (r BEP44Record) IsValid() error {
 		return fmt.Errorf("error bencoding bep44 record: %v", err)
 	}
 
-	if !bep44.Verify(r.Key[:], nil, r.SequenceNumber, bv, r.Signature[:]) {
-		return errors.New("signature is invalid")
+	if !bep44.Verify(r.Key[:], r.Salt, r.SequenceNumber, bv, r.Signature[:]) {
+		return ErrBadSignature
 	}
 	return nil
 }
 
+// SigningPayload returns the message the record's key signs: the bencoded salt, if any, and sequence number, followed
+// by "1:v" and the bencoded value, as in "4:salt6:foobar3:seqi1e1:v12:Hello World!"
+func (r BEP44Record) SigningPayload() ([]byte, error) {
+	var payload []byte
+	if len(r.Salt) > 0 {
+		salt, err := bencode.Marshal(r.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("error bencoding bep44 record salt: %v", err)
+		}
+		payload = append([]byte("4:salt"), salt...)
+	}
+	bv, err := bencode.Marshal(r.Value)
+	if err != nil {
+		return nil, fmt.Errorf("error bencoding bep44 record: %v", err)
+	}
+	payload = fmt.Appendf(payload, "3:seqi%de1:v", r.SequenceNumber)
+	return append(payload, bv...), nil
+}
+
 // Response returns the record as a BEP44Response
 func (r BEP44Record) Response() BEP44Response {
 	return BEP44Response{
@@ -95,22 +256,115 @@ func (r BEP44Record) Response() BEP44Response {
 // Put returns the record as a bep44.Put message
 func (r BEP44Record) Put() bep44.Put {
 	return bep44.Put{
-		V:   r.Value,
-		K:   &r.Key,
-		Sig: r.Signature,
-		Seq: r.SequenceNumber,
+		V:    r.Value,
+		K:    &r.Key,
+		Sig:  r.Signature,
+		Seq:  r.SequenceNumber,
+		Salt: r.Salt,
 	}
 }
 
 // String returns a string representation of the record
 func (r BEP44Record) String() string {
 	e := base64.RawURLEncoding
+	if len(r.Salt) > 0 {
+		return fmt.Sprintf("dht.BEP44Record{K=%s V=%s Sig=%s Seq=%d Salt=%s}", zbase32.EncodeToString(r.Key[:]), e.EncodeToString(r.Value), e.EncodeToString(r.Signature[:]), r.SequenceNumber, e.EncodeToString(r.Salt))
+	}
 	return fmt.Sprintf("dht.BEP44Record{K=%s V=%s Sig=%s Seq=%d}", zbase32.EncodeToString(r.Key[:]), e.EncodeToString(r.Value), e.EncodeToString(r.Signature[:]), r.SequenceNumber)
 }
 
-// ID returns the base32 encoded key as a string
+// ID returns the base32 encoded key as a string, followed by the base64url encoded salt if the record has one
 func (r BEP44Record) ID() string {
-	return zbase32.EncodeToString(r.Key[:])
+	return RecordID(r.Key[:], r.Salt)
+}
+
+// Deactivated reports whether the record deactivates its DID: an unsalted record whose DNS packet holds no records.
+// A gateway keeps the latest such record as the DID's tombstone, serving it in place of any earlier document.
+func (r BEP44Record) Deactivated() bool {
+	return len(r.Salt) == 0 && IsDeactivation(r.Value)
+}
+
+// typesRecordName is the name of the TXT record listing the types of a DID, such as "id=1,7"
+const typesRecordName = "_typ._did."
+
+// Types returns the indexed types of the DID the record publishes, in the order they're listed. Salted records and
+// records that aren't DNS packets publish no DID, so have no types. Types that aren't non-negative 32-bit integers
+// are skipped.
+func (r BEP44Record) Types() []int {
+	if len(r.Salt) > 0 {
+		return nil
+	}
+	var msg dns.Msg
+	if err := msg.Unpack(r.Value); err != nil {
+		return nil
+	}
+	var types []int
+	for _, rr := range msg.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok || txt.Hdr.Name != typesRecordName {
+			continue
+		}
+		for _, t := range strings.Split(strings.TrimPrefix(strings.Join(txt.Txt, ""), "id="), ",") {
+			typ, err := strconv.ParseUint(t, 10, 31)
+			if err != nil || slices.Contains(types, int(typ)) {
+				continue
+			}
+			types = append(types, int(typ))
+		}
+	}
+	return types
+}
+
+// ParseTypes returns the types of the DID the record publishes like Types, but strictly: a DID has at most one
+// types record, of the form id=H,I,J listing distinct non-negative integers, or an ErrInvalidTypes is returned.
+// Whether the types are registered is left to the caller.
+func (r BEP44Record) ParseTypes() ([]int, error) {
+	if len(r.Salt) > 0 {
+		return nil, nil
+	}
+	var msg dns.Msg
+	if err := msg.Unpack(r.Value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDNSPacket, err)
+	}
+	var types []int
+	found := false
+	for _, rr := range msg.Answer {
+		if rr.Header().Name != typesRecordName {
+			continue
+		}
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a TXT record", ErrInvalidTypes, typesRecordName)
+		}
+		if found {
+			return nil, fmt.Errorf("%w: more than one %s record", ErrInvalidTypes, typesRecordName)
+		}
+		found = true
+		list, ok := strings.CutPrefix(strings.Join(txt.Txt, ""), "id=")
+		if !ok || list == "" {
+			return nil, fmt.Errorf("%w: expected id=H,I,J", ErrInvalidTypes)
+		}
+		for _, t := range strings.Split(list, ",") {
+			typ, err := strconv.ParseUint(t, 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("%w: type %q is not a non-negative integer", ErrInvalidTypes, t)
+			}
+			if slices.Contains(types, int(typ)) {
+				return nil, fmt.Errorf("%w: duplicate type %d", ErrInvalidTypes, typ)
+			}
+			types = append(types, int(typ))
+		}
+	}
+	return types, nil
+}
+
+// IsDeactivation reports whether v is the DNS packet of a deactivated DID, which holds no records
+func IsDeactivation(v []byte) bool {
+	var msg dns.Msg
+	if err := msg.Unpack(v); err != nil {
+		return false
+	}
+	return len(msg.Question) == 0 && len(msg.Answer) == 0 && len(msg.Ns) == 0 && len(msg.Extra) == 0
 }
 
 // Hash returns the SHA256 hash of the record as a string
@@ -129,5 +383,6 @@ func RecordFromBEP44(putMsg *bep44.Put) BEP44Record {
 		Value:          putMsg.V.([]byte),
 		Signature:      putMsg.Sig,
 		SequenceNumber: putMsg.Seq,
+		Salt:           putMsg.Salt,
 	}
 }
@@ -1,9 +1,11 @@
 package dht_test
 
 import (
+	"crypto/ed25519"
 	"strings"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -31,7 +33,12 @@ func TestNewRecord(t *testing.T) {
 	require.NotEmpty(t, putMsg)
 
 	r, err = dht.NewBEP44Record(putMsg.K[:], []byte(strings.Repeat("a", 1001)), putMsg.Sig[:], putMsg.Seq)
-	assert.EqualError(t, err, "bep44 record value too long")
+	assert.EqualError(t, err, "bep44 record value too long: bencoded value is 1006 bytes, 6 over the 1000 byte limit")
+	assert.ErrorIs(t, err, dht.ErrValueTooLarge)
+	assert.Nil(t, r)
+
+	r, err = dht.NewBEP44Record(putMsg.K[:], []byte("not a dns packet"), putMsg.Sig[:], putMsg.Seq)
+	assert.ErrorIs(t, err, dht.ErrInvalidDNSPacket)
 	assert.Nil(t, r)
 
 	r, err = dht.NewBEP44Record(putMsg.K[:], putMsg.V.([]byte), []byte(strings.Repeat("a", 65)), putMsg.Seq)
@@ -66,3 +73,187 @@ func TestNewRecord(t *testing.T) {
 	assert.Equal(t, r.Signature, r2.Signature)
 	assert.Equal(t, r.SequenceNumber, r2.SequenceNumber)
 }
+
+func TestSaltedRecord(t *testing.T) {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = dht.CreateSaltedDNSPublishRequest(sk, []byte(strings.Repeat("a", 65)), *packet)
+	assert.Error(t, err)
+
+	putMsg, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("profile"), *packet)
+	require.NoError(t, err)
+
+	// the signature covers the salt
+	_, err = dht.NewBEP44Record(putMsg.K[:], putMsg.V.([]byte), putMsg.Sig[:], putMsg.Seq)
+	assert.EqualError(t, err, "signature is invalid")
+
+	r, err := dht.NewSaltedBEP44Record(putMsg.K[:], putMsg.V.([]byte), putMsg.Sig[:], putMsg.Salt, putMsg.Seq)
+	require.NoError(t, err)
+	assert.Equal(t, putMsg.Salt, r.Put().Salt)
+
+	// the signature is over the signing payload, with and without a salt
+	payload, err := r.SigningPayload()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(payload), "4:salt7:profile3:seqi"))
+	assert.True(t, ed25519.Verify(sk.Public().(ed25519.PublicKey), payload, putMsg.Sig[:]))
+	unsaltedPut, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	payload, err = dht.RecordFromBEP44(unsaltedPut).SigningPayload()
+	require.NoError(t, err)
+	assert.True(t, ed25519.Verify(sk.Public().(ed25519.PublicKey), payload, unsaltedPut.Sig[:]))
+
+	suffix, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+	assert.Equal(t, suffix+".cHJvZmlsZQ", r.ID())
+
+	k, salt, err := dht.ParseRecordID(r.ID())
+	require.NoError(t, err)
+	assert.Equal(t, putMsg.K[:], k)
+	assert.Equal(t, []byte("profile"), salt)
+
+	k, salt, err = dht.ParseRecordID(suffix)
+	require.NoError(t, err)
+	assert.Equal(t, putMsg.K[:], k)
+	assert.Nil(t, salt)
+
+	for _, id := range []string{"----", "aaaa", suffix + ".", suffix + ".!!", suffix + "." + strings.Repeat("a", 90)} {
+		_, _, err = dht.ParseRecordID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestDeactivatedRecord(t *testing.T) {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	assert.False(t, dht.RecordFromBEP44(putMsg).Deactivated())
+
+	deactivation, err := dht.CreateDNSPublishRequest(sk, *did.DeactivationDNSPacket())
+	require.NoError(t, err)
+	assert.True(t, dht.RecordFromBEP44(deactivation).Deactivated())
+
+	// salted records don't describe the did, so can't deactivate it
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("profile"), *did.DeactivationDNSPacket())
+	require.NoError(t, err)
+	assert.False(t, dht.RecordFromBEP44(salted).Deactivated())
+	assert.True(t, dht.IsDeactivation(salted.V.([]byte)))
+
+	assert.False(t, dht.IsDeactivation([]byte("not a dns packet")))
+}
+
+func TestRecordTypes(t *testing.T) {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, []did.TypeIndex{did.Organization, did.FinancialInstitution}, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 7}, dht.RecordFromBEP44(putMsg).Types())
+
+	// salted records don't describe the did, so aren't typed
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("profile"), *packet)
+	require.NoError(t, err)
+	assert.Empty(t, dht.RecordFromBEP44(salted).Types())
+
+	untyped, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err = dht.CreateDNSPublishRequest(sk, *untyped)
+	require.NoError(t, err)
+	assert.Empty(t, dht.RecordFromBEP44(putMsg).Types())
+}
+
+func TestRecordParseTypes(t *testing.T) {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	withTypes := func(records ...dns.RR) dht.BEP44Record {
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		packet.Answer = append(packet.Answer, records...)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		return dht.RecordFromBEP44(putMsg)
+	}
+	txt := func(txt ...string) dns.RR {
+		return &dns.TXT{Hdr: dns.RR_Header{Name: "_typ._did.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 7200}, Txt: txt}
+	}
+
+	types, err := withTypes().ParseTypes()
+	require.NoError(t, err)
+	assert.Empty(t, types)
+
+	// chunked records are joined
+	types, err = withTypes(txt("id=0,1", ",2,42")).ParseTypes()
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 42}, types)
+
+	for _, invalid := range [][]dns.RR{
+		{txt("1,2")},
+		{txt("id=")},
+		{txt("id=1,,2")},
+		{txt("id=-1")},
+		{txt("id=1,1")},
+		{txt("id=1"), txt("id=2")},
+		{&dns.NS{Hdr: dns.RR_Header{Name: "_typ._did.", Rrtype: dns.TypeNS, Class: dns.ClassINET}, Ns: "example.com."}},
+	} {
+		record := withTypes(invalid...)
+		_, err = record.ParseTypes()
+		assert.ErrorIs(t, err, dht.ErrInvalidTypes, invalid)
+	}
+}
+
+func TestRecordCheckLimits(t *testing.T) {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	suffix, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+	withRecords := func(records ...dns.RR) dht.BEP44Record {
+		p := packet.Copy()
+		p.Answer = append(p.Answer, records...)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *p)
+		require.NoError(t, err)
+		return dht.RecordFromBEP44(putMsg)
+	}
+	txt := func(name string) dns.RR {
+		return &dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 7200}, Txt: []string{"v=0"}}
+	}
+
+	assert.NoError(t, withRecords().CheckLimits())
+	assert.NoError(t, withRecords(txt("_prv._did."), txt("_typ._did.")).CheckLimits())
+
+	// the packet already has a root record
+	for _, tt := range []struct {
+		limit   string
+		records []dns.RR
+		actual  int
+	}{
+		{dht.LimitRootRecords, []dns.RR{txt("_did." + suffix + ".")}, 2},
+		{dht.LimitPreviousRecords, []dns.RR{txt("_prv._did."), txt("_prv._did.")}, 2},
+		{dht.LimitTypesRecords, []dns.RR{txt("_typ._did."), txt("_typ._did."), txt("_typ._did.")}, 3},
+	} {
+		err = withRecords(tt.records...).CheckLimits()
+		assert.ErrorIs(t, err, dht.ErrTooManyRecords, tt.limit)
+		var limitErr *dht.LimitError
+		require.ErrorAs(t, err, &limitErr, tt.limit)
+		assert.Equal(t, tt.limit, limitErr.Limit)
+		assert.Equal(t, 1, limitErr.Max)
+		assert.Equal(t, tt.actual, limitErr.Actual)
+	}
+
+	// salted records aren't dids, so aren't held to a did's limits
+	p := packet.Copy()
+	p.Answer = append(p.Answer, txt("_prv._did."), txt("_prv._did."))
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("profile"), *p)
+	require.NoError(t, err)
+	assert.NoError(t, dht.RecordFromBEP44(salted).CheckLimits())
+}
@@ -0,0 +1,266 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// maxRelayPayload bounds the body read from a relay: a signature, a sequence number, and a value of at most 1000 bytes
+const maxRelayPayload = 64 + 8 + 1000
+
+// Relay is a Client resolving and publishing records through Pkarr relays over HTTP rather than on the DHT itself,
+// for gateways whose proxy carries no UDP, such as Tor. Relays key records by public key alone, so salted records
+// can't be published or resolved through them. https://pkarr.org/relays
+type Relay struct {
+	urls   []string
+	client *http.Client
+}
+
+var _ Client = (*Relay)(nil)
+
+// NewRelay returns a client of the Pkarr relays at the given base URLs, sending its requests with the given client
+func NewRelay(urls []string, client *http.Client) *Relay {
+	trimmed := make([]string, 0, len(urls))
+	for _, u := range urls {
+		trimmed = append(trimmed, strings.TrimSuffix(u, "/"))
+	}
+	return &Relay{urls: trimmed, client: client}
+}
+
+// Put puts the given BEP-44 value to every relay, succeeding if any relay accepts it, and returns its record ID
+func (r *Relay) Put(ctx context.Context, request bep44.Put) (string, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "Relay.Put")
+	defer span.End()
+
+	if err := r.put(ctx, request); err != nil {
+		return "", err
+	}
+	return RecordID(request.K[:], request.Salt), nil
+}
+
+// PutMany puts each of the given BEP-44 values to the relays, running up to the batch's workers at once, and returns
+// the result of each put in the same order as the requests. Relays don't report the nodes that stored a value, so
+// the results have no reports.
+func (r *Relay) PutMany(ctx context.Context, requests []bep44.Put, batch dhtint.PutManyConfig) []dhtint.PutManyResult {
+	ctx, span := telemetry.GetTracer().Start(ctx, "Relay.PutMany")
+	defer span.End()
+
+	results := make([]dhtint.PutManyResult, len(requests))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range max(batch.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i].Err = r.putOne(ctx, requests[i], batch)
+			}
+		}()
+	}
+	for i := range requests {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+func (r *Relay) putOne(ctx context.Context, request bep44.Put, batch dhtint.PutManyConfig) error {
+	if batch.Limiter != nil {
+		if err := batch.Limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if batch.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, batch.Timeout)
+		defer cancel()
+	}
+	return r.put(ctx, request)
+}
+
+func (r *Relay) put(ctx context.Context, request bep44.Put) error {
+	if err := ValidatePut(request); err != nil {
+		return errors.Wrap(err, "invalid put")
+	}
+	key := RecordID(request.K[:], request.Salt)
+	if len(request.Salt) > 0 {
+		return errors.Errorf("failed to put key[%s] to relays: pkarr relays don't support salted records", key)
+	}
+	v, err := rawValue(request.V)
+	if err != nil {
+		return errors.Wrapf(err, "failed to put key[%s] to relays", key)
+	}
+	payload := make([]byte, 0, 72+len(v))
+	payload = append(payload, request.Sig[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(request.Seq))
+	payload = append(payload, v...)
+
+	errs := make([]error, len(r.urls))
+	var wg sync.WaitGroup
+	for i, u := range r.urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = r.putTo(ctx, u+"/"+key, payload)
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		logrus.WithContext(ctx).WithError(err).WithField("relay", r.urls[i]).WithField("key", key).Debug("relay rejected put")
+		failed = append(failed, err.Error())
+	}
+	if len(failed) == len(r.urls) {
+		return errors.Wrapf(ErrAllPutsFailed, "failed to put key[%s] to relays: %s", key, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (r *Relay) putTo(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// GetFull returns the newest version of the record with the given ID any relay serves, counting the relays serving
+// that version as its confirmations. It fails with ErrNotFound if every relay answers that it has no such record, and
+// with ErrStalled if no relay answers at all.
+func (r *Relay) GetFull(ctx context.Context, key string) (*dhtint.FullGetResult, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "Relay.GetFull")
+	defer span.End()
+
+	k, salt, err := ParseRecordID(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode key [%s]", key)
+	}
+	if len(salt) > 0 {
+		return nil, errors.Errorf("failed to get key[%s] from relays: pkarr relays don't support salted records", key)
+	}
+
+	results := make([]*dhtint.FullGetResult, len(r.urls))
+	errs := make([]error, len(r.urls))
+	var wg sync.WaitGroup
+	for i, u := range r.urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = r.getFrom(ctx, u+"/"+key, k)
+		}()
+	}
+	wg.Wait()
+
+	var newest *dhtint.FullGetResult
+	notFound := 0
+	for i, res := range results {
+		switch {
+		case errors.Is(errs[i], ErrNotFound):
+			notFound++
+		case errs[i] != nil:
+			logrus.WithContext(ctx).WithError(errs[i]).WithField("relay", r.urls[i]).WithField("key", key).Debug("failed to get from relay")
+		case newest == nil || res.Seq > newest.Seq:
+			newest = res
+		case res.Seq == newest.Seq && bytes.Equal(res.V, newest.V):
+			newest.Confirmations++
+		}
+	}
+	switch {
+	case newest != nil:
+		return newest, nil
+	case notFound == len(r.urls):
+		return nil, errors.Wrapf(ErrNotFound, "failed to get key[%s] from relays", key)
+	default:
+		return nil, errors.Wrapf(ErrStalled, "failed to get key[%s] from relays: %d of %d relays answered", key, notFound, len(r.urls))
+	}
+}
+
+// getFrom gets the record with the given key from the relay URL, verifying its signature
+func (r *Relay) getFrom(ctx context.Context, url string, k []byte) (*dhtint.FullGetResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, maxRelayPayload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 72 || len(payload) > maxRelayPayload {
+		return nil, fmt.Errorf("%s served a payload of %d bytes", url, len(payload))
+	}
+	v, err := bencode.Marshal(payload[72:])
+	if err != nil {
+		return nil, err
+	}
+	res := dhtint.FullGetResult{
+		Seq:           int64(binary.BigEndian.Uint64(payload[64:72])),
+		V:             v,
+		Mutable:       true,
+		Confirmations: 1,
+	}
+	copy(res.Sig[:], payload[:64])
+	if !bep44.Verify(k, nil, res.Seq, v, res.Sig[:]) {
+		return nil, errors.Wrapf(ErrBadSignature, "%s served a record", url)
+	}
+	return &res, nil
+}
+
+// Close closes the client's idle connections to the relays
+func (r *Relay) Close() {
+	r.client.CloseIdleConnections()
+}
+
+// rawValue returns the bytes of a put's value, which relays carry without its bencoding
+func rawValue(v any) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	encoded, err := bencode.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode value")
+	}
+	var b []byte
+	if err = bencode.Unmarshal(encoded, &b); err != nil {
+		return nil, errors.Wrap(err, "value isn't a byte string")
+	}
+	return b, nil
+}
@@ -0,0 +1,165 @@
+package dht_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/internal/util"
+	dhtclient "github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// fakeRelay serves records the way a Pkarr relay does: the signature, the big endian sequence number, and the raw
+// value, keyed by public key
+type fakeRelay struct {
+	mu      sync.Mutex
+	records map[string][]byte
+}
+
+func newFakeRelay(t *testing.T) (*fakeRelay, *httptest.Server) {
+	r := &fakeRelay{records: make(map[string][]byte)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := strings.TrimPrefix(req.URL.Path, "/")
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		switch req.Method {
+		case http.MethodPut:
+			payload, err := io.ReadAll(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.records[key] = payload
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			payload, ok := r.records[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(payload)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return r, srv
+}
+
+func signedPut(t *testing.T, v string, seq int64) bep44.Put {
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+	put := bep44.Put{V: []byte(v), K: (*[32]byte)(pubKey), Seq: seq}
+	put.Sign(privKey)
+	return put
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	first, firstSrv := newFakeRelay(t)
+	_, secondSrv := newFakeRelay(t)
+	relay := dhtclient.NewRelay([]string{firstSrv.URL + "/", secondSrv.URL}, &http.Client{Timeout: 5 * time.Second})
+	t.Cleanup(relay.Close)
+
+	put := signedPut(t, "hello relay", time.Now().Unix())
+	id, err := relay.Put(ctx, put)
+	require.NoError(t, err)
+	assert.Equal(t, dhtclient.RecordID(put.K[:], nil), id)
+
+	got, err := relay.GetFull(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, put.Seq, got.Seq)
+	assert.Equal(t, put.Sig, got.Sig)
+	assert.Equal(t, "11:hello relay", string(got.V))
+	assert.Equal(t, 2, got.Confirmations)
+
+	t.Run("newest version wins", func(t *testing.T) {
+		pubKey, privKey, err := util.GenerateKeypair()
+		require.NoError(t, err)
+		older := bep44.Put{V: []byte("older"), K: (*[32]byte)(pubKey), Seq: 1}
+		older.Sign(privKey)
+		newer := bep44.Put{V: []byte("newer"), K: (*[32]byte)(pubKey), Seq: 2}
+		newer.Sign(privKey)
+
+		id, err := relay.Put(ctx, older)
+		require.NoError(t, err)
+		// only the first relay learns of the newer version
+		_, err = dhtclient.NewRelay([]string{firstSrv.URL}, http.DefaultClient).Put(ctx, newer)
+		require.NoError(t, err)
+
+		got, err := relay.GetFull(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), got.Seq)
+		assert.Equal(t, "5:newer", string(got.V))
+		assert.Equal(t, 1, got.Confirmations)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		id := dhtclient.RecordID(signedPut(t, "unpublished", 1).K[:], nil)
+		_, err := relay.GetFull(ctx, id)
+		assert.ErrorIs(t, err, dhtclient.ErrNotFound)
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		put := signedPut(t, "tampered", 1)
+		id, err := relay.Put(ctx, put)
+		require.NoError(t, err)
+		first.mu.Lock()
+		payload := first.records[id]
+		payload[len(payload)-1] ^= 0xff
+		first.mu.Unlock()
+
+		// the second relay still serves the genuine record
+		got, err := relay.GetFull(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "8:tampered", string(got.V))
+		assert.Equal(t, 1, got.Confirmations)
+	})
+
+	t.Run("salted records", func(t *testing.T) {
+		pubKey, privKey, err := util.GenerateKeypair()
+		require.NoError(t, err)
+		put := bep44.Put{V: []byte("salted"), K: (*[32]byte)(pubKey), Salt: []byte("salt"), Seq: 1}
+		put.Sign(privKey)
+		_, err = relay.Put(ctx, put)
+		assert.ErrorContains(t, err, "don't support salted records")
+
+		_, err = relay.GetFull(ctx, dhtclient.RecordID(put.K[:], put.Salt))
+		assert.ErrorContains(t, err, "don't support salted records")
+	})
+
+	t.Run("put many", func(t *testing.T) {
+		puts := []bep44.Put{signedPut(t, "one", 1), signedPut(t, "two", 2)}
+		results := relay.PutMany(ctx, puts, dhtint.PutManyConfig{Workers: 2})
+		require.Len(t, results, 2)
+		for i, res := range results {
+			require.NoError(t, res.Err)
+			got, err := relay.GetFull(ctx, dhtclient.RecordID(puts[i].K[:], nil))
+			require.NoError(t, err)
+			assert.Equal(t, puts[i].Seq, got.Seq)
+		}
+	})
+}
+
+func TestRelayUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	relay := dhtclient.NewRelay([]string{srv.URL}, &http.Client{Timeout: 5 * time.Second})
+
+	put := signedPut(t, "hello", 1)
+	_, err := relay.Put(context.Background(), put)
+	assert.ErrorIs(t, err, dhtclient.ErrAllPutsFailed)
+
+	_, err = relay.GetFull(context.Background(), dhtclient.RecordID(put.K[:], nil))
+	assert.ErrorIs(t, err, dhtclient.ErrStalled)
+}
@@ -0,0 +1,148 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// bucketCapacity is the number of nodes the library keeps in each routing table bucket
+const bucketCapacity = 8
+
+// RoutingTable is a snapshot of the DHT server's routing table
+type RoutingTable struct {
+	NodeID                  string               `json:"nodeId"`
+	Addr                    string               `json:"addr"`
+	Nodes                   int                  `json:"nodes"`
+	GoodNodes               int                  `json:"goodNodes"`
+	OutstandingTransactions int                  `json:"outstandingTransactions"`
+	Buckets                 []RoutingTableBucket `json:"buckets"`
+}
+
+// RoutingTableBucket is a non-empty bucket of the routing table, indexed by the length of the prefix its nodes
+// share with the server's node ID
+type RoutingTableBucket struct {
+	Index    int                `json:"index"`
+	Fill     int                `json:"fill"`
+	Capacity int                `json:"capacity"`
+	Nodes    []RoutingTableNode `json:"nodes"`
+}
+
+// RoutingTableNode is a node in the routing table. Last seen times are approximate to the second.
+type RoutingTableNode struct {
+	ID           string     `json:"id"`
+	Addr         string     `json:"addr"`
+	LastQuery    *time.Time `json:"lastQuery,omitempty"`
+	LastResponse *time.Time `json:"lastResponse,omitempty"`
+	Receives     int        `json:"receives"`
+	Flags        []string   `json:"flags,omitempty"`
+}
+
+// RoutingTable returns a snapshot of the routing table. The library does not export its table, so the snapshot is
+// parsed from its status output.
+func (d *DHT) RoutingTable() (*RoutingTable, error) {
+	stats := d.Stats()
+	id := d.ID()
+	table := RoutingTable{
+		NodeID:                  hex.EncodeToString(id[:]),
+		Addr:                    d.Addr().String(),
+		Nodes:                   stats.Nodes,
+		GoodNodes:               stats.GoodNodes,
+		OutstandingTransactions: stats.OutstandingTransactions,
+	}
+
+	var status bytes.Buffer
+	d.WriteStatus(&status)
+	buckets, err := parseBuckets(&status, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse routing table")
+	}
+	table.Buckets = buckets
+	return &table, nil
+}
+
+// parseBuckets reads the bucket and node lines of the library's status output, which look like
+//
+//	b# 159: 2 nodes, last updated: 3s ago
+//	  node id                                  addr           last query last response recv discard flags
+//	  0b1c...                                  1.2.3.4:6881   never      3s ago        1    false   good
+func parseBuckets(status *bytes.Buffer, now time.Time) ([]RoutingTableBucket, error) {
+	var buckets []RoutingTableBucket
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "b# ") {
+			index, err := strconv.Atoi(strings.TrimPrefix(strings.SplitN(line, ":", 2)[0], "b# "))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid bucket line %q", line)
+			}
+			buckets = append(buckets, RoutingTableBucket{Index: index, Capacity: bucketCapacity})
+			continue
+		}
+		if !strings.HasPrefix(line, "  ") || len(buckets) == 0 {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "node" {
+			continue
+		}
+		n, err := parseNode(fields, now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid node line %q", line)
+		}
+		b := &buckets[len(buckets)-1]
+		b.Nodes = append(b.Nodes, *n)
+		b.Fill = len(b.Nodes)
+	}
+	return buckets, scanner.Err()
+}
+
+func parseNode(fields []string, now time.Time) (*RoutingTableNode, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("missing node id or address")
+	}
+	n := RoutingTableNode{ID: fields[0], Addr: fields[1]}
+	rest := fields[2:]
+	var err error
+	if n.LastQuery, rest, err = parseSince(rest, now); err != nil {
+		return nil, err
+	}
+	if n.LastResponse, rest, err = parseSince(rest, now); err != nil {
+		return nil, err
+	}
+	// recv, discard and an optional comma separated list of flags
+	if len(rest) < 2 {
+		return nil, errors.New("missing receive count")
+	}
+	if n.Receives, err = strconv.Atoi(rest[0]); err != nil {
+		return nil, err
+	}
+	if len(rest) > 2 {
+		n.Flags = strings.Split(rest[2], ",")
+	}
+	return &n, nil
+}
+
+// parseSince parses either "never" or "<duration> ago" from the front of fields
+func parseSince(fields []string, now time.Time) (*time.Time, []string, error) {
+	if len(fields) == 0 {
+		return nil, nil, errors.New("missing time")
+	}
+	if fields[0] == "never" {
+		return nil, fields[1:], nil
+	}
+	if len(fields) < 2 || fields[1] != "ago" {
+		return nil, nil, errors.Errorf("invalid time %q", fields[0])
+	}
+	since, err := time.ParseDuration(fields[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	t := now.Add(-since)
+	return &t, fields[2:], nil
+}
@@ -0,0 +1,50 @@
+package dht
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBuckets(t *testing.T) {
+	status := bytes.NewBufferString(`Listening on 127.0.0.1:6881
+Nodes in table: 1 good, 2 total
+Ongoing transactions: 0
+Server node ID: 0102
+b# 3: 2 nodes, last updated: 5s ago
+  node id addr          last query last response recv discard flags
+  aa      1.2.3.4:6881  never      3s ago        4    false   good,sec
+  bb      5.6.7.8:6881  1m2s ago   never         0    true
+b# 9: 0 nodes, last updated: 1h0m0s ago
+
+`)
+	now := time.Now()
+	buckets, err := parseBuckets(status, now)
+	require.NoError(t, err)
+	require.Len(t, buckets, 2)
+
+	assert.Equal(t, 3, buckets[0].Index)
+	assert.Equal(t, 2, buckets[0].Fill)
+	assert.Equal(t, bucketCapacity, buckets[0].Capacity)
+
+	first := buckets[0].Nodes[0]
+	assert.Equal(t, "aa", first.ID)
+	assert.Equal(t, "1.2.3.4:6881", first.Addr)
+	assert.Nil(t, first.LastQuery)
+	require.NotNil(t, first.LastResponse)
+	assert.Equal(t, now.Add(-3*time.Second), *first.LastResponse)
+	assert.Equal(t, 4, first.Receives)
+	assert.Equal(t, []string{"good", "sec"}, first.Flags)
+
+	second := buckets[0].Nodes[1]
+	require.NotNil(t, second.LastQuery)
+	assert.Equal(t, now.Add(-62*time.Second), *second.LastQuery)
+	assert.Nil(t, second.LastResponse)
+	assert.Empty(t, second.Flags)
+
+	assert.Equal(t, 9, buckets[1].Index)
+	assert.Zero(t, buckets[1].Fill)
+}
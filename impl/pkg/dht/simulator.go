@@ -0,0 +1,166 @@
+package dht
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/pkg/errors"
+
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+)
+
+// Client is the set of DHT operations the gateway's services depend on. DHT implements it against the Mainline
+// DHT, and Simulator implements it in memory so services and handlers can be tested deterministically.
+type Client interface {
+	// Put puts the given BEP-44 value into the DHT and returns its record ID
+	Put(ctx context.Context, request bep44.Put) (string, error)
+	// PutMany puts each of the given BEP-44 values, returning the result of each in the same order
+	PutMany(ctx context.Context, requests []bep44.Put, batch dhtint.PutManyConfig) []dhtint.PutManyResult
+	// GetFull returns the full BEP-44 result, including signature data, for the given record ID
+	GetFull(ctx context.Context, key string) (*dhtint.FullGetResult, error)
+	// Close releases any resources held by the client
+	Close()
+}
+
+var (
+	_ Client = (*DHT)(nil)
+	_ Client = (*Simulator)(nil)
+)
+
+// Simulator is an in-memory Client that stores items locally instead of on the Mainline DHT. It follows BEP-44
+// semantics: signatures and value sizes are checked, items are addressed by the hash of their key and salt, and a
+// put is rejected if its sequence number is older than the stored item's or its CAS doesn't match.
+type Simulator struct {
+	mu          sync.RWMutex
+	items       map[bep44.Target]*bep44.Item
+	unreachable bool
+	puts        int
+	gets        int
+}
+
+// NewSimulator returns an empty simulated DHT
+func NewSimulator() *Simulator {
+	return &Simulator{items: make(map[bep44.Target]*bep44.Item)}
+}
+
+// SetUnreachable makes every subsequent operation fail with ErrStalled, as if no DHT nodes responded, until it is
+// set back to false
+func (s *Simulator) SetUnreachable(unreachable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unreachable = unreachable
+}
+
+// Puts returns the number of puts made against the simulator, including failed puts
+func (s *Simulator) Puts() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.puts
+}
+
+// Gets returns the number of gets made against the simulator, including failed gets
+func (s *Simulator) Gets() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gets
+}
+
+func (s *Simulator) Put(ctx context.Context, request bep44.Put) (string, error) {
+	if _, err := s.put(ctx, request); err != nil {
+		return "", err
+	}
+	var k []byte
+	if request.K != nil {
+		k = request.K[:]
+	}
+	return RecordID(k, request.Salt), nil
+}
+
+// put stores the item, returning the sequence number of the item it replaces or would have replaced, or 0 if there
+// was none
+func (s *Simulator) put(ctx context.Context, request bep44.Put) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if s.unreachable {
+		return 0, errors.Wrap(ErrStalled, "simulated dht is unreachable")
+	}
+
+	if err := ValidatePut(request); err != nil {
+		return 0, errors.Wrap(err, "invalid put")
+	}
+	item := bep44.Item{V: request.V, Salt: request.Salt, Sig: request.Sig, Cas: request.Cas, Seq: request.Seq}
+	if request.K != nil {
+		item.K = *request.K
+	}
+	if err := bep44.Check(&item); err != nil {
+		if errors.Is(err, bep44.ErrInvalidSignature) {
+			return 0, ErrBadSignature
+		}
+		return 0, errors.Wrap(err, "invalid bep44 item")
+	}
+	target := item.Target()
+	var storedSeq int64
+	if stored, ok := s.items[target]; ok && item.IsMutable() {
+		storedSeq = stored.Seq
+		if err := bep44.CheckIncoming(stored, &item); err != nil {
+			return storedSeq, errors.Wrap(err, "put rejected")
+		}
+	}
+	s.items[target] = &item
+	return storedSeq, nil
+}
+
+func (s *Simulator) PutMany(ctx context.Context, requests []bep44.Put, _ dhtint.PutManyConfig) []dhtint.PutManyResult {
+	results := make([]dhtint.PutManyResult, len(requests))
+	for i, request := range requests {
+		storedSeq, err := s.put(ctx, request)
+		if storedSeq > 0 || err == nil {
+			results[i].Report = &dhtint.PutReport{NewestSeq: storedSeq}
+		}
+		if err != nil {
+			results[i].Err = errors.Wrapf(err, "failed to put key[%s] into dht", RecordID(request.K[:], request.Salt))
+		}
+	}
+	return results
+}
+
+func (s *Simulator) GetFull(ctx context.Context, key string) (*dhtint.FullGetResult, error) {
+	t, _, err := target(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.unreachable {
+		return nil, errors.Wrapf(ErrStalled, "failed to get key[%s] from dht: simulated dht is unreachable", key)
+	}
+	item, ok := s.items[t]
+	if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "failed to get key[%s] from dht", key)
+	}
+	v, err := bencode.Marshal(item.V)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode value for key[%s]", key)
+	}
+	return &dhtint.FullGetResult{
+		Seq:           item.Seq,
+		V:             v,
+		Sig:           item.Sig,
+		Mutable:       item.IsMutable(),
+		Confirmations: 1,
+	}, nil
+}
+
+// Close is a no-op, since the simulator holds no resources
+func (s *Simulator) Close() {}
@@ -0,0 +1,80 @@
+package dht_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/internal/util"
+	dhtclient "github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestSimulator(t *testing.T) {
+	ctx := context.Background()
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+	newPut := func(v string, seq int64) bep44.Put {
+		put := bep44.Put{V: []byte(v), K: (*[32]byte)(pubKey), Seq: seq}
+		put.Sign(privKey)
+		return put
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		sim := dhtclient.NewSimulator()
+		id, err := sim.Put(ctx, newPut("hello", 1))
+		require.NoError(t, err)
+		assert.Equal(t, dhtclient.RecordID(pubKey, nil), id)
+
+		got, err := sim.GetFull(ctx, id)
+		require.NoError(t, err)
+		var payload string
+		require.NoError(t, bencode.Unmarshal(got.V, &payload))
+		assert.Equal(t, "hello", payload)
+		assert.Equal(t, int64(1), got.Seq)
+		assert.True(t, got.Mutable)
+		assert.Equal(t, 1, sim.Puts())
+		assert.Equal(t, 1, sim.Gets())
+	})
+
+	t.Run("bep44 semantics", func(t *testing.T) {
+		sim := dhtclient.NewSimulator()
+		_, err := sim.Put(ctx, newPut("v2", 2))
+		require.NoError(t, err)
+
+		// republishing the same item is accepted, an older seq is not
+		_, err = sim.Put(ctx, newPut("v2", 2))
+		assert.NoError(t, err)
+		_, err = sim.Put(ctx, newPut("v1", 1))
+		assert.ErrorIs(t, err, bep44.ErrSequenceNumberLessThanCurrent)
+
+		bad := newPut("v3", 3)
+		bad.Sig[0] ^= 0xff
+		_, err = sim.Put(ctx, bad)
+		assert.ErrorIs(t, err, dhtclient.ErrBadSignature)
+
+		_, err = sim.GetFull(ctx, dhtclient.RecordID(pubKey, []byte("other salt")))
+		assert.ErrorIs(t, err, dhtclient.ErrNotFound)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		sim := dhtclient.NewSimulator()
+		id, err := sim.Put(ctx, newPut("hello", 1))
+		require.NoError(t, err)
+
+		sim.SetUnreachable(true)
+		_, err = sim.GetFull(ctx, id)
+		assert.ErrorIs(t, err, dhtclient.ErrStalled)
+		results := sim.PutMany(ctx, []bep44.Put{newPut("hello", 2)}, dhtint.PutManyConfig{})
+		require.Len(t, results, 1)
+		assert.ErrorIs(t, results[0].Err, dhtclient.ErrStalled)
+
+		sim.SetUnreachable(false)
+		_, err = sim.GetFull(ctx, id)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,87 @@
+package dht
+
+import (
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// serverState persists a DHT server's node ID and routing table across restarts, so the node rejoins the network
+// with the same identity and a warm routing table rather than bootstrapping cold. Each listen address gets its own
+// pair of files in the state directory.
+type serverState struct {
+	dir  string
+	name string
+}
+
+func newServerState(dir, listenAddr string) serverState {
+	name := strings.NewReplacer(":", "_", "[", "", "]", "", "/", "_").Replace(listenAddr)
+	return serverState{dir: dir, name: name}
+}
+
+func (s serverState) nodeIDPath() string {
+	return filepath.Join(s.dir, s.name+".id")
+}
+
+func (s serverState) nodesPath() string {
+	return filepath.Join(s.dir, s.name+".nodes")
+}
+
+// loadNodeID returns the saved node ID, or a zero ID if none was saved. A saved ID is discarded when it is not
+// secure for the given public IP, which happens when the node's public address has changed since it was saved.
+func (s serverState) loadNodeID(publicIP net.IP) (krpc.ID, error) {
+	var id krpc.ID
+	b, err := os.ReadFile(s.nodeIDPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return id, nil
+	}
+	if err != nil {
+		return id, errors.Wrap(err, "failed to read node id")
+	}
+	decoded, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(decoded) != len(id) {
+		return id, errors.Errorf("invalid node id in %s", s.nodeIDPath())
+	}
+	copy(id[:], decoded)
+	if publicIP != nil && !dht.NodeIdSecure(id, publicIP) {
+		logrus.WithField("public_ip", publicIP.String()).Info("saved node id is not secure for the public ip, generating a new one")
+		return krpc.ID{}, nil
+	}
+	return id, nil
+}
+
+// loadNodes adds the saved routing table nodes to the server, returning how many were added
+func (s serverState) loadNodes(server *dht.Server) (int, error) {
+	added, err := server.AddNodesFromFile(s.nodesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return added, errors.Wrap(err, "failed to load routing table")
+	}
+	return added, nil
+}
+
+// save writes the server's node ID and current routing table
+func (s serverState) save(server *dht.Server) error {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return errors.Wrap(err, "failed to create dht state directory")
+	}
+	id := server.ID()
+	if err := os.WriteFile(s.nodeIDPath(), []byte(hex.EncodeToString(id[:])), 0o640); err != nil {
+		return errors.Wrap(err, "failed to write node id")
+	}
+	if nodes := server.Nodes(); len(nodes) > 0 {
+		if err := dht.WriteNodesToFile(nodes, s.nodesPath()); err != nil {
+			return errors.Wrap(err, "failed to write routing table")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package dht
+
+import (
+	"net"
+	"testing"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerState(t *testing.T) {
+	state := newServerState(t.TempDir(), "0.0.0.0:6881")
+
+	t.Run("nothing saved", func(t *testing.T) {
+		id, err := state.loadNodeID(nil)
+		require.NoError(t, err)
+		assert.True(t, id.IsZero())
+
+		added, err := state.loadNodes(NewTestDHT(t).Server)
+		require.NoError(t, err)
+		assert.Zero(t, added)
+	})
+
+	t.Run("save and restore", func(t *testing.T) {
+		peer := NewTestDHT(t)
+		d := NewTestDHT(t, dht.NewAddr(peer.Addr()))
+		require.NotZero(t, d.NumNodes())
+		require.NoError(t, state.save(d.Server))
+
+		id, err := state.loadNodeID(nil)
+		require.NoError(t, err)
+		assert.Equal(t, krpc.ID(d.ID()), id)
+
+		restored := NewTestDHT(t)
+		added, err := state.loadNodes(restored.Server)
+		require.NoError(t, err)
+		assert.Positive(t, added)
+	})
+
+	t.Run("id not secure for public ip", func(t *testing.T) {
+		id, err := state.loadNodeID(net.IPv4(203, 0, 113, 7))
+		require.NoError(t, err)
+		assert.True(t, id.IsZero())
+	})
+}
@@ -0,0 +1,146 @@
+package dht
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// maxValueLength is the largest bencoded v BEP44 allows. Nodes measure the bencoded form, so a raw value of 1000
+// bytes is already over the limit once its length prefix is added.
+const maxValueLength = 1000
+
+// ValidatePut checks a put against BEP44's limits before it is sent, so a put remote nodes would reject fails fast
+// with a descriptive error instead of after a full traversal.
+func ValidatePut(request bep44.Put) error {
+	if request.K == nil {
+		return errors.New("put is missing its public key")
+	}
+	if len(request.Salt) > maxSaltLength {
+		return fmt.Errorf("salt is %d bytes, over the %d byte limit", len(request.Salt), maxSaltLength)
+	}
+	bv, err := bencode.Marshal(request.V)
+	if err != nil {
+		return errors.Wrap(err, "failed to bencode value")
+	}
+	return checkValueLength(len(bv))
+}
+
+// ValidateDNSPacket checks that v is a DNS packet that fits in a BEP44 value, as the DID DHT spec requires of
+// every record's value
+func ValidateDNSPacket(v []byte) error {
+	bv, err := bencode.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to bencode value")
+	}
+	if err = checkValueLength(len(bv)); err != nil {
+		return err
+	}
+	if err = new(dns.Msg).Unpack(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDNSPacket, err)
+	}
+	return nil
+}
+
+func checkValueLength(n int) error {
+	if n > maxValueLength {
+		return &LimitError{
+			Limit:  LimitValueLength,
+			Max:    maxValueLength,
+			Actual: n,
+			err:    ErrValueTooLarge,
+			detail: fmt.Sprintf("bencoded value is %d bytes, %d over the %d byte limit", n, n-maxValueLength, maxValueLength),
+		}
+	}
+	return nil
+}
+
+// Limits a record's DNS packet is checked against, named in the LimitError of a record over one
+const (
+	// LimitValueLength is BEP44's 1000 byte limit on a record's bencoded value
+	LimitValueLength = "value_length"
+	// LimitRootRecords is the DID DHT spec's limit of one root _did.<ID>. TXT record per DID
+	LimitRootRecords = "root_records"
+	// LimitPreviousRecords is the DID DHT spec's limit of one _prv._did. record per DID
+	LimitPreviousRecords = "previous_records"
+	// LimitTypesRecords is the DID DHT spec's limit of one _typ._did. record per DID
+	LimitTypesRecords = "types_records"
+)
+
+// previousRecordName is the name of the TXT record linking a DID to the DID it replaces
+const previousRecordName = "_prv._did."
+
+// LimitError is returned for a record over one of the limits BEP44 and the DID DHT spec set, naming the limit so a
+// publisher can tell exactly what to fix. It wraps ErrValueTooLarge or ErrTooManyRecords.
+type LimitError struct {
+	// Limit is the name of the limit, one of the Limit constants
+	Limit string
+	// Max is the most the limit allows
+	Max int
+	// Actual is how much the record has
+	Actual int
+
+	err    error
+	detail string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s", e.err, e.detail)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.err
+}
+
+// Over returns how much the record is over the limit, such as the number of bytes to trim from a value
+func (e *LimitError) Over() int {
+	return e.Actual - e.Max
+}
+
+// CheckLimits checks the DNS packet of an unsalted record against the DID DHT spec's limits on how many of each
+// record a DID has: one root record, one previous record, and one types record. Salted records aren't DIDs, so they
+// aren't checked. The value is expected to have passed ValidateDNSPacket.
+func (r BEP44Record) CheckLimits() error {
+	if len(r.Salt) > 0 {
+		return nil
+	}
+	var msg dns.Msg
+	if err := msg.Unpack(r.Value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDNSPacket, err)
+	}
+
+	rootRecordName := "_did." + r.ID() + "."
+	var roots, previous, types int
+	for _, rr := range msg.Answer {
+		switch name := rr.Header().Name; {
+		case name == rootRecordName && rr.Header().Rrtype == dns.TypeTXT:
+			roots++
+		case name == previousRecordName:
+			previous++
+		case name == typesRecordName:
+			types++
+		}
+	}
+	for _, count := range []struct {
+		limit, name string
+		actual      int
+	}{
+		{LimitRootRecords, rootRecordName + " TXT", roots},
+		{LimitPreviousRecords, previousRecordName, previous},
+		{LimitTypesRecords, typesRecordName, types},
+	} {
+		if count.actual > 1 {
+			return &LimitError{
+				Limit:  count.limit,
+				Max:    1,
+				Actual: count.actual,
+				err:    ErrTooManyRecords,
+				detail: fmt.Sprintf("%d %s records, a DID has at most one", count.actual, count.name),
+			}
+		}
+	}
+	return nil
+}
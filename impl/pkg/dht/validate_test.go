@@ -0,0 +1,61 @@
+package dht_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/internal/util"
+	dhtclient "github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestValidatePut(t *testing.T) {
+	pubKey, privKey, err := util.GenerateKeypair()
+	require.NoError(t, err)
+	newPut := func(v string) bep44.Put {
+		put := bep44.Put{V: []byte(v), K: (*[32]byte)(pubKey), Seq: time.Now().Unix()}
+		put.Sign(privKey)
+		return put
+	}
+
+	t.Run("largest value fits", func(t *testing.T) {
+		// a 996 byte value bencodes to exactly 1000 bytes with its "996:" prefix
+		assert.NoError(t, dhtclient.ValidatePut(newPut(strings.Repeat("a", 996))))
+	})
+
+	t.Run("value over the limit once bencoded", func(t *testing.T) {
+		err := dhtclient.ValidatePut(newPut(strings.Repeat("a", 997)))
+		assert.ErrorIs(t, err, dhtclient.ErrValueTooLarge)
+		assert.ErrorContains(t, err, "bencoded value is 1001 bytes, 1 over the 1000 byte limit")
+	})
+
+	t.Run("salt too long", func(t *testing.T) {
+		put := newPut("hello")
+		put.Salt = []byte(strings.Repeat("s", 65))
+		assert.ErrorContains(t, dhtclient.ValidatePut(put), "salt is 65 bytes")
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		assert.Error(t, dhtclient.ValidatePut(bep44.Put{V: []byte("hello")}))
+	})
+
+	t.Run("put fails before traversing", func(t *testing.T) {
+		d := dhtclient.NewTestDHT(t)
+		defer d.Close()
+
+		_, err := d.Put(context.Background(), newPut(strings.Repeat("a", 1000)))
+		assert.ErrorIs(t, err, dhtclient.ErrValueTooLarge)
+
+		results := d.PutMany(context.Background(), []bep44.Put{newPut(strings.Repeat("a", 1000)), newPut("hello")}, dhtint.PutManyConfig{})
+		require.Len(t, results, 2)
+		assert.ErrorIs(t, results[0].Err, dhtclient.ErrValueTooLarge)
+		assert.Nil(t, results[0].Report)
+		assert.NoError(t, results[1].Err)
+	})
+}
@@ -0,0 +1,224 @@
+// Package httpsig signs the gateway's HTTP responses with an ed25519 key, as HTTP Message Signatures, so clients can
+// verify a response came from the gateway unaltered even when TLS is terminated by a proxy in between.
+// https://www.rfc-editor.org/rfc/rfc9421
+//
+// Each signed response carries a Content-Digest of its body (https://www.rfc-editor.org/rfc/rfc9530), and a signature
+// labelled diddht over its status, its digest, and the method, path, and query of the request it answers, so a
+// response can't be replayed as the answer to another request:
+//
+//	Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:
+//	Signature-Input: diddht=("@status" "content-digest" "@method";req "@path";req "@query";req);created=1618884473;keyid="...";alg="ed25519"
+//	Signature: diddht=:...:
+//
+// The key ID is the RFC 7638 thumbprint of the key as a JWK.
+//
+// A DID's owner signs requests the gateway only serves to the owner the same way, with the DID's identity key, over
+// the method and path of the request:
+//
+//	Signature-Input: diddht=("@method" "@path");created=1618884473;keyid="...";alg="ed25519"
+//	Signature: diddht=:...:
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Label is the label of the gateway's signature in the Signature and Signature-Input headers
+	Label = "diddht"
+	// Algorithm is the signature algorithm, from the HTTP Signature Algorithms registry
+	Algorithm = "ed25519"
+
+	ContentDigestHeader  = "Content-Digest"
+	SignatureHeader      = "Signature"
+	SignatureInputHeader = "Signature-Input"
+)
+
+// components are the components of a response, and of the request it answers, that are signed
+const components = `"@status" "content-digest" "@method";req "@path";req "@query";req`
+
+// requestComponents are the components of a request that are signed
+const requestComponents = `"@method" "@path"`
+
+var (
+	ErrMissingSignature = errors.New("response is not signed")
+	ErrInvalidSignature = errors.New("invalid response signature")
+
+	ErrMissingRequestSignature = errors.New("request is not signed")
+	ErrInvalidRequestSignature = errors.New("invalid request signature")
+)
+
+// Signer signs responses with an ed25519 key
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+	now   func() time.Time
+}
+
+// NewSigner returns a signer signing with the given key
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey)), now: time.Now}
+}
+
+// KeyID returns the ID signatures by the signer name its key by
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// PublicKey returns the key signatures by the signer are verified with
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// SignResponse sets the Content-Digest, Signature-Input, and Signature headers of a response to the request with the
+// given status and body
+func (s *Signer) SignResponse(req *http.Request, status int, header http.Header, body []byte) {
+	header.Set(ContentDigestHeader, contentDigest(body))
+	params := fmt.Sprintf(`(%s);created=%d;keyid="%s";alg="%s"`, components, s.now().Unix(), s.keyID, Algorithm)
+	sig := ed25519.Sign(s.key, []byte(signatureBase(req, status, header.Get(ContentDigestHeader), params)))
+	header.Set(SignatureInputHeader, Label+"="+params)
+	header.Set(SignatureHeader, Label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+}
+
+// signatureInput matches the signature parameters of the gateway's signature
+var signatureInput = regexp.MustCompile(`^\(` + regexp.QuoteMeta(components) + `\);created=(\d+);keyid="([^"]*)";alg="` + Algorithm + `"$`)
+
+// VerifyResponse verifies the gateway's signature of a response, and that the body is the one signed. The response's
+// Request must be the request it answers.
+func VerifyResponse(resp *http.Response, body []byte, key ed25519.PublicKey) error {
+	input, ok := strings.CutPrefix(resp.Header.Get(SignatureInputHeader), Label+"=")
+	if !ok {
+		return ErrMissingSignature
+	}
+	m := signatureInput.FindStringSubmatch(input)
+	if m == nil {
+		return fmt.Errorf("%w: unsupported signature input: %s", ErrInvalidSignature, input)
+	}
+	if m[2] != KeyID(key) {
+		return fmt.Errorf("%w: signed by key %s, not %s", ErrInvalidSignature, m[2], KeyID(key))
+	}
+	if _, err := strconv.ParseInt(m[1], 10, 64); err != nil {
+		return fmt.Errorf("%w: invalid created time: %s", ErrInvalidSignature, m[1])
+	}
+
+	encoded, ok := strings.CutPrefix(resp.Header.Get(SignatureHeader), Label+"=:")
+	if !ok || !strings.HasSuffix(encoded, ":") {
+		return ErrMissingSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(encoded, ":"))
+	if err != nil {
+		return fmt.Errorf("%w: signature is not base64 encoded", ErrInvalidSignature)
+	}
+
+	digest := resp.Header.Get(ContentDigestHeader)
+	if digest != contentDigest(body) {
+		return fmt.Errorf("%w: body doesn't match its content digest", ErrInvalidSignature)
+	}
+	if resp.Request == nil {
+		return errors.New("response has no request to verify its signature against")
+	}
+	if !ed25519.Verify(key, []byte(signatureBase(resp.Request, resp.StatusCode, digest, input)), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SignRequest sets the Signature-Input and Signature headers of a request, signing its method and path with the given key
+func SignRequest(req *http.Request, key ed25519.PrivateKey) {
+	params := fmt.Sprintf(`(%s);created=%d;keyid="%s";alg="%s"`, requestComponents, time.Now().Unix(),
+		KeyID(key.Public().(ed25519.PublicKey)), Algorithm)
+	sig := ed25519.Sign(key, []byte(requestSignatureBase(req, params)))
+	req.Header.Set(SignatureInputHeader, Label+"="+params)
+	req.Header.Set(SignatureHeader, Label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+}
+
+// requestSignatureInput matches the signature parameters of a signed request
+var requestSignatureInput = regexp.MustCompile(`^\(` + regexp.QuoteMeta(requestComponents) + `\);created=(\d+);keyid="([^"]*)";alg="` + Algorithm + `"$`)
+
+// VerifyRequest verifies a request's signature by the given key, and that it was signed no more than maxAge ago, so a
+// captured request can't be replayed for long
+func VerifyRequest(req *http.Request, key ed25519.PublicKey, maxAge time.Duration) error {
+	input, ok := strings.CutPrefix(req.Header.Get(SignatureInputHeader), Label+"=")
+	if !ok {
+		return ErrMissingRequestSignature
+	}
+	m := requestSignatureInput.FindStringSubmatch(input)
+	if m == nil {
+		return fmt.Errorf("%w: unsupported signature input: %s", ErrInvalidRequestSignature, input)
+	}
+	if m[2] != KeyID(key) {
+		return fmt.Errorf("%w: signed by key %s, not %s", ErrInvalidRequestSignature, m[2], KeyID(key))
+	}
+	created, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid created time: %s", ErrInvalidRequestSignature, m[1])
+	}
+	if age := time.Since(time.Unix(created, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: signed at %s, outside the %s allowed", ErrInvalidRequestSignature, time.Unix(created, 0).UTC(), maxAge)
+	}
+
+	encoded, ok := strings.CutPrefix(req.Header.Get(SignatureHeader), Label+"=:")
+	if !ok || !strings.HasSuffix(encoded, ":") {
+		return ErrMissingRequestSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(encoded, ":"))
+	if err != nil {
+		return fmt.Errorf("%w: signature is not base64 encoded", ErrInvalidRequestSignature)
+	}
+	if !ed25519.Verify(key, []byte(requestSignatureBase(req, input)), sig) {
+		return ErrInvalidRequestSignature
+	}
+	return nil
+}
+
+// KeyID returns the RFC 7638 JWK thumbprint of an ed25519 public key
+func KeyID(key ed25519.PublicKey) string {
+	thumbprint := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(key) + `"}`))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
+
+// contentDigest returns the Content-Digest of a body, its SHA-256 hash
+func contentDigest(body []byte) string {
+	digest := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+}
+
+// signatureBase returns the RFC 9421 signature base of the signed components of a response
+func signatureBase(req *http.Request, status int, digest, params string) string {
+	lines := []string{
+		fmt.Sprintf(`"@status": %d`, status),
+		fmt.Sprintf(`"content-digest": %s`, digest),
+		fmt.Sprintf(`"@method";req: %s`, req.Method),
+		fmt.Sprintf(`"@path";req: %s`, requestPath(req)),
+		fmt.Sprintf(`"@query";req: ?%s`, req.URL.RawQuery),
+		fmt.Sprintf(`"@signature-params": %s`, params),
+	}
+	return strings.Join(lines, "\n")
+}
+
+// requestSignatureBase returns the RFC 9421 signature base of the signed components of a request
+func requestSignatureBase(req *http.Request, params string) string {
+	lines := []string{
+		fmt.Sprintf(`"@method": %s`, req.Method),
+		fmt.Sprintf(`"@path": %s`, requestPath(req)),
+		fmt.Sprintf(`"@signature-params": %s`, params),
+	}
+	return strings.Join(lines, "\n")
+}
+
+// requestPath returns the @path component of a request, its escaped path
+func requestPath(req *http.Request) string {
+	if path := req.URL.EscapedPath(); path != "" {
+		return path
+	}
+	return "/"
+}
@@ -0,0 +1,108 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignResponse(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer := NewSigner(key)
+	signer.now = func() time.Time { return time.Unix(1618884473, 0) }
+	assert.Equal(t, KeyID(pub), signer.KeyID())
+
+	req := httptest.NewRequest(http.MethodGet, "https://diddht-service.com/abc/versions/1?versionTime=x", nil)
+	body := []byte(`{"hello": "world"}`)
+	signed := func() *http.Response {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+		signer.SignResponse(req, resp.StatusCode, resp.Header, body)
+		return resp
+	}
+
+	resp := signed()
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", resp.Header.Get(ContentDigestHeader))
+	assert.Equal(t, `diddht=("@status" "content-digest" "@method";req "@path";req "@query";req);created=1618884473;keyid="`+
+		signer.KeyID()+`";alg="ed25519"`, resp.Header.Get(SignatureInputHeader))
+	assert.NoError(t, VerifyResponse(resp, body, pub))
+
+	t.Run("tampered body", func(t *testing.T) {
+		assert.ErrorIs(t, VerifyResponse(signed(), []byte(`{"hello": "there"}`), pub), ErrInvalidSignature)
+	})
+
+	t.Run("tampered status", func(t *testing.T) {
+		resp := signed()
+		resp.StatusCode = http.StatusNotFound
+		assert.ErrorIs(t, VerifyResponse(resp, body, pub), ErrInvalidSignature)
+	})
+
+	t.Run("answering another request", func(t *testing.T) {
+		resp := signed()
+		resp.Request = httptest.NewRequest(http.MethodGet, "https://diddht-service.com/abc/versions/2?versionTime=x", nil)
+		assert.ErrorIs(t, VerifyResponse(resp, body, pub), ErrInvalidSignature)
+	})
+
+	t.Run("another key", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		assert.ErrorIs(t, VerifyResponse(signed(), body, other), ErrInvalidSignature)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+		assert.ErrorIs(t, VerifyResponse(resp, body, pub), ErrMissingSignature)
+	})
+}
+
+func TestSignRequest(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	signed := func(method, target string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		SignRequest(req, key)
+		return req
+	}
+
+	req := signed(http.MethodPost, "https://diddht-service.com/dids/did:dht:abc/republish")
+	assert.Regexp(t, `^diddht=\("@method" "@path"\);created=\d+;keyid="`+KeyID(pub)+`";alg="ed25519"$`, req.Header.Get(SignatureInputHeader))
+	assert.NoError(t, VerifyRequest(req, pub, time.Minute))
+
+	t.Run("another path", func(t *testing.T) {
+		req := signed(http.MethodPost, "https://diddht-service.com/dids/did:dht:abc/republish")
+		req.URL.Path = "/dids/did:dht:xyz/republish"
+		assert.ErrorIs(t, VerifyRequest(req, pub, time.Minute), ErrInvalidRequestSignature)
+	})
+
+	t.Run("another method", func(t *testing.T) {
+		req := signed(http.MethodPost, "https://diddht-service.com/dids/did:dht:abc/republish")
+		req.Method = http.MethodDelete
+		assert.ErrorIs(t, VerifyRequest(req, pub, time.Minute), ErrInvalidRequestSignature)
+	})
+
+	t.Run("another key", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		assert.ErrorIs(t, VerifyRequest(signed(http.MethodPost, "/republish"), other, time.Minute), ErrInvalidRequestSignature)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/republish", nil)
+		params := `("@method" "@path");created=1618884473;keyid="` + KeyID(pub) + `";alg="ed25519"`
+		req.Header.Set(SignatureInputHeader, Label+"="+params)
+		req.Header.Set(SignatureHeader, Label+"=:"+base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(requestSignatureBase(req, params))))+":")
+		assert.ErrorIs(t, VerifyRequest(req, pub, time.Minute), ErrInvalidRequestSignature)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/republish", nil)
+		assert.ErrorIs(t, VerifyRequest(req, pub, time.Minute), ErrMissingRequestSignature)
+	})
+}
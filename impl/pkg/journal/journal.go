@@ -0,0 +1,148 @@
+// Package journal keeps an append-only journal of every record a gateway accepts. Records are journaled before they
+// are written to storage, so replaying the journal rebuilds the store up to any point in time, and the journal is an
+// audit log of every mutation the gateway accepted.
+//
+// The journal is a JSON Lines file with one entry per accepted record:
+//
+//	{"time":"2024-01-02T03:04:05.123Z","id":"<record id>","seq":1704164645,"sig":"...","v":"..."}
+//
+// where id is the record ID (the z-base-32 encoded key, followed by the encoded salt for salted records), and sig
+// and v are the unpadded base64url encoded signature and value.
+package journal
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+var encoding = base64.RawURLEncoding
+
+// Entry is one record accepted by the gateway
+type Entry struct {
+	Time time.Time `json:"time"`
+	ID   string    `json:"id"`
+	Seq  int64     `json:"seq"`
+	Sig  string    `json:"sig"`
+	V    string    `json:"v"`
+}
+
+// NewEntry returns the journal entry of the given record, accepted at the given time
+func NewEntry(record dht.BEP44Record, at time.Time) Entry {
+	return Entry{
+		Time: at.UTC(),
+		ID:   record.ID(),
+		Seq:  record.SequenceNumber,
+		Sig:  encoding.EncodeToString(record.Signature[:]),
+		V:    encoding.EncodeToString(record.Value),
+	}
+}
+
+// Record returns the journaled record, verifying its signature
+func (e Entry) Record() (*dht.BEP44Record, error) {
+	key, salt, err := dht.ParseRecordID(e.ID)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := encoding.DecodeString(e.Sig)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 sig field: %v", err)
+	}
+	v, err := encoding.DecodeString(e.V)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 value field: %v", err)
+	}
+	return dht.NewSaltedBEP44Record(key, v, sig, salt, e.Seq)
+}
+
+// Journal appends accepted records to a journal file
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+	sync bool
+}
+
+// Open opens the journal at the configured path for appending, creating it if needed, or returns nil if journaling
+// isn't configured
+func Open(cfg config.JournalConfig) (*Journal, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(cfg.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open journal %s", cfg.Path)
+	}
+	return &Journal{file: file, sync: cfg.Sync}, nil
+}
+
+// Append journals the given record as accepted now. When the journal syncs, the entry is on disk once Append returns.
+// Appending to a nil journal does nothing.
+func (j *Journal) Append(record dht.BEP44Record) error {
+	if j == nil {
+		return nil
+	}
+	line, err := json.Marshal(NewEntry(record, time.Now()))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err = j.file.Write(line); err != nil {
+		return errors.Wrap(err, "failed to append to journal")
+	}
+	if j.sync {
+		if err = j.file.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync journal")
+		}
+	}
+	return nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// Read calls fn with every entry in the journal read from r, in the order they were appended. A final line that was
+// only partly written, as after a crash, is skipped.
+func Read(r io.Reader, fn func(Entry) error) error {
+	reader := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				logrus.WithField("line", lineNum).Warn("skipping partly written final journal entry")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry Entry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return errors.Wrapf(err, "invalid journal entry on line %d", lineNum)
+		}
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := Open(config.JournalConfig{Path: path, Sync: true})
+	require.NoError(t, err)
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	first, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	second := *first
+	second.Seq++
+	second.Sign(sk)
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+
+	require.NoError(t, j.Append(dht.RecordFromBEP44(first)))
+	require.NoError(t, j.Append(dht.RecordFromBEP44(salted)))
+	time.Sleep(10 * time.Millisecond)
+	checkpoint := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, j.Append(dht.RecordFromBEP44(&second)))
+	require.NoError(t, j.Close())
+
+	journaled, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	t.Run("entries are read in order", func(t *testing.T) {
+		var entries []Entry
+		require.NoError(t, Read(bytes.NewReader(journaled), func(e Entry) error {
+			entries = append(entries, e)
+			return nil
+		}))
+		require.Len(t, entries, 3)
+		assert.Equal(t, first.Seq, entries[0].Seq)
+		assert.Equal(t, dht.RecordFromBEP44(salted).ID(), entries[1].ID)
+		assert.Equal(t, second.Seq, entries[2].Seq)
+
+		record, err := entries[1].Record()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("salt"), record.Salt)
+	})
+
+	t.Run("a partly written final entry is skipped", func(t *testing.T) {
+		truncated := journaled[:len(journaled)-10]
+		var count int
+		require.NoError(t, Read(bytes.NewReader(truncated), func(Entry) error {
+			count++
+			return nil
+		}))
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("replay rebuilds storage", func(t *testing.T) {
+		db := newTestStorage(t)
+		count, err := Replay(context.Background(), bytes.NewReader(journaled), db, time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		got, err := db.ReadRecord(context.Background(), dht.RecordFromBEP44(first).ID())
+		require.NoError(t, err)
+		assert.Equal(t, second.Seq, got.SequenceNumber)
+
+		versions, err := db.ListRecordVersions(context.Background(), got.ID())
+		require.NoError(t, err)
+		assert.Len(t, versions, 2)
+	})
+
+	t.Run("replay stops at the given time", func(t *testing.T) {
+		db := newTestStorage(t)
+		count, err := Replay(context.Background(), bytes.NewReader(journaled), db, checkpoint)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		got, err := db.ReadRecord(context.Background(), dht.RecordFromBEP44(first).ID())
+		require.NoError(t, err)
+		assert.Equal(t, first.Seq, got.SequenceNumber)
+	})
+
+	t.Run("invalid entries fail the replay", func(t *testing.T) {
+		_, err := Replay(context.Background(), strings.NewReader("not json\n"), newTestStorage(t), time.Time{})
+		assert.ErrorContains(t, err, "invalid journal entry on line 1")
+	})
+}
+
+func TestOpenDisabled(t *testing.T) {
+	j, err := Open(config.JournalConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, j)
+	assert.NoError(t, j.Append(dht.BEP44Record{}))
+	assert.NoError(t, j.Close())
+}
+
+func newTestStorage(t *testing.T) storage.Storage {
+	db, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "diddht.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
@@ -0,0 +1,39 @@
+package journal
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+// Replay writes every record journaled up to and including the given time to the storage, in the order they were
+// accepted, returning the number of entries replayed. A zero time replays the whole journal.
+func Replay(ctx context.Context, r io.Reader, db storage.Storage, until time.Time) (int, error) {
+	var count int
+	errStop := errors.New("stop")
+	err := Read(r, func(entry Entry) error {
+		if !until.IsZero() && entry.Time.After(until) {
+			return errStop
+		}
+		record, err := entry.Record()
+		if err != nil {
+			return errors.Wrapf(err, "invalid journal entry for record %s", entry.ID)
+		}
+		if err = db.WriteRecord(ctx, *record); err != nil {
+			return errors.Wrapf(err, "failed to replay record %s", entry.ID)
+		}
+		if err = db.WriteRecordVersion(ctx, *record); err != nil {
+			return errors.Wrapf(err, "failed to replay version %d of record %s", entry.Seq, entry.ID)
+		}
+		count++
+		return nil
+	})
+	if errors.Is(err, errStop) {
+		err = nil
+	}
+	return count, err
+}
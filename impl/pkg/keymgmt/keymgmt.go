@@ -0,0 +1,217 @@
+// Package keymgmt manages the identity keys of did:dht DIDs for wallets publishing their own DIDs. A Key generates a
+// DID, builds and encodes its document as a DNS packet, and signs the packet as a BEP44 record, giving each record a
+// sequence number above the last it signed, so wallets needn't reimplement BEP44 signing.
+//
+// Keys are saved as JSON, holding the last sequence number signed, so a wallet reloading a key keeps bumping it:
+//
+//	{"did": "did:dht:...", "privateKey": "<base64url ed25519 seed>", "seq": 1715578800}
+package keymgmt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// VerificationMethod is a verification method to include in a DID document, besides the identity key, with the
+// purposes it's used for
+type VerificationMethod = did.VerificationMethod
+
+// Options are the properties of a DID's document, besides its identity key, and the properties published only in
+// its DNS packet
+type Options struct {
+	// Controller are the DIDs controlling the DID
+	Controller []string
+	// AlsoKnownAs are alternative identifiers of the DID, as absolute URIs
+	AlsoKnownAs []string
+	// VerificationMethods are verification methods to include; ID 0 is reserved for the identity key
+	VerificationMethods []VerificationMethod
+	Services            []didsdk.Service
+	// Types are the indexed types of the DID, such as 1 for Organization
+	Types []int
+	// Gateways are the fully qualified domain names of the DID's authoritative gateways, such as gateway.example.
+	Gateways []string
+	// Supersedes is the key of the DID this one replaces, if any, which signs over the DID to name it as the previous
+	// DID
+	Supersedes *Key
+}
+
+// Key is the identity key of a DID, signing the records publishing it
+type Key struct {
+	private ed25519.PrivateKey
+	did     string
+
+	mu sync.Mutex
+	// seq is the sequence number of the last record the key signed
+	seq int64
+	// now returns the current time, which sequence numbers are at least
+	now func() time.Time
+}
+
+// GenerateKey generates the identity key of a new DID
+func GenerateKey() (*Key, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate identity key")
+	}
+	return NewKey(private, 0), nil
+}
+
+// NewKey returns the key of the DID whose identity key is the given private key, which last signed a record with
+// the given sequence number, or 0 if none
+func NewKey(private ed25519.PrivateKey, seq int64) *Key {
+	return &Key{
+		private: private,
+		did:     did.GetDIDDHTIdentifier(private.Public().(ed25519.PublicKey)),
+		seq:     seq,
+		now:     time.Now,
+	}
+}
+
+// DID returns the DID the key is the identity key of
+func (k *Key) DID() string {
+	return k.did
+}
+
+// PublicKey returns the identity key, which the DID's suffix encodes
+func (k *Key) PublicKey() ed25519.PublicKey {
+	return k.private.Public().(ed25519.PublicKey)
+}
+
+// PrivateKey returns the private key
+func (k *Key) PrivateKey() ed25519.PrivateKey {
+	return k.private
+}
+
+// Seq returns the sequence number of the last record the key signed, or 0 if none
+func (k *Key) Seq() int64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.seq
+}
+
+// ObserveSeq raises the sequence number the key's next record must be above to the given one, such as that of a
+// version published from another device, so the next record supersedes it
+func (k *Key) ObserveSeq(seq int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.seq = max(k.seq, seq)
+}
+
+// Document builds the DID's document with the given options
+func (k *Key) Document(opts Options) (*didsdk.Document, error) {
+	// the verification methods and services are qualified with the DID in place, so the options are left as given
+	return did.CreateDIDDHTDID(k.PublicKey(), did.CreateDIDDHTOpts{
+		Controller:          opts.Controller,
+		AlsoKnownAs:         opts.AlsoKnownAs,
+		VerificationMethods: slices.Clone(opts.VerificationMethods),
+		Services:            slices.Clone(opts.Services),
+	})
+}
+
+// Encode encodes the DID's document as the DNS packet publishing it, with the types, gateways, and previous DID of
+// the given options
+func (k *Key) Encode(doc didsdk.Document, opts Options) (*dns.Msg, error) {
+	if doc.ID != k.did {
+		return nil, fmt.Errorf("document of %s isn't the document of %s", doc.ID, k.did)
+	}
+	types := make([]did.TypeIndex, 0, len(opts.Types))
+	for _, typ := range opts.Types {
+		types = append(types, did.TypeIndex(typ))
+	}
+	gateways := make([]did.AuthoritativeGateway, 0, len(opts.Gateways))
+	for _, gateway := range opts.Gateways {
+		gateways = append(gateways, did.AuthoritativeGateway(gateway))
+	}
+	var previousDID *did.PreviousDID
+	if previous := opts.Supersedes; previous != nil {
+		var err error
+		if previousDID, err = did.CreatePreviousDIDRecord(previous.private, did.DHT(previous.did), did.DHT(k.did)); err != nil {
+			return nil, errors.Wrap(err, "failed to sign previous did record")
+		}
+	}
+	return did.DHT(k.did).ToDNSPacket(doc, types, gateways, previousDID)
+}
+
+// Publish builds, encodes, and signs the DID's document with the given options, returning the record to publish
+func (k *Key) Publish(opts Options) (*bep44.Put, error) {
+	doc, err := k.Document(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build did document")
+	}
+	msg, err := k.Encode(*doc, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode did document")
+	}
+	return k.Sign(*msg)
+}
+
+// Deactivate signs the record deactivating the DID
+func (k *Key) Deactivate() (*bep44.Put, error) {
+	return k.Sign(*did.DeactivationDNSPacket())
+}
+
+// Sign signs a DNS packet as the DID's next record, with a sequence number above the last the key signed: the current
+// Unix time in seconds, as the spec recommends, unless the key signed a record in the same second or later
+func (k *Key) Sign(msg dns.Msg) (*bep44.Put, error) {
+	// CreateDNSPublishRequest checks the packet fits in a record
+	put, err := dht.CreateDNSPublishRequest(k.private, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.seq = max(k.now().Unix(), k.seq+1)
+	put.Seq = k.seq
+	put.Sign(k.private)
+	return put, nil
+}
+
+// keyJSON is a key as it is saved
+type keyJSON struct {
+	DID string `json:"did"`
+	// PrivateKey is the base64url encoded seed of the ed25519 private key
+	PrivateKey string `json:"privateKey"`
+	Seq        int64  `json:"seq"`
+}
+
+// MarshalJSON encodes the key with the last sequence number it signed, to be saved
+func (k *Key) MarshalJSON() ([]byte, error) {
+	return json.Marshal(keyJSON{
+		DID:        k.did,
+		PrivateKey: base64.RawURLEncoding.EncodeToString(k.private.Seed()),
+		Seq:        k.Seq(),
+	})
+}
+
+// UnmarshalJSON decodes a saved key, checking it is the identity key of the DID it was saved with
+func (k *Key) UnmarshalJSON(data []byte) error {
+	var saved keyJSON
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	seed, err := base64.RawURLEncoding.DecodeString(saved.PrivateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return errors.New("private key must be a base64url encoded ed25519 seed")
+	}
+	key := NewKey(ed25519.NewKeyFromSeed(seed), saved.Seq)
+	if saved.DID != "" && saved.DID != key.did {
+		return fmt.Errorf("private key is the identity key of %s, not %s", key.did, saved.DID)
+	}
+	k.private, k.did, k.seq, k.now = key.private, key.did, key.seq, key.now
+	return nil
+}
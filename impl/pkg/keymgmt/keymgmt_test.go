@@ -0,0 +1,126 @@
+package keymgmt
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestPublish(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	assert.True(t, did.DHT(key.DID()).IsValid())
+
+	opts := Options{
+		Services: []didsdk.Service{{ID: "dwn", Type: "DecentralizedWebNode", ServiceEndpoint: "https://dwn.example"}},
+		Types:    []int{1, 7},
+		Gateways: []string{"gateway.example."},
+	}
+	put, err := key.Publish(opts)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(put)
+	require.NoError(t, record.IsValid())
+	assert.Equal(t, put.Seq, key.Seq())
+
+	// the record decodes to the document the key builds
+	msg := new(dns.Msg)
+	require.NoError(t, msg.Unpack(record.Value))
+	decoded, err := did.DHT(key.DID()).FromDNSPacket(msg)
+	require.NoError(t, err)
+	doc, err := key.Document(opts)
+	require.NoError(t, err)
+	assert.Equal(t, doc.VerificationMethod, decoded.Doc.VerificationMethod)
+	require.Len(t, decoded.Doc.Services, 1)
+	assert.Equal(t, key.DID()+"#dwn", decoded.Doc.Services[0].ID)
+	assert.Equal(t, "dwn", opts.Services[0].ID)
+	assert.Equal(t, []did.TypeIndex{1, 7}, decoded.Types)
+	assert.Equal(t, []did.AuthoritativeGateway{"gateway.example."}, decoded.Gateways)
+
+	t.Run("document of another did", func(t *testing.T) {
+		other, err := GenerateKey()
+		require.NoError(t, err)
+		_, err = other.Encode(*doc, opts)
+		assert.Error(t, err)
+	})
+}
+
+func TestSeq(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	now := time.Unix(1715578800, 0)
+	key.now = func() time.Time { return now }
+
+	first, err := key.Publish(Options{})
+	require.NoError(t, err)
+	assert.Equal(t, now.Unix(), first.Seq)
+
+	// records signed in the same second still supersede each other
+	second, err := key.Publish(Options{})
+	require.NoError(t, err)
+	assert.Equal(t, first.Seq+1, second.Seq)
+	require.NoError(t, dht.RecordFromBEP44(second).IsValid())
+
+	// a version published elsewhere is superseded
+	key.ObserveSeq(now.Unix() + 100)
+	deactivation, err := key.Deactivate()
+	require.NoError(t, err)
+	assert.Equal(t, now.Unix()+101, deactivation.Seq)
+	assert.True(t, dht.RecordFromBEP44(deactivation).Deactivated())
+
+	now = now.Add(time.Hour)
+	third, err := key.Publish(Options{})
+	require.NoError(t, err)
+	assert.Equal(t, now.Unix(), third.Seq)
+}
+
+func TestSupersedes(t *testing.T) {
+	previous, err := GenerateKey()
+	require.NoError(t, err)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	put, err := key.Publish(Options{Supersedes: previous})
+	require.NoError(t, err)
+	msg := new(dns.Msg)
+	require.NoError(t, msg.Unpack(put.V.([]byte)))
+	decoded, err := did.DHT(key.DID()).FromDNSPacket(msg)
+	require.NoError(t, err)
+	require.NotNil(t, decoded.PreviousDID)
+	assert.Equal(t, did.DHT(previous.DID()), decoded.PreviousDID.PreviousDID)
+	assert.NoError(t, did.ValidatePreviousDIDSignatureValid(did.DHT(key.DID()), *decoded.PreviousDID))
+}
+
+func TestKeyJSON(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	_, err = key.Publish(Options{})
+	require.NoError(t, err)
+
+	saved, err := json.Marshal(key)
+	require.NoError(t, err)
+	var loaded Key
+	require.NoError(t, json.Unmarshal(saved, &loaded))
+	assert.Equal(t, key.DID(), loaded.DID())
+	assert.Equal(t, key.PrivateKey(), loaded.PrivateKey())
+	assert.Equal(t, key.Seq(), loaded.Seq())
+
+	next, err := loaded.Publish(Options{})
+	require.NoError(t, err)
+	assert.Greater(t, next.Seq, key.Seq())
+
+	other, err := GenerateKey()
+	require.NoError(t, err)
+	mismatched, err := json.Marshal(keyJSON{DID: other.DID(), PrivateKey: base64.RawURLEncoding.EncodeToString(key.PrivateKey().Seed())})
+	require.NoError(t, err)
+	assert.Error(t, json.Unmarshal(mismatched, &loaded))
+	assert.Error(t, json.Unmarshal([]byte(`{"privateKey": "short"}`), &loaded))
+}
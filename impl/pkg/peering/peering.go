@@ -0,0 +1,209 @@
+// Package peering syncs newly published records between gateways. Each gateway sends the records published to it
+// to its configured peer gateways over HTTP, so records propagate between gateways even while the DHT is flaky.
+// Records are signed, so a receiving gateway verifies each one rather than trusting the peer that sent it.
+package peering
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+const (
+	// RecordsPath is the path, relative to a peer's base URL, that batches of records are sent to
+	RecordsPath = "/peering/records"
+	// MaxBatchSize is the largest batch of records a gateway accepts from a peer at once
+	MaxBatchSize = 100
+	// MaxBatchBytes is the largest request body a gateway accepts a batch in: MaxBatchSize records, each of which
+	// encodes to under 4 KiB
+	MaxBatchBytes = MaxBatchSize * 4 << 10
+
+	defaultFlushInterval = time.Second
+	// queueSize bounds the records waiting to be sent; records announced while it is full are dropped
+	queueSize   = 10000
+	sendTimeout = 10 * time.Second
+)
+
+// Batch is the body of a request sending records to a peer
+type Batch struct {
+	Records []dht.BEP44Record `json:"records"`
+}
+
+// BatchResult is the response to a batch, counting the records the peer accepted and rejected
+type BatchResult struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+}
+
+// Gossiper sends announced records to every configured peer gateway in batches
+type Gossiper struct {
+	peers         []string
+	secret        string
+	client        *http.Client
+	batchSize     int
+	flushInterval time.Duration
+
+	queue chan dht.BEP44Record
+	stop  chan struct{}
+	done  sync.WaitGroup
+}
+
+// NewGossiper returns a gossiper sending to the peers in the given config with the given transport, the default
+// transport if nil, or nil if no peers are configured. Batches carry the peering secret from the environment, which
+// peers require of the gateways sending to them.
+func NewGossiper(cfg config.PeeringConfig, transport http.RoundTripper) *Gossiper {
+	if len(cfg.Peers) == 0 {
+		return nil
+	}
+	peers := make([]string, 0, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		peers = append(peers, strings.TrimSuffix(peer, "/"))
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 || batchSize > MaxBatchSize {
+		batchSize = MaxBatchSize
+	}
+	flushInterval := time.Duration(cfg.FlushIntervalMillis) * time.Millisecond
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	g := &Gossiper{
+		peers:         peers,
+		secret:        os.Getenv(config.PeeringSecret.String()),
+		client:        &http.Client{Transport: transport, Timeout: sendTimeout},
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		queue:         make(chan dht.BEP44Record, queueSize),
+		stop:          make(chan struct{}),
+	}
+	g.done.Add(1)
+	go g.run()
+	return g
+}
+
+// Announce queues a newly published record to be sent to every peer. It never blocks; when the queue is full the
+// record is dropped, since the DHT and the regular republish remain the source of truth.
+func (g *Gossiper) Announce(record dht.BEP44Record) {
+	if g == nil {
+		return
+	}
+	select {
+	case g.queue <- record:
+	default:
+		logrus.WithField("record_id", record.ID()).Warn("peering queue is full, dropping record")
+	}
+}
+
+// Close sends any queued records and stops the gossiper
+func (g *Gossiper) Close() {
+	if g == nil {
+		return
+	}
+	close(g.stop)
+	g.done.Wait()
+}
+
+func (g *Gossiper) run() {
+	defer g.done.Done()
+	ticker := time.NewTicker(g.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]dht.BEP44Record, 0, g.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			g.send(batch)
+			batch = make([]dht.BEP44Record, 0, g.batchSize)
+		}
+	}
+	for {
+		select {
+		case record := <-g.queue:
+			batch = append(batch, record)
+			if len(batch) >= g.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-g.stop:
+			// drain what has already been announced before stopping
+			for {
+				select {
+				case record := <-g.queue:
+					batch = append(batch, record)
+					if len(batch) >= g.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send sends the batch to every peer at once
+func (g *Gossiper) send(records []dht.BEP44Record) {
+	body, err := json.Marshal(Batch{Records: records})
+	if err != nil {
+		logrus.WithError(err).Error("failed to encode peering batch")
+		return
+	}
+	var wg sync.WaitGroup
+	for _, peer := range g.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := g.sendToPeer(peer, body)
+			if err != nil {
+				logrus.WithError(err).WithField("peer", peer).WithField("records", len(records)).Warn("failed to send records to peer")
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"peer":     peer,
+				"accepted": result.Accepted,
+				"rejected": result.Rejected,
+			}).Debug("sent records to peer")
+		}()
+	}
+	wg.Wait()
+}
+
+func (g *Gossiper) sendToPeer(peer string, body []byte) (*BatchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+RecordsPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+g.secret)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer responded with status %d", resp.StatusCode)
+	}
+	var result BatchResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "failed to decode peer response")
+	}
+	return &result, nil
+}
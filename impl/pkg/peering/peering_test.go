@@ -0,0 +1,109 @@
+package peering_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/peering"
+)
+
+// fakePeer records every batch sent to it
+type fakePeer struct {
+	mu      sync.Mutex
+	batches []peering.Batch
+	// authorization is the Authorization header of the last batch
+	authorization string
+}
+
+func (p *fakePeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != peering.RecordsPath || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var batch peering.Batch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	p.batches = append(p.batches, batch)
+	p.authorization = r.Header.Get("Authorization")
+	p.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(peering.BatchResult{Accepted: len(batch.Records)})
+}
+
+func (p *fakePeer) received() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sizes := make([]int, 0, len(p.batches))
+	for _, b := range p.batches {
+		sizes = append(sizes, len(b.Records))
+	}
+	return sizes
+}
+
+func TestGossiper(t *testing.T) {
+	t.Run("no peers is a no-op", func(t *testing.T) {
+		g := peering.NewGossiper(config.PeeringConfig{}, nil)
+		assert.Nil(t, g)
+		g.Announce(dht.BEP44Record{})
+		g.Close()
+	})
+
+	t.Run("records are batched to every peer", func(t *testing.T) {
+		first, second := new(fakePeer), new(fakePeer)
+		firstSrv, secondSrv := httptest.NewServer(first), httptest.NewServer(second)
+		defer firstSrv.Close()
+		defer secondSrv.Close()
+
+		g := peering.NewGossiper(config.PeeringConfig{
+			Peers:               []string{firstSrv.URL, secondSrv.URL + "/"},
+			BatchSize:           2,
+			FlushIntervalMillis: 200,
+		}, nil)
+		require.NotNil(t, g)
+		for i := 0; i < 3; i++ {
+			g.Announce(dht.BEP44Record{SequenceNumber: int64(i + 1)})
+		}
+
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual([]int{2, 1}, first.received()) &&
+				assert.ObjectsAreEqual([]int{2, 1}, second.received())
+		}, time.Second, 10*time.Millisecond)
+		g.Close()
+	})
+
+	t.Run("close sends queued records", func(t *testing.T) {
+		peer := new(fakePeer)
+		srv := httptest.NewServer(peer)
+		defer srv.Close()
+
+		g := peering.NewGossiper(config.PeeringConfig{Peers: []string{srv.URL}, FlushIntervalMillis: int(time.Hour.Milliseconds())}, nil)
+		g.Announce(dht.BEP44Record{SequenceNumber: 1})
+		g.Close()
+		assert.Equal(t, []int{1}, peer.received())
+	})
+
+	t.Run("batches carry the peering secret", func(t *testing.T) {
+		t.Setenv(config.PeeringSecret.String(), "peering-secret")
+		peer := new(fakePeer)
+		srv := httptest.NewServer(peer)
+		defer srv.Close()
+
+		g := peering.NewGossiper(config.PeeringConfig{Peers: []string{srv.URL}}, nil)
+		g.Announce(dht.BEP44Record{SequenceNumber: 1})
+		g.Close()
+		peer.mu.Lock()
+		defer peer.mu.Unlock()
+		assert.Equal(t, "Bearer peering-secret", peer.authorization)
+	})
+}
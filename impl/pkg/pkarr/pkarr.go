@@ -0,0 +1,121 @@
+// Package pkarr exports and imports a gateway's records as Pkarr relay dumps, to move records between gateways or
+// seed a gateway from a Pkarr relay.
+//
+// A dump has one line per record:
+//
+//	<z-base-32 encoded public key> <payload>
+//
+// where the payload is the unpadded base64url encoding of the body a Pkarr relay serves for the key, which is also
+// the body of this gateway's GET /{id}: the 64 byte signature, the 8 byte big-endian sequence number, and the DNS
+// packet. Pkarr keys records by public key alone, so salted records aren't exported.
+package pkarr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+// listPageSize is how many records are read from storage at a time while exporting
+const listPageSize = 1000
+
+var encoding = base64.RawURLEncoding
+
+// MarshalRecord returns the dump line of the given unsalted record, without a trailing newline
+func MarshalRecord(record dht.BEP44Record) []byte {
+	payload := make([]byte, 0, 72+len(record.Value))
+	payload = append(payload, record.Signature[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(record.SequenceNumber))
+	payload = append(payload, record.Value...)
+
+	line := []byte(record.ID())
+	line = append(line, ' ')
+	return encoding.AppendEncode(line, payload)
+}
+
+// UnmarshalRecord returns the record in the given dump line, verifying its signature
+func UnmarshalRecord(line []byte) (*dht.BEP44Record, error) {
+	id, encoded, ok := bytes.Cut(bytes.TrimSpace(line), []byte(" "))
+	if !ok {
+		return nil, errors.New("expected a key and a payload separated by a space")
+	}
+	key, salt, err := dht.ParseRecordID(string(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(salt) > 0 {
+		return nil, errors.New("pkarr records can't be salted")
+	}
+	payload, err := encoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid payload encoding")
+	}
+	if len(payload) < 72 {
+		return nil, fmt.Errorf("payload is %d bytes, shorter than a signature and sequence number", len(payload))
+	}
+	seq := int64(binary.BigEndian.Uint64(payload[64:72]))
+	return dht.NewBEP44Record(key, payload[72:], payload[:64], seq)
+}
+
+// Export writes every unsalted record in the storage to w as a dump, returning the number of records exported and
+// the number of salted records skipped
+func Export(ctx context.Context, db storage.Storage, w io.Writer) (exported int, skipped int, err error) {
+	buf := bufio.NewWriter(w)
+	var nextPageToken []byte
+	for {
+		records, next, err := db.ListRecords(ctx, nextPageToken, listPageSize)
+		if err != nil {
+			return exported, skipped, errors.Wrap(err, "failed to list records")
+		}
+		for _, record := range records {
+			if len(record.Salt) > 0 {
+				skipped++
+				continue
+			}
+			if _, err = buf.Write(append(MarshalRecord(record), '\n')); err != nil {
+				return exported, skipped, err
+			}
+			exported++
+		}
+		if next == nil {
+			break
+		}
+		nextPageToken = next
+	}
+	return exported, skipped, buf.Flush()
+}
+
+// Import writes every record in the dump read from r to the storage, along with its version in the record history,
+// returning the number of records imported. Stored records newer than those in the dump are kept. Blank lines are
+// skipped, and an invalid line stops the import.
+func Import(ctx context.Context, r io.Reader, db storage.Storage) (int, error) {
+	var count int
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		record, err := UnmarshalRecord(line)
+		if err != nil {
+			return count, errors.Wrapf(err, "invalid record on line %d", lineNum)
+		}
+		if err = db.WriteRecord(ctx, *record); err != nil {
+			return count, errors.Wrapf(err, "failed to import record %s", record.ID())
+		}
+		if err = db.WriteRecordVersion(ctx, *record); err != nil {
+			return count, errors.Wrapf(err, "failed to import version %d of record %s", record.SequenceNumber, record.ID())
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,114 @@
+package pkarr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+func newTestStorage(t *testing.T) storage.Storage {
+	db, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "diddht.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := newTestStorage(t)
+
+	var records []dht.BEP44Record
+	for i := 0; i < 3; i++ {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		record := dht.RecordFromBEP44(putMsg)
+		require.NoError(t, src.WriteRecord(ctx, record))
+		records = append(records, record)
+
+		if i == 0 {
+			salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+			require.NoError(t, err)
+			require.NoError(t, src.WriteRecord(ctx, dht.RecordFromBEP44(salted)))
+		}
+	}
+
+	var dump bytes.Buffer
+	exported, skipped, err := Export(ctx, src, &dump)
+	require.NoError(t, err)
+	assert.Equal(t, 3, exported)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, 3, strings.Count(dump.String(), "\n"))
+
+	// each payload is the relay body: sig, big-endian seq, then v
+	line, _, _ := strings.Cut(dump.String(), "\n")
+	id, payload, ok := strings.Cut(line, " ")
+	require.True(t, ok)
+	body, err := encoding.DecodeString(payload)
+	require.NoError(t, err)
+	stored, err := src.ReadRecord(ctx, id)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, stored.Signature[:], body[:64])
+	assert.Equal(t, uint64(stored.SequenceNumber), binary.BigEndian.Uint64(body[64:72]))
+	assert.Equal(t, stored.Value, body[72:])
+
+	dst := newTestStorage(t)
+	imported, err := Import(ctx, strings.NewReader(dump.String()+"\n"), dst)
+	require.NoError(t, err)
+	assert.Equal(t, 3, imported)
+	for _, record := range records {
+		got, err := dst.ReadRecord(ctx, record.ID())
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, record.Value, got.Value)
+		assert.Equal(t, record.Signature, got.Signature)
+
+		versions, err := dst.ListRecordVersions(ctx, record.ID())
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	line := string(MarshalRecord(dht.RecordFromBEP44(putMsg)))
+	id, payload, _ := strings.Cut(line, " ")
+
+	tampered, err := encoding.DecodeString(payload)
+	require.NoError(t, err)
+	tampered[len(tampered)-1] ^= 1
+
+	for name, dump := range map[string]string{
+		"missing payload":   id,
+		"invalid key":       "notakey " + payload,
+		"salted key":        id + ".c2FsdA " + payload,
+		"invalid encoding":  id + " !!",
+		"truncated payload": id + " " + encoding.EncodeToString([]byte("short")),
+		"bad signature":     id + " " + encoding.EncodeToString(tampered),
+	} {
+		t.Run(name, func(t *testing.T) {
+			count, err := Import(context.Background(), strings.NewReader(line+"\n"+dump+"\n"), newTestStorage(t))
+			assert.ErrorContains(t, err, "invalid record on line 2")
+			assert.Equal(t, 1, count)
+		})
+	}
+}
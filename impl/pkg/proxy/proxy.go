@@ -0,0 +1,69 @@
+// Package proxy routes the gateway's outbound traffic through a SOCKS5 proxy: HTTP requests through an HTTP client
+// dialing through the proxy, and DHT datagrams through a packet conn relayed with SOCKS5 UDP ASSOCIATE (RFC 1928).
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/config"
+)
+
+// ParseURL returns the proxy URL in the given config, or nil if none is configured
+func ParseURL(cfg config.ProxyConfig) (*url.URL, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy url")
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, errors.Errorf("unsupported proxy scheme %q: must be socks5 or socks5h", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy url has no host")
+	}
+	return u, nil
+}
+
+// DHTMode returns how DHT traffic is sent through the proxy in the given config, ProxyDHTUDP if unset
+func DHTMode(cfg config.ProxyConfig) (string, error) {
+	switch cfg.DHTMode {
+	case "", config.ProxyDHTUDP:
+		return config.ProxyDHTUDP, nil
+	case config.ProxyDHTRelay:
+		if len(cfg.Relays) == 0 {
+			return "", errors.New("relay mode requires at least one pkarr relay")
+		}
+		return config.ProxyDHTRelay, nil
+	default:
+		return "", errors.Errorf("unknown proxy dht mode %q: must be %s or %s", cfg.DHTMode, config.ProxyDHTUDP,
+			config.ProxyDHTRelay)
+	}
+}
+
+// Transport returns an HTTP transport sending requests through the configured proxy, or nil to send them directly
+// if none is configured. The proxy resolves the host names requested, so DNS lookups don't leak either.
+func Transport(cfg config.ProxyConfig) (http.RoundTripper, error) {
+	u, err := ParseURL(cfg)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(u)
+	return transport, nil
+}
+
+// HTTPClient returns an HTTP client with the given timeout sending its requests through the configured proxy, or
+// directly if none is configured
+func HTTPClient(cfg config.ProxyConfig, timeout time.Duration) (*http.Client, error) {
+	transport, err := Transport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
@@ -0,0 +1,298 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/proxy"
+)
+
+// socksServer is a minimal SOCKS5 proxy supporting CONNECT and UDP ASSOCIATE, requiring the given username and
+// password if set
+type socksServer struct {
+	t                  *testing.T
+	username, password string
+	listener           net.Listener
+}
+
+func newSOCKSServer(t *testing.T, username, password string) *socksServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &socksServer{t: t, username: username, password: password, listener: l}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) url(user *url.Userinfo) *url.URL {
+	return &url.URL{Scheme: "socks5", Host: s.listener.Addr().String(), User: user}
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(0x00)
+	if s.username != "" {
+		method = 0x02
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		_, _ = conn.Write([]byte{0x05, 0xff})
+		return
+	}
+	_, _ = conn.Write([]byte{0x05, method})
+	if method == 0x02 {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		username := make([]byte, header[1])
+		_, _ = io.ReadFull(conn, username)
+		length := make([]byte, 1)
+		_, _ = io.ReadFull(conn, length)
+		password := make([]byte, length[0])
+		_, _ = io.ReadFull(conn, password)
+		if string(username) != s.username || string(password) != s.password {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+	}
+
+	request := make([]byte, 3)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	dst, ok := readAddr(conn)
+	if !ok {
+		return
+	}
+	switch request[1] {
+	case 0x01:
+		upstream, err := net.Dial("tcp", dst)
+		if err != nil {
+			_, _ = conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		go func() { _, _ = io.Copy(upstream, conn) }()
+		_, _ = io.Copy(conn, upstream)
+	case 0x03:
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+		// answer with an unspecified address, leaving the client to use the proxy's
+		reply := binary.BigEndian.AppendUint16([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0}, uint16(relay.LocalAddr().(*net.UDPAddr).Port))
+		_, _ = conn.Write(reply)
+		go s.relay(relay)
+		_, _ = io.Copy(io.Discard, conn)
+	default:
+		_, _ = conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// relay forwards the client's datagrams to their destinations, and the answers back to the client
+func (s *socksServer) relay(relay *net.UDPConn) {
+	var client *net.UDPAddr
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || (from.IP.Equal(client.IP) && from.Port == client.Port) {
+			client = from
+			r := bytes.NewReader(buf[3:n])
+			dst, ok := readAddr(r)
+			if !ok {
+				continue
+			}
+			addr, err := net.ResolveUDPAddr("udp", dst)
+			if err != nil {
+				continue
+			}
+			_, _ = relay.WriteToUDP(buf[n-r.Len():n], addr)
+			continue
+		}
+		datagram := []byte{0, 0, 0, 0x01}
+		datagram = append(datagram, from.IP.To4()...)
+		datagram = binary.BigEndian.AppendUint16(datagram, uint16(from.Port))
+		_, _ = relay.WriteToUDP(append(datagram, buf[:n]...), client)
+	}
+}
+
+func readAddr(r io.Reader) (string, bool) {
+	kind := make([]byte, 1)
+	if _, err := io.ReadFull(r, kind); err != nil {
+		return "", false
+	}
+	var host string
+	switch kind[0] {
+	case 0x01:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", false
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", false
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", false
+		}
+		host = string(domain)
+	default:
+		return "", false
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", false
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), true
+}
+
+// udpEcho answers every datagram with its contents
+func udpEcho(t *testing.T) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestListenPacket(t *testing.T) {
+	echo := udpEcho(t)
+	exchange := func(t *testing.T, conn net.PacketConn) {
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+		_, err := conn.WriteTo([]byte("ping"), echo)
+		require.NoError(t, err)
+		buf := make([]byte, 1500)
+		n, from, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf[:n]))
+		assert.Equal(t, echo.String(), from.String())
+	}
+
+	t.Run("without authentication", func(t *testing.T) {
+		s := newSOCKSServer(t, "", "")
+		conn, err := proxy.ListenPacket(context.Background(), s.url(nil))
+		require.NoError(t, err)
+		defer conn.Close()
+		exchange(t, conn)
+	})
+
+	t.Run("with a username and password", func(t *testing.T) {
+		s := newSOCKSServer(t, "alice", "secret")
+		conn, err := proxy.ListenPacket(context.Background(), s.url(url.UserPassword("alice", "secret")))
+		require.NoError(t, err)
+		defer conn.Close()
+		exchange(t, conn)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		s := newSOCKSServer(t, "alice", "secret")
+		_, err := proxy.ListenPacket(context.Background(), s.url(url.UserPassword("alice", "wrong")))
+		assert.ErrorContains(t, err, "proxy rejected username and password")
+	})
+
+	t.Run("missing credentials", func(t *testing.T) {
+		s := newSOCKSServer(t, "alice", "secret")
+		_, err := proxy.ListenPacket(context.Background(), s.url(nil))
+		assert.ErrorContains(t, err, "proxy accepted none of the authentication methods offered")
+	})
+}
+
+func TestHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	t.Cleanup(srv.Close)
+	s := newSOCKSServer(t, "", "")
+
+	client, err := proxy.HTTPClient(config.ProxyConfig{URL: s.url(nil).String()}, 5*time.Second)
+	require.NoError(t, err)
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "proxied", string(body))
+
+	client, err = proxy.HTTPClient(config.ProxyConfig{}, 5*time.Second)
+	require.NoError(t, err)
+	assert.Nil(t, client.Transport)
+}
+
+func TestParseURL(t *testing.T) {
+	u, err := proxy.ParseURL(config.ProxyConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, u)
+
+	u, err = proxy.ParseURL(config.ProxyConfig{URL: "socks5h://127.0.0.1:9050"})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9050", u.Host)
+
+	_, err = proxy.ParseURL(config.ProxyConfig{URL: "http://127.0.0.1:8080"})
+	assert.ErrorContains(t, err, `unsupported proxy scheme "http"`)
+
+	_, err = proxy.ParseURL(config.ProxyConfig{URL: "socks5://"})
+	assert.ErrorContains(t, err, "proxy url has no host")
+}
+
+func TestDHTMode(t *testing.T) {
+	mode, err := proxy.DHTMode(config.ProxyConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, config.ProxyDHTUDP, mode)
+
+	mode, err = proxy.DHTMode(config.ProxyConfig{DHTMode: "relay", Relays: []string{"https://relay.pkarr.org"}})
+	require.NoError(t, err)
+	assert.Equal(t, config.ProxyDHTRelay, mode)
+
+	_, err = proxy.DHTMode(config.ProxyConfig{DHTMode: "relay"})
+	assert.ErrorContains(t, err, "relay mode requires at least one pkarr relay")
+
+	_, err = proxy.DHTMode(config.ProxyConfig{DHTMode: "tcp"})
+	assert.ErrorContains(t, err, `unknown proxy dht mode "tcp"`)
+}
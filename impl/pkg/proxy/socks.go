@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	socksVersion = 0x05
+
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksAuthRejected = 0xff
+
+	socksCmdUDPAssociate = 0x03
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	// handshakeTimeout bounds negotiating the association with the proxy
+	handshakeTimeout = 30 * time.Second
+	// maxDatagram is the largest datagram relayed, header included
+	maxDatagram = 64 * 1024
+)
+
+// PacketConn is a UDP packet conn whose datagrams are relayed through a SOCKS5 proxy. The association lasts as long as
+// the TCP connection it was negotiated on, so the conn closes if the proxy drops it.
+type PacketConn struct {
+	control net.Conn
+	udp     *net.UDPConn
+	relay   *net.UDPAddr
+
+	readMu  sync.Mutex
+	readBuf []byte
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+// ListenPacket negotiates a UDP association with the SOCKS5 proxy at the given URL, returning a packet conn relaying
+// datagrams through it. The proxy's username and password, if any, are taken from the URL.
+func ListenPacket(ctx context.Context, proxyURL *url.URL) (*PacketConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	control, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to proxy")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = control.SetDeadline(deadline)
+	}
+	relay, err := associate(control, proxyURL.User)
+	if err != nil {
+		_ = control.Close()
+		return nil, err
+	}
+	_ = control.SetDeadline(time.Time{})
+
+	// proxies may answer with an unspecified relay address, meaning the address the proxy was reached at
+	if relay.IP.IsUnspecified() {
+		relay.IP = control.RemoteAddr().(*net.TCPAddr).IP
+	}
+	udp, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		_ = control.Close()
+		return nil, errors.Wrap(err, "failed to listen for relayed datagrams")
+	}
+	c := &PacketConn{control: control, udp: udp, relay: relay, readBuf: make([]byte, maxDatagram)}
+	go c.watchControl()
+	return c, nil
+}
+
+// associate authenticates with the proxy and requests a UDP association, returning the address of its relay
+func associate(control net.Conn, user *url.Userinfo) (*net.UDPAddr, error) {
+	methods := []byte{socksAuthNone}
+	if user != nil {
+		methods = []byte{socksAuthNone, socksAuthPassword}
+	}
+	if _, err := control.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return nil, errors.Wrap(err, "failed to greet proxy")
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(control, reply); err != nil {
+		return nil, errors.Wrap(err, "failed to read proxy greeting")
+	}
+	if reply[0] != socksVersion {
+		return nil, errors.Errorf("unexpected socks version %d", reply[0])
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if err := authenticate(control, user); err != nil {
+			return nil, err
+		}
+	case socksAuthRejected:
+		return nil, errors.New("proxy accepted none of the authentication methods offered")
+	default:
+		return nil, errors.Errorf("proxy chose unsupported authentication method %d", reply[1])
+	}
+
+	// the client's address is unknown until it sends, so it's left unspecified
+	request := []byte{socksVersion, socksCmdUDPAssociate, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := control.Write(request); err != nil {
+		return nil, errors.Wrap(err, "failed to request udp association")
+	}
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(control, header); err != nil {
+		return nil, errors.Wrap(err, "failed to read udp association reply")
+	}
+	if header[1] != 0 {
+		return nil, errors.Errorf("proxy refused udp association: %s", replyMessage(header[1]))
+	}
+	return readAddr(control)
+}
+
+// authenticate sends the username and password of RFC 1929
+func authenticate(control net.Conn, user *url.Userinfo) error {
+	if user == nil {
+		return errors.New("proxy requires a username and password")
+	}
+	password, _ := user.Password()
+	if len(user.Username()) > 255 || len(password) > 255 {
+		return errors.New("proxy username and password must be at most 255 bytes each")
+	}
+	request := []byte{0x01, byte(len(user.Username()))}
+	request = append(request, user.Username()...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+	if _, err := control.Write(request); err != nil {
+		return errors.Wrap(err, "failed to authenticate with proxy")
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(control, reply); err != nil {
+		return errors.Wrap(err, "failed to read proxy authentication reply")
+	}
+	if reply[1] != 0 {
+		return errors.New("proxy rejected username and password")
+	}
+	return nil
+}
+
+// replyMessage describes the reply codes of RFC 1928
+func replyMessage(code byte) string {
+	switch code {
+	case 0x01:
+		return "general failure"
+	case 0x02:
+		return "connection not allowed by ruleset"
+	case 0x07:
+		return "command not supported"
+	default:
+		return "reply code " + strconv.Itoa(int(code))
+	}
+}
+
+// readAddr reads an address and port in the SOCKS5 encoding. Domain names are resolved, as relays and datagram
+// sources are expected to be IP addresses.
+func readAddr(r io.Reader) (*net.UDPAddr, error) {
+	kind := make([]byte, 1)
+	if _, err := io.ReadFull(r, kind); err != nil {
+		return nil, errors.Wrap(err, "failed to read address type")
+	}
+	var host string
+	switch kind[0] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make([]byte, net.IPv4len)
+		if kind[0] == socksAddrIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, errors.Wrap(err, "failed to read address")
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return nil, errors.Wrap(err, "failed to read domain length")
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return nil, errors.Wrap(err, "failed to read domain")
+		}
+		host = string(domain)
+	default:
+		return nil, errors.Errorf("unsupported address type %d", kind[0])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return nil, errors.Wrap(err, "failed to read port")
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+}
+
+// appendAddr appends the SOCKS5 encoding of the given address
+func appendAddr(b []byte, addr *net.UDPAddr) []byte {
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		b = append(append(b, socksAddrIPv4), ip4...)
+	} else {
+		b = append(append(b, socksAddrIPv6), addr.IP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+// watchControl closes the conn once the proxy closes the association's TCP connection
+func (c *PacketConn) watchControl() {
+	_, _ = io.Copy(io.Discard, c.control)
+	_ = c.udp.Close()
+}
+
+// ReadFrom reads the next datagram relayed by the proxy, returning the address of the node that sent it. Datagrams
+// from anywhere but the relay, and fragments, which the proxy needn't support, are dropped.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	buf := c.readBuf
+	for {
+		n, from, err := c.udp.ReadFromUDP(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !from.IP.Equal(c.relay.IP) || from.Port != c.relay.Port || n < 4 || buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		src, err := readAddr(r)
+		if err != nil {
+			continue
+		}
+		return copy(p, buf[n-r.Len():n]), src, nil
+	}
+}
+
+// WriteTo sends the datagram to the given address through the proxy's relay
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.Errorf("unsupported address type %T", addr)
+	}
+	datagram := appendAddr([]byte{0, 0, 0}, dst)
+	if _, err := c.udp.WriteToUDP(append(datagram, p...), c.relay); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the association and closes the conn
+func (c *PacketConn) Close() error {
+	_ = c.control.Close()
+	return c.udp.Close()
+}
+
+// LocalAddr is the address of the local socket exchanging datagrams with the relay
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.udp.LocalAddr()
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.udp.SetDeadline(t)
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	return c.udp.SetReadDeadline(t)
+}
+
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	return c.udp.SetWriteDeadline(t)
+}
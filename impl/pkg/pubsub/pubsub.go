@@ -0,0 +1,245 @@
+// Package pubsub is the gateway's in-process bus for the new record versions it sees. The DHT service publishes an
+// event for every record it accepts or finds to be newer on the DHT, and subscribers receive the events for the
+// records and DID types they subscribe to.
+package pubsub
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sources of the new record versions the gateway sees
+const (
+	// SourcePublish is a record published to the gateway
+	SourcePublish = "publish"
+	// SourcePeer is a record sent to the gateway by a peer gateway
+	SourcePeer = "peer"
+	// SourceDHT is a record found on the DHT while resolving it
+	SourceDHT = "dht"
+	// SourceRepublish is a record found on the DHT, newer than the stored one, while republishing
+	SourceRepublish = "republish"
+)
+
+// bufferSize is how many events a subscriber can fall behind by before further events are dropped for it
+const bufferSize = 64
+
+// Event tells a subscriber the gateway saw a new version of a record
+type Event struct {
+	// ID is the ID of the record: the z-base-32 encoded key, followed by the encoded salt for salted records
+	ID string `json:"id"`
+	// Seq is the sequence number of the new version
+	Seq int64 `json:"seq"`
+	// Types are the indexed types of the DID the record publishes
+	Types  []int     `json:"types,omitempty"`
+	Source string    `json:"source"`
+	Time   time.Time `json:"time"`
+}
+
+// Filter selects the events for records with any of the IDs, or for DIDs of any of the types
+type Filter struct {
+	IDs   []string `json:"ids,omitempty"`
+	Types []int    `json:"types,omitempty"`
+}
+
+// Bus sends the events published to it to the subscriptions whose filters match them
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	byID   map[string]map[*Subscription]struct{}
+	byType map[int]map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBus returns a bus with no subscriptions
+func NewBus() *Bus {
+	return &Bus{
+		subs:   make(map[*Subscription]struct{}),
+		byID:   make(map[string]map[*Subscription]struct{}),
+		byType: make(map[int]map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events matching its filter, and only the versions of each record newer than the last
+// one it was sent
+type Subscription struct {
+	bus     *Bus
+	events  chan Event
+	ids     map[string]struct{}
+	types   map[int]struct{}
+	lastSeq map[string]int64
+	closed  bool
+}
+
+// Subscribe returns a subscription matching no events until records or types are added to it
+func (b *Bus) Subscribe() *Subscription {
+	s := &Subscription{
+		bus:     b,
+		events:  make(chan Event, bufferSize),
+		ids:     make(map[string]struct{}),
+		types:   make(map[int]struct{}),
+		lastSeq: make(map[string]int64),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.close()
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Publish sends the event to the matching subscriptions that haven't been sent its version of the record or a newer
+// one. Events are dropped for subscriptions too far behind to take them, rather than holding up the publisher.
+func (b *Bus) Publish(ctx context.Context, event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	matched := make(map[*Subscription]struct{}, len(b.byID[event.ID]))
+	for s := range b.byID[event.ID] {
+		matched[s] = struct{}{}
+	}
+	for _, typ := range event.Types {
+		for s := range b.byType[typ] {
+			matched[s] = struct{}{}
+		}
+	}
+	for s := range matched {
+		if event.Seq <= s.lastSeq[event.ID] {
+			continue
+		}
+		select {
+		case s.events <- event:
+			s.lastSeq[event.ID] = event.Seq
+		default:
+			logrus.WithContext(ctx).WithField("record_id", event.ID).Debug("subscriber is behind, dropping event")
+		}
+	}
+}
+
+// Close closes every subscription and any made later
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		s.close()
+	}
+	clear(b.subs)
+	clear(b.byID)
+	clear(b.byType)
+}
+
+// Events returns the channel the subscription's events are sent on, which is closed when the subscription or its
+// bus is closed
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Seen records that the subscriber already has the given version of a record, so only newer versions are sent
+func (s *Subscription) Seen(id string, seq int64) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if seq > s.lastSeq[id] {
+		s.lastSeq[id] = seq
+	}
+}
+
+// Add adds the filter's records and types to those the subscription matches
+func (s *Subscription) Add(filter Filter) {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for _, id := range filter.IDs {
+		s.ids[id] = struct{}{}
+		if b.byID[id] == nil {
+			b.byID[id] = make(map[*Subscription]struct{})
+		}
+		b.byID[id][s] = struct{}{}
+	}
+	for _, typ := range filter.Types {
+		s.types[typ] = struct{}{}
+		if b.byType[typ] == nil {
+			b.byType[typ] = make(map[*Subscription]struct{})
+		}
+		b.byType[typ][s] = struct{}{}
+	}
+}
+
+// Remove removes the filter's records and types from those the subscription matches
+func (s *Subscription) Remove(filter Filter) {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range filter.IDs {
+		s.removeID(id)
+	}
+	for _, typ := range filter.Types {
+		s.removeType(typ)
+	}
+}
+
+// Filter returns the records and types the subscription matches, in sorted order
+func (s *Subscription) Filter() Filter {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	var filter Filter
+	for id := range s.ids {
+		filter.IDs = append(filter.IDs, id)
+	}
+	for typ := range s.types {
+		filter.Types = append(filter.Types, typ)
+	}
+	slices.Sort(filter.IDs)
+	slices.Sort(filter.Types)
+	return filter
+}
+
+// Close stops the subscription and closes its events channel. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	for id := range s.ids {
+		s.removeID(id)
+	}
+	for typ := range s.types {
+		s.removeType(typ)
+	}
+	delete(s.bus.subs, s)
+	s.close()
+}
+
+// removeID stops matching the record with the given ID; the bus lock must be held
+func (s *Subscription) removeID(id string) {
+	delete(s.ids, id)
+	delete(s.lastSeq, id)
+	delete(s.bus.byID[id], s)
+	if len(s.bus.byID[id]) == 0 {
+		delete(s.bus.byID, id)
+	}
+}
+
+// removeType stops matching DIDs of the given type; the bus lock must be held
+func (s *Subscription) removeType(typ int) {
+	delete(s.types, typ)
+	delete(s.bus.byType[typ], s)
+	if len(s.bus.byType[typ]) == 0 {
+		delete(s.bus.byType, typ)
+	}
+}
+
+// close closes the events channel if it isn't already; the bus lock must be held
+func (s *Subscription) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+}
@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	ctx := context.Background()
+
+	byID := bus.Subscribe()
+	byID.Add(Filter{IDs: []string{"a"}})
+	byID.Seen("a", 10)
+	byType := bus.Subscribe()
+	byType.Add(Filter{Types: []int{1, 7}})
+	assert.Equal(t, Filter{Types: []int{1, 7}}, byType.Filter())
+
+	// only versions newer than the last one sent are sent
+	for _, seq := range []int64{9, 10, 11, 11, 12} {
+		bus.Publish(ctx, Event{ID: "a", Seq: seq})
+	}
+	assert.Equal(t, int64(11), (<-byID.Events()).Seq)
+	assert.Equal(t, int64(12), (<-byID.Events()).Seq)
+	assert.Empty(t, byType.Events())
+
+	// events match subscriptions by ID or by any of their types
+	bus.Publish(ctx, Event{ID: "b", Seq: 1, Types: []int{3, 7}})
+	bus.Publish(ctx, Event{ID: "c", Seq: 1, Types: []int{3}})
+	event := <-byType.Events()
+	assert.Equal(t, "b", event.ID)
+	assert.Empty(t, byType.Events())
+	assert.Empty(t, byID.Events())
+
+	byType.Remove(Filter{Types: []int{7}})
+	assert.Equal(t, Filter{Types: []int{1}}, byType.Filter())
+	bus.Publish(ctx, Event{ID: "b", Seq: 2, Types: []int{7}})
+	assert.Empty(t, byType.Events())
+
+	// events are dropped for subscriptions that have fallen behind
+	for seq := int64(13); seq < 13+2*bufferSize; seq++ {
+		bus.Publish(ctx, Event{ID: "a", Seq: seq})
+	}
+	assert.Len(t, byID.Events(), bufferSize)
+
+	byID.Close()
+	byID.Close()
+	for range byID.Events() {
+	}
+
+	// closing the bus closes every subscription, even those matching nothing
+	empty := bus.Subscribe()
+	bus.Close()
+	for _, sub := range []*Subscription{byType, empty, bus.Subscribe()} {
+		_, ok := <-sub.Events()
+		require.False(t, ok)
+	}
+}
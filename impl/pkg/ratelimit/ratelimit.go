@@ -0,0 +1,120 @@
+// Package ratelimit meters requests into token buckets, kept in memory for a single gateway or in redis for gateway
+// replicas sharing their limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often the memory limiter drops the buckets that have refilled
+const sweepInterval = time.Minute
+
+// Limit is the size and refill rate of a token bucket
+type Limit struct {
+	// PerSecond is how many tokens are added to the bucket each second; zero or less disables the limit
+	PerSecond float64
+	// Burst is how many tokens the bucket holds, at least one
+	Burst int
+}
+
+// Enabled returns whether the limit limits anything
+func (l Limit) Enabled() bool {
+	return l.PerSecond > 0
+}
+
+func (l Limit) burst() int {
+	return max(l.Burst, 1)
+}
+
+// refill returns how long an empty bucket takes to fill; a full bucket is as good as a new one
+func (l Limit) refill() time.Duration {
+	return time.Duration(float64(l.burst()) / l.PerSecond * float64(time.Second))
+}
+
+// Limiter takes tokens from the buckets of a set of keys
+type Limiter interface {
+	// Allow takes a token from the key's bucket, returning zero, or how long until the bucket has a token if it is
+	// empty, in which case no token is taken
+	Allow(ctx context.Context, key string, limit Limit) (time.Duration, error)
+	Close() error
+}
+
+// NewLimiter returns a limiter keeping its buckets in the redis database at the given redis:// or rediss:// URI, or
+// in memory for an empty URI
+func NewLimiter(uri string) (Limiter, error) {
+	if uri == "" {
+		return NewMemory(), nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return NewRedis(uri)
+	default:
+		return nil, fmt.Errorf("unsupported rate limit scheme: %s", u.Scheme)
+	}
+}
+
+// Memory is a Limiter keeping its buckets in memory
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	// full is when the bucket will have refilled if no more tokens are taken
+	full time.Time
+}
+
+var _ Limiter = (*Memory)(nil)
+
+// NewMemory returns a limiter with no buckets
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), lastSweep: time.Now(), now: time.Now}
+}
+
+func (m *Memory) Allow(_ context.Context, key string, limit Limit) (time.Duration, error) {
+	if !limit.Enabled() {
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		for k, b := range m.buckets {
+			if !now.Before(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.PerSecond), limit.burst())}
+		m.buckets[key] = b
+	}
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, nil
+	}
+	b.full = now.Add(limit.refill())
+	return 0, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
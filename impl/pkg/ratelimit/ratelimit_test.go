@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	testLimiter(t, m, func(d time.Duration) { now = now.Add(d) })
+
+	// refilled buckets are swept
+	now = now.Add(sweepInterval)
+	_, err := m.Allow(context.Background(), "other", Limit{PerSecond: 1, Burst: 1})
+	require.NoError(t, err)
+	assert.Len(t, m.buckets, 1)
+}
+
+func TestRedis(t *testing.T) {
+	uri := os.Getenv("TEST_REDIS")
+	if uri == "" {
+		t.SkipNow()
+	}
+	r, err := NewRedis(uri)
+	require.NoError(t, err)
+	defer r.Close()
+	testLimiter(t, r, time.Sleep)
+}
+
+func TestNewLimiter(t *testing.T) {
+	l, err := NewLimiter("")
+	require.NoError(t, err)
+	assert.IsType(t, &Memory{}, l)
+
+	_, err = NewLimiter("memcached://localhost")
+	assert.ErrorContains(t, err, "unsupported rate limit scheme")
+}
+
+// testLimiter runs a limiter through bursts and refills, waiting with wait
+func testLimiter(t *testing.T, l Limiter, wait func(time.Duration)) {
+	ctx := context.Background()
+	key := t.Name() + time.Now().String()
+	limit := Limit{PerSecond: 10, Burst: 3}
+
+	// disabled limits allow everything
+	for range 10 {
+		retryAfter, err := l.Allow(ctx, key, Limit{})
+		require.NoError(t, err)
+		assert.Zero(t, retryAfter)
+	}
+
+	for range limit.Burst {
+		retryAfter, err := l.Allow(ctx, key, limit)
+		require.NoError(t, err)
+		assert.Zero(t, retryAfter)
+	}
+	retryAfter, err := l.Allow(ctx, key, limit)
+	require.NoError(t, err)
+	assert.Greater(t, retryAfter, time.Duration(0))
+	assert.LessOrEqual(t, retryAfter, 100*time.Millisecond)
+
+	// other keys have their own buckets
+	retryAfter, err = l.Allow(ctx, key+"other", limit)
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	wait(100 * time.Millisecond)
+	retryAfter, err = l.Allow(ctx, key, limit)
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+	retryAfter, err = l.Allow(ctx, key, limit)
+	require.NoError(t, err)
+	assert.Greater(t, retryAfter, time.Duration(0))
+}
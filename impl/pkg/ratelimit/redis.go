@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// keyPrefix starts the key of each bucket, a hash of its tokens and when they were counted, in Unix milliseconds
+const keyPrefix = "diddht:ratelimit:"
+
+// takeToken refills the bucket at KEYS[1] for the time since it was last counted, then takes a token from it,
+// returning 0, or returns how many milliseconds until it has a token. ARGV holds the tokens added per millisecond,
+// the burst, and the current time in Unix milliseconds. Buckets expire once they would have refilled.
+var takeToken = goredis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+return wait
+`)
+
+// Redis is a Limiter keeping its buckets in redis, so gateway replicas share them. Buckets are counted with the
+// clocks of the replicas taking tokens from them.
+type Redis struct {
+	client *goredis.Client
+}
+
+var _ Limiter = (*Redis)(nil)
+
+// NewRedis returns a limiter keeping its buckets in the redis database at the given redis:// or rediss:// URI
+func NewRedis(uri string) (*Redis, error) {
+	opts, err := goredis.ParseURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	client := goredis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("error connecting to redis: %v", err)
+	}
+	return &Redis{client: client}, nil
+}
+
+func (r *Redis) Allow(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+	if !limit.Enabled() {
+		return 0, nil
+	}
+	perMilli := limit.PerSecond / 1000
+	wait, err := takeToken.Run(ctx, r.client, []string{keyPrefix + key}, perMilli, limit.burst(), time.Now().UnixMilli()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
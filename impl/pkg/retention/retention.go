@@ -0,0 +1,140 @@
+// Package retention issues the retention challenges of the DID DHT spec, and validates their solutions: proofs of
+// work a client does before a gateway accepts its DID.
+// https://did-dht.com/#retained-did-set
+package retention
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HashSource is the source of the gateway's challenge hashes, which it derives from a secret and the time
+const HashSource = "gateway"
+
+// MinDifficulty is the least difficulty the spec allows, in bits of leading zeros
+const MinDifficulty = 26
+
+var (
+	ErrMissingSolution = errors.New("missing retention solution")
+	ErrInvalidSolution = errors.New("invalid retention solution")
+)
+
+// Challenge is a hash to compute a retention solution against, and the difficulty the solution must meet
+type Challenge struct {
+	Hash       string `json:"hash"`
+	HashSource string `json:"hash_source"`
+	// Difficulty is the number of bits of leading zeros a solution must have
+	Difficulty int `json:"difficulty"`
+	// Expires is when solutions against the hash stop being accepted, as a Unix timestamp in seconds
+	Expires int64 `json:"expires"`
+}
+
+// Challenger issues a new challenge hash every window, and accepts solutions against the hashes of the current and
+// previous windows, so a challenge stays valid for at least a window. Challengers with the same secret and window
+// issue the same challenges.
+type Challenger struct {
+	secret     []byte
+	difficulty int
+	window     time.Duration
+	now        func() time.Time
+}
+
+// NewChallenger returns a challenger issuing challenges of the given difficulty, derived from the secret, every
+// window. An empty secret is replaced with a random one.
+func NewChallenger(secret []byte, difficulty int, window time.Duration) (*Challenger, error) {
+	if difficulty < 1 || difficulty > sha256.Size*8 {
+		return nil, fmt.Errorf("invalid retention difficulty %d: must be between 1 and %d bits", difficulty, sha256.Size*8)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid retention challenge window %s: must be positive", window)
+	}
+	if len(secret) == 0 {
+		secret = make([]byte, sha256.Size)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &Challenger{secret: secret, difficulty: difficulty, window: window, now: time.Now}, nil
+}
+
+// Challenge returns the current challenge
+func (c *Challenger) Challenge() Challenge {
+	window := c.now().UnixNano() / int64(c.window)
+	return Challenge{
+		Hash:       c.hash(window),
+		HashSource: HashSource,
+		Difficulty: c.difficulty,
+		Expires:    time.Unix(0, (window+2)*int64(c.window)).Unix(),
+	}
+}
+
+// Verify checks that the solution, the hex encoded attempt and the nonce separated by a colon, solves the current
+// or previous challenge for the DID
+func (c *Challenger) Verify(did, solution string) error {
+	if solution == "" {
+		return ErrMissingSolution
+	}
+	attempt, nonce, ok := strings.Cut(solution, ":")
+	if !ok {
+		return fmt.Errorf("%w: expected attempt:nonce", ErrInvalidSolution)
+	}
+	if _, err := strconv.ParseUint(nonce, 10, 32); err != nil {
+		return fmt.Errorf("%w: nonce must be a 32-bit unsigned integer", ErrInvalidSolution)
+	}
+	attemptBytes, err := hex.DecodeString(attempt)
+	if err != nil || len(attemptBytes) != sha256.Size {
+		return fmt.Errorf("%w: attempt must be a hex encoded sha-256 hash", ErrInvalidSolution)
+	}
+	if LeadingZeros(attemptBytes) < c.difficulty {
+		return fmt.Errorf("%w: fewer than %d leading zero bits", ErrInvalidSolution, c.difficulty)
+	}
+
+	window := c.now().UnixNano() / int64(c.window)
+	for _, w := range []int64{window, window - 1} {
+		computed := sha256.Sum256([]byte(did + c.hash(w) + nonce))
+		if hmac.Equal(computed[:], attemptBytes) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: does not solve a current challenge for %s", ErrInvalidSolution, did)
+}
+
+// hash returns the challenge hash of a window
+func (c *Challenger) hash(window int64) string {
+	mac := hmac.New(sha256.New, c.secret)
+	_ = binary.Write(mac, binary.BigEndian, window)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Solve searches for a solution to the challenge for the DID, the way a client does
+func Solve(did string, challenge Challenge) string {
+	for nonce := uint64(0); nonce <= 1<<32-1; nonce++ {
+		n := strconv.FormatUint(nonce, 10)
+		attempt := sha256.Sum256([]byte(did + challenge.Hash + n))
+		if LeadingZeros(attempt[:]) >= challenge.Difficulty {
+			return hex.EncodeToString(attempt[:]) + ":" + n
+		}
+	}
+	return ""
+}
+
+// LeadingZeros returns the number of leading zero bits of b
+func LeadingZeros(b []byte) int {
+	zeros := 0
+	for _, x := range b {
+		if x != 0 {
+			return zeros + bits.LeadingZeros8(x)
+		}
+		zeros += 8
+	}
+	return zeros
+}
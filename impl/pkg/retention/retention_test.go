@@ -0,0 +1,70 @@
+package retention
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChallenger(t *testing.T) {
+	c, err := NewChallenger([]byte("secret"), 8, 10*time.Minute)
+	require.NoError(t, err)
+	now := time.Unix(1715578000, 0)
+	c.now = func() time.Time { return now }
+
+	challenge := c.Challenge()
+	assert.Equal(t, HashSource, challenge.HashSource)
+	assert.Equal(t, 8, challenge.Difficulty)
+	assert.Len(t, challenge.Hash, 64)
+	assert.Equal(t, int64(1715578800), challenge.Expires)
+
+	const did = "did:dht:cyuoqaf7itop8ohww4yn5ojg13qaq83r9zihgqntc5i9zwrfdfoo"
+	solution := Solve(did, challenge)
+	require.NoError(t, c.Verify(did, solution))
+
+	// solutions are bound to their DID
+	assert.ErrorIs(t, c.Verify(did+"o", solution), ErrInvalidSolution)
+
+	// challengers with the same secret issue the same challenges
+	other, err := NewChallenger([]byte("secret"), 8, 10*time.Minute)
+	require.NoError(t, err)
+	other.now = c.now
+	assert.Equal(t, challenge, other.Challenge())
+	random, err := NewChallenger(nil, 8, 10*time.Minute)
+	require.NoError(t, err)
+	random.now = c.now
+	assert.NotEqual(t, challenge.Hash, random.Challenge().Hash)
+
+	// the previous window's challenge is accepted, then it expires
+	now = now.Add(10 * time.Minute)
+	assert.NotEqual(t, challenge.Hash, c.Challenge().Hash)
+	require.NoError(t, c.Verify(did, solution))
+	now = time.Unix(challenge.Expires, 0)
+	assert.ErrorIs(t, c.Verify(did, solution), ErrInvalidSolution)
+
+	attempt, nonce, _ := strings.Cut(solution, ":")
+	for _, invalid := range []string{
+		attempt,
+		attempt + ":x",
+		attempt + ":4294967296",
+		"zz:" + nonce,
+		strings.Repeat("f", 64) + ":" + nonce,
+	} {
+		assert.ErrorIs(t, c.Verify(did, invalid), ErrInvalidSolution, invalid)
+	}
+	assert.ErrorIs(t, c.Verify(did, ""), ErrMissingSolution)
+
+	_, err = NewChallenger(nil, 0, time.Minute)
+	assert.Error(t, err)
+	_, err = NewChallenger(nil, 8, 0)
+	assert.Error(t, err)
+}
+
+func TestLeadingZeros(t *testing.T) {
+	assert.Equal(t, 0, LeadingZeros([]byte{0x80}))
+	assert.Equal(t, 11, LeadingZeros([]byte{0, 0x10, 0xff}))
+	assert.Equal(t, 16, LeadingZeros([]byte{0, 0}))
+}
@@ -0,0 +1,4 @@
+// Package rpc is the gateway's gRPC API, generated from gateway.proto with protoc-gen-go and protoc-gen-go-grpc
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gateway.proto
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/TBD54566975/did-dht/pkg/access"
+)
+
+// errClientDenied is the message clients the access lists refuse are answered with, which doesn't say which rule
+// refused them
+const errClientDenied = "client is denied by the gateway"
+
+// CheckClientAccess refuses the requests of clients whose IPs the access lists deny, or don't allow in allowlist mode,
+// with a 403. Only the trusted proxies are believed to forward the client IP.
+func CheckClientAccess(list *access.List) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if list.AllowIP(c.Request.Context(), c.Request.Method+" "+c.FullPath(), c.ClientIP()) {
+			c.Next()
+			return
+		}
+		LoggingRespondErrMsg(c, errClientDenied, http.StatusForbidden)
+		c.Abort()
+	}
+}
+
+// accessUnaryInterceptor refuses the unary calls of clients the access lists refuse, by their connection's address
+func accessUnaryInterceptor(list *access.List) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !list.AllowIP(ctx, info.FullMethod, peerIP(ctx)) {
+			return nil, status.Error(codes.PermissionDenied, errClientDenied)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// accessStreamInterceptor refuses the streaming calls of clients the access lists refuse, by their connection's
+// address
+func accessStreamInterceptor(list *access.List) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !list.AllowIP(ss.Context(), info.FullMethod, peerIP(ss.Context())) {
+			return status.Error(codes.PermissionDenied, errClientDenied)
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+)
+
+func TestClientAccess(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "access", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+	_, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	suffix, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+
+	get := func(path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("/gateways", "203.0.113.1:1234"))
+
+	require.NoError(t, svc.ReloadAccess(config.AccessConfig{DeniedCIDRs: []string{"203.0.113.0/24"}, DeniedDIDs: []string{suffix}}))
+	assert.Equal(t, http.StatusForbidden, get("/gateways", "203.0.113.1:1234"))
+	assert.Equal(t, http.StatusOK, get("/gateways", "198.51.100.1:1234"))
+	assert.Equal(t, http.StatusForbidden, get("/"+suffix, "198.51.100.1:1234"), "denied dids aren't resolved")
+
+	// gRPC calls are checked by the address of the client's connection
+	call := func(addr string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 1234}})
+		_, err := accessUnaryInterceptor(svc.Access())(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/gateway.v1.Gateway/Resolve"},
+			func(context.Context, any) (any, error) { return nil, nil })
+		return err
+	}
+	assert.NoError(t, call("198.51.100.1"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("203.0.113.1")))
+}
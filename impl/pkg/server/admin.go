@@ -0,0 +1,601 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/abuse"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// AdminRouter is the router for operator endpoints managing the gateway's stored records and config
+type AdminRouter struct {
+	service *service.DHTService
+	// reload reloads the gateway's config file
+	reload func(ctx context.Context) error
+}
+
+// NewAdminRouter returns a new instance of AdminRouter for the given service, reloading the config with reload
+func NewAdminRouter(service *service.DHTService, reload func(ctx context.Context) error) *AdminRouter {
+	return &AdminRouter{service: service, reload: reload}
+}
+
+// AdminAuth admits requests made over TLS with a verified client certificate, which the server only verifies
+// against the admin client CAs, or carrying the given bearer token. An empty token admits no requests by token.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isAdmin(c, token) {
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+		LoggingRespondErrMsg(c, "admin token or client certificate required", http.StatusUnauthorized)
+		c.Abort()
+	}
+}
+
+// isAdmin returns whether a request is made with a verified client certificate or carries the given bearer token
+func isAdmin(c *gin.Context, token string) bool {
+	if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+// RecordMetadata godoc
+//
+//	@Summary		Get a stored record's metadata
+//	@Description	RecordMetadata returns when a stored record was created, updated, last seen, and last republished, and how many times it has been resolved through the gateway
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		string	true	"ID of the record: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Success		200	{object}	dht.RecordMetadata
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/records/{id} [get]
+func (r *AdminRouter) RecordMetadata(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.RecordMetadata")
+	defer span.End()
+
+	id, ok := recordIDParam(c)
+	if !ok {
+		return
+	}
+
+	metadata, err := r.service.GetRecordMetadata(ctx, id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to read record metadata: %s", id), http.StatusInternalServerError)
+		return
+	}
+	if metadata == nil {
+		LoggingRespondErrMsg(c, fmt.Sprintf("dht record not found: %s", id), http.StatusNotFound)
+		return
+	}
+	Respond(c, metadata, http.StatusOK)
+}
+
+// DeleteRecord godoc
+//
+//	@Summary		Delete a stored record
+//	@Description	DeleteRecord deletes a stored record along with its history and metadata. The record stays on the DHT until it expires, and resolving it from the DHT stores it again.
+//	@Tags			Admin
+//	@Param			id	path		string	true	"ID of the record: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/records/{id} [delete]
+func (r *AdminRouter) DeleteRecord(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.DeleteRecord")
+	defer span.End()
+
+	id, ok := recordIDParam(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := r.service.DeleteRecord(ctx, id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to delete record: %s", id), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		LoggingRespondErrMsg(c, fmt.Sprintf("dht record not found: %s", id), http.StatusNotFound)
+		return
+	}
+	ResponseStatus(c, http.StatusNoContent)
+}
+
+// RepublishRecord godoc
+//
+//	@Summary		Republish a stored record now
+//	@Description	RepublishRecord puts a stored record to the DHT now, rather than waiting for the next scheduled republish
+//	@Tags			Admin
+//	@Param			id	path		string	true	"ID of the record: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		410	{string}	string	"DID deactivated"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/records/{id}/republish [post]
+func (r *AdminRouter) RepublishRecord(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.RepublishRecord")
+	defer span.End()
+
+	id, ok := recordIDParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := r.service.RepublishRecord(ctx, id)
+	switch {
+	case errors.Is(err, dht.ErrDeactivated):
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("not republishing record: %s", id), http.StatusGone)
+	case err != nil:
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to republish record: %s", id), http.StatusInternalServerError)
+	case report == nil:
+		LoggingRespondErrMsg(c, fmt.Sprintf("dht record not found: %s", id), http.StatusNotFound)
+	default:
+		ResponseStatus(c, http.StatusNoContent)
+	}
+}
+
+// ListFailedRecords godoc
+//
+//	@Summary		List records that failed to republish
+//	@Description	ListFailedRecords lists the records that failed to be republished after every retry, with how many times each has failed
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{array}		dht.FailedRecord
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/failed [get]
+func (r *AdminRouter) ListFailedRecords(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.ListFailedRecords")
+	defer span.End()
+
+	failed, err := r.service.ListFailedRecords(ctx)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, "failed to list failed records", http.StatusInternalServerError)
+		return
+	}
+	if failed == nil {
+		failed = []dht.FailedRecord{}
+	}
+	Respond(c, failed, http.StatusOK)
+}
+
+// ListQuarantinedRecords godoc
+//
+//	@Summary		List quarantined records
+//	@Description	ListQuarantinedRecords lists the records quarantined for repeatedly failing to republish, with how many times in a row each has failed and when it's next retried, those retried soonest first
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{array}		service.QuarantinedRecord
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Security		AdminToken
+//	@Router			/admin/quarantine [get]
+func (r *AdminRouter) ListQuarantinedRecords(c *gin.Context) {
+	_, span := telemetry.GetTracer().Start(c, "AdminHTTP.ListQuarantinedRecords")
+	defer span.End()
+
+	Respond(c, r.service.ListQuarantinedRecords(), http.StatusOK)
+}
+
+// RetryQuarantinedRecord godoc
+//
+//	@Summary		Retry a quarantined record now
+//	@Description	RetryQuarantinedRecord puts a quarantined record to the DHT now, rather than waiting for its backoff to pass, releasing it from the quarantine if it's republished
+//	@Tags			Admin
+//	@Param			id	path		string	true	"ID of the record: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		410	{string}	string	"DID deactivated"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/quarantine/{id}/retry [post]
+func (r *AdminRouter) RetryQuarantinedRecord(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.RetryQuarantinedRecord")
+	defer span.End()
+
+	id, ok := recordIDParam(c)
+	if !ok {
+		return
+	}
+
+	quarantined, err := r.service.RetryQuarantinedRecord(ctx, id)
+	switch {
+	case errors.Is(err, dht.ErrDeactivated):
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("not republishing record: %s", id), http.StatusGone)
+	case err != nil:
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to republish record: %s", id), http.StatusInternalServerError)
+	case !quarantined:
+		LoggingRespondErrMsg(c, fmt.Sprintf("record not quarantined: %s", id), http.StatusNotFound)
+	default:
+		ResponseStatus(c, http.StatusNoContent)
+	}
+}
+
+// PurgeQuarantinedRecord godoc
+//
+//	@Summary		Purge a quarantined record
+//	@Description	PurgeQuarantinedRecord gives up on a quarantined record, deleting it along with its history and metadata so it's no longer republished. The record stays on the DHT until it expires.
+//	@Tags			Admin
+//	@Param			id	path		string	true	"ID of the record: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/quarantine/{id} [delete]
+func (r *AdminRouter) PurgeQuarantinedRecord(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.PurgeQuarantinedRecord")
+	defer span.End()
+
+	id, ok := recordIDParam(c)
+	if !ok {
+		return
+	}
+
+	quarantined, err := r.service.PurgeQuarantinedRecord(ctx, id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to purge record: %s", id), http.StatusInternalServerError)
+		return
+	}
+	if !quarantined {
+		LoggingRespondErrMsg(c, fmt.Sprintf("record not quarantined: %s", id), http.StatusNotFound)
+		return
+	}
+	ResponseStatus(c, http.StatusNoContent)
+}
+
+// ListAbuseReviews godoc
+//
+//	@Summary		List records listed for abuse review
+//	@Description	ListAbuseReviews lists the records the abuse filter flagged, which were published, or held, which weren't, with the score and reasons each was listed for, oldest listed first
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{array}		abuse.Review
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Security		AdminToken
+//	@Router			/admin/abuse [get]
+func (r *AdminRouter) ListAbuseReviews(c *gin.Context) {
+	_, span := telemetry.GetTracer().Start(c, "AdminHTTP.ListAbuseReviews")
+	defer span.End()
+
+	reviews := r.service.ListAbuseReviews()
+	if reviews == nil {
+		reviews = []abuse.Review{}
+	}
+	Respond(c, reviews, http.StatusOK)
+}
+
+// ApproveAbuseReview godoc
+//
+//	@Summary		Approve a record listed for abuse review
+//	@Description	ApproveAbuseReview takes a record off the abuse review list, publishing it if it was held and no newer version has been stored since
+//	@Tags			Admin
+//	@Param			id	path		string	true	"ID of the record: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		409	{string}	string	"DID deactivated"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/abuse/{id}/approve [post]
+func (r *AdminRouter) ApproveAbuseReview(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.ApproveAbuseReview")
+	defer span.End()
+
+	id, ok := recordIDParam(c)
+	if !ok {
+		return
+	}
+
+	listed, err := r.service.ApproveAbuseReview(ctx, id)
+	switch {
+	case errors.Is(err, dht.ErrDeactivated):
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("not publishing record: %s", id), http.StatusConflict)
+	case err != nil:
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to publish record: %s", id), http.StatusInternalServerError)
+	case !listed:
+		LoggingRespondErrMsg(c, fmt.Sprintf("record not listed for review: %s", id), http.StatusNotFound)
+	default:
+		ResponseStatus(c, http.StatusNoContent)
+	}
+}
+
+// RejectAbuseReview godoc
+//
+//	@Summary		Reject a record listed for abuse review
+//	@Description	RejectAbuseReview takes a record off the abuse review list as abuse, dropping it if it was held, or deleting it along with its history and metadata if it was published. A published record stays on the DHT until it expires.
+//	@Tags			Admin
+//	@Param			id	path		string	true	"ID of the record: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/abuse/{id} [delete]
+func (r *AdminRouter) RejectAbuseReview(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.RejectAbuseReview")
+	defer span.End()
+
+	id, ok := recordIDParam(c)
+	if !ok {
+		return
+	}
+
+	listed, err := r.service.RejectAbuseReview(ctx, id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to delete record: %s", id), http.StatusInternalServerError)
+		return
+	}
+	if !listed {
+		LoggingRespondErrMsg(c, fmt.Sprintf("record not listed for review: %s", id), http.StatusNotFound)
+		return
+	}
+	ResponseStatus(c, http.StatusNoContent)
+}
+
+// ListRetainedDIDs godoc
+//
+//	@Summary		List retained DIDs
+//	@Description	ListRetainedDIDs lists the DIDs retained through the admin API, whose records are never collected as stale. The DIDs retained in the config aren't listed.
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{array}		string
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/retained [get]
+func (r *AdminRouter) ListRetainedDIDs(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.ListRetainedDIDs")
+	defer span.End()
+
+	dids, err := r.service.ListRetainedDIDs(ctx)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, "failed to list retained dids", http.StatusInternalServerError)
+		return
+	}
+	Respond(c, dids, http.StatusOK)
+}
+
+// RetainDID godoc
+//
+//	@Summary		Retain a DID
+//	@Description	RetainDID keeps the records of a DID, including its salted records, from being collected as stale, whatever the retention window
+//	@Tags			Admin
+//	@Param			did	path		string	true	"DID, or the z-base-32 encoded key of the DID"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/retained/{did} [put]
+func (r *AdminRouter) RetainDID(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.RetainDID")
+	defer span.End()
+
+	k, ok := didKeyParam(c)
+	if !ok {
+		return
+	}
+	if err := r.service.RetainDID(ctx, k); err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to retain did: %s", c.Param(DIDParam)), http.StatusInternalServerError)
+		return
+	}
+	ResponseStatus(c, http.StatusNoContent)
+}
+
+// ReleaseDID godoc
+//
+//	@Summary		Stop retaining a DID
+//	@Description	ReleaseDID lets the records of a DID retained through the admin API be collected again once stale. DIDs retained in the config stay retained.
+//	@Tags			Admin
+//	@Param			did	path		string	true	"DID, or the z-base-32 encoded key of the DID"
+//	@Success		204
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/retained/{did} [delete]
+func (r *AdminRouter) ReleaseDID(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.ReleaseDID")
+	defer span.End()
+
+	k, ok := didKeyParam(c)
+	if !ok {
+		return
+	}
+	if err := r.service.ReleaseDID(ctx, k); err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to release did: %s", c.Param(DIDParam)), http.StatusInternalServerError)
+		return
+	}
+	ResponseStatus(c, http.StatusNoContent)
+}
+
+// ReloadConfig godoc
+//
+//	@Summary		Reload the config
+//	@Description	ReloadConfig reloads the config file the gateway was started with, applying the log level and record collection settings. Other settings take effect on restart.
+//	@Tags			Admin
+//	@Success		204
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/config/reload [post]
+func (r *AdminRouter) ReloadConfig(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.ReloadConfig")
+	defer span.End()
+
+	if err := r.reload(ctx); err != nil {
+		LoggingRespondErrWithMsg(c, err, "failed to reload config", http.StatusInternalServerError)
+		return
+	}
+	ResponseStatus(c, http.StatusNoContent)
+}
+
+// MintAPIKeyRequest is the name and limits of an API key to mint
+type MintAPIKeyRequest struct {
+	// Name says who or what the key is for
+	Name string `json:"name,omitempty"`
+	// RequestsPerSecond is how fast the key's request bucket refills, holding up to Burst requests; zero is no limit
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+	// PublishesPerDay is how many records the key publishes a day; zero is no quota
+	PublishesPerDay int `json:"publishesPerDay"`
+}
+
+// MintAPIKeyResponse is a minted API key and the token clients present it with, which can't be retrieved again
+type MintAPIKeyResponse struct {
+	Token string     `json:"token"`
+	Key   dht.APIKey `json:"key"`
+}
+
+// MintAPIKey godoc
+//
+//	@Summary		Mint an API key
+//	@Description	MintAPIKey mints an API key with the given limits, returning the token clients present in the X-API-Key header. Requests made with the token are metered by the key's limits instead of the client IP's, and its publishes against the key's daily quota, refilling continuously. Only a hash of the token is stored, so it can't be retrieved again.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		MintAPIKeyRequest	true	"Name and limits of the key"
+//	@Success		201		{object}	MintAPIKeyResponse
+//	@Failure		400		{string}	string	"Bad request"
+//	@Failure		401		{string}	string	"Unauthorized"
+//	@Failure		500		{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/keys [post]
+func (r *AdminRouter) MintAPIKey(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.MintAPIKey")
+	defer span.End()
+
+	var request MintAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		LoggingRespondErrWithMsg(c, err, "invalid api key request", http.StatusBadRequest)
+		return
+	}
+	if request.RequestsPerSecond < 0 || request.Burst < 0 || request.PublishesPerDay < 0 {
+		LoggingRespondErrMsg(c, "api key limits can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	token, key, err := r.service.MintAPIKey(ctx, dht.APIKey{
+		Name:              request.Name,
+		RequestsPerSecond: request.RequestsPerSecond,
+		Burst:             request.Burst,
+		PublishesPerDay:   request.PublishesPerDay,
+	})
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, "failed to mint api key", http.StatusInternalServerError)
+		return
+	}
+	Respond(c, MintAPIKeyResponse{Token: token, Key: *key}, http.StatusCreated)
+}
+
+// ListAPIKeys godoc
+//
+//	@Summary		List API keys
+//	@Description	ListAPIKeys lists the minted API keys that haven't been revoked, with their limits. Their tokens aren't listed.
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{array}		dht.APIKey
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/keys [get]
+func (r *AdminRouter) ListAPIKeys(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.ListAPIKeys")
+	defer span.End()
+
+	keys, err := r.service.ListAPIKeys(ctx)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, "failed to list api keys", http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []dht.APIKey{}
+	}
+	Respond(c, keys, http.StatusOK)
+}
+
+// RevokeAPIKey godoc
+//
+//	@Summary		Revoke an API key
+//	@Description	RevokeAPIKey deletes an API key, so requests made with its token are rejected
+//	@Tags			Admin
+//	@Param			id	path		string	true	"ID of the API key"
+//	@Success		204
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Security		AdminToken
+//	@Router			/admin/keys/{id} [delete]
+func (r *AdminRouter) RevokeAPIKey(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "AdminHTTP.RevokeAPIKey")
+	defer span.End()
+
+	id := c.Param(IDParam)
+	revoked, err := r.service.RevokeAPIKey(ctx, id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to revoke api key: %s", id), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		LoggingRespondErrMsg(c, fmt.Sprintf("api key not found: %s", id), http.StatusNotFound)
+		return
+	}
+	ResponseStatus(c, http.StatusNoContent)
+}
+
+// recordIDParam returns the record ID path parameter, responding with an error if it is missing or invalid
+func recordIDParam(c *gin.Context) (string, bool) {
+	id := GetParam(c, IDParam)
+	if id == nil || *id == "" {
+		LoggingRespondErrMsg(c, "missing id param", http.StatusBadRequest)
+		return "", false
+	}
+	if _, _, err := dht.ParseRecordID(*id); err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid record id: %s", *id), http.StatusBadRequest)
+		return "", false
+	}
+	return *id, true
+}
+
+// didKeyParam returns the key of the DID path parameter, responding with an error if it is invalid
+func didKeyParam(c *gin.Context) ([]byte, bool) {
+	id, err := didSuffix(c.Param(DIDParam))
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid did: %s", c.Param(DIDParam)), http.StatusBadRequest)
+		return nil, false
+	}
+	k, _, err := dht.ParseRecordID(id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid did: %s", c.Param(DIDParam)), http.StatusBadRequest)
+		return nil, false
+	}
+	return k, true
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/httpsig"
+)
+
+// defaultCORSMethods are the methods cross-origin requests may use unless configured otherwise
+var defaultCORSMethods = []string{
+	http.MethodHead,
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// CORS returns middleware answering preflight requests and setting the CORS headers of cross-origin requests, so
+// browser-based wallets can resolve and publish DIDs from web apps. Requests from origins that aren't allowed are
+// rejected with a 403.
+func CORS(cfg config.CORSConfig) (gin.HandlerFunc, error) {
+	corsCfg := cors.Config{
+		AllowOrigins:  cfg.AllowedOrigins,
+		AllowMethods:  cfg.AllowedMethods,
+		AllowHeaders:  cfg.AllowedHeaders,
+		AllowWildcard: true,
+		MaxAge:        time.Duration(cfg.MaxAgeSeconds) * time.Second,
+		// web apps verifying signed resolutions read the signature headers
+		ExposeHeaders: []string{httpsig.ContentDigestHeader, httpsig.SignatureInputHeader, httpsig.SignatureHeader, RequestIDHeader},
+	}
+	if len(corsCfg.AllowOrigins) == 0 {
+		corsCfg.AllowOrigins = []string{"*"}
+	}
+	if len(corsCfg.AllowMethods) == 0 {
+		corsCfg.AllowMethods = defaultCORSMethods
+	}
+	if len(corsCfg.AllowHeaders) == 0 {
+		corsCfg.AllowHeaders = []string{"*"}
+	}
+	for _, origin := range corsCfg.AllowOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return nil, fmt.Errorf("invalid cors origin %q: only one wildcard is allowed", origin)
+		}
+	}
+	if err := corsCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cors config: %w", err)
+	}
+	if cfg.MaxAgeSeconds < 0 {
+		return nil, fmt.Errorf("invalid cors max age %d: must not be negative", cfg.MaxAgeSeconds)
+	}
+	return cors.New(corsCfg), nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+)
+
+func TestCORS(t *testing.T) {
+	newHandler := func(t *testing.T, cfg config.CORSConfig) *gin.Engine {
+		cors, err := CORS(cfg)
+		require.NoError(t, err)
+		handler := gin.New()
+		handler.Use(cors)
+		handler.GET("/dids/:did", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return handler
+	}
+	preflight := func(handler *gin.Engine, origin string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "/dids/did:dht:example", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("any origin by default", func(t *testing.T) {
+		handler := newHandler(t, config.CORSConfig{})
+		w := preflight(handler, "https://wallet.example.com")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), http.MethodPut)
+		assert.Empty(t, w.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("configured origins", func(t *testing.T) {
+		handler := newHandler(t, config.CORSConfig{
+			AllowedOrigins: []string{"https://wallet.example.com", "https://*.example.org"},
+			AllowedMethods: []string{http.MethodGet, http.MethodPut},
+			AllowedHeaders: []string{"Content-Type", RetentionSolutionHeader},
+			MaxAgeSeconds:  600,
+		})
+		for _, origin := range []string{"https://wallet.example.com", "https://app.example.org"} {
+			w := preflight(handler, origin)
+			assert.Equal(t, http.StatusNoContent, w.Code, origin)
+			assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "GET,PUT", w.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, "Content-Type,Retention-Solution", w.Header().Get("Access-Control-Allow-Headers"))
+			assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+		}
+		assert.Equal(t, http.StatusForbidden, preflight(handler, "https://evil.example.net").Code)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/dids/did:dht:example", nil)
+		req.Header.Set("Origin", "https://wallet.example.com")
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://wallet.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "Content-Digest,Signature-Input,Signature,X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		for _, cfg := range []config.CORSConfig{
+			{AllowedOrigins: []string{"wallet.example.com"}},
+			{AllowedOrigins: []string{"https://*.*.example.com"}},
+			{MaxAgeSeconds: -1},
+		} {
+			_, err := CORS(cfg)
+			assert.Error(t, err, "%+v", cfg)
+		}
+	})
+}
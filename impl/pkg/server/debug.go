@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// DebugRouter is the router for gateway-internal debugging endpoints
+type DebugRouter struct {
+	dht *dht.DHT
+}
+
+// NewDebugRouter returns a new instance of DebugRouter for the given DHT
+func NewDebugRouter(d *dht.DHT) *DebugRouter {
+	return &DebugRouter{dht: d}
+}
+
+// DHT godoc
+//
+//	@Summary		Dump the DHT routing table
+//	@Description	DHT returns the gateway's routing table: node IDs, addresses, last seen times, and bucket fill levels
+//	@Tags			Debug
+//	@Produce		json
+//	@Success		200	{object}	dht.RoutingTable
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Router			/debug/dht [get]
+func (r *DebugRouter) DHT(c *gin.Context) {
+	_, span := telemetry.GetTracer().Start(c, "DebugHTTP.DHT")
+	defer span.End()
+
+	table, err := r.dht.RoutingTable()
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, "failed to read routing table", http.StatusInternalServerError)
+		return
+	}
+	Respond(c, table, http.StatusOK)
+}
@@ -1,17 +1,24 @@
 package server
 
 import (
+	"context"
 	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 
-	"github.com/TBD54566975/did-dht/internal/util"
+	"github.com/TBD54566975/did-dht/internal/did"
 	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/httpsig"
 	"github.com/TBD54566975/did-dht/pkg/service"
 	"github.com/TBD54566975/did-dht/pkg/telemetry"
 )
@@ -19,25 +26,52 @@ import (
 // DHTRouter is the router for the DHT API
 type DHTRouter struct {
 	service *service.DHTService
+	// maxBodyBytes is the largest request body a record is published with
+	maxBodyBytes int64
+	// pkarrRelay serves records as a Pkarr relay does
+	pkarrRelay bool
+	// adminToken admits admins to the routes otherwise only a DID's owner may use, if not empty
+	adminToken string
 }
 
-// NewDHTRouter returns a new instance of the DHT router
-func NewDHTRouter(service *service.DHTService) (*DHTRouter, error) {
-	return &DHTRouter{service: service}, nil
+const (
+	// minPutBodyBytes is the 64 byte signature and 8 byte sequence number preceding a record's value in a put
+	minPutBodyBytes = 72
+	// DefaultMaxPutBodyBytes fits a put of a record with the largest value BEP44 allows, 1000 bytes
+	DefaultMaxPutBodyBytes = minPutBodyBytes + 1000
+)
+
+// NewDHTRouter returns a new instance of the DHT router, publishing records with request bodies of up to
+// maxBodyBytes, or DefaultMaxPutBodyBytes if zero
+func NewDHTRouter(service *service.DHTService, maxBodyBytes int64) (*DHTRouter, error) {
+	if maxBodyBytes == 0 {
+		maxBodyBytes = DefaultMaxPutBodyBytes
+	}
+	return &DHTRouter{service: service, maxBodyBytes: maxBodyBytes}, nil
 }
 
 // GetRecord godoc
 //
 //	@Summary		GetRecord a BEP44 DNS record from the DHT
-//	@Description	GetRecord a BEP44 DNS record from the DHT
+//	@Description	GetRecord a BEP44 DNS record from the DHT. With raw=true, the record is returned as a RawRecordResponse in JSON, with its bencoded value, so clients can verify its signature over the bencoded salt, seq, and v themselves.
 //	@Tags			DHT
 //	@Accept			octet-stream
-//	@Produce		octet-stream
-//	@Param			id	path		string	true	"ID to get"
-//	@Success		200	{array}		byte	"64 bytes sig, 8 bytes u64 big-endian seq, 0-1000 bytes of v."
-//	@Failure		400	{string}	string	"Bad request"
-//	@Failure		404	{string}	string	"Not found"
-//	@Failure		500	{string}	string	"Internal server error"
+//	@Produce		octet-stream,json
+//	@Param			id			path		string	true	"ID to get: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Param			versionId	query		integer	false	"Sequence number of a stored version of the record to get"
+//	@Param			versionTime	query		string	false	"RFC 3339 time to get the stored version of the record that was current at"
+//	@Param			raw			query		boolean	false	"Return the record as it is on the DHT, as a RawRecordResponse in JSON, to verify its signature"
+//	@Param			If-Modified-Since	header	string	false	"HTTP date to get the record only if it was modified after, when the gateway is a Pkarr relay"
+//	@Param			Resolution-Strategy	header	string	false	"Resolution strategy of the request rather than the gateway's: dht-first, storage-first, race, or cache-only"
+//	@Success		200			{array}		byte	"64 bytes sig, 8 bytes u64 big-endian seq, 0-1000 bytes of v."
+//	@Success		304			"Not modified since If-Modified-Since"
+//	@Failure		400			{string}	string	"Bad request"
+//	@Failure		403			{string}	string	"Client or DID is denied by the gateway"
+//	@Failure		404			{string}	string	"Not found"
+//	@Failure		429			{string}	string	"Too many requests"
+//	@Failure		500			{string}	string	"Internal server error"
+//	@Failure		502			{string}	string	"Bad gateway"
+//	@Failure		504			{string}	string	"Gateway timeout"
 //	@Router			/{id} [get]
 func (r *DHTRouter) GetRecord(c *gin.Context) {
 	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.GetRecord")
@@ -49,25 +83,32 @@ func (r *DHTRouter) GetRecord(c *gin.Context) {
 		return
 	}
 
-	// make sure the key is valid
-	key, err := util.Z32Decode(*id)
+	// make sure the key, and salt if present, are valid
+	key, salt, err := dht.ParseRecordID(*id)
 	if err != nil {
-		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid record id: %s", *id), http.StatusInternalServerError)
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid record id: %s", *id), http.StatusBadRequest)
 		return
 	}
-	if len(key) != ed25519.PublicKeySize {
-		LoggingRespondErrMsg(c, fmt.Sprintf("invalid z32 encoded ed25519 public key: %s", *id), http.StatusBadRequest)
-		return
+	var raw bool
+	if rawQuery, ok := c.GetQuery("raw"); ok {
+		if raw, err = strconv.ParseBool(rawQuery); err != nil {
+			LoggingRespondErrWithMsg(c, err, "invalid raw query", http.StatusBadRequest)
+			return
+		}
 	}
 
-	resp, err := r.service.GetDHT(ctx, *id)
+	resp, err := r.getRecord(ctx, c, *id)
+	if errors.Is(err, errInvalidVersion) {
+		LoggingRespondErrWithMsg(c, err, "invalid version query", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		if errors.Is(err, service.SpamError) {
 			LoggingRespondErrMsg(c, fmt.Sprintf("too many requests for bad key %s", *id), http.StatusTooManyRequests)
 			return
 		}
 
-		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to get dht record: %s", *id), http.StatusInternalServerError)
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to get dht record: %s", *id), errorStatus(err))
 		return
 	}
 	if resp == nil {
@@ -75,25 +116,99 @@ func (r *DHTRouter) GetRecord(c *gin.Context) {
 		return
 	}
 
+	if raw {
+		encodedValue, err := resp.EncodedValue()
+		if err != nil {
+			LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to encode dht record: %s", *id), http.StatusInternalServerError)
+			return
+		}
+		Respond(c, RawRecordResponse{
+			K:    dht.RecordID(key, nil),
+			Salt: base64.RawURLEncoding.EncodeToString(salt),
+			Seq:  resp.Seq,
+			Sig:  base64.RawURLEncoding.EncodeToString(resp.Sig[:]),
+			V:    base64.RawURLEncoding.EncodeToString(encodedValue),
+		}, http.StatusOK)
+		return
+	}
+
 	// Convert int64 to uint64 since binary.PutUint64 expects a uint64 value
 	var seqBuf [8]byte
 	binary.BigEndian.PutUint64(seqBuf[:], uint64(resp.Seq))
 	// sig:seq:v
 	res := append(resp.Sig[:], append(seqBuf[:], resp.V[:]...)...)
+	if r.pkarrRelay {
+		respondPkarrPayload(c, resp.Seq, res)
+		return
+	}
 	RespondBytes(c, res, http.StatusOK)
 }
 
+// RawRecordResponse is a record as BEP44 puts it to the DHT, for clients verifying its signature themselves: the
+// ed25519 signature by k over the bencoded salt, if any, and seq, followed by "1:v" and the bencoded value, as in
+// "4:salt6:foobar3:seqi1e1:v12:Hello World!"
+type RawRecordResponse struct {
+	// K is the z-base-32 encoded ed25519 public key of the record
+	K string `json:"k"`
+	// Salt is the base64url encoded salt of the record, if any
+	Salt string `json:"salt,omitempty"`
+	Seq  int64  `json:"seq"`
+	// Sig is the base64url encoded signature
+	Sig string `json:"sig"`
+	// V is the base64url encoded bencoded value: the length of the DNS packet, a colon, and the DNS packet
+	V string `json:"v"`
+}
+
+var errInvalidVersion = errors.New("invalid version")
+
+// getRecord resolves the record with the given ID: the stored version selected by the versionId or versionTime query
+// parameter, as in DID Core resolution, or the current record if neither is given
+func (r *DHTRouter) getRecord(ctx context.Context, c *gin.Context, id string) (*dht.BEP44Response, error) {
+	versionID, hasVersionID := c.GetQuery("versionId")
+	versionTime, hasVersionTime := c.GetQuery("versionTime")
+	switch {
+	case hasVersionID && hasVersionTime:
+		return nil, errors.Wrap(errInvalidVersion, "versionId and versionTime are mutually exclusive")
+	case hasVersionID:
+		seq, err := strconv.ParseInt(versionID, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(errInvalidVersion, "versionId %q is not a sequence number", versionID)
+		}
+		return r.service.GetDHTVersion(ctx, id, seq)
+	case hasVersionTime:
+		at, err := time.Parse(time.RFC3339, versionTime)
+		if err != nil {
+			return nil, errors.Wrapf(errInvalidVersion, "versionTime %q is not an RFC 3339 time", versionTime)
+		}
+		return r.service.GetDHTVersionAt(ctx, id, at)
+	default:
+		return r.service.GetDHT(ctx, id)
+	}
+}
+
 // PutRecord godoc
 //
 //	@Summary		PutRecord a BEP44 DNS record into the DHT
 //	@Description	PutRecord a BEP44 DNS record into the DHT
 //	@Tags			DHT
 //	@Accept			octet-stream
-//	@Param			id		path	string	true	"ID of the record to put"
-//	@Param			request	body	[]byte	true	"64 bytes sig, 8 bytes u64 big-endian seq, 0-1000 bytes of v."
+//	@Param			id					path	string	true	"ID of the record to put: the z-base-32 encoded key, optionally followed by '.' and a base64url encoded salt"
+//	@Param			request				body	[]byte	true	"64 bytes sig, 8 bytes u64 big-endian seq, 0-1000 bytes of v. A DID's types, if any, are listed in one _typ._did. TXT record of the form id=H,I,J, of types in the spec's registry."
+//	@Param			Retention-Solution	header	string	false	"Solution to a current retention challenge for the DID, required if the gateway issues challenges"
+//	@Param			Expected-Seq		header	integer	false	"Sequence number the current version of the record is expected to have; the put is rejected if the gateway has or finds a newer version"
+//	@Param			If-Unmodified-Since	header	string	false	"HTTP date the current version of the record is expected to be last modified at, when the gateway is a Pkarr relay"
 //	@Success		200
-//	@Failure		400	{string}	string	"Bad request"
-//	@Failure		500	{string}	string	"Internal server error"
+//	@Success		204	"Published, when the gateway is a Pkarr relay"
+//	@Failure		400	{object}	PutErrorResponse	"Bad request, naming the limit the record is over or the DID document's invalid fields, if any"
+//	@Failure		403	{object}	PutErrorResponse	"Record rejected as abusive, or the client or DID is denied by the gateway"
+//	@Failure		409	{object}	PutErrorResponse	"DID is deactivated, or the record has a newer version than expected"
+//	@Failure		412	{object}	PutErrorResponse	"Record was modified since If-Unmodified-Since"
+//	@Failure		413	{object}	PutErrorResponse	"Request body too large"
+//	@Failure		428	{object}	PutErrorResponse	"Record is being put by another request"
+//	@Failure		429	{string}	string	"Too many requests"
+//	@Failure		500	{object}	PutErrorResponse	"Internal server error"
+//	@Failure		502	{object}	PutErrorResponse	"Bad gateway"
+//	@Failure		504	{object}	PutErrorResponse	"Gateway timeout"
 //	@Router			/{id} [put]
 func (r *DHTRouter) PutRecord(c *gin.Context) {
 	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.PutRecord")
@@ -101,46 +216,686 @@ func (r *DHTRouter) PutRecord(c *gin.Context) {
 
 	id := GetParam(c, IDParam)
 	if id == nil || *id == "" {
-		LoggingRespondErrMsg(c, "missing id param", http.StatusBadRequest)
+		respondPutError(c, errors.New("missing id param"), http.StatusBadRequest)
+		return
+	}
+	request, ok := r.readRecord(c, *id)
+	if !ok {
+		return
+	}
+
+	if r.pkarrRelay {
+		r.putPkarrRecord(ctx, c, *id, *request)
 		return
 	}
-	key, err := util.Z32Decode(*id)
+	var err error
+	if expected := c.GetHeader(ExpectedSeqHeader); expected != "" {
+		expectedSeq, parseErr := strconv.ParseInt(expected, 10, 64)
+		if parseErr != nil {
+			respondPutError(c, errors.Wrapf(parseErr, "invalid %s header: %s", ExpectedSeqHeader, expected), http.StatusBadRequest)
+			return
+		}
+		err = r.service.PublishDHTIfSeq(ctx, *id, *request, expectedSeq)
+	} else {
+		err = r.service.PublishDHT(ctx, *id, *request)
+	}
 	if err != nil {
-		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid record id: %s", *id), http.StatusInternalServerError)
+		respondPutError(c, errors.Wrapf(err, "failed to publish dht record: %s", *id), errorStatus(err))
 		return
 	}
-	if len(key) != ed25519.PublicKeySize {
-		LoggingRespondErrMsg(c, fmt.Sprintf("invalid z32 encoded ed25519 public key: %s", *id), http.StatusBadRequest)
+
+	ResponseStatus(c, http.StatusOK)
+}
+
+// DeactivateRecord godoc
+//
+//	@Summary		Deactivate a DID
+//	@Description	DeactivateRecord publishes the tombstone deactivating a DID: the spec's deactivation record, a DNS packet with no records, signed by the DID's identity key with a sequence number above the current version's. The tombstone is stored in place of the DID's document, put to the DHT, and no longer republished.
+//	@Tags			DHT
+//	@Accept			octet-stream
+//	@Param			id		path	string	true	"ID of the DID: the z-base-32 encoded key"
+//	@Param			request	body	[]byte	true	"64 bytes sig, 8 bytes u64 big-endian seq, and the deactivation record's v"
+//	@Success		200
+//	@Failure		400	{object}	PutErrorResponse	"Bad request"
+//	@Failure		403	{object}	PutErrorResponse	"Client or DID is denied by the gateway"
+//	@Failure		404	{object}	PutErrorResponse	"Not found"
+//	@Failure		409	{object}	PutErrorResponse	"DID is already deactivated, or has a version as new as the tombstone"
+//	@Failure		413	{object}	PutErrorResponse	"Request body too large"
+//	@Failure		429	{string}	string	"Too many requests"
+//	@Failure		500	{object}	PutErrorResponse	"Internal server error"
+//	@Router			/{id} [delete]
+func (r *DHTRouter) DeactivateRecord(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.DeactivateRecord")
+	defer span.End()
+
+	id := GetParam(c, IDParam)
+	if id == nil || *id == "" {
+		respondPutError(c, errors.New("missing id param"), http.StatusBadRequest)
 		return
 	}
+	request, ok := r.readRecord(c, *id)
+	if !ok {
+		return
+	}
+	if err := r.service.DeactivateDID(ctx, *id, *request); err != nil {
+		respondPutError(c, errors.Wrapf(err, "failed to deactivate did: %s", *id), errorStatus(err))
+		return
+	}
+
+	ResponseStatus(c, http.StatusOK)
+}
 
-	body, err := io.ReadAll(c.Request.Body)
+// readRecord reads the record for the given ID from a request body in the format BEP44 puts are relayed in, responding
+// with the error if it isn't valid
+func (r *DHTRouter) readRecord(c *gin.Context, id string) (*dht.BEP44Record, bool) {
+	key, salt, err := dht.ParseRecordID(id)
 	if err != nil {
-		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to read body for id: %s", *id), http.StatusInternalServerError)
-		return
+		respondPutError(c, errors.Wrapf(err, "invalid record id: %s", id), http.StatusBadRequest)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, r.maxBodyBytes))
+	if err != nil {
+		if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+			respondPutError(c, &requestBodyError{max: tooLarge.Limit, length: c.Request.ContentLength}, http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		respondPutError(c, errors.Wrapf(err, "failed to read body for id: %s", id), http.StatusInternalServerError)
+		return nil, false
 	}
 	defer c.Request.Body.Close()
 
-	// 64 byte signature and 8 byte sequence number
-	if len(body) <= 72 {
-		LoggingRespondErrMsg(c, fmt.Sprintf("invalid request body for id: %s", *id), http.StatusBadRequest)
-		return
+	if len(body) <= minPutBodyBytes {
+		respondPutError(c, errors.Errorf("request body for id %s is %d bytes, but must hold a 64 byte signature and 8 byte sequence number followed by a value", id, len(body)), http.StatusBadRequest)
+		return nil, false
 	}
 
 	// transform the request into a service request by extracting the fields
-	value := body[72:]
+	value := body[minPutBodyBytes:]
 	sig := body[:64]
-	seq := int64(binary.BigEndian.Uint64(body[64:72]))
-	request, err := dht.NewBEP44Record(key, value, sig, seq)
+	seq := int64(binary.BigEndian.Uint64(body[64:minPutBodyBytes]))
+	record, err := dht.NewSaltedBEP44Record(key, value, sig, salt, seq)
+	if err != nil {
+		respondPutError(c, errors.Wrap(err, "error parsing request"), http.StatusBadRequest)
+		return nil, false
+	}
+	return record, true
+}
+
+// ResolutionStrategyHeader is the request header choosing the resolution strategy of a request rather than the
+// gateway's: dht-first, storage-first, race, or cache-only
+const ResolutionStrategyHeader = "Resolution-Strategy"
+
+// ChooseResolutionStrategy resolves the request with the strategy its ResolutionStrategyHeader names, if any,
+// rejecting unknown strategies
+func ChooseResolutionStrategy(c *gin.Context) {
+	name := c.GetHeader(ResolutionStrategyHeader)
+	if name == "" {
+		c.Next()
+		return
+	}
+	strategy, err := service.ParseResolutionStrategy(name)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid %s header", ResolutionStrategyHeader), http.StatusBadRequest)
+		c.Abort()
+		return
+	}
+	c.Request = c.Request.WithContext(service.WithResolutionStrategy(c.Request.Context(), strategy))
+	c.Next()
+}
+
+// ExpectedSeqHeader is the request header of a conditional put, carrying the sequence number the current version of
+// the record is expected to have
+const ExpectedSeqHeader = "Expected-Seq"
+
+// PutErrorResponse is the error a record isn't published with. A record over one of the limits BEP44 and the DID DHT
+// spec set, or a request body over the gateway's limit, names the limit, its maximum, and how far over it the record
+// is. A conditional put of a record with a newer version than expected names the newer version's sequence number. A
+// record for an invalid DID document lists every property of the document breaking a rule.
+type PutErrorResponse struct {
+	Error string `json:"error"`
+	// Limit is the name of the limit: body_length, value_length, root_records, previous_records, or types_records
+	Limit string `json:"limit,omitempty"`
+	// Max is the most the limit allows
+	Max int64 `json:"max,omitempty"`
+	// Actual is how much the request has; a body over the limit without a Content-Length omits it
+	Actual int64 `json:"actual,omitempty"`
+	// Seq is the sequence number of the newer version a conditional put conflicts with
+	Seq int64 `json:"seq,omitempty"`
+	// Fields are the properties of an invalid DID document breaking a rule of DID Core or the DID DHT spec
+	Fields []dht.FieldError `json:"fields,omitempty"`
+}
+
+// LimitBodyLength is the name of the gateway's limit on the request body a record is published with
+const LimitBodyLength = "body_length"
+
+// requestBodyError is returned for a request body over the gateway's limit
+type requestBodyError struct {
+	max int64
+	// length is the Content-Length of the body, or -1 if unknown
+	length int64
+}
+
+func (e *requestBodyError) Error() string {
+	if e.length < 0 {
+		return fmt.Sprintf("request body is over the %d byte limit", e.max)
+	}
+	return fmt.Sprintf("request body is %d bytes, %d over the %d byte limit", e.length, e.length-e.max, e.max)
+}
+
+// respondPutError responds to a put with the error as a PutErrorResponse, naming the limit it's over if any
+func respondPutError(c *gin.Context, err error, statusCode int) {
+	logrus.WithContext(c).WithError(err).Error()
+
+	resp := PutErrorResponse{Error: err.Error()}
+	var limitErr *dht.LimitError
+	var bodyErr *requestBodyError
+	var seqErr *dht.SeqConflictError
+	var docErr *dht.DocumentError
+	switch {
+	case errors.As(err, &limitErr):
+		resp.Limit, resp.Max, resp.Actual = limitErr.Limit, int64(limitErr.Max), int64(limitErr.Actual)
+	case errors.As(err, &bodyErr):
+		resp.Limit, resp.Max = LimitBodyLength, bodyErr.max
+		if bodyErr.length >= 0 {
+			resp.Actual = bodyErr.length
+		}
+	case errors.As(err, &seqErr):
+		resp.Seq = seqErr.Current
+	case errors.As(err, &docErr):
+		resp.Fields = docErr.Fields
+	}
+	Respond(c, resp, statusCode)
+}
+
+const (
+	// defaultTypePageSize is how many DIDs a page of a type lists unless the pageSize query parameter says otherwise
+	defaultTypePageSize = 100
+	// maxTypePageSize is the most DIDs a page of a type lists
+	maxTypePageSize = 1000
+)
+
+// ListDIDsByTypeResponse is a page of the DIDs of a type
+type ListDIDsByTypeResponse struct {
+	DIDs []string `json:"dids"`
+	// NextPageToken is passed as the pageToken query parameter to list the next page; it is omitted after the last
+	// page
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// ListDIDsByType godoc
+//
+//	@Summary		List the DIDs of a type
+//	@Description	ListDIDsByType lists a page of the DIDs stored by this gateway that are indexed under the given type. Listing in update order, updated since the last sync, syncs a type incrementally.
+//	@Tags			DHT
+//	@Produce		json
+//	@Param			id			path		integer	true	"Type index, such as 1 for Organization"
+//	@Param			pageSize	query		integer	false	"Most DIDs to list, up to 1000 (default 100)"
+//	@Param			pageToken	query		string	false	"Token from the previous page to list the next page, with the same updatedSince and order"
+//	@Param			updatedSince	query		string	false	"List only DIDs whose records were updated at or after this RFC 3339 time"
+//	@Param			order		query		string	false	"Order to list DIDs in: stored (default), updated for least recently updated first, or -updated for most recently updated first"	Enums(stored, updated, -updated)
+//	@Success		200			{object}	ListDIDsByTypeResponse
+//	@Failure		400			{string}	string	"Bad request"
+//	@Failure		403			{string}	string	"Client is denied by the gateway"
+//	@Failure		404			{string}	string	"Type not registered"
+//	@Failure		500			{string}	string	"Internal server error"
+//	@Router			/dids/types/{id} [get]
+func (r *DHTRouter) ListDIDsByType(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.ListDIDsByType")
+	defer span.End()
+
+	id := GetParam(c, IDParam)
+	if id == nil || *id == "" {
+		LoggingRespondErrMsg(c, "missing id param", http.StatusBadRequest)
+		return
+	}
+	typ, err := strconv.ParseUint(*id, 10, 31)
 	if err != nil {
-		LoggingRespondErrWithMsg(c, err, "error parsing request", http.StatusBadRequest)
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid type: %s", *id), http.StatusBadRequest)
+		return
+	}
+	if !did.TypeIndex(typ).IsRegistered() {
+		LoggingRespondErrMsg(c, fmt.Sprintf("type %d is not registered", typ), http.StatusNotFound)
 		return
 	}
 
-	if err = r.service.PublishDHT(ctx, *id, *request); err != nil {
-		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to publish dht record: %s", *id), http.StatusInternalServerError)
+	pageSize := defaultTypePageSize
+	if raw, ok := c.GetQuery("pageSize"); ok {
+		if pageSize, err = strconv.Atoi(raw); err != nil || pageSize < 1 || pageSize > maxTypePageSize {
+			LoggingRespondErrMsg(c, fmt.Sprintf("invalid pageSize %q: must be between 1 and %d", raw, maxTypePageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	var pageToken []byte
+	if raw, ok := c.GetQuery("pageToken"); ok {
+		if pageToken, err = base64.RawURLEncoding.DecodeString(raw); err != nil || len(pageToken) == 0 {
+			LoggingRespondErrMsg(c, fmt.Sprintf("invalid pageToken %q", raw), http.StatusBadRequest)
+			return
+		}
+	}
+	query := dht.TypeQuery{Type: int(typ), Order: dht.TypeOrder(c.Query("order"))}
+	if !query.Order.IsValid() {
+		LoggingRespondErrMsg(c, fmt.Sprintf("invalid order %q: must be one of %s, %s, or %s", query.Order,
+			dht.OrderStored, dht.OrderUpdated, dht.OrderUpdatedDesc), http.StatusBadRequest)
 		return
 	}
+	if raw, ok := c.GetQuery("updatedSince"); ok {
+		if query.UpdatedSince, err = time.Parse(time.RFC3339, raw); err != nil {
+			LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid updatedSince %q: must be an RFC 3339 time", raw), http.StatusBadRequest)
+			return
+		}
+	}
 
-	ResponseStatus(c, http.StatusOK)
+	dids, nextPageToken, err := r.service.ListDIDsByType(ctx, query, pageToken, pageSize)
+	if errors.Is(err, dht.ErrInvalidPageToken) {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid pageToken for order %q", query.Order), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to list dids of type: %d", typ), http.StatusInternalServerError)
+		return
+	}
+	Respond(c, ListDIDsByTypeResponse{
+		DIDs:          dids,
+		NextPageToken: base64.RawURLEncoding.EncodeToString(nextPageToken),
+	}, http.StatusOK)
+}
+
+// ResolveDIDsRequest is a batch of DIDs to resolve
+type ResolveDIDsRequest struct {
+	DIDs []string `json:"dids"`
+}
+
+// ResolveDIDsResponse holds the result of resolving each DID in a batch, in the order requested
+type ResolveDIDsResponse struct {
+	Results []service.DIDResolution `json:"results"`
+}
+
+// ResolveDIDs godoc
+//
+//	@Summary		Resolve a batch of DIDs
+//	@Description	ResolveDIDs resolves up to 100 DIDs at once, returning each DID's document or why it couldn't be resolved. Lookups run in parallel under a deadline shared by the batch.
+//	@Tags			DHT
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ResolveDIDsRequest	true	"DIDs to resolve"
+//	@Param			Resolution-Strategy	header	string	false	"Resolution strategy of the request rather than the gateway's: dht-first, storage-first, race, or cache-only"
+//	@Success		200		{object}	ResolveDIDsResponse
+//	@Failure		400		{string}	string	"Bad request"
+//	@Failure		403		{string}	string	"Client is denied by the gateway"
+//	@Failure		429		{string}	string	"Too many requests"
+//	@Router			/dids/resolve [post]
+func (r *DHTRouter) ResolveDIDs(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.ResolveDIDs")
+	defer span.End()
+
+	var request ResolveDIDsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		LoggingRespondErrWithMsg(c, err, "invalid resolve request", http.StatusBadRequest)
+		return
+	}
+	if len(request.DIDs) == 0 {
+		LoggingRespondErrMsg(c, "no dids to resolve", http.StatusBadRequest)
+		return
+	}
+	if len(request.DIDs) > service.MaxResolveBatchSize {
+		LoggingRespondErrMsg(c, fmt.Sprintf("batch of %d dids is over the limit of %d", len(request.DIDs), service.MaxResolveBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	Respond(c, ResolveDIDsResponse{Results: r.service.ResolveDIDs(ctx, request.DIDs)}, http.StatusOK)
+}
+
+// ResolveDID godoc
+//
+//	@Summary		Resolve a DID or dereference a DID URL
+//	@Description	ResolveDID resolves a DID to its document, or dereferences a DID URL to the verification method or service its fragment references, or to the endpoint of the service selected by its service and relativeRef parameters. Encode the DID URL's fragment as %23; its parameters may also be passed as query parameters. Documents are represented in the media type negotiated with the Accept header, and the DID resolution result is returned for application/ld+json;profile="https://w3id.org/did-resolution".
+//	@Tags			DHT
+//	@Produce		json
+//	@Produce		application/did+json
+//	@Produce		application/did+ld+json
+//	@Produce		application/did+cbor
+//	@Produce		application/ld+json;profile="https://w3id.org/did-resolution"
+//	@Param			did			path		string	true	"DID or DID URL, such as did:dht:...%230"
+//	@Param			service		query		string	false	"ID fragment of the service whose endpoint to select"
+//	@Param			relativeRef	query		string	false	"Reference resolved against the selected service's endpoint"
+//	@Param			Resolution-Strategy	header	string	false	"Resolution strategy of the request rather than the gateway's: dht-first, storage-first, race, or cache-only"
+//	@Success		200			{object}	object	"DID document, verification method, or service"
+//	@Success		303			{string}	string	"Redirect to the selected service endpoint"
+//	@Failure		400			{string}	string	"Bad request"
+//	@Failure		403			{string}	string	"Client or DID is denied by the gateway"
+//	@Failure		404			{string}	string	"Not found"
+//	@Failure		406			{string}	string	"No acceptable representation"
+//	@Failure		410			{object}	object	"DID document of a deactivated DID"
+//	@Failure		429			{string}	string	"Too many requests"
+//	@Failure		500			{string}	string	"Internal server error"
+//	@Router			/dids/{did} [get]
+func (r *DHTRouter) ResolveDID(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.ResolveDID")
+	defer span.End()
+
+	mediaType, ok := negotiateDIDMediaType(c.GetHeader("Accept"), DIDJSONMediaType)
+	if !ok {
+		LoggingRespondErrMsg(c, fmt.Sprintf("unsupported media type: %s", c.GetHeader("Accept")), http.StatusNotAcceptable)
+		return
+	}
+
+	didURL := c.Param(DIDParam)
+	if rawQuery := c.Request.URL.RawQuery; rawQuery != "" && !strings.Contains(didURL, "?") {
+		didURL += "?" + rawQuery
+	}
+	u, err := did.ParseURL(didURL)
+	if err != nil {
+		if mediaType == DIDResolutionMediaType {
+			respondResolutionError(c, invalidDIDError, http.StatusBadRequest)
+			return
+		}
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid did url: %s", didURL), http.StatusBadRequest)
+		return
+	}
+
+	resolution, err := r.service.ResolveDID(ctx, u.DID.String())
+	if err != nil {
+		if errors.Is(err, service.SpamError) {
+			LoggingRespondErrMsg(c, fmt.Sprintf("too many requests for bad did %s", u.DID), http.StatusTooManyRequests)
+			return
+		}
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to resolve did: %s", u.DID), errorStatus(err))
+		return
+	}
+	if resolution == nil {
+		if mediaType == DIDResolutionMediaType {
+			respondResolutionError(c, notFoundError, http.StatusNotFound)
+			return
+		}
+		LoggingRespondErrMsg(c, fmt.Sprintf("did not found: %s", u.DID), http.StatusNotFound)
+		return
+	}
+	if resolution.Deactivated {
+		respondDIDDocument(c, mediaType, *resolution, http.StatusGone)
+		return
+	}
+	if u.IsDID() {
+		respondDIDDocument(c, mediaType, *resolution, http.StatusOK)
+		return
+	}
+
+	dereferenced, err := u.Dereference(*resolution.Document)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, did.ErrNotDereferenced) {
+			status = http.StatusNotFound
+		}
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to dereference did url: %s", didURL), status)
+		return
+	}
+	switch {
+	case dereferenced.VerificationMethod != nil:
+		Respond(c, dereferenced.VerificationMethod, http.StatusOK)
+	case dereferenced.Service != nil:
+		Respond(c, dereferenced.Service, http.StatusOK)
+	default:
+		c.Redirect(http.StatusSeeOther, dereferenced.ServiceEndpoint)
+	}
+}
+
+// ResolveIdentifier godoc
+//
+//	@Summary		Resolve a DID as a Universal Resolver driver
+//	@Description	ResolveIdentifier resolves a DID following the DIF Universal Resolver driver contract, so the gateway can be deployed as the did:dht driver of a Universal Resolver. The DID resolution result is returned unless a DID document representation is accepted, and errors are always resolution results, with the error in their didResolutionMetadata.
+//	@Tags			DHT
+//	@Produce		application/ld+json;profile="https://w3id.org/did-resolution"
+//	@Produce		application/did+ld+json
+//	@Produce		application/did+json
+//	@Produce		application/did+cbor
+//	@Param			did	path		string	true	"DID to resolve"
+//	@Param			Resolution-Strategy	header	string	false	"Resolution strategy of the request rather than the gateway's: dht-first, storage-first, race, or cache-only"
+//	@Success		200	{object}	ResolutionResult
+//	@Failure		400	{object}	ResolutionResult	"Invalid DID"
+//	@Failure		403	{object}	ResolutionResult	"Client or DID is denied by the gateway"
+//	@Failure		404	{object}	ResolutionResult	"Not found"
+//	@Failure		406	{object}	ResolutionResult	"No acceptable representation"
+//	@Failure		410	{object}	ResolutionResult	"Deactivated DID"
+//	@Failure		429	{string}	string				"Too many requests"
+//	@Failure		500	{object}	ResolutionResult	"Internal server error"
+//	@Failure		501	{object}	ResolutionResult	"DID method not supported"
+//	@Router			/1.0/identifiers/{did} [get]
+func (r *DHTRouter) ResolveIdentifier(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.ResolveIdentifier")
+	defer span.End()
+
+	mediaType, ok := negotiateDIDMediaType(c.GetHeader("Accept"), DIDResolutionMediaType)
+	if !ok {
+		respondResolutionError(c, representationNotSupportedError, http.StatusNotAcceptable)
+		return
+	}
+
+	id := c.Param(DIDParam)
+	if method, _, found := strings.Cut(strings.TrimPrefix(id, "did:"), ":"); strings.HasPrefix(id, "did:") && found && method != "dht" {
+		respondResolutionError(c, methodNotSupportedError, http.StatusNotImplemented)
+		return
+	}
+	if !did.DHT(id).IsValid() {
+		respondResolutionError(c, invalidDIDError, http.StatusBadRequest)
+		return
+	}
+
+	resolution, err := r.service.ResolveDID(ctx, id)
+	if err != nil {
+		if errors.Is(err, service.SpamError) {
+			LoggingRespondErrMsg(c, fmt.Sprintf("too many requests for bad did %s", id), http.StatusTooManyRequests)
+			return
+		}
+		// the resolution spec has no error for DIDs a resolver refuses, so they're reported as not found, told apart
+		// by the status
+		if errors.Is(err, dht.ErrDenied) {
+			respondResolutionError(c, notFoundError, http.StatusForbidden)
+			return
+		}
+		logrus.WithContext(ctx).WithError(err).WithField("did", id).Error("failed to resolve did")
+		respondResolutionError(c, internalError, http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case resolution == nil:
+		respondResolutionError(c, notFoundError, http.StatusNotFound)
+	case resolution.Deactivated:
+		respondDIDDocument(c, mediaType, *resolution, http.StatusGone)
+	default:
+		respondDIDDocument(c, mediaType, *resolution, http.StatusOK)
+	}
+}
+
+// ownerSignatureMaxAge is how long after a DID's owner signs a request the request is accepted
+const ownerSignatureMaxAge = 5 * time.Minute
+
+// RepublishDID godoc
+//
+//	@Summary		Republish a DID now
+//	@Description	RepublishDID puts a DID's stored record to the DHT now, rather than waiting for the next scheduled republish, for a DID that dropped off the DHT. The request must be signed by the DID's identity key, as an HTTP Message Signature labelled diddht over its @method and @path and created in the last 5 minutes, or be made by an admin.
+//	@Tags			DHT
+//	@Produce		json
+//	@Param			did	path		string	true	"DID to republish"
+//	@Success		200	{object}	service.RepublishReport
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		401	{string}	string	"Unauthorized"
+//	@Failure		403	{string}	string	"Client is denied by the gateway"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		410	{string}	string	"DID deactivated"
+//	@Failure		429	{string}	string	"Too many requests"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Failure		502	{object}	service.RepublishReport	"Too few DHT nodes stored the record"
+//	@Security		OwnerSignature
+//	@Security		AdminToken
+//	@Router			/dids/{did}/republish [post]
+func (r *DHTRouter) RepublishDID(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.RepublishDID")
+	defer span.End()
+
+	id, err := didSuffix(c.Param(DIDParam))
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid did: %s", c.Param(DIDParam)), http.StatusBadRequest)
+		return
+	}
+	if !isAdmin(c, r.adminToken) {
+		// the suffix of a did is its identity key
+		key, _, _ := dht.ParseRecordID(id)
+		if err = httpsig.VerifyRequest(c.Request, ed25519.PublicKey(key), ownerSignatureMaxAge); err != nil {
+			LoggingRespondErrWithMsg(c, err, "request must be signed by the did's identity key", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	report, err := r.service.RepublishRecord(ctx, id)
+	switch {
+	case errors.Is(err, dht.ErrDeactivated):
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("not republishing deactivated did: %s", id), http.StatusGone)
+	case err != nil && report != nil:
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to republish did")
+		Respond(c, report, http.StatusBadGateway)
+	case err != nil:
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to republish did: %s", id), http.StatusInternalServerError)
+	case report == nil:
+		LoggingRespondErrMsg(c, fmt.Sprintf("did not found: %s", id), http.StatusNotFound)
+	default:
+		Respond(c, report, http.StatusOK)
+	}
+}
+
+// VersionSummary identifies a version of a record in the gateway's record history
+type VersionSummary struct {
+	VersionID string `json:"versionId"`
+	// VersionTime is when the version was published, from its sequence number
+	VersionTime time.Time `json:"versionTime"`
+}
+
+// ListVersionsResponse lists the versions of a record, oldest first
+type ListVersionsResponse struct {
+	Versions []VersionSummary `json:"versions"`
+}
+
+// ListVersions godoc
+//
+//	@Summary		List the versions of a DID
+//	@Description	ListVersions lists every version of a DID in the gateway's record history, oldest first
+//	@Tags			DHT
+//	@Produce		json
+//	@Param			id	path		string	true	"ID of the DID: the z-base-32 encoded key"
+//	@Success		200	{object}	ListVersionsResponse
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		403	{string}	string	"Client or DID is denied by the gateway"
+//	@Failure		404	{string}	string	"Not found"
+//	@Failure		429	{string}	string	"Too many requests"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Router			/{id}/versions [get]
+func (r *DHTRouter) ListVersions(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.ListVersions")
+	defer span.End()
+
+	id, ok := didParam(c)
+	if !ok {
+		return
+	}
+	versions, err := r.service.ListDHTVersions(ctx, id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to list versions: %s", id), errorStatus(err))
+		return
+	}
+	if len(versions) == 0 {
+		LoggingRespondErrMsg(c, fmt.Sprintf("no versions found: %s", id), http.StatusNotFound)
+		return
+	}
+
+	resp := ListVersionsResponse{Versions: make([]VersionSummary, 0, len(versions))}
+	for _, version := range versions {
+		resp.Versions = append(resp.Versions, VersionSummary{
+			VersionID:   strconv.FormatInt(version.SequenceNumber, 10),
+			VersionTime: time.Unix(version.SequenceNumber, 0).UTC(),
+		})
+	}
+	Respond(c, resp, http.StatusOK)
+}
+
+// GetVersion godoc
+//
+//	@Summary		Resolve a version of a DID
+//	@Description	GetVersion resolves a version of a DID from the gateway's record history to its DID document, with DID Core document metadata linking it to the next version
+//	@Tags			DHT
+//	@Produce		json
+//	@Param			id			path		string	true	"ID of the DID: the z-base-32 encoded key"
+//	@Param			versionId	path		integer	true	"Sequence number of the version"
+//	@Success		200			{object}	service.DIDVersion
+//	@Failure		400			{string}	string	"Bad request"
+//	@Failure		403			{string}	string	"Client or DID is denied by the gateway"
+//	@Failure		404			{string}	string	"Not found"
+//	@Failure		429			{string}	string	"Too many requests"
+//	@Failure		500			{string}	string	"Internal server error"
+//	@Router			/{id}/versions/{versionId} [get]
+func (r *DHTRouter) GetVersion(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.GetVersion")
+	defer span.End()
+
+	id, ok := didParam(c)
+	if !ok {
+		return
+	}
+	versionID := c.Param(VersionIDParam)
+	seq, err := strconv.ParseInt(versionID, 10, 64)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid version id: %s", versionID), http.StatusBadRequest)
+		return
+	}
+
+	version, err := r.service.GetDIDVersion(ctx, id, seq)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to resolve version %d: %s", seq, id), errorStatus(err))
+		return
+	}
+	if version == nil {
+		LoggingRespondErrMsg(c, fmt.Sprintf("version %d not found: %s", seq, id), http.StatusNotFound)
+		return
+	}
+	Respond(c, version, http.StatusOK)
+}
+
+// didParam returns the id path parameter if it is a DID's z-base-32 encoded key, and responds with an error otherwise.
+// Salted records aren't DIDs, so they have no DID documents to version.
+func didParam(c *gin.Context) (string, bool) {
+	id := GetParam(c, IDParam)
+	if id == nil || *id == "" {
+		LoggingRespondErrMsg(c, "missing id param", http.StatusBadRequest)
+		return "", false
+	}
+	_, salt, err := dht.ParseRecordID(*id)
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid record id: %s", *id), http.StatusBadRequest)
+		return "", false
+	}
+	if len(salt) > 0 {
+		LoggingRespondErrMsg(c, fmt.Sprintf("salted records aren't versioned as dids: %s", *id), http.StatusBadRequest)
+		return "", false
+	}
+	return *id, true
+}
+
+// errorStatus maps an error from a DHT operation to the HTTP status it is served with
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, dht.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, dht.ErrBadSignature), errors.Is(err, dht.ErrValueTooLarge), errors.Is(err, dht.ErrInvalidDNSPacket),
+		errors.Is(err, dht.ErrInvalidTypes), errors.Is(err, dht.ErrTooManyRecords), errors.Is(err, dht.ErrInvalidDocument),
+		errors.Is(err, dht.ErrInvalidRotation):
+		return http.StatusBadRequest
+	case errors.Is(err, dht.ErrAbusive), errors.Is(err, dht.ErrDenied):
+		return http.StatusForbidden
+	case errors.Is(err, dht.ErrDeactivated), errors.Is(err, dht.ErrSeqConflict):
+		return http.StatusConflict
+	case errors.Is(err, dht.ErrAllPutsFailed):
+		return http.StatusBadGateway
+	case errors.Is(err, dht.ErrStalled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -2,26 +2,39 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
 
 	"github.com/TBD54566975/did-dht/config"
 	"github.com/TBD54566975/did-dht/internal/did"
 	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/httpsig"
 	"github.com/TBD54566975/did-dht/pkg/service"
 	"github.com/TBD54566975/did-dht/pkg/storage"
 )
 
 func TestDHTRouter(t *testing.T) {
 	dhtSvc := testDHTService(t)
-	dhtRouter, err := NewDHTRouter(&dhtSvc)
+	dhtRouter, err := NewDHTRouter(dhtSvc, 0)
 	require.NoError(t, err)
 	require.NotEmpty(t, dhtRouter)
 
@@ -65,6 +78,503 @@ func TestDHTRouter(t *testing.T) {
 		assert.Equal(t, reqData, resp)
 	})
 
+	t.Run("test put and get salted record", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		bep44Put, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+		require.NoError(t, err)
+
+		var seqBuf [8]byte
+		binary.BigEndian.PutUint64(seqBuf[:], uint64(bep44Put.Seq))
+		reqData := append(bep44Put.Sig[:], append(seqBuf[:], bep44Put.V.([]byte)...)...)
+		id := dht.RecordFromBEP44(bep44Put).ID()
+
+		// the salt is part of the signature, so it can't be put without it
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode, "unexpected %s", w.Result().Status)
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, id), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: id}))
+		assert.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", testServerURL, id), nil)
+		dhtRouter.GetRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: id}))
+		assert.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+		resp, err := io.ReadAll(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, reqData, resp)
+	})
+
+	t.Run("test get raw record", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		bep44Put, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+		require.NoError(t, err)
+		var seqBuf [8]byte
+		binary.BigEndian.PutUint64(seqBuf[:], uint64(bep44Put.Seq))
+		reqData := append(bep44Put.Sig[:], append(seqBuf[:], bep44Put.V.([]byte)...)...)
+		id := dht.RecordFromBEP44(bep44Put).ID()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, id), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: id}))
+		require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s?raw=true", testServerURL, id), nil)
+		dhtRouter.GetRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: id}))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var raw RawRecordResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		assert.Equal(t, suffix, raw.K)
+		assert.Equal(t, bep44Put.Seq, raw.Seq)
+		decode := func(s string) []byte {
+			b, err := base64.RawURLEncoding.DecodeString(s)
+			require.NoError(t, err)
+			return b
+		}
+		assert.Equal(t, []byte("salt"), decode(raw.Salt))
+		v := decode(raw.V)
+		assert.Equal(t, fmt.Sprintf("%d:%s", len(bep44Put.V.([]byte)), bep44Put.V), string(v))
+		// the client can verify the record without the gateway decoding it
+		assert.True(t, bep44.Verify(bep44Put.K[:], decode(raw.Salt), raw.Seq, v, decode(raw.Sig)))
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s?raw=maybe", testServerURL, id), nil)
+		dhtRouter.GetRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: id}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("test get record version", func(t *testing.T) {
+		didID, reqData := generateDIDPutRequest(t)
+		suffix, err := did.DHT(didID).Suffix()
+		require.NoError(t, err)
+		seq := int64(binary.BigEndian.Uint64(reqData[64:72]))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+		tests := []struct {
+			query  string
+			status int
+		}{
+			{query: fmt.Sprintf("versionId=%d", seq), status: http.StatusOK},
+			{query: fmt.Sprintf("versionId=%d", seq+1), status: http.StatusNotFound},
+			{query: "versionTime=" + url.QueryEscape(time.Unix(seq, 0).Add(time.Hour).Format(time.RFC3339)), status: http.StatusOK},
+			{query: "versionTime=" + url.QueryEscape(time.Unix(seq, 0).Add(-time.Hour).Format(time.RFC3339)), status: http.StatusNotFound},
+			{query: "versionId=latest", status: http.StatusBadRequest},
+			{query: "versionTime=yesterday", status: http.StatusBadRequest},
+			{query: fmt.Sprintf("versionId=%d&versionTime=2024-01-01T00:00:00Z", seq), status: http.StatusBadRequest},
+		}
+		for _, test := range tests {
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s?%s", testServerURL, suffix, test.query), nil)
+			dhtRouter.GetRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+			assert.Equal(t, test.status, w.Result().StatusCode, "unexpected %s for %s", w.Result().Status, test.query)
+			if test.status == http.StatusOK {
+				assert.Equal(t, reqData, w.Body.Bytes())
+			}
+		}
+	})
+
+	t.Run("test list and resolve versions", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		first, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		second := *first
+		second.Seq++
+		second.Sign(sk)
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		for _, putMsg := range []*bep44.Put{first, &second} {
+			var seqBuf [8]byte
+			binary.BigEndian.PutUint64(seqBuf[:], uint64(putMsg.Seq))
+			reqData := append(putMsg.Sig[:], append(seqBuf[:], putMsg.V.([]byte)...)...)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+			dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+			require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s/versions", testServerURL, suffix), nil)
+		dhtRouter.ListVersions(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		var versions ListVersionsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &versions))
+		require.Len(t, versions.Versions, 2)
+		assert.Equal(t, fmt.Sprint(first.Seq), versions.Versions[0].VersionID)
+		assert.Equal(t, time.Unix(second.Seq, 0).UTC(), versions.Versions[1].VersionTime)
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s/versions/%d", testServerURL, suffix, first.Seq), nil)
+		dhtRouter.GetVersion(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix, VersionIDParam: fmt.Sprint(first.Seq)}))
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		var version service.DIDVersion
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &version))
+		assert.Equal(t, doc.ID, version.Document.ID)
+		assert.Equal(t, fmt.Sprint(first.Seq), version.Metadata.VersionID)
+		assert.Equal(t, fmt.Sprint(second.Seq), version.Metadata.NextVersionID)
+		assert.Equal(t, time.Unix(first.Seq, 0).UTC(), version.Metadata.Created)
+
+		for _, bad := range []struct {
+			id, versionID string
+			status        int
+		}{
+			{suffix, fmt.Sprint(second.Seq + 1), http.StatusNotFound},
+			{suffix, "latest", http.StatusBadRequest},
+			{suffix + ".c2FsdA", fmt.Sprint(first.Seq), http.StatusBadRequest},
+			{"invalid", fmt.Sprint(first.Seq), http.StatusBadRequest},
+		} {
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s/versions/%s", testServerURL, bad.id, bad.versionID), nil)
+			dhtRouter.GetVersion(newRequestContextWithParams(w, req, map[string]string{IDParam: bad.id, VersionIDParam: bad.versionID}))
+			assert.Equal(t, bad.status, w.Code, "%s version %s", bad.id, bad.versionID)
+		}
+
+		missing, _ := generateDIDPutRequest(t)
+		missingSuffix, err := did.DHT(missing).Suffix()
+		require.NoError(t, err)
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s/versions", testServerURL, missingSuffix), nil)
+		dhtRouter.ListVersions(newRequestContextWithParams(w, req, map[string]string{IDParam: missingSuffix}))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("test resolve dids", func(t *testing.T) {
+		didID, reqData := generateDIDPutRequest(t)
+		suffix, err := did.DHT(didID).Suffix()
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+		body, err := json.Marshal(ResolveDIDsRequest{DIDs: []string{didID, "did:example:123"}})
+		require.NoError(t, err)
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/dids/resolve", testServerURL), bytes.NewReader(body))
+		dhtRouter.ResolveDIDs(newRequestContext(w, req))
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+
+		var resp ResolveDIDsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Results, 2)
+		assert.Equal(t, didID, resp.Results[0].DID)
+		require.NotNil(t, resp.Results[0].Document)
+		assert.Equal(t, didID, resp.Results[0].Document.ID)
+		assert.Equal(t, fmt.Sprint(binary.BigEndian.Uint64(reqData[64:72])), resp.Results[0].VersionID)
+		assert.Empty(t, resp.Results[0].Error)
+		assert.Equal(t, "did:example:123", resp.Results[1].DID)
+		assert.Nil(t, resp.Results[1].Document)
+		assert.NotEmpty(t, resp.Results[1].Error)
+
+		tooMany := make([]string, service.MaxResolveBatchSize+1)
+		for i := range tooMany {
+			tooMany[i] = didID
+		}
+		tooManyBody, err := json.Marshal(ResolveDIDsRequest{DIDs: tooMany})
+		require.NoError(t, err)
+		for _, bad := range []string{`{"dids": []}`, `not json`, string(tooManyBody)} {
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/dids/resolve", testServerURL), bytes.NewReader([]byte(bad)))
+			dhtRouter.ResolveDIDs(newRequestContext(w, req))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("test resolve and dereference did urls", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{
+			Services: []didsdk.Service{{ID: "hub", Type: "DWN", ServiceEndpoint: "https://example.com/dwn/"}},
+		})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		var seqBuf [8]byte
+		binary.BigEndian.PutUint64(seqBuf[:], uint64(putMsg.Seq))
+		reqData := append(putMsg.Sig[:], append(seqBuf[:], putMsg.V.([]byte)...)...)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+		resolve := func(didURL, rawQuery string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			target := fmt.Sprintf("%s/dids/%s", testServerURL, url.PathEscape(didURL))
+			if rawQuery != "" {
+				target += "?" + rawQuery
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			dhtRouter.ResolveDID(newRequestContextWithParams(w, req, map[string]string{DIDParam: didURL}))
+			return w
+		}
+
+		w = resolve(doc.ID, "")
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		var resolved didsdk.Document
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resolved))
+		assert.Equal(t, doc.ID, resolved.ID)
+
+		w = resolve(doc.ID+"#0", "")
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		var vm didsdk.VerificationMethod
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &vm))
+		assert.Equal(t, doc.ID+"#0", vm.ID)
+
+		w = resolve(doc.ID+"#hub", "")
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		var svc didsdk.Service
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &svc))
+		assert.Equal(t, doc.ID+"#hub", svc.ID)
+
+		w = resolve(doc.ID, "service=hub&relativeRef=records%2F1")
+		require.Equal(t, http.StatusSeeOther, w.Code, "unexpected %s", w.Result().Status)
+		assert.Equal(t, "https://example.com/dwn/records/1", w.Header().Get("Location"))
+
+		w = resolve(doc.ID+"?service=hub", "")
+		require.Equal(t, http.StatusSeeOther, w.Code, "unexpected %s", w.Result().Status)
+		assert.Equal(t, "https://example.com/dwn/", w.Header().Get("Location"))
+
+		for _, bad := range []struct {
+			didURL, rawQuery string
+			status           int
+		}{
+			{doc.ID + "#missing", "", http.StatusNotFound},
+			{doc.ID, "service=missing", http.StatusNotFound},
+			{doc.ID, "versionId=1", http.StatusBadRequest},
+			{doc.ID + "/path", "", http.StatusBadRequest},
+			{"did:example:123", "", http.StatusBadRequest},
+		} {
+			w = resolve(bad.didURL, bad.rawQuery)
+			assert.Equal(t, bad.status, w.Code, "%s?%s", bad.didURL, bad.rawQuery)
+		}
+
+		missing, _ := generateDIDPutRequest(t)
+		w = resolve(missing, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("test resolve did representations", func(t *testing.T) {
+		didID, reqData := generateDIDPutRequest(t)
+		suffix, err := did.DHT(didID).Suffix()
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+		resolve := func(didID, accept string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/dids/%s", testServerURL, didID), nil)
+			req.Header.Set("Accept", accept)
+			dhtRouter.ResolveDID(newRequestContextWithParams(w, req, map[string]string{DIDParam: didID}))
+			return w
+		}
+
+		w = resolve(didID, DIDJSONMediaType)
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		assert.Equal(t, DIDJSONMediaType, w.Header().Get("Content-Type"))
+		var doc didsdk.Document
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, didID, doc.ID)
+		assert.Nil(t, doc.Context)
+
+		w = resolve(didID, DIDLDJSONMediaType)
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		assert.Equal(t, DIDLDJSONMediaType, w.Header().Get("Content-Type"))
+		doc = didsdk.Document{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, didsdk.KnownDIDContext, doc.Context)
+
+		w = resolve(didID, DIDCBORMediaType)
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		assert.Equal(t, DIDCBORMediaType, w.Header().Get("Content-Type"))
+		var cborDoc map[string]any
+		require.NoError(t, codec.NewDecoderBytes(w.Body.Bytes(), new(codec.CborHandle)).Decode(&cborDoc))
+		assert.Equal(t, didID, cborDoc["id"])
+
+		w = resolve(didID, DIDResolutionMediaType)
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		assert.Equal(t, DIDResolutionMediaType, w.Header().Get("Content-Type"))
+		var result ResolutionResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		require.NotNil(t, result.DIDDocument)
+		assert.Equal(t, didID, result.DIDDocument.ID)
+		assert.Equal(t, DIDLDJSONMediaType, result.DIDResolutionMetadata.ContentType)
+		seq := int64(binary.BigEndian.Uint64(reqData[64:72]))
+		assert.Equal(t, fmt.Sprint(seq), result.DIDDocumentMetadata.VersionID)
+		require.NotNil(t, result.DIDDocumentMetadata.Updated)
+		assert.Equal(t, time.Unix(seq, 0).UTC(), *result.DIDDocumentMetadata.Updated)
+		assert.NotEmpty(t, result.DIDResolutionMetadata.Source)
+		require.NotNil(t, result.DIDResolutionMetadata.Retrieved)
+		assert.WithinDuration(t, time.Now(), *result.DIDResolutionMetadata.Retrieved, time.Minute)
+		require.NotNil(t, result.DIDResolutionMetadata.SignatureVerified)
+		assert.True(t, *result.DIDResolutionMetadata.SignatureVerified)
+
+		missing, _ := generateDIDPutRequest(t)
+		for _, bad := range []struct {
+			didID, resolutionError string
+			status                 int
+		}{
+			{missing, notFoundError, http.StatusNotFound},
+			{"did:example:123", invalidDIDError, http.StatusBadRequest},
+		} {
+			w = resolve(bad.didID, DIDResolutionMediaType)
+			require.Equal(t, bad.status, w.Code, bad.didID)
+			result = ResolutionResult{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Nil(t, result.DIDDocument)
+			assert.Equal(t, bad.resolutionError, result.DIDResolutionMetadata.Error)
+		}
+
+		w = resolve(didID, "text/html")
+		assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	})
+
+	t.Run("test resolve identifier as a universal resolver driver", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		put := func(packet *dns.Msg, seq int64) {
+			bep44Put, err := dht.CreateDNSPublishRequest(sk, *packet)
+			require.NoError(t, err)
+			bep44Put.Seq = seq
+			bep44Put.Sign(sk)
+			body := append(bep44Put.Sig[:], append(binary.BigEndian.AppendUint64(nil, uint64(bep44Put.Seq)), bep44Put.V.([]byte)...)...)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(body))
+			dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+			require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+		}
+		resolve := func(id, accept string) (*httptest.ResponseRecorder, ResolutionResult) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/1.0/identifiers/%s", testServerURL, id), nil)
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			dhtRouter.ResolveIdentifier(newRequestContextWithParams(w, req, map[string]string{DIDParam: id}))
+			var result ResolutionResult
+			if w.Header().Get("Content-Type") == DIDResolutionMediaType {
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			}
+			return w, result
+		}
+
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		seq := time.Now().Unix()
+		put(packet, seq)
+
+		// the resolution result is the default representation
+		for _, accept := range []string{"", "*/*", DIDResolutionMediaType} {
+			w, result := resolve(doc.ID, accept)
+			require.Equal(t, http.StatusOK, w.Code, accept)
+			assert.Equal(t, DIDResolutionMediaType, w.Header().Get("Content-Type"), accept)
+			require.NotNil(t, result.DIDDocument, accept)
+			assert.Equal(t, doc.ID, result.DIDDocument.ID)
+			assert.Equal(t, DIDLDJSONMediaType, result.DIDResolutionMetadata.ContentType)
+			assert.Equal(t, fmt.Sprint(seq), result.DIDDocumentMetadata.VersionID)
+		}
+		w, _ := resolve(doc.ID, DIDLDJSONMediaType)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, DIDLDJSONMediaType, w.Header().Get("Content-Type"))
+		var resolved didsdk.Document
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resolved))
+		assert.Equal(t, doc.ID, resolved.ID)
+
+		missing, _ := generateDIDPutRequest(t)
+		for _, bad := range []struct {
+			id, accept, resolutionError string
+			status                      int
+		}{
+			{missing, "", notFoundError, http.StatusNotFound},
+			{"did:dht:invalid", "", invalidDIDError, http.StatusBadRequest},
+			{"example", "", invalidDIDError, http.StatusBadRequest},
+			{"did:example:123", "", methodNotSupportedError, http.StatusNotImplemented},
+			{doc.ID, "text/html", representationNotSupportedError, http.StatusNotAcceptable},
+		} {
+			w, result := resolve(bad.id, bad.accept)
+			require.Equal(t, bad.status, w.Code, bad.id)
+			assert.Nil(t, result.DIDDocument, bad.id)
+			assert.Equal(t, bad.resolutionError, result.DIDResolutionMetadata.Error, bad.id)
+		}
+
+		put(did.DeactivationDNSPacket(), seq+1)
+		w, result := resolve(doc.ID, "")
+		require.Equal(t, http.StatusGone, w.Code)
+		assert.True(t, result.DIDDocumentMetadata.Deactivated)
+	})
+
+	t.Run("test resolve did superseding a previous did", func(t *testing.T) {
+		previousSK, previousDoc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		didID := did.DHT(doc.ID)
+		previousDID, err := did.CreatePreviousDIDRecord(previousSK, did.DHT(previousDoc.ID), didID)
+		require.NoError(t, err)
+		packet, err := didID.ToDNSPacket(*doc, nil, nil, previousDID)
+		require.NoError(t, err)
+
+		put := func(packet *dns.Msg) int {
+			bep44Put, err := dht.CreateDNSPublishRequest(sk, *packet)
+			require.NoError(t, err)
+			suffix, err := didID.Suffix()
+			require.NoError(t, err)
+			body := append(bep44Put.Sig[:], append(binary.BigEndian.AppendUint64(nil, uint64(bep44Put.Seq)), bep44Put.V.([]byte)...)...)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(body))
+			dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+			return w.Code
+		}
+
+		// a previous record signed by another key is rejected
+		forged := packet.Copy()
+		for _, rr := range forged.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && txt.Hdr.Name == "_prv._did." {
+				forgedSK, _, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+				require.NoError(t, err)
+				forgedSig := base64.RawURLEncoding.EncodeToString(ed25519.Sign(forgedSK, forgedSK.Public().(ed25519.PublicKey)))
+				txt.Txt = []string{fmt.Sprintf("id=%s;s=%s", previousDoc.ID, forgedSig)}
+			}
+		}
+		assert.Equal(t, http.StatusBadRequest, put(forged))
+
+		require.True(t, is2xxResponse(put(packet)))
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/dids/%s", testServerURL, doc.ID), nil)
+		req.Header.Set("Accept", DIDResolutionMediaType)
+		dhtRouter.ResolveDID(newRequestContextWithParams(w, req, map[string]string{DIDParam: doc.ID}))
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		var result ResolutionResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, previousDoc.ID, result.DIDDocumentMetadata.PreviousDID)
+	})
+
 	t.Run("test get no ID", func(t *testing.T) {
 		didID, reqData := generateDIDPutRequest(t)
 
@@ -85,6 +595,114 @@ func TestDHTRouter(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode, "unexpected %s", w.Result().Status)
 	})
 
+	t.Run("test list dids by type", func(t *testing.T) {
+		since := time.Now()
+		var dids []string
+		for i := 0; i < 3; i++ {
+			sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+			require.NoError(t, err)
+			packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, []did.TypeIndex{did.WebApplication}, nil, nil)
+			require.NoError(t, err)
+			bep44Put, err := dht.CreateDNSPublishRequest(sk, *packet)
+			require.NoError(t, err)
+			var seqBuf [8]byte
+			binary.BigEndian.PutUint64(seqBuf[:], uint64(bep44Put.Seq))
+			reqData := append(bep44Put.Sig[:], append(seqBuf[:], bep44Put.V.([]byte)...)...)
+
+			suffix, err := did.DHT(doc.ID).Suffix()
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+			dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+			require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+			dids = append(dids, doc.ID)
+		}
+
+		// page through every did of the type, two at a time
+		listed := make(map[string]int)
+		var pageToken string
+		for first := true; first || pageToken != ""; first = false {
+			query := url.Values{"pageSize": {"2"}}
+			if pageToken != "" {
+				query.Set("pageToken", pageToken)
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/dids/types/6?%s", testServerURL, query.Encode()), nil)
+			dhtRouter.ListDIDsByType(newRequestContextWithParams(w, req, map[string]string{IDParam: "6"}))
+			require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+
+			var page ListDIDsByTypeResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			require.LessOrEqual(t, len(page.DIDs), 2)
+			for _, id := range page.DIDs {
+				listed[id]++
+			}
+			pageToken = page.NextPageToken
+		}
+		for _, id := range dids {
+			assert.Equal(t, 1, listed[id], id)
+		}
+
+		// the dids published since the sync started, most recently updated first
+		query := url.Values{"updatedSince": {since.Format(time.RFC3339Nano)}, "order": {"-updated"}}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/dids/types/6?%s", testServerURL, query.Encode()), nil)
+		dhtRouter.ListDIDsByType(newRequestContextWithParams(w, req, map[string]string{IDParam: "6"}))
+		require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+		var page ListDIDsByTypeResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, []string{dids[2], dids[1], dids[0]}, page.DIDs)
+		assert.Empty(t, page.NextPageToken)
+
+		for _, bad := range []struct{ typ, query string }{
+			{"web", ""},
+			{"-1", ""},
+			{"6", "pageSize=0"},
+			{"6", "pageSize=1001"},
+			{"6", "pageToken=!!"},
+			{"6", "order=newest"},
+			{"6", "updatedSince=yesterday"},
+			{"6", "order=updated&pageToken=" + base64.RawURLEncoding.EncodeToString([]byte("invalid"))},
+		} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/dids/types/%s?%s", testServerURL, bad.typ, bad.query), nil)
+			dhtRouter.ListDIDsByType(newRequestContextWithParams(w, req, map[string]string{IDParam: bad.typ}))
+			assert.Equal(t, http.StatusBadRequest, w.Code, "type %s with %s", bad.typ, bad.query)
+		}
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/dids/types/8", testServerURL), nil)
+		dhtRouter.ListDIDsByType(newRequestContextWithParams(w, req, map[string]string{IDParam: "8"}))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("test put invalid types", func(t *testing.T) {
+		for _, types := range [][]string{{"id=8"}, {"id=1,1"}, {"1,7"}, {"id="}, {"id=web"}, {"id=1", "id=7"}} {
+			sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+			require.NoError(t, err)
+			packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+			require.NoError(t, err)
+			for _, typ := range types {
+				packet.Answer = append(packet.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: "_typ._did.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 7200},
+					Txt: []string{typ},
+				})
+			}
+			bep44Put, err := dht.CreateDNSPublishRequest(sk, *packet)
+			require.NoError(t, err)
+			var seqBuf [8]byte
+			binary.BigEndian.PutUint64(seqBuf[:], uint64(bep44Put.Seq))
+			reqData := append(bep44Put.Sig[:], append(seqBuf[:], bep44Put.V.([]byte)...)...)
+
+			suffix, err := did.DHT(doc.ID).Suffix()
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+			dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+			assert.Equal(t, http.StatusBadRequest, w.Code, "types %v", types)
+		}
+	})
+
 	t.Run("test put no ID", func(t *testing.T) {
 		_, reqData := generateDIDPutRequest(t)
 
@@ -107,7 +725,7 @@ func TestDHTRouter(t *testing.T) {
 		c := newRequestContextWithParams(w, req, map[string]string{IDParam: suffix})
 
 		dhtRouter.PutRecord(c)
-		assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode, "unexpected %s", w.Result().Status)
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode, "unexpected %s", w.Result().Status)
 	})
 
 	t.Run("test put invalid record signature", func(t *testing.T) {
@@ -138,6 +756,119 @@ func TestDHTRouter(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode, "unexpected %s", w.Result().Status)
 	})
 
+	t.Run("test put body over the limit", func(t *testing.T) {
+		limited, err := NewDHTRouter(dhtSvc, 100)
+		require.NoError(t, err)
+		didID, reqData := generateDIDPutRequest(t)
+		suffix, err := did.DHT(didID).Suffix()
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		limited.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		var resp PutErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, LimitBodyLength, resp.Limit)
+		assert.Equal(t, int64(100), resp.Max)
+		assert.Equal(t, int64(len(reqData)), resp.Actual)
+		assert.Contains(t, resp.Error, "over the 100 byte limit")
+	})
+
+	t.Run("test deactivate record", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		put, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		require.NoError(t, dhtSvc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(put)))
+
+		deactivate := func(id string, packet *dns.Msg, seq int64) int {
+			put, err := dht.CreateDNSPublishRequest(sk, *packet)
+			require.NoError(t, err)
+			put.Seq = seq
+			put.Sign(sk)
+			var seqBuf [8]byte
+			binary.BigEndian.PutUint64(seqBuf[:], uint64(put.Seq))
+			body := append(put.Sig[:], append(seqBuf[:], put.V.([]byte)...)...)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("%s/%s", testServerURL, id), bytes.NewReader(body))
+			dhtRouter.DeactivateRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: id}))
+			return w.Code
+		}
+
+		// only the deactivation record deactivates, and only with a newer seq
+		assert.Equal(t, http.StatusBadRequest, deactivate(suffix, packet, put.Seq+1))
+		assert.Equal(t, http.StatusConflict, deactivate(suffix, did.DeactivationDNSPacket(), put.Seq))
+
+		assert.Equal(t, http.StatusOK, deactivate(suffix, did.DeactivationDNSPacket(), put.Seq+1))
+		resolution, err := dhtSvc.ResolveDID(context.Background(), doc.ID)
+		require.NoError(t, err)
+		assert.True(t, resolution.Deactivated)
+		stored, err := dhtSvc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Equal(t, put.Seq+1, stored.Seq)
+
+		assert.Equal(t, http.StatusConflict, deactivate(suffix, did.DeactivationDNSPacket(), put.Seq+2))
+	})
+
+	t.Run("test deactivate unknown record", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		put, err := dht.CreateDNSPublishRequest(sk, *did.DeactivationDNSPacket())
+		require.NoError(t, err)
+		var seqBuf [8]byte
+		binary.BigEndian.PutUint64(seqBuf[:], uint64(put.Seq))
+		body := append(put.Sig[:], append(seqBuf[:], put.V.([]byte)...)...)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(body))
+		dhtRouter.DeactivateRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("test put too many records", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		for range 2 {
+			packet.Answer = append(packet.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: "_prv._did.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 7200},
+				Txt: []string{"id=did:dht:i9xkp8ddcbcg8jwq54ox699wuzxyifsqx4jru45zodqu453ksz6y;s=sig"},
+			})
+		}
+		bep44Put, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		var seqBuf [8]byte
+		binary.BigEndian.PutUint64(seqBuf[:], uint64(bep44Put.Seq))
+		reqData := append(bep44Put.Sig[:], append(seqBuf[:], bep44Put.V.([]byte)...)...)
+
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var resp PutErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, PutErrorResponse{
+			Error:  resp.Error,
+			Limit:  dht.LimitPreviousRecords,
+			Max:    1,
+			Actual: 2,
+		}, resp)
+		assert.Contains(t, resp.Error, "2 _prv._did. records, a DID has at most one")
+	})
+
 	t.Run("test get not found", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		suffix := "uqaj3fcr9db6jg6o9pjs53iuftyj45r46aubogfaceqjbo6pp9sy"
@@ -147,7 +878,7 @@ func TestDHTRouter(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, w.Result().StatusCode, "unexpected %s", w.Result().Status)
 	})
 
-	t.Run("test get not found spam", func(t *testing.T) {
+	t.Run("test get not found is cached", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		suffix := "cz13drbfxy3ih6xun4mw3cyiexrtfcs9gyp46o4469e93y36zhsy"
 		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", testServerURL, suffix), nil)
@@ -159,11 +890,189 @@ func TestDHTRouter(t *testing.T) {
 		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", testServerURL, suffix), nil)
 		c = newRequestContextWithParams(w, req, map[string]string{IDParam: suffix})
 		dhtRouter.GetRecord(c)
-		assert.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode, "unexpected %s", w.Result().Status)
+		assert.Equal(t, http.StatusNotFound, w.Result().StatusCode, "unexpected %s", w.Result().Status)
 	})
 }
 
-func testDHTService(t *testing.T) service.DHTService {
+func TestConditionalPut(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "conditional-put", config.PeeringConfig{})
+	dhtRouter, err := NewDHTRouter(svc, 0)
+	require.NoError(t, err)
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	bep44Put, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	suffix, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+
+	put := func(seq int64, expectedSeq string) *httptest.ResponseRecorder {
+		next := *bep44Put
+		next.Seq = seq
+		next.Sign(sk)
+		var seqBuf [8]byte
+		binary.BigEndian.PutUint64(seqBuf[:], uint64(next.Seq))
+		reqData := append(next.Sig[:], append(seqBuf[:], next.V.([]byte)...)...)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+		if expectedSeq != "" {
+			req.Header.Set(ExpectedSeqHeader, expectedSeq)
+		}
+		dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+		return w
+	}
+	first := bep44Put.Seq
+
+	w := put(first, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = put(first+1, fmt.Sprint(first))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// another device expecting the first version conflicts with the second
+	w = put(first+2, fmt.Sprint(first))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var resp PutErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, first+1, resp.Seq)
+	assert.Contains(t, resp.Error, dht.ErrSeqConflict.Error())
+
+	w = put(first+2, "latest")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRepublishDID(t *testing.T) {
+	svc, sim := simulatedDHTService(t, "republish-did", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, "admin-token"))
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	put, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(put)
+	require.NoError(t, svc.PublishDHT(context.Background(), record.ID(), record))
+
+	republish := func(id string, sign func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/dids/"+id+"/republish", nil)
+		if sign != nil {
+			sign(req)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	signedBy := func(key ed25519.PrivateKey) func(*http.Request) {
+		return func(req *http.Request) { httpsig.SignRequest(req, key) }
+	}
+	report := func(w *httptest.ResponseRecorder) service.RepublishReport {
+		var report service.RepublishReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return report
+	}
+
+	t.Run("by the owner", func(t *testing.T) {
+		w := republish(doc.ID, signedBy(sk))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		got := report(w)
+		assert.Equal(t, record.ID(), got.ID)
+		assert.Equal(t, put.Seq, got.SequenceNumber)
+	})
+
+	t.Run("by an admin", func(t *testing.T) {
+		w := republish(record.ID(), func(req *http.Request) { req.Header.Set("Authorization", "Bearer admin-token") })
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		_, other, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, republish(doc.ID, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, republish(doc.ID, signedBy(other)).Code)
+		assert.Equal(t, http.StatusUnauthorized, republish(doc.ID, func(req *http.Request) { req.Header.Set("Authorization", "Bearer wrong") }).Code)
+	})
+
+	t.Run("not stored", func(t *testing.T) {
+		otherKey, otherDoc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, republish(otherDoc.ID, signedBy(otherKey)).Code)
+	})
+
+	t.Run("invalid did", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, republish("did:example:123", signedBy(sk)).Code)
+	})
+
+	t.Run("put fails", func(t *testing.T) {
+		sim.SetUnreachable(true)
+		defer sim.SetUnreachable(false)
+		w := republish(doc.ID, signedBy(sk))
+		require.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+		assert.Equal(t, record.ID(), report(w).ID)
+	})
+}
+
+func TestResolutionStrategyHeader(t *testing.T) {
+	svc, sim := simulatedDHTService(t, "resolution-strategy", config.PeeringConfig{})
+	handler := gin.New()
+	handler.ContextWithFallback = true
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+
+	// the record is only on the dht
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	put, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	_, err = sim.Put(context.Background(), *put)
+	require.NoError(t, err)
+
+	resolve := func(strategy string) int {
+		req := httptest.NewRequest(http.MethodGet, "/dids/"+doc.ID, nil)
+		if strategy != "" {
+			req.Header.Set(ResolutionStrategyHeader, strategy)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNotFound, resolve("cache-only"))
+	assert.Equal(t, http.StatusOK, resolve(""))
+	assert.Equal(t, http.StatusOK, resolve("cache-only"))
+	assert.Equal(t, http.StatusBadRequest, resolve("dht-only"))
+}
+
+// TestPublishOutlivesRequest puts a record through the gin engine, which reuses a request's context once it's served,
+// and resolves it while the record is put to the DHT in the background. Run with -race.
+func TestPublishOutlivesRequest(t *testing.T) {
+	svc, sim := simulatedDHTService(t, "publish-outlives-request", config.PeeringConfig{})
+	handler := gin.New()
+	handler.ContextWithFallback = true
+	handler.Use(requestID())
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+
+	id, reqData := generateDIDPutRequest(t)
+	suffix, err := did.DHT(id).Suffix()
+	require.NoError(t, err)
+
+	puts := sim.Puts()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/"+suffix, bytes.NewReader(reqData)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	for range 10 {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+suffix, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	assert.Eventually(t, func() bool { return sim.Puts() > puts }, 5*time.Second, 10*time.Millisecond)
+}
+
+func testDHTService(t *testing.T) *service.DHTService {
 	defaultConfig := config.GetDefaultConfig()
 
 	db, err := storage.NewStorage(defaultConfig.ServerConfig.StorageURI)
@@ -173,9 +1082,9 @@ func testDHTService(t *testing.T) service.DHTService {
 	dht := dht.NewTestDHT(t)
 	dhtService, err := service.NewDHTService(&defaultConfig, db, dht)
 	require.NoError(t, err)
-	require.NotEmpty(t, dhtService)
+	require.NotNil(t, dhtService)
 
-	return *dhtService
+	return dhtService
 }
 
 func generateDIDPutRequest(t *testing.T) (string, []byte) {
@@ -197,3 +1106,28 @@ func generateDIDPutRequest(t *testing.T) (string, []byte) {
 	binary.BigEndian.PutUint64(seqBuf[:], uint64(bep44Put.Seq))
 	return doc.ID, append(bep44Put.Sig[:], append(seqBuf[:], bep44Put.V.([]byte)...)...)
 }
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.Wrap(dht.ErrNotFound, "failed to get key"), http.StatusNotFound},
+		{dht.ErrBadSignature, http.StatusBadRequest},
+		{errors.Wrap(dht.ErrValueTooLarge, "invalid put"), http.StatusBadRequest},
+		{errors.Wrap(dht.ErrInvalidTypes, "type 8 is not registered"), http.StatusBadRequest},
+		{errors.Wrap(dht.ErrTooManyRecords, "2 _prv._did. records"), http.StatusBadRequest},
+		{&dht.DocumentError{ID: "did:dht:id", Fields: []dht.FieldError{{Field: "verificationMethod", Message: "is missing the identity key"}}}, http.StatusBadRequest},
+		{&dht.RotationError{ID: "did:dht:id", Fields: []dht.FieldError{{Field: "verificationMethod[1].id", Message: "moves the key of #k1 to #k2; keep the key's id"}}}, http.StatusBadRequest},
+		{&dht.SeqConflictError{ID: "id", Expected: 1, Current: 2}, http.StatusConflict},
+		{errors.Wrap(dht.ErrAbusive, "record id scored 2.00"), http.StatusForbidden},
+		{errors.Wrap(dht.ErrDenied, "not serving record id"), http.StatusForbidden},
+		{errors.Wrap(dht.ErrAllPutsFailed, "failed to put key"), http.StatusBadGateway},
+		{errors.Wrap(dht.ErrStalled, "failed to get key"), http.StatusGatewayTimeout},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{errors.New("db unavailable"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, errorStatus(tt.err), tt.err.Error())
+	}
+}
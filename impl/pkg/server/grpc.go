@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
+	"github.com/TBD54566975/did-dht/pkg/retention"
+	"github.com/TBD54566975/did-dht/pkg/rpc"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// RetryAfterMetadataKey is the header metadata key giving the seconds a rate limited call should wait to be retried
+const RetryAfterMetadataKey = "retry-after"
+
+// rateLimitedMethods are the HTTP methods of the requests each rate limited gRPC method mirrors, whose buckets the
+// calls share
+var rateLimitedMethods = map[string]string{
+	rpc.Gateway_Resolve_FullMethodName:  http.MethodGet,
+	rpc.Gateway_Publish_FullMethodName:  http.MethodPut,
+	rpc.Gateway_WatchDID_FullMethodName: http.MethodGet,
+}
+
+// GatewayServer serves the gRPC API, publishing and resolving records through the same service as the HTTP API
+type GatewayServer struct {
+	rpc.UnimplementedGatewayServer
+
+	service    *service.DHTService
+	challenger *retention.Challenger
+}
+
+// NewGatewayServer returns a gRPC gateway publishing and resolving records through the given service. Publishes must
+// carry solutions to the challenger's retention challenges, if it isn't nil.
+func NewGatewayServer(service *service.DHTService, challenger *retention.Challenger) *GatewayServer {
+	return &GatewayServer{service: service, challenger: challenger}
+}
+
+// NewGRPCServer returns a gRPC server serving the gateway, over TLS with the given config if it isn't nil. Calls are
+// checked against the service's access lists and rate limited by the limiter, if any, in the same buckets as the HTTP
+// API, by the address of the client's connection.
+func NewGRPCServer(tlsCfg *tls.Config, service *service.DHTService, limiter *rateLimiter, challenger *retention.Challenger) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	// clients the access lists refuse are turned away before they're rate limited
+	unary := []grpc.UnaryServerInterceptor{accessUnaryInterceptor(service.Access())}
+	stream := []grpc.StreamServerInterceptor{accessStreamInterceptor(service.Access())}
+	if limiter != nil {
+		unary = append(unary, limiter.unaryInterceptor())
+		stream = append(stream, limiter.streamInterceptor())
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	server := grpc.NewServer(opts...)
+	rpc.RegisterGatewayServer(server, NewGatewayServer(service, challenger))
+	return server, nil
+}
+
+// Resolve gets the current record with the given ID
+func (s *GatewayServer) Resolve(ctx context.Context, req *rpc.ResolveRequest) (*rpc.ResolveResponse, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTGRPC.Resolve")
+	defer span.End()
+
+	id, err := grpcRecordID(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record id %s: %s", req.GetId(), err)
+	}
+	resp, err := s.service.GetDHT(ctx, id)
+	if err != nil {
+		if errors.Is(err, service.SpamError) {
+			return nil, status.Errorf(codes.ResourceExhausted, "too many requests for bad key %s", id)
+		}
+		return nil, grpcError(ctx, err, fmt.Sprintf("failed to get dht record: %s", id))
+	}
+	if resp == nil {
+		return nil, status.Errorf(codes.NotFound, "dht record not found: %s", id)
+	}
+	return &rpc.ResolveResponse{Record: &rpc.Record{V: resp.V, Seq: resp.Seq, Sig: resp.Sig[:]}}, nil
+}
+
+// Publish stores a record and puts it to the DHT
+func (s *GatewayServer) Publish(ctx context.Context, req *rpc.PublishRequest) (*rpc.PublishResponse, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTGRPC.Publish")
+	defer span.End()
+
+	id, err := grpcRecordID(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record id %s: %s", req.GetId(), err)
+	}
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record id %s: %s", id, err)
+	}
+	if req.GetRecord() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing record for id: %s", id)
+	}
+	if s.challenger != nil {
+		if err = s.challenger.Verify(did.Prefix+":"+id, req.GetRetentionSolution()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	record := req.GetRecord()
+	request, err := dht.NewSaltedBEP44Record(key, record.GetV(), record.GetSig(), salt, record.GetSeq())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error parsing request: %s", err)
+	}
+	if expected := req.GetExpectedSeq(); expected != nil {
+		err = s.service.PublishDHTIfSeq(ctx, id, *request, expected.GetValue())
+	} else {
+		err = s.service.PublishDHT(ctx, id, *request)
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, fmt.Sprintf("failed to publish dht record: %s", id))
+	}
+	return &rpc.PublishResponse{}, nil
+}
+
+// ListTypes lists a page of the DIDs stored by the gateway that are indexed under a type
+func (s *GatewayServer) ListTypes(ctx context.Context, req *rpc.ListTypesRequest) (*rpc.ListTypesResponse, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTGRPC.ListTypes")
+	defer span.End()
+
+	if req.GetType() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid type: %d", req.GetType())
+	}
+	if !did.TypeIndex(req.GetType()).IsRegistered() {
+		return nil, status.Errorf(codes.NotFound, "type %d is not registered", req.GetType())
+	}
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultTypePageSize
+	}
+	if pageSize < 1 || pageSize > maxTypePageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid page size %d: must be between 1 and %d", pageSize, maxTypePageSize)
+	}
+	query := dht.TypeQuery{Type: int(req.GetType())}
+	switch req.GetOrder() {
+	case rpc.TypeOrder_TYPE_ORDER_STORED:
+		query.Order = dht.OrderStored
+	case rpc.TypeOrder_TYPE_ORDER_UPDATED:
+		query.Order = dht.OrderUpdated
+	case rpc.TypeOrder_TYPE_ORDER_UPDATED_DESC:
+		query.Order = dht.OrderUpdatedDesc
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid order: %s", req.GetOrder())
+	}
+	if req.GetUpdatedSince() != nil {
+		if err := req.GetUpdatedSince().CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid updated since: %s", err)
+		}
+		query.UpdatedSince = req.GetUpdatedSince().AsTime()
+	}
+
+	dids, nextPageToken, err := s.service.ListDIDsByType(ctx, query, req.GetPageToken(), pageSize)
+	if errors.Is(err, dht.ErrInvalidPageToken) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid page token for order %s", req.GetOrder())
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, fmt.Sprintf("failed to list dids of type: %d", req.GetType()))
+	}
+	return &rpc.ListTypesResponse{Dids: dids, NextPageToken: nextPageToken}, nil
+}
+
+// WatchDID streams an event for each new version of a DID the gateway sees, until the call or the subscription ends.
+// Headers are sent once the DID is watched.
+func (s *GatewayServer) WatchDID(req *rpc.WatchDIDRequest, stream grpc.ServerStreamingServer[rpc.Event]) error {
+	ctx, span := telemetry.GetTracer().Start(stream.Context(), "DHTGRPC.WatchDID")
+	defer span.End()
+
+	id, err := didSuffix(req.GetDid())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid did %s: %s", req.GetDid(), err)
+	}
+	sub, err := s.service.Subscribe(ctx, pubsub.Filter{IDs: []string{id}})
+	if err != nil {
+		return grpcError(ctx, err, fmt.Sprintf("failed to subscribe to did: %s", id))
+	}
+	defer sub.Close()
+	// headers are sent once the subscription is live, so the client knows no later version will be missed
+	if err = stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			types := make([]int32, 0, len(event.Types))
+			for _, typ := range event.Types {
+				types = append(types, int32(typ))
+			}
+			if err = stream.Send(&rpc.Event{
+				Id:     event.ID,
+				Seq:    event.Seq,
+				Types:  types,
+				Source: event.Source,
+				Time:   timestamppb.New(event.Time),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcRecordID returns the record ID of a record ID or did:dht DID, checking the key, and salt if present, are valid
+func grpcRecordID(id string) (string, error) {
+	id = strings.TrimPrefix(id, did.Prefix+":")
+	if _, _, err := dht.ParseRecordID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// grpcError logs an error from a DHT operation and returns it with the gRPC code matching its HTTP status
+func grpcError(ctx context.Context, err error, msg string) error {
+	err = errors.Wrap(err, msg)
+	logrus.WithContext(ctx).WithError(err).Error()
+
+	// a conflicting conditional publish is retried after resolving the newer version, unlike a publish for a
+	// deactivated DID
+	if errors.Is(err, dht.ErrSeqConflict) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	code := codes.Internal
+	switch errorStatus(err) {
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	case http.StatusBadGateway:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+// unaryInterceptor rate limits the unary calls mirroring rate limited HTTP requests
+func (l *rateLimiter) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if retryAfter := l.allowCall(ctx, info.FullMethod, req); retryAfter > 0 {
+			_ = grpc.SetHeader(ctx, retryAfterMetadata(retryAfter))
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// streamInterceptor rate limits the streaming calls mirroring rate limited HTTP requests, once their request is
+// received
+func (l *rateLimiter) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &rateLimitedStream{ServerStream: ss, limiter: l, method: info.FullMethod})
+	}
+}
+
+// rateLimitedStream rate limits the requests received on a stream
+type rateLimitedStream struct {
+	grpc.ServerStream
+	limiter *rateLimiter
+	method  string
+}
+
+func (s *rateLimitedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if retryAfter := s.limiter.allowCall(s.Context(), s.method, m); retryAfter > 0 {
+		_ = s.SetHeader(retryAfterMetadata(retryAfter))
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return nil
+}
+
+// allowCall takes a token from the buckets of the client's address and of the DID of the request, if the method is
+// rate limited, returning how long to wait before retrying if either bucket is empty, and zero otherwise. API keys
+// aren't accepted over gRPC, so calls are always metered by address.
+func (l *rateLimiter) allowCall(ctx context.Context, fullMethod string, req any) time.Duration {
+	method, ok := rateLimitedMethods[fullMethod]
+	if !ok {
+		return 0
+	}
+	var id string
+	switch r := req.(type) {
+	case interface{ GetId() string }:
+		id = r.GetId()
+	case interface{ GetDid() string }:
+		id = r.GetDid()
+	}
+	return l.allow(ctx, method, peerIP(ctx), rateLimitKey(id), nil, method == http.MethodPut)
+}
+
+// peerIP returns the IP of the client's connection
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func retryAfterMetadata(retryAfter time.Duration) metadata.MD {
+	return metadata.Pairs(RetryAfterMetadataKey, strconv.Itoa(retryAfterSeconds(retryAfter)))
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
+	"github.com/TBD54566975/did-dht/pkg/ratelimit"
+	"github.com/TBD54566975/did-dht/pkg/rpc"
+	"github.com/TBD54566975/did-dht/pkg/service"
+)
+
+func TestGRPCGateway(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "grpc", config.PeeringConfig{})
+	client := newGRPCClient(t, svc, nil)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, []did.TypeIndex{did.LocalBusiness}, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := &rpc.Record{V: putMsg.V.([]byte), Seq: putMsg.Seq, Sig: putMsg.Sig[:]}
+
+	t.Run("publish and resolve", func(t *testing.T) {
+		_, err := client.Publish(ctx, &rpc.PublishRequest{Id: doc.ID, Record: record})
+		require.NoError(t, err)
+
+		for _, id := range []string{doc.ID, dht.RecordFromBEP44(putMsg).ID()} {
+			resp, err := client.Resolve(ctx, &rpc.ResolveRequest{Id: id})
+			require.NoError(t, err)
+			assert.Equal(t, record.GetV(), resp.GetRecord().GetV())
+			assert.Equal(t, record.GetSeq(), resp.GetRecord().GetSeq())
+			assert.Equal(t, record.GetSig(), resp.GetRecord().GetSig())
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := client.Resolve(ctx, &rpc.ResolveRequest{Id: "invalid"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, otherDoc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		_, err = client.Resolve(ctx, &rpc.ResolveRequest{Id: otherDoc.ID})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = client.Publish(ctx, &rpc.PublishRequest{Id: doc.ID})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		badSig := &rpc.Record{V: record.GetV(), Seq: record.GetSeq() + 1, Sig: record.GetSig()}
+		_, err = client.Publish(ctx, &rpc.PublishRequest{Id: doc.ID, Record: badSig})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		next := *putMsg
+		next.Seq++
+		next.Sign(sk)
+		_, err = client.Publish(ctx, &rpc.PublishRequest{
+			Id:          doc.ID,
+			Record:      &rpc.Record{V: next.V.([]byte), Seq: next.Seq, Sig: next.Sig[:]},
+			ExpectedSeq: wrapperspb.Int64(putMsg.Seq - 1),
+		})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+	})
+
+	t.Run("list types", func(t *testing.T) {
+		resp, err := client.ListTypes(ctx, &rpc.ListTypesRequest{Type: int32(did.LocalBusiness)})
+		require.NoError(t, err)
+		assert.Contains(t, resp.GetDids(), doc.ID)
+		assert.Empty(t, resp.GetNextPageToken())
+
+		_, err = client.ListTypes(ctx, &rpc.ListTypesRequest{Type: 9999})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, err = client.ListTypes(ctx, &rpc.ListTypesRequest{Type: int32(did.LocalBusiness), PageSize: maxTypePageSize + 1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("watch did", func(t *testing.T) {
+		watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		stream, err := client.WatchDID(watchCtx, &rpc.WatchDIDRequest{Did: doc.ID})
+		require.NoError(t, err)
+		// headers are sent once the subscription is live
+		_, err = stream.Header()
+		require.NoError(t, err)
+
+		next := *putMsg
+		next.Seq++
+		next.Sign(sk)
+		_, err = client.Publish(ctx, &rpc.PublishRequest{Id: doc.ID, Record: &rpc.Record{V: next.V.([]byte), Seq: next.Seq, Sig: next.Sig[:]}})
+		require.NoError(t, err)
+
+		event, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, dht.RecordFromBEP44(putMsg).ID(), event.GetId())
+		assert.Equal(t, next.Seq, event.GetSeq())
+		assert.Equal(t, []int32{int32(did.LocalBusiness)}, event.GetTypes())
+		assert.Equal(t, pubsub.SourcePublish, event.GetSource())
+
+		stream, err = client.WatchDID(ctx, &rpc.WatchDIDRequest{Did: "did:example:123"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "grpc-rate-limit", config.PeeringConfig{})
+	client := newGRPCClient(t, svc, newRateLimiter(ratelimit.NewMemory(), config.RateLimitConfig{
+		IP: config.RateLimit{RequestsPerSecond: 0.1, Burst: 1},
+	}))
+	ctx := context.Background()
+
+	_, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	_, err = client.Resolve(ctx, &rpc.ResolveRequest{Id: doc.ID})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	var header metadata.MD
+	_, err = client.Resolve(ctx, &rpc.ResolveRequest{Id: doc.ID}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"10"}, header.Get(RetryAfterMetadataKey))
+
+	// watching shares the bucket of resolving
+	stream, err := client.WatchDID(ctx, &rpc.WatchDIDRequest{Did: doc.ID})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// listing types isn't rate limited, as over HTTP
+	_, err = client.ListTypes(ctx, &rpc.ListTypesRequest{Type: int32(did.LocalBusiness)})
+	assert.NoError(t, err)
+}
+
+// newGRPCClient serves the gRPC API over an in-memory listener, returning a client connected to it
+func newGRPCClient(t *testing.T, svc *service.DHTService, limiter *rateLimiter) rpc.GatewayClient {
+	server, err := NewGRPCServer(nil, svc, limiter, nil)
+	require.NoError(t, err)
+	listener := bufconn.Listen(1 << 20)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return rpc.NewGatewayClient(conn)
+}
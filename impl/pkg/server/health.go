@@ -1,35 +1,157 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/service"
 	"github.com/TBD54566975/did-dht/pkg/telemetry"
 )
 
+const (
+	// defaultMaxRepublishLag is how far behind schedule the republisher may fall while the gateway is ready, unless
+	// configured otherwise
+	defaultMaxRepublishLag = 3 * time.Hour
+	// storageCheckTimeout is how long the readiness check waits to read from storage
+	storageCheckTimeout = 2 * time.Second
+)
+
+// HealthRouter is the router for the health endpoints
+type HealthRouter struct {
+	dht     *dht.DHT
+	service *service.DHTService
+	cfg     config.ReadinessConfig
+}
+
+// NewHealthRouter returns a new instance of HealthRouter for the given DHT and service, which are ready according to
+// the given config
+func NewHealthRouter(d *dht.DHT, svc *service.DHTService, cfg config.ReadinessConfig) *HealthRouter {
+	if cfg.MinRoutingTableNodes == 0 {
+		cfg.MinRoutingTableNodes = 1
+	}
+	if cfg.MaxRepublishLagSeconds == 0 {
+		cfg.MaxRepublishLagSeconds = int(defaultMaxRepublishLag.Seconds())
+	}
+	return &HealthRouter{dht: d, service: svc, cfg: cfg}
+}
+
 type GetHealthCheckResponse struct {
 	// Status is always equal to `OK`.
 	Status string `json:"status"`
+	// DHT is the most recent health of the DHT as observed by its prober.
+	DHT *dht.Health `json:"dht,omitempty"`
 }
 
 const (
-	HealthOK string = "OK"
+	HealthOK          string = "OK"
+	HealthUnavailable string = "UNAVAILABLE"
 )
 
+// Dependencies checked for readiness
+const (
+	DependencyStorage     = "storage"
+	DependencyDHT         = "dht"
+	DependencyRepublisher = "republisher"
+)
+
+// GetReadinessResponse is the status of the gateway's dependencies
+type GetReadinessResponse struct {
+	// Status is `OK` if every dependency is, or `UNAVAILABLE` otherwise
+	Status string `json:"status"`
+	// Dependencies are the statuses of storage, the DHT, and the republisher
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// DependencyStatus is whether a dependency of the gateway is healthy enough for it to serve traffic
+type DependencyStatus struct {
+	// Status is `OK` or `UNAVAILABLE`
+	Status string `json:"status"`
+	// Message describes the state of the dependency, such as how many nodes the routing table holds
+	Message string `json:"message,omitempty"`
+}
+
 // Health godoc
 //
 //	@Summary		Health Check
-//	@Description	Health is a simple handler that always responds with a 200 OK
+//	@Description	Health responds with a 200 OK along with the most recently observed health of the DHT, as long as the gateway is running.
 //	@Tags			Health
 //	@Accept			json
 //	@Produce		json
 //	@Success		200	{object}	GetHealthCheckResponse
 //	@Router			/health [get]
-func Health(c *gin.Context) {
+//	@Router			/health/live [get]
+func (r *HealthRouter) Health(c *gin.Context) {
 	_, span := telemetry.GetTracer().Start(c, "HealthHTTP.Health")
 	defer span.End()
 
 	status := GetHealthCheckResponse{Status: HealthOK}
+	if r.dht != nil {
+		health := r.dht.Health()
+		status.DHT = &health
+	}
 	Respond(c, status, http.StatusOK)
 }
+
+// Ready godoc
+//
+//	@Summary		Readiness Check
+//	@Description	Ready responds with a 200 OK if storage can be read from, the DHT's routing table holds enough nodes, and the republisher has scheduled the stored records and isn't behind, or with a 503 otherwise, along with the status of each.
+//	@Tags			Health
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	GetReadinessResponse
+//	@Failure		503	{object}	GetReadinessResponse
+//	@Router			/health/ready [get]
+func (r *HealthRouter) Ready(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "HealthHTTP.Ready")
+	defer span.End()
+
+	resp := GetReadinessResponse{Status: HealthOK, Dependencies: make(map[string]DependencyStatus)}
+	check := func(dependency string, err error, msg string) {
+		status := DependencyStatus{Status: HealthOK, Message: msg}
+		if err != nil {
+			status = DependencyStatus{Status: HealthUnavailable, Message: err.Error()}
+			resp.Status = HealthUnavailable
+		}
+		resp.Dependencies[dependency] = status
+	}
+
+	if r.service != nil {
+		storageCtx, cancel := context.WithTimeout(ctx, storageCheckTimeout)
+		check(DependencyStorage, r.service.CheckStorage(storageCtx), "")
+		cancel()
+
+		maxLag := time.Duration(r.cfg.MaxRepublishLagSeconds) * time.Second
+		var err error
+		msg := "republishing on schedule"
+		if !r.service.RepublishScheduleLoaded() {
+			err = errors.New("scheduling the stored records for republishing")
+		} else if lag := r.service.RepublishLag().Round(time.Second); lag > maxLag {
+			err = fmt.Errorf("republishing %s behind schedule, over the %s limit", lag, maxLag)
+		} else if lag > 0 {
+			msg = fmt.Sprintf("republishing %s behind schedule", lag)
+		}
+		check(DependencyRepublisher, err, msg)
+	}
+	if r.dht != nil {
+		var err error
+		nodes := r.dht.Health().RoutingTableNodes
+		if nodes < r.cfg.MinRoutingTableNodes {
+			err = fmt.Errorf("routing table holds %d nodes, fewer than the %d required", nodes, r.cfg.MinRoutingTableNodes)
+		}
+		check(DependencyDHT, err, fmt.Sprintf("routing table holds %d nodes", nodes))
+	}
+
+	statusCode := http.StatusOK
+	if resp.Status != HealthOK {
+		statusCode = http.StatusServiceUnavailable
+	}
+	Respond(c, resp, statusCode)
+}
@@ -1,16 +1,48 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"math"
 	"net/http"
 	"os"
+	"regexp"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/trace"
+
+	"github.com/TBD54566975/did-dht/internal/util"
 )
 
+// RequestIDHeader is the header carrying the ID that correlates the log entries of a request. An ID set by an
+// upstream proxy is kept, and requests without one are given a new ID; either way it's echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID matches the request IDs accepted from upstream: up to 128 printable ASCII characters, without spaces
+var validRequestID = regexp.MustCompile(`^[!-~]{1,128}$`)
+
+// requestID tags the request's context with its ID, so everything logged while serving it can be correlated
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Request = c.Request.WithContext(util.WithRequestID(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// newRequestID returns a random 128-bit request ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 // logger is the logrus logger handler amended for telemetry
 // inspired by https://github.com/toorop/gin-logrus
 func logger(logger logrus.FieldLogger, notLogged ...string) gin.HandlerFunc {
@@ -54,18 +86,19 @@ func logger(logger logrus.FieldLogger, notLogged ...string) gin.HandlerFunc {
 		}
 
 		entry := logger.WithFields(logrus.Fields{
-			"hostname":    hostname,
-			"status_code": statusCode,
-			"latency":     latency,
-			"client_ip":   clientIP,
-			"method":      c.Request.Method,
-			"path":        path,
-			"referer":     referer,
-			"data_length": dataLength,
-			"user_agent":  clientUserAgent,
-			"time":        time.Now().Format(time.RFC3339),
-			"trace_id":    traceID,
-			"span_id":     spanID,
+			"hostname":          hostname,
+			"status_code":       statusCode,
+			"latency":           latency,
+			"client_ip":         clientIP,
+			"method":            c.Request.Method,
+			"path":              path,
+			"referer":           referer,
+			"data_length":       dataLength,
+			"user_agent":        clientUserAgent,
+			"time":              time.Now().Format(time.RFC3339),
+			"trace_id":          traceID,
+			"span_id":           spanID,
+			util.RequestIDField: util.RequestID(c.Request.Context()),
 		})
 
 		if len(c.Errors) > 0 {
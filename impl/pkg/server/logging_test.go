@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/util"
+)
+
+func TestRequestID(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	handler := gin.New()
+	handler.ContextWithFallback = true
+	handler.Use(requestID(), logger(log))
+	handler.GET("/", func(c *gin.Context) {
+		// the service sees the request id through the gin context
+		c.String(http.StatusOK, util.RequestID(c))
+	})
+
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, w.Body.String(), w.Header().Get(RequestIDHeader))
+		return w
+	}
+
+	t.Run("from upstream", func(t *testing.T) {
+		w := get("upstream-id-1")
+		assert.Equal(t, "upstream-id-1", w.Body.String())
+		require.NotNil(t, hook.LastEntry())
+		assert.Equal(t, "upstream-id-1", hook.LastEntry().Data[util.RequestIDField])
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		first, second := get("").Body.String(), get("").Body.String()
+		assert.Len(t, first, 32)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("invalid ids are replaced", func(t *testing.T) {
+		for _, id := range []string{"has space", strings.Repeat("a", 129)} {
+			w := get(id)
+			assert.NotEqual(t, id, w.Body.String())
+			assert.Len(t, w.Body.String(), 32)
+		}
+	})
+}
+
+func TestRequestIDHook(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	log.AddHook(&util.RequestIDHook{})
+	ctx := util.WithRequestID(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "abc")
+
+	log.WithContext(ctx).Info("served")
+	assert.Equal(t, "abc", hook.LastEntry().Data[util.RequestIDField])
+	log.Info("not serving a request")
+	assert.NotContains(t, hook.LastEntry().Data, util.RequestIDField)
+}
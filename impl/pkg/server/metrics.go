@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// Metrics godoc
+//
+//	@Summary		Scrape the gateway's metrics
+//	@Description	Metrics returns the gateway's metrics in the Prometheus text exposition format: resolution latency by source, publishes by result, DHT nodes contacted per traversal, republish batch durations, storage query latency, and stored record counts
+//	@Tags			Debug
+//	@Produce		plain
+//	@Success		200	{string}	string	"Metrics in the Prometheus text exposition format"
+//	@Failure		503	{string}	string	"Metrics aren't collected"
+//	@Router			/metrics [get]
+func Metrics(c *gin.Context) {
+	telemetry.PrometheusHandler().ServeHTTP(c.Writer, c.Request)
+}
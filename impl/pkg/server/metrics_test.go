@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, telemetry.SetupTelemetry(ctx, config.Config{ServerConfig: config.ServerConfig{MetricsEndpoint: true}}))
+	t.Cleanup(func() { telemetry.Shutdown(ctx) })
+
+	svc, _ := simulatedDHTService(t, "metrics", config.PeeringConfig{})
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(putMsg)
+	require.NoError(t, svc.PublishDHT(ctx, record.ID(), record))
+	_, err = svc.GetDHT(ctx, record.ID())
+	require.NoError(t, err)
+
+	handler := gin.New()
+	handler.GET("/metrics", Metrics)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, telemetry.PrometheusContentType, w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, `dht_publishes_total{result="success",source="publish"} 1`)
+	assert.Contains(t, body, `dht_resolution_duration_seconds_count{source="cache"} 1`)
+	assert.Contains(t, body, "storage_records 1")
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/peering"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// authenticatedPeerKey marks the context of a request made by a peer gateway with the peering secret
+const authenticatedPeerKey = "authenticated_peer"
+
+// PeeringAPI sets up the route peer gateways send records to. It's closed to the clients the access lists refuse, and
+// to every caller without the peering secret, and rate limited by the given middleware, if any, as a publishing route.
+func PeeringAPI(rg *gin.RouterGroup, service *service.DHTService, rateLimit gin.HandlerFunc, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("peering requires a %s shared with the peer gateways", config.PeeringSecret)
+	}
+	handlers := gin.HandlersChain{CheckClientAccess(service.Access()), PeeringAuth(secret)}
+	if rateLimit != nil {
+		handlers = append(handlers, meterAsPublish, rateLimit)
+	}
+	rg.POST(peering.RecordsPath, append(handlers, NewPeeringRouter(service).Records)...)
+	return nil
+}
+
+// PeeringAuth admits requests carrying the given peering secret as a bearer token, marking them as made by a peer
+func PeeringAuth(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || secret == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(secret)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="peering"`)
+			LoggingRespondErrMsg(c, "peering secret required", http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+		c.Set(authenticatedPeerKey, true)
+		c.Next()
+	}
+}
+
+// PeeringRouter is the router for records sent by peer gateways
+type PeeringRouter struct {
+	service *service.DHTService
+}
+
+// NewPeeringRouter returns a new instance of PeeringRouter for the given service
+func NewPeeringRouter(service *service.DHTService) *PeeringRouter {
+	return &PeeringRouter{service: service}
+}
+
+// Records godoc
+//
+//	@Summary		Receive records from a peer gateway
+//	@Description	Records stores and publishes a batch of records sent by a peer gateway. Each record's signature is
+//	@Description	verified, and records that fail verification are counted as rejected rather than failing the batch.
+//	@Description	Only served to peers, which authenticate with the peering secret.
+//	@Tags			Peering
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		peering.Batch	true	"Records published to the peer"
+//	@Success		200		{object}	peering.BatchResult
+//	@Failure		400		{string}	string	"Bad request"
+//	@Failure		401		{string}	string	"Peering secret required"
+//	@Failure		403		{string}	string	"Client is denied by the gateway"
+//	@Failure		413		{string}	string	"Request body too large"
+//	@Failure		429		{string}	string	"Too many requests"
+//	@Security		PeeringSecret
+//	@Router			/peering/records [post]
+func (r *PeeringRouter) Records(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "PeeringHTTP.Records")
+	defer span.End()
+
+	var batch peering.Batch
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, peering.MaxBatchBytes)
+	if err := c.ShouldBindJSON(&batch); err != nil {
+		if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+			LoggingRespondErrMsg(c, fmt.Sprintf("batch is over the limit of %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		LoggingRespondErrWithMsg(c, err, "invalid peering batch", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Records) > peering.MaxBatchSize {
+		LoggingRespondErrMsg(c, fmt.Sprintf("batch of %d records is over the limit of %d", len(batch.Records), peering.MaxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	var result peering.BatchResult
+	for _, record := range batch.Records {
+		if err := r.service.PublishPeerDHT(ctx, record); err != nil {
+			logrus.WithContext(ctx).WithError(err).WithField("record_id", record.ID()).Warn("rejected record from peer")
+			result.Rejected++
+			continue
+		}
+		result.Accepted++
+	}
+	Respond(c, result, http.StatusOK)
+}
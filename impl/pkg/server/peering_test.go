@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/peering"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+func TestPeering(t *testing.T) {
+	// gateway b serves the peering api, and gateway a sends it every record published to a
+	t.Setenv(config.PeeringSecret.String(), "peering-secret")
+	b, bDHT := simulatedDHTService(t, "peer-b", config.PeeringConfig{})
+	handler := gin.New()
+	require.Error(t, PeeringAPI(&handler.RouterGroup, b, nil, ""))
+	require.NoError(t, PeeringAPI(&handler.RouterGroup, b, nil, "peering-secret"))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	// post sends a batch to gateway b with the given peering secret, if any
+	post := func(t *testing.T, body []byte, secret string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+peering.RecordsPath, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	a, _ := simulatedDHTService(t, "peer-a", config.PeeringConfig{Peers: []string{srv.URL}, FlushIntervalMillis: 10})
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(putMsg)
+
+	require.NoError(t, a.PublishDHT(context.Background(), record.ID(), record))
+	assert.Eventually(t, func() bool {
+		_, err := bDHT.GetFull(context.Background(), record.ID())
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	got, err := b.GetDHT(context.Background(), record.ID())
+	require.NoError(t, err)
+	assert.Equal(t, putMsg.Seq, got.Seq)
+	assert.Equal(t, putMsg.Sig, got.Sig)
+
+	t.Run("invalid records are rejected", func(t *testing.T) {
+		bad := record
+		bad.Signature[0] ^= 0xff
+		body, err := json.Marshal(peering.Batch{Records: []dht.BEP44Record{bad, record}})
+		require.NoError(t, err)
+		resp := post(t, body, "peering-secret")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result peering.BatchResult
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		assert.Equal(t, peering.BatchResult{Accepted: 1, Rejected: 1}, result)
+	})
+
+	t.Run("batches without the peering secret are refused", func(t *testing.T) {
+		body, err := json.Marshal(peering.Batch{Records: []dht.BEP44Record{record}})
+		require.NoError(t, err)
+		for _, secret := range []string{"", "wrong-secret"} {
+			resp := post(t, body, secret)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("oversized batches are refused", func(t *testing.T) {
+		body := append([]byte(`{"records": [`), bytes.Repeat([]byte(" "), peering.MaxBatchBytes)...)
+		resp := post(t, append(body, "]}"...), "peering-secret")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+}
+
+func simulatedDHTService(t *testing.T, id string, peers config.PeeringConfig) (*service.DHTService, *dht.Simulator) {
+	cfg := config.GetDefaultConfig()
+	cfg.PeeringConfig = peers
+
+	db, err := storage.NewStorage(fmt.Sprintf("bolt://diddht-test-%s.db", id))
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove(fmt.Sprintf("diddht-test-%s.db", id)) })
+
+	sim := dht.NewSimulator()
+	svc, err := service.NewDHTService(&cfg, db, sim)
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+	return svc, sim
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// PkarrPayloadContentType is the media type of the sig, seq, and v body a Pkarr relay serves records with
+// https://pkarr.org/relays
+const PkarrPayloadContentType = "application/pkarr.org/relays#payload"
+
+// pkarrLastModified returns the Last-Modified time a Pkarr relay serves a record with. Pkarr clients sign records
+// with the time in microseconds as their sequence number, which an HTTP date holds to the second.
+func pkarrLastModified(seq int64) time.Time {
+	return time.UnixMicro(seq).UTC().Truncate(time.Second)
+}
+
+// respondPkarrPayload responds with a record's sig, seq, and v as a Pkarr relay does, or with 304 Not Modified if
+// the record wasn't modified after the request's If-Modified-Since
+func respondPkarrPayload(c *gin.Context, seq int64, payload []byte) {
+	lastModified := pkarrLastModified(seq)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, PkarrPayloadContentType, payload)
+}
+
+// putPkarrRecord publishes a record put by a Pkarr client, responding as a Pkarr relay does. A put is rejected if
+// the gateway has, or finds on the DHT, a newer version of the record: with 409 Conflict if that version is newer
+// than the record put, and with 412 Precondition Failed if it was only modified after the request's
+// If-Unmodified-Since. A put made while another put of the record is being checked is rejected with 428
+// Precondition Required, for the client to retry with If-Unmodified-Since.
+func (r *DHTRouter) putPkarrRecord(ctx context.Context, c *gin.Context, id string, record dht.BEP44Record) {
+	expectedSeq := record.SequenceNumber
+	if header := c.GetHeader("If-Unmodified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			respondPutError(c, errors.Wrapf(err, "invalid If-Unmodified-Since header: %s", header), http.StatusBadRequest)
+			return
+		}
+		// a version modified within the second of the header was not modified since
+		expectedSeq = min(expectedSeq, since.Add(time.Second).UnixMicro()-1)
+	}
+
+	err := r.service.PublishDHTIfSeq(ctx, id, record, expectedSeq)
+	var seqErr *dht.SeqConflictError
+	switch {
+	case err == nil:
+		ResponseStatus(c, http.StatusNoContent)
+	case errors.As(err, &seqErr) && seqErr.Current > record.SequenceNumber:
+		respondPutError(c, errors.Wrapf(err, "record %s has a newer version", id), http.StatusConflict)
+	case errors.As(err, &seqErr):
+		respondPutError(c, errors.Wrapf(err, "record %s was modified since %s", id, c.GetHeader("If-Unmodified-Since")), http.StatusPreconditionFailed)
+	case errors.Is(err, dht.ErrSeqConflict):
+		respondPutError(c, errors.Wrapf(err, "record %s is being put", id), http.StatusPreconditionRequired)
+	default:
+		respondPutError(c, errors.Wrapf(err, "failed to publish dht record: %s", id), errorStatus(err))
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/retention"
+)
+
+func TestPkarrRelay(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "pkarr-relay", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, true, nil, ""))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// a Pkarr packet, which isn't a DID, signed with the time in microseconds as its sequence number
+	_, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Answer = []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: "_foo.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+		Txt: []string{"bar"},
+	}}
+	bep44Put, err := dht.CreateDNSPublishRequest(sk, *msg)
+	require.NoError(t, err)
+	id := dht.RecordID(sk.Public().(ed25519.PublicKey), nil)
+	first := time.Date(2024, 6, 1, 12, 0, 0, 500000000, time.UTC)
+
+	payload := func(at time.Time) []byte {
+		next := *bep44Put
+		next.Seq = at.UnixMicro()
+		next.Sign(sk)
+		return append(next.Sig[:], append(binary.BigEndian.AppendUint64(nil, uint64(next.Seq)), next.V.([]byte)...)...)
+	}
+	put := func(at time.Time, unmodifiedSince string) int {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/"+id, bytes.NewReader(payload(at)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", PkarrPayloadContentType)
+		if unmodifiedSince != "" {
+			req.Header.Set("If-Unmodified-Since", unmodifiedSince)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	get := func(modifiedSince string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/"+id, nil)
+		require.NoError(t, err)
+		if modifiedSince != "" {
+			req.Header.Set("If-Modified-Since", modifiedSince)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body
+	}
+
+	require.Equal(t, http.StatusNoContent, put(first, ""))
+
+	resp, body := get("")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, PkarrPayloadContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Sat, 01 Jun 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+	assert.Equal(t, payload(first), body)
+
+	resp, _ = get("Sat, 01 Jun 2024 12:00:00 GMT")
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp, _ = get("Sat, 01 Jun 2024 11:59:59 GMT")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	second := first.Add(time.Minute)
+	assert.Equal(t, http.StatusNoContent, put(second, "Sat, 01 Jun 2024 12:00:00 GMT"))
+
+	// an older version conflicts, and a client that last saw the first version fails its compare and swap
+	assert.Equal(t, http.StatusConflict, put(first.Add(time.Second), ""))
+	assert.Equal(t, http.StatusPreconditionFailed, put(second.Add(time.Minute), "Sat, 01 Jun 2024 12:00:00 GMT"))
+	assert.Equal(t, http.StatusBadRequest, put(second.Add(time.Minute), "yesterday"))
+	assert.Equal(t, http.StatusNoContent, put(second.Add(time.Minute), "Sat, 01 Jun 2024 12:01:00 GMT"))
+
+	challenger, err := retention.NewChallenger(nil, 26, time.Minute)
+	require.NoError(t, err)
+	assert.Error(t, DHTAPI(&gin.New().RouterGroup, svc, nil, challenger, 0, true, nil, ""))
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/ratelimit"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+const (
+	// APIKeyHeader is the header clients present the token of their API key in
+	APIKeyHeader = "X-API-Key"
+	// publishingKey marks the context of a request to a route publishing records other than by PUT
+	publishingKey = "publishing"
+	// secondsPerDay is how many seconds a day's publish quota refills over
+	secondsPerDay = 24 * 60 * 60
+)
+
+// RateLimit returns middleware metering requests into the limiter's buckets for the client IP and for the DID in
+// the route's id or did parameter, if any, with separate buckets for each request method. Requests over either
+// limit are rejected with a 429 and a Retry-After header. Requests are let through when the limiter fails, so an
+// outage of shared buckets doesn't take the gateway down with it.
+func RateLimit(limiter ratelimit.Limiter, cfg config.RateLimitConfig) gin.HandlerFunc {
+	return newRateLimiter(limiter, cfg).middleware()
+}
+
+// rateLimiter meters requests into a limiter's buckets for their client IP, or API key, and DID
+type rateLimiter struct {
+	limiter  ratelimit.Limiter
+	ipLimit  ratelimit.Limit
+	didLimit ratelimit.Limit
+	limited  metric.Int64Counter
+	// apiKeys authenticates the API keys of requests, or is nil if API keys aren't accepted
+	apiKeys apiKeyAuthenticator
+	// requireAPIKey rejects the requests made without an API key
+	requireAPIKey bool
+}
+
+// apiKeyAuthenticator returns the API key a token was minted for, or service.ErrInvalidAPIKey if there is none
+type apiKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, token string) (*dht.APIKey, error)
+}
+
+func newRateLimiter(limiter ratelimit.Limiter, cfg config.RateLimitConfig) *rateLimiter {
+	limited, err := telemetry.GetMeter().Int64Counter("server.rate_limited_requests",
+		metric.WithDescription("requests rejected for exceeding a rate limit"))
+	if err != nil {
+		logrus.WithError(err).Error("failed to create rate limited requests counter")
+		limited = noop.Int64Counter{}
+	}
+	return &rateLimiter{
+		limiter:  limiter,
+		ipLimit:  ratelimit.Limit{PerSecond: cfg.IP.RequestsPerSecond, Burst: cfg.IP.Burst},
+		didLimit: ratelimit.Limit{PerSecond: cfg.DID.RequestsPerSecond, Burst: cfg.DID.Burst},
+		limited:  limited,
+	}
+}
+
+// meterAsPublish marks requests to a route publishing records, so they're held to their API key's publish quota like
+// PUTs. It goes in front of the rate limit.
+func meterAsPublish(c *gin.Context) {
+	c.Set(publishingKey, true)
+	c.Next()
+}
+
+func (l *rateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := l.apiKey(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+		publishing := c.Request.Method == http.MethodPut || c.GetBool(publishingKey)
+		if retryAfter := l.allow(c, c.Request.Method, c.ClientIP(), rateLimitedDID(c), key, publishing); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+			// rejections aren't logged as errors, so abusive clients can't flood the logs
+			Respond(c, errors.New("rate limit exceeded"), http.StatusTooManyRequests)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// apiKey returns the API key a request is made with, or nil if it has none or API keys aren't accepted, responding
+// with an error if the key is invalid, or missing while required. Peer gateways, authenticated by the peering secret,
+// aren't required to have one.
+func (l *rateLimiter) apiKey(c *gin.Context) (*dht.APIKey, bool) {
+	if l.apiKeys == nil {
+		return nil, true
+	}
+	token := c.GetHeader(APIKeyHeader)
+	if token == "" {
+		if !l.requireAPIKey || c.GetBool(authenticatedPeerKey) {
+			return nil, true
+		}
+		Respond(c, errors.Errorf("an api key is required in the %s header", APIKeyHeader), http.StatusUnauthorized)
+		return nil, false
+	}
+	key, err := l.apiKeys.AuthenticateAPIKey(c, token)
+	switch {
+	case errors.Is(err, service.ErrInvalidAPIKey):
+		Respond(c, err, http.StatusUnauthorized)
+	case err != nil:
+		LoggingRespondErrWithMsg(c, err, "failed to authenticate api key", http.StatusInternalServerError)
+	default:
+		return key, true
+	}
+	return nil, false
+}
+
+// allow takes a token from the buckets of the API key, if any, or else of the client IP, and of the DID, if any, for
+// the request method, and for publishing requests made with an API key, from the key's publish quota, returning how
+// long to wait before retrying if a bucket is empty, and zero otherwise
+func (l *rateLimiter) allow(ctx context.Context, method, ip, didKey string, key *dht.APIKey, publishing bool) time.Duration {
+	buckets := []rateLimitBucket{{name: "ip", key: ip, limit: l.ipLimit}}
+	if key != nil {
+		buckets = []rateLimitBucket{{
+			name:  "key",
+			key:   key.ID,
+			limit: ratelimit.Limit{PerSecond: key.RequestsPerSecond, Burst: key.Burst},
+		}}
+	}
+	if didKey != "" {
+		buckets = append(buckets, rateLimitBucket{name: "did", key: didKey, limit: l.didLimit})
+	}
+	if key != nil && publishing && key.PublishesPerDay > 0 {
+		// publishes by every method take from the one quota
+		buckets = append(buckets, rateLimitBucket{
+			name:   "quota",
+			key:    key.ID,
+			method: http.MethodPut,
+			limit:  ratelimit.Limit{PerSecond: float64(key.PublishesPerDay) / secondsPerDay, Burst: key.PublishesPerDay},
+		})
+	}
+
+	for _, b := range buckets {
+		retryAfter, err := l.limiter.Allow(ctx, b.name+":"+cmp.Or(b.method, method)+":"+b.key, b.limit)
+		if err != nil {
+			logrus.WithContext(ctx).WithError(err).Warn("failed to check rate limit, allowing request")
+			continue
+		}
+		if retryAfter > 0 {
+			l.limited.Add(ctx, 1, metric.WithAttributes(attribute.String("bucket", b.name)))
+			return retryAfter
+		}
+	}
+	return 0
+}
+
+// rateLimitBucket is a bucket a request takes a token from
+type rateLimitBucket struct {
+	// name is the kind of bucket: ip, key, did, or quota
+	name string
+	key  string
+	// method is the request method the bucket meters, if not that of the request
+	method string
+	limit  ratelimit.Limit
+}
+
+// rateLimitedDID returns the z-base-32 encoded key of the DID a request is for, without any salt or DID URL path,
+// query, or fragment, or empty if the route has no DID
+func rateLimitedDID(c *gin.Context) string {
+	id := c.Param(IDParam)
+	if id == "" {
+		id = c.Param(DIDParam)
+	}
+	return rateLimitKey(id)
+}
+
+// rateLimitKey returns the z-base-32 encoded key of the DID a record ID, DID, or DID URL is for
+func rateLimitKey(id string) string {
+	id = strings.TrimPrefix(id, did.Prefix+":")
+	if i := strings.IndexAny(id, ".?#/"); i >= 0 {
+		id = id[:i]
+	}
+	return id
+}
+
+// retryAfterSeconds is the Retry-After of a rejected request, in whole seconds, at least one
+func retryAfterSeconds(retryAfter time.Duration) int {
+	return max(int(math.Ceil(retryAfter.Seconds())), 1)
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	handler := gin.New()
+	rateLimit := RateLimit(ratelimit.NewMemory(), config.RateLimitConfig{
+		IP:  config.RateLimit{RequestsPerSecond: 0.1, Burst: 3},
+		DID: config.RateLimit{RequestsPerSecond: 0.1, Burst: 2},
+	})
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	handler.GET("/:id", rateLimit, ok)
+	handler.PUT("/:id", rateLimit, ok)
+	handler.GET("/dids/:did", rateLimit, ok)
+
+	do := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	const key = "cyuoqaf7itop8ohww4yn5ojg13qaq83r9zihgqntc5i9zwrfdfoo"
+
+	// the did's bucket is shared by clients, and by its DID, salted records, and DID URLs
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/"+key, "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/dids/did:dht:"+key+"%230", "10.0.0.2").Code)
+	w := do(http.MethodGet, "/"+key+".c2FsdA", "10.0.0.3")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+
+	// publishes are metered separately from resolutions
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/"+key, "10.0.0.1").Code)
+
+	// each client ip has its own bucket
+	for i := range 3 {
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/did"+string(rune('a'+i)), "10.0.0.4").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/other", "10.0.0.4").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/other", "10.0.0.5").Code)
+}
+
+// failingLimiter fails every check
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, ratelimit.Limit) (time.Duration, error) {
+	return 0, errors.New("redis is down")
+}
+
+func (failingLimiter) Close() error { return nil }
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	handler := gin.New()
+	handler.GET("/:id", RateLimit(failingLimiter{}, config.RateLimitConfig{
+		IP: config.RateLimit{RequestsPerSecond: 1, Burst: 1},
+	}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/id", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestRateLimitTrustedProxies(t *testing.T) {
+	cfg := config.RateLimitConfig{IP: config.RateLimit{RequestsPerSecond: 0.1, Burst: 1}}
+
+	limiter, err := configuredRateLimiter(config.RateLimitConfig{}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, limiter)
+
+	// without trusted proxies, forwarded IPs are ignored
+	var handler *gin.Engine
+	newHandler := func(serverCfg config.ServerConfig, cfg config.RateLimitConfig) {
+		handler = gin.New()
+		require.NoError(t, trustProxies(handler, serverCfg, cfg))
+		limiter, err := configuredRateLimiter(cfg, nil)
+		require.NoError(t, err)
+		handler.GET("/:id", limiter.middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+	forwarded := func(header, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/id", nil)
+		req.RemoteAddr = "192.168.0.1:1234"
+		req.Header.Set(header, ip)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	newHandler(config.ServerConfig{}, cfg)
+	assert.Equal(t, http.StatusOK, forwarded("X-Forwarded-For", "10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, forwarded("X-Forwarded-For", "10.0.0.2"))
+
+	newHandler(config.ServerConfig{TrustedProxies: []string{"192.168.0.0/24"}}, cfg)
+	assert.Equal(t, http.StatusOK, forwarded("X-Forwarded-For", "10.0.0.1"))
+	assert.Equal(t, http.StatusOK, forwarded("X-Forwarded-For", "10.0.0.2"))
+	assert.Equal(t, http.StatusTooManyRequests, forwarded("X-Forwarded-For", "10.0.0.2"))
+
+	// the rate limit's trusted proxies are still trusted
+	deprecated := cfg
+	deprecated.TrustedProxies = []string{"192.168.0.0/24"}
+	newHandler(config.ServerConfig{}, deprecated)
+	assert.Equal(t, http.StatusOK, forwarded("X-Forwarded-For", "10.0.0.1"))
+	assert.Equal(t, http.StatusOK, forwarded("X-Forwarded-For", "10.0.0.2"))
+
+	// only the configured headers give the client IP
+	newHandler(config.ServerConfig{TrustedProxies: []string{"192.168.0.1"}, ClientIPHeaders: []string{"cf-connecting-ip"}}, cfg)
+	assert.Equal(t, http.StatusOK, forwarded("CF-Connecting-IP", "10.0.0.1"))
+	assert.Equal(t, http.StatusOK, forwarded("CF-Connecting-IP", "10.0.0.2"))
+	assert.Equal(t, http.StatusOK, forwarded("X-Forwarded-For", "10.0.0.3"))
+	assert.Equal(t, http.StatusTooManyRequests, forwarded("X-Forwarded-For", "10.0.0.4"))
+
+	err = trustProxies(gin.New(), config.ServerConfig{TrustedProxies: []string{"not an ip"}}, config.RateLimitConfig{})
+	assert.ErrorContains(t, err, "invalid trusted proxies")
+}
+
+func TestRateLimitAPIKeys(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "api-keys", config.PeeringConfig{})
+	cfg := config.RateLimitConfig{
+		IP:      config.RateLimit{RequestsPerSecond: 0.1, Burst: 1},
+		APIKeys: config.APIKeysConfig{Enabled: true},
+	}
+	handler := gin.New()
+	limiter, err := configuredRateLimiter(cfg, svc)
+	require.NoError(t, err)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	handler.GET("/:id", limiter.middleware(), ok)
+	handler.PUT("/:id", limiter.middleware(), ok)
+	handler.POST("/publish", meterAsPublish, limiter.middleware(), ok)
+	AdminAPI(handler.Group("/admin", AdminAuth("secret")), NewAdminRouter(svc, nil))
+
+	do := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req := httptest.NewRequest(method, path, reqBody)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Authorization", "Bearer secret")
+		if token != "" {
+			req.Header.Set(APIKeyHeader, token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/admin/keys", "", MintAPIKeyRequest{Name: "tier one", RequestsPerSecond: 0.1, Burst: 3, PublishesPerDay: 2})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var minted MintAPIKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &minted))
+	require.NotEmpty(t, minted.Token)
+	assert.Equal(t, "tier one", minted.Key.Name)
+
+	// without a key, the client ip's limit applies
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/a", "", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/b", "", nil).Code)
+
+	// with a key, the key's limit applies instead
+	for i := range 3 {
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/did"+string(rune('a'+i)), minted.Token, nil).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/other", minted.Token, nil).Code)
+
+	// publishes are held to the key's daily quota as well, whichever route they're made through
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/a", minted.Token, nil).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/publish", minted.Token, nil).Code)
+	w = do(http.MethodPut, "/c", minted.Token, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "43200", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/publish", minted.Token, nil).Code)
+
+	// invalid keys are rejected rather than falling back to the client ip's limit
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/d", minted.Key.ID+".wrong", nil).Code)
+
+	w = do(http.MethodGet, "/admin/keys", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), minted.Token)
+	var keys []dht.APIKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &keys))
+	require.Len(t, keys, 1)
+	assert.Equal(t, minted.Key.ID, keys[0].ID)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/admin/keys", "", MintAPIKeyRequest{Burst: -1}).Code)
+
+	// revoked keys are rejected
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/admin/keys/"+minted.Key.ID, "", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/e", minted.Token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/admin/keys/"+minted.Key.ID, "", nil).Code)
+
+	t.Run("required", func(t *testing.T) {
+		cfg.APIKeys.Required = true
+		limiter, err := configuredRateLimiter(cfg, svc)
+		require.NoError(t, err)
+		handler := gin.New()
+		handler.GET("/:id", limiter.middleware(), ok)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/id", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// peer gateways authenticate with the peering secret instead
+		handler.POST("/peer", PeeringAuth("peering-secret"), limiter.middleware(), ok)
+		req := httptest.NewRequest(http.MethodPost, "/peer", nil)
+		req.Header.Set("Authorization", "Bearer peering-secret")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		cfg.APIKeys.Enabled = false
+		_, err = configuredRateLimiter(cfg, svc)
+		assert.ErrorContains(t, err, "can't be required without being enabled")
+	})
+}
@@ -0,0 +1,487 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// States of a registrar job https://identity.foundation/did-registration/#didstatestate
+const (
+	registrarStateFinished = "finished"
+	registrarStateFailed   = "failed"
+	registrarStateAction   = "action"
+
+	// signPayloadAction asks the client to sign the payloads of the job's signing requests
+	signPayloadAction = "signPayload"
+	// identityKeySigningRequest names the signing request for the record's signature by the DID's identity key
+	identityKeySigningRequest = "signingRequest0"
+	// setDIDDocumentOperation replaces a DID's document, the only update operation supported
+	setDIDDocumentOperation = "setDidDocument"
+)
+
+// RegistrarRouter serves the DIF Universal Registrar driver API https://identity.foundation/did-registration, so
+// did:dht DIDs can be created, updated, and deactivated through registrar-based tooling. Keys are always managed by
+// the client: each operation encodes the DID's document as a DNS packet and returns the BEP44 payload for the client
+// to sign with the DID's identity key, then publishes the record once the client sends the signature back.
+type RegistrarRouter struct {
+	service *service.DHTService
+}
+
+// NewRegistrarRouter returns a new instance of RegistrarRouter for the given service
+func NewRegistrarRouter(service *service.DHTService) *RegistrarRouter {
+	return &RegistrarRouter{service: service}
+}
+
+// RegistrarOptions are the options of a registrar request
+type RegistrarOptions struct {
+	// ClientSecretMode is implied: the gateway never holds keys, so every record is signed by the client
+	ClientSecretMode *bool `json:"clientSecretMode,omitempty"`
+	// Types are the indexed types of the DID; an update keeps the DID's current types if not given
+	Types []int `json:"types,omitempty"`
+	// Gateways are the fully qualified domain names of the DID's authoritative gateways; an update keeps the DID's
+	// current gateways if not given
+	Gateways []string `json:"gateways,omitempty"`
+}
+
+// RegistrarSecret is the secret of a registrar request, carrying the client's signatures
+type RegistrarSecret struct {
+	SigningResponse map[string]SigningResponse `json:"signingResponse,omitempty"`
+}
+
+// SigningRequest asks the client to sign a payload with the key identified by KID
+type SigningRequest struct {
+	KID string `json:"kid"`
+	Alg string `json:"alg"`
+	// SerializedPayload is the base64url encoded BEP44 payload to sign
+	SerializedPayload string `json:"serializedPayload"`
+}
+
+// SigningResponse is the client's signature for a signing request
+type SigningResponse struct {
+	// Signature is the base64url encoded ed25519 signature of the payload
+	Signature string `json:"signature"`
+}
+
+// RegistrarCreateRequest creates a DID. The DID is that of the document's verification method with ID 0, which must
+// be an Ed25519 key; the document's ID may be left out.
+type RegistrarCreateRequest struct {
+	JobID       string           `json:"jobId,omitempty"`
+	Options     RegistrarOptions `json:"options"`
+	Secret      RegistrarSecret  `json:"secret"`
+	DIDDocument *didsdk.Document `json:"didDocument,omitempty"`
+}
+
+// RegistrarUpdateRequest replaces a DID's document with the one given
+type RegistrarUpdateRequest struct {
+	JobID   string           `json:"jobId,omitempty"`
+	DID     string           `json:"did"`
+	Options RegistrarOptions `json:"options"`
+	Secret  RegistrarSecret  `json:"secret"`
+	// DIDDocumentOperation is setDidDocument, the default, for the document given
+	DIDDocumentOperation []string          `json:"didDocumentOperation,omitempty"`
+	DIDDocument          []didsdk.Document `json:"didDocument,omitempty"`
+}
+
+// RegistrarDeactivateRequest deactivates a DID
+type RegistrarDeactivateRequest struct {
+	JobID   string           `json:"jobId,omitempty"`
+	DID     string           `json:"did"`
+	Options RegistrarOptions `json:"options"`
+	Secret  RegistrarSecret  `json:"secret"`
+}
+
+// RegistrarState is the state of a registrar job. A job in the action state is finished by sending its job ID back
+// with the signatures its signing requests ask for.
+type RegistrarState struct {
+	JobID                   string           `json:"jobId,omitempty"`
+	DIDState                DIDState         `json:"didState"`
+	DIDRegistrationMetadata map[string]any   `json:"didRegistrationMetadata"`
+	DIDDocumentMetadata     DocumentMetadata `json:"didDocumentMetadata"`
+}
+
+// DIDState is the state of the DID a registrar job operates on
+type DIDState struct {
+	// State is finished, failed, or action
+	State       string           `json:"state"`
+	DID         string           `json:"did,omitempty"`
+	DIDDocument *didsdk.Document `json:"didDocument,omitempty"`
+	// Action is signPayload when the job is waiting for the client's signatures
+	Action         string                    `json:"action,omitempty"`
+	SigningRequest map[string]SigningRequest `json:"signingRequest,omitempty"`
+	// Reason is why the job failed, if it did
+	Reason string `json:"reason,omitempty"`
+}
+
+// Create godoc
+//
+//	@Summary		Create a DID as a Universal Registrar driver
+//	@Description	Create creates a DID following the DIF Universal Registrar driver contract. The first request gives the DID's document, and is answered with a signPayload action asking the client to sign the record's BEP44 payload with the DID's identity key. Sending the job ID back with the signature publishes the DID.
+//	@Tags			Registrar
+//	@Accept			json
+//	@Produce		json
+//	@Param			method	query		string					false	"DID method, which must be dht"
+//	@Param			request	body		RegistrarCreateRequest	true	"Create request"
+//	@Success		200		{object}	RegistrarState			"Signatures requested"
+//	@Success		201		{object}	RegistrarState			"DID created"
+//	@Failure		400		{object}	RegistrarState			"Bad request"
+//	@Failure		403		{object}	RegistrarState			"Client or DID is denied by the gateway"
+//	@Failure		429		{string}	string					"Too many requests"
+//	@Failure		500		{object}	RegistrarState			"Internal server error"
+//	@Router			/1.0/create [post]
+func (r *RegistrarRouter) Create(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "RegistrarHTTP.Create")
+	defer span.End()
+
+	var request RegistrarCreateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondRegistrarError(c, request.JobID, errors.Wrap(err, "invalid request"), http.StatusBadRequest)
+		return
+	}
+	if method := c.Query("method"); method != "" && method != string(did.DHTMethod) {
+		respondRegistrarError(c, request.JobID, fmt.Errorf("method not supported: %s", method), http.StatusBadRequest)
+		return
+	}
+	if request.JobID != "" {
+		r.finish(ctx, c, request.JobID, request.Secret, http.StatusCreated)
+		return
+	}
+	if request.DIDDocument == nil {
+		respondRegistrarError(c, "", errors.New("a did document is required"), http.StatusBadRequest)
+		return
+	}
+
+	doc := *request.DIDDocument
+	identityKey, err := documentIdentityKey(doc)
+	if err != nil {
+		respondRegistrarError(c, "", err, http.StatusBadRequest)
+		return
+	}
+	id := did.GetDIDDHTIdentifier(identityKey)
+	if doc.ID != "" && doc.ID != id {
+		respondRegistrarError(c, "", fmt.Errorf("document id %s isn't the did of its identity key, %s", doc.ID, id), http.StatusBadRequest)
+		return
+	}
+	doc.ID = id
+	packet, err := did.DHT(id).ToDNSPacket(doc, typeIndexes(request.Options.Types), authoritativeGateways(request.Options.Gateways), nil)
+	if err != nil {
+		respondRegistrarError(c, "", errors.Wrap(err, "failed to encode did document"), http.StatusBadRequest)
+		return
+	}
+	r.requestSignature(c, id, packet, 0)
+}
+
+// Update godoc
+//
+//	@Summary		Update a DID as a Universal Registrar driver
+//	@Description	Update replaces a DID's document following the DIF Universal Registrar driver contract, keeping its types, gateways, and previous DID unless options say otherwise. Like Create, the record is published once the client sends the signature a signPayload action asks for.
+//	@Tags			Registrar
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		RegistrarUpdateRequest	true	"Update request"
+//	@Success		200		{object}	RegistrarState
+//	@Failure		400		{object}	RegistrarState	"Bad request"
+//	@Failure		403		{object}	RegistrarState	"Client or DID is denied by the gateway"
+//	@Failure		404		{object}	RegistrarState	"Not found"
+//	@Failure		410		{object}	RegistrarState	"DID deactivated"
+//	@Failure		429		{string}	string			"Too many requests"
+//	@Failure		500		{object}	RegistrarState	"Internal server error"
+//	@Router			/1.0/update [post]
+func (r *RegistrarRouter) Update(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "RegistrarHTTP.Update")
+	defer span.End()
+
+	var request RegistrarUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondRegistrarError(c, request.JobID, errors.Wrap(err, "invalid request"), http.StatusBadRequest)
+		return
+	}
+	if request.JobID != "" {
+		r.finish(ctx, c, request.JobID, request.Secret, http.StatusOK)
+		return
+	}
+	for _, operation := range request.DIDDocumentOperation {
+		if operation != setDIDDocumentOperation {
+			respondRegistrarError(c, "", fmt.Errorf("operation not supported: %s", operation), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(request.DIDDocument) != 1 {
+		respondRegistrarError(c, "", errors.New("exactly one did document is required"), http.StatusBadRequest)
+		return
+	}
+
+	current, seq, ok := r.current(ctx, c, request.DID)
+	if !ok {
+		return
+	}
+	doc := request.DIDDocument[0]
+	if doc.ID == "" {
+		doc.ID = request.DID
+	}
+	if doc.ID != request.DID {
+		respondRegistrarError(c, "", fmt.Errorf("document id %s isn't the did being updated, %s", doc.ID, request.DID), http.StatusBadRequest)
+		return
+	}
+	types, gateways := current.Types, current.Gateways
+	if request.Options.Types != nil {
+		types = typeIndexes(request.Options.Types)
+	}
+	if request.Options.Gateways != nil {
+		gateways = authoritativeGateways(request.Options.Gateways)
+	}
+	// the previous did's signature is over this did, so still holds
+	packet, err := did.DHT(request.DID).ToDNSPacket(doc, types, gateways, current.PreviousDID)
+	if err != nil {
+		respondRegistrarError(c, "", errors.Wrap(err, "failed to encode did document"), http.StatusBadRequest)
+		return
+	}
+	r.requestSignature(c, request.DID, packet, seq)
+}
+
+// Deactivate godoc
+//
+//	@Summary		Deactivate a DID as a Universal Registrar driver
+//	@Description	Deactivate deactivates a DID following the DIF Universal Registrar driver contract. Like Create, the deactivation is published once the client sends the signature a signPayload action asks for.
+//	@Tags			Registrar
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		RegistrarDeactivateRequest	true	"Deactivate request"
+//	@Success		200		{object}	RegistrarState
+//	@Failure		400		{object}	RegistrarState	"Bad request"
+//	@Failure		403		{object}	RegistrarState	"Client or DID is denied by the gateway"
+//	@Failure		404		{object}	RegistrarState	"Not found"
+//	@Failure		410		{object}	RegistrarState	"DID deactivated"
+//	@Failure		429		{string}	string			"Too many requests"
+//	@Failure		500		{object}	RegistrarState	"Internal server error"
+//	@Router			/1.0/deactivate [post]
+func (r *RegistrarRouter) Deactivate(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "RegistrarHTTP.Deactivate")
+	defer span.End()
+
+	var request RegistrarDeactivateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondRegistrarError(c, request.JobID, errors.Wrap(err, "invalid request"), http.StatusBadRequest)
+		return
+	}
+	if request.JobID != "" {
+		r.finish(ctx, c, request.JobID, request.Secret, http.StatusOK)
+		return
+	}
+	if _, seq, ok := r.current(ctx, c, request.DID); ok {
+		r.requestSignature(c, request.DID, did.DeactivationDNSPacket(), seq)
+	}
+}
+
+// current returns the current document and sequence number of the DID to update or deactivate, responding with the
+// error if there's none
+func (r *RegistrarRouter) current(ctx context.Context, c *gin.Context, id string) (*did.DIDDHTDocument, int64, bool) {
+	suffix, err := didSuffix(id)
+	if err != nil {
+		respondRegistrarError(c, "", errors.Wrapf(err, "invalid did: %s", id), http.StatusBadRequest)
+		return nil, 0, false
+	}
+	record, err := r.service.GetDHT(ctx, suffix)
+	if err != nil {
+		if errors.Is(err, service.SpamError) {
+			LoggingRespondErrMsg(c, fmt.Sprintf("too many requests for bad did %s", id), http.StatusTooManyRequests)
+			return nil, 0, false
+		}
+		respondRegistrarError(c, "", errors.Wrapf(err, "failed to resolve did: %s", id), errorStatus(err))
+		return nil, 0, false
+	}
+	if record == nil {
+		respondRegistrarError(c, "", fmt.Errorf("did not found: %s", id), http.StatusNotFound)
+		return nil, 0, false
+	}
+	msg := new(dns.Msg)
+	if err = msg.Unpack(record.V); err != nil {
+		respondRegistrarError(c, "", errors.Wrap(err, "failed to unpack current dns packet"), http.StatusInternalServerError)
+		return nil, 0, false
+	}
+	current, err := did.DHT(id).FromDNSPacket(msg)
+	if err != nil {
+		respondRegistrarError(c, "", errors.Wrap(err, "failed to decode current did document"), http.StatusInternalServerError)
+		return nil, 0, false
+	}
+	if current.Deactivated {
+		respondRegistrarError(c, "", fmt.Errorf("did is deactivated: %s", id), http.StatusGone)
+		return nil, 0, false
+	}
+	return current, record.Seq, true
+}
+
+// requestSignature responds with a job asking the client to sign the record publishing the DNS packet, with a
+// sequence number above the DID's current one
+func (r *RegistrarRouter) requestSignature(c *gin.Context, id string, packet *dns.Msg, currentSeq int64) {
+	value, err := packet.Pack()
+	if err != nil {
+		respondRegistrarError(c, "", errors.Wrap(err, "failed to pack dns packet"), http.StatusInternalServerError)
+		return
+	}
+	identityKey, _ := did.DHT(id).IdentityKey()
+	job := registrarJob{Key: [32]byte(identityKey), Seq: max(time.Now().Unix(), currentSeq+1), Value: value}
+	payload, err := job.record().SigningPayload()
+	if err != nil {
+		respondRegistrarError(c, "", err, http.StatusInternalServerError)
+		return
+	}
+	Respond(c, RegistrarState{
+		JobID: job.encode(),
+		DIDState: DIDState{
+			State:  registrarStateAction,
+			DID:    id,
+			Action: signPayloadAction,
+			SigningRequest: map[string]SigningRequest{
+				identityKeySigningRequest: {
+					KID:               id + "#0",
+					Alg:               "EdDSA",
+					SerializedPayload: base64.RawURLEncoding.EncodeToString(payload),
+				},
+			},
+		},
+		DIDRegistrationMetadata: map[string]any{},
+	}, http.StatusOK)
+}
+
+// finish publishes the record of a job signed by the client
+func (r *RegistrarRouter) finish(ctx context.Context, c *gin.Context, jobID string, secret RegistrarSecret, statusCode int) {
+	job, err := decodeRegistrarJob(jobID)
+	if err != nil {
+		respondRegistrarError(c, jobID, err, http.StatusBadRequest)
+		return
+	}
+	response, ok := secret.SigningResponse[identityKeySigningRequest]
+	if !ok {
+		respondRegistrarError(c, jobID, fmt.Errorf("the signature of %s is required", identityKeySigningRequest), http.StatusBadRequest)
+		return
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(response.Signature)
+	if err != nil {
+		respondRegistrarError(c, jobID, errors.Wrap(err, "signature must be base64url encoded"), http.StatusBadRequest)
+		return
+	}
+	record, err := dht.NewBEP44Record(job.Key[:], job.Value, sig, job.Seq)
+	if err != nil {
+		respondRegistrarError(c, jobID, errors.Wrap(err, "invalid signature"), http.StatusBadRequest)
+		return
+	}
+	if err = r.service.PublishDHT(ctx, record.ID(), *record); err != nil {
+		respondRegistrarError(c, jobID, errors.Wrap(err, "failed to publish did"), errorStatus(err))
+		return
+	}
+
+	id := did.GetDIDDHTIdentifier(job.Key[:])
+	msg := new(dns.Msg)
+	_ = msg.Unpack(job.Value)
+	doc, err := did.DHT(id).FromDNSPacket(msg)
+	if err != nil {
+		respondRegistrarError(c, jobID, errors.Wrap(err, "failed to decode did document"), http.StatusInternalServerError)
+		return
+	}
+	updated := time.Unix(job.Seq, 0).UTC()
+	Respond(c, RegistrarState{
+		JobID:                   jobID,
+		DIDState:                DIDState{State: registrarStateFinished, DID: id, DIDDocument: &doc.Doc},
+		DIDRegistrationMetadata: map[string]any{},
+		DIDDocumentMetadata: DocumentMetadata{
+			Updated:     &updated,
+			VersionID:   fmt.Sprint(job.Seq),
+			Deactivated: doc.Deactivated,
+		},
+	}, statusCode)
+}
+
+// respondRegistrarError responds with a failed registrar job, giving the error as its reason
+func respondRegistrarError(c *gin.Context, jobID string, err error, statusCode int) {
+	logrus.WithContext(c).WithError(err).Error()
+	Respond(c, RegistrarState{
+		JobID:                   jobID,
+		DIDState:                DIDState{State: registrarStateFailed, Reason: err.Error()},
+		DIDRegistrationMetadata: map[string]any{},
+	}, statusCode)
+}
+
+// registrarJob is a record waiting for the client's signature. It is encoded in the job ID rather than kept by the
+// gateway, so any replica can finish the job; a tampered job only asks for a signature the client never gave.
+type registrarJob struct {
+	Key   [32]byte
+	Seq   int64
+	Value []byte
+}
+
+func (j registrarJob) record() dht.BEP44Record {
+	return dht.BEP44Record{Key: j.Key, SequenceNumber: j.Seq, Value: j.Value}
+}
+
+// encode encodes the job as the base64url encoding of its key, big-endian sequence number, and value
+func (j registrarJob) encode() string {
+	encoded := append(j.Key[:], binary.BigEndian.AppendUint64(nil, uint64(j.Seq))...)
+	return base64.RawURLEncoding.EncodeToString(append(encoded, j.Value...))
+}
+
+func decodeRegistrarJob(jobID string) (*registrarJob, error) {
+	encoded, err := base64.RawURLEncoding.DecodeString(jobID)
+	if err != nil || len(encoded) <= 40 {
+		return nil, fmt.Errorf("unknown job: %s", jobID)
+	}
+	return &registrarJob{
+		Key:   [32]byte(encoded[:32]),
+		Seq:   int64(binary.BigEndian.Uint64(encoded[32:40])),
+		Value: encoded[40:],
+	}, nil
+}
+
+// documentIdentityKey returns the identity key of a DID document to create: its verification method with ID 0
+func documentIdentityKey(doc didsdk.Document) (ed25519.PublicKey, error) {
+	for _, vm := range doc.VerificationMethod {
+		if _, fragment, _ := strings.Cut(vm.ID, "#"); fragment != "0" && vm.ID != "0" {
+			continue
+		}
+		if vm.PublicKeyJWK == nil {
+			return nil, errors.New("identity key must be a publicKeyJwk")
+		}
+		key, err := vm.PublicKeyJWK.ToPublicKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid identity key")
+		}
+		identityKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("identity key must be an Ed25519 key")
+		}
+		return identityKey, nil
+	}
+	return nil, errors.New("document must have a verification method with id 0, its identity key")
+}
+
+func typeIndexes(types []int) []did.TypeIndex {
+	indexes := make([]did.TypeIndex, 0, len(types))
+	for _, typ := range types {
+		indexes = append(indexes, did.TypeIndex(typ))
+	}
+	return indexes
+}
+
+func authoritativeGateways(gateways []string) []did.AuthoritativeGateway {
+	authoritative := make([]did.AuthoritativeGateway, 0, len(gateways))
+	for _, gateway := range gateways {
+		authoritative = append(authoritative, did.AuthoritativeGateway(gateway))
+	}
+	return authoritative
+}
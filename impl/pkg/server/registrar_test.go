@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TBD54566975/ssi-sdk/crypto/jwx"
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+)
+
+func TestRegistrar(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "registrar", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+	ctx := context.Background()
+
+	call := func(path string, request any) (int, RegistrarState) {
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		var state RegistrarState
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state), w.Body.String())
+		return w.Code, state
+	}
+	// sign answers a job's signing request with the key's signature
+	sign := func(t *testing.T, state RegistrarState, key ed25519.PrivateKey) RegistrarSecret {
+		require.Equal(t, registrarStateAction, state.DIDState.State, state.DIDState.Reason)
+		require.Equal(t, signPayloadAction, state.DIDState.Action)
+		request, ok := state.DIDState.SigningRequest[identityKeySigningRequest]
+		require.True(t, ok)
+		assert.Equal(t, state.DIDState.DID+"#0", request.KID)
+		payload, err := base64.RawURLEncoding.DecodeString(request.SerializedPayload)
+		require.NoError(t, err)
+		signature := base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, payload))
+		return RegistrarSecret{SigningResponse: map[string]SigningResponse{identityKeySigningRequest: {Signature: signature}}}
+	}
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	jwk, err := jwx.PublicKeyToPublicKeyJWK(nil, pub)
+	require.NoError(t, err)
+	doc := didsdk.Document{
+		VerificationMethod: []didsdk.VerificationMethod{{ID: "#0", Type: "JsonWebKey", PublicKeyJWK: jwk}},
+		Authentication:     []didsdk.VerificationMethodSet{"#0"},
+		Services:           []didsdk.Service{{ID: "#dwn", Type: "DecentralizedWebNode", ServiceEndpoint: "https://dwn.example"}},
+	}
+	id := did.GetDIDDHTIdentifier(pub)
+
+	t.Run("create", func(t *testing.T) {
+		status, state := call("/1.0/create?method=dht", RegistrarCreateRequest{DIDDocument: &doc, Options: RegistrarOptions{Types: []int{1}}})
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, id, state.DIDState.DID)
+
+		status, finished := call("/1.0/create", RegistrarCreateRequest{JobID: state.JobID, Secret: sign(t, state, key)})
+		require.Equal(t, http.StatusCreated, status, finished.DIDState.Reason)
+		assert.Equal(t, registrarStateFinished, finished.DIDState.State)
+		require.NotNil(t, finished.DIDState.DIDDocument)
+		assert.Equal(t, id, finished.DIDState.DIDDocument.ID)
+
+		resolution, err := svc.ResolveDID(ctx, id)
+		require.NoError(t, err)
+		require.NotNil(t, resolution)
+		require.Len(t, resolution.Document.Services, 1)
+		assert.Equal(t, id+"#dwn", resolution.Document.Services[0].ID)
+		assert.Equal(t, finished.DIDDocumentMetadata.VersionID, resolution.VersionID)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		updated := doc
+		updated.Services = nil
+		status, state := call("/1.0/update", RegistrarUpdateRequest{DID: id, DIDDocument: []didsdk.Document{updated}})
+		require.Equal(t, http.StatusOK, status)
+		status, finished := call("/1.0/update", RegistrarUpdateRequest{JobID: state.JobID, Secret: sign(t, state, key)})
+		require.Equal(t, http.StatusOK, status, finished.DIDState.Reason)
+		assert.Equal(t, registrarStateFinished, finished.DIDState.State)
+
+		resolution, err := svc.ResolveDID(ctx, id)
+		require.NoError(t, err)
+		assert.Empty(t, resolution.Document.Services)
+		assert.Equal(t, finished.DIDDocumentMetadata.VersionID, resolution.VersionID)
+
+		// the types are kept
+		record, err := svc.GetDHT(ctx, id[len(did.Prefix)+1:])
+		require.NoError(t, err)
+		msg := new(dns.Msg)
+		require.NoError(t, msg.Unpack(record.V))
+		decoded, err := did.DHT(id).FromDNSPacket(msg)
+		require.NoError(t, err)
+		assert.Equal(t, []did.TypeIndex{1}, decoded.Types)
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		_, otherKey, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		_, state := call("/1.0/update", RegistrarUpdateRequest{DID: id, DIDDocument: []didsdk.Document{doc}})
+		status, failed := call("/1.0/update", RegistrarUpdateRequest{JobID: state.JobID, Secret: sign(t, state, otherKey)})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, registrarStateFailed, failed.DIDState.State)
+		assert.NotEmpty(t, failed.DIDState.Reason)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		noIdentityKey := doc
+		noIdentityKey.VerificationMethod = nil
+		otherDID, _ := generateDIDPutRequest(t)
+		for _, bad := range []struct {
+			name, path string
+			request    any
+			status     int
+		}{
+			{"other method", "/1.0/create?method=web", RegistrarCreateRequest{DIDDocument: &doc}, http.StatusBadRequest},
+			{"no document", "/1.0/create", RegistrarCreateRequest{}, http.StatusBadRequest},
+			{"no identity key", "/1.0/create", RegistrarCreateRequest{DIDDocument: &noIdentityKey}, http.StatusBadRequest},
+			{"unknown job", "/1.0/create", RegistrarCreateRequest{JobID: "unknown"}, http.StatusBadRequest},
+			{"no signature", "/1.0/deactivate", RegistrarDeactivateRequest{JobID: base64.RawURLEncoding.EncodeToString(make([]byte, 64))}, http.StatusBadRequest},
+			{"unsupported operation", "/1.0/update", RegistrarUpdateRequest{DID: id, DIDDocumentOperation: []string{"addToDidDocument"}, DIDDocument: []didsdk.Document{doc}}, http.StatusBadRequest},
+			{"other did's document", "/1.0/update", RegistrarUpdateRequest{DID: otherDID, DIDDocument: []didsdk.Document{{ID: id}}}, http.StatusNotFound},
+			{"invalid did", "/1.0/deactivate", RegistrarDeactivateRequest{DID: "did:example:123"}, http.StatusBadRequest},
+		} {
+			status, state := call(bad.path, bad.request)
+			assert.Equal(t, bad.status, status, bad.name)
+			assert.Equal(t, registrarStateFailed, state.DIDState.State, bad.name)
+		}
+	})
+
+	t.Run("deactivate", func(t *testing.T) {
+		status, state := call("/1.0/deactivate", RegistrarDeactivateRequest{DID: id})
+		require.Equal(t, http.StatusOK, status)
+		status, finished := call("/1.0/deactivate", RegistrarDeactivateRequest{JobID: state.JobID, Secret: sign(t, state, key)})
+		require.Equal(t, http.StatusOK, status, finished.DIDState.Reason)
+		assert.True(t, finished.DIDDocumentMetadata.Deactivated)
+
+		resolution, err := svc.ResolveDID(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, resolution.Deactivated)
+
+		status, _ = call("/1.0/update", RegistrarUpdateRequest{DID: id, DIDDocument: []didsdk.Document{doc}})
+		assert.Equal(t, http.StatusGone, status)
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/service"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// RegistryRouter serves the gateways clients can discover through this one
+type RegistryRouter struct {
+	service *service.DHTService
+}
+
+// NewRegistryRouter returns a new instance of RegistryRouter for the given service
+func NewRegistryRouter(service *service.DHTService) *RegistryRouter {
+	return &RegistryRouter{service: service}
+}
+
+// ListGatewaysResponse is the gateways known to this one
+type ListGatewaysResponse struct {
+	Gateways []service.Gateway `json:"gateways"`
+}
+
+// ListGateways godoc
+//
+//	@Summary		List known gateways
+//	@Description	ListGateways lists the gateway's own DID, if it announces itself, and the DIDs of the other gateways it knows of, each with the base URLs its DID document names in DIDDHTGateway services, so clients can discover alternative gateways. A gateway whose DID can't be resolved is listed with the error.
+//	@Tags			DHT
+//	@Produce		json
+//	@Success		200	{object}	ListGatewaysResponse
+//	@Failure		403	{string}	string	"Client is denied by the gateway"
+//	@Router			/gateways [get]
+func (r *RegistryRouter) ListGateways(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "RegistryHTTP.ListGateways")
+	defer span.End()
+
+	Respond(c, ListGatewaysResponse{Gateways: r.service.ListGateways(ctx)}, http.StatusOK)
+}
+
+// gatewayIdentityKey returns the identity key of the DID the gateway announces itself under
+func gatewayIdentityKey() (ed25519.PrivateKey, error) {
+	encodedKey, ok := os.LookupEnv(config.GatewayIdentityKey.String())
+	if !ok {
+		return nil, errors.Errorf("announcing the gateway requires a %s", config.GatewayIdentityKey)
+	}
+	seed, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s: must be base64 encoded", config.GatewayIdentityKey)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.Errorf("invalid %s: must be a %d byte ed25519 seed", config.GatewayIdentityKey, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
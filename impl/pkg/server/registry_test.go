@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/service"
+)
+
+func TestListGateways(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "registry", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+
+	list := func() ListGatewaysResponse {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/gateways", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp ListGatewaysResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	assert.Empty(t, list().Gateways)
+
+	t.Setenv(config.GatewayIdentityKey.String(), base64.StdEncoding.EncodeToString(make([]byte, ed25519.SeedSize)))
+	identityKey, err := gatewayIdentityKey()
+	require.NoError(t, err)
+	gatewayDID, err := svc.AnnounceGateway(context.Background(), identityKey)
+	require.NoError(t, err)
+	assert.Equal(t, []service.Gateway{{DID: gatewayDID, Endpoints: []string{"http://localhost:8305"}, Self: true}}, list().Gateways)
+
+	t.Setenv(config.GatewayIdentityKey.String(), base64.StdEncoding.EncodeToString([]byte("short")))
+	_, err = gatewayIdentityKey()
+	assert.ErrorContains(t, err, "must be a 32 byte ed25519 seed")
+}
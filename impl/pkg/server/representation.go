@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/ugorji/go/codec"
+
+	"github.com/TBD54566975/did-dht/pkg/service"
+)
+
+// Media types of the DID document representations https://www.w3.org/TR/did-core/#representations, and of the DID
+// resolution result https://w3c-ccg.github.io/did-resolution/#did-resolution-result
+const (
+	DIDJSONMediaType       = "application/did+json"
+	DIDLDJSONMediaType     = "application/did+ld+json"
+	DIDCBORMediaType       = "application/did+cbor"
+	DIDResolutionMediaType = `application/ld+json;profile="https://w3id.org/did-resolution"`
+
+	didResolutionProfile = "https://w3id.org/did-resolution"
+	didResolutionContext = "https://w3id.org/did-resolution/v1"
+)
+
+// DID resolution errors https://www.w3.org/TR/did-spec-registries/#error
+const (
+	invalidDIDError                 = "invalidDid"
+	notFoundError                   = "notFound"
+	representationNotSupportedError = "representationNotSupported"
+	methodNotSupportedError         = "methodNotSupported"
+	internalError                   = "internalError"
+)
+
+// ResolutionResult is the DID resolution result returned for requests accepting DIDResolutionMediaType
+type ResolutionResult struct {
+	Context               string             `json:"@context"`
+	DIDDocument           *didsdk.Document   `json:"didDocument"`
+	DIDResolutionMetadata ResolutionMetadata `json:"didResolutionMetadata"`
+	DIDDocumentMetadata   DocumentMetadata   `json:"didDocumentMetadata"`
+}
+
+// ResolutionMetadata is the metadata about the resolution of a DID
+type ResolutionMetadata struct {
+	ContentType string `json:"contentType,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Source is where the gateway retrieved the record from: cache, storage, or dht
+	Source string `json:"source,omitempty"`
+	// Retrieved is when the gateway retrieved the record; a record served from the cache was retrieved when cached
+	Retrieved *time.Time `json:"retrieved,omitempty"`
+	// Confirmations is the number of DHT nodes that returned the record, for records retrieved from the DHT
+	Confirmations int `json:"confirmations,omitempty"`
+	// SignatureVerified is whether the record's signature verified against the DID's identity key
+	SignatureVerified *bool `json:"signatureVerified,omitempty"`
+}
+
+// DocumentMetadata is the DID Core document metadata of a resolved DID. Versions are identified by their sequence
+// numbers, which are the Unix times they were published at.
+type DocumentMetadata struct {
+	Updated     *time.Time `json:"updated,omitempty"`
+	VersionID   string     `json:"versionId,omitempty"`
+	Deactivated bool       `json:"deactivated,omitempty"`
+	// PreviousDID is the DID the resolved DID supersedes https://did-dht.com/#rotation
+	PreviousDID string `json:"previousDid,omitempty"`
+}
+
+// negotiateDIDMediaType returns the media type to represent a resolved DID with for the given Accept header, preferring
+// media types with higher quality values and then those listed first. The fallback media type is used when any JSON
+// will do, and false is returned if no representation is acceptable.
+func negotiateDIDMediaType(accept string, fallback string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return fallback, true
+	}
+
+	var best string
+	bestQuality := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality <= bestQuality {
+			continue
+		}
+
+		var offered string
+		switch mediaType {
+		case DIDJSONMediaType, DIDLDJSONMediaType, DIDCBORMediaType:
+			offered = mediaType
+		case "application/ld+json":
+			// the profile parameter is a space separated list of profile URIs
+			offered = DIDLDJSONMediaType
+			for _, profile := range strings.Fields(params["profile"]) {
+				if profile == didResolutionProfile {
+					offered = DIDResolutionMediaType
+				}
+			}
+		case "application/json", "application/*", "*/*":
+			offered = fallback
+		default:
+			continue
+		}
+		best, bestQuality = offered, quality
+	}
+	return best, best != ""
+}
+
+// respondDIDDocument responds with the resolved DID's document in the given media type, or with the DID resolution
+// result if the media type is DIDResolutionMediaType
+func respondDIDDocument(c *gin.Context, mediaType string, resolution service.DIDResolution, statusCode int) {
+	doc := *resolution.Document
+	if mediaType == DIDLDJSONMediaType || mediaType == DIDResolutionMediaType {
+		if doc.Context == nil {
+			doc.Context = didsdk.KnownDIDContext
+		}
+	}
+
+	switch mediaType {
+	case DIDResolutionMediaType:
+		result := ResolutionResult{
+			Context:               didResolutionContext,
+			DIDDocument:           &doc,
+			DIDResolutionMetadata: ResolutionMetadata{ContentType: DIDLDJSONMediaType},
+			DIDDocumentMetadata: DocumentMetadata{
+				VersionID:   resolution.VersionID,
+				Deactivated: resolution.Deactivated,
+				PreviousDID: resolution.PreviousDID,
+			},
+		}
+		if seq, err := strconv.ParseInt(resolution.VersionID, 10, 64); err == nil {
+			updated := time.Unix(seq, 0).UTC()
+			result.DIDDocumentMetadata.Updated = &updated
+		}
+		if p := resolution.Provenance; p != nil {
+			retrieved := p.RetrievedAt.UTC()
+			verified := p.SignatureVerified
+			result.DIDResolutionMetadata.Source = p.Source
+			result.DIDResolutionMetadata.Retrieved = &retrieved
+			result.DIDResolutionMetadata.Confirmations = p.Confirmations
+			result.DIDResolutionMetadata.SignatureVerified = &verified
+		}
+		respondJSON(c, statusCode, DIDResolutionMediaType, result)
+	case DIDCBORMediaType:
+		data, err := marshalCBOR(doc)
+		if err != nil {
+			LoggingRespondErrWithMsg(c, err, "failed to encode did document as cbor", http.StatusInternalServerError)
+			return
+		}
+		c.Data(statusCode, DIDCBORMediaType, data)
+	default:
+		respondJSON(c, statusCode, mediaType, doc)
+	}
+}
+
+// respondResolutionError responds with a DID resolution result holding the given error and no document
+func respondResolutionError(c *gin.Context, resolutionError string, statusCode int) {
+	respondJSON(c, statusCode, DIDResolutionMediaType, ResolutionResult{
+		Context:               didResolutionContext,
+		DIDResolutionMetadata: ResolutionMetadata{Error: resolutionError},
+	})
+}
+
+// respondJSON responds with the JSON encoding of data under the given content type, without escaping HTML characters
+// as PureJSON does
+func respondJSON(c *gin.Context, statusCode int, contentType string, data any) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(data); err != nil {
+		LoggingRespondErrWithMsg(c, err, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	c.Data(statusCode, contentType, body.Bytes())
+}
+
+// marshalCBOR encodes the value in CBOR by way of its JSON data model, so the CBOR representation has the same
+// entries as the JSON representation. Maps are encoded in canonical order.
+func marshalCBOR(v any) ([]byte, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var model any
+	if err = json.Unmarshal(jsonBytes, &model); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	handle := codec.CborHandle{}
+	handle.Canonical = true
+	if err = codec.NewEncoderBytes(&out, &handle).Encode(model); err != nil {
+		return nil, errors.Wrap(err, "failed to encode cbor")
+	}
+	return out, nil
+}
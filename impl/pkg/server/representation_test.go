@@ -0,0 +1,71 @@
+package server
+
+import (
+	"testing"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+func TestNegotiateDIDMediaType(t *testing.T) {
+	tests := []struct {
+		accept    string
+		mediaType string
+	}{
+		{"", DIDJSONMediaType},
+		{"*/*", DIDJSONMediaType},
+		{"application/json", DIDJSONMediaType},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", DIDJSONMediaType},
+		{"application/did+json", DIDJSONMediaType},
+		{"application/did+ld+json", DIDLDJSONMediaType},
+		{"application/did+cbor", DIDCBORMediaType},
+		{"application/ld+json", DIDLDJSONMediaType},
+		{`application/ld+json;profile="https://w3id.org/did-resolution"`, DIDResolutionMediaType},
+		{`application/ld+json; profile="https://example.com https://w3id.org/did-resolution"`, DIDResolutionMediaType},
+		{"application/did+json;q=0.5, application/did+cbor", DIDCBORMediaType},
+		{"application/did+cbor, application/did+json", DIDCBORMediaType},
+		{"application/did+cbor;q=0, application/did+json;q=0.1", DIDJSONMediaType},
+	}
+	for _, test := range tests {
+		mediaType, ok := negotiateDIDMediaType(test.accept, DIDJSONMediaType)
+		assert.True(t, ok, test.accept)
+		assert.Equal(t, test.mediaType, mediaType, test.accept)
+	}
+
+	for _, accept := range []string{"text/html", "application/did+cbor;q=0", "application/xml, text/plain"} {
+		_, ok := negotiateDIDMediaType(accept, DIDJSONMediaType)
+		assert.False(t, ok, accept)
+	}
+
+	// the universal resolver driver falls back to the resolution result
+	for _, accept := range []string{"", "*/*", "application/json"} {
+		mediaType, ok := negotiateDIDMediaType(accept, DIDResolutionMediaType)
+		assert.True(t, ok, accept)
+		assert.Equal(t, DIDResolutionMediaType, mediaType, accept)
+	}
+	mediaType, ok := negotiateDIDMediaType("application/did+ld+json", DIDResolutionMediaType)
+	assert.True(t, ok)
+	assert.Equal(t, DIDLDJSONMediaType, mediaType)
+}
+
+func TestMarshalCBOR(t *testing.T) {
+	doc := didsdk.Document{
+		ID:       "did:dht:example",
+		Services: []didsdk.Service{{ID: "#dwn", Type: "DWN", ServiceEndpoint: []string{"https://example.com?a=1&b=2"}}},
+	}
+	data, err := marshalCBOR(doc)
+	require.NoError(t, err)
+
+	var decoded map[string]any
+	require.NoError(t, codec.NewDecoderBytes(data, new(codec.CborHandle)).Decode(&decoded))
+	assert.Equal(t, "did:dht:example", decoded["id"])
+	services, ok := decoded["service"].([]any)
+	require.True(t, ok)
+	require.Len(t, services, 1)
+	service, ok := services[0].(map[any]any)
+	require.True(t, ok)
+	assert.Equal(t, "DWN", service["type"])
+	assert.Equal(t, []any{"https://example.com?a=1&b=2"}, service["serviceEndpoint"])
+}
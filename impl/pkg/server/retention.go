@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/retention"
+)
+
+// RetentionSolutionHeader is the request header carrying the retention solution of a put
+const RetentionSolutionHeader = "Retention-Solution"
+
+// defaultChallengeExpiry is how often a new retention challenge is issued, as the spec recommends
+const defaultChallengeExpiry = 10 * time.Minute
+
+// RetentionRouter serves the gateway's retention challenges
+type RetentionRouter struct {
+	challenger *retention.Challenger
+}
+
+// NewRetentionRouter returns a router serving the challenger's challenges; a nil challenger serves none
+func NewRetentionRouter(challenger *retention.Challenger) *RetentionRouter {
+	return &RetentionRouter{challenger: challenger}
+}
+
+// Difficulty godoc
+//
+//	@Summary		Get the current retention challenge
+//	@Description	Difficulty returns the hash that retention solutions are computed against, and the number of leading zero bits they must have, for the gateway to accept a record. A solution is the hex encoded SHA-256 hash of the DID, the challenge hash, and a 32-bit nonce, concatenated, followed by a colon and the nonce; it is sent with a put in the Retention-Solution header.
+//	@Tags			DHT
+//	@Produce		json
+//	@Success		200	{object}	retention.Challenge
+//	@Failure		403	{string}	string	"Client is denied by the gateway"
+//	@Failure		501	{string}	string	"Retention challenges aren't required by this gateway"
+//	@Router			/difficulty [get]
+func (r *RetentionRouter) Difficulty(c *gin.Context) {
+	if r.challenger == nil {
+		Respond(c, errors.New("retention challenges aren't required by this gateway"), http.StatusNotImplemented)
+		return
+	}
+	Respond(c, r.challenger.Challenge(), http.StatusOK)
+}
+
+// RequireRetentionSolution returns middleware rejecting puts without a solution to a current retention challenge
+// for the DID of the record, salted records included
+func RequireRetentionSolution(challenger *retention.Challenger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := did.Prefix + ":" + c.Param(IDParam)
+		if err := challenger.Verify(id, c.GetHeader(RetentionSolutionHeader)); err != nil {
+			LoggingRespondError(c, err, http.StatusBadRequest)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// retentionChallenger returns the challenger of the configured retention challenges, or nil if they are disabled
+func retentionChallenger(cfg config.RetentionConfig) (*retention.Challenger, error) {
+	if cfg.Difficulty == 0 {
+		return nil, nil
+	}
+	if cfg.Difficulty < retention.MinDifficulty {
+		return nil, errors.Errorf("retention difficulty must be at least %d bits", retention.MinDifficulty)
+	}
+	expiry := time.Duration(cfg.ChallengeExpirySeconds) * time.Second
+	if expiry == 0 {
+		expiry = defaultChallengeExpiry
+	}
+	return retention.NewChallenger([]byte(os.Getenv(config.RetentionSecret.String())), cfg.Difficulty, expiry)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/peering"
+	"github.com/TBD54566975/did-dht/pkg/retention"
+)
+
+func TestRetentionChallenges(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "retention", config.PeeringConfig{})
+	challenger, err := retention.NewChallenger(nil, 8, time.Minute)
+	require.NoError(t, err)
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, challenger, 0, false, nil, ""))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/difficulty")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var challenge retention.Challenge
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+	assert.Equal(t, 8, challenge.Difficulty)
+	assert.Equal(t, retention.HashSource, challenge.HashSource)
+
+	didID, body := generateDIDPutRequest(t)
+	put := func(solution string) int {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/"+strings.TrimPrefix(didID, "did:dht:"), bytes.NewReader(body))
+		require.NoError(t, err)
+		if solution != "" {
+			req.Header.Set(RetentionSolutionHeader, solution)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusBadRequest, put(""))
+	assert.Equal(t, http.StatusBadRequest, put(retention.Solve(didID+"o", challenge)))
+	assert.Equal(t, http.StatusOK, put(retention.Solve(didID, challenge)))
+}
+
+func TestRetentionChallengesDisabled(t *testing.T) {
+	handler := gin.New()
+	handler.GET("/difficulty", NewRetentionRouter(nil).Difficulty)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/difficulty", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	challenger, err := retentionChallenger(config.RetentionConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, challenger)
+
+	_, err = retentionChallenger(config.RetentionConfig{Difficulty: 8})
+	assert.ErrorContains(t, err, "at least 26 bits")
+
+	t.Setenv(config.RetentionSecret.String(), "secret")
+	challenger, err = retentionChallenger(config.RetentionConfig{Difficulty: 26})
+	require.NoError(t, err)
+	other, err := retention.NewChallenger([]byte("secret"), 26, defaultChallengeExpiry)
+	require.NoError(t, err)
+	assert.Equal(t, other.Challenge(), challenger.Challenge())
+}
+
+func TestRetentionChallengesRefusePeers(t *testing.T) {
+	t.Setenv(config.PeeringSecret.String(), "secret")
+	cfg := config.GetDefaultConfig()
+	cfg.ServerConfig.StorageURI = "bolt://retention-peers-test.db"
+	cfg.PeeringConfig.Peers = []string{"http://127.0.0.1:1"}
+	cfg.Retention.Difficulty = 26
+	t.Cleanup(func() { _ = os.Remove("retention-peers-test.db") })
+	s, err := NewServer(&cfg, make(chan os.Signal, 1), dht.NewTestDHT(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	// records from peers carry no retention solution, so they aren't accepted
+	for _, route := range s.handler.Routes() {
+		assert.NotEqual(t, peering.RecordsPath, route.Path)
+	}
+}
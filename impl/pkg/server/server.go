@@ -2,31 +2,43 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/TBD54566975/ssi-sdk/util"
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	swaggerfiles "github.com/swaggo/files"
 	ginswagger "github.com/swaggo/gin-swagger"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"google.golang.org/grpc"
 
 	"github.com/TBD54566975/did-dht/config"
 	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/httpsig"
+	"github.com/TBD54566975/did-dht/pkg/ratelimit"
+	"github.com/TBD54566975/did-dht/pkg/retention"
 	"github.com/TBD54566975/did-dht/pkg/service"
 	"github.com/TBD54566975/did-dht/pkg/storage"
 )
 
 const (
-	IDParam string = "id"
+	IDParam        string = "id"
+	VersionIDParam string = "versionId"
+	DIDParam       string = "did"
 )
 
 type Server struct {
 	*http.Server
-	handler *gin.Engine
+	// GRPCAddr is the address the gRPC API is served on, if it is enabled
+	GRPCAddr   string
+	grpcServer *grpc.Server
+	handler    *gin.Engine
 
 	shutdown chan os.Signal
 
@@ -35,14 +47,18 @@ type Server struct {
 }
 
 // NewServer returns a new instance of Server with the given db and host.
-func NewServer(cfg *config.Config, shutdown chan os.Signal, d *dht.DHT) (*Server, error) {
+func NewServer(cfg *config.Config, shutdown chan os.Signal, d dht.Client) (*Server, error) {
 	// set up server prerequisites
-	handler := setupHandler(cfg.ServerConfig.Environment)
+	handler, err := setupHandler(cfg)
+	if err != nil {
+		return nil, util.LoggingErrorMsg(err, "could not set up the handler")
+	}
 
 	db, err := storage.NewStorage(cfg.ServerConfig.StorageURI)
 	if err != nil {
 		return nil, util.LoggingErrorMsg(err, "failed to instantiate storage")
 	}
+	db = storage.Instrument(db)
 
 	recordCnt, err := db.RecordCount(context.Background())
 	if err != nil {
@@ -56,41 +72,244 @@ func NewServer(cfg *config.Config, shutdown chan os.Signal, d *dht.DHT) (*Server
 		return nil, util.LoggingErrorMsg(err, "could not instantiate the dht service")
 	}
 
-	handler.GET("/health", Health)
+	if cfg.Registry.Announce {
+		identityKey, err := gatewayIdentityKey()
+		if err != nil {
+			return nil, util.LoggingErrorMsg(err, "could not announce the gateway")
+		}
+		gatewayDID, err := dhtService.AnnounceGateway(context.Background(), identityKey)
+		if err != nil {
+			return nil, util.LoggingErrorMsg(err, "could not announce the gateway")
+		}
+		logrus.WithField("did", gatewayDID).Info("announced the gateway")
+	}
+
+	// the node's health and routing table are only known when the gateway runs a DHT node rather than using relays
+	node, _ := d.(*dht.DHT)
+	healthRouter := NewHealthRouter(node, dhtService, cfg.Readiness)
+	handler.GET("/health", healthRouter.Health)
+	handler.GET("/health/live", healthRouter.Health)
+	handler.GET("/health/ready", healthRouter.Ready)
+	if cfg.ServerConfig.DebugEndpoints && node != nil {
+		handler.GET("/debug/dht", NewDebugRouter(node).DHT)
+	}
+	if cfg.ServesMetrics() {
+		handler.GET("/metrics", Metrics)
+	}
+	// the admin token is only accepted with the admin endpoints enabled, and client certificates aren't required
+	var adminToken string
+	if cfg.ServerConfig.AdminEndpoints {
+		if !cfg.ServerConfig.AdminRequireClientCert {
+			adminToken = os.Getenv(config.AdminToken.String())
+		}
+		if adminToken == "" && cfg.ServerConfig.AdminClientCAFile == "" {
+			return nil, fmt.Errorf("admin endpoints require an %s or an admin client CA", config.AdminToken)
+		}
+		adminRouter := NewAdminRouter(dhtService, func(ctx context.Context) error {
+			return reloadConfig(ctx, cfg.Path, dhtService)
+		})
+		AdminAPI(handler.Group("/admin", AdminAuth(adminToken)), adminRouter)
+	}
 
-	// set up swagger
+	// set up the spec, served as OpenAPI 3 with Swagger UI at /spec, and as Swagger 2.0 at /swagger for existing clients
+	handler.StaticFile("openapi.yaml", "./docs/openapi.yaml")
+	handler.GET("/spec", func(c *gin.Context) { c.Redirect(http.StatusMovedPermanently, "/spec/index.html") })
+	handler.GET("/spec/*any", ginswagger.WrapHandler(swaggerfiles.Handler, ginswagger.URL("/openapi.yaml")))
 	handler.StaticFile("swagger.yaml", "./docs/swagger.yaml")
 	handler.GET("/swagger/*any", ginswagger.WrapHandler(swaggerfiles.Handler, ginswagger.URL("/swagger.yaml")))
 
 	// root relay API
-	if err = DHTAPI(&handler.RouterGroup, dhtService); err != nil {
+	limiter, err := configuredRateLimiter(cfg.RateLimit, dhtService)
+	if err != nil {
+		return nil, util.LoggingErrorMsg(err, "could not set up rate limiting")
+	}
+	var rateLimit gin.HandlerFunc
+	if limiter != nil {
+		rateLimit = limiter.middleware()
+	}
+	challenger, err := retentionChallenger(cfg.Retention)
+	if err != nil {
+		return nil, util.LoggingErrorMsg(err, "could not set up retention challenges")
+	}
+	signer, err := responseSigner()
+	if err != nil {
+		return nil, util.LoggingErrorMsg(err, "could not set up response signing")
+	}
+	if err = DHTAPI(&handler.RouterGroup, dhtService, rateLimit, challenger, cfg.ServerConfig.MaxPutBodyBytes, cfg.ServerConfig.PkarrRelay, signer, adminToken); err != nil {
 		return nil, util.LoggingErrorMsg(err, "could not setup the dht API")
 	}
+	// records are only accepted from peers by gateways peering with them, and since records from peers carry no
+	// retention solution, not by gateways requiring one
+	switch {
+	case len(cfg.PeeringConfig.Peers) == 0:
+	case challenger != nil:
+		logrus.Warn("not accepting records from peers, which carry no retention solution")
+	default:
+		if err = PeeringAPI(&handler.RouterGroup, dhtService, rateLimit, os.Getenv(config.PeeringSecret.String())); err != nil {
+			return nil, util.LoggingErrorMsg(err, "could not set up peering")
+		}
+	}
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.ServerConfig.APIHost, cfg.ServerConfig.APIPort),
+		Handler:           handler,
+		ReadTimeout:       time.Second * 15,
+		ReadHeaderTimeout: time.Second * 10,
+		WriteTimeout:      time.Second * 10,
+		MaxHeaderBytes:    1 << 20,
+	}
+	if httpServer.TLSConfig, err = tlsConfig(cfg.ServerConfig); err != nil {
+		return nil, util.LoggingErrorMsg(err, "could not configure tls")
+	}
+	var grpcServer *grpc.Server
+	if cfg.ServerConfig.GRPCPort != 0 {
+		if cfg.RateLimit.APIKeys.Required {
+			return nil, util.LoggingNewError("api keys can't be required with the grpc api enabled, since it doesn't accept them")
+		}
+		// the gRPC API serves the same certificate, but has no admin calls to request client certificates for
+		var grpcTLS *tls.Config
+		if httpServer.TLSConfig != nil {
+			grpcTLS = httpServer.TLSConfig.Clone()
+			grpcTLS.ClientAuth, grpcTLS.ClientCAs = tls.NoClientCert, nil
+		}
+		if grpcServer, err = NewGRPCServer(grpcTLS, dhtService, limiter, challenger); err != nil {
+			return nil, util.LoggingErrorMsg(err, "could not set up the grpc API")
+		}
+	}
+	// end event streams on shutdown, since the server waits for open connections to close
+	httpServer.RegisterOnShutdown(dhtService.CloseSubscriptions)
 	return &Server{
-		Server: &http.Server{
-			Addr:              fmt.Sprintf("%s:%d", cfg.ServerConfig.APIHost, cfg.ServerConfig.APIPort),
-			Handler:           handler,
-			ReadTimeout:       time.Second * 15,
-			ReadHeaderTimeout: time.Second * 10,
-			WriteTimeout:      time.Second * 10,
-			MaxHeaderBytes:    1 << 20,
-		},
-		cfg:      cfg,
-		svc:      dhtService,
-		handler:  handler,
-		shutdown: shutdown,
+		Server:     httpServer,
+		GRPCAddr:   fmt.Sprintf("%s:%d", cfg.ServerConfig.APIHost, cfg.ServerConfig.GRPCPort),
+		grpcServer: grpcServer,
+		cfg:        cfg,
+		svc:        dhtService,
+		handler:    handler,
+		shutdown:   shutdown,
 	}, nil
 }
 
-func setupHandler(env config.Environment) *gin.Engine {
+// ListenAndServe serves the API over TLS when a certificate or ACME domains are configured, and over plain HTTP
+// otherwise, along with the gRPC API if it is enabled, until either server fails or is shut down
+func (s *Server) ListenAndServe() error {
+	errs := make(chan error, 2)
+	if s.grpcServer != nil {
+		listener, err := net.Listen("tcp", s.GRPCAddr)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen for grpc")
+		}
+		go func() { errs <- s.grpcServer.Serve(listener) }()
+	}
+	go func() {
+		if s.Server.TLSConfig != nil {
+			// the certificate is in the TLS config, loaded from its files or obtained from the ACME CA
+			errs <- s.Server.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- s.Server.ListenAndServe()
+	}()
+	return <-errs
+}
+
+// Shutdown gracefully shuts down the gateway: the HTTP server, then the gRPC server, if any, stop accepting
+// connections and finish the requests in flight, then the service finishes its background work and closes, closing
+// the DHT and saving its routing state. Event streams of either API end on shutdown. Whatever is still in flight when
+// the context ends is abandoned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Warn("http requests still in flight at the shutdown deadline")
+	}
+	if s.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.grpcServer.Stop()
+		}
+	}
+	s.svc.Shutdown(ctx)
+	return err
+}
+
+// Close immediately closes the HTTP server and the gRPC server, if any, and their connections, and closes the
+// service, abandoning its background work
+func (s *Server) Close() error {
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+	err := s.Server.Close()
+	s.svc.Close()
+	return err
+}
+
+// reloadConfig reloads the config file at the given path, applying the settings that can change while the gateway
+// runs: the log level, record collection, and access lists
+func reloadConfig(ctx context.Context, path string, svc *service.DHTService) error {
+	if path == "" {
+		return errors.New("the gateway was started with the default config, which has no file to reload")
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	var level logrus.Level
+	if cfg.Log.Level != "" {
+		if level, err = logrus.ParseLevel(cfg.Log.Level); err != nil {
+			return errors.Wrapf(err, "invalid log level %q", cfg.Log.Level)
+		}
+	}
+	if err = svc.ReloadGC(cfg.GCConfig); err != nil {
+		return errors.Wrap(err, "failed to reload record collection")
+	}
+	if err = svc.ReloadAccess(cfg.Access); err != nil {
+		return errors.Wrap(err, "failed to reload access lists")
+	}
+	if cfg.Log.Level != "" {
+		logrus.SetLevel(level)
+	}
+	logrus.WithContext(ctx).WithField("path", path).Info("reloaded config")
+	return nil
+}
+
+// configuredRateLimiter returns the rate limiter of the configured limits, authenticating API keys with apiKeys if
+// they're enabled, or nil if no limit is configured and API keys aren't enabled
+func configuredRateLimiter(cfg config.RateLimitConfig, apiKeys apiKeyAuthenticator) (*rateLimiter, error) {
+	if cfg.APIKeys.Required && !cfg.APIKeys.Enabled {
+		return nil, errors.New("api keys can't be required without being enabled")
+	}
+	if cfg.IP.RequestsPerSecond <= 0 && cfg.DID.RequestsPerSecond <= 0 && !cfg.APIKeys.Enabled {
+		return nil, nil
+	}
+	limiter, err := ratelimit.NewLimiter(cfg.RedisURI)
+	if err != nil {
+		return nil, err
+	}
+	l := newRateLimiter(limiter, cfg)
+	if cfg.APIKeys.Enabled {
+		l.apiKeys, l.requireAPIKey = apiKeys, cfg.APIKeys.Required
+	}
+	return l, nil
+}
+
+func setupHandler(cfg *config.Config) (*gin.Engine, error) {
 	gin.ForceConsoleColor()
+	cors, err := CORS(cfg.CORS)
+	if err != nil {
+		return nil, err
+	}
 	middlewares := gin.HandlersChain{
+		requestID(),
 		otelgin.Middleware(config.ServiceName),
 		gin.Recovery(),
 		gin.ErrorLogger(),
-		CORS(),
+		cors,
 		logger(logrus.StandardLogger()),
 	}
+	env := cfg.ServerConfig.Environment
 	logrus.WithField("environment", env).Info("configuring server for environment")
 	switch env {
 	case config.EnvironmentDev:
@@ -101,18 +320,94 @@ func setupHandler(env config.Environment) *gin.Engine {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	handler := gin.New()
+	// handlers pass the gin context on as their context, which must see the request ID and span set on the request
+	handler.ContextWithFallback = true
+	if err = trustProxies(handler, cfg.ServerConfig, cfg.RateLimit); err != nil {
+		return nil, err
+	}
 	handler.Use(middlewares...)
-	return handler
+	return handler, nil
+}
+
+// AdminAPI sets up the operator API routes, which the route group must authenticate
+func AdminAPI(rg *gin.RouterGroup, adminRouter *AdminRouter) {
+	rg.GET("/records/:id", adminRouter.RecordMetadata)
+	rg.DELETE("/records/:id", adminRouter.DeleteRecord)
+	rg.POST("/records/:id/republish", adminRouter.RepublishRecord)
+	rg.GET("/failed", adminRouter.ListFailedRecords)
+	rg.GET("/quarantine", adminRouter.ListQuarantinedRecords)
+	rg.POST("/quarantine/:id/retry", adminRouter.RetryQuarantinedRecord)
+	rg.DELETE("/quarantine/:id", adminRouter.PurgeQuarantinedRecord)
+	rg.GET("/abuse", adminRouter.ListAbuseReviews)
+	rg.POST("/abuse/:id/approve", adminRouter.ApproveAbuseReview)
+	rg.DELETE("/abuse/:id", adminRouter.RejectAbuseReview)
+	rg.GET("/retained", adminRouter.ListRetainedDIDs)
+	rg.PUT("/retained/:did", adminRouter.RetainDID)
+	rg.DELETE("/retained/:did", adminRouter.ReleaseDID)
+	rg.POST("/config/reload", adminRouter.ReloadConfig)
+	rg.GET("/keys", adminRouter.ListAPIKeys)
+	rg.POST("/keys", adminRouter.MintAPIKey)
+	rg.DELETE("/keys/:id", adminRouter.RevokeAPIKey)
 }
 
-// DHTAPI sets up the relay API routes according to the spec https://did-dht.com/#gateway-api
-func DHTAPI(rg *gin.RouterGroup, service *service.DHTService) error {
-	dhtRouter, err := NewDHTRouter(service)
+// DHTAPI sets up the relay API routes according to the spec https://did-dht.com/#gateway-api. Admins, by the given
+// token or a client certificate, may republish any DID, as its owner may.
+func DHTAPI(rg *gin.RouterGroup, service *service.DHTService, rateLimit gin.HandlerFunc, challenger *retention.Challenger, maxPutBodyBytes int64, pkarrRelay bool, signer *httpsig.Signer, adminToken string) error {
+	dhtRouter, err := NewDHTRouter(service, maxPutBodyBytes)
 	if err != nil {
 		return util.LoggingErrorMsg(err, "could not instantiate dht router")
 	}
+	if pkarrRelay && challenger != nil {
+		return errors.New("a pkarr relay can't require retention challenges, which pkarr clients don't solve")
+	}
+	dhtRouter.pkarrRelay = pkarrRelay
+	dhtRouter.adminToken = adminToken
+	// every route is closed to the clients the access lists refuse
+	rg = rg.Group("", CheckClientAccess(service.Access()))
 
-	rg.PUT("/:id", dhtRouter.PutRecord)
-	rg.GET("/:id", dhtRouter.GetRecord)
+	// limited puts the rate limit, if any, in front of the routes publishing and resolving DIDs
+	limited := func(handlers ...gin.HandlerFunc) gin.HandlersChain {
+		if rateLimit == nil {
+			return handlers
+		}
+		return append(gin.HandlersChain{rateLimit}, handlers...)
+	}
+	// resolving puts the rate limit, if any, and then signing, if configured, in front of the routes resolving DIDs
+	resolving := func(handlers ...gin.HandlerFunc) gin.HandlersChain {
+		if signer == nil {
+			return limited(handlers...)
+		}
+		return limited(append(gin.HandlersChain{SignResponses(signer)}, handlers...)...)
+	}
+	// publishing holds the routes publishing records other than by PUT to the publish quotas of API keys
+	publishing := func(handlers ...gin.HandlerFunc) gin.HandlersChain {
+		return append(gin.HandlersChain{meterAsPublish}, limited(handlers...)...)
+	}
+	put := gin.HandlersChain{dhtRouter.PutRecord}
+	if challenger != nil {
+		put = gin.HandlersChain{RequireRetentionSolution(challenger), dhtRouter.PutRecord}
+	}
+	rg.PUT("/:id", limited(put...)...)
+	rg.DELETE("/:id", publishing(dhtRouter.DeactivateRecord)...)
+	rg.GET("/:id", resolving(ChooseResolutionStrategy, dhtRouter.GetRecord)...)
+	rg.GET("/:id/versions", resolving(dhtRouter.ListVersions)...)
+	rg.GET("/:id/versions/:versionId", resolving(dhtRouter.GetVersion)...)
+	rg.GET("/difficulty", NewRetentionRouter(challenger).Difficulty)
+	rg.GET("/signing-key", NewSigningRouter(signer).SigningKey)
+	rg.GET("/gateways", limited(NewRegistryRouter(service).ListGateways)...)
+	rg.GET("/dids/types/:id", dhtRouter.ListDIDsByType)
+	rg.POST("/dids/resolve", resolving(ChooseResolutionStrategy, dhtRouter.ResolveDIDs)...)
+	rg.GET("/dids/:did", resolving(ChooseResolutionStrategy, dhtRouter.ResolveDID)...)
+	rg.GET("/1.0/identifiers/:did", resolving(ChooseResolutionStrategy, dhtRouter.ResolveIdentifier)...)
+	rg.GET("/dids/events", limited(dhtRouter.Subscribe)...)
+	rg.GET("/dids/:did/events", limited(dhtRouter.DIDEvents)...)
+	rg.POST("/dids/:did/republish", limited(dhtRouter.RepublishDID)...)
+	// records published through the registrar carry no retention solution, so gateways requiring one don't serve it
+	if challenger == nil {
+		registrarRouter := NewRegistrarRouter(service)
+		rg.POST("/1.0/create", publishing(registrarRouter.Create)...)
+		rg.POST("/1.0/update", publishing(registrarRouter.Update)...)
+		rg.POST("/1.0/deactivate", publishing(registrarRouter.Deactivate)...)
+	}
 	return nil
 }
@@ -1,18 +1,30 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/goccy/go-json"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/abuse"
 	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/service"
 )
 
 const (
@@ -26,7 +38,8 @@ func TestHealthCheckAPI(t *testing.T) {
 	serviceConfig.ServerConfig.BaseURL = testServerURL
 	assert.NoError(t, err)
 
-	server, err := NewServer(serviceConfig, shutdown, dht.NewTestDHT(t))
+	d := dht.NewTestDHT(t)
+	server, err := NewServer(serviceConfig, shutdown, d)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, server)
 
@@ -36,17 +49,285 @@ func TestHealthCheckAPI(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	c := newRequestContext(w, req)
-	Health(c)
+	NewHealthRouter(d, nil, config.ReadinessConfig{}).Health(c)
 	assert.True(t, is2xxResponse(w.Code))
 
 	var resp GetHealthCheckResponse
 	err = json.NewDecoder(w.Body).Decode(&resp)
 	assert.NoError(t, err)
 	assert.Equal(t, HealthOK, resp.Status)
+	assert.NotNil(t, resp.DHT)
+	assert.False(t, resp.DHT.Reachable)
 
 	shutdown <- os.Interrupt
 }
 
+func TestReadinessAPI(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "readiness", config.PeeringConfig{})
+	ready := func(t *testing.T, router *HealthRouter) (int, GetReadinessResponse) {
+		w := httptest.NewRecorder()
+		router.Ready(newRequestContext(w, httptest.NewRequest(http.MethodGet, testServerURL+"/health/ready", nil)))
+		var resp GetReadinessResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return w.Code, resp
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		require.Eventually(t, svc.RepublishScheduleLoaded, 5*time.Second, 10*time.Millisecond)
+		code, resp := ready(t, NewHealthRouter(nil, svc, config.ReadinessConfig{}))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, HealthOK, resp.Status)
+		assert.Equal(t, HealthOK, resp.Dependencies[DependencyStorage].Status)
+		assert.Equal(t, HealthOK, resp.Dependencies[DependencyRepublisher].Status)
+	})
+
+	t.Run("routing table too small", func(t *testing.T) {
+		d := dht.NewTestDHT(t)
+		defer d.Close()
+
+		code, resp := ready(t, NewHealthRouter(d, svc, config.ReadinessConfig{MinRoutingTableNodes: 1000}))
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, HealthUnavailable, resp.Status)
+		assert.Equal(t, HealthUnavailable, resp.Dependencies[DependencyDHT].Status)
+		assert.Contains(t, resp.Dependencies[DependencyDHT].Message, "fewer than the 1000 required")
+		assert.Equal(t, HealthOK, resp.Dependencies[DependencyStorage].Status)
+	})
+
+	t.Run("storage unreachable", func(t *testing.T) {
+		closed, _ := simulatedDHTService(t, "readiness-closed", config.PeeringConfig{})
+		closed.Close()
+
+		code, resp := ready(t, NewHealthRouter(nil, closed, config.ReadinessConfig{}))
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, HealthUnavailable, resp.Dependencies[DependencyStorage].Status)
+	})
+}
+
+func TestDebugDHTAPI(t *testing.T) {
+	d := dht.NewTestDHT(t)
+	defer d.Close()
+
+	req := httptest.NewRequest(http.MethodGet, testServerURL+"/debug/dht", nil)
+	w := httptest.NewRecorder()
+
+	c := newRequestContext(w, req)
+	NewDebugRouter(d).DHT(c)
+	assert.True(t, is2xxResponse(w.Code))
+
+	var resp dht.RoutingTable
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	assert.NoError(t, err)
+	assert.Equal(t, d.Addr().String(), resp.Addr)
+	assert.NotEmpty(t, resp.NodeID)
+}
+
+func TestAdminRecordMetadataAPI(t *testing.T) {
+	dhtSvc := testDHTService(t)
+	defer dhtSvc.Close()
+	dhtRouter, err := NewDHTRouter(dhtSvc, 0)
+	require.NoError(t, err)
+	adminRouter := NewAdminRouter(dhtSvc, nil)
+
+	didID, reqData := generateDIDPutRequest(t)
+	suffix, err := did.DHT(didID).Suffix()
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+	dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+	require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/admin/records/%s", testServerURL, suffix), nil)
+	adminRouter.RecordMetadata(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+	require.Equal(t, http.StatusOK, w.Code, "unexpected %s", w.Result().Status)
+
+	var metadata dht.RecordMetadata
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&metadata))
+	assert.False(t, metadata.Created.IsZero())
+	assert.Nil(t, metadata.LastRepublished)
+
+	missing, _ := generateDIDPutRequest(t)
+	missingSuffix, err := did.DHT(missing).Suffix()
+	require.NoError(t, err)
+	for id, status := range map[string]int{missingSuffix: http.StatusNotFound, "invalid": http.StatusBadRequest} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s/admin/records/%s", testServerURL, id), nil)
+		adminRouter.RecordMetadata(newRequestContextWithParams(w, req, map[string]string{IDParam: id}))
+		assert.Equal(t, status, w.Code, id)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	svc, sim := simulatedDHTService(t, "admin", config.PeeringConfig{})
+	var reloads int
+	handler := gin.New()
+	AdminAPI(handler.Group("/admin", AdminAuth("secret")), NewAdminRouter(svc, func(context.Context) error {
+		reloads++
+		return nil
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	call := func(t *testing.T, method, path string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/admin"+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	didID, reqData := generateDIDPutRequest(t)
+	suffix, err := did.DHT(didID).Suffix()
+	require.NoError(t, err)
+	dhtRouter, err := NewDHTRouter(svc, 0)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", testServerURL, suffix), bytes.NewReader(reqData))
+	dhtRouter.PutRecord(newRequestContextWithParams(w, req, map[string]string{IDParam: suffix}))
+	require.True(t, is2xxResponse(w.Code), "unexpected %s", w.Result().Status)
+
+	t.Run("requests without the token are rejected", func(t *testing.T) {
+		for _, authorization := range []string{"", "Bearer wrong", "secret"} {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/admin/failed", nil)
+			require.NoError(t, err)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, authorization)
+			assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+		}
+	})
+
+	t.Run("republish a record now", func(t *testing.T) {
+		puts := sim.Puts()
+		assert.Equal(t, http.StatusNoContent, call(t, http.MethodPost, "/records/"+suffix+"/republish").StatusCode)
+		assert.Equal(t, puts+1, sim.Puts())
+
+		metadata, err := svc.GetRecordMetadata(context.Background(), suffix)
+		require.NoError(t, err)
+		require.NotNil(t, metadata)
+		assert.NotNil(t, metadata.LastRepublished)
+	})
+
+	t.Run("list failed records", func(t *testing.T) {
+		resp := call(t, http.MethodGet, "/failed")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var failed []dht.FailedRecord
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&failed))
+		assert.NotNil(t, failed)
+	})
+
+	t.Run("records that aren't quarantined can't be retried or purged", func(t *testing.T) {
+		resp := call(t, http.MethodGet, "/quarantine")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var quarantined []service.QuarantinedRecord
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&quarantined))
+		assert.NotNil(t, quarantined)
+		assert.Empty(t, quarantined)
+
+		assert.Equal(t, http.StatusNotFound, call(t, http.MethodPost, "/quarantine/"+suffix+"/retry").StatusCode)
+		assert.Equal(t, http.StatusNotFound, call(t, http.MethodDelete, "/quarantine/"+suffix).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, call(t, http.MethodDelete, "/quarantine/invalid").StatusCode)
+	})
+
+	t.Run("records that aren't listed for abuse review can't be approved or rejected", func(t *testing.T) {
+		resp := call(t, http.MethodGet, "/abuse")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var reviews []abuse.Review
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&reviews))
+		assert.NotNil(t, reviews)
+		assert.Empty(t, reviews)
+
+		assert.Equal(t, http.StatusNotFound, call(t, http.MethodPost, "/abuse/"+suffix+"/approve").StatusCode)
+		assert.Equal(t, http.StatusNotFound, call(t, http.MethodDelete, "/abuse/"+suffix).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, call(t, http.MethodDelete, "/abuse/invalid").StatusCode)
+	})
+
+	t.Run("retain and release a did", func(t *testing.T) {
+		listRetained := func(t *testing.T) []string {
+			resp := call(t, http.MethodGet, "/retained")
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			var dids []string
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&dids))
+			return dids
+		}
+
+		assert.Equal(t, http.StatusNoContent, call(t, http.MethodPut, "/retained/"+didID).StatusCode)
+		assert.Equal(t, []string{didID}, listRetained(t))
+		assert.Equal(t, http.StatusNoContent, call(t, http.MethodDelete, "/retained/"+suffix).StatusCode)
+		assert.Empty(t, listRetained(t))
+		assert.Equal(t, http.StatusBadRequest, call(t, http.MethodPut, "/retained/did:example:123").StatusCode)
+	})
+
+	t.Run("reload the config", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, call(t, http.MethodPost, "/config/reload").StatusCode)
+		assert.Equal(t, 1, reloads)
+	})
+
+	t.Run("delete a record", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, call(t, http.MethodDelete, "/records/"+suffix).StatusCode)
+		assert.Equal(t, http.StatusNotFound, call(t, http.MethodGet, "/records/"+suffix).StatusCode)
+		assert.Equal(t, http.StatusNotFound, call(t, http.MethodDelete, "/records/"+suffix).StatusCode)
+		assert.Equal(t, http.StatusNotFound, call(t, http.MethodPost, "/records/"+suffix+"/republish").StatusCode)
+		assert.Equal(t, http.StatusBadRequest, call(t, http.MethodDelete, "/records/invalid").StatusCode)
+	})
+}
+
+func TestAdminAuthClientCertificate(t *testing.T) {
+	handler := gin.New()
+	handler.GET("/admin", AdminAuth(""), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for verified, status := range map[bool]int{true: http.StatusOK, false: http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "https://gateway.example.com/admin", nil)
+		req.TLS = &tls.ConnectionState{}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{{}}}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code)
+	}
+
+	// an empty token admits no requests by token
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestReloadConfig(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "reload", config.PeeringConfig{})
+	ctx := context.Background()
+	defer logrus.SetLevel(logrus.GetLevel())
+
+	assert.ErrorContains(t, reloadConfig(ctx, "", svc), "default config")
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+[log]
+level = "warn"
+
+[gc]
+retention_hours = 24
+cron = "0 4 * * *"
+`), 0600))
+	require.NoError(t, reloadConfig(ctx, path, svc))
+	assert.Equal(t, logrus.WarnLevel, logrus.GetLevel())
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+[log]
+level = "loud"
+`), 0600))
+	assert.ErrorContains(t, reloadConfig(ctx, path, svc), "invalid log level")
+	assert.Equal(t, logrus.WarnLevel, logrus.GetLevel())
+}
+
 // Is2xxResponse returns true if the given status code is a 2xx response
 func is2xxResponse(statusCode int) bool {
 	return statusCode/100 == 2
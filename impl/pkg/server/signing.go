@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/httpsig"
+)
+
+// responseSigner returns the signer of resolution responses, or nil if no signing key is configured
+func responseSigner() (*httpsig.Signer, error) {
+	encodedKey, ok := os.LookupEnv(config.ResponseSigningKey.String())
+	if !ok {
+		return nil, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s: must be base64 encoded", config.ResponseSigningKey)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.Errorf("invalid %s: must be a %d byte ed25519 seed", config.ResponseSigningKey, ed25519.SeedSize)
+	}
+	return httpsig.NewSigner(ed25519.NewKeyFromSeed(seed)), nil
+}
+
+// SignResponses holds the responses of the handlers after it until they finish, then signs them with the signer
+func SignResponses(signer *httpsig.Signer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &signingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		signer.SignResponse(c.Request, w.Status(), w.Header(), w.body.Bytes())
+		w.ResponseWriter.WriteHeaderNow()
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
+
+// signingWriter buffers a response, including its headers, until it's signed
+type signingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *signingWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *signingWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *signingWriter) WriteHeaderNow() {}
+
+func (w *signingWriter) Flush() {}
+
+// SigningRouter serves the key resolution responses are signed with
+type SigningRouter struct {
+	signer *httpsig.Signer
+}
+
+// NewSigningRouter returns a router serving the signer's key; a nil signer serves none
+func NewSigningRouter(signer *httpsig.Signer) *SigningRouter {
+	return &SigningRouter{signer: signer}
+}
+
+// SigningKeyResponse is the public key resolution responses are signed with, as an ed25519 JWK
+type SigningKeyResponse struct {
+	KTY string `json:"kty"`
+	CRV string `json:"crv"`
+	X   string `json:"x"`
+	// KID is the JWK thumbprint of the key, which signatures name as their keyid
+	KID string `json:"kid"`
+	Alg string `json:"alg"`
+}
+
+// SigningKey godoc
+//
+//	@Summary		Get the response signing key
+//	@Description	SigningKey returns the ed25519 public key the gateway signs resolution responses with, as a JWK. Signed responses carry HTTP Message Signatures (RFC 9421) labelled diddht, covering the status, the Content-Digest of the body, and the method, path, and query of the request.
+//	@Tags			DHT
+//	@Produce		json
+//	@Success		200	{object}	SigningKeyResponse
+//	@Failure		403	{string}	string	"Client is denied by the gateway"
+//	@Failure		501	{string}	string	"Responses aren't signed by this gateway"
+//	@Router			/signing-key [get]
+func (r *SigningRouter) SigningKey(c *gin.Context) {
+	if r.signer == nil {
+		Respond(c, errors.New("responses aren't signed by this gateway"), http.StatusNotImplemented)
+		return
+	}
+	Respond(c, SigningKeyResponse{
+		KTY: "OKP",
+		CRV: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(r.signer.PublicKey()),
+		KID: r.signer.KeyID(),
+		Alg: "EdDSA",
+	}, http.StatusOK)
+}
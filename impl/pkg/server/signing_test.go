@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/httpsig"
+)
+
+func TestSignedResponses(t *testing.T) {
+	seed := bytes.Repeat([]byte{1}, ed25519.SeedSize)
+	t.Setenv(config.ResponseSigningKey.String(), base64.StdEncoding.EncodeToString(seed))
+	signer, err := responseSigner()
+	require.NoError(t, err)
+	require.NotNil(t, signer)
+
+	svc, _ := simulatedDHTService(t, "signing", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, signer, ""))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/signing-key")
+	require.NoError(t, err)
+	var key SigningKeyResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&key))
+	_ = resp.Body.Close()
+	x, err := base64.RawURLEncoding.DecodeString(key.X)
+	require.NoError(t, err)
+	pub := ed25519.PublicKey(x)
+	assert.Equal(t, ed25519.NewKeyFromSeed(seed).Public(), pub)
+	assert.Equal(t, httpsig.KeyID(pub), key.KID)
+
+	didID, body := generateDIDPutRequest(t)
+	suffix := strings.TrimPrefix(didID, "did:dht:")
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/"+suffix, bytes.NewReader(body))
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	// only resolutions are signed
+	assert.Empty(t, resp.Header.Get(httpsig.SignatureHeader))
+
+	for _, path := range []string{"/" + suffix, "/dids/" + didID, "/" + suffix + "/versions", "/dids/" + didID + "o"} {
+		resp, err = http.Get(srv.URL + path)
+		require.NoError(t, err)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.NoError(t, httpsig.VerifyResponse(resp, got, pub), path)
+		if path == "/"+suffix {
+			assert.Equal(t, body, got)
+		}
+	}
+}
+
+func TestResponseSigningDisabled(t *testing.T) {
+	signer, err := responseSigner()
+	require.NoError(t, err)
+	assert.Nil(t, signer)
+
+	handler := gin.New()
+	handler.GET("/signing-key", NewSigningRouter(nil).SigningKey)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/signing-key", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	t.Setenv(config.ResponseSigningKey.String(), base64.StdEncoding.EncodeToString([]byte("short")))
+	_, err = responseSigner()
+	assert.ErrorContains(t, err, "32 byte ed25519 seed")
+}
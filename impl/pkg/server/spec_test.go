@@ -0,0 +1,60 @@
+package server
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// TestSpecCoversRoutes checks the OpenAPI document generated from the handlers' annotations documents every route
+// the server serves, and only those, so clients generated from it can call the whole API
+func TestSpecCoversRoutes(t *testing.T) {
+	specBytes, err := os.ReadFile("../../docs/openapi.yaml")
+	require.NoError(t, err)
+	var spec struct {
+		OpenAPI string                    `yaml:"openapi"`
+		Paths   map[string]map[string]any `yaml:"paths"`
+	}
+	require.NoError(t, yaml.Unmarshal(specBytes, &spec))
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."), "not an OpenAPI 3 document")
+
+	t.Setenv(config.AdminToken.String(), "token")
+	t.Setenv(config.PeeringSecret.String(), "secret")
+	cfg := config.GetDefaultConfig()
+	cfg.PeeringConfig.Peers = []string{"http://127.0.0.1:1"}
+	cfg.ServerConfig.StorageURI = "bolt://spec-test.db"
+	cfg.ServerConfig.DebugEndpoints = true
+	cfg.ServerConfig.AdminEndpoints = true
+	cfg.ServerConfig.MetricsEndpoint = true
+	t.Cleanup(func() { _ = os.Remove("spec-test.db") })
+	d := dht.NewTestDHT(t)
+	s, err := NewServer(&cfg, make(chan os.Signal, 1), d)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	pathParam := regexp.MustCompile(`[:*](\w+)`)
+	routed := make(map[string]bool)
+	for _, route := range s.handler.Routes() {
+		if route.Path == "/openapi.yaml" || route.Path == "/swagger.yaml" ||
+			strings.HasPrefix(route.Path, "/spec") || strings.HasPrefix(route.Path, "/swagger/") {
+			continue
+		}
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		method := strings.ToLower(route.Method)
+		routed[method+" "+path] = true
+		assert.Contains(t, spec.Paths[path], method, "%s %s is not documented", route.Method, path)
+	}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			assert.True(t, routed[method+" "+path], "%s %s is documented but not served", strings.ToUpper(method), path)
+		}
+	}
+}
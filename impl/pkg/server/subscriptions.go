@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// eventKeepAliveInterval is how often a comment is sent on an idle event stream, so proxies don't close it
+const eventKeepAliveInterval = 30 * time.Second
+
+// Actions of subscription requests
+const (
+	SubscribeAction   = "subscribe"
+	UnsubscribeAction = "unsubscribe"
+)
+
+// DIDEvents godoc
+//
+//	@Summary		Stream a DID's updates
+//	@Description	DIDEvents streams a Server-Sent Event for each new version of the DID the gateway sees, whether published to the gateway, sent by a peer gateway, or found on the DHT while resolving or republishing it. Events are named update, have the version's sequence number as their ID, and hold a pubsub.Event as JSON.
+//	@Tags			DHT
+//	@Produce		text/event-stream
+//	@Param			did	path		string	true	"DID, or the z-base-32 encoded key of the DID"
+//	@Success		200	{object}	pubsub.Event
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		403	{string}	string	"Client is denied by the gateway"
+//	@Failure		429	{string}	string	"Too many requests"
+//	@Failure		500	{string}	string	"Internal server error"
+//	@Router			/dids/{did}/events [get]
+func (r *DHTRouter) DIDEvents(c *gin.Context) {
+	ctx, span := telemetry.GetTracer().Start(c, "DHTHTTP.DIDEvents")
+	defer span.End()
+
+	id, err := didSuffix(c.Param(DIDParam))
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("invalid did: %s", c.Param(DIDParam)), http.StatusBadRequest)
+		return
+	}
+
+	sub, err := r.service.Subscribe(ctx, pubsub.Filter{IDs: []string{id}})
+	if err != nil {
+		LoggingRespondErrWithMsg(c, err, fmt.Sprintf("failed to subscribe to did: %s", id), http.StatusInternalServerError)
+		return
+	}
+	defer sub.Close()
+
+	// the stream outlives the server's write timeout
+	if err = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logrus.WithContext(ctx).WithError(err).Warn("failed to clear write deadline for event stream")
+	}
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err = c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err = sse.Encode(c.Writer, sse.Event{
+				Id:    strconv.FormatInt(event.Seq, 10),
+				Event: "update",
+				Data:  event,
+			}); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// SubscriptionRequest is a message from a WebSocket subscriber changing the DIDs and types it is subscribed to
+type SubscriptionRequest struct {
+	// Action is subscribe or unsubscribe
+	Action string `json:"action"`
+	// DIDs are did:dht DIDs or their z-base-32 encoded keys
+	DIDs []string `json:"dids,omitempty"`
+	// Types are DID type indexes, such as 1 for Organization
+	Types []int `json:"types,omitempty"`
+}
+
+// SubscriptionMessage is a message to a WebSocket subscriber: a new version of a record it is subscribed to, what it
+// is subscribed to after a request, or why a request was rejected
+type SubscriptionMessage struct {
+	Update     *pubsub.Event  `json:"update,omitempty"`
+	Subscribed *pubsub.Filter `json:"subscribed,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Subscribe godoc
+//
+//	@Summary		Subscribe to DID updates over a WebSocket
+//	@Description	Subscribe upgrades to a WebSocket on which the client sends SubscriptionRequests to subscribe to and unsubscribe from DIDs and DID types, and receives a SubscriptionMessage acknowledging each request, then one for each new version of a subscribed DID, or of a DID of a subscribed type, that the gateway sees.
+//	@Tags			DHT
+//	@Success		101	{object}	SubscriptionMessage
+//	@Failure		400	{string}	string	"Bad request"
+//	@Failure		403	{string}	string	"Client is denied by the gateway"
+//	@Failure		429	{string}	string	"Too many requests"
+//	@Router			/dids/events [get]
+func (r *DHTRouter) Subscribe(c *gin.Context) {
+	websocket.Server{
+		// any origin may subscribe, as any origin may call the rest of the API
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   r.serveSubscription,
+	}.ServeHTTP(c.Writer, c.Request)
+}
+
+// serveSubscription relays a WebSocket subscriber's requests to its subscription, and the subscription's events to
+// the subscriber, until either side closes
+func (r *DHTRouter) serveSubscription(ws *websocket.Conn) {
+	defer ws.Close()
+	ctx, span := telemetry.GetTracer().Start(ws.Request().Context(), "DHTHTTP.Subscribe")
+	defer span.End()
+
+	// the connection outlives the server's read and write timeouts
+	if err := ws.SetDeadline(time.Time{}); err != nil {
+		logrus.WithContext(ctx).WithError(err).Warn("failed to clear deadline for subscription")
+	}
+
+	sub, err := r.service.Subscribe(ctx, pubsub.Filter{})
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("failed to subscribe")
+		_ = websocket.JSON.Send(ws, SubscriptionMessage{Error: "failed to subscribe"})
+		return
+	}
+	defer sub.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var request []byte
+			if err := websocket.Message.Receive(ws, &request); err != nil {
+				return
+			}
+			if err := websocket.JSON.Send(ws, r.handleSubscriptionRequest(ctx, sub, request)); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err = websocket.JSON.Send(ws, SubscriptionMessage{Update: &event}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleSubscriptionRequest applies the subscriber's request to its subscription, returning the message
+// acknowledging it
+func (r *DHTRouter) handleSubscriptionRequest(ctx context.Context, sub *pubsub.Subscription, data []byte) SubscriptionMessage {
+	var request SubscriptionRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return SubscriptionMessage{Error: fmt.Sprintf("invalid request: %s", err)}
+	}
+
+	filter := pubsub.Filter{Types: request.Types}
+	for _, d := range request.DIDs {
+		id, err := didSuffix(d)
+		if err != nil {
+			return SubscriptionMessage{Error: fmt.Sprintf("invalid did %s: %s", d, err)}
+		}
+		filter.IDs = append(filter.IDs, id)
+	}
+	for _, typ := range request.Types {
+		if typ < 0 {
+			return SubscriptionMessage{Error: fmt.Sprintf("invalid type: %d", typ)}
+		}
+	}
+
+	switch request.Action {
+	case SubscribeAction:
+		if err := r.service.AddToSubscription(ctx, sub, filter); err != nil {
+			return SubscriptionMessage{Error: err.Error()}
+		}
+	case UnsubscribeAction:
+		sub.Remove(filter)
+	default:
+		return SubscriptionMessage{Error: fmt.Sprintf("unknown action %q, expected %s or %s", request.Action, SubscribeAction, UnsubscribeAction)}
+	}
+	subscribed := sub.Filter()
+	return SubscriptionMessage{Subscribed: &subscribed}
+}
+
+// didSuffix returns the z-base-32 encoded key of a DID given as a did:dht DID or as the key itself
+func didSuffix(id string) (string, error) {
+	id = strings.TrimPrefix(id, did.Prefix+":")
+	_, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return "", err
+	}
+	if len(salt) > 0 {
+		return "", errors.New("salted records aren't dids")
+	}
+	return id, nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
+)
+
+func TestDIDEvents(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "events", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	first, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(first)
+	require.NoError(t, svc.PublishDHT(context.Background(), record.ID(), record))
+
+	for _, bad := range []string{"did:example:123", record.ID() + ".c2FsdA", "invalid"} {
+		resp, err := http.Get(fmt.Sprintf("%s/dids/%s/events", srv.URL, bad))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, bad)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/dids/%s/events", srv.URL, doc.ID))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	second := *first
+	second.Seq++
+	second.Sign(sk)
+	require.NoError(t, svc.PublishDHT(context.Background(), record.ID(), dht.RecordFromBEP44(&second)))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			break
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	require.Len(t, lines, 3)
+	assert.Equal(t, fmt.Sprintf("id:%d", second.Seq), lines[0])
+	assert.Equal(t, "event:update", lines[1])
+	var event pubsub.Event
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data:")), &event))
+	assert.Equal(t, record.ID(), event.ID)
+	assert.Equal(t, second.Seq, event.Seq)
+	assert.Equal(t, pubsub.SourcePublish, event.Source)
+
+	// closing subscriptions ends the stream
+	svc.CloseSubscriptions()
+	_, err = io.ReadAll(reader)
+	assert.NoError(t, err)
+}
+
+func TestSubscribe(t *testing.T) {
+	svc, _ := simulatedDHTService(t, "subscribe", config.PeeringConfig{})
+	handler := gin.New()
+	require.NoError(t, DHTAPI(&handler.RouterGroup, svc, nil, nil, 0, false, nil, ""))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	ws, err := websocket.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/dids/events", "", srv.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+	require.NoError(t, ws.SetDeadline(time.Now().Add(5*time.Second)))
+
+	request := func(t *testing.T, request SubscriptionRequest) SubscriptionMessage {
+		require.NoError(t, websocket.JSON.Send(ws, request))
+		var msg SubscriptionMessage
+		require.NoError(t, websocket.JSON.Receive(ws, &msg))
+		return msg
+	}
+	publish := func(t *testing.T, types []did.TypeIndex) (ed25519.PrivateKey, *bep44.Put) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, types, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		record := dht.RecordFromBEP44(putMsg)
+		require.NoError(t, svc.PublishDHT(context.Background(), record.ID(), record))
+		return sk, putMsg
+	}
+
+	sk, putMsg := publish(t, nil)
+	subscribed := dht.RecordFromBEP44(putMsg).ID()
+	msg := request(t, SubscriptionRequest{Action: SubscribeAction, DIDs: []string{did.Prefix + ":" + subscribed}, Types: []int{7}})
+	require.Empty(t, msg.Error)
+	require.NotNil(t, msg.Subscribed)
+	assert.Equal(t, pubsub.Filter{IDs: []string{subscribed}, Types: []int{7}}, *msg.Subscribed)
+
+	for _, bad := range []SubscriptionRequest{
+		{Action: "watch", Types: []int{1}},
+		{Action: SubscribeAction, DIDs: []string{"did:example:123"}},
+		{Action: SubscribeAction, Types: []int{-1}},
+	} {
+		msg = request(t, bad)
+		assert.NotEmpty(t, msg.Error, "%+v", bad)
+	}
+
+	// a new version of the subscribed did, then a did of a subscribed type; dids of other types aren't sent
+	second := *putMsg
+	second.Seq++
+	second.Sign(sk)
+	require.NoError(t, svc.PublishDHT(context.Background(), subscribed, dht.RecordFromBEP44(&second)))
+	publish(t, []did.TypeIndex{3})
+	_, typed := publish(t, []did.TypeIndex{7})
+
+	require.NoError(t, websocket.JSON.Receive(ws, &msg))
+	require.NotNil(t, msg.Update)
+	assert.Equal(t, subscribed, msg.Update.ID)
+	assert.Equal(t, second.Seq, msg.Update.Seq)
+	assert.Equal(t, pubsub.SourcePublish, msg.Update.Source)
+
+	require.NoError(t, websocket.JSON.Receive(ws, &msg))
+	require.NotNil(t, msg.Update)
+	assert.Equal(t, dht.RecordFromBEP44(typed).ID(), msg.Update.ID)
+	assert.Equal(t, []int{7}, msg.Update.Types)
+
+	msg = request(t, SubscriptionRequest{Action: UnsubscribeAction, Types: []int{7}})
+	require.NotNil(t, msg.Subscribed)
+	assert.Equal(t, pubsub.Filter{IDs: []string{subscribed}}, *msg.Subscribed)
+
+	// closing subscriptions closes the connection
+	svc.CloseSubscriptions()
+	_, err = io.ReadAll(ws)
+	assert.NoError(t, err)
+}
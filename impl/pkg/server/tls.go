@@ -0,0 +1,98 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/TBD54566975/did-dht/config"
+)
+
+// tlsConfig returns the TLS config for the server, or nil when it serves plain HTTP. The certificate is loaded from
+// the configured files, or obtained and renewed from an ACME CA for the configured domains. Client certificates are
+// requested but not required, and verified against the admin client CAs, if any.
+func tlsConfig(cfg config.ServerConfig) (*tls.Config, error) {
+	tlsCfg, err := certificateConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg == nil {
+		if cfg.AdminClientCAFile != "" {
+			return nil, errors.New("admin client CAs require a tls certificate")
+		}
+		return nil, nil
+	}
+	if cfg.AdminClientCAFile == "" {
+		if cfg.AdminRequireClientCert {
+			return nil, errors.New("requiring admin client certificates requires admin client CAs")
+		}
+		return tlsCfg, nil
+	}
+	pem, err := os.ReadFile(cfg.AdminClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read admin client CAs")
+	}
+	tlsCfg.ClientCAs = x509.NewCertPool()
+	if !tlsCfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.AdminClientCAFile)
+	}
+	tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsCfg, nil
+}
+
+// certificateConfig returns the TLS config serving the configured certificate, or nil if none is configured
+func certificateConfig(cfg config.ServerConfig) (*tls.Config, error) {
+	switch {
+	case cfg.TLSCertFile != "" && len(cfg.ACMEDomains) > 0:
+		return nil, errors.New("a tls certificate file can't be combined with acme domains")
+	case cfg.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load tls certificate")
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	case len(cfg.ACMEDomains) > 0:
+		if cfg.ACMECacheDir == "" {
+			return nil, errors.New("acme domains require an acme cache dir")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEDirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+		}
+		// the manager's config answers the CA's TLS-ALPN-01 challenges, and gets and renews certificates on demand
+		tlsCfg := manager.TLSConfig()
+		tlsCfg.MinVersion = tls.VersionTLS12
+		return tlsCfg, nil
+	default:
+		return nil, nil
+	}
+}
+
+// trustProxies sets the proxies whose client IP headers the handler believes, along with the headers they give the
+// client IP in. Any other client's IP is its connection's address, so clients can't spoof their IP to dodge rate
+// limits and access lists.
+func trustProxies(handler *gin.Engine, cfg config.ServerConfig, rateLimit config.RateLimitConfig) error {
+	proxies := append(append([]string{}, cfg.TrustedProxies...), rateLimit.TrustedProxies...)
+	if err := handler.SetTrustedProxies(proxies); err != nil {
+		return errors.Wrap(err, "invalid trusted proxies")
+	}
+	if len(cfg.ClientIPHeaders) > 0 {
+		handler.RemoteIPHeaders = make([]string, 0, len(cfg.ClientIPHeaders))
+		for _, header := range cfg.ClientIPHeaders {
+			handler.RemoteIPHeaders = append(handler.RemoteIPHeaders, http.CanonicalHeaderKey(header))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+
+	"github.com/TBD54566975/did-dht/config"
+)
+
+// testCertificate issues a certificate for localhost signed by the parent, or a self-signed CA if the parent is nil
+func testCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+// writeCertificate writes the PEM encoded certificate and key to files, returning their paths
+func writeCertificate(t *testing.T, cert *x509.Certificate, key *ecdsa.PrivateKey) (string, string) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600))
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	tlsCfg, err := tlsConfig(config.ServerConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, tlsCfg)
+
+	_, err = tlsConfig(config.ServerConfig{AdminClientCAFile: "ca.pem"})
+	assert.ErrorContains(t, err, "require a tls certificate")
+
+	_, err = tlsConfig(config.ServerConfig{TLSCertFile: "missing.pem", TLSKeyFile: "missing.pem"})
+	assert.ErrorContains(t, err, "failed to load tls certificate")
+
+	ca, caKey := testCertificate(t, nil, nil)
+	cert, key := testCertificate(t, ca, caKey)
+	certFile, keyFile := writeCertificate(t, cert, key)
+	empty := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0600))
+	_, err = tlsConfig(config.ServerConfig{TLSCertFile: certFile, TLSKeyFile: keyFile, AdminClientCAFile: empty})
+	assert.ErrorContains(t, err, "no certificates found")
+
+	tlsCfg, err = tlsConfig(config.ServerConfig{TLSCertFile: certFile, TLSKeyFile: keyFile})
+	require.NoError(t, err)
+	assert.Len(t, tlsCfg.Certificates, 1)
+	assert.Nil(t, tlsCfg.ClientCAs)
+
+	_, err = tlsConfig(config.ServerConfig{TLSCertFile: certFile, TLSKeyFile: keyFile, AdminRequireClientCert: true})
+	assert.ErrorContains(t, err, "requires admin client CAs")
+
+	t.Run("acme", func(t *testing.T) {
+		_, err := tlsConfig(config.ServerConfig{TLSCertFile: certFile, TLSKeyFile: keyFile, ACMEDomains: []string{"gateway.example.com"}})
+		assert.ErrorContains(t, err, "can't be combined")
+
+		_, err = tlsConfig(config.ServerConfig{ACMEDomains: []string{"gateway.example.com"}})
+		assert.ErrorContains(t, err, "require an acme cache dir")
+
+		tlsCfg, err := tlsConfig(config.ServerConfig{ACMEDomains: []string{"gateway.example.com"}, ACMECacheDir: t.TempDir()})
+		require.NoError(t, err)
+		assert.NotNil(t, tlsCfg.GetCertificate)
+		assert.Contains(t, tlsCfg.NextProtos, acme.ALPNProto, "tls-alpn-01 challenges are answered")
+
+		// certificates are only obtained for the configured domains
+		_, err = tlsCfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+		assert.Error(t, err)
+	})
+}
+
+func TestAdminMutualTLS(t *testing.T) {
+	ca, caKey := testCertificate(t, nil, nil)
+	cert, key := testCertificate(t, ca, caKey)
+	certFile, keyFile := writeCertificate(t, cert, key)
+	caFile, _ := writeCertificate(t, ca, caKey)
+	tlsCfg, err := tlsConfig(config.ServerConfig{TLSCertFile: certFile, TLSKeyFile: keyFile, AdminClientCAFile: caFile, AdminRequireClientCert: true})
+	require.NoError(t, err)
+
+	// with client certificates required, the admin routes are given no token
+	handler := gin.New()
+	handler.GET("/admin", AdminAuth(""), func(c *gin.Context) { c.Status(http.StatusOK) })
+	handler.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = tlsCfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(path string, certs ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get("/"), "public routes don't need a client certificate")
+	assert.Equal(t, http.StatusUnauthorized, get("/admin"))
+
+	clientCert, clientKey := testCertificate(t, ca, caKey)
+	assert.Equal(t, http.StatusOK, get("/admin", tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}))
+
+	// certificates from other CAs are refused in the handshake
+	otherCA, otherKey := testCertificate(t, nil, nil)
+	otherCert, otherCertKey := testCertificate(t, otherCA, otherKey)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{otherCert.Raw}, PrivateKey: otherCertKey}},
+	}}}
+	_, err = client.Get(srv.URL + "/admin")
+	assert.Error(t, err)
+}
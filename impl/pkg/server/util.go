@@ -3,7 +3,6 @@ package server
 import (
 	"net/http"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -72,19 +71,3 @@ func GetParam(c *gin.Context, param string) *string {
 	}
 	return &got
 }
-
-func CORS() gin.HandlerFunc {
-	return cors.New(cors.Config{
-		AllowOrigins: []string{"*"},
-		AllowMethods: []string{
-			http.MethodHead,
-			http.MethodGet,
-			http.MethodPost,
-			http.MethodPut,
-			http.MethodPatch,
-			http.MethodDelete,
-		},
-		AllowHeaders:     []string{"*"},
-		AllowCredentials: false,
-	})
-}
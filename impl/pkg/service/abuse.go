@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/pkg/abuse"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// screen scores a record published to the gateway with the abuse filter, returning whether the filter holds it for
+// review rather than it being published, or ErrAbusive if the filter rejects it. Deactivations aren't scored, so a
+// DID can always be deactivated.
+func (s *DHTService) screen(ctx context.Context, id string, record dht.BEP44Record) (bool, error) {
+	if s.abuse == nil || record.Deactivated() {
+		return false, nil
+	}
+	verdict := s.abuse.Check(ctx, record)
+	s.metrics.recordAbuseVerdict(ctx, verdict.Action)
+	if verdict.Action == abuse.ActionAccept {
+		return false, nil
+	}
+
+	logger := logrus.WithContext(ctx).WithField("record_id", id).WithField("score", verdict.Score).
+		WithField("reasons", strings.Join(verdict.Reasons, "; "))
+	switch verdict.Action {
+	case abuse.ActionReject:
+		logger.Info("rejected record as abusive")
+		// the reasons are left out of the error so publishers can't tune their records to the scorers
+		return false, errors.Wrapf(dht.ErrAbusive, "record %s scored %.2f", id, verdict.Score)
+	case abuse.ActionShadow:
+		logger.Info("held record for review")
+		return true, nil
+	default:
+		logger.Info("flagged record for review")
+		return false, nil
+	}
+}
+
+// ListAbuseReviews returns the records the abuse filter flagged or held for review, oldest listed first
+func (s *DHTService) ListAbuseReviews() []abuse.Review {
+	return s.abuse.Reviews()
+}
+
+// ApproveAbuseReview clears the record with the given ID for publishing, returning whether it was listed for review. A
+// held record is published as it would have been, unless a newer version has been stored since; a flagged record was
+// already published, so it's only taken off the list.
+func (s *DHTService) ApproveAbuseReview(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ApproveAbuseReview")
+	defer span.End()
+
+	review, ok := s.abuse.Take(id)
+	if !ok || review.Action != abuse.ActionShadow {
+		return ok, nil
+	}
+	stored, err := s.db.ReadRecord(ctx, id)
+	if err != nil {
+		return true, err
+	}
+	if stored != nil && stored.SequenceNumber >= review.Seq {
+		logrus.WithContext(ctx).WithField("record_id", id).Info("not publishing approved record, a newer version is stored")
+		return true, nil
+	}
+
+	published, err := s.publish(ctx, id, review.Record, pubsub.SourcePublish, false)
+	s.metrics.recordPublish(ctx, pubsub.SourcePublish, err)
+	if published {
+		s.peers.Announce(review.Record)
+	}
+	return true, err
+}
+
+// RejectAbuseReview takes the record with the given ID off the review list as abuse, returning whether it was listed.
+// A held record is dropped, and a flagged record is deleted as DeleteRecord does, though it stays on the DHT until
+// it expires.
+func (s *DHTService) RejectAbuseReview(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.RejectAbuseReview")
+	defer span.End()
+
+	review, ok := s.abuse.Take(id)
+	if !ok || review.Action != abuse.ActionFlag {
+		return ok, nil
+	}
+	if _, err := s.DeleteRecord(ctx, id); err != nil {
+		return true, err
+	}
+	return true, nil
+}
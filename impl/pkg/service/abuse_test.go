@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/abuse"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+func TestAbuseFilter(t *testing.T) {
+	ctx := context.Background()
+	newService := func(t *testing.T, id string, abuseConfig config.AbuseConfig) (*DHTService, *dht.Simulator) {
+		cfg := config.GetDefaultConfig()
+		abuseConfig.Scorers = []string{"endpoints"}
+		abuseConfig.BlockedEndpoints = []string{"spam.example"}
+		cfg.Abuse = abuseConfig
+
+		db, err := storage.NewStorage("bolt://diddht-test-" + id + ".db")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.Remove("diddht-test-" + id + ".db") })
+		sim := dht.NewSimulator()
+		svc, err := NewDHTService(&cfg, db, sim)
+		require.NoError(t, err)
+		t.Cleanup(svc.Close)
+		return svc, sim
+	}
+	// newDIDRecord returns the record publishing a new DID whose service endpoint is on the given host
+	newDIDRecord := func(t *testing.T, host string) dht.BEP44Record {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{Services: []didsdk.Service{
+			{ID: "web", Type: "LinkedDomains", ServiceEndpoint: []string{"https://" + host}},
+		}})
+		require.NoError(t, err)
+		return rotationRecord(t, sk, *doc, time.Now().Unix())
+	}
+	stored := func(t *testing.T, svc *DHTService, id string) bool {
+		record, err := svc.db.ReadRecord(ctx, id)
+		require.NoError(t, err)
+		return record != nil
+	}
+
+	t.Run("rejected records", func(t *testing.T) {
+		svc, _ := newService(t, "abuse-reject", config.AbuseConfig{RejectScore: 1})
+		record := newDIDRecord(t, "spam.example")
+		err := svc.PublishDHT(ctx, record.ID(), record)
+		assert.ErrorIs(t, err, dht.ErrAbusive)
+		assert.NotContains(t, err.Error(), "spam.example", "publishers aren't told why")
+		assert.False(t, stored(t, svc, record.ID()))
+		assert.Empty(t, svc.ListAbuseReviews())
+
+		clean := newDIDRecord(t, "example.com")
+		require.NoError(t, svc.PublishDHT(ctx, clean.ID(), clean))
+		assert.True(t, stored(t, svc, clean.ID()))
+	})
+
+	t.Run("held records are published once approved", func(t *testing.T) {
+		svc, sim := newService(t, "abuse-shadow", config.AbuseConfig{ShadowScore: 1})
+		record := newDIDRecord(t, "cdn.spam.example")
+		require.NoError(t, svc.PublishDHT(ctx, record.ID(), record), "publishers are told held records were accepted")
+		assert.False(t, stored(t, svc, record.ID()))
+		assert.Never(t, func() bool { return sim.Puts() > 0 }, 100*time.Millisecond, 10*time.Millisecond)
+
+		reviews := svc.ListAbuseReviews()
+		require.Len(t, reviews, 1)
+		assert.Equal(t, record.ID(), reviews[0].ID)
+		assert.Equal(t, abuse.ActionShadow, reviews[0].Action)
+		assert.Equal(t, 1.0, reviews[0].Score)
+
+		listed, err := svc.ApproveAbuseReview(ctx, record.ID())
+		require.NoError(t, err)
+		assert.True(t, listed)
+		assert.True(t, stored(t, svc, record.ID()))
+		assert.Eventually(t, func() bool { return sim.Puts() == 1 }, time.Second, 10*time.Millisecond)
+		assert.Empty(t, svc.ListAbuseReviews())
+
+		listed, err = svc.ApproveAbuseReview(ctx, record.ID())
+		require.NoError(t, err)
+		assert.False(t, listed)
+	})
+
+	t.Run("rejecting held records drops them", func(t *testing.T) {
+		svc, _ := newService(t, "abuse-drop", config.AbuseConfig{ShadowScore: 1})
+		record := newDIDRecord(t, "spam.example")
+		require.NoError(t, svc.PublishDHT(ctx, record.ID(), record))
+
+		listed, err := svc.RejectAbuseReview(ctx, record.ID())
+		require.NoError(t, err)
+		assert.True(t, listed)
+		assert.False(t, stored(t, svc, record.ID()))
+		assert.Empty(t, svc.ListAbuseReviews())
+	})
+
+	t.Run("flagged records are published and deleted if rejected", func(t *testing.T) {
+		svc, sim := newService(t, "abuse-flag", config.AbuseConfig{FlagScore: 1})
+		record := newDIDRecord(t, "spam.example")
+		require.NoError(t, svc.PublishDHT(ctx, record.ID(), record))
+		assert.True(t, stored(t, svc, record.ID()))
+		assert.Eventually(t, func() bool { return sim.Puts() == 1 }, time.Second, 10*time.Millisecond)
+
+		reviews := svc.ListAbuseReviews()
+		require.Len(t, reviews, 1)
+		assert.Equal(t, abuse.ActionFlag, reviews[0].Action)
+
+		listed, err := svc.RejectAbuseReview(ctx, record.ID())
+		require.NoError(t, err)
+		assert.True(t, listed)
+		assert.False(t, stored(t, svc, record.ID()))
+	})
+
+	t.Run("records from peers are scored", func(t *testing.T) {
+		svc, _ := newService(t, "abuse-peer", config.AbuseConfig{RejectScore: 1})
+		record := newDIDRecord(t, "spam.example")
+		assert.ErrorIs(t, svc.PublishPeerDHT(ctx, record), dht.ErrAbusive)
+		assert.False(t, stored(t, svc, record.ID()))
+	})
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/access"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// the operations on DIDs the access lists are checked for, as named in their audit entries
+const (
+	operationPublish = "publish"
+	operationResolve = "resolve"
+)
+
+// checkAccess returns ErrDenied if the access lists deny the DID of the record with the given ID
+func (s *DHTService) checkAccess(ctx context.Context, operation, id string) error {
+	if s.access.AllowDID(ctx, operation, id) {
+		return nil
+	}
+	return errors.Wrapf(dht.ErrDenied, "not serving record %s", id)
+}
+
+// Access returns the gateway's access lists, which the API checks its clients against
+func (s *DHTService) Access() *access.List {
+	return s.access
+}
+
+// ReloadAccess replaces the gateway's access lists with those of the given config. Records of newly denied DIDs stay
+// stored, and republished, until they're deleted.
+func (s *DHTService) ReloadAccess(cfg config.AccessConfig) error {
+	return s.access.Reload(cfg)
+}
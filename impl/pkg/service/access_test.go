@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+func TestAccessLists(t *testing.T) {
+	ctx := context.Background()
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	record := rotationRecord(t, sk, *doc, time.Now().Unix())
+
+	cfg := config.GetDefaultConfig()
+	cfg.Access = config.AccessConfig{DeniedDIDs: []string{doc.ID}}
+	db, err := storage.NewStorage("bolt://diddht-test-access.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove("diddht-test-access.db") })
+	svc, err := NewDHTService(&cfg, db, dht.NewSimulator())
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+
+	// denied dids are neither published, from any source, nor resolved
+	assert.ErrorIs(t, svc.PublishDHT(ctx, record.ID(), record), dht.ErrDenied)
+	assert.ErrorIs(t, svc.PublishPeerDHT(ctx, record), dht.ErrDenied)
+	stored, err := svc.db.ReadRecord(ctx, record.ID())
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	_, err = svc.GetDHT(ctx, record.ID())
+	assert.ErrorIs(t, err, dht.ErrDenied)
+	_, err = svc.ResolveDID(ctx, doc.ID)
+	assert.ErrorIs(t, err, dht.ErrDenied)
+	_, err = svc.ListDHTVersions(ctx, record.ID())
+	assert.ErrorIs(t, err, dht.ErrDenied)
+
+	// reloading the lists applies them at once, and invalid lists are refused
+	assert.Error(t, svc.ReloadAccess(config.AccessConfig{Allowlist: true}))
+	require.NoError(t, svc.ReloadAccess(config.AccessConfig{}))
+	require.NoError(t, svc.PublishDHT(ctx, record.ID(), record))
+	got, err := svc.GetDHT(ctx, record.ID())
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, record.SequenceNumber, got.Seq)
+
+	// in allowlist mode, only the allowed dids are served
+	_, other, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	require.NoError(t, svc.ReloadAccess(config.AccessConfig{Allowlist: true, AllowedDIDs: []string{other.ID}}))
+	_, err = svc.GetDHT(ctx, record.ID())
+	assert.ErrorIs(t, err, dht.ErrDenied)
+}
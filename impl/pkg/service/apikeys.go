@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+const (
+	// apiKeyIDBytes is how many random bytes identify an API key
+	apiKeyIDBytes = 8
+	// apiKeySecretBytes is how many random bytes of secret an API key's token holds
+	apiKeySecretBytes = 32
+)
+
+// ErrInvalidAPIKey is returned for a token of an API key that was never minted or has been revoked
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// MintAPIKey stores a new API key with the name and limits of the given key, returning the token clients present
+// and the stored key. Only a hash of the token is stored, so the token can't be recovered once returned.
+func (s *DHTService) MintAPIKey(ctx context.Context, key dht.APIKey) (string, *dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.MintAPIKey")
+	defer span.End()
+
+	id := make([]byte, apiKeyIDBytes)
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, errors.Wrap(err, "failed to generate api key id")
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, errors.Wrap(err, "failed to generate api key secret")
+	}
+	key.ID = hex.EncodeToString(id)
+	token := key.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(token))
+	key.Hash = hash[:]
+	key.Created = time.Now().UTC()
+	if err := s.db.WriteAPIKey(ctx, key); err != nil {
+		return "", nil, errors.Wrap(err, "failed to store api key")
+	}
+	return token, &key, nil
+}
+
+// ListAPIKeys returns every API key in ID order
+func (s *DHTService) ListAPIKeys(ctx context.Context) ([]dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ListAPIKeys")
+	defer span.End()
+
+	return s.db.ListAPIKeys(ctx)
+}
+
+// RevokeAPIKey deletes the API key with the given ID, returning whether it was stored. Requests with the key's token
+// are rejected from then on.
+func (s *DHTService) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.RevokeAPIKey")
+	defer span.End()
+
+	return s.db.DeleteAPIKey(ctx, id)
+}
+
+// AuthenticateAPIKey returns the API key the given token was minted for, or ErrInvalidAPIKey if there is none
+func (s *DHTService) AuthenticateAPIKey(ctx context.Context, token string) (*dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.AuthenticateAPIKey")
+	defer span.End()
+
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := s.db.ReadAPIKey(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read api key")
+	}
+	hash := sha256.Sum256([]byte(token))
+	if key == nil || subtle.ConstantTimeCompare(hash[:], key.Hash) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	return key, nil
+}
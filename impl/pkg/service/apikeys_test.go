@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestAPIKeys(t *testing.T) {
+	svc, _ := newSimulatedDHTService(t, "api-keys")
+	defer svc.Close()
+	ctx := context.Background()
+
+	token, key, err := svc.MintAPIKey(ctx, dht.APIKey{Name: "tier one", RequestsPerSecond: 5, Burst: 10, PublishesPerDay: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "tier one", key.Name)
+	assert.NotEmpty(t, key.ID)
+	assert.False(t, key.Created.IsZero())
+	assert.NotContains(t, string(key.Hash), token, "the token itself isn't stored")
+
+	authenticated, err := svc.AuthenticateAPIKey(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, authenticated.ID)
+	assert.Equal(t, 100, authenticated.PublishesPerDay)
+
+	for _, invalid := range []string{"", "no-separator", key.ID + ".wrong-secret", "unknown." + token[len(key.ID)+1:]} {
+		_, err = svc.AuthenticateAPIKey(ctx, invalid)
+		assert.ErrorIs(t, err, ErrInvalidAPIKey, invalid)
+	}
+
+	keys, err := svc.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, key.ID, keys[0].ID)
+
+	revoked, err := svc.RevokeAPIKey(ctx, key.ID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	_, err = svc.AuthenticateAPIKey(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	revoked, err = svc.RevokeAPIKey(ctx, key.ID)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
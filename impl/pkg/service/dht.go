@@ -2,160 +2,592 @@ package service
 
 import (
 	"context"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
 	ssiutil "github.com/TBD54566975/ssi-sdk/util"
 	"github.com/allegro/bigcache/v3"
+	"github.com/anacrolix/dht/v2/bep44"
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/goccy/go-json"
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/TBD54566975/did-dht/internal/did"
 	"github.com/TBD54566975/did-dht/internal/util"
 
 	"github.com/TBD54566975/did-dht/config"
 	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/pkg/abuse"
+	"github.com/TBD54566975/did-dht/pkg/access"
+	"github.com/TBD54566975/did-dht/pkg/archive"
+	"github.com/TBD54566975/did-dht/pkg/cache"
 	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/journal"
+	"github.com/TBD54566975/did-dht/pkg/peering"
+	"github.com/TBD54566975/did-dht/pkg/proxy"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
 	"github.com/TBD54566975/did-dht/pkg/storage"
 	"github.com/TBD54566975/did-dht/pkg/telemetry"
 )
 
-const recordSizeLimitBytes = 1000
+const (
+	recordSizeLimitBytes = 1000
+	// defaultCacheSizeLimitMB is how much the in-memory cache holds when the config sets no limit
+	defaultCacheSizeLimitMB = 1000
+
+	// MaxResolveBatchSize is the most DIDs resolved in one batch
+	MaxResolveBatchSize = 100
+	// resolveWorkers is the number of DIDs in a batch resolved at once
+	resolveWorkers = 16
+	// resolveTimeout is the deadline shared by every resolution in a batch
+	resolveTimeout = 15 * time.Second
+
+	// MaxSubscribedIDs is the most records one subscription can be subscribed to
+	MaxSubscribedIDs = 1000
+
+	// conditionalLookupTimeout is how long a conditional publish looks for a newer version of the record on the DHT
+	conditionalLookupTimeout = 5 * time.Second
+)
 
 // DHTService is the service responsible for managing BEP44 DNS records in the DHT and reading/writing records
 type DHTService struct {
-	cfg         *config.Config
-	db          storage.Storage
-	dht         *dht.DHT
-	cache       *bigcache.BigCache
+	cfg *config.Config
+	db  storage.Storage
+	dht dht.Client
+	// cache holds resolved records, as cachedRecords, for the TTL plus the stale window
+	cache       cache.Cache
 	badGetCache *bigcache.BigCache
-	scheduler   *dhtint.Scheduler
+	// republisher schedules the stored records for republishing
+	republisher *republisher
+	// peers is sent every record published to this gateway; nil when no peers are configured
+	peers *peering.Gossiper
+	// archiver snapshots the stored records to object storage; nil when archiving isn't configured
+	archiver *archive.Archiver
+	// journal records every accepted record before it is stored; nil when journaling isn't configured
+	journal *journal.Journal
+	// collector deletes records that are no longer published or resolved; nil when collection isn't configured
+	collector *storage.Collector
+	// mu guards collector, which is replaced when the config is reloaded
+	mu sync.Mutex
+	// resolutions counts the resolutions of records, which are periodically added to the stored records' metadata
+	resolutions *storage.ResolutionCounter
+	// republishing holds the IDs of records being republished on read, so each is only republished once at a time
+	republishing sync.Map
+	// revalidating holds the IDs of stale cached records being refreshed, so each is only refreshed once at a time
+	revalidating sync.Map
+	// strategy is the gateway's resolution strategy, which requests can override
+	strategy ResolutionStrategy
+	// popular counts the resolutions of records to refresh the most resolved before they go stale; nil when refreshing
+	// is disabled
+	popular *popularity
+	// conditionallyPublishing holds the IDs of records being conditionally published, so the check of each record's
+	// current version and its publish aren't interleaved with another's
+	conditionallyPublishing sync.Map
+	// bus sends the new record versions the gateway sees to their subscribers
+	bus *pubsub.Bus
+	// gatewayDID is the DID the gateway announced itself under; empty if it doesn't announce itself
+	gatewayDID string
+	// abuse scores the records published to the gateway, holding or rejecting those that look like abuse; nil when no
+	// scorers are configured
+	abuse *abuse.Filter
+	// access are the access lists of the DIDs the gateway publishes and resolves, and the clients it serves
+	access *access.List
+	// metrics records resolutions, publishes, and republishes
+	metrics *serviceMetrics
+
+	// work tracks the background work in flight, such as puts of published records and the republishes in progress,
+	// which shutting down waits for
+	work sync.WaitGroup
+	// workMu guards shuttingDown, so no work starts once shutting down waits for the work in flight
+	workMu       sync.Mutex
+	shuttingDown bool
+	// workCtx is the context of background work, cancelled to abandon it when shutting down runs out of time
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+	closeOnce  sync.Once
+}
+
+// cachedRecord is a record as stored in the get cache, along with when it was cached
+type cachedRecord struct {
+	dht.BEP44Response
+	CachedAt time.Time `json:"cachedAt"`
 }
 
-// NewDHTService returns a new instance of the DHT service
-func NewDHTService(cfg *config.Config, db storage.Storage, d *dht.DHT) (*DHTService, error) {
+// NewDHTService returns a new instance of the DHT service, backed by the given DHT client
+func NewDHTService(cfg *config.Config, db storage.Storage, d dht.Client) (*DHTService, error) {
 	if cfg == nil {
 		return nil, ssiutil.LoggingNewError("config is required")
 	}
 
-	// create and start get cache
-	cacheTTL := time.Duration(cfg.DHTConfig.CacheTTLSeconds) * time.Second
-	cacheConfig := bigcache.DefaultConfig(cacheTTL)
-	cacheConfig.MaxEntrySize = recordSizeLimitBytes
-	cacheConfig.HardMaxCacheSize = cfg.DHTConfig.CacheSizeLimitMB
-	cacheConfig.CleanWindow = cacheTTL / 2
-	cache, err := bigcache.New(context.Background(), cacheConfig)
+	for _, id := range cfg.Registry.Gateways {
+		if !did.DHT(id).IsValid() {
+			return nil, ssiutil.LoggingNewErrorf("invalid gateway did: %s", id)
+		}
+	}
+
+	strategy, err := ParseResolutionStrategy(cfg.DHTConfig.ResolutionStrategy)
+	if err != nil {
+		return nil, ssiutil.LoggingErrorMsg(err, "invalid resolution strategy")
+	}
+	abuseFilter, err := abuse.NewFilter(cfg.Abuse)
+	if err != nil {
+		return nil, ssiutil.LoggingErrorMsg(err, "invalid abuse filter")
+	}
+	accessList, err := access.NewList(cfg.Access)
+	if err != nil {
+		return nil, ssiutil.LoggingErrorMsg(err, "invalid access lists")
+	}
+	// records are gossiped to peers through the proxy, like the rest of the gateway's egress
+	transport, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, ssiutil.LoggingErrorMsg(err, "invalid proxy")
+	}
+
+	// create the get cache
+	cacheSizeLimitMB := cfg.DHTConfig.CacheSizeLimitMB
+	if cacheSizeLimitMB == 0 {
+		cacheSizeLimitMB = defaultCacheSizeLimitMB
+	}
+	getCache, err := cache.NewCache(cfg.DHTConfig.CacheRedisURI, cacheSizeLimitMB<<20, cfg.DHTConfig.CacheColdPath)
 	if err != nil {
 		return nil, ssiutil.LoggingErrorMsg(err, "failed to instantiate cache")
 	}
 
 	// create a new cache for bad gets to prevent spamming the DHT
-	cacheConfig.LifeWindow = 60 * time.Second
+	cacheConfig := bigcache.DefaultConfig(60 * time.Second)
+	cacheConfig.MaxEntrySize = recordSizeLimitBytes
+	cacheConfig.HardMaxCacheSize = cacheSizeLimitMB
 	cacheConfig.CleanWindow = 30 * time.Second
 	badGetCache, err := bigcache.New(context.Background(), cacheConfig)
 	if err != nil {
 		return nil, ssiutil.LoggingErrorMsg(err, "failed to instantiate badGetCache")
 	}
 
-	// start scheduler for republishing
-	scheduler := dhtint.NewScheduler()
-	svc := DHTService{
+	svc := &DHTService{
 		cfg:         cfg,
 		db:          db,
 		dht:         d,
-		cache:       cache,
+		cache:       getCache,
 		badGetCache: badGetCache,
-		scheduler:   &scheduler,
+		strategy:    strategy,
+		abuse:       abuseFilter,
+		access:      accessList,
+		republisher: newRepublisher(cfg.DHTConfig),
+		peers:       peering.NewGossiper(cfg.PeeringConfig, transport),
+		bus:         pubsub.NewBus(),
+	}
+	if claimer, ok := storage.AsRepublishClaimer(db); ok {
+		svc.republisher.claimer = claimer
+		logrus.WithField("replica", svc.republisher.holder).Info("claiming records to republish, sharing republishing with replicas")
+	}
+	svc.metrics = newServiceMetrics(db, svc.republisher, getCache)
+	svc.workCtx, svc.cancelWork = context.WithCancel(context.Background())
+	if svc.archiver, err = archive.NewArchiver(cfg.ArchiveConfig, db); err != nil {
+		svc.metrics.close()
+		return nil, ssiutil.LoggingErrorMsg(err, "failed to start archiver")
+	}
+	if svc.journal, err = journal.Open(cfg.JournalConfig); err != nil {
+		svc.archiver.Close()
+		svc.metrics.close()
+		return nil, ssiutil.LoggingErrorMsg(err, "failed to open journal")
 	}
-	if err = scheduler.Schedule(cfg.DHTConfig.RepublishCRON, svc.republish); err != nil {
-		return nil, ssiutil.LoggingErrorMsg(err, "failed to start republisher")
+	if svc.collector, err = storage.NewCollector(cfg.GCConfig, db); err != nil {
+		svc.archiver.Close()
+		_ = svc.journal.Close()
+		svc.metrics.close()
+		return nil, ssiutil.LoggingErrorMsg(err, "failed to start record collector")
 	}
-	return &svc, nil
+	svc.resolutions = storage.NewResolutionCounter(db)
+	go svc.runRepublisher()
+	if cfg.DHTConfig.CacheRefreshTopN > 0 {
+		svc.popular = newPopularity(cfg.DHTConfig.CacheRefreshTopN * popularityTrackedPerRefreshed)
+		go svc.runRefresher()
+	}
+	return svc, nil
 }
 
-// PublishDHT stores the record in the db, publishes the given DNS record to the DHT, and returns the z-base-32 encoded ID
+// PublishDHT stores the record in the db and publishes the given DNS record to the DHT. The ID is the z-base-32
+// encoded key, followed by the encoded salt for salted records. New records are also sent to any peer gateways.
 func (s *DHTService) PublishDHT(ctx context.Context, id string, record dht.BEP44Record) error {
 	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.PublishDHT")
 	defer span.End()
 
-	// make sure the key is valid
-	if _, err := util.Z32Decode(id); err != nil {
-		return ssiutil.LoggingCtxErrorMsgf(ctx, err, "failed to decode z-base-32 encoded ID: %s", id)
+	published, err := s.publish(ctx, id, record, pubsub.SourcePublish, true)
+	s.metrics.recordPublish(ctx, pubsub.SourcePublish, err)
+	if published {
+		s.peers.Announce(record)
 	}
+	return err
+}
+
+// PublishDHTIfSeq publishes the record like PublishDHT on the condition that the current version of it has the
+// expected sequence number, so two clients holding the same key don't silently overwrite each other's versions. A
+// SeqConflictError is returned if the gateway has a newer version stored or cached, or finds one on the DHT, and
+// ErrSeqConflict if another conditional publish of the record is under way.
+func (s *DHTService) PublishDHTIfSeq(ctx context.Context, id string, record dht.BEP44Record, expectedSeq int64) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.PublishDHTIfSeq")
+	defer span.End()
 
 	if err := record.IsValid(); err != nil {
 		return err
 	}
-
-	// check if the message is already in the cache
-	if got, err := s.cache.Get(id); err == nil {
-		var resp dht.BEP44Response
-		if err = json.Unmarshal(got, &resp); err == nil && record.Response().Equals(resp) {
-			logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved dht record from cache with matching response")
-			return nil
-		}
+	if _, publishing := s.conditionallyPublishing.LoadOrStore(id, struct{}{}); publishing {
+		return errors.Wrapf(dht.ErrSeqConflict, "another conditional publish of record %s is under way", id)
 	}
+	defer s.conditionallyPublishing.Delete(id)
 
-	// write to db and cache
-	if err := s.db.WriteRecord(ctx, record); err != nil {
+	current, err := s.currentSeq(ctx, id)
+	if err != nil {
 		return err
 	}
-	recordBytes, err := json.Marshal(record.Response())
+	if current > expectedSeq {
+		return &dht.SeqConflictError{ID: id, Expected: expectedSeq, Current: current}
+	}
+	return s.PublishDHT(ctx, id, record)
+}
+
+// currentSeq returns the sequence number of the newest version of the record stored, cached, or on the DHT, or -1 if
+// there is none. A lookup on the DHT that fails or times out finds nothing.
+func (s *DHTService) currentSeq(ctx context.Context, id string) (int64, error) {
+	current := int64(-1)
+	stored, err := s.db.ReadRecord(ctx, id)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if stored != nil {
+		current = stored.SequenceNumber
+	}
+	if cached := s.readCache(ctx, id); cached != nil {
+		current = max(current, cached.Seq)
 	}
-	if err = s.cache.Set(id, recordBytes); err != nil {
+
+	getCtx, cancel := context.WithTimeout(ctx, conditionalLookupTimeout)
+	defer cancel()
+	got, err := s.dht.GetFull(getCtx, id)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Debug("found no record on the dht for conditional publish")
+		return current, nil
+	}
+	return max(current, got.Seq), nil
+}
+
+// validateTypes checks that the record's types record is well-formed, listing only types in the spec's registry.
+// Only records published to this gateway are checked; records from peers and the DHT are indexed as they are.
+func validateTypes(record dht.BEP44Record) error {
+	types, err := record.ParseTypes()
+	if err != nil {
 		return err
 	}
+	for _, t := range types {
+		if !did.TypeIndex(t).IsRegistered() {
+			return errors.Wrapf(dht.ErrInvalidTypes, "type %d is not registered", t)
+		}
+	}
+	return nil
+}
+
+// PublishPeerDHT stores and publishes a record received from a peer gateway like PublishDHT, but doesn't send it
+// on to other peers. Every gateway sends the records published to it to its own peers, so forwarding would only
+// echo records back and forth.
+func (s *DHTService) PublishPeerDHT(ctx context.Context, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.PublishPeerDHT")
+	defer span.End()
+
+	_, err := s.publish(ctx, record.ID(), record, pubsub.SourcePeer, true)
+	s.metrics.recordPublish(ctx, pubsub.SourcePeer, err)
+	return err
+}
+
+// publish stores the record and puts it to the DHT, returning whether the record was new to this gateway. The record
+// is published to the bus as an event from the given source. Screened records are first scored by the abuse filter,
+// and not published if it holds them.
+func (s *DHTService) publish(ctx context.Context, id string, record dht.BEP44Record, source string, screened bool) (bool, error) {
+	// make sure the key is valid
+	if _, err := util.Z32Decode(recordKey(id)); err != nil {
+		return false, ssiutil.LoggingCtxErrorMsgf(ctx, err, "failed to decode z-base-32 encoded ID: %s", id)
+	}
+
+	if err := record.IsValid(); err != nil {
+		return false, err
+	}
+	if id != record.ID() {
+		return false, ssiutil.LoggingCtxNewErrorf(ctx, "record ID %s does not match the record's key and salt", id)
+	}
+	if err := s.checkAccess(ctx, operationPublish, id); err != nil {
+		return false, err
+	}
+	// records published to a gateway, whether to this one or to a peer, are held to the publishing limits and checks
+	if source == pubsub.SourcePublish || source == pubsub.SourcePeer {
+		if err := record.CheckLimits(); err != nil {
+			return false, err
+		}
+		if err := validateTypes(record); err != nil {
+			return false, err
+		}
+		if err := validateDocument(record); err != nil {
+			return false, err
+		}
+	}
+
+	// check if the message is already in the cache
+	if cached := s.readCache(ctx, id); cached != nil && record.Response().Equals(cached.BEP44Response) {
+		logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved dht record from cache with matching response")
+		return false, nil
+	}
+
+	// a deactivated DID stays deactivated
+	if err := s.checkNotDeactivated(ctx, record); err != nil {
+		return false, err
+	}
+	if screened {
+		if held, err := s.screen(ctx, id, record); held || err != nil {
+			return false, err
+		}
+	}
+
+	// write to db and cache
+	if err := s.store(ctx, record); err != nil {
+		return false, err
+	}
+	if err := s.addRecordToCache(ctx, id, record.Response()); err != nil {
+		return false, err
+	}
 	logrus.WithContext(ctx).WithField("record_id", id).Debug("added dht record to cache and db")
+	if record.Deactivated() {
+		logrus.WithContext(ctx).WithField("record_id", id).Info("stored tombstone for deactivated did")
+		s.releaseQuarantined(ctx, id)
+		s.republisher.unschedule(id)
+	}
+	s.publishEvent(ctx, id, record.SequenceNumber, record.Types(), source)
 
-	// return here and put it in the DHT asynchronously
-	go func() {
+	// return here and put it in the DHT asynchronously; a record stored while shutting down is put when it's next
+	// republished. The request's context may be reused once the request is served, so only its ID is carried over.
+	reqID := util.RequestID(ctx)
+	s.goWork(func(workCtx context.Context) {
 		// Create a new context with a timeout so that the parent context does not cancel the put
-		putCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		putCtx, cancel := context.WithTimeout(util.WithRequestID(workCtx, reqID), 10*time.Second)
 		defer cancel()
 
-		if _, err = s.dht.Put(putCtx, record.Put()); err != nil {
-			logrus.WithContext(ctx).WithField("record_id", id).WithError(err).Warnf("error from dht.Put for record: %s", id)
+		_, err := s.dht.Put(putCtx, record.Put())
+		if err != nil {
+			logrus.WithContext(putCtx).WithField("record_id", id).WithError(err).Warn("failed to put record to DHT")
 		} else {
-			logrus.WithContext(ctx).WithField("record_id", id).Debug("put record to DHT")
+			logrus.WithContext(putCtx).WithField("record_id", id).Debug("put record to DHT")
 		}
-	}()
+		// a failed put is retried once the backoff passes, and the record is republished from then on
+		if record.Deactivated() {
+			return
+		}
+		if err != nil {
+			s.republisher.schedule(id, time.Now().Add(republishRetryBackoff), 0)
+		} else {
+			s.releaseQuarantined(workCtx, id)
+			s.republisher.schedule(id, time.Now().Add(s.republisher.interval), 0)
+		}
+	})
 
-	return nil
+	return true, nil
+}
+
+// store journals the record, then writes it and its version to the db. Journaling first means every stored record
+// can be rebuilt from the journal.
+func (s *DHTService) store(ctx context.Context, record dht.BEP44Record) error {
+	if err := s.journal.Append(record); err != nil {
+		return err
+	}
+	if err := s.db.WriteRecord(ctx, record); err != nil {
+		return err
+	}
+	return s.db.WriteRecordVersion(ctx, record)
+}
+
+// checkNotDeactivated returns ErrDeactivated if the record is for a DID whose stored record is a tombstone, unless
+// the record is that tombstone
+func (s *DHTService) checkNotDeactivated(ctx context.Context, record dht.BEP44Record) error {
+	if len(record.Salt) > 0 {
+		return nil
+	}
+	stored, err := s.db.ReadRecord(ctx, record.ID())
+	if err != nil {
+		return err
+	}
+	if stored == nil || !stored.Deactivated() || stored.Response().Equals(record.Response()) {
+		return nil
+	}
+	return errors.Wrapf(dht.ErrDeactivated, "not publishing record %s", record.ID())
+}
+
+// readStored reads the stored record for the ID of a DID, or nil if there is none. Salted records are never
+// tombstones, so their IDs aren't read. Storage errors are logged rather than failing the resolution.
+func (s *DHTService) readStored(ctx context.Context, id string) *dht.BEP44Record {
+	if recordKey(id) != id {
+		return nil
+	}
+	record, err := s.db.ReadRecord(ctx, id)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to read stored record")
+		return nil
+	}
+	return record
+}
+
+// recordKey returns the z-base-32 encoded key portion of a record ID, dropping any encoded salt
+func recordKey(id string) string {
+	key, _, _ := strings.Cut(id, ".")
+	return key
 }
 
 var SpamError = errors.New("rate limited to prevent spam")
 
-// GetDHT returns the full DNS record (including sig data) for the given z-base-32 encoded ID
+// GetDHT returns the full DNS record (including sig data) for the given ID, which is the z-base-32 encoded key
+// followed by the encoded salt for salted records
 func (s *DHTService) GetDHT(ctx context.Context, id string) (*dht.BEP44Response, error) {
 	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.GetDHT")
 	defer span.End()
 
+	resp, _, err := s.getDHT(ctx, id)
+	return resp, err
+}
+
+// Provenance is where and when the gateway retrieved a resolved record
+type Provenance struct {
+	// Source is where the record was retrieved from: cache, storage, or dht
+	Source string `json:"source"`
+	// RetrievedAt is when the record was retrieved; a record served from the cache was retrieved when it was cached
+	RetrievedAt time.Time `json:"retrievedAt"`
+	// Confirmations is the number of DHT nodes that returned the record, for records retrieved from the DHT
+	Confirmations int `json:"confirmations,omitempty"`
+	// SignatureVerified reports whether the record's signature verified against the DID's identity key when the DID
+	// was resolved
+	SignatureVerified bool `json:"signatureVerified"`
+}
+
+// getDHT returns the record for the given ID like GetDHT, along with where and when it was retrieved
+func (s *DHTService) getDHT(ctx context.Context, id string) (*dht.BEP44Response, Provenance, error) {
+	start := time.Now()
+
 	// make sure the key is valid
-	if _, err := util.Z32Decode(id); err != nil {
+	if _, err := util.Z32Decode(recordKey(id)); err != nil {
 		logrus.WithContext(ctx).WithField("record_id", id).Error("failed to decode z-base-32 encoded ID")
-		return nil, errors.Wrapf(err, "failed to decode z-base-32 encoded ID: %s", id)
+		return nil, Provenance{}, errors.Wrapf(err, "failed to decode z-base-32 encoded ID: %s", id)
+	}
+	if err := s.checkAccess(ctx, operationResolve, id); err != nil {
+		return nil, Provenance{}, err
+	}
+
+	// first do a cache lookup, serving stale records while they're refreshed
+	if cached := s.readCache(ctx, id); cached != nil {
+		logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved record from cache")
+		s.resolutions.Count(id)
+		s.popular.count(id)
+		s.metrics.recordResolution(ctx, resolvedFromCache, start)
+		if time.Since(cached.CachedAt) >= s.cacheTTL() {
+			s.revalidate(ctx, id)
+		} else {
+			s.republishOnRead(ctx, id, *cached)
+		}
+		return &cached.BEP44Response, Provenance{Source: resolvedFromCache, RetrievedAt: cached.CachedAt}, nil
+	}
+
+	if s.resolutionStrategy(ctx) == StrategyCacheOnly {
+		logrus.WithContext(ctx).WithField("record_id", id).Debug("record not cached; not looking it up for a cache-only resolution")
+		s.metrics.recordResolution(ctx, resolvedFromNone, start)
+		return nil, Provenance{Source: resolvedFromNone}, nil
+	}
+
+	// a record recently found nowhere is reported not found without looking it up again
+	if s.cachedNotFound(ctx, id) {
+		logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved not found from cache")
+		s.metrics.recordResolution(ctx, resolvedFromNone, start)
+		return nil, Provenance{Source: resolvedFromNone}, nil
 	}
 
 	// if the key is in the badGetCache, return an error
 	if _, err := s.badGetCache.Get(id); err == nil {
 		logrus.WithContext(ctx).WithField("record_id", id).Error("bad key rate limited to prevent spam")
-		return nil, SpamError
+		return nil, Provenance{}, SpamError
 	}
 
-	// first do a cache lookup
-	if got, err := s.cache.Get(id); err == nil {
-		var resp dht.BEP44Response
-		if err = json.Unmarshal(got, &resp); err == nil {
-			logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved record from cache")
-			return &resp, nil
+	resp, provenance, err := s.resolve(ctx, id)
+	if resp != nil {
+		s.resolutions.Count(id)
+		s.popular.count(id)
+	}
+	s.metrics.recordResolution(ctx, provenance.Source, start)
+	return resp, provenance, err
+}
+
+// resolve looks up the record with the given ID in storage and on the DHT, bypassing the cache, and caches it. The
+// resolution strategy of the context decides whether storage or the DHT is tried first. It returns where the record
+// was retrieved from, with the source resolvedFromNone if there's no record.
+func (s *DHTService) resolve(ctx context.Context, id string) (*dht.BEP44Response, Provenance, error) {
+	strategy := s.resolutionStrategy(ctx)
+
+	// race the dht lookup against reading storage
+	var raced chan dhtLookup
+	if strategy == StrategyRace {
+		raced = make(chan dhtLookup, 1)
+		go func() {
+			got, err := s.lookupDHT(ctx, id)
+			raced <- dhtLookup{got: got, err: err}
+		}()
+	}
+
+	// a deactivated DID resolves to its tombstone, never to an earlier document still on the DHT
+	stored := s.readStored(ctx, id)
+	if stored != nil && stored.Deactivated() {
+		logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved tombstone from storage")
+		resp := stored.Response()
+		if err := s.addRecordToCache(ctx, id, resp); err != nil {
+			logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Error("failed to set record in cache")
 		}
-		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to get record from cache, falling back to dht")
+		return &resp, Provenance{Source: resolvedFromStorage, RetrievedAt: time.Now()}, nil
+	}
+
+	switch strategy {
+	case StrategyStorageFirst:
+		if local := s.readLocal(ctx, id, stored); local != nil {
+			return s.fromStorage(ctx, id, *local)
+		}
+	case StrategyRace:
+		local := s.readLocal(ctx, id, stored)
+		lookup := <-raced
+		if lookup.err != nil {
+			return s.fromStorageAfterDHT(ctx, id, local, lookup.err)
+		}
+		// the dht wins ties, confirming the stored record
+		if local != nil && local.SequenceNumber > lookup.got.Seq {
+			return s.fromStorage(ctx, id, *local)
+		}
+		return s.fromDHT(ctx, id, stored, lookup.got)
+	}
+
+	got, err := s.lookupDHT(ctx, id)
+	if err != nil {
+		record, readErr := s.db.ReadRecord(ctx, id)
+		if readErr != nil {
+			logrus.WithContext(ctx).WithError(readErr).WithField("record_id", id).Error("failed to resolve record from storage; adding to bad get cache")
+			s.addBadGet(ctx, id)
+			return nil, Provenance{Source: resolvedFromNone}, readErr
+		}
+		return s.fromStorageAfterDHT(ctx, id, record, err)
 	}
+	return s.fromDHT(ctx, id, stored, got)
+}
 
-	// next do a dht lookup with a timeout of 10 seconds
+// dhtLookup is the result of looking a record up on the DHT
+type dhtLookup struct {
+	got *dhtint.FullGetResult
+	err error
+}
+
+// lookupDHT looks the record with the given ID up on the DHT, with a timeout of 10 seconds
+func (s *DHTService) lookupDHT(ctx context.Context, id string) (*dhtint.FullGetResult, error) {
 	getCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
@@ -166,37 +598,82 @@ func (s *DHTService) GetDHT(ctx context.Context, id string) (*dht.BEP44Response,
 		} else {
 			logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to get record from dht, attempting to resolve from storage")
 		}
+	}
+	return got, err
+}
 
-		record, err := s.db.ReadRecord(ctx, id)
-		if err != nil || record == nil {
-			logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Error("failed to resolve record from storage; adding to bad get cache")
+// readLocal returns the stored record with the given ID, which is the record already read from storage for a DID.
+// Storage errors are logged rather than failing the resolution.
+func (s *DHTService) readLocal(ctx context.Context, id string, stored *dht.BEP44Record) *dht.BEP44Record {
+	if recordKey(id) == id {
+		return stored
+	}
+	record, err := s.db.ReadRecord(ctx, id)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to read stored record")
+		return nil
+	}
+	return record
+}
 
-			// add the key to the badGetCache to prevent spamming the DHT
-			if err = s.badGetCache.Set(id, []byte{0}); err != nil {
-				logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Error("failed to set key in bad get cache")
-			}
+// fromStorageAfterDHT resolves the record with the given ID from storage after the DHT lookup failed with the given
+// error, caching a not found record as not found or adding it to the bad get cache
+func (s *DHTService) fromStorageAfterDHT(ctx context.Context, id string, record *dht.BEP44Record, dhtErr error) (*dht.BEP44Response, Provenance, error) {
+	if record != nil {
+		return s.fromStorage(ctx, id, *record)
+	}
+	none := Provenance{Source: resolvedFromNone}
+	if errors.Is(dhtErr, dht.ErrNotFound) && s.notFoundTTL() > 0 {
+		logrus.WithContext(ctx).WithField("record_id", id).Debug("record not found on the dht or in storage; caching not found")
+		s.cacheNotFound(ctx, id)
+		return nil, none, nil
+	}
+	logrus.WithContext(ctx).WithField("record_id", id).Error("failed to resolve record from storage; adding to bad get cache")
+	s.addBadGet(ctx, id)
+	// a stalled lookup can't tell us the record doesn't exist, so surface it rather than reporting not found
+	if errors.Is(dhtErr, dht.ErrStalled) {
+		return nil, none, dhtErr
+	}
+	return nil, none, nil
+}
 
-			return nil, err
-		}
+// addBadGet adds the record with the given ID to the bad get cache, to prevent spamming the DHT
+func (s *DHTService) addBadGet(ctx context.Context, id string) {
+	if err := s.badGetCache.Set(id, []byte{0}); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Error("failed to set key in bad get cache")
+	}
+}
 
-		logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved record from storage")
-		resp := record.Response()
-		// add the record back to the cache for future lookups
-		if err = s.addRecordToCache(id, record.Response()); err != nil {
-			logrus.WithError(err).WithField("record_id", id).Error("failed to set record in cache")
-		}
+// fromStorage resolves the record with the given ID to the stored record, caching it
+func (s *DHTService) fromStorage(ctx context.Context, id string, record dht.BEP44Record) (*dht.BEP44Response, Provenance, error) {
+	logrus.WithContext(ctx).WithField("record_id", id).Debug("resolved record from storage")
+	s.touchRecord(ctx, id)
+	resp := record.Response()
+	// add the record back to the cache for future lookups
+	err := s.addRecordToCache(ctx, id, resp)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Error("failed to set record in cache")
+	}
+	return &resp, Provenance{Source: resolvedFromStorage, RetrievedAt: time.Now()}, err
+}
 
-		return &resp, err
+// fromDHT resolves the record with the given ID to the record found on the DHT, caching it. Newer records than the
+// stored record of a DID, if any, are sent to subscribers, and a newer deactivation is stored as its tombstone.
+func (s *DHTService) fromDHT(ctx context.Context, id string, stored *dht.BEP44Record, got *dhtint.FullGetResult) (*dht.BEP44Response, Provenance, error) {
+	none := Provenance{Source: resolvedFromNone}
+	if got.ConflictDetected {
+		logrus.WithContext(ctx).WithField("record_id", id).WithField("seq", got.Seq).
+			Warn("dht returned conflicting records with the same sequence number; the record's key may be compromised")
 	}
 
 	// prepare the record for return
 	bBytes, err := got.V.MarshalBencode()
 	if err != nil {
-		return nil, err
+		return nil, none, err
 	}
 	var payload string
 	if err = bencode.Unmarshal(bBytes, &payload); err != nil {
-		return nil, ssiutil.LoggingCtxErrorMsg(ctx, err, "failed to unmarshal bencoded payload")
+		return nil, none, ssiutil.LoggingCtxErrorMsg(ctx, err, "failed to unmarshal bencoded payload")
 	}
 	resp := dht.BEP44Response{
 		V:   []byte(payload),
@@ -204,192 +681,859 @@ func (s *DHTService) GetDHT(ctx context.Context, id string) (*dht.BEP44Response,
 		Sig: got.Sig,
 	}
 
+	s.touchRecord(ctx, id)
+	if stored == nil || resp.Seq > stored.SequenceNumber {
+		var types []int
+		if recordKey(id) == id {
+			types = dht.BEP44Record{Value: resp.V}.Types()
+		}
+		s.publishEvent(ctx, id, resp.Seq, types, pubsub.SourceDHT)
+	}
+
+	// keep a deactivation published elsewhere as the tombstone of a stored DID, so its earlier document is no longer
+	// republished or served
+	if stored != nil && resp.Seq > stored.SequenceNumber && dht.IsDeactivation(resp.V) {
+		s.storeTombstone(ctx, stored.Key[:], resp)
+	}
+
 	// add the record to cache, do it here to avoid duplicate calculations
-	if err = s.addRecordToCache(id, resp); err != nil {
+	if err = s.addRecordToCache(ctx, id, resp); err != nil {
 		logrus.WithContext(ctx).WithField("record_id", id).WithError(err).Error("failed to set record in cache")
 	} else {
 		logrus.WithContext(ctx).WithField("record_id", id).Debug("added record back to cache")
 	}
 
-	return &resp, nil
+	return &resp, Provenance{Source: resolvedFromDHT, RetrievedAt: time.Now(), Confirmations: got.Confirmations}, nil
 }
 
-func (s *DHTService) addRecordToCache(id string, resp dht.BEP44Response) error {
-	recordBytes, err := json.Marshal(resp)
+// GetDHTVersion returns the version of the record with the given ID and sequence number from the gateway's record
+// history, or nil if the gateway has no such version
+func (s *DHTService) GetDHTVersion(ctx context.Context, id string, seq int64) (*dht.BEP44Response, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.GetDHTVersion")
+	defer span.End()
+
+	if err := s.checkAccess(ctx, operationResolve, id); err != nil {
+		return nil, err
+	}
+	versions, err := s.db.ListRecordVersions(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err = s.cache.Set(id, recordBytes); err != nil {
-		return err
+	for _, version := range versions {
+		if version.SequenceNumber == seq {
+			resp := version.Response()
+			return &resp, nil
+		}
 	}
-	return nil
+	return nil, nil
 }
 
-// failedRecord is a struct to keep track of records that failed to be republished
-type failedRecord struct {
-	record     dht.BEP44Record
-	failureCnt int
+// GetDHTVersionAt returns the version of the record with the given ID that was current at the given time from the
+// gateway's record history, or nil if the record had no version yet. Sequence numbers are the Unix timestamps the
+// versions were published at, so this is the latest version with a sequence number no later than the time.
+func (s *DHTService) GetDHTVersionAt(ctx context.Context, id string, at time.Time) (*dht.BEP44Response, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.GetDHTVersionAt")
+	defer span.End()
+
+	if err := s.checkAccess(ctx, operationResolve, id); err != nil {
+		return nil, err
+	}
+	versions, err := s.db.ListRecordVersions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var current *dht.BEP44Record
+	for i := range versions {
+		if versions[i].SequenceNumber > at.Unix() {
+			break
+		}
+		current = &versions[i]
+	}
+	if current == nil {
+		return nil, nil
+	}
+	resp := current.Response()
+	return &resp, nil
 }
 
-// TODO(gabe) make this more efficient. create a publish schedule based on each individual record, not all records
-// republish republishes all records in the db
-func (s *DHTService) republish() {
-	ctx, span := telemetry.GetTracer().Start(context.Background(), "DHTService.republish")
+// ListDHTVersions returns every version of the record with the given ID in the gateway's record history, ordered by
+// sequence number
+func (s *DHTService) ListDHTVersions(ctx context.Context, id string) ([]dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ListDHTVersions")
 	defer span.End()
 
-	recordCnt, err := s.db.RecordCount(ctx)
+	if err := s.checkAccess(ctx, operationResolve, id); err != nil {
+		return nil, err
+	}
+	return s.db.ListRecordVersions(ctx, id)
+}
+
+// DIDVersion is a DID document resolved from a version of its record in the gateway's record history
+type DIDVersion struct {
+	Document didsdk.Document    `json:"didDocument"`
+	Metadata DIDVersionMetadata `json:"didDocumentMetadata"`
+}
+
+// DIDVersionMetadata is the DID Core document metadata of a version of a DID. Versions are identified by their
+// sequence numbers, which are the Unix times they were published at.
+type DIDVersionMetadata struct {
+	// Created is when the first version of the DID known to the gateway was published
+	Created time.Time `json:"created"`
+	// Updated is when this version was published
+	Updated   time.Time `json:"updated"`
+	VersionID string    `json:"versionId"`
+	// NextUpdate and NextVersionID identify the version that replaced this one, if any
+	NextUpdate    *time.Time `json:"nextUpdate,omitempty"`
+	NextVersionID string     `json:"nextVersionId,omitempty"`
+	Deactivated   bool       `json:"deactivated,omitempty"`
+	// PreviousDID is the DID this one supersedes, linked by a _prv._did. record signed with its identity key
+	PreviousDID string `json:"previousDid,omitempty"`
+}
+
+// GetDIDVersion resolves the version of the DID with the given z-base-32 encoded key and sequence number from the
+// gateway's record history, or returns nil if the gateway has no such version
+func (s *DHTService) GetDIDVersion(ctx context.Context, id string, seq int64) (*DIDVersion, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.GetDIDVersion")
+	defer span.End()
+
+	if err := s.checkAccess(ctx, operationResolve, id); err != nil {
+		return nil, err
+	}
+	versions, err := s.db.ListRecordVersions(ctx, id)
 	if err != nil {
-		logrus.WithContext(ctx).WithError(err).Error("failed to get record count before republishing")
-		return
+		return nil, err
 	}
-	logrus.WithContext(ctx).WithField("record_count", recordCnt).Info("republishing records")
+	for i, version := range versions {
+		if version.SequenceNumber != seq {
+			continue
+		}
 
-	// republish all records in the db and handle failed records up to 3 times
-	failedRecords := s.republishRecords(ctx)
+		doc, err := decodeDIDDocument(id, version.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode version %d of record %s", seq, id)
+		}
 
-	// handle failed records
-	logrus.WithContext(ctx).WithField("failed_record_count", len(failedRecords)).Info("handling failed records")
-	s.handleFailedRecords(ctx, failedRecords)
+		metadata := DIDVersionMetadata{
+			Created:     time.Unix(versions[0].SequenceNumber, 0).UTC(),
+			Updated:     time.Unix(seq, 0).UTC(),
+			VersionID:   strconv.FormatInt(seq, 10),
+			Deactivated: doc.Deactivated,
+			PreviousDID: previousDID(doc),
+		}
+		if i+1 < len(versions) {
+			next := versions[i+1].SequenceNumber
+			nextUpdate := time.Unix(next, 0).UTC()
+			metadata.NextUpdate = &nextUpdate
+			metadata.NextVersionID = strconv.FormatInt(next, 10)
+		}
+		return &DIDVersion{Document: doc.Doc, Metadata: metadata}, nil
+	}
+	return nil, nil
 }
 
-// republishRecords republishes all records in the db and returns a list of failed records to be retried
-func (s *DHTService) republishRecords(ctx context.Context) []failedRecord {
-	var nextPageToken []byte
-	var seenRecords, batchCnt int32
-	var failedRecords []failedRecord
-	var recordsBatch []dht.BEP44Record
-	var err error
+// decodeDIDDocument decodes the document of the DID with the given z-base-32 encoded key from a record's value
+func decodeDIDDocument(id string, v []byte) (*did.DIDDHTDocument, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(v); err != nil {
+		return nil, errors.Wrap(err, "failed to unpack dns packet")
+	}
+	return did.DHT(did.Prefix + ":" + id).FromDNSPacket(msg)
+}
+
+// previousDID returns the DID a decoded document supersedes, if it has a previous record. FromDNSPacket has verified
+// the record's signature.
+func previousDID(doc *did.DIDDHTDocument) string {
+	if doc.PreviousDID == nil {
+		return ""
+	}
+	return doc.PreviousDID.PreviousDID.String()
+}
+
+// DIDResolution is the result of resolving one DID in a batch: its document, or why it couldn't be resolved
+type DIDResolution struct {
+	DID      string           `json:"did"`
+	Document *didsdk.Document `json:"didDocument,omitempty"`
+	// VersionID is the sequence number of the resolved record
+	VersionID   string `json:"versionId,omitempty"`
+	Deactivated bool   `json:"deactivated,omitempty"`
+	// PreviousDID is the DID this one supersedes, linked by a _prv._did. record signed with its identity key
+	PreviousDID string `json:"previousDid,omitempty"`
+	// Provenance is where and when the resolved record was retrieved
+	Provenance *Provenance `json:"provenance,omitempty"`
+	// Error is why the DID couldn't be resolved, if it couldn't
+	Error string `json:"error,omitempty"`
+}
+
+// ResolveDIDs resolves each of the given DIDs through the cache, storage, and DHT as GetDHT does, several at a time,
+// under a deadline shared by the whole batch. A result is returned for each DID, in the order given.
+func (s *DHTService) ResolveDIDs(ctx context.Context, dids []string) []DIDResolution {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ResolveDIDs")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
 
+	results := make([]DIDResolution, len(dids))
+	work := make(chan int)
 	var wg sync.WaitGroup
+	for range min(resolveWorkers, len(dids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = s.resolveDID(ctx, dids[i])
+			}
+		}()
+	}
+	for i := range dids {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+func (s *DHTService) resolveDID(ctx context.Context, id string) DIDResolution {
+	if err := ctx.Err(); err != nil {
+		return DIDResolution{DID: id, Error: err.Error()}
+	}
+	resolution, err := s.ResolveDID(ctx, id)
+	if err != nil {
+		return DIDResolution{DID: id, Error: err.Error()}
+	}
+	if resolution == nil {
+		return DIDResolution{DID: id, Error: "did not found"}
+	}
+	return *resolution
+}
+
+// ResolveDID resolves the DID to its current document through the cache, storage, and DHT as GetDHT does, returning
+// nil if the DID isn't found. The resolution's provenance reports whether the record's signature verifies against the
+// DID's identity key.
+func (s *DHTService) ResolveDID(ctx context.Context, id string) (*DIDResolution, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ResolveDID")
+	defer span.End()
+
+	suffix, err := did.DHT(id).Suffix()
+	if err != nil {
+		return nil, err
+	}
+	resp, provenance, err := s.getDHT(ctx, suffix)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	doc, err := decodeDIDDocument(suffix, resp.V)
+	if err != nil {
+		return nil, err
+	}
+	provenance.SignatureVerified = verifySignature(suffix, *resp)
+	return &DIDResolution{
+		DID:         id,
+		Document:    &doc.Doc,
+		VersionID:   strconv.FormatInt(resp.Seq, 10),
+		Deactivated: doc.Deactivated,
+		PreviousDID: previousDID(doc),
+		Provenance:  &provenance,
+	}, nil
+}
+
+// verifySignature returns whether the record's signature verifies against the key of the unsalted record ID
+func verifySignature(id string, resp dht.BEP44Response) bool {
+	key, err := util.Z32Decode(id)
+	if err != nil {
+		return false
+	}
+	bv, err := bencode.Marshal(resp.V)
+	if err != nil {
+		return false
+	}
+	return bep44.Verify(key, nil, resp.Seq, bv, resp.Sig[:])
+}
+
+// ListDIDsByType returns a page of the DIDs indexed under the query's type that match the query, in the query's order,
+// along with a token for the next page, which is nil after the last page. Types are indexed from the records stored
+// by this gateway.
+func (s *DHTService) ListDIDsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, pageSize int) ([]string, []byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ListDIDsByType")
+	defer span.End()
+
+	ids, nextPageToken, err := s.db.ListRecordsByType(ctx, query, nextPageToken, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	// only unsalted records are typed, so each ID is the DID's z-base-32 encoded key
+	dids := make([]string, 0, len(ids))
+	for _, id := range ids {
+		dids = append(dids, did.Prefix+":"+id)
+	}
+	return dids, nextPageToken, nil
+}
+
+// Subscribe returns a subscription to the new versions of records the gateway sees that match the filter, whether
+// published to the gateway, sent by a peer gateway, or found on the DHT. Only versions newer than the stored versions
+// of the filter's records are sent. The subscription's events channel is closed when it is closed or subscriptions
+// are closed.
+func (s *DHTService) Subscribe(ctx context.Context, filter pubsub.Filter) (*pubsub.Subscription, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.Subscribe")
+	defer span.End()
+
+	sub := s.bus.Subscribe()
+	if err := s.AddToSubscription(ctx, sub, filter); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	return sub, nil
+}
 
-	republishStart := time.Now()
+// AddToSubscription adds the filter's records and types to the subscription, which is then only sent versions of the
+// added records newer than the stored ones. Subscriptions are limited to MaxSubscribedIDs records.
+func (s *DHTService) AddToSubscription(ctx context.Context, sub *pubsub.Subscription, filter pubsub.Filter) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.AddToSubscription")
+	defer span.End()
 
-	for {
-		recordsBatch, nextPageToken, err = s.db.ListRecords(ctx, nextPageToken, 1000)
+	if subscribed := len(sub.Filter().IDs); subscribed+len(filter.IDs) > MaxSubscribedIDs {
+		return errors.Errorf("subscriptions are limited to %d records, and %d are already subscribed", MaxSubscribedIDs, subscribed)
+	}
+	for _, id := range filter.IDs {
+		stored, err := s.db.ReadRecord(ctx, id)
 		if err != nil {
-			logrus.WithContext(ctx).WithError(err).Error("failed to list record(s) for republishing")
-			continue
+			return err
 		}
-
-		batchSize := len(recordsBatch)
-		seenRecords += int32(batchSize)
-		if batchSize == 0 {
-			logrus.WithContext(ctx).Info("no records to republish")
-			break
+		if stored != nil {
+			sub.Seen(id, stored.SequenceNumber)
 		}
+	}
+	sub.Add(filter)
+	return nil
+}
+
+// storageProbeID is the ID read to check that storage is reachable: the z-base-32 encoded all zero key, whose records
+// aren't expected to be stored
+var storageProbeID = strings.Repeat("y", 52)
+
+// CheckStorage returns an error if storage can't be read from
+func (s *DHTService) CheckStorage(ctx context.Context) error {
+	if _, err := s.db.ReadRecord(ctx, storageProbeID); err != nil {
+		return errors.Wrap(err, "failed to read from storage")
+	}
+	return nil
+}
 
-		logrus.WithContext(ctx).WithFields(logrus.Fields{
-			"record_count": batchSize,
-			"batch_number": batchCnt,
-			"total_seen":   seenRecords,
-		}).Debugf("republishing batch [%d] of [%d] records", batchCnt, batchSize)
-		batchCnt++
+// CloseSubscriptions closes every subscription, so long-lived subscribers don't hold up shutting down
+func (s *DHTService) CloseSubscriptions() {
+	s.bus.Close()
+}
 
-		batchFailedRecords := s.republishBatch(ctx, &wg, recordsBatch)
-		failedRecords = append(failedRecords, batchFailedRecords...)
+// publishEvent publishes a version of a record seen from the given source to the bus
+func (s *DHTService) publishEvent(ctx context.Context, id string, seq int64, types []int, source string) {
+	s.bus.Publish(ctx, pubsub.Event{ID: id, Seq: seq, Types: types, Source: source, Time: time.Now().UTC()})
+}
 
-		if nextPageToken == nil {
-			break
+// GetRecordMetadata returns the metadata the gateway keeps about the stored record with the given ID, or nil if the
+// record isn't stored. Resolutions are counted in memory and added to the metadata periodically, so the count may
+// lag by a few seconds.
+func (s *DHTService) GetRecordMetadata(ctx context.Context, id string) (*dht.RecordMetadata, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.GetRecordMetadata")
+	defer span.End()
+
+	return s.db.ReadRecordMetadata(ctx, id)
+}
+
+// DeleteRecord deletes the stored record with the given ID, along with its history and metadata, and drops it from
+// the cache, returning whether it was stored. The record stays on the DHT until it expires, and resolving it from the
+// DHT stores it again.
+func (s *DHTService) DeleteRecord(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.DeleteRecord")
+	defer span.End()
+
+	deleted, err := s.db.DeleteRecord(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	s.releaseQuarantined(ctx, id)
+	s.republisher.unschedule(id)
+	if err = s.cache.Delete(ctx, id); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to drop record from cache")
+	}
+	return deleted, nil
+}
+
+// RepublishReport describes the outcome of republishing a record on demand
+type RepublishReport struct {
+	ID             string `json:"id"`
+	SequenceNumber int64  `json:"seq"`
+	// NodesStored counts the DHT nodes that acknowledged the put
+	NodesStored int `json:"nodesStored"`
+	// NodesFailed counts the DHT nodes the put was sent to that didn't acknowledge it
+	NodesFailed int `json:"nodesFailed"`
+	// NewestSeq is the sequence number of the newest version of the record the DHT held before the put, or 0 if none
+	NewestSeq int64 `json:"newestSeq"`
+}
+
+// RepublishRecord puts the stored record with the given ID to the DHT now, rather than waiting for the next scheduled
+// republish, returning a report of the put, or nil if the record isn't stored. A quarantined record that republishes
+// is released. Tombstones aren't republished.
+func (s *DHTService) RepublishRecord(ctx context.Context, id string) (*RepublishReport, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.RepublishRecord")
+	defer span.End()
+
+	record, err := s.db.ReadRecord(ctx, id)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	report := &RepublishReport{ID: id, SequenceNumber: record.SequenceNumber}
+	if record.Deactivated() {
+		return report, errors.Wrapf(dht.ErrDeactivated, "not republishing record %s", id)
+	}
+
+	res := s.dht.PutMany(ctx, []bep44.Put{record.Put()}, dhtint.PutManyConfig{Workers: 1, Timeout: 10 * time.Second})[0]
+	if res.Report != nil {
+		report.NodesStored, report.NodesFailed, report.NewestSeq = res.Report.Succeeded(), res.Report.Failed(), res.Report.NewestSeq
+		if res.Report.NewestSeq > record.SequenceNumber {
+			s.discoveredNewer(ctx, *record, res.Report.NewestSeq)
 		}
 	}
+	if res.Err != nil {
+		return report, res.Err
+	}
+	s.markRepublished(ctx, id)
+	s.releaseQuarantined(ctx, id)
+	s.republisher.schedule(id, time.Now().Add(s.republisher.interval), 0)
+	return report, nil
+}
 
-	wg.Wait()
+// ListFailedRecords returns the records that failed to be republished after every retry, with how many times each
+// has failed
+func (s *DHTService) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ListFailedRecords")
+	defer span.End()
 
-	republishEnd := time.Since(republishStart)
-	hours := int(republishEnd.Hours())
-	minutes := int(republishEnd.Minutes()) % 60
-	seconds := int(republishEnd.Seconds()) % 60
-	logrus.WithContext(ctx).Infof("Republishing completed in: %d hours, %d minutes, %d seconds", hours, minutes, seconds)
+	return s.db.ListFailedRecords(ctx)
+}
+
+// ListQuarantinedRecords returns the records quarantined for failing to republish, those retried soonest first
+func (s *DHTService) ListQuarantinedRecords() []QuarantinedRecord {
+	return s.republisher.quarantined()
+}
 
-	successRate := float64(seenRecords-int32(len(failedRecords))) / float64(seenRecords) * 100
-	logrus.WithContext(ctx).WithFields(logrus.Fields{
-		"success": seenRecords - int32(len(failedRecords)),
-		"errors":  len(failedRecords),
-		"total":   seenRecords,
-	}).Infof("republishing complete with [%d] batches of [%d] total records with a [%.2f] percent success rate", batchCnt, seenRecords, successRate)
+// RetryQuarantinedRecord republishes the quarantined record with the given ID now, as RepublishRecord does, returning
+// whether it is quarantined. A record that republishes is released from the quarantine.
+func (s *DHTService) RetryQuarantinedRecord(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.RetryQuarantinedRecord")
+	defer span.End()
 
-	return failedRecords
+	if !s.republisher.isQuarantined(id) {
+		return false, nil
+	}
+	report, err := s.RepublishRecord(ctx, id)
+	if err == nil && report == nil {
+		// the record was deleted while quarantined
+		s.releaseQuarantined(ctx, id)
+		s.republisher.unschedule(id)
+	}
+	return true, err
 }
 
-// republishBatch republishes a batch of records and returns a list of failed records to be retried
-func (s *DHTService) republishBatch(ctx context.Context, wg *sync.WaitGroup, recordsBatch []dht.BEP44Record) []failedRecord {
-	failedRecordsChan := make(chan failedRecord, len(recordsBatch))
-	var failedRecords []failedRecord
+// PurgeQuarantinedRecord gives up on the quarantined record with the given ID, deleting it as DeleteRecord does so
+// it's no longer republished, and returning whether it was quarantined
+func (s *DHTService) PurgeQuarantinedRecord(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.PurgeQuarantinedRecord")
+	defer span.End()
 
-	for _, record := range recordsBatch {
-		wg.Add(1)
-		go func(record dht.BEP44Record) {
-			defer wg.Done()
+	if !s.republisher.isQuarantined(id) {
+		return false, nil
+	}
+	if _, err := s.DeleteRecord(ctx, id); err != nil {
+		return true, err
+	}
+	return true, nil
+}
 
-			id := record.ID()
-			putCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
-
-			if _, putErr := s.dht.Put(putCtx, record.Put()); putErr != nil {
-				if errors.Is(putErr, context.DeadlineExceeded) {
-					logrus.WithContext(putCtx).WithField("record_id", id).Debug("republish timeout exceeded")
-				} else {
-					logrus.WithContext(putCtx).WithField("record_id", id).WithError(putErr).Debug("failed to republish record")
-				}
-				failedRecordsChan <- failedRecord{
-					record:     record,
-					failureCnt: 1,
-				}
-			}
-		}(record)
+// releaseQuarantined clears the stored failure count of the record with the given ID if it's quarantined, before the
+// record is rescheduled or unscheduled
+func (s *DHTService) releaseQuarantined(ctx context.Context, id string) {
+	if s.republisher.isQuarantined(id) {
+		s.clearFailedRecord(ctx, id)
 	}
+}
 
-	wg.Wait()
-	close(failedRecordsChan)
+// RetainDID keeps the records of the DID with the given key, including its salted records, from being collected,
+// alongside the configured retained DIDs
+func (s *DHTService) RetainDID(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.RetainDID")
+	defer span.End()
+
+	return s.db.WriteRetainedKey(ctx, k)
+}
+
+// ReleaseDID stops retaining the records of the DID with the given key, unless it is a configured retained DID
+func (s *DHTService) ReleaseDID(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ReleaseDID")
+	defer span.End()
+
+	return s.db.DeleteRetainedKey(ctx, k)
+}
+
+// ListRetainedDIDs returns the DIDs retained with RetainDID, in sorted order. The configured retained DIDs aren't
+// listed.
+func (s *DHTService) ListRetainedDIDs(ctx context.Context) ([]string, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ListRetainedDIDs")
+	defer span.End()
 
-	for fr := range failedRecordsChan {
-		failedRecords = append(failedRecords, fr)
+	keys, err := s.db.ListRetainedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dids := make([]string, 0, len(keys))
+	for _, k := range keys {
+		dids = append(dids, did.Prefix+":"+dht.RecordID(k, nil))
 	}
-	return failedRecords
+	return dids, nil
 }
 
-// handleFailedRecords attempts to republish failed records up to 3 times
-func (s *DHTService) handleFailedRecords(ctx context.Context, failedRecords []failedRecord) {
-	for _, fr := range failedRecords {
-		retryCount := 0
-		for retryCount < 3 {
-			id := fr.record.ID()
-			putCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
+// ReloadGC replaces the record collector with one for the given config, so changes to the retention window, schedule,
+// and retained DIDs apply without a restart
+func (s *DHTService) ReloadGC(cfg config.GCConfig) error {
+	collector, err := storage.NewCollector(cfg, s.db)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	previous := s.collector
+	s.collector = collector
+	s.mu.Unlock()
+	previous.Close()
+	return nil
+}
 
-			if _, putErr := s.dht.Put(putCtx, fr.record.Put()); putErr != nil {
-				logrus.WithContext(putCtx).WithField("record_id", id).WithError(putErr).Debugf("failed to re-republish [%s], attempt: %d", id, retryCount+1)
-				retryCount++
-			} else {
-				break
-			}
-		}
+// touchRecord marks a stored record as resolved, so it isn't collected. Records resolved from the cache aren't
+// touched again until they fall out of it, which is far sooner than any retention window.
+func (s *DHTService) touchRecord(ctx context.Context, id string) {
+	if err := s.db.TouchRecord(ctx, id); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to mark record as resolved")
+	}
+}
 
-		if retryCount == 3 {
-			id := fr.record.ID()
-			logrus.WithContext(ctx).WithField("record_id", id).Error("record failed to republish after 3 attempts")
-			if err := s.db.WriteFailedRecord(ctx, id); err != nil {
-				logrus.WithContext(ctx).WithField("record_id", id).WithError(err).Warn("failed to write failed record to db")
-			}
+// storeTombstone stores a deactivation resolved from the DHT as the tombstone of the DID with the given key
+func (s *DHTService) storeTombstone(ctx context.Context, k []byte, resp dht.BEP44Response) {
+	record, err := dht.NewBEP44Record(k, resp.V, resp.Sig[:], resp.Seq)
+	if err == nil {
+		err = s.store(ctx, *record)
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", dht.RecordID(k, nil)).Warn("failed to store tombstone")
+		return
+	}
+	logrus.WithContext(ctx).WithField("record_id", record.ID()).Info("stored tombstone for did deactivated on the dht")
+}
+
+// addRecordToCache caches the record with the given ID for the cache TTL, and then the stale window
+func (s *DHTService) addRecordToCache(ctx context.Context, id string, resp dht.BEP44Response) error {
+	recordBytes, err := json.Marshal(cachedRecord{BEP44Response: resp, CachedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	stale := time.Duration(s.cfg.DHTConfig.CacheStaleSeconds) * time.Second
+	if err = s.cache.Set(ctx, id, recordBytes, s.cacheTTL()+max(stale, 0)); err != nil {
+		return err
+	}
+	// the record is no longer not found
+	if s.notFoundTTL() > 0 {
+		return s.cache.Delete(ctx, notFoundKey(id))
+	}
+	return nil
+}
+
+// notFoundKey is the cache key marking the record with the given ID as not found. It can't collide with a record ID,
+// since the separator is in neither the z-base-32 nor the base64url alphabet.
+func notFoundKey(id string) string {
+	return "notfound/" + id
+}
+
+// notFoundTTL is how long a record found nowhere is reported not found without looking it up again; zero disables it
+func (s *DHTService) notFoundTTL() time.Duration {
+	return time.Duration(max(s.cfg.DHTConfig.NotFoundCacheSeconds, 0)) * time.Second
+}
+
+// cacheNotFound marks the record with the given ID as not found for the not found TTL. The mark is shared with the
+// gateway's replicas through the cache, and dropped once the record is cached.
+func (s *DHTService) cacheNotFound(ctx context.Context, id string) {
+	if err := s.cache.Set(ctx, notFoundKey(id), []byte{1}, s.notFoundTTL()); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to cache not found record")
+	}
+}
+
+// cachedNotFound returns whether the record with the given ID is marked as not found
+func (s *DHTService) cachedNotFound(ctx context.Context, id string) bool {
+	if s.notFoundTTL() <= 0 {
+		return false
+	}
+	got, err := s.cache.Get(ctx, notFoundKey(id))
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to get not found record from cache, falling back to dht")
+		return false
+	}
+	return got != nil
+}
+
+// readCache returns the record cached under the given ID, or nil if none is
+func (s *DHTService) readCache(ctx context.Context, id string) *cachedRecord {
+	got, err := s.cache.Get(ctx, id)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to get record from cache, falling back to dht")
+		return nil
+	}
+	if got == nil {
+		return nil
+	}
+	var cached cachedRecord
+	if err = json.Unmarshal(got, &cached); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to decode cached record, falling back to dht")
+		return nil
+	}
+	return &cached
+}
+
+// cacheTTL is how long a cached record is served before it is refreshed from the DHT
+func (s *DHTService) cacheTTL() time.Duration {
+	if s.cfg.DHTConfig.CacheTTLSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(s.cfg.DHTConfig.CacheTTLSeconds) * time.Second
+}
+
+// revalidate refreshes a stale cached record from storage and the DHT in the background, so the stale record is served
+// until the refreshed record replaces it in the cache. If the refresh fails, the stale record is served until it
+// expires.
+func (s *DHTService) revalidate(ctx context.Context, id string) {
+	if _, inFlight := s.revalidating.LoadOrStore(id, struct{}{}); inFlight {
+		return
+	}
+	reqID := util.RequestID(ctx)
+	started := s.goWork(func(workCtx context.Context) {
+		defer s.revalidating.Delete(id)
+
+		// Create a new context with a timeout so that the parent context does not cancel the refresh
+		refreshCtx, cancel := context.WithTimeout(util.WithRequestID(workCtx, reqID), 15*time.Second)
+		defer cancel()
+
+		if _, _, err := s.resolve(refreshCtx, id); err != nil {
+			logrus.WithContext(refreshCtx).WithField("record_id", id).WithError(err).Warn("failed to refresh stale cached record")
+			return
 		}
+		logrus.WithContext(refreshCtx).WithField("record_id", id).Debug("refreshed stale cached record")
+	})
+	if !started {
+		s.revalidating.Delete(id)
 	}
+}
 
-	failedRecordCnt, err := s.db.FailedRecordCount(ctx)
+// republishOnRead republishes a cached record in the background once it has been in the cache for longer than the
+// configured age. Records that are resolved often then stay on the DHT even when their publisher is offline.
+func (s *DHTService) republishOnRead(ctx context.Context, id string, cached cachedRecord) {
+	maxAge := time.Duration(s.cfg.DHTConfig.RepublishOnReadSeconds) * time.Second
+	if maxAge <= 0 || time.Since(cached.CachedAt) < maxAge {
+		return
+	}
+	if _, inFlight := s.republishing.LoadOrStore(id, struct{}{}); inFlight {
+		return
+	}
+
+	k, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		s.republishing.Delete(id)
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to parse record id for republish on read")
+		return
+	}
+	record, err := dht.NewSaltedBEP44Record(k, cached.V, cached.Sig[:], salt, cached.Seq)
 	if err != nil {
-		logrus.WithContext(ctx).WithError(err).Error("failed to get failed record count")
+		s.republishing.Delete(id)
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("cached record is invalid, not republishing")
 		return
 	}
 
-	logrus.WithContext(ctx).WithField("failed_record_count", failedRecordCnt).Warn("total count of record that failed to republish")
+	reqID := util.RequestID(ctx)
+	started := s.goWork(func(workCtx context.Context) {
+		defer s.republishing.Delete(id)
+
+		// Create a new context with a timeout so that the parent context does not cancel the put
+		putCtx, cancel := context.WithTimeout(util.WithRequestID(workCtx, reqID), 10*time.Second)
+		defer cancel()
+
+		if _, err := s.dht.Put(putCtx, record.Put()); err != nil {
+			logrus.WithContext(putCtx).WithField("record_id", id).WithError(err).Warn("failed to republish record on read")
+			return
+		}
+		// re-cache the record to restart its age, so it isn't republished again on the next read
+		if err := s.addRecordToCache(putCtx, id, cached.BEP44Response); err != nil {
+			logrus.WithContext(putCtx).WithField("record_id", id).WithError(err).Error("failed to set record in cache")
+		}
+		logrus.WithContext(putCtx).WithField("record_id", id).Debug("republished record on read")
+	})
+	if !started {
+		s.republishing.Delete(id)
+	}
+}
+
+// RepublishLag returns how far behind schedule the republisher is: how long the most overdue record has been due, or
+// zero if no record is overdue
+func (s *DHTService) RepublishLag() time.Duration {
+	return s.republisher.lag(time.Now())
+}
+
+// RepublishScheduleLoaded returns whether every stored record has been scheduled to be republished, which the
+// republisher does in the background after the service starts
+func (s *DHTService) RepublishScheduleLoaded() bool {
+	return s.republisher.loaded.Load()
+}
+
+// republishBatch republishes a batch of records and returns the IDs of those that failed. Tombstones aren't
+// republished, so deactivated DIDs expire from the DHT.
+func (s *DHTService) republishBatch(ctx context.Context, batch []dht.BEP44Record) map[string]bool {
+	recordsBatch := make([]dht.BEP44Record, 0, len(batch))
+	puts := make([]bep44.Put, 0, len(batch))
+	for _, record := range batch {
+		if record.Deactivated() {
+			continue
+		}
+		recordsBatch = append(recordsBatch, record)
+		puts = append(puts, record.Put())
+	}
+
+	failed := make(map[string]bool)
+	republished := make([]string, 0, len(recordsBatch))
+	results := s.dht.PutMany(ctx, puts, dhtint.PutManyConfig{
+		Workers: s.republisher.workers,
+		Limiter: s.republisher.limiter,
+		Timeout: 10 * time.Second,
+	})
+	for i, res := range results {
+		record := recordsBatch[i]
+		id := record.ID()
+		if res.Report != nil && res.Report.NewestSeq > record.SequenceNumber {
+			s.discoveredNewer(ctx, record, res.Report.NewestSeq)
+		}
+		if res.Err == nil {
+			republished = append(republished, id)
+			continue
+		}
+		if errors.Is(res.Err, context.DeadlineExceeded) {
+			logrus.WithContext(ctx).WithField("record_id", id).Debug("republish timeout exceeded")
+		} else {
+			logrus.WithContext(ctx).WithField("record_id", id).WithError(res.Err).Debug("failed to republish record")
+		}
+		failed[id] = true
+	}
+	s.markRepublished(ctx, republished...)
+	return failed
 }
 
-// Close closes the Mainline service gracefully
+// discoveredNewer handles finding a version of a stored record on the DHT that is newer than the stored one while
+// republishing. The cached record is dropped, so the next resolution looks up the newer version on the DHT, and the
+// new version is published to the bus with the stored version's types, since the new version's aren't known.
+func (s *DHTService) discoveredNewer(ctx context.Context, record dht.BEP44Record, seq int64) {
+	id := record.ID()
+	logrus.WithContext(ctx).WithField("record_id", id).WithField("seq", seq).Debug("found newer record on dht while republishing")
+	if err := s.cache.Delete(ctx, id); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to drop record from cache")
+	}
+	s.publishEvent(ctx, id, seq, record.Types(), pubsub.SourceRepublish)
+}
+
+// markRepublished records in the metadata of the stored records with the given IDs that they were just republished
+func (s *DHTService) markRepublished(ctx context.Context, ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := s.db.MarkRepublished(ctx, ids); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_count", len(ids)).Warn("failed to mark records as republished")
+	}
+}
+
+// Shutdown closes the service once the background work in flight finishes: republishing stops after the batch in
+// progress, and puts of published records, republishes on read, and cache refreshes complete. No new work
+// starts once shutting down begins. Work still in flight when the context ends is abandoned.
+func (s *DHTService) Shutdown(ctx context.Context) {
+	s.stopWork()
+	s.republisher.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.work.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logrus.WithContext(ctx).Info("background work finished")
+	case <-ctx.Done():
+		logrus.WithContext(ctx).Warn("shutdown deadline reached, abandoning background work in flight")
+		s.cancelWork()
+		<-done
+	}
+	s.Close()
+}
+
+// beginWork registers background work, returning false if the service is shutting down and the work shouldn't
+// start. Work that begins must call s.work.Done when it ends.
+func (s *DHTService) beginWork() bool {
+	s.workMu.Lock()
+	defer s.workMu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.work.Add(1)
+	return true
+}
+
+// goWork runs the work in the background with the work context, returning false if the service is shutting down and
+// the work wasn't started
+func (s *DHTService) goWork(work func(ctx context.Context)) bool {
+	if !s.beginWork() {
+		return false
+	}
+	go func() {
+		defer s.work.Done()
+		work(s.workCtx)
+	}()
+	return true
+}
+
+// stopWork stops new background work from starting
+func (s *DHTService) stopWork() {
+	s.workMu.Lock()
+	defer s.workMu.Unlock()
+	s.shuttingDown = true
+}
+
+// isShuttingDown returns whether the service has begun shutting down
+func (s *DHTService) isShuttingDown() bool {
+	s.workMu.Lock()
+	defer s.workMu.Unlock()
+	return s.shuttingDown
+}
+
+// Close closes the Mainline service, abandoning any background work in flight; closing it again is a no-op
 func (s *DHTService) Close() {
 	if s == nil {
 		return
 	}
-	if s.scheduler != nil {
-		s.scheduler.Stop()
+	s.closeOnce.Do(s.close)
+}
+
+func (s *DHTService) close() {
+	s.stopWork()
+	s.cancelWork()
+	s.republisher.stop()
+	s.peers.Close()
+	s.bus.Close()
+	s.archiver.Close()
+	s.mu.Lock()
+	s.collector.Close()
+	s.mu.Unlock()
+	s.resolutions.Close()
+	s.metrics.close()
+	if err := s.journal.Close(); err != nil {
+		logrus.WithError(err).Error("failed to close journal")
 	}
 	if s.cache != nil {
 		if err := s.cache.Close(); err != nil {
@@ -4,15 +4,21 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	anacrolixdht "github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/bep44"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/TBD54566975/did-dht/config"
 	"github.com/TBD54566975/did-dht/internal/did"
 	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/pubsub"
 	"github.com/TBD54566975/did-dht/pkg/storage"
 )
 
@@ -113,7 +119,7 @@ func TestDHTService(t *testing.T) {
 		require.NoError(t, err)
 
 		// remove it from the cache so the get tests the uncached lookup path
-		err = svc.cache.Delete(suffix)
+		err = svc.cache.Delete(context.Background(), suffix)
 		require.NoError(t, err)
 
 		got, err := svc.GetDHT(context.Background(), suffix)
@@ -129,9 +135,10 @@ func TestDHTService(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Empty(t, got)
 
-		// try it again to make sure the cache is working
+		// try it again to make sure the not found result is cached
+		assert.True(t, svc.cachedNotFound(context.Background(), "uqaj3fcr9db6jg6o9pjs53iuftyj45r46aubogfaceqjbo6pp9sy"))
 		got, err = svc.GetDHT(context.Background(), "uqaj3fcr9db6jg6o9pjs53iuftyj45r46aubogfaceqjbo6pp9sy")
-		assert.ErrorContains(t, err, "rate limited to prevent spam")
+		assert.NoError(t, err)
 		assert.Empty(t, got)
 	})
 
@@ -166,7 +173,7 @@ func TestDHT(t *testing.T) {
 	assert.Equal(t, putMsg.Seq, got.Seq)
 
 	// create service2 with service1 as a bootstrap peer
-	svc2 := newDHTService(t, "c", anacrolixdht.NewAddr(svc1.dht.Addr()))
+	svc2 := newDHTService(t, "c", anacrolixdht.NewAddr(svc1.dht.(*dht.DHT).Addr()))
 
 	// get the record via service2
 	gotFrom2, err := svc2.GetDHT(context.Background(), suffix)
@@ -192,21 +199,13 @@ func TestNoConfig(t *testing.T) {
 			CacheSizeLimitMB: -1,
 		},
 	}, nil, nil)
-	assert.EqualError(t, err, "failed to instantiate cache: HardMaxCacheSize must be >= 0")
-	assert.Nil(t, svc)
-
-	svc, err = NewDHTService(&config.Config{
-		DHTConfig: config.DHTServiceConfig{
-			RepublishCRON: "not a real cron expression",
-		},
-	}, nil, nil)
-	assert.EqualError(t, err, "failed to start republisher: gocron: cron expression failed to be parsed: failed to parse int from not: strconv.Atoi: parsing \"not\": invalid syntax")
+	assert.EqualError(t, err, "failed to instantiate badGetCache: HardMaxCacheSize must be >= 0")
 	assert.Nil(t, svc)
 
 	t.Cleanup(func() { svc.Close() })
 }
 
-func newDHTService(t *testing.T, id string, bootstrapPeers ...anacrolixdht.Addr) DHTService {
+func newDHTService(t *testing.T, id string, bootstrapPeers ...anacrolixdht.Addr) *DHTService {
 	defaultConfig := config.GetDefaultConfig()
 
 	db, err := storage.NewStorage(fmt.Sprintf("bolt://diddht-test-%s.db", id))
@@ -218,7 +217,768 @@ func newDHTService(t *testing.T, id string, bootstrapPeers ...anacrolixdht.Addr)
 	d := dht.NewTestDHT(t, bootstrapPeers...)
 	dhtService, err := NewDHTService(&defaultConfig, db, d)
 	require.NoError(t, err)
-	require.NotEmpty(t, dhtService)
+	require.NotNil(t, dhtService)
+
+	return dhtService
+}
+
+func TestDHTServiceSimulated(t *testing.T) {
+	svc, sim := newSimulatedDHTService(t, "d")
+	t.Cleanup(func() { svc.Close() })
+
+	newRecord := func(t *testing.T) (string, *bep44.Put) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		d := did.DHT(doc.ID)
+		packet, err := d.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		suffix, err := d.Suffix()
+		require.NoError(t, err)
+		return suffix, putMsg
+	}
+
+	t.Run("publish puts to the dht", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+		assert.Eventually(t, func() bool {
+			_, err := sim.GetFull(context.Background(), suffix)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, svc.cache.Delete(context.Background(), suffix))
+		got, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Equal(t, putMsg.Seq, got.Seq)
+	})
+
+	t.Run("unreachable dht falls back to storage", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+		require.NoError(t, svc.cache.Delete(context.Background(), suffix))
+
+		sim.SetUnreachable(true)
+		defer sim.SetUnreachable(false)
+		got, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Equal(t, putMsg.Sig, got.Sig)
+	})
+
+	t.Run("unreachable dht without a stored record is an error", func(t *testing.T) {
+		suffix, _ := newRecord(t)
+		sim.SetUnreachable(true)
+		defer sim.SetUnreachable(false)
+		_, err := svc.GetDHT(context.Background(), suffix)
+		assert.ErrorIs(t, err, dht.ErrStalled)
+	})
+
+	t.Run("not found results are cached until the record is published", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		got, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+
+		before := sim.Gets()
+		got, err = svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+		assert.Equal(t, before, sim.Gets())
+
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+		got, err = svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, putMsg.Seq, got.Seq)
+	})
+
+	t.Run("resolutions report their provenance", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+		assert.Eventually(t, func() bool {
+			_, err := sim.GetFull(context.Background(), suffix)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		resolve := func(t *testing.T) Provenance {
+			resolution, err := svc.ResolveDID(context.Background(), did.Prefix+":"+suffix)
+			require.NoError(t, err)
+			require.NotNil(t, resolution)
+			require.NotNil(t, resolution.Provenance)
+			assert.True(t, resolution.Provenance.SignatureVerified)
+			return *resolution.Provenance
+		}
+
+		require.NoError(t, svc.cache.Delete(context.Background(), suffix))
+		fromDHT := resolve(t)
+		assert.Equal(t, resolvedFromDHT, fromDHT.Source)
+		assert.Equal(t, 1, fromDHT.Confirmations)
+		assert.WithinDuration(t, time.Now(), fromDHT.RetrievedAt, time.Minute)
+
+		fromCache := resolve(t)
+		assert.Equal(t, resolvedFromCache, fromCache.Source)
+		assert.Zero(t, fromCache.Confirmations)
+
+		require.NoError(t, svc.cache.Delete(context.Background(), suffix))
+		sim.SetUnreachable(true)
+		defer sim.SetUnreachable(false)
+		assert.Equal(t, resolvedFromStorage, resolve(t).Source)
+	})
+
+	t.Run("stale cache hits are republished", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+		assert.Eventually(t, func() bool {
+			_, err := sim.GetFull(context.Background(), suffix)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+
+		// a fresh cache hit is served without a put
+		before := sim.Puts()
+		_, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Never(t, func() bool { return sim.Puts() > before }, 100*time.Millisecond, 10*time.Millisecond)
+
+		// age the cached record past the republish threshold, but not past its TTL
+		aged, err := json.Marshal(cachedRecord{
+			BEP44Response: dht.RecordFromBEP44(putMsg).Response(),
+			CachedAt:      time.Now().Add(-time.Duration(svc.cfg.DHTConfig.RepublishOnReadSeconds+1) * time.Second),
+		})
+		require.NoError(t, err)
+		require.NoError(t, svc.cache.Set(context.Background(), suffix, aged, time.Hour))
+
+		got, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Equal(t, putMsg.Seq, got.Seq)
+		assert.Eventually(t, func() bool { return sim.Puts() == before+1 }, time.Second, 10*time.Millisecond)
+
+		// the republish restarts the record's age
+		assert.Eventually(t, func() bool {
+			cached, err := svc.cache.Get(context.Background(), suffix)
+			require.NoError(t, err)
+			var c cachedRecord
+			require.NoError(t, json.Unmarshal(cached, &c))
+			return time.Since(c.CachedAt) < time.Minute
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("stale cache hits are served while they're refreshed", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+		assert.Eventually(t, func() bool {
+			_, err := sim.GetFull(context.Background(), suffix)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+
+		// cache an earlier version of the record, past its TTL but within the stale window
+		earlier := dht.RecordFromBEP44(putMsg).Response()
+		earlier.Seq--
+		stale, err := json.Marshal(cachedRecord{
+			BEP44Response: earlier,
+			CachedAt:      time.Now().Add(-svc.cacheTTL() - time.Second),
+		})
+		require.NoError(t, err)
+		require.NoError(t, svc.cache.Set(context.Background(), suffix, stale, time.Hour))
+
+		gets := sim.Gets()
+		got, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Equal(t, earlier.Seq, got.Seq)
+
+		// the record is refreshed from the dht in the background, once
+		_, err = svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			got, err := svc.GetDHT(context.Background(), suffix)
+			require.NoError(t, err)
+			return got.Seq == putMsg.Seq
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, gets+1, sim.Gets())
+	})
+
+	t.Run("published versions are resolved by sequence number and time", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		d := did.DHT(doc.ID)
+		packet, err := d.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		suffix, err := d.Suffix()
+		require.NoError(t, err)
+
+		first, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		second := *first
+		second.Seq += 100
+		second.Sign(sk)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(first)))
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(&second)))
+
+		got, err := svc.GetDHTVersion(context.Background(), suffix, first.Seq)
+		require.NoError(t, err)
+		assert.Equal(t, first.Sig, got.Sig)
+
+		got, err = svc.GetDHTVersion(context.Background(), suffix, first.Seq+1)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+
+		got, err = svc.GetDHTVersionAt(context.Background(), suffix, time.Unix(first.Seq+50, 0))
+		require.NoError(t, err)
+		assert.Equal(t, first.Seq, got.Seq)
+
+		got, err = svc.GetDHTVersionAt(context.Background(), suffix, time.Unix(second.Seq, 0))
+		require.NoError(t, err)
+		assert.Equal(t, second.Seq, got.Seq)
+
+		got, err = svc.GetDHTVersionAt(context.Background(), suffix, time.Unix(first.Seq-1, 0))
+		require.NoError(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("deactivated dids resolve to their tombstone", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		d := did.DHT(doc.ID)
+		packet, err := d.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		suffix, err := d.Suffix()
+		require.NoError(t, err)
+
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+		deactivationMsg, err := dht.CreateDNSPublishRequest(sk, *did.DeactivationDNSPacket())
+		require.NoError(t, err)
+		deactivation := *deactivationMsg
+		deactivation.Seq = putMsg.Seq + 1
+		deactivation.Sign(sk)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(&deactivation)))
+
+		// the tombstone is resolved from storage, without asking a dht that may still hold the earlier document
+		require.NoError(t, svc.cache.Delete(context.Background(), suffix))
+		gets := sim.Gets()
+		got, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Equal(t, deactivation.Seq, got.Seq)
+		assert.True(t, dht.IsDeactivation(got.V))
+		assert.Equal(t, gets, sim.Gets())
+
+		// later records are rejected
+		later := *putMsg
+		later.Seq = deactivation.Seq + 1
+		later.Sign(sk)
+		err = svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(&later))
+		assert.ErrorIs(t, err, dht.ErrDeactivated)
+
+		// tombstones aren't republished
+		before := sim.Puts()
+		svc.republishBatch(context.Background(), []dht.BEP44Record{dht.RecordFromBEP44(&deactivation)})
+		assert.Equal(t, before, sim.Puts())
+	})
+
+	t.Run("resolutions and republishes are kept in record metadata", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		record := dht.RecordFromBEP44(putMsg)
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, record))
+		for i := 0; i < 2; i++ {
+			_, err := svc.GetDHT(context.Background(), suffix)
+			require.NoError(t, err)
+		}
+		require.NoError(t, svc.resolutions.Flush(context.Background()))
+		assert.Empty(t, svc.republishBatch(context.Background(), []dht.BEP44Record{record}))
+
+		metadata, err := svc.GetRecordMetadata(context.Background(), suffix)
+		require.NoError(t, err)
+		require.NotNil(t, metadata)
+		assert.Equal(t, int64(2), metadata.Resolutions)
+		assert.NotNil(t, metadata.LastRepublished)
+	})
+
+	t.Run("records are republished as they come due", func(t *testing.T) {
+		// every record published so far is scheduled once its put finishes
+		assert.Eventually(t, func() bool { return svc.republisher.perTick() > 0 }, time.Second, 10*time.Millisecond)
+		svc.republisher.mu.Lock()
+		scheduled := len(svc.republisher.queue)
+		svc.republisher.mu.Unlock()
+		assert.GreaterOrEqual(t, scheduled, 2)
+		assert.Zero(t, svc.RepublishLag())
+
+		// nothing is due yet
+		before := sim.Puts()
+		svc.republishDue()
+		assert.Equal(t, before, sim.Puts())
+
+		// once due, records are republished a tick's share at a time, and rescheduled an interval on
+		svc.republisher.mu.Lock()
+		for _, r := range svc.republisher.queue {
+			r.due = time.Now().Add(-time.Hour)
+		}
+		svc.republisher.mu.Unlock()
+		assert.Greater(t, svc.RepublishLag(), time.Duration(0))
+		for i := 0; i < scheduled && svc.RepublishLag() > 0; i++ {
+			svc.republishDue()
+		}
+		assert.Zero(t, svc.RepublishLag())
+
+		// tombstones, stored after the put of an earlier version scheduled the record, are dropped rather than put
+		svc.republisher.mu.Lock()
+		republished := len(svc.republisher.queue)
+		for _, r := range svc.republisher.queue {
+			assert.WithinDuration(t, time.Now().Add(defaultRepublishInterval), r.due, time.Minute)
+		}
+		svc.republisher.mu.Unlock()
+		assert.GreaterOrEqual(t, republished, 2)
+		assert.Equal(t, before+republished, sim.Puts())
+	})
 
-	return *dhtService
+	t.Run("stored records are scheduled in the background once the service starts", func(t *testing.T) {
+		assert.Eventually(t, svc.RepublishScheduleLoaded, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("stored records are scheduled from when they were last published", func(t *testing.T) {
+		suffix, putMsg := newRecord(t)
+		require.NoError(t, svc.db.WriteRecord(context.Background(), dht.RecordFromBEP44(putMsg)))
+		svc.loadRepublishSchedule()
+
+		svc.republisher.mu.Lock()
+		defer svc.republisher.mu.Unlock()
+		scheduled, ok := svc.republisher.records[suffix]
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(defaultRepublishInterval), scheduled.due, time.Minute)
+	})
+
+	t.Run("storage is checked by reading from it", func(t *testing.T) {
+		assert.NoError(t, svc.CheckStorage(context.Background()))
+	})
+
+	t.Run("conditional publishes are rejected once there's a newer version", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		d := did.DHT(doc.ID)
+		packet, err := d.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		suffix, err := d.Suffix()
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		version := func(seq int64) dht.BEP44Record {
+			next := *putMsg
+			next.Seq = seq
+			next.Sign(sk)
+			return dht.RecordFromBEP44(&next)
+		}
+		first := putMsg.Seq
+
+		// with nothing published, any expected version is current
+		require.NoError(t, svc.PublishDHTIfSeq(context.Background(), suffix, version(first), first-1))
+		require.NoError(t, svc.PublishDHTIfSeq(context.Background(), suffix, version(first+1), first))
+
+		// a stale expected version conflicts with the stored one
+		err = svc.PublishDHTIfSeq(context.Background(), suffix, version(first+2), first)
+		var conflict *dht.SeqConflictError
+		require.ErrorAs(t, err, &conflict)
+		assert.ErrorIs(t, err, dht.ErrSeqConflict)
+		assert.Equal(t, first+1, conflict.Current)
+
+		// as does a newer version only seen on the dht
+		_, err = sim.Put(context.Background(), version(first+5).Put())
+		require.NoError(t, err)
+		err = svc.PublishDHTIfSeq(context.Background(), suffix, version(first+6), first+1)
+		require.ErrorAs(t, err, &conflict)
+		assert.Equal(t, first+5, conflict.Current)
+
+		// a conditional publish of the same record under way conflicts too
+		svc.conditionallyPublishing.Store(suffix, struct{}{})
+		err = svc.PublishDHTIfSeq(context.Background(), suffix, version(first+6), first+5)
+		assert.ErrorIs(t, err, dht.ErrSeqConflict)
+		svc.conditionallyPublishing.Delete(suffix)
+		assert.NoError(t, svc.PublishDHTIfSeq(context.Background(), suffix, version(first+6), first+5))
+	})
+
+	t.Run("new versions are sent to record subscribers", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		d := did.DHT(doc.ID)
+		packet, err := d.ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		suffix, err := d.Suffix()
+		require.NoError(t, err)
+		first, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		version := func(seq int64) *bep44.Put {
+			put := *first
+			put.Seq = seq
+			put.Sign(sk)
+			return &put
+		}
+		receive := func(t *testing.T, events <-chan pubsub.Event) pubsub.Event {
+			select {
+			case event := <-events:
+				return event
+			case <-time.After(time.Second):
+				require.FailNow(t, "no event received")
+				return pubsub.Event{}
+			}
+		}
+
+		require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(first)))
+		sub, err := svc.Subscribe(context.Background(), pubsub.Filter{IDs: []string{suffix}})
+		require.NoError(t, err)
+		defer sub.Close()
+		events := sub.Events()
+
+		second := version(first.Seq + 1)
+		require.NoError(t, svc.PublishPeerDHT(context.Background(), dht.RecordFromBEP44(second)))
+		event := receive(t, events)
+		assert.Equal(t, suffix, event.ID)
+		assert.Equal(t, second.Seq, event.Seq)
+		assert.Equal(t, pubsub.SourcePeer, event.Source)
+
+		// a newer version published elsewhere is found while republishing
+		third := version(first.Seq + 2)
+		_, err = sim.Put(context.Background(), *third)
+		require.NoError(t, err)
+		svc.republishBatch(context.Background(), []dht.BEP44Record{dht.RecordFromBEP44(second)})
+		event = receive(t, events)
+		assert.Equal(t, third.Seq, event.Seq)
+		assert.Equal(t, pubsub.SourceRepublish, event.Source)
+
+		// the cached version was dropped, so the newer version is resolved, but isn't sent again
+		got, err := svc.GetDHT(context.Background(), suffix)
+		require.NoError(t, err)
+		assert.Equal(t, third.Seq, got.Seq)
+		select {
+		case event = <-events:
+			assert.Failf(t, "unexpected event", "%+v", event)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		sub.Close()
+		_, ok := <-events
+		assert.False(t, ok)
+	})
+}
+
+func TestDHTServiceShutdown(t *testing.T) {
+	svc, sim := newSimulatedDHTService(t, "shutdown")
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	suffix, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+
+	// the put of a published record is finished before shutting down returns
+	require.NoError(t, svc.PublishDHT(context.Background(), suffix, dht.RecordFromBEP44(putMsg)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	svc.Shutdown(ctx)
+	_, err = sim.GetFull(context.Background(), suffix)
+	assert.NoError(t, err)
+
+	// no work starts once shut down, and closing again is a no-op
+	assert.False(t, svc.goWork(func(context.Context) {}))
+	svc.republisher.schedule(suffix, time.Now().Add(-time.Minute), 0)
+	svc.republishDue()
+	assert.Equal(t, 1, sim.Puts())
+	svc.Close()
+}
+
+func newSimulatedDHTService(t *testing.T, id string) (*DHTService, *dht.Simulator) {
+	defaultConfig := config.GetDefaultConfig()
+
+	db, err := storage.NewStorage(fmt.Sprintf("bolt://diddht-test-%s.db", id))
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove(fmt.Sprintf("diddht-test-%s.db", id)) })
+
+	sim := dht.NewSimulator()
+	dhtService, err := NewDHTService(&defaultConfig, db, sim)
+	require.NoError(t, err)
+	return dhtService, sim
+}
+
+func TestRepublishQuarantine(t *testing.T) {
+	svc, sim := newSimulatedDHTService(t, "quarantine")
+	defer svc.Close()
+	ctx := context.Background()
+
+	newStoredRecord := func(t *testing.T) string { return storeNewRecord(t, svc.db) }
+	failedCount := func(t *testing.T, id string) int {
+		failed, err := svc.ListFailedRecords(ctx)
+		require.NoError(t, err)
+		for _, f := range failed {
+			if f.ID == id {
+				return f.Count
+			}
+		}
+		return 0
+	}
+	makeDue := func() {
+		svc.republisher.mu.Lock()
+		for _, r := range svc.republisher.quarantine {
+			r.due = time.Now().Add(-time.Minute)
+		}
+		svc.republisher.mu.Unlock()
+	}
+
+	t.Run("records that keep failing are quarantined and backed off", func(t *testing.T) {
+		suffix := newStoredRecord(t)
+		sim.SetUnreachable(true)
+		defer sim.SetUnreachable(false)
+
+		svc.republisher.schedule(suffix, time.Now().Add(-time.Minute), republishAttempts-1)
+		svc.republishDue()
+		require.True(t, svc.republisher.isQuarantined(suffix))
+		assert.Equal(t, 1, failedCount(t, suffix))
+		assert.Zero(t, svc.RepublishLag())
+
+		quarantined := svc.ListQuarantinedRecords()
+		require.Len(t, quarantined, 1)
+		assert.Equal(t, suffix, quarantined[0].ID)
+		assert.Equal(t, republishAttempts, quarantined[0].Failures)
+		assert.WithinDuration(t, time.Now().Add(4*time.Minute), quarantined[0].NextAttempt, time.Minute)
+
+		// quarantined records aren't retried before their backoff passes, which doubles with each failure
+		puts := sim.Puts()
+		svc.republishDue()
+		assert.Equal(t, puts, sim.Puts())
+		makeDue()
+		svc.republishDue()
+		quarantined = svc.ListQuarantinedRecords()
+		require.Len(t, quarantined, 1)
+		assert.Equal(t, republishAttempts+1, quarantined[0].Failures)
+		assert.WithinDuration(t, time.Now().Add(8*time.Minute), quarantined[0].NextAttempt, time.Minute)
+		assert.Equal(t, 2, failedCount(t, suffix))
+
+		// the quarantine is rebuilt from the failed records
+		svc.republisher.unschedule(suffix)
+		svc.loadRepublishSchedule()
+		require.True(t, svc.republisher.isQuarantined(suffix))
+		assert.Equal(t, republishAttempts+1, svc.ListQuarantinedRecords()[0].Failures)
+	})
+
+	t.Run("quarantined records that republish are released", func(t *testing.T) {
+		require.Len(t, svc.ListQuarantinedRecords(), 1)
+		suffix := svc.ListQuarantinedRecords()[0].ID
+		makeDue()
+		svc.republishDue()
+		assert.False(t, svc.republisher.isQuarantined(suffix))
+		assert.Zero(t, failedCount(t, suffix))
+		assert.Empty(t, svc.ListQuarantinedRecords())
+	})
+
+	t.Run("quarantined records are retried or purged on demand", func(t *testing.T) {
+		suffix := newStoredRecord(t)
+		quarantined, err := svc.RetryQuarantinedRecord(ctx, suffix)
+		require.NoError(t, err)
+		assert.False(t, quarantined)
+
+		require.NoError(t, svc.db.WriteFailedRecord(ctx, suffix))
+		svc.republisher.quarantineRecord(suffix, time.Now().Add(time.Hour), republishAttempts)
+		puts := sim.Puts()
+		quarantined, err = svc.RetryQuarantinedRecord(ctx, suffix)
+		require.NoError(t, err)
+		assert.True(t, quarantined)
+		assert.Equal(t, puts+1, sim.Puts())
+		assert.False(t, svc.republisher.isQuarantined(suffix))
+		assert.Zero(t, failedCount(t, suffix))
+
+		require.NoError(t, svc.db.WriteFailedRecord(ctx, suffix))
+		svc.republisher.quarantineRecord(suffix, time.Now().Add(time.Hour), republishAttempts)
+		quarantined, err = svc.PurgeQuarantinedRecord(ctx, suffix)
+		require.NoError(t, err)
+		assert.True(t, quarantined)
+		assert.Zero(t, failedCount(t, suffix))
+		record, err := svc.db.ReadRecord(ctx, suffix)
+		require.NoError(t, err)
+		assert.Nil(t, record)
+
+		quarantined, err = svc.PurgeQuarantinedRecord(ctx, suffix)
+		require.NoError(t, err)
+		assert.False(t, quarantined)
+	})
+
+	t.Run("quarantined retries count toward the cap on each cycle", func(t *testing.T) {
+		// a short interval has every scheduled record due many times over each tick, so only the cap binds
+		cfg := config.GetDefaultConfig()
+		cfg.DHTConfig.RepublishIntervalSeconds = int(republishTick.Seconds())
+		cfg.DHTConfig.RepublishMaxRecordsPerCycle = 2
+		db, err := storage.NewStorage("bolt://diddht-test-quarantine-cap.db")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.Remove("diddht-test-quarantine-cap.db") })
+		sim := dht.NewSimulator()
+		capped, err := NewDHTService(&cfg, db, sim)
+		require.NoError(t, err)
+		defer capped.Close()
+
+		retried := storeNewRecord(t, capped.db)
+		capped.republisher.quarantineRecord(retried, time.Now().Add(-time.Minute), republishAttempts)
+		capped.republisher.schedule(storeNewRecord(t, capped.db), time.Now().Add(-2*time.Minute), 0)
+		waiting := storeNewRecord(t, capped.db)
+		capped.republisher.schedule(waiting, time.Now().Add(-time.Minute), 0)
+
+		puts := sim.Puts()
+		capped.republishDue()
+		assert.Equal(t, puts+2, sim.Puts())
+		assert.False(t, capped.republisher.isQuarantined(retried))
+		due := capped.republisher.popDue(time.Now(), 10)
+		require.Len(t, due, 1)
+		assert.Equal(t, waiting, due[0].id)
+	})
+}
+
+// sharedStorage is storage shared by gateway replicas, claiming records to republish in memory as postgres does in its
+// lease table
+type sharedStorage struct {
+	storage.Storage
+	mu     sync.Mutex
+	leases map[string]republishLeaseHeld
+}
+
+type republishLeaseHeld struct {
+	holder  string
+	expires time.Time
+}
+
+func (s *sharedStorage) ClaimRepublish(ctx context.Context, holder string, ids []string, republishedSince time.Time, lease time.Duration) (*dht.RepublishClaims, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claims := dht.RepublishClaims{Republished: make(map[string]time.Time), Leased: make(map[string]time.Time)}
+	for _, id := range ids {
+		metadata, err := s.ReadRecordMetadata(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		switch held, ok := s.leases[id]; {
+		case metadata == nil:
+		case metadata.LastRepublished != nil && !metadata.LastRepublished.Before(republishedSince):
+			claims.Republished[id] = *metadata.LastRepublished
+		case ok && held.holder != holder && held.expires.After(time.Now()):
+			claims.Leased[id] = held.expires
+		default:
+			s.leases[id] = republishLeaseHeld{holder: holder, expires: time.Now().Add(lease)}
+			claims.Claimed = append(claims.Claimed, id)
+		}
+	}
+	return &claims, nil
+}
+
+func TestRepublishCoordination(t *testing.T) {
+	db, err := storage.NewStorage("bolt://diddht-test-coordination.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove("diddht-test-coordination.db") })
+	shared := &sharedStorage{Storage: db, leases: make(map[string]republishLeaseHeld)}
+
+	cfg := config.GetDefaultConfig()
+	sim := dht.NewSimulator()
+	a, err := NewDHTService(&cfg, shared, sim)
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := NewDHTService(&cfg, shared, sim)
+	require.NoError(t, err)
+	defer b.Close()
+	require.NotNil(t, a.republisher.claimer)
+	assert.NotEqual(t, a.republisher.holder, b.republisher.holder)
+
+	ids := []string{storeNewRecord(t, db), storeNewRecord(t, db), storeNewRecord(t, db)}
+	scheduleDue := func(svc *DHTService, ids ...string) {
+		for _, id := range ids {
+			svc.republisher.schedule(id, time.Now().Add(-time.Minute), 0)
+		}
+	}
+
+	t.Run("each record is republished by one replica", func(t *testing.T) {
+		scheduleDue(a, ids...)
+		scheduleDue(b, ids...)
+
+		puts := sim.Puts()
+		for i := 0; i < len(ids); i++ {
+			a.republishDue()
+		}
+		assert.Equal(t, puts+len(ids), sim.Puts())
+
+		// the records the other replica republished are skipped in one tick, and scheduled an interval after
+		b.republishDue()
+		assert.Equal(t, puts+len(ids), sim.Puts())
+		assert.Empty(t, b.republisher.popDue(time.Now().Add(defaultRepublishInterval-time.Minute), 10))
+		assert.Len(t, b.republisher.popDue(time.Now().Add(defaultRepublishInterval+time.Minute), 10), len(ids))
+	})
+
+	t.Run("records another replica has claimed are left until its claim expires", func(t *testing.T) {
+		id := storeNewRecord(t, db)
+		claims, err := shared.ClaimRepublish(context.Background(), "other", []string{id}, time.Now(), republishLease)
+		require.NoError(t, err)
+		require.Equal(t, []string{id}, claims.Claimed)
+
+		scheduleDue(a, id)
+		puts := sim.Puts()
+		a.republishDue()
+		assert.Equal(t, puts, sim.Puts())
+		assert.Empty(t, a.republisher.popDue(time.Now().Add(republishLease-time.Minute), 10))
+		assert.Len(t, a.republisher.popDue(time.Now().Add(republishLease+time.Minute), 10), 1)
+	})
+}
+
+// unreadableStorage is storage that times out reading records in bulk
+type unreadableStorage struct {
+	storage.Storage
+}
+
+func (unreadableStorage) ReadRecords(context.Context, []string) (map[string]dht.BEP44Record, error) {
+	time.Sleep(10 * time.Millisecond)
+	return nil, errors.New("storage unavailable")
+}
+
+func TestRepublishUnreadable(t *testing.T) {
+	db, err := storage.NewStorage("bolt://diddht-test-unreadable.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove("diddht-test-unreadable.db") })
+
+	cfg := config.GetDefaultConfig()
+	svc, err := NewDHTService(&cfg, unreadableStorage{Storage: db}, dht.NewSimulator())
+	require.NoError(t, err)
+	defer svc.Close()
+
+	// records are rescheduled while others are being scheduled
+	ids := []string{storeNewRecord(t, db), storeNewRecord(t, db)}
+	svc.republisher.schedule(ids[0], time.Now().Add(-time.Minute), 2)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				svc.republisher.schedule(ids[1], time.Now().Add(defaultRepublishInterval), 0)
+			}
+		}
+	}()
+	svc.republishDue()
+	close(done)
+	wg.Wait()
+
+	svc.republisher.mu.Lock()
+	defer svc.republisher.mu.Unlock()
+	scheduled, ok := svc.republisher.records[ids[0]]
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(republishRetryBackoff), scheduled.due, time.Second)
+	assert.Equal(t, 2, scheduled.failures)
+}
+
+// storeNewRecord writes the record of a new DID to the storage, returning its ID
+func storeNewRecord(t *testing.T, db storage.Storage) string {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	suffix, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+	require.NoError(t, db.WriteRecord(context.Background(), dht.RecordFromBEP44(putMsg)))
+	return suffix
 }
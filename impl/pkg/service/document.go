@@ -0,0 +1,193 @@
+package service
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// identityKeyID is the verification method ID of a DID's identity key
+const identityKeyID = "0"
+
+// validateDocument checks that a published record for a DID decodes to a DID document following the rules of DID
+// Core and the DID DHT spec, returning a DocumentError listing every property that breaks one. Records that publish
+// no DID, such as salted records, Pkarr packets, and tombstones, aren't checked. The value is expected to have
+// passed CheckLimits.
+func validateDocument(record dht.BEP44Record) error {
+	if len(record.Salt) > 0 || record.Deactivated() {
+		return nil
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(record.Value); err != nil {
+		return errors.Wrap(dht.ErrInvalidDNSPacket, err.Error())
+	}
+	if !publishesDID(record.ID(), msg) {
+		return nil
+	}
+
+	doc, err := did.DHT(did.Prefix + ":" + record.ID()).FromDNSPacket(msg)
+	if err != nil {
+		return errors.Wrapf(dht.ErrInvalidDocument, "failed to decode did document: %s", err.Error())
+	}
+	identityKey, err := did.DHT(doc.Doc.ID).IdentityKey()
+	if err != nil {
+		return errors.Wrapf(dht.ErrInvalidDocument, "failed to decode identity key: %s", err.Error())
+	}
+	if fields := documentErrors(doc.Doc, base64.RawURLEncoding.EncodeToString(identityKey)); len(fields) > 0 {
+		return &dht.DocumentError{ID: doc.Doc.ID, Fields: fields}
+	}
+	return nil
+}
+
+// publishesDID reports whether the DNS packet publishes a DID, having a root record or another _did. record
+func publishesDID(id string, msg *dns.Msg) bool {
+	for _, rr := range msg.Answer {
+		if name := rr.Header().Name; name == "_did."+id+"." || strings.HasSuffix(name, "._did.") {
+			return true
+		}
+	}
+	return false
+}
+
+// documentErrors returns the properties of the document breaking a rule: the identity key, with the base64url
+// encoded x, must be the verification method with ID 0; verification methods and services need unique IDs;
+// verification relationships must reference the document's verification methods; controllers must be DIDs;
+// alsoKnownAs entries must be absolute URIs; and service endpoints must be absolute URIs or maps
+func documentErrors(doc didsdk.Document, identityKeyX string) []dht.FieldError {
+	var fields []dht.FieldError
+	addError := func(field, message string) {
+		fields = append(fields, dht.FieldError{Field: field, Message: message})
+	}
+
+	for i, controller := range stringList(doc.Controller) {
+		if !did.IsDID(controller) {
+			addError(indexed("controller", i), "must be a DID")
+		}
+	}
+	for i, aka := range stringList(doc.AlsoKnownAs) {
+		if !did.IsAbsoluteURI(aka) {
+			addError(indexed("alsoKnownAs", i), "must be an absolute URI")
+		}
+	}
+
+	methods := make(map[string]int, len(doc.VerificationMethod))
+	hasIdentityKey := false
+	for i, vm := range doc.VerificationMethod {
+		field := indexed("verificationMethod", i)
+		if first, ok := methods[vm.ID]; ok {
+			addError(field+".id", "duplicates "+indexed("verificationMethod", first)+".id")
+		} else {
+			methods[vm.ID] = i
+		}
+		if !did.IsDID(vm.Controller) {
+			addError(field+".controller", "must be a DID")
+		}
+		if vm.ID != doc.ID+"#"+identityKeyID {
+			continue
+		}
+		if vm.PublicKeyJWK == nil || vm.PublicKeyJWK.X != identityKeyX {
+			addError(field+".publicKeyJwk", "must be the identity key, as verification method "+identityKeyID)
+			continue
+		}
+		hasIdentityKey = true
+	}
+	if !hasIdentityKey {
+		addError("verificationMethod", "is missing the identity key")
+	}
+
+	for _, relationship := range []struct {
+		name string
+		refs []didsdk.VerificationMethodSet
+	}{
+		{"authentication", doc.Authentication},
+		{"assertionMethod", doc.AssertionMethod},
+		{"keyAgreement", doc.KeyAgreement},
+		{"capabilityInvocation", doc.CapabilityInvocation},
+		{"capabilityDelegation", doc.CapabilityDelegation},
+	} {
+		for i, ref := range relationship.refs {
+			if id, ok := ref.(string); ok {
+				if _, found := methods[id]; !found {
+					addError(indexed(relationship.name, i), "references no verification method: "+id)
+				}
+			}
+		}
+	}
+
+	services := make(map[string]int, len(doc.Services))
+	for i, service := range doc.Services {
+		field := indexed("service", i)
+		switch first, ok := services[service.ID]; {
+		case strings.HasSuffix(service.ID, "#"):
+			addError(field+".id", "is missing")
+		case ok:
+			addError(field+".id", "duplicates "+indexed("service", first)+".id")
+		default:
+			services[service.ID] = i
+		}
+		if service.Type == "" {
+			addError(field+".type", "is missing")
+		}
+		endpoints := endpointList(service.ServiceEndpoint)
+		if len(endpoints) == 0 {
+			addError(field+".serviceEndpoint", "is missing")
+		}
+		for j, endpoint := range endpoints {
+			switch endpoint := endpoint.(type) {
+			case string:
+				if !did.IsAbsoluteURI(endpoint) {
+					addError(indexed(field+".serviceEndpoint", j), "must be an absolute URI")
+				}
+			case map[string]any:
+				if len(endpoint) == 0 {
+					addError(indexed(field+".serviceEndpoint", j), "must not be an empty map")
+				}
+			default:
+				addError(indexed(field+".serviceEndpoint", j), "must be an absolute URI or a map")
+			}
+		}
+	}
+	return fields
+}
+
+// endpointList returns a service endpoint, which is a URI, a map, or a list of them, as a list
+func endpointList(serviceEndpoint any) []any {
+	switch se := serviceEndpoint.(type) {
+	case nil:
+		return nil
+	case []any:
+		return se
+	case []string:
+		endpoints := make([]any, 0, len(se))
+		for _, uri := range se {
+			endpoints = append(endpoints, uri)
+		}
+		return endpoints
+	default:
+		return []any{se}
+	}
+}
+
+// stringList returns a property that's a string or a list of strings as a list
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	default:
+		return nil
+	}
+}
+
+// indexed returns the path of an element of a list property
+func indexed(field string, i int) string {
+	return field + "[" + strconv.Itoa(i) + "]"
+}
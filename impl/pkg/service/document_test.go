@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestDocumentErrors(t *testing.T) {
+	_, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	identityKey, err := did.DHT(doc.ID).IdentityKey()
+	require.NoError(t, err)
+	identityKeyX := base64.RawURLEncoding.EncodeToString(identityKey)
+	assert.Empty(t, documentErrors(*doc, identityKeyX))
+
+	tests := []struct {
+		name   string
+		modify func(doc *didsdk.Document)
+		want   []string
+	}{
+		{
+			name: "missing identity key",
+			modify: func(doc *didsdk.Document) {
+				doc.VerificationMethod = nil
+				doc.Authentication, doc.AssertionMethod = nil, nil
+				doc.CapabilityInvocation, doc.CapabilityDelegation = nil, nil
+			},
+			want: []string{"verificationMethod"},
+		},
+		{
+			name: "another key as the identity key",
+			modify: func(doc *didsdk.Document) {
+				jwk := *doc.VerificationMethod[0].PublicKeyJWK
+				jwk.X = base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+				doc.VerificationMethod[0].PublicKeyJWK = &jwk
+			},
+			want: []string{"verificationMethod[0].publicKeyJwk", "verificationMethod"},
+		},
+		{
+			name: "invalid references and controllers",
+			modify: func(doc *didsdk.Document) {
+				doc.Controller = []string{doc.ID, "alice"}
+				doc.AlsoKnownAs = []string{"example.com"}
+				doc.VerificationMethod = append(doc.VerificationMethod, doc.VerificationMethod[0])
+				doc.VerificationMethod[1].Controller = "bob"
+				doc.Authentication = append(doc.Authentication, doc.ID+"#missing")
+			},
+			want: []string{
+				"controller[1]", "alsoKnownAs[0]", "verificationMethod[1].id", "verificationMethod[1].controller",
+				"authentication[1]",
+			},
+		},
+		{
+			name: "invalid services",
+			modify: func(doc *didsdk.Document) {
+				doc.Services = []didsdk.Service{
+					{ID: doc.ID + "#dwn", Type: "DecentralizedWebNode", ServiceEndpoint: []string{"https://dwn.example.com", "dwn"}},
+					{ID: doc.ID + "#dwn", ServiceEndpoint: []string{"did:web:example.com"}},
+					{ID: doc.ID + "#", Type: "LinkedDomains"},
+				}
+			},
+			want: []string{
+				"service[0].serviceEndpoint[1]", "service[1].id", "service[1].type", "service[2].id",
+				"service[2].serviceEndpoint",
+			},
+		},
+		{
+			name: "structured service endpoints",
+			modify: func(doc *didsdk.Document) {
+				doc.Services = []didsdk.Service{
+					{ID: doc.ID + "#dwn", Type: "DecentralizedWebNode", ServiceEndpoint: map[string]any{"nodes": []any{"https://dwn.example.com"}}},
+					{ID: doc.ID + "#hub", Type: "MessagingService", ServiceEndpoint: []any{"https://example.com", map[string]any{}, 5.0}},
+				}
+			},
+			want: []string{"service[1].serviceEndpoint[1]", "service[1].serviceEndpoint[2]"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+			require.NoError(t, err)
+			identityKey, err := did.DHT(doc.ID).IdentityKey()
+			require.NoError(t, err)
+			test.modify(doc)
+
+			var got []string
+			for _, field := range documentErrors(*doc, base64.RawURLEncoding.EncodeToString(identityKey)) {
+				got = append(got, field.Field)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestValidateDocument(t *testing.T) {
+	svc, _ := newSimulatedDHTService(t, "validate-document")
+	defer svc.Close()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	doc.Services = []didsdk.Service{{ID: doc.ID + "#dwn", Type: "DWN", ServiceEndpoint: "dwn.example.com"}}
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(putMsg)
+
+	err = svc.PublishDHT(context.Background(), record.ID(), record)
+	assert.ErrorIs(t, err, dht.ErrInvalidDocument)
+	var docErr *dht.DocumentError
+	require.ErrorAs(t, err, &docErr)
+	assert.Equal(t, []dht.FieldError{{Field: "service[0].serviceEndpoint[0]", Message: "must be an absolute URI"}}, docErr.Fields)
+
+	// peers are held to the same checks
+	assert.ErrorIs(t, svc.PublishPeerDHT(context.Background(), record), dht.ErrInvalidDocument)
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/TBD54566975/did-dht/pkg/abuse"
+	"github.com/TBD54566975/did-dht/pkg/cache"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// the sources a record is resolved from
+const (
+	resolvedFromCache   = "cache"
+	resolvedFromStorage = "storage"
+	resolvedFromDHT     = "dht"
+	// resolvedFromNone is the source of resolutions that found no record
+	resolvedFromNone = "none"
+)
+
+var (
+	// resolutionBuckets are the bounds of the resolution latency buckets, in seconds
+	resolutionBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	// republishBatchBuckets are the bounds of the republish batch and cycle duration buckets, in seconds
+	republishBatchBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600}
+)
+
+// serviceMetrics are the instruments the service records resolutions, publishes, and republishes with
+type serviceMetrics struct {
+	resolutions      metric.Float64Histogram
+	publishes        metric.Int64Counter
+	abuseVerdicts    metric.Int64Counter
+	republishBatches metric.Float64Histogram
+	republishCycles  metric.Float64Histogram
+	// records unregisters the callback observing the stored record counts
+	records metric.Registration
+	// queue unregisters the callback observing the depth of the republish queue
+	queue metric.Registration
+	// cacheLookups unregisters the callback observing the lookups of a tiered cache; nil for other caches
+	cacheLookups metric.Registration
+}
+
+func newServiceMetrics(db storage.Storage, r *republisher, c cache.Cache) *serviceMetrics {
+	meter := telemetry.GetMeter()
+	m := new(serviceMetrics)
+	var err error
+	if m.resolutions, err = meter.Float64Histogram("dht.resolution.duration", metric.WithUnit("s"),
+		metric.WithDescription("latency of record resolutions by the source they were resolved from"),
+		metric.WithExplicitBucketBoundaries(resolutionBuckets...)); err != nil {
+		logrus.WithError(err).Error("failed to create resolution histogram")
+		m.resolutions = noop.Float64Histogram{}
+	}
+	if m.publishes, err = meter.Int64Counter("dht.publishes",
+		metric.WithDescription("records published to the gateway by source and result")); err != nil {
+		logrus.WithError(err).Error("failed to create publish counter")
+		m.publishes = noop.Int64Counter{}
+	}
+	if m.abuseVerdicts, err = meter.Int64Counter("abuse.verdicts",
+		metric.WithDescription("records published to the gateway scored by the abuse filter by the action taken")); err != nil {
+		logrus.WithError(err).Error("failed to create abuse verdict counter")
+		m.abuseVerdicts = noop.Int64Counter{}
+	}
+	if m.republishBatches, err = meter.Float64Histogram("dht.republish.batch.duration", metric.WithUnit("s"),
+		metric.WithDescription("time taken to republish a batch of records"),
+		metric.WithExplicitBucketBoundaries(republishBatchBuckets...)); err != nil {
+		logrus.WithError(err).Error("failed to create republish batch histogram")
+		m.republishBatches = noop.Float64Histogram{}
+	}
+	if m.republishCycles, err = meter.Float64Histogram("dht.republish.cycle.duration", metric.WithUnit("s"),
+		metric.WithDescription("time taken to republish the records due in one cycle of the republisher"),
+		metric.WithExplicitBucketBoundaries(republishBatchBuckets...)); err != nil {
+		logrus.WithError(err).Error("failed to create republish cycle histogram")
+		m.republishCycles = noop.Float64Histogram{}
+	}
+	if m.records, err = registerRecordCounts(meter, db); err != nil {
+		logrus.WithError(err).Error("failed to register record count gauges")
+	}
+	if m.queue, err = registerRepublishQueueDepth(meter, r); err != nil {
+		logrus.WithError(err).Error("failed to register republish queue gauge")
+	}
+	if tiered, ok := c.(*cache.Tiered); ok {
+		if m.cacheLookups, err = registerCacheLookups(meter, tiered); err != nil {
+			logrus.WithError(err).Error("failed to register cache lookup counter")
+		}
+	}
+	return m
+}
+
+// registerCacheLookups observes how many lookups of the tiered cache each tier answered, and how many missed both
+// tiers, whenever metrics are collected
+func registerCacheLookups(meter metric.Meter, c *cache.Tiered) (metric.Registration, error) {
+	lookups, err := meter.Int64ObservableCounter("cache.lookups",
+		metric.WithDescription("lookups of the record cache by the tier that answered them"))
+	if err != nil {
+		return nil, err
+	}
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := c.Stats()
+		o.ObserveInt64(lookups, stats.HotHits, metric.WithAttributes(attribute.String("tier", "hot")))
+		o.ObserveInt64(lookups, stats.ColdHits, metric.WithAttributes(attribute.String("tier", "cold")))
+		o.ObserveInt64(lookups, stats.Misses, metric.WithAttributes(attribute.String("tier", "miss")))
+		return nil
+	}, lookups)
+}
+
+// registerRepublishQueueDepth observes how many records are scheduled for republishing, and how many are
+// quarantined, whenever metrics are collected
+func registerRepublishQueueDepth(meter metric.Meter, r *republisher) (metric.Registration, error) {
+	depth, err := meter.Int64ObservableGauge("dht.republish.queue.depth",
+		metric.WithDescription("records waiting to be republished by queue"))
+	if err != nil {
+		return nil, err
+	}
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		scheduled, quarantined := r.depth()
+		o.ObserveInt64(depth, int64(scheduled), metric.WithAttributes(attribute.String("queue", "scheduled")))
+		o.ObserveInt64(depth, int64(quarantined), metric.WithAttributes(attribute.String("queue", "quarantined")))
+		return nil
+	}, depth)
+}
+
+// registerRecordCounts observes how many records and failed records are stored whenever metrics are collected
+func registerRecordCounts(meter metric.Meter, db storage.Storage) (metric.Registration, error) {
+	records, err := meter.Int64ObservableGauge("storage.records", metric.WithDescription("records stored"))
+	if err != nil {
+		return nil, err
+	}
+	failed, err := meter.Int64ObservableGauge("storage.failed_records",
+		metric.WithDescription("records stored that failed to be republished"))
+	if err != nil {
+		return nil, err
+	}
+	return meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		if n, err := db.RecordCount(ctx); err == nil {
+			o.ObserveInt64(records, int64(n))
+		}
+		if n, err := db.FailedRecordCount(ctx); err == nil {
+			o.ObserveInt64(failed, int64(n))
+		}
+		return nil
+	}, records, failed)
+}
+
+// recordResolution records the latency of a resolution that started at the given time
+func (m *serviceMetrics) recordResolution(ctx context.Context, source string, start time.Time) {
+	m.resolutions.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("source", source)))
+}
+
+// recordPublish counts a publish from the given source, which failed if err isn't nil
+func (m *serviceMetrics) recordPublish(ctx context.Context, source string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.publishes.Add(ctx, 1, metric.WithAttributes(attribute.String("source", source), attribute.String("result", result)))
+}
+
+// recordAbuseVerdict counts a record scored by the abuse filter, by the action taken
+func (m *serviceMetrics) recordAbuseVerdict(ctx context.Context, action abuse.Action) {
+	m.abuseVerdicts.Add(ctx, 1, metric.WithAttributes(attribute.String("action", string(action))))
+}
+
+// recordRepublishBatch records the duration of a republish batch that started at the given time
+func (m *serviceMetrics) recordRepublishBatch(ctx context.Context, start time.Time) {
+	m.republishBatches.Record(ctx, time.Since(start).Seconds())
+}
+
+// recordRepublishCycle records the duration of a republisher cycle that started at the given time
+func (m *serviceMetrics) recordRepublishCycle(ctx context.Context, start time.Time) {
+	m.republishCycles.Record(ctx, time.Since(start).Seconds())
+}
+
+// close stops observing the stored record counts, republish queue depth, and cache lookups
+func (m *serviceMetrics) close() {
+	if m == nil {
+		return
+	}
+	if m.records != nil {
+		if err := m.records.Unregister(); err != nil {
+			logrus.WithError(err).Error("failed to unregister record count gauges")
+		}
+	}
+	if m.queue != nil {
+		if err := m.queue.Unregister(); err != nil {
+			logrus.WithError(err).Error("failed to unregister republish queue gauge")
+		}
+	}
+	if m.cacheLookups != nil {
+		if err := m.cacheLookups.Unregister(); err != nil {
+			logrus.WithError(err).Error("failed to unregister cache lookup counter")
+		}
+	}
+}
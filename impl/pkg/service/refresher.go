@@ -0,0 +1,148 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+const (
+	// popularityTrackedPerRefreshed is how many records the popularity tracker counts for each record refreshed,
+	// bounding its memory while leaving room for records rising in popularity
+	popularityTrackedPerRefreshed = 10
+	// refreshWorkers is the number of records refreshed at once
+	refreshWorkers = 8
+	// refreshTimeout is how long each refresh of a record gets
+	refreshTimeout = 15 * time.Second
+)
+
+// popularity counts the resolutions of records, halving the counts every refresh cycle so records resolved lately
+// rank above records resolved long ago. It tracks a bounded number of records; records resolved while it's full are
+// counted once the halving drops the records no longer resolved.
+type popularity struct {
+	mu     sync.Mutex
+	counts map[string]float64
+	limit  int
+}
+
+func newPopularity(limit int) *popularity {
+	return &popularity{counts: make(map[string]float64), limit: limit}
+}
+
+// count counts a resolution of the record with the given ID; a nil tracker counts nothing
+func (p *popularity) count(id string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.counts[id]; ok || len(p.counts) < p.limit {
+		p.counts[id]++
+	}
+}
+
+// top returns the IDs of the n records resolved most, most resolved first, then halves every count, dropping the
+// records whose counts fall below one
+func (p *popularity) top(n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]string, 0, len(p.counts))
+	for id := range p.counts {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return cmp.Or(cmp.Compare(p.counts[b], p.counts[a]), cmp.Compare(a, b))
+	})
+	for id, count := range p.counts {
+		if count /= 2; count < 1 {
+			delete(p.counts, id)
+		} else {
+			p.counts[id] = count
+		}
+	}
+	return ids[:min(n, len(ids))]
+}
+
+// refreshInterval is how often the most resolved records are refreshed
+func (s *DHTService) refreshInterval() time.Duration {
+	if s.cfg.DHTConfig.CacheRefreshIntervalSeconds <= 0 {
+		return s.cacheTTL() / 10
+	}
+	return time.Duration(s.cfg.DHTConfig.CacheRefreshIntervalSeconds) * time.Second
+}
+
+// runRefresher refreshes the most resolved records every refresh interval until the service is closed
+func (s *DHTService) runRefresher() {
+	ticker := time.NewTicker(s.refreshInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.workCtx.Done():
+			return
+		case <-ticker.C:
+			s.refreshPopular()
+		}
+	}
+}
+
+// refreshPopular resolves the most resolved records from storage and the DHT, caching them afresh, when their cached
+// copies would otherwise go stale before the next refresh. Records already being refreshed are skipped.
+func (s *DHTService) refreshPopular() {
+	if !s.beginWork() {
+		return
+	}
+	defer s.work.Done()
+	ctx, span := telemetry.GetTracer().Start(s.workCtx, "DHTService.refreshPopular")
+	defer span.End()
+
+	staleAt := s.cacheTTL() - s.refreshInterval()
+	var due []string
+	for _, id := range s.popular.top(s.cfg.DHTConfig.CacheRefreshTopN) {
+		if cached := s.readCache(ctx, id); cached == nil || time.Since(cached.CachedAt) >= staleAt {
+			due = append(due, id)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range min(refreshWorkers, len(due)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				s.refreshRecord(ctx, id)
+			}
+		}()
+	}
+	for _, id := range due {
+		work <- id
+	}
+	close(work)
+	wg.Wait()
+	logrus.WithContext(ctx).WithField("records", len(due)).Debug("refreshed most resolved records")
+}
+
+// refreshRecord resolves the record with the given ID from storage and the DHT, caching it afresh, unless it's being
+// revalidated already
+func (s *DHTService) refreshRecord(ctx context.Context, id string) {
+	if _, inFlight := s.revalidating.LoadOrStore(id, struct{}{}); inFlight {
+		return
+	}
+	defer s.revalidating.Delete(id)
+
+	refreshCtx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	if _, _, err := s.resolve(refreshCtx, id); err != nil {
+		logrus.WithContext(ctx).WithField("record_id", id).WithError(err).Warn("failed to refresh most resolved record")
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPopularity(t *testing.T) {
+	p := newPopularity(3)
+	for id, n := range map[string]int{"a": 1, "b": 4, "c": 2} {
+		for range n {
+			p.count(id)
+		}
+	}
+	// the tracker is full, so d isn't counted
+	p.count("d")
+	assert.Equal(t, []string{"b", "c"}, p.top(2))
+
+	// halving dropped a, making room for d
+	p.count("d")
+	p.count("d")
+	p.count("d")
+	assert.Equal(t, []string{"d", "b", "c"}, p.top(5))
+
+	var nilTracker *popularity
+	nilTracker.count("a")
+}
+
+func TestRefreshPopular(t *testing.T) {
+	svc, sim := newSimulatedDHTService(t, "refresher")
+	t.Cleanup(func() { svc.Close() })
+	ctx := context.Background()
+	require.NotNil(t, svc.popular)
+
+	publish := func(t *testing.T) string {
+		id := storeNewRecord(t, svc.db)
+		record, err := svc.db.ReadRecord(ctx, id)
+		require.NoError(t, err)
+		require.NoError(t, svc.PublishDHT(ctx, id, *record))
+		_, err = svc.GetDHT(ctx, id)
+		require.NoError(t, err)
+		return id
+	}
+	stale, fresh := publish(t), publish(t)
+	require.Eventually(t, func() bool { return sim.Puts() >= 2 }, time.Second, 10*time.Millisecond)
+
+	// age the cached copy of one record to where it would go stale before the next refresh
+	aged := time.Now().Add(-svc.cacheTTL() + svc.refreshInterval()/2)
+	cached := svc.readCache(ctx, stale)
+	require.NotNil(t, cached)
+	agedBytes, err := json.Marshal(cachedRecord{BEP44Response: cached.BEP44Response, CachedAt: aged})
+	require.NoError(t, err)
+	require.NoError(t, svc.cache.Set(ctx, stale, agedBytes, time.Hour))
+
+	before := sim.Gets()
+	svc.refreshPopular()
+	assert.Equal(t, before+1, sim.Gets())
+	refreshed := svc.readCache(ctx, stale)
+	require.NotNil(t, refreshed)
+	assert.WithinDuration(t, time.Now(), refreshed.CachedAt, time.Minute)
+	assert.NotNil(t, svc.readCache(ctx, fresh))
+
+	// records not resolved since drop out of the refresh
+	require.NoError(t, svc.cache.Delete(ctx, stale))
+	before = sim.Gets()
+	svc.refreshPopular()
+	assert.Equal(t, before, sim.Gets())
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net"
+	"net/url"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// GatewayServiceType is the type of the service a gateway's DID document names the gateway's base URL with
+const GatewayServiceType = "DIDDHTGateway"
+
+// Gateway is a gateway listed for clients to discover
+type Gateway struct {
+	DID string `json:"did"`
+	// Endpoints are the base URLs the gateway's DID document names in its gateway services
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Self is set on the gateway's own DID, if it announces itself
+	Self bool `json:"self,omitempty"`
+	// Error is why the gateway's DID couldn't be resolved, if it couldn't
+	Error string `json:"error,omitempty"`
+}
+
+// AnnounceGateway publishes the gateway's own DID, keyed by the given identity key, with a document naming the
+// configured base URL as a gateway service. If the base URL's host is a domain name, the gateway is designated
+// authoritative for the DID. The DID is listed first by ListGateways.
+func (s *DHTService) AnnounceGateway(ctx context.Context, identityKey ed25519.PrivateKey) (string, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.AnnounceGateway")
+	defer span.End()
+
+	baseURL, err := url.Parse(s.cfg.ServerConfig.BaseURL)
+	if err != nil || !baseURL.IsAbs() || baseURL.Host == "" {
+		return "", errors.Errorf("the base url %q must be an absolute url to announce the gateway", s.cfg.ServerConfig.BaseURL)
+	}
+	doc, err := did.CreateDIDDHTDID(identityKey.Public().(ed25519.PublicKey), did.CreateDIDDHTOpts{
+		Services: []didsdk.Service{{
+			ID:              "gateway",
+			Type:            GatewayServiceType,
+			ServiceEndpoint: []string{baseURL.String()},
+		}},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create the gateway's DID document")
+	}
+	var gateways []did.AuthoritativeGateway
+	if host := baseURL.Hostname(); net.ParseIP(host) == nil && host != "localhost" {
+		gateways = append(gateways, did.AuthoritativeGateway(dns.Fqdn(host)))
+	}
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, gateways, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode the gateway's DID document")
+	}
+	put, err := dht.CreateDNSPublishRequest(identityKey, *packet)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign the gateway's DID document")
+	}
+	record := dht.RecordFromBEP44(put)
+	if err = s.PublishDHT(ctx, record.ID(), record); err != nil {
+		return "", errors.Wrap(err, "failed to publish the gateway's DID")
+	}
+	s.gatewayDID = doc.ID
+	return doc.ID, nil
+}
+
+// ListGateways resolves the gateway's own DID, if it announces itself, and the configured gateway DIDs, listing each
+// with the endpoints of its gateway services
+func (s *DHTService) ListGateways(ctx context.Context) []Gateway {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.ListGateways")
+	defer span.End()
+
+	var dids []string
+	if s.gatewayDID != "" {
+		dids = append(dids, s.gatewayDID)
+	}
+	for _, id := range s.cfg.Registry.Gateways {
+		if id != s.gatewayDID {
+			dids = append(dids, id)
+		}
+	}
+
+	gateways := make([]Gateway, 0, len(dids))
+	for _, resolution := range s.ResolveDIDs(ctx, dids) {
+		gateway := Gateway{DID: resolution.DID, Self: resolution.DID == s.gatewayDID, Error: resolution.Error}
+		switch {
+		case resolution.Document == nil:
+		case resolution.Deactivated:
+			gateway.Error = "did is deactivated"
+		default:
+			gateway.Endpoints = gatewayEndpoints(*resolution.Document)
+		}
+		gateways = append(gateways, gateway)
+	}
+	return gateways
+}
+
+// gatewayEndpoints returns the URIs of the gateway services of a document
+func gatewayEndpoints(doc didsdk.Document) []string {
+	var endpoints []string
+	for _, service := range doc.Services {
+		if service.Type != GatewayServiceType {
+			continue
+		}
+		switch endpoint := service.ServiceEndpoint.(type) {
+		case string:
+			endpoints = append(endpoints, endpoint)
+		case []string:
+			endpoints = append(endpoints, endpoint...)
+		case []any:
+			for _, e := range endpoint {
+				if uri, ok := e.(string); ok {
+					endpoints = append(endpoints, uri)
+				}
+			}
+		}
+	}
+	return endpoints
+}
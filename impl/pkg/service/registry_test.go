@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestGatewayRegistry(t *testing.T) {
+	svc, _ := newSimulatedDHTService(t, "registry")
+	defer svc.Close()
+	ctx := context.Background()
+
+	// another gateway, announcing itself
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{
+		Services: []didsdk.Service{{ID: "gateway", Type: GatewayServiceType, ServiceEndpoint: []string{"https://other.example.com"}}},
+	})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	put, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(put)
+	require.NoError(t, svc.PublishDHT(ctx, record.ID(), record))
+
+	_, unknown, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	svc.cfg.Registry.Gateways = []string{doc.ID, unknown.ID}
+
+	t.Run("without announcing", func(t *testing.T) {
+		gateways := svc.ListGateways(ctx)
+		require.Len(t, gateways, 2)
+		assert.Equal(t, Gateway{DID: doc.ID, Endpoints: []string{"https://other.example.com"}}, gateways[0])
+		assert.Equal(t, unknown.ID, gateways[1].DID)
+		assert.NotEmpty(t, gateways[1].Error)
+	})
+
+	t.Run("announcing", func(t *testing.T) {
+		_, identityKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		svc.cfg.ServerConfig.BaseURL = "https://gateway.example.com"
+		gatewayDID, err := svc.AnnounceGateway(ctx, identityKey)
+		require.NoError(t, err)
+		assert.Equal(t, did.GetDIDDHTIdentifier(identityKey.Public().(ed25519.PublicKey)), gatewayDID)
+
+		gateways := svc.ListGateways(ctx)
+		require.Len(t, gateways, 3)
+		assert.Equal(t, Gateway{DID: gatewayDID, Endpoints: []string{"https://gateway.example.com"}, Self: true}, gateways[0])
+		assert.Equal(t, doc.ID, gateways[1].DID)
+
+		// the gateway is authoritative for its DID
+		resp, err := svc.GetDHT(ctx, gatewayDID[len(did.Prefix)+1:])
+		require.NoError(t, err)
+		decoded, err := decodeDIDDocument(gatewayDID[len(did.Prefix)+1:], resp.V)
+		require.NoError(t, err)
+		assert.Equal(t, []did.AuthoritativeGateway{"gateway.example.com."}, decoded.Gateways)
+	})
+
+	t.Run("invalid base url", func(t *testing.T) {
+		_, identityKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		svc.cfg.ServerConfig.BaseURL = "gateway.example.com"
+		_, err = svc.AnnounceGateway(ctx, identityKey)
+		assert.ErrorContains(t, err, "must be an absolute url")
+	})
+}
@@ -0,0 +1,634 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+const (
+	// defaultRepublishInterval is how long after it was last published each record is republished, unless configured
+	defaultRepublishInterval = 3 * time.Hour
+	// republishTick is how often the records that have come due are republished
+	republishTick = 10 * time.Second
+	// republishBatchSize is the most records read from storage and put to the DHT at once
+	republishBatchSize = 1000
+	// defaultRepublishWorkers is the number of republish puts in flight at once, unless configured
+	defaultRepublishWorkers = 64
+	// republishRetryBackoff is how long after a failed republish the record is retried, doubling with each failure
+	republishRetryBackoff = time.Minute
+	// maxRepublishBackoff caps the backoff between the retries of a quarantined record
+	maxRepublishBackoff = 24 * time.Hour
+	// republishAttempts is how many times in a row a record fails to republish before it's quarantined
+	republishAttempts = 3
+	// republishLease is how long a replica's claim on a record it's republishing keeps other replicas sharing the
+	// storage from republishing it
+	republishLease = 5 * time.Minute
+	// quarantineShare is the fraction of a tick's republishes, at least one, given to quarantined records on top of
+	// the rest, so records that keep failing can't take over the republishing budget
+	quarantineShare = 0.1
+)
+
+// QuarantinedRecord is a record that failed to republish republishAttempts times in a row, which is retried with
+// exponential backoff apart from the other records until it republishes again
+type QuarantinedRecord struct {
+	ID string `json:"id"`
+	// Failures counts the republishes of the record that failed in a row
+	Failures int `json:"failures"`
+	// NextAttempt is when the record is next retried
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// scheduledRecord is a record waiting in the republish queue
+type scheduledRecord struct {
+	id  string
+	due time.Time
+	// failures counts the republishes of the record that failed in a row
+	failures int
+	// quarantined is whether the record is in the quarantine rather than the queue
+	quarantined bool
+	// index is the record's position in its queue's heap
+	index int
+}
+
+// republishBackoff returns how long to wait to retry a record that failed to republish the given number of times in
+// a row
+func republishBackoff(failures int) time.Duration {
+	backoff := republishRetryBackoff
+	for i := 1; i < failures && backoff < maxRepublishBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRepublishBackoff)
+}
+
+// republishQueue is a min-heap of scheduled records, the record due soonest first
+type republishQueue []*scheduledRecord
+
+func (q republishQueue) Len() int           { return len(q) }
+func (q republishQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+
+func (q republishQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *republishQueue) Push(x any) {
+	r := x.(*scheduledRecord)
+	r.index = len(*q)
+	*q = append(*q, r)
+}
+
+func (q *republishQueue) Pop() any {
+	old := *q
+	n := len(old)
+	r := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return r
+}
+
+// republisher schedules each stored record to be republished one interval after it was last published, so
+// republishing is spread across the interval rather than putting every record at once. Records that keep failing
+// are quarantined, and retried apart from the rest.
+type republisher struct {
+	interval time.Duration
+	// workers is the number of republish puts in flight at once
+	workers int
+	// limiter bounds how many republish puts start each second, across every batch
+	limiter *rate.Limiter
+	// maxPerCycle is the most records republished in one tick, quarantined or not; zero is no cap
+	maxPerCycle int
+	// started is when the republisher started, before which it can't be behind schedule
+	started time.Time
+	// claimer claims the records due before they're republished when the storage is shared by replicas, so each
+	// record is republished by one of them; nil when the storage isn't shared
+	claimer storage.RepublishClaimer
+	// holder identifies the replica's claims
+	holder string
+	// loaded is set once every stored record has been scheduled
+	loaded atomic.Bool
+
+	// mu guards queue, quarantine, and records, which indexes the records scheduled in either by ID
+	mu         sync.Mutex
+	queue      republishQueue
+	quarantine republishQueue
+	records    map[string]*scheduledRecord
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newRepublisher(cfg config.DHTServiceConfig) *republisher {
+	interval := time.Duration(cfg.RepublishIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultRepublishInterval
+	}
+	workers := cfg.RepublishWorkers
+	if workers <= 0 {
+		workers = defaultRepublishWorkers
+	}
+	r := &republisher{
+		interval:    interval,
+		workers:     workers,
+		limiter:     rate.NewLimiter(rate.Inf, 0),
+		maxPerCycle: max(cfg.RepublishMaxRecordsPerCycle, 0),
+		started:     time.Now(),
+		holder:      newReplicaID(),
+		records:     make(map[string]*scheduledRecord),
+		done:        make(chan struct{}),
+	}
+	if perSecond := cfg.RepublishPutsPerSecond; perSecond > 0 {
+		r.limiter = rate.NewLimiter(rate.Limit(perSecond), perSecond)
+		// a tick never starts more puts than the budget allows before the next tick
+		perTick := perSecond * int(republishTick.Seconds())
+		if r.maxPerCycle == 0 || perTick < r.maxPerCycle {
+			r.maxPerCycle = perTick
+		}
+	}
+	return r
+}
+
+// newReplicaID returns an ID for the replica's claims, unique across its restarts
+func newReplicaID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// schedule schedules the record with the given ID to be republished at the given time, after the given number of
+// failed republishes in a row, rescheduling it if it's already scheduled and releasing it if it's quarantined
+func (r *republisher) schedule(id string, due time.Time, failures int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.put(id, due, failures, false)
+}
+
+// quarantineRecord quarantines the record with the given ID, to be retried at the given time after the given number
+// of failed republishes in a row
+func (r *republisher) quarantineRecord(id string, due time.Time, failures int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.put(id, due, failures, true)
+}
+
+// reschedule schedules the record with the given ID to be republished at the given time, after the given number of
+// failed republishes in a row, keeping it in the quarantine or out of it
+func (r *republisher) reschedule(id string, due time.Time, failures int, quarantined bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.put(id, due, failures, quarantined)
+}
+
+// put schedules the record with the given ID, moving it between the queue and the quarantine if needed; r.mu must be
+// held
+func (r *republisher) put(id string, due time.Time, failures int, quarantined bool) {
+	if scheduled, ok := r.records[id]; ok {
+		if scheduled.quarantined == quarantined {
+			scheduled.due, scheduled.failures = due, failures
+			heap.Fix(r.queueOf(scheduled), scheduled.index)
+			return
+		}
+		heap.Remove(r.queueOf(scheduled), scheduled.index)
+	}
+	scheduled := &scheduledRecord{id: id, due: due, failures: failures, quarantined: quarantined}
+	heap.Push(r.queueOf(scheduled), scheduled)
+	r.records[id] = scheduled
+}
+
+// queueOf returns the queue the scheduled record belongs in
+func (r *republisher) queueOf(scheduled *scheduledRecord) *republishQueue {
+	if scheduled.quarantined {
+		return &r.quarantine
+	}
+	return &r.queue
+}
+
+// addIfAbsent schedules the record with the given ID like put, unless it's already scheduled
+func (r *republisher) addIfAbsent(id string, due time.Time, failures int, quarantined bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.records[id]; !ok {
+		r.put(id, due, failures, quarantined)
+	}
+}
+
+// unschedule stops the record with the given ID from being republished, whether it's quarantined or not
+func (r *republisher) unschedule(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if scheduled, ok := r.records[id]; ok {
+		heap.Remove(r.queueOf(scheduled), scheduled.index)
+		delete(r.records, id)
+	}
+}
+
+// isQuarantined returns whether the record with the given ID is quarantined
+func (r *republisher) isQuarantined(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scheduled, ok := r.records[id]
+	return ok && scheduled.quarantined
+}
+
+// quarantined returns the quarantined records, those retried soonest first
+func (r *republisher) quarantined() []QuarantinedRecord {
+	r.mu.Lock()
+	records := make([]QuarantinedRecord, 0, len(r.quarantine))
+	for _, scheduled := range r.quarantine {
+		records = append(records, QuarantinedRecord{ID: scheduled.id, Failures: scheduled.failures, NextAttempt: scheduled.due})
+	}
+	r.mu.Unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].NextAttempt.Before(records[j].NextAttempt) })
+	return records
+}
+
+// popDue removes up to limit of the records due by the given time from the queue, the longest overdue first
+func (r *republisher) popDue(now time.Time, limit int) []scheduledRecord {
+	return r.pop(&r.queue, now, limit)
+}
+
+// popQuarantined removes up to limit of the quarantined records due for a retry by the given time, the longest
+// overdue first
+func (r *republisher) popQuarantined(now time.Time, limit int) []scheduledRecord {
+	return r.pop(&r.quarantine, now, limit)
+}
+
+func (r *republisher) pop(q *republishQueue, now time.Time, limit int) []scheduledRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []scheduledRecord
+	for len(due) < limit && len(*q) > 0 && !(*q)[0].due.After(now) {
+		scheduled := heap.Pop(q).(*scheduledRecord)
+		delete(r.records, scheduled.id)
+		due = append(due, *scheduled)
+	}
+	return due
+}
+
+// perTick is the most records republished in one tick: enough to republish every scheduled record twice an interval,
+// so a backlog is worked off without putting it all at once, up to the cap on each cycle
+func (r *republisher) perTick() int {
+	r.mu.Lock()
+	n := len(r.queue)
+	r.mu.Unlock()
+	perTick := int(math.Ceil(2 * float64(n) * republishTick.Seconds() / r.interval.Seconds()))
+	if r.maxPerCycle > 0 {
+		return min(perTick, r.maxPerCycle)
+	}
+	return perTick
+}
+
+// capacity is the most records the republisher can republish once each interval, given the cap on each cycle; zero
+// is no limit
+func (r *republisher) capacity() int {
+	if r.maxPerCycle == 0 {
+		return 0
+	}
+	return int(r.interval/republishTick) * r.maxPerCycle
+}
+
+// depth returns how many records are scheduled, and how many are quarantined
+func (r *republisher) depth() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queue), len(r.quarantine)
+}
+
+// quarantinedPerTick is the most quarantined records retried in one tick, on top of perTick
+func (r *republisher) quarantinedPerTick() int {
+	r.mu.Lock()
+	n := len(r.quarantine)
+	r.mu.Unlock()
+	if n == 0 {
+		return 0
+	}
+	return max(1, int(quarantineShare*float64(r.perTick())))
+}
+
+// lag returns how far behind schedule the republisher is at the given time: how long the most overdue record has
+// been due since the republisher started, or zero if no record is overdue. Quarantined records aren't counted.
+func (r *republisher) lag(now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 {
+		return 0
+	}
+	due := r.queue[0].due
+	if due.Before(r.started) {
+		due = r.started
+	}
+	if lag := now.Sub(due); lag > 0 {
+		return lag
+	}
+	return 0
+}
+
+// stop stops the republisher's loop; stopping it again is a no-op
+func (r *republisher) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+// runRepublisher schedules the stored records, then republishes the records that come due every tick until the
+// republisher is stopped. Scheduling the stored records is retried every tick until it succeeds. When replicas share
+// the storage, the records are scheduled again every interval, picking up the records stored through the other
+// replicas.
+func (s *DHTService) runRepublisher() {
+	s.loadRepublishSchedule()
+
+	ticker := time.NewTicker(republishTick)
+	defer ticker.Stop()
+	var reload <-chan time.Time
+	if s.republisher.claimer != nil {
+		reloader := time.NewTicker(s.republisher.interval)
+		defer reloader.Stop()
+		reload = reloader.C
+	}
+	for {
+		select {
+		case <-s.republisher.done:
+			return
+		case <-ticker.C:
+			if !s.republisher.loaded.Load() {
+				s.loadRepublishSchedule()
+			}
+			s.republishDue()
+		case <-reload:
+			s.loadRepublishSchedule()
+		}
+	}
+}
+
+// loadRepublishSchedule quarantines the records recorded as failed, and schedules every other stored record, other
+// than tombstones, to be republished one interval after it was last published to the gateway or republished,
+// whichever is later. Records long overdue, such as after the gateway was down, are republished first. The schedule
+// is marked loaded once every stored record is scheduled.
+func (s *DHTService) loadRepublishSchedule() {
+	if !s.beginWork() {
+		return
+	}
+	defer s.work.Done()
+
+	ctx, span := telemetry.GetTracer().Start(s.workCtx, "DHTService.loadRepublishSchedule")
+	defer span.End()
+
+	failed, err := s.db.ListFailedRecords(ctx)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("failed to list failed records to quarantine")
+	}
+	for _, f := range failed {
+		failures := republishAttempts + f.Count - 1
+		s.republisher.addIfAbsent(f.ID, time.Now().Add(republishBackoff(failures)), failures, true)
+	}
+
+	var nextPageToken []byte
+	var scheduled int
+	for {
+		records, token, err := s.db.ListRecords(ctx, nextPageToken, republishBatchSize)
+		if err != nil {
+			logrus.WithContext(ctx).WithError(err).Error("failed to list records to schedule for republishing")
+			return
+		}
+		for _, record := range records {
+			if record.Deactivated() {
+				continue
+			}
+			id := record.ID()
+			published := time.Now()
+			metadata, err := s.db.ReadRecordMetadata(ctx, id)
+			if err != nil {
+				logrus.WithContext(ctx).WithError(err).WithField("record_id", id).Warn("failed to read record metadata, scheduling it as just published")
+			} else if metadata != nil {
+				published = metadata.Updated
+				if metadata.LastRepublished != nil && metadata.LastRepublished.After(published) {
+					published = *metadata.LastRepublished
+				}
+			}
+			s.republisher.addIfAbsent(id, published.Add(s.republisher.interval), 0, false)
+			scheduled++
+		}
+		if token == nil {
+			break
+		}
+		if s.isShuttingDown() {
+			return
+		}
+		nextPageToken = token
+	}
+	s.republisher.loaded.Store(true)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"record_count":      scheduled,
+		"quarantined_count": len(failed),
+	}).Info("scheduled records for republishing")
+	if capacity := s.republisher.capacity(); capacity > 0 && scheduled > capacity {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"record_count": scheduled,
+			"capacity":     capacity,
+		}).Warn("republish limits are too low to republish every record each interval; records will fall behind schedule")
+	}
+}
+
+// republishDue republishes the records that have come due, up to the tick's share of the scheduled records, and
+// reschedules them: one interval on if they were republished, or after a backoff if they failed. A record that fails
+// republishAttempts times in a row is recorded as failed and quarantined. Quarantined records due for a retry are
+// then retried, up to the quarantine's share of the tick, which counts toward the cap on each cycle.
+func (s *DHTService) republishDue() {
+	if !s.beginWork() {
+		return
+	}
+	defer s.work.Done()
+
+	ctx, span := telemetry.GetTracer().Start(s.workCtx, "DHTService.republishDue")
+	defer span.End()
+
+	start := time.Now()
+	retries := s.republisher.popQuarantined(start, s.republisher.quarantinedPerTick())
+	remaining := s.republisher.perTick()
+	if s.republisher.maxPerCycle > 0 {
+		remaining = min(remaining, s.republisher.maxPerCycle-len(retries))
+	}
+
+	var republished, failed int
+	for remaining > 0 && !s.isShuttingDown() {
+		due := s.republisher.popDue(time.Now(), min(remaining, republishBatchSize))
+		if len(due) == 0 {
+			break
+		}
+
+		batchStart := time.Now()
+		ok, failures := s.republishScheduled(ctx, due)
+		s.metrics.recordRepublishBatch(ctx, batchStart)
+		// records left to other replicas, or no longer stored, aren't put so don't count toward the tick's share
+		remaining -= ok + failures
+		republished += ok
+		failed += failures
+	}
+	if len(retries) > 0 && !s.isShuttingDown() {
+		ok, failures := s.republishScheduled(ctx, retries)
+		republished += ok
+		failed += failures
+	} else {
+		for _, scheduled := range retries {
+			s.republisher.quarantineRecord(scheduled.id, scheduled.due, scheduled.failures)
+		}
+	}
+	if republished+failed > 0 {
+		s.metrics.recordRepublishCycle(ctx, start)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"success": republished,
+			"errors":  failed,
+		}).Debug("republished due records")
+	}
+}
+
+// republishScheduled republishes the given scheduled records and reschedules them, returning how many were
+// republished and how many failed. Quarantined records that republish are released. Records no longer stored, and
+// tombstones, aren't rescheduled. When replicas share the storage, only the records this replica claims are
+// republished; the rest are rescheduled for when they're next due, or when the other replica's claim expires.
+func (s *DHTService) republishScheduled(ctx context.Context, due []scheduledRecord) (int, int) {
+	ids := make([]string, 0, len(due))
+	for _, scheduled := range due {
+		ids = append(ids, scheduled.id)
+	}
+	stored, err := s.db.ReadRecords(ctx, ids)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_count", len(ids)).Error("failed to read records to republish")
+		// try them again once the backoff passes, without counting it against the records
+		for _, scheduled := range due {
+			s.republisher.reschedule(scheduled.id, time.Now().Add(republishRetryBackoff), scheduled.failures,
+				scheduled.quarantined)
+		}
+		return 0, 0
+	}
+
+	batch := make([]dht.BEP44Record, 0, len(due))
+	for _, scheduled := range due {
+		if record, ok := stored[scheduled.id]; ok && !record.Deactivated() {
+			batch = append(batch, record)
+		}
+	}
+	claims := s.claimRepublish(ctx, batch)
+	if claims != nil {
+		claimed := make(map[string]bool, len(claims.Claimed))
+		for _, id := range claims.Claimed {
+			claimed[id] = true
+		}
+		batch = slices.DeleteFunc(batch, func(record dht.BEP44Record) bool { return !claimed[record.ID()] })
+	}
+	failedIDs := s.republishBatch(ctx, batch)
+
+	now := time.Now()
+	var failed int
+	for _, scheduled := range due {
+		if record, ok := stored[scheduled.id]; !ok || record.Deactivated() {
+			if scheduled.quarantined {
+				s.clearFailedRecord(ctx, scheduled.id)
+			}
+			continue
+		}
+		if republished, ok := claims.republished(scheduled.id); ok {
+			// another replica republished it
+			if scheduled.quarantined {
+				s.clearFailedRecord(ctx, scheduled.id)
+			}
+			s.republisher.schedule(scheduled.id, republished.Add(s.republisher.interval), 0)
+			continue
+		}
+		if expires, ok := claims.leased(scheduled.id); ok {
+			// another replica is republishing it
+			s.republisher.reschedule(scheduled.id, expires, scheduled.failures, scheduled.quarantined)
+			continue
+		}
+		if !failedIDs[scheduled.id] {
+			if scheduled.quarantined {
+				logrus.WithContext(ctx).WithField("record_id", scheduled.id).Info("quarantined record republished, releasing it")
+				s.clearFailedRecord(ctx, scheduled.id)
+			}
+			s.republisher.schedule(scheduled.id, now.Add(s.republisher.interval), 0)
+			continue
+		}
+		failed++
+		failures := scheduled.failures + 1
+		if failures < republishAttempts {
+			s.republisher.schedule(scheduled.id, now.Add(republishBackoff(failures)), failures)
+			continue
+		}
+		if !scheduled.quarantined {
+			logrus.WithContext(ctx).WithField("record_id", scheduled.id).WithField("attempts", failures).Warn("record failed to republish, quarantining it")
+		}
+		if err = s.db.WriteFailedRecord(ctx, scheduled.id); err != nil {
+			logrus.WithContext(ctx).WithField("record_id", scheduled.id).WithError(err).Warn("failed to write failed record to db")
+		}
+		s.republisher.quarantineRecord(scheduled.id, now.Add(republishBackoff(failures)), failures)
+	}
+	return len(batch) - failed, failed
+}
+
+// claimRepublish claims the records of the batch for the replica to republish when replicas share the storage,
+// returning nil when they don't, so every record is republished. Records are also republished without claiming them if
+// claiming fails, as a record republished twice is better than one not republished at all.
+func (s *DHTService) claimRepublish(ctx context.Context, batch []dht.BEP44Record) *republishClaims {
+	if s.republisher.claimer == nil || len(batch) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(batch))
+	for _, record := range batch {
+		ids = append(ids, record.ID())
+	}
+	// a record republished by another replica within the last half interval is left until it's due again
+	since := time.Now().Add(-s.republisher.interval / 2)
+	claims, err := s.republisher.claimer.ClaimRepublish(ctx, s.republisher.holder, ids, since, republishLease)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("record_count", len(ids)).Warn("failed to claim records to republish, republishing them unclaimed")
+		return nil
+	}
+	return (*republishClaims)(claims)
+}
+
+// republishClaims are the claims made on a batch of records, nil when the records weren't claimed
+type republishClaims dht.RepublishClaims
+
+// republished returns when another replica republished the record with the given ID, if it did recently
+func (c *republishClaims) republished(id string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	t, ok := c.Republished[id]
+	return t, ok
+}
+
+// leased returns when another replica's claim on the record with the given ID expires, if it has one
+func (c *republishClaims) leased(id string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	t, ok := c.Leased[id]
+	return t, ok
+}
+
+// clearFailedRecord clears the stored failure count of the record with the given ID
+func (s *DHTService) clearFailedRecord(ctx context.Context, id string) {
+	if err := s.db.DeleteFailedRecord(ctx, id); err != nil {
+		logrus.WithContext(ctx).WithField("record_id", id).WithError(err).Warn("failed to clear failed record from db")
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/TBD54566975/did-dht/config"
+)
+
+func TestRepublisher(t *testing.T) {
+	r := newRepublisher(config.DHTServiceConfig{})
+	assert.Equal(t, defaultRepublishInterval, r.interval)
+	assert.Equal(t, defaultRepublishWorkers, r.workers)
+	assert.Equal(t, rate.Inf, r.limiter.Limit())
+	assert.Zero(t, r.capacity())
+	assert.Zero(t, r.perTick())
+
+	now := time.Now()
+	r.schedule("a", now.Add(-time.Minute), 0)
+	r.schedule("b", now.Add(-time.Hour), 0)
+	r.schedule("c", now.Add(time.Hour), 0)
+	r.schedule("d", now.Add(-2*time.Minute), 0)
+
+	// records already scheduled keep their schedule when loaded
+	r.addIfAbsent("d", now.Add(-3*time.Hour), 0, false)
+	r.unschedule("c")
+	assert.Equal(t, 1, r.perTick())
+
+	// the longest overdue records are popped first, up to the limit
+	due := r.popDue(now, 2)
+	require.Len(t, due, 2)
+	assert.Equal(t, "b", due[0].id)
+	assert.Equal(t, "d", due[1].id)
+
+	// rescheduling moves a record in the queue
+	r.schedule("a", now.Add(time.Hour), 1)
+	assert.Empty(t, r.popDue(now, 10))
+	due = r.popDue(now.Add(2*time.Hour), 10)
+	require.Len(t, due, 1)
+	assert.Equal(t, 1, due[0].failures)
+}
+
+func TestRepublisherQuarantine(t *testing.T) {
+	r := newRepublisher(config.DHTServiceConfig{RepublishIntervalSeconds: 3600})
+	assert.Zero(t, r.quarantinedPerTick())
+
+	now := time.Now()
+	r.schedule("a", now.Add(-time.Minute), 0)
+	r.quarantineRecord("b", now.Add(-time.Hour), republishAttempts)
+	r.quarantineRecord("c", now.Add(time.Hour), republishAttempts)
+	assert.True(t, r.isQuarantined("b"))
+	assert.False(t, r.isQuarantined("a"))
+
+	// quarantined records get a tenth of each tick's share on top of it, at least one
+	assert.Equal(t, 1, r.quarantinedPerTick())
+	for i := 1; i < 3600; i++ {
+		r.schedule(strconv.Itoa(i), now.Add(time.Hour), 0)
+	}
+	assert.Equal(t, 20, r.perTick())
+	assert.Equal(t, 2, r.quarantinedPerTick())
+
+	// quarantined records are popped apart from the rest, and don't count toward the lag
+	due := r.popDue(now, 10)
+	require.Len(t, due, 1)
+	assert.Equal(t, "a", due[0].id)
+	assert.Zero(t, r.lag(now))
+	due = r.popQuarantined(now, 10)
+	require.Len(t, due, 1)
+	assert.Equal(t, "b", due[0].id)
+	assert.True(t, due[0].quarantined)
+
+	// scheduling a quarantined record releases it
+	r.schedule("c", now, 0)
+	assert.False(t, r.isQuarantined("c"))
+	assert.Empty(t, r.quarantined())
+}
+
+func TestRepublishBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, republishBackoff(1))
+	assert.Equal(t, 2*time.Minute, republishBackoff(2))
+	assert.Equal(t, 4*time.Minute, republishBackoff(3))
+	assert.Equal(t, maxRepublishBackoff, republishBackoff(20))
+	assert.Equal(t, maxRepublishBackoff, republishBackoff(1000))
+}
+
+func TestRepublisherLag(t *testing.T) {
+	r := newRepublisher(config.DHTServiceConfig{RepublishIntervalSeconds: 3600})
+	assert.Zero(t, r.lag(time.Now()))
+
+	// the republisher isn't behind on records that came due before it started
+	r.schedule("a", r.started.Add(-time.Hour), 0)
+	assert.Zero(t, r.lag(r.started))
+	assert.Equal(t, time.Minute, r.lag(r.started.Add(time.Minute)))
+
+	r.schedule("a", r.started.Add(time.Hour), 0)
+	assert.Zero(t, r.lag(r.started.Add(time.Minute)))
+	assert.Equal(t, time.Minute, r.lag(r.started.Add(time.Hour+time.Minute)))
+
+	// a large queue is republished a share at a time, twice an interval
+	for i := 0; i < 720; i++ {
+		r.schedule(strconv.Itoa(i), r.started, 0)
+	}
+	assert.Equal(t, 5, r.perTick())
+}
+
+func TestRepublisherLimits(t *testing.T) {
+	now := time.Now()
+	fill := func(r *republisher, n int) {
+		for i := 0; i < n; i++ {
+			r.schedule(strconv.Itoa(i), now, 0)
+		}
+	}
+
+	// records per cycle are capped
+	r := newRepublisher(config.DHTServiceConfig{RepublishIntervalSeconds: 3600, RepublishWorkers: 8, RepublishMaxRecordsPerCycle: 10})
+	assert.Equal(t, 8, r.workers)
+	fill(r, 3600)
+	assert.Equal(t, 10, r.perTick())
+	assert.Equal(t, 3600, r.capacity())
+
+	// as are the puts started each second, which bound each cycle to the puts the budget allows before the next
+	r = newRepublisher(config.DHTServiceConfig{RepublishIntervalSeconds: 3600, RepublishPutsPerSecond: 2, RepublishMaxRecordsPerCycle: 100})
+	assert.Equal(t, rate.Limit(2), r.limiter.Limit())
+	assert.Equal(t, 2, r.limiter.Burst())
+	fill(r, 3600)
+	assert.Equal(t, 20, r.perTick())
+	assert.Equal(t, 7200, r.capacity())
+	scheduled, quarantined := r.depth()
+	assert.Equal(t, 3600, scheduled)
+	assert.Zero(t, quarantined)
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TBD54566975/ssi-sdk/crypto/jwx"
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// RotationPolicy is how UpdateDID validates the verification methods an update of a DID rotates
+type RotationPolicy struct {
+	// GracePeriod is how long the other verification methods of a DID's document must have been published before any
+	// of its methods can be removed, so a new key is published alongside the key it replaces for at least this long
+	// and relying parties holding either version of the document can verify signatures made with either key. Zero
+	// lets methods be removed at once.
+	GracePeriod time.Duration
+}
+
+// UpdateDID publishes a record updating the DID with the given z-base-32 encoded key like PublishDHT, after
+// validating it as a rotation of the DID's current document. The record must be signed by the DID's identity key,
+// with a sequence number above the current version's, and must neither give the ID of a verification method to
+// another key nor move a key to another ID. Verification methods can only be removed as the policy's grace period
+// allows. ErrNotFound is returned for a DID with no current version, and a RotationError lists every verification
+// method of the update breaking a rule.
+func (s *DHTService) UpdateDID(ctx context.Context, id string, record dht.BEP44Record, policy RotationPolicy) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.UpdateDID")
+	defer span.End()
+
+	if err := record.IsValid(); err != nil {
+		return err
+	}
+	if len(record.Salt) > 0 || record.ID() != id {
+		return errors.Wrapf(dht.ErrBadSignature, "update of did %s must be an unsalted record signed by its identity key", id)
+	}
+	if err := validateDocument(record); err != nil {
+		return err
+	}
+	if err := s.checkAccess(ctx, operationPublish, id); err != nil {
+		return err
+	}
+
+	current, err := s.GetDHT(ctx, id)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return errors.Wrapf(dht.ErrNotFound, "did %s has no document to update", id)
+	}
+	if record.SequenceNumber <= current.Seq {
+		return errors.Wrapf(dht.ErrSeqConflict, "seq %d of the update of did %s must be greater than the current seq %d",
+			record.SequenceNumber, id, current.Seq)
+	}
+	currentDoc, err := decodeDIDDocument(id, current.V)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode the current document of did %s", id)
+	}
+	if currentDoc.Deactivated {
+		return errors.Wrapf(dht.ErrDeactivated, "not updating did %s", id)
+	}
+
+	// a deactivation has no verification methods left to rotate
+	if !record.Deactivated() {
+		doc, err := decodeDIDDocument(id, record.Value)
+		if err != nil {
+			return errors.Wrapf(dht.ErrInvalidDocument, "failed to decode did document: %s", err.Error())
+		}
+		since, err := s.methodsSince(ctx, id, current.Seq, currentDoc.Doc)
+		if err != nil {
+			return err
+		}
+		if fields := rotationErrors(currentDoc.Doc, doc.Doc, since, policy.GracePeriod, time.Now()); len(fields) > 0 {
+			return &dht.RotationError{ID: doc.Doc.ID, Fields: fields}
+		}
+	}
+	return s.PublishDHT(ctx, id, record)
+}
+
+// DeactivateDID publishes the tombstone deactivating the DID with the given z-base-32 encoded key: the spec's
+// deactivation record, signed by the DID's identity key with a sequence number above the current version's, as
+// UpdateDID requires of any update. The tombstone is stored in place of the DID's document, and the DID is no longer
+// republished.
+func (s *DHTService) DeactivateDID(ctx context.Context, id string, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "DHTService.DeactivateDID")
+	defer span.End()
+
+	if !record.Deactivated() {
+		return errors.Wrapf(dht.ErrInvalidDNSPacket, "record for did %s isn't a deactivation, an unsalted dns packet with no records", id)
+	}
+	return s.UpdateDID(ctx, id, record, RotationPolicy{})
+}
+
+// methodsSince returns the sequence number since which each verification method of a DID's current document, keyed
+// by methodKey, has been published: that of the earliest stored version leading up to the current one with no
+// version in between missing the method
+func (s *DHTService) methodsSince(ctx context.Context, id string, currentSeq int64, current didsdk.Document) (map[string]int64, error) {
+	since := make(map[string]int64, len(current.VerificationMethod))
+	unbroken := make(map[string]bool, len(current.VerificationMethod))
+	for _, vm := range current.VerificationMethod {
+		since[methodKey(vm)] = currentSeq
+		unbroken[methodKey(vm)] = true
+	}
+
+	versions, err := s.db.ListRecordVersions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0 && len(unbroken) > 0; i-- {
+		if versions[i].SequenceNumber >= currentSeq {
+			continue
+		}
+		doc, err := decodeDIDDocument(id, versions[i].Value)
+		if err != nil || doc.Deactivated {
+			break
+		}
+		published := make(map[string]bool, len(doc.Doc.VerificationMethod))
+		for _, vm := range doc.Doc.VerificationMethod {
+			published[methodKey(vm)] = true
+		}
+		for key := range unbroken {
+			if published[key] {
+				since[key] = versions[i].SequenceNumber
+			} else {
+				delete(unbroken, key)
+			}
+		}
+	}
+	return since, nil
+}
+
+// rotationErrors returns the verification methods of a DID's next document breaking a rule of rotating the current
+// document's: a method's ID can't be given to another key, a key can't move to another ID, and while a grace period
+// applies, methods can only be removed once every other method has been published for it. Fields are those of the
+// next document.
+func rotationErrors(current, next didsdk.Document, since map[string]int64, gracePeriod time.Duration, now time.Time) []dht.FieldError {
+	var fields []dht.FieldError
+	addError := func(field, message string) {
+		fields = append(fields, dht.FieldError{Field: field, Message: message})
+	}
+
+	currentKeys := make(map[string]string, len(current.VerificationMethod))
+	currentIDs := make(map[string]string, len(current.VerificationMethod))
+	for _, vm := range current.VerificationMethod {
+		currentKeys[fragment(vm.ID)] = jwkKey(vm.PublicKeyJWK)
+		currentIDs[jwkKey(vm.PublicKeyJWK)] = fragment(vm.ID)
+	}
+	kept := make(map[string]bool, len(next.VerificationMethod))
+	for i, vm := range next.VerificationMethod {
+		field := indexed("verificationMethod", i)
+		id, key := fragment(vm.ID), jwkKey(vm.PublicKeyJWK)
+		kept[id] = true
+		if currentKey, ok := currentKeys[id]; ok && currentKey != key {
+			addError(field+".publicKeyJwk", fmt.Sprintf("gives #%s another key; publish the new key under a new id", id))
+		} else if currentID, ok := currentIDs[key]; ok && currentID != id {
+			addError(field+".id", fmt.Sprintf("moves the key of #%s to #%s; keep the key's id", currentID, id))
+		}
+	}
+
+	var removed []string
+	for _, vm := range current.VerificationMethod {
+		if !kept[fragment(vm.ID)] {
+			removed = append(removed, "#"+fragment(vm.ID))
+		}
+	}
+	if len(removed) == 0 || gracePeriod <= 0 {
+		return fields
+	}
+	removing := strings.Join(removed, ", ")
+	for i, vm := range next.VerificationMethod {
+		// the identity key never changes
+		if fragment(vm.ID) == identityKeyID {
+			continue
+		}
+		field := indexed("verificationMethod", i)
+		published, ok := since[methodKey(vm)]
+		if !ok {
+			addError(field, fmt.Sprintf("is added by the update removing %s; publish it alongside them for %s first",
+				removing, gracePeriod))
+			continue
+		}
+		if removable := time.Unix(published, 0).Add(gracePeriod); now.Before(removable) {
+			addError(field, fmt.Sprintf("has been published since %s, so %s can't be removed before %s",
+				time.Unix(published, 0).UTC().Format(time.RFC3339), removing, removable.UTC().Format(time.RFC3339)))
+		}
+	}
+	return fields
+}
+
+// methodKey identifies a verification method by its ID and key, so a method given another key is another method
+func methodKey(vm didsdk.VerificationMethod) string {
+	return fragment(vm.ID) + " " + jwkKey(vm.PublicKeyJWK)
+}
+
+// jwkKey returns the public key of a JWK, without its other parameters
+func jwkKey(jwk *jwx.PublicKeyJWK) string {
+	if jwk == nil {
+		return ""
+	}
+	return strings.Join([]string{jwk.KTY, jwk.CRV, jwk.X, jwk.Y}, ":")
+}
+
+// fragment returns the fragment of a verification method's ID, which is the whole ID if it's relative
+func fragment(id string) string {
+	if _, f, ok := strings.Cut(id, "#"); ok {
+		return f
+	}
+	return id
+}
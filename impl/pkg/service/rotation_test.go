@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/TBD54566975/ssi-sdk/crypto/jwx"
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func TestUpdateDID(t *testing.T) {
+	svc, _ := newSimulatedDHTService(t, "rotation")
+	t.Cleanup(func() { svc.Close() })
+	ctx := context.Background()
+
+	newMethod := func(t *testing.T, id, fragment string) didsdk.VerificationMethod {
+		pub, _, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		jwk, err := jwx.PublicKeyToPublicKeyJWK(nil, pub)
+		require.NoError(t, err)
+		return didsdk.VerificationMethod{ID: id + "#" + fragment, Type: "JsonWebKey", Controller: id, PublicKeyJWK: jwk}
+	}
+	// newDID publishes a DID with the identity key and the given other methods, an hour for each version before now
+	newDID := func(t *testing.T, versions ...[]string) (ed25519.PrivateKey, string, didsdk.Document, map[string]didsdk.VerificationMethod) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		methods := make(map[string]didsdk.VerificationMethod)
+		identityKey := doc.VerificationMethod[0]
+		for i, fragments := range versions {
+			doc.VerificationMethod = []didsdk.VerificationMethod{identityKey}
+			for _, f := range fragments {
+				if _, ok := methods[f]; !ok {
+					methods[f] = newMethod(t, doc.ID, f)
+				}
+				doc.VerificationMethod = append(doc.VerificationMethod, methods[f])
+			}
+			seq := time.Now().Add(-time.Duration(len(versions)-i) * time.Hour).Unix()
+			require.NoError(t, svc.PublishDHT(ctx, suffix, rotationRecord(t, sk, *doc, seq)))
+		}
+		return sk, suffix, *doc, methods
+	}
+	withMethods := func(doc didsdk.Document, methods ...didsdk.VerificationMethod) didsdk.Document {
+		doc.VerificationMethod = append([]didsdk.VerificationMethod{doc.VerificationMethod[0]}, methods...)
+		return doc
+	}
+	rotationFields := func(t *testing.T, err error) []string {
+		var rotationErr *dht.RotationError
+		require.ErrorAs(t, err, &rotationErr)
+		assert.ErrorIs(t, err, dht.ErrInvalidRotation)
+		var fields []string
+		for _, f := range rotationErr.Fields {
+			fields = append(fields, f.Field)
+		}
+		return fields
+	}
+	now := time.Now().Unix()
+
+	t.Run("updates without a current version are rejected", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		suffix, err := did.DHT(doc.ID).Suffix()
+		require.NoError(t, err)
+		err = svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, *doc, now), RotationPolicy{})
+		assert.ErrorIs(t, err, dht.ErrNotFound)
+	})
+
+	t.Run("updates must be signed by the identity key and increase seq", func(t *testing.T) {
+		sk, suffix, doc, _ := newDID(t, []string{"k1"})
+		otherSK, otherDoc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		err = svc.UpdateDID(ctx, suffix, rotationRecord(t, otherSK, *otherDoc, now), RotationPolicy{})
+		assert.ErrorIs(t, err, dht.ErrBadSignature)
+
+		current, err := svc.GetDHT(ctx, suffix)
+		require.NoError(t, err)
+		err = svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, doc, current.Seq), RotationPolicy{})
+		assert.ErrorIs(t, err, dht.ErrSeqConflict)
+	})
+
+	t.Run("a method's id can't be given to another key", func(t *testing.T) {
+		sk, suffix, doc, _ := newDID(t, []string{"k1"})
+		err := svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, withMethods(doc, newMethod(t, doc.ID, "k1")), now), RotationPolicy{})
+		assert.Equal(t, []string{"verificationMethod[1].publicKeyJwk"}, rotationFields(t, err))
+	})
+
+	t.Run("a key can't move to another id", func(t *testing.T) {
+		sk, suffix, doc, methods := newDID(t, []string{"k1"})
+		moved := methods["k1"]
+		moved.ID = doc.ID + "#k2"
+		err := svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, withMethods(doc, moved), now), RotationPolicy{})
+		assert.Equal(t, []string{"verificationMethod[1].id"}, rotationFields(t, err))
+	})
+
+	t.Run("without a grace period methods are swapped at once", func(t *testing.T) {
+		sk, suffix, doc, _ := newDID(t, []string{"k1"})
+		require.NoError(t, svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, withMethods(doc, newMethod(t, doc.ID, "k2")), now), RotationPolicy{}))
+		resolution, err := svc.ResolveDID(ctx, did.Prefix+":"+suffix)
+		require.NoError(t, err)
+		require.Len(t, resolution.Document.VerificationMethod, 2)
+		assert.Equal(t, doc.ID+"#k2", resolution.Document.VerificationMethod[1].ID)
+	})
+
+	t.Run("a grace period requires replacements to be published alongside removed methods", func(t *testing.T) {
+		// k1 has been published for two hours, and k2 alongside it for one
+		sk, suffix, doc, methods := newDID(t, []string{"k1"}, []string{"k1", "k2"})
+
+		err := svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, withMethods(doc, methods["k2"], newMethod(t, doc.ID, "k3")), now), RotationPolicy{GracePeriod: time.Minute})
+		assert.Equal(t, []string{"verificationMethod[2]"}, rotationFields(t, err))
+
+		err = svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, withMethods(doc, methods["k2"]), now), RotationPolicy{GracePeriod: 2 * time.Hour})
+		assert.Equal(t, []string{"verificationMethod[1]"}, rotationFields(t, err))
+		assert.ErrorContains(t, err, "#k1 can't be removed before")
+
+		require.NoError(t, svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, withMethods(doc, methods["k2"]), now), RotationPolicy{GracePeriod: 30 * time.Minute}))
+	})
+
+	t.Run("deactivated dids can't be updated", func(t *testing.T) {
+		sk, suffix, doc, _ := newDID(t, []string{"k1"})
+		deactivation, err := dht.CreateDNSPublishRequest(sk, *did.DeactivationDNSPacket())
+		require.NoError(t, err)
+		deactivation.Seq = now
+		deactivation.Sign(sk)
+		require.NoError(t, svc.UpdateDID(ctx, suffix, dht.RecordFromBEP44(deactivation), RotationPolicy{GracePeriod: time.Hour}))
+
+		err = svc.UpdateDID(ctx, suffix, rotationRecord(t, sk, doc, now+1), RotationPolicy{})
+		assert.ErrorIs(t, err, dht.ErrDeactivated)
+	})
+}
+
+// rotationRecord returns the record publishing the DID document with the given sequence number
+func rotationRecord(t *testing.T, sk ed25519.PrivateKey, doc didsdk.Document, seq int64) dht.BEP44Record {
+	packet, err := did.DHT(doc.ID).ToDNSPacket(doc, nil, nil, nil)
+	require.NoError(t, err)
+	put, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	put.Seq = seq
+	put.Sign(sk)
+	return dht.RecordFromBEP44(put)
+}
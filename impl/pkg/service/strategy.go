@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// ResolutionStrategy is the order records missing from the cache are looked up in
+type ResolutionStrategy string
+
+const (
+	// StrategyDHTFirst looks records up on the DHT, falling back to storage if the lookup fails
+	StrategyDHTFirst ResolutionStrategy = "dht-first"
+	// StrategyStorageFirst serves stored records without looking them up on the DHT, looking up records that aren't
+	// stored
+	StrategyStorageFirst ResolutionStrategy = "storage-first"
+	// StrategyRace looks records up on the DHT while reading them from storage, serving whichever has the higher
+	// sequence number
+	StrategyRace ResolutionStrategy = "race"
+	// StrategyCacheOnly serves cached records only, reporting records missing from the cache as not found. Stale
+	// cached records are still refreshed in the background, from the DHT first.
+	StrategyCacheOnly ResolutionStrategy = "cache-only"
+)
+
+// ParseResolutionStrategy returns the resolution strategy with the given name, StrategyDHTFirst if it's empty
+func ParseResolutionStrategy(name string) (ResolutionStrategy, error) {
+	switch strategy := ResolutionStrategy(name); strategy {
+	case "":
+		return StrategyDHTFirst, nil
+	case StrategyDHTFirst, StrategyStorageFirst, StrategyRace, StrategyCacheOnly:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown resolution strategy %q: must be one of %s, %s, %s, or %s", name,
+			StrategyDHTFirst, StrategyStorageFirst, StrategyRace, StrategyCacheOnly)
+	}
+}
+
+type resolutionStrategyKey struct{}
+
+// WithResolutionStrategy returns a context resolving records with the given strategy rather than the gateway's
+func WithResolutionStrategy(ctx context.Context, strategy ResolutionStrategy) context.Context {
+	return context.WithValue(ctx, resolutionStrategyKey{}, strategy)
+}
+
+// resolutionStrategy returns the resolution strategy of the context, or the gateway's if it has none
+func (s *DHTService) resolutionStrategy(ctx context.Context) ResolutionStrategy {
+	if strategy, ok := ctx.Value(resolutionStrategyKey{}).(ResolutionStrategy); ok {
+		return strategy
+	}
+	return s.strategy
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+)
+
+func TestParseResolutionStrategy(t *testing.T) {
+	strategy, err := ParseResolutionStrategy("")
+	require.NoError(t, err)
+	assert.Equal(t, StrategyDHTFirst, strategy)
+
+	for _, name := range []string{"dht-first", "storage-first", "race", "cache-only"} {
+		strategy, err = ParseResolutionStrategy(name)
+		require.NoError(t, err)
+		assert.Equal(t, ResolutionStrategy(name), strategy)
+	}
+
+	_, err = ParseResolutionStrategy("dht-only")
+	assert.ErrorContains(t, err, `unknown resolution strategy "dht-only"`)
+}
+
+func TestResolutionStrategies(t *testing.T) {
+	svc, sim := newSimulatedDHTService(t, "strategies")
+	t.Cleanup(func() { svc.Close() })
+	ctx := context.Background()
+
+	// the gateway stores the first version, the dht has the second, and then the gateway stores the third
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	id, err := did.DHT(doc.ID).Suffix()
+	require.NoError(t, err)
+	seq := time.Now().Unix()
+	require.NoError(t, svc.PublishDHT(ctx, id, rotationRecord(t, sk, *doc, seq)))
+	require.Eventually(t, func() bool { return sim.Puts() > 0 }, time.Second, 10*time.Millisecond)
+	_, err = sim.Put(ctx, rotationRecord(t, sk, *doc, seq+1).Put())
+	require.NoError(t, err)
+
+	resolve := func(t *testing.T, strategy ResolutionStrategy) (int64, string) {
+		require.NoError(t, svc.cache.Delete(ctx, id))
+		got, provenance, err := svc.getDHT(WithResolutionStrategy(ctx, strategy), id)
+		require.NoError(t, err)
+		if got == nil {
+			return 0, provenance.Source
+		}
+		return got.Seq, provenance.Source
+	}
+
+	t.Run("dht-first serves the dht's version", func(t *testing.T) {
+		got, source := resolve(t, StrategyDHTFirst)
+		assert.Equal(t, seq+1, got)
+		assert.Equal(t, resolvedFromDHT, source)
+	})
+
+	t.Run("storage-first serves the stored version", func(t *testing.T) {
+		got, source := resolve(t, StrategyStorageFirst)
+		assert.Equal(t, seq, got)
+		assert.Equal(t, resolvedFromStorage, source)
+	})
+
+	t.Run("race serves the newer version", func(t *testing.T) {
+		got, source := resolve(t, StrategyRace)
+		assert.Equal(t, seq+1, got)
+		assert.Equal(t, resolvedFromDHT, source)
+
+		require.NoError(t, svc.db.WriteRecord(ctx, rotationRecord(t, sk, *doc, seq+2)))
+		got, source = resolve(t, StrategyRace)
+		assert.Equal(t, seq+2, got)
+		assert.Equal(t, resolvedFromStorage, source)
+	})
+
+	t.Run("cache-only serves cached records only", func(t *testing.T) {
+		got, source := resolve(t, StrategyCacheOnly)
+		assert.Zero(t, got)
+		assert.Equal(t, resolvedFromNone, source)
+
+		_, err := svc.GetDHT(ctx, id)
+		require.NoError(t, err)
+		cached, _, err := svc.getDHT(WithResolutionStrategy(ctx, StrategyCacheOnly), id)
+		require.NoError(t, err)
+		assert.NotNil(t, cached)
+	})
+
+	t.Run("requests use the gateway's strategy by default", func(t *testing.T) {
+		assert.Equal(t, StrategyDHTFirst, svc.resolutionStrategy(ctx))
+	})
+}
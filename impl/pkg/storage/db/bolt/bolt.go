@@ -2,12 +2,18 @@ package bolt
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/cipher"
 	"encoding/binary"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/goccy/go-json"
-
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
@@ -17,12 +23,26 @@ import (
 )
 
 const (
-	dhtNamespace    = "dht"
-	failedNamespace = "failed"
+	dhtNamespace      = "dht"
+	failedNamespace   = "failed"
+	versionsNamespace = "versions"
+	// lastSeenNamespace holds when each record was last written or touched, as big-endian Unix nanoseconds
+	lastSeenNamespace = "last-seen"
+	// typesNamespace indexes records by DID type, holding an empty value under each type, as a big-endian uint32,
+	// followed by the ID of a record of that type
+	typesNamespace = "types"
+	// metadataNamespace holds the metadata of each record besides its last seen time, encoded by recordMetadata
+	metadataNamespace = "metadata"
+	// retainedNamespace holds the retained keys, with empty values
+	retainedNamespace = "retained"
+	// apiKeysNamespace holds the API keys by ID, encoded as storedAPIKey JSON
+	apiKeysNamespace = "api-keys"
 )
 
 type Bolt struct {
 	db *bolt.DB
+	// aead encrypts record payloads, or is nil if they are stored in the clear
+	aead cipher.AEAD
 }
 
 type boltRecord struct {
@@ -41,19 +61,215 @@ func NewBolt(path string) (*Bolt, error) {
 	return &Bolt{db: db}, nil
 }
 
-// WriteRecord writes the given record to the storage
-// TODO: don't overwrite existing records, store unique seq numbers
+// WriteRecord writes the given record to the storage, marking it as seen now and indexing its types, unless a newer
+// record is stored
 func (b *Bolt) WriteRecord(ctx context.Context, record dht.BEP44Record) error {
-	ctx, span := telemetry.GetTracer().Start(ctx, "bolt.WriteRecord")
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.WriteRecord")
 	defer span.End()
 
-	encoded := encodeRecord(record)
-	recordBytes, err := json.Marshal(encoded)
+	id := []byte(record.ID())
+	recordBytes, err := b.marshalRecord(id, record)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(dhtNamespace))
+		if err != nil {
+			return err
+		}
+		var previousTypes []int
+		if storedBytes := bucket.Get(id); storedBytes != nil {
+			encoded, err := b.unmarshalRecord(id, storedBytes)
+			if err != nil {
+				return err
+			}
+			if encoded.Seq > record.SequenceNumber {
+				return nil
+			}
+			stored, err := encoded.Decode()
+			if err != nil {
+				return err
+			}
+			previousTypes = stored.Types()
+		}
+		if err = bucket.Put(id, recordBytes); err != nil {
+			return err
+		}
+		if err = indexTypes(tx, id, previousTypes, record.Types()); err != nil {
+			return err
+		}
+		now := time.Now()
+		err = updateMetadata(tx, id, func(metadata *recordMetadata) {
+			if metadata.created == 0 {
+				metadata.created = now.UnixNano()
+			}
+			metadata.updated = now.UnixNano()
+		})
+		if err != nil {
+			return err
+		}
+		return markSeen(tx, id, now)
+	})
+}
+
+// indexTypes moves the record with the given ID in the type index from its previous types to its current types
+func indexTypes(tx *bolt.Tx, id []byte, previous, current []int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(typesNamespace))
 	if err != nil {
 		return err
 	}
+	for _, typ := range previous {
+		if slices.Contains(current, typ) {
+			continue
+		}
+		if err = bucket.Delete(typeKey(typ, id)); err != nil {
+			return err
+		}
+	}
+	for _, typ := range current {
+		if err = bucket.Put(typeKey(typ, id), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return b.write(ctx, dhtNamespace, record.ID(), recordBytes)
+// typePrefix returns the prefix of the type index keys of every record of the given type
+func typePrefix(typ int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(typ))
+}
+
+// typeKey returns the type index key of the record with the given ID under the given type
+func typeKey(typ int, id []byte) []byte {
+	return append(typePrefix(typ), id...)
+}
+
+// ListRecordsByType lists a page of the IDs of the records of the query's type. In stored order, records are ordered
+// by ID and the page token is the last ID listed. In update order, every record of the type is read to sort them, and
+// the page token is the update time and ID of the last record listed.
+func (b *Bolt) ListRecordsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, pageSize int) ([]string, []byte, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.ListRecordsByType")
+	defer span.End()
+
+	if query.Order == dht.OrderUpdated || query.Order == dht.OrderUpdatedDesc {
+		return b.listRecordsByTypeUpdate(query, nextPageToken, pageSize)
+	}
+
+	since := unixNanoSince(query.UpdatedSince)
+	var ids []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(typesNamespace))
+		if bucket == nil {
+			return nil
+		}
+		metadata := tx.Bucket([]byte(metadataNamespace))
+		prefix := typePrefix(query.Type)
+		cursor := bucket.Cursor()
+		k, _ := cursor.Seek(typeKey(query.Type, nextPageToken))
+		if nextPageToken != nil && bytes.Equal(k, typeKey(query.Type, nextPageToken)) {
+			k, _ = cursor.Next()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(ids) < pageSize; k, _ = cursor.Next() {
+			id := k[len(prefix):]
+			if readUpdated(metadata, id) < since {
+				continue
+			}
+			ids = append(ids, string(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(ids) == pageSize {
+		nextPageToken = []byte(ids[len(ids)-1])
+	} else {
+		nextPageToken = nil
+	}
+	return ids, nextPageToken, nil
+}
+
+// updatedRecord is the ID of a record of a type and when it was last written, as Unix nanoseconds
+type updatedRecord struct {
+	updated int64
+	id      string
+}
+
+// listRecordsByTypeUpdate lists a page of the IDs of the records of the query's type in update order
+func (b *Bolt) listRecordsByTypeUpdate(query dht.TypeQuery, nextPageToken []byte, pageSize int) ([]string, []byte, error) {
+	compare := func(a, b updatedRecord) int {
+		return cmp.Or(cmp.Compare(a.updated, b.updated), strings.Compare(a.id, b.id))
+	}
+	if query.Order == dht.OrderUpdatedDesc {
+		compare = func(a, b updatedRecord) int {
+			return cmp.Or(cmp.Compare(b.updated, a.updated), strings.Compare(b.id, a.id))
+		}
+	}
+
+	var records []updatedRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(typesNamespace))
+		if bucket == nil {
+			return nil
+		}
+		metadata := tx.Bucket([]byte(metadataNamespace))
+		since := unixNanoSince(query.UpdatedSince)
+		prefix := typePrefix(query.Type)
+		cursor := bucket.Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			id := k[len(prefix):]
+			if updated := readUpdated(metadata, id); updated >= since {
+				records = append(records, updatedRecord{updated: updated, id: string(id)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.SortFunc(records, compare)
+
+	if nextPageToken != nil {
+		updated, id, ok := bytes.Cut(nextPageToken, []byte(":"))
+		after, err := strconv.ParseInt(string(updated), 10, 64)
+		if !ok || err != nil {
+			return nil, nil, dht.ErrInvalidPageToken
+		}
+		last := updatedRecord{updated: after, id: string(id)}
+		records = records[sort.Search(len(records), func(i int) bool { return compare(records[i], last) > 0 }):]
+	}
+	if len(records) <= pageSize {
+		nextPageToken = nil
+	} else {
+		records = records[:pageSize]
+		last := records[len(records)-1]
+		nextPageToken = append(strconv.AppendInt(nil, last.updated, 10), ":"+last.id...)
+	}
+
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.id)
+	}
+	return ids, nextPageToken, nil
+}
+
+// readUpdated returns when the record with the given ID was last written, as Unix nanoseconds, or zero if the
+// metadata bucket doesn't hold its metadata
+func readUpdated(metadata *bolt.Bucket, id []byte) int64 {
+	if metadata == nil {
+		return 0
+	}
+	return decodeMetadata(metadata.Get(id)).updated
+}
+
+// unixNanoSince returns the time as Unix nanoseconds, or the earliest time if it is zero
+func unixNanoSince(t time.Time) int64 {
+	if t.IsZero() {
+		return math.MinInt64
+	}
+	return t.UnixNano()
 }
 
 // ReadRecord reads the record with the given id from the storage
@@ -69,8 +285,8 @@ func (b *Bolt) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, err
 		return nil, nil
 	}
 
-	var b64record base64BEP44Record
-	if err = json.Unmarshal(recordBytes, &b64record); err != nil {
+	b64record, err := b.unmarshalRecord([]byte(id), recordBytes)
+	if err != nil {
 		return nil, err
 	}
 
@@ -82,7 +298,48 @@ func (b *Bolt) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, err
 	return record, nil
 }
 
-// ListRecords lists all records in the storage
+// ReadRecords reads the records with the given IDs in one pass of a cursor over the records, visiting the IDs in order
+func (b *Bolt) ReadRecords(ctx context.Context, ids []string) (map[string]dht.BEP44Record, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.ReadRecords")
+	defer span.End()
+
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	records := make(map[string]dht.BEP44Record, len(sorted))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dhtNamespace))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for _, id := range sorted {
+			k, v := cursor.Seek([]byte(id))
+			if k == nil {
+				// every remaining ID sorts after the last record
+				break
+			}
+			if string(k) != id {
+				continue
+			}
+			encoded, err := b.unmarshalRecord(k, v)
+			if err != nil {
+				return err
+			}
+			record, err := encoded.Decode()
+			if err != nil {
+				return err
+			}
+			records[id] = *record
+		}
+		return nil
+	})
+	return records, err
+}
+
+// ListRecords lists a page of the records in the storage, ordered by ID. The page token is the ID of the last record
+// listed.
 func (b *Bolt) ListRecords(ctx context.Context, nextPageToken []byte, pageSize int) ([]dht.BEP44Record, []byte, error) {
 	ctx, span := telemetry.GetTracer().Start(ctx, "bolt.ListRecords")
 	defer span.End()
@@ -94,8 +351,8 @@ func (b *Bolt) ListRecords(ctx context.Context, nextPageToken []byte, pageSize i
 
 	var records []dht.BEP44Record
 	for _, recordBytes := range boltRecords {
-		var encodedRecord base64BEP44Record
-		if err = json.Unmarshal(recordBytes.value, &encodedRecord); err != nil {
+		encodedRecord, err := b.unmarshalRecord(recordBytes.key, recordBytes.value)
+		if err != nil {
 			return nil, nil, err
 		}
 
@@ -116,6 +373,340 @@ func (b *Bolt) ListRecords(ctx context.Context, nextPageToken []byte, pageSize i
 	return records, nextPageToken, nil
 }
 
+// WriteRecordVersion stores the record in the history of its ID, keyed by sequence number
+func (b *Bolt) WriteRecordVersion(ctx context.Context, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "bolt.WriteRecordVersion")
+	defer span.End()
+
+	key := versionKey(record.ID(), record.SequenceNumber)
+	recordBytes, err := b.marshalRecord(key, record)
+	if err != nil {
+		return err
+	}
+	return b.write(ctx, versionsNamespace, string(key), recordBytes)
+}
+
+// ListRecordVersions returns every stored version of the record with the given ID, oldest first
+func (b *Bolt) ListRecordVersions(ctx context.Context, id string) ([]dht.BEP44Record, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.ListRecordVersions")
+	defer span.End()
+
+	var records []dht.BEP44Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(versionsNamespace))
+		if bucket == nil {
+			return nil
+		}
+		prefix := versionPrefix(id)
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			encoded, err := b.unmarshalRecord(k, v)
+			if err != nil {
+				return err
+			}
+			record, err := encoded.Decode()
+			if err != nil {
+				return err
+			}
+			records = append(records, *record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// versionPrefix returns the prefix of the keys of every version of the record with the given ID. Record IDs never
+// contain a NUL byte, so one ID's prefix can't match another's versions.
+func versionPrefix(id string) []byte {
+	return append([]byte(id), 0)
+}
+
+// versionKey returns the key of a version of a record. The sequence number is encoded big-endian with its sign bit
+// flipped, so a cursor visits versions in ascending sequence order.
+func versionKey(id string, seq int64) []byte {
+	return binary.BigEndian.AppendUint64(versionPrefix(id), uint64(seq)^(1<<63))
+}
+
+// TouchRecord marks the record with the given ID as seen now, if it is stored
+func (b *Bolt) TouchRecord(ctx context.Context, id string) error {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.TouchRecord")
+	defer span.End()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket([]byte(dhtNamespace))
+		if records == nil || records.Get([]byte(id)) == nil {
+			return nil
+		}
+		return markSeen(tx, []byte(id), time.Now())
+	})
+}
+
+// DeleteStaleRecords deletes the records last seen before the given time, with their versions and failure counts,
+// except for records whose keys are retained. Records stored before last seen times were kept are marked as seen
+// now, giving them a full retention window.
+func (b *Bolt) DeleteStaleRecords(ctx context.Context, before time.Time, retained [][]byte) (int, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.DeleteStaleRecords")
+	defer span.End()
+
+	retainedKeys := make(map[string]bool, len(retained))
+	for _, k := range retained {
+		retainedKeys[string(k)] = true
+	}
+
+	var deleted int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket([]byte(dhtNamespace))
+		if records == nil {
+			return nil
+		}
+		lastSeen, err := tx.CreateBucketIfNotExists([]byte(lastSeenNamespace))
+		if err != nil {
+			return err
+		}
+
+		// find the stale records first, since deleting from a bucket while iterating it skips keys
+		var stale, unseen [][]byte
+		cursor := records.Cursor()
+		for id, _ := cursor.First(); id != nil; id, _ = cursor.Next() {
+			seen := lastSeen.Get(id)
+			if seen == nil {
+				unseen = append(unseen, bytes.Clone(id))
+				continue
+			}
+			if int64(binary.BigEndian.Uint64(seen)) >= before.UnixNano() {
+				continue
+			}
+			if k, _, err := dht.ParseRecordID(string(id)); err == nil && retainedKeys[string(k)] {
+				continue
+			}
+			stale = append(stale, bytes.Clone(id))
+		}
+
+		now := time.Now()
+		for _, id := range unseen {
+			if err = markSeen(tx, id, now); err != nil {
+				return err
+			}
+		}
+		for _, id := range stale {
+			if err = b.deleteRecord(tx, id); err != nil {
+				return err
+			}
+		}
+		deleted = len(stale)
+		return nil
+	})
+	return deleted, err
+}
+
+// DeleteRecord deletes the record with the given ID, with its versions, metadata, and failure count
+func (b *Bolt) DeleteRecord(ctx context.Context, id string) (bool, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.DeleteRecord")
+	defer span.End()
+
+	var stored bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket([]byte(dhtNamespace))
+		if records == nil || records.Get([]byte(id)) == nil {
+			return nil
+		}
+		stored = true
+		return b.deleteRecord(tx, []byte(id))
+	})
+	return stored, err
+}
+
+// WriteRetainedKey marks the records under the given key as retained
+func (b *Bolt) WriteRetainedKey(ctx context.Context, k []byte) error {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.WriteRetainedKey")
+	defer span.End()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(retainedNamespace))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(k, nil)
+	})
+}
+
+// DeleteRetainedKey stops retaining the records under the given key
+func (b *Bolt) DeleteRetainedKey(ctx context.Context, k []byte) error {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.DeleteRetainedKey")
+	defer span.End()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(retainedNamespace))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete(k)
+	})
+}
+
+// ListRetainedKeys returns every retained key, in the bucket's sorted order
+func (b *Bolt) ListRetainedKeys(ctx context.Context) ([][]byte, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.ListRetainedKeys")
+	defer span.End()
+
+	var keys [][]byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(retainedNamespace))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, _ []byte) error {
+			keys = append(keys, bytes.Clone(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// storedAPIKey is an API key as stored, with the hash its JSON leaves out
+type storedAPIKey struct {
+	dht.APIKey
+	Hash []byte `json:"hash"`
+}
+
+// WriteAPIKey stores the API key, replacing the stored key with its ID, if any
+func (b *Bolt) WriteAPIKey(ctx context.Context, key dht.APIKey) error {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.WriteAPIKey")
+	defer span.End()
+
+	keyBytes, err := json.Marshal(storedAPIKey{APIKey: key, Hash: key.Hash})
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(apiKeysNamespace))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key.ID), keyBytes)
+	})
+}
+
+// ReadAPIKey returns the API key with the given ID, or nil if there is none
+func (b *Bolt) ReadAPIKey(ctx context.Context, id string) (*dht.APIKey, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.ReadAPIKey")
+	defer span.End()
+
+	var key *dht.APIKey
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(apiKeysNamespace))
+		if bucket == nil {
+			return nil
+		}
+		keyBytes := bucket.Get([]byte(id))
+		if keyBytes == nil {
+			return nil
+		}
+		stored, err := unmarshalAPIKey(keyBytes)
+		key = &stored
+		return err
+	})
+	return key, err
+}
+
+// ListAPIKeys returns every API key, in the bucket's sorted order
+func (b *Bolt) ListAPIKeys(ctx context.Context) ([]dht.APIKey, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.ListAPIKeys")
+	defer span.End()
+
+	var keys []dht.APIKey
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(apiKeysNamespace))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, keyBytes []byte) error {
+			key, err := unmarshalAPIKey(keyBytes)
+			keys = append(keys, key)
+			return err
+		})
+	})
+	return keys, err
+}
+
+// DeleteAPIKey deletes the API key with the given ID, returning whether it was stored
+func (b *Bolt) DeleteAPIKey(ctx context.Context, id string) (bool, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.DeleteAPIKey")
+	defer span.End()
+
+	var stored bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(apiKeysNamespace))
+		if bucket == nil || bucket.Get([]byte(id)) == nil {
+			return nil
+		}
+		stored = true
+		return bucket.Delete([]byte(id))
+	})
+	return stored, err
+}
+
+func unmarshalAPIKey(keyBytes []byte) (dht.APIKey, error) {
+	var stored storedAPIKey
+	if err := json.Unmarshal(keyBytes, &stored); err != nil {
+		return dht.APIKey{}, errors.Wrap(err, "failed to unmarshal api key")
+	}
+	stored.APIKey.Hash = stored.Hash
+	return stored.APIKey, nil
+}
+
+// markSeen records that the record with the given ID was seen at the given time
+func markSeen(tx *bolt.Tx, id []byte, at time.Time) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(lastSeenNamespace))
+	if err != nil {
+		return err
+	}
+	return bucket.Put(id, binary.BigEndian.AppendUint64(nil, uint64(at.UnixNano())))
+}
+
+// deleteRecord deletes the record with the given ID, with its type index entries, last seen time, metadata, versions,
+// and failure count
+func (b *Bolt) deleteRecord(tx *bolt.Tx, id []byte) error {
+	if recordBytes := tx.Bucket([]byte(dhtNamespace)).Get(id); recordBytes != nil {
+		encoded, err := b.unmarshalRecord(id, recordBytes)
+		if err != nil {
+			return err
+		}
+		record, err := encoded.Decode()
+		if err != nil {
+			return err
+		}
+		if err = indexTypes(tx, id, record.Types(), nil); err != nil {
+			return err
+		}
+	}
+
+	for _, namespace := range []string{dhtNamespace, lastSeenNamespace, metadataNamespace, failedNamespace} {
+		if bucket := tx.Bucket([]byte(namespace)); bucket != nil {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+	}
+
+	versions := tx.Bucket([]byte(versionsNamespace))
+	if versions == nil {
+		return nil
+	}
+	var keys [][]byte
+	prefix := versionPrefix(string(id))
+	cursor := versions.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		keys = append(keys, bytes.Clone(k))
+	}
+	for _, k := range keys {
+		if err := versions.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *Bolt) Close() error {
 	return b.db.Close()
 }
@@ -147,7 +738,7 @@ func (b *Bolt) read(ctx context.Context, namespace, key string) ([]byte, error)
 			logrus.WithContext(ctx).WithField("namespace", namespace).Info("namespace does not exist")
 			return nil
 		}
-		result = bucket.Get([]byte(key))
+		result = bytes.Clone(bucket.Get([]byte(key)))
 		return nil
 	})
 	return result, err
@@ -163,7 +754,7 @@ func (b *Bolt) readAll(namespace string) (map[string][]byte, error) {
 		}
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			result[string(k)] = v
+			result[string(k)] = bytes.Clone(v)
 		}
 		return nil
 	})
@@ -184,17 +775,22 @@ func (b *Bolt) readSeveral(ctx context.Context, namespace string, after []byte,
 
 		cursor := bucket.Cursor()
 
+		// keys are visited in order, so resuming after the last key read neither skips nor repeats keys written in
+		// between, even if that key has since been removed
 		var k []byte
 		var v []byte
 		if after != nil {
-			cursor.Seek(after)
-			k, v = cursor.Next()
+			k, v = cursor.Seek(after)
+			if bytes.Equal(k, after) {
+				k, v = cursor.Next()
+			}
 		} else {
 			k, v = cursor.First()
 		}
 
 		for ; k != nil; k, v = cursor.Next() {
-			result = append(result, boltRecord{key: k, value: v})
+			// keys and values are only valid during the transaction, so copy them out
+			result = append(result, boltRecord{key: bytes.Clone(k), value: bytes.Clone(v)})
 			if len(result) >= count {
 				break
 			}
@@ -261,17 +857,31 @@ func (b *Bolt) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error
 
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			var count int
+			var count int32
 			if err := binary.Read(bytes.NewReader(v), binary.LittleEndian, &count); err != nil {
 				return err
 			}
-			result = append(result, dht.FailedRecord{ID: string(k), Count: count})
+			result = append(result, dht.FailedRecord{ID: string(k), Count: int(count)})
 		}
 		return nil
 	})
 	return result, err
 }
 
+// DeleteFailedRecord clears the failure count of the record with the given ID
+func (b *Bolt) DeleteFailedRecord(ctx context.Context, id string) error {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.DeleteFailedRecord")
+	defer span.End()
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(failedNamespace))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
 func (b *Bolt) FailedRecordCount(ctx context.Context) (int, error) {
 	_, span := telemetry.GetTracer().Start(ctx, "bolt.FailedRecordCount")
 	defer span.End()
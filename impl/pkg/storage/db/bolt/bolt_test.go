@@ -4,11 +4,13 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/goccy/go-json"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/TBD54566975/did-dht/internal/did"
 	"github.com/TBD54566975/did-dht/pkg/dht"
@@ -144,6 +146,113 @@ func TestReadWrite(t *testing.T) {
 	assert.Equal(t, beforeCnt+1, afterCnt)
 }
 
+func TestReadWriteSalted(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	unsalted, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+
+	// both records share a key but are stored separately
+	r1, r2 := dht.RecordFromBEP44(unsalted), dht.RecordFromBEP44(salted)
+	require.NoError(t, db.WriteRecord(ctx, r1))
+	require.NoError(t, db.WriteRecord(ctx, r2))
+
+	got1, err := db.ReadRecord(ctx, r1.ID())
+	require.NoError(t, err)
+	assert.Empty(t, got1.Salt)
+
+	got2, err := db.ReadRecord(ctx, r2.ID())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("salt"), got2.Salt)
+	assert.Equal(t, r2.Signature, got2.Signature)
+}
+
+func TestReadRecords(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	unsalted, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+
+	r1, r2 := dht.RecordFromBEP44(unsalted), dht.RecordFromBEP44(salted)
+	require.NoError(t, db.WriteRecord(ctx, r1))
+	require.NoError(t, db.WriteRecord(ctx, r2))
+
+	// the missing id sorts after every stored record
+	records, err := db.ReadRecords(ctx, []string{r2.ID(), "zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz", r1.ID(), r1.ID()})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, r1.Signature, records[r1.ID()].Signature)
+	assert.Equal(t, r2.Signature, records[r2.ID()].Signature)
+	assert.Equal(t, []byte("salt"), records[r2.ID()].Salt)
+
+	records, err = db.ReadRecords(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestRecordVersions(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+
+	// write versions out of order, including a duplicate
+	r := dht.RecordFromBEP44(putMsg)
+	for _, offset := range []int64{2, 0, 1, 0} {
+		version := *putMsg
+		version.Seq += offset
+		version.Sign(sk)
+		require.NoError(t, db.WriteRecordVersion(ctx, dht.RecordFromBEP44(&version)))
+	}
+	// the salted record shares the key but has its own history
+	require.NoError(t, db.WriteRecordVersion(ctx, dht.RecordFromBEP44(salted)))
+
+	versions, err := db.ListRecordVersions(ctx, r.ID())
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, version := range versions {
+		assert.Equal(t, r.SequenceNumber+int64(i), version.SequenceNumber)
+		assert.Equal(t, r.Key, version.Key)
+		assert.Empty(t, version.Salt)
+	}
+
+	saltedVersions, err := db.ListRecordVersions(ctx, dht.RecordFromBEP44(salted).ID())
+	require.NoError(t, err)
+	require.Len(t, saltedVersions, 1)
+	assert.Equal(t, []byte("salt"), saltedVersions[0].Salt)
+
+	none, err := db.ListRecordVersions(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
 func TestDBPagination(t *testing.T) {
 	db := getTestDB(t)
 	defer db.Close()
@@ -213,6 +322,56 @@ func TestDBPagination(t *testing.T) {
 	assert.Equal(t, beforeCnt+11, afterCnt)
 }
 
+func TestDBPaginationConcurrentWrites(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	writeRecords := func(n int) map[string]bool {
+		ids := make(map[string]bool)
+		for i := 0; i < n; i++ {
+			sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+			require.NoError(t, err)
+			packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+			require.NoError(t, err)
+			putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+			require.NoError(t, err)
+			r := dht.RecordFromBEP44(putMsg)
+			require.NoError(t, db.WriteRecord(ctx, r))
+			ids[r.ID()] = true
+		}
+		return ids
+	}
+	original := writeRecords(20)
+
+	listed := make(map[string]int)
+	page, nextPageToken, err := db.ListRecords(ctx, nil, 5)
+	require.NoError(t, err)
+	for _, r := range page {
+		listed[r.ID()]++
+	}
+
+	// write more records mid-scan, and remove the record the page token points at
+	writeRecords(20)
+	require.NoError(t, db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(dhtNamespace)).Delete(nextPageToken)
+	}))
+
+	for nextPageToken != nil {
+		page, nextPageToken, err = db.ListRecords(ctx, nextPageToken, 5)
+		require.NoError(t, err)
+		for _, r := range page {
+			listed[r.ID()]++
+		}
+	}
+
+	for id, n := range listed {
+		assert.Equal(t, 1, n, "record %s listed %d times", id, n)
+	}
+	for id := range original {
+		assert.Contains(t, listed, id)
+	}
+}
+
 func TestNewBolt(t *testing.T) {
 	b, err := NewBolt("")
 	assert.Error(t, err)
@@ -222,3 +381,73 @@ func TestNewBolt(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, b)
 }
+
+func TestDeleteStaleRecords(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	newRecords := func(salt []byte) (dht.BEP44Record, dht.BEP44Record) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, []did.TypeIndex{did.Organization}, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		saltedMsg, err := dht.CreateSaltedDNSPublishRequest(sk, salt, *packet)
+		require.NoError(t, err)
+		return dht.RecordFromBEP44(putMsg), dht.RecordFromBEP44(saltedMsg)
+	}
+
+	stale, _ := newRecords([]byte("salt"))
+	touched, _ := newRecords([]byte("salt"))
+	unseen, _ := newRecords([]byte("salt"))
+	retained, retainedSalted := newRecords([]byte("salt"))
+	for _, record := range []dht.BEP44Record{stale, touched, retained} {
+		require.NoError(t, db.WriteRecord(ctx, record))
+		require.NoError(t, db.WriteRecordVersion(ctx, record))
+	}
+	// records written before last seen times were kept have none
+	retainedSaltedBytes, err := json.Marshal(encodeRecord(retainedSalted))
+	require.NoError(t, err)
+	require.NoError(t, db.write(ctx, dhtNamespace, retainedSalted.ID(), retainedSaltedBytes))
+	unseenBytes, err := json.Marshal(encodeRecord(unseen))
+	require.NoError(t, err)
+	require.NoError(t, db.write(ctx, dhtNamespace, unseen.ID(), unseenBytes))
+	require.NoError(t, db.WriteFailedRecord(ctx, stale.ID()))
+
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond)
+	require.NoError(t, db.TouchRecord(ctx, touched.ID()))
+	require.NoError(t, db.TouchRecord(ctx, "unknown"))
+
+	deleted, err := db.DeleteStaleRecords(ctx, cutoff, [][]byte{retained.Key[:]})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	got, err := db.ReadRecord(ctx, stale.ID())
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	versions, err := db.ListRecordVersions(ctx, stale.ID())
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	failedCount, err := db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, failedCount)
+	typed, _, err := db.ListRecordsByType(ctx, dht.TypeQuery{Type: int(did.Organization)}, nil, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{touched.ID(), retained.ID()}, typed)
+
+	for _, record := range []dht.BEP44Record{touched, retained, retainedSalted, unseen} {
+		got, err = db.ReadRecord(ctx, record.ID())
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+	}
+
+	// only retained records survive once everything is stale
+	deleted, err = db.DeleteStaleRecords(ctx, time.Now().Add(time.Hour), [][]byte{retained.Key[:]})
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	count, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
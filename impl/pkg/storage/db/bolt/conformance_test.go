@@ -0,0 +1,31 @@
+package bolt_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/pkg/storage"
+	"github.com/TBD54566975/did-dht/pkg/storage/db/bolt"
+	"github.com/TBD54566975/did-dht/pkg/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		db, err := bolt.NewBolt(filepath.Join(t.TempDir(), "diddht.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	})
+}
+
+func TestConformanceEncrypted(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		db, err := bolt.NewEncryptedBolt(filepath.Join(t.TempDir(), "diddht.db"), bytes.Repeat([]byte{7}, 32))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	})
+}
@@ -21,14 +21,17 @@ type base64BEP44Record struct {
 	// 64 byte base64URL encoded string
 	Sig string `json:"sig" validate:"required"`
 	Seq int64  `json:"seq" validate:"required"`
+	// Up to a 64 byte base64URL encoded string
+	Salt string `json:"salt,omitempty"`
 }
 
 func encodeRecord(r dht.BEP44Record) base64BEP44Record {
 	return base64BEP44Record{
-		V:   encoding.EncodeToString(r.Value[:]),
-		K:   encoding.EncodeToString(r.Key[:]),
-		Sig: encoding.EncodeToString(r.Signature[:]),
-		Seq: r.SequenceNumber,
+		V:    encoding.EncodeToString(r.Value[:]),
+		K:    encoding.EncodeToString(r.Key[:]),
+		Sig:  encoding.EncodeToString(r.Signature[:]),
+		Seq:  r.SequenceNumber,
+		Salt: encoding.EncodeToString(r.Salt),
 	}
 }
 
@@ -48,7 +51,12 @@ func (b base64BEP44Record) Decode() (*dht.BEP44Record, error) {
 		return nil, fmt.Errorf("error parsing bep44 sig field: %v", err)
 	}
 
-	record, err := dht.NewBEP44Record(k, v, sig, b.Seq)
+	salt, err := encoding.DecodeString(b.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 salt field: %v", err)
+	}
+
+	record, err := dht.NewSaltedBEP44Record(k, v, sig, salt, b.Seq)
 	if err != nil {
 		// TODO: do something useful if this happens
 		return nil, util.LoggingErrorMsg(err, "error loading record from database, skipping")
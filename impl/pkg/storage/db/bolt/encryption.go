@@ -0,0 +1,83 @@
+package bolt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+// sealedPrefix starts every encrypted value. Unencrypted values are JSON objects, which start with '{'.
+const sealedPrefix byte = 1
+
+// ErrEncrypted is returned when reading an encrypted record from storage opened without an encryption key
+var ErrEncrypted = errors.New("record is encrypted, but no encryption key is configured")
+
+// NewEncryptedBolt creates a BoltDB-based implementation of storage.Storage that encrypts record payloads at rest
+// with AES-GCM under the given 16, 24, or 32 byte key. Record IDs, which are public keys, stay in the clear so
+// records are still listed in order. Records written before encryption was enabled stay readable, and are encrypted
+// when they are next written.
+func NewEncryptedBolt(path string, key []byte) (*Bolt, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid encryption key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewBolt(path)
+	if err != nil {
+		return nil, err
+	}
+	b.aead = aead
+	return b, nil
+}
+
+// marshalRecord encodes the record to store under the given key, encrypting it if the storage is encrypted. The key
+// is authenticated along with the record, so a sealed record can't be moved under another key.
+func (b *Bolt) marshalRecord(key []byte, record dht.BEP44Record) ([]byte, error) {
+	recordBytes, err := json.Marshal(encodeRecord(record))
+	if err != nil {
+		return nil, err
+	}
+	if b.aead == nil {
+		return recordBytes, nil
+	}
+
+	sealed := make([]byte, 1+b.aead.NonceSize(), 1+b.aead.NonceSize()+len(recordBytes)+b.aead.Overhead())
+	sealed[0] = sealedPrefix
+	nonce := sealed[1:]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(sealed, nonce, recordBytes, key), nil
+}
+
+// unmarshalRecord decodes the record stored under the given key, decrypting it if it is encrypted
+func (b *Bolt) unmarshalRecord(key, value []byte) (*base64BEP44Record, error) {
+	if len(value) > 0 && value[0] == sealedPrefix {
+		if b.aead == nil {
+			return nil, ErrEncrypted
+		}
+		if len(value) < 1+b.aead.NonceSize() {
+			return nil, errors.New("encrypted record is truncated")
+		}
+		nonce, ciphertext := value[1:1+b.aead.NonceSize()], value[1+b.aead.NonceSize():]
+		opened, err := b.aead.Open(nil, nonce, ciphertext, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt record")
+		}
+		value = opened
+	}
+
+	var encoded base64BEP44Record
+	if err := json.Unmarshal(value, &encoded); err != nil {
+		return nil, err
+	}
+	return &encoded, nil
+}
@@ -0,0 +1,103 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+func newEncryptionTestRecord(t *testing.T) dht.BEP44Record {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	return dht.RecordFromBEP44(putMsg)
+}
+
+func TestEncryptedBolt(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "diddht.db")
+	key := bytes.Repeat([]byte{7}, 32)
+
+	db, err := NewEncryptedBolt(path, key)
+	require.NoError(t, err)
+	record := newEncryptionTestRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, record))
+	require.NoError(t, db.WriteRecordVersion(ctx, record))
+
+	// neither the record nor its version is stored in the clear
+	err = db.db.View(func(tx *bolt.Tx) error {
+		for _, namespace := range []string{dhtNamespace, versionsNamespace} {
+			cursor := tx.Bucket([]byte(namespace)).Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				assert.Equal(t, sealedPrefix, v[0])
+				assert.NotContains(t, string(v), encoding.EncodeToString(record.Value))
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	got, err := db.ReadRecord(ctx, record.ID())
+	require.NoError(t, err)
+	assert.Equal(t, record.Value, got.Value)
+	versions, err := db.ListRecordVersions(ctx, record.ID())
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, record.Value, versions[0].Value)
+	require.NoError(t, db.Close())
+
+	// the records can't be read without the key, or with another key
+	db, err = NewBolt(path)
+	require.NoError(t, err)
+	_, err = db.ReadRecord(ctx, record.ID())
+	assert.ErrorIs(t, err, ErrEncrypted)
+	require.NoError(t, db.Close())
+
+	db, err = NewEncryptedBolt(path, bytes.Repeat([]byte{8}, 32))
+	require.NoError(t, err)
+	_, err = db.ReadRecord(ctx, record.ID())
+	assert.ErrorContains(t, err, "failed to decrypt record")
+	require.NoError(t, db.Close())
+}
+
+func TestEncryptedBoltReadsUnencryptedRecords(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "diddht.db")
+
+	db, err := NewBolt(path)
+	require.NoError(t, err)
+	record := newEncryptionTestRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, record))
+	require.NoError(t, db.Close())
+
+	db, err = NewEncryptedBolt(path, bytes.Repeat([]byte{7}, 16))
+	require.NoError(t, err)
+	defer db.Close()
+	got, err := db.ReadRecord(ctx, record.ID())
+	require.NoError(t, err)
+	assert.Equal(t, record.Value, got.Value)
+
+	// writing the record again encrypts it
+	require.NoError(t, db.WriteRecord(ctx, record))
+	err = db.db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, sealedPrefix, tx.Bucket([]byte(dhtNamespace)).Get([]byte(record.ID()))[0])
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestNewEncryptedBoltInvalidKey(t *testing.T) {
+	_, err := NewEncryptedBolt(filepath.Join(t.TempDir(), "diddht.db"), []byte("short"))
+	assert.ErrorContains(t, err, "invalid encryption key")
+}
@@ -0,0 +1,132 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// recordMetadata is a record's metadata as stored in the metadata namespace: the times it was created, updated, and
+// republished, as Unix nanoseconds, and its resolution count, each encoded as a big-endian 64-bit integer. Records
+// written before metadata was kept have none until they are next written, republished, or resolved.
+type recordMetadata struct {
+	created, updated, republished int64
+	resolutions                   int64
+}
+
+func (m recordMetadata) encode() []byte {
+	v := make([]byte, 0, 32)
+	for _, field := range []int64{m.created, m.updated, m.republished, m.resolutions} {
+		v = binary.BigEndian.AppendUint64(v, uint64(field))
+	}
+	return v
+}
+
+func decodeMetadata(v []byte) recordMetadata {
+	if len(v) != 32 {
+		return recordMetadata{}
+	}
+	return recordMetadata{
+		created:     int64(binary.BigEndian.Uint64(v[0:])),
+		updated:     int64(binary.BigEndian.Uint64(v[8:])),
+		republished: int64(binary.BigEndian.Uint64(v[16:])),
+		resolutions: int64(binary.BigEndian.Uint64(v[24:])),
+	}
+}
+
+// updateMetadata applies update to the metadata of the record with the given ID
+func updateMetadata(tx *bolt.Tx, id []byte, update func(metadata *recordMetadata)) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(metadataNamespace))
+	if err != nil {
+		return err
+	}
+	metadata := decodeMetadata(bucket.Get(id))
+	update(&metadata)
+	return bucket.Put(id, metadata.encode())
+}
+
+// updateStoredMetadata applies update to the metadata of each of the records with the given IDs that is stored
+func (b *Bolt) updateStoredMetadata(ids []string, update func(id string, metadata *recordMetadata)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket([]byte(dhtNamespace))
+		if records == nil {
+			return nil
+		}
+		for _, id := range ids {
+			if records.Get([]byte(id)) == nil {
+				continue
+			}
+			err := updateMetadata(tx, []byte(id), func(metadata *recordMetadata) {
+				update(id, metadata)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReadRecordMetadata returns the metadata of the record with the given ID, or nil if it isn't stored
+func (b *Bolt) ReadRecordMetadata(ctx context.Context, id string) (*dht.RecordMetadata, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.ReadRecordMetadata")
+	defer span.End()
+
+	var result *dht.RecordMetadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket([]byte(dhtNamespace))
+		if records == nil || records.Get([]byte(id)) == nil {
+			return nil
+		}
+		var metadata recordMetadata
+		if bucket := tx.Bucket([]byte(metadataNamespace)); bucket != nil {
+			metadata = decodeMetadata(bucket.Get([]byte(id)))
+		}
+		result = &dht.RecordMetadata{Resolutions: metadata.resolutions}
+		if metadata.created != 0 {
+			result.Created = time.Unix(0, metadata.created)
+			result.Updated = time.Unix(0, metadata.updated)
+		}
+		if metadata.republished != 0 {
+			republished := time.Unix(0, metadata.republished)
+			result.LastRepublished = &republished
+		}
+		if bucket := tx.Bucket([]byte(lastSeenNamespace)); bucket != nil {
+			if seen := bucket.Get([]byte(id)); seen != nil {
+				result.LastSeen = time.Unix(0, int64(binary.BigEndian.Uint64(seen)))
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// MarkRepublished marks the stored records with the given IDs as republished now
+func (b *Bolt) MarkRepublished(ctx context.Context, ids []string) error {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.MarkRepublished")
+	defer span.End()
+
+	now := time.Now().UnixNano()
+	return b.updateStoredMetadata(ids, func(_ string, metadata *recordMetadata) {
+		metadata.republished = now
+	})
+}
+
+// AddResolutions adds to the resolution counts of the stored records with the given IDs
+func (b *Bolt) AddResolutions(ctx context.Context, counts map[string]int) error {
+	_, span := telemetry.GetTracer().Start(ctx, "bolt.AddResolutions")
+	defer span.End()
+
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	return b.updateStoredMetadata(ids, func(id string, metadata *recordMetadata) {
+		metadata.resolutions += int64(counts[id])
+	})
+}
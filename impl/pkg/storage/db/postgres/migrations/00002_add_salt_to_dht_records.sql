@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE dht_records ADD COLUMN salt BYTEA NOT NULL DEFAULT '';
+ALTER TABLE dht_records DROP CONSTRAINT dht_records_key_key;
+ALTER TABLE dht_records ADD CONSTRAINT dht_records_key_salt_key UNIQUE (key, salt);
+
+-- +goose Down
+ALTER TABLE dht_records DROP CONSTRAINT dht_records_key_salt_key;
+DELETE FROM dht_records WHERE salt <> '';
+ALTER TABLE dht_records ADD CONSTRAINT dht_records_key_key UNIQUE (key);
+ALTER TABLE dht_records DROP COLUMN salt;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE dht_record_versions (
+    key BYTEA NOT NULL,
+    salt BYTEA NOT NULL DEFAULT '',
+    seq BIGINT NOT NULL,
+    value BYTEA NOT NULL,
+    sig BYTEA NOT NULL,
+    PRIMARY KEY (key, salt, seq)
+);
+
+-- +goose Down
+DROP TABLE dht_record_versions;
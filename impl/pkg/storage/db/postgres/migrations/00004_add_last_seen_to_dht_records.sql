@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE dht_records ADD COLUMN last_seen TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX dht_records_last_seen_idx ON dht_records (last_seen);
+
+-- +goose Down
+DROP INDEX dht_records_last_seen_idx;
+ALTER TABLE dht_records DROP COLUMN last_seen;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE dht_record_metadata (
+    record_id INTEGER PRIMARY KEY REFERENCES dht_records (id) ON DELETE CASCADE,
+    created TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_republished TIMESTAMPTZ,
+    resolutions BIGINT NOT NULL DEFAULT 0
+);
+-- records stored before metadata was kept are dated from when they were last seen
+INSERT INTO dht_record_metadata (record_id, created, updated) SELECT id, last_seen, last_seen FROM dht_records;
+
+-- +goose Down
+DROP TABLE dht_record_metadata;
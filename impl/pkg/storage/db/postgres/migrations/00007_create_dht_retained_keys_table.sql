@@ -0,0 +1,7 @@
+-- +goose Up
+CREATE TABLE dht_retained_keys (
+    key BYTEA PRIMARY KEY
+);
+
+-- +goose Down
+DROP TABLE dht_retained_keys;
@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX dht_record_metadata_updated_idx ON dht_record_metadata (updated, record_id);
+
+-- +goose Down
+DROP INDEX dht_record_metadata_updated_idx;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE dht_api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    hash BYTEA NOT NULL,
+    requests_per_second DOUBLE PRECISION NOT NULL,
+    burst INTEGER NOT NULL,
+    publishes_per_day INTEGER NOT NULL,
+    created TIMESTAMPTZ NOT NULL
+);
+
+-- +goose Down
+DROP TABLE dht_api_keys;
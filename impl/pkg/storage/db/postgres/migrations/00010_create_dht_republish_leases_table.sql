@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE dht_republish_leases (
+    record_id INTEGER PRIMARY KEY REFERENCES dht_records (id) ON DELETE CASCADE,
+    holder TEXT NOT NULL,
+    expires TIMESTAMPTZ NOT NULL
+);
+
+-- +goose Down
+DROP TABLE dht_republish_leases;
@@ -4,12 +4,59 @@
 
 package postgres
 
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type DhtApiKey struct {
+	ID                string
+	Name              string
+	Hash              []byte
+	RequestsPerSecond float64
+	Burst             int32
+	PublishesPerDay   int32
+	Created           pgtype.Timestamptz
+}
+
 type DhtRecord struct {
-	ID    int32
+	ID       int32
+	Key      []byte
+	Value    []byte
+	Sig      []byte
+	Seq      int64
+	Salt     []byte
+	LastSeen pgtype.Timestamptz
+}
+
+type DhtRecordMetadatum struct {
+	RecordID        int32
+	Created         pgtype.Timestamptz
+	Updated         pgtype.Timestamptz
+	LastRepublished pgtype.Timestamptz
+	Resolutions     int64
+}
+
+type DhtRecordType struct {
+	Type     int32
+	RecordID int32
+}
+
+type DhtRecordVersion struct {
 	Key   []byte
+	Salt  []byte
+	Seq   int64
 	Value []byte
 	Sig   []byte
-	Seq   int64
+}
+
+type DhtRepublishLease struct {
+	RecordID int32
+	Holder   string
+	Expires  pgtype.Timestamptz
+}
+
+type DhtRetainedKey struct {
+	Key []byte
 }
 
 type FailedRecord struct {
@@ -4,13 +4,21 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 	"github.com/sirupsen/logrus"
-	"github.com/tv42/zbase32"
 
 	"github.com/TBD54566975/did-dht/pkg/dht"
 	"github.com/TBD54566975/did-dht/pkg/telemetry"
@@ -19,20 +27,83 @@ import (
 //go:embed migrations
 var migrations embed.FS
 
-type Postgres string
+const (
+	// queryTimeoutParam is the storage URI query parameter setting how long each query attempt may take
+	queryTimeoutParam = "query_timeout"
+	// maxRetriesParam is the storage URI query parameter setting how many times a query failing with a transient
+	// error is retried
+	maxRetriesParam = "max_retries"
 
-// NewPostgres creates a PostgresQL-based implementation of storage.Storage
-func NewPostgres(uri string) (Postgres, error) {
-	db := Postgres(uri)
-	if err := db.migrate(); err != nil {
-		return db, fmt.Errorf("error migrating postgres database: %v", err)
+	defaultQueryTimeout = 5 * time.Second
+	defaultMaxRetries   = 3
+	retryBackoff        = 100 * time.Millisecond
+
+	// deleteBatchSize is the most stale records deleted in one transaction
+	deleteBatchSize = 1000
+)
+
+// Postgres is a PostgreSQL-based implementation of storage.Storage, backed by a connection pool
+type Postgres struct {
+	pool         *pgxpool.Pool
+	queries      *Queries
+	queryTimeout time.Duration
+	maxRetries   int
+}
+
+// NewPostgres creates a PostgreSQL-based implementation of storage.Storage, migrating the schema as needed.
+//
+// The pool is configured with pgxpool's URI query parameters, such as pool_max_conns and pool_min_conns. Statements
+// are prepared once per connection and reused. Each query attempt is limited to the query_timeout parameter (a Go
+// duration, 5s by default), which also sets the server-side statement_timeout unless it is set itself, and queries
+// failing with transient errors are retried up to max_retries times (3 by default).
+func NewPostgres(uri string) (*Postgres, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	queryTimeout := defaultQueryTimeout
+	if raw := query.Get(queryTimeoutParam); raw != "" {
+		if queryTimeout, err = time.ParseDuration(raw); err != nil || queryTimeout <= 0 {
+			return nil, fmt.Errorf("invalid postgres %s %q: must be a positive duration", queryTimeoutParam, raw)
+		}
+	}
+	maxRetries := defaultMaxRetries
+	if raw := query.Get(maxRetriesParam); raw != "" {
+		if maxRetries, err = strconv.Atoi(raw); err != nil || maxRetries < 0 {
+			return nil, fmt.Errorf("invalid postgres %s %q: must be a non-negative integer", maxRetriesParam, raw)
+		}
+	}
+	query.Del(queryTimeoutParam)
+	query.Del(maxRetriesParam)
+	u.RawQuery = query.Encode()
+
+	poolConfig, err := pgxpool.ParseConfig(u.String())
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if _, ok := poolConfig.ConnConfig.RuntimeParams["statement_timeout"]; !ok {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(queryTimeout.Milliseconds(), 10)
 	}
 
-	return db, nil
+	if err = migrate(poolConfig.ConnString()); err != nil {
+		return nil, fmt.Errorf("error migrating postgres database: %v", err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating postgres connection pool: %v", err)
+	}
+	return &Postgres{
+		pool:         pool,
+		queries:      New(pool),
+		queryTimeout: queryTimeout,
+		maxRetries:   maxRetries,
+	}, nil
 }
 
-func (p Postgres) migrate() error {
-	db, err := sql.Open("pgx/v5", string(p))
+func migrate(uri string) error {
+	db, err := sql.Open("pgx/v5", uri)
 	if err != nil {
 		return err
 	}
@@ -50,33 +121,76 @@ func (p Postgres) migrate() error {
 	return nil
 }
 
-func (p Postgres) connect(ctx context.Context) (*Queries, *pgx.Conn, error) {
-	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.connect")
-	defer span.End()
+// do runs the query, limiting each attempt to the query timeout and retrying transient failures with backoff
+func (p *Postgres) do(ctx context.Context, query func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, p.queryTimeout)
+		err = query(attemptCtx)
+		cancel()
+		if err == nil || attempt >= p.maxRetries || !isTransient(ctx, err) {
+			return err
+		}
 
-	conn, err := pgx.Connect(ctx, string(p))
-	if err != nil {
-		return nil, nil, err
+		backoff := retryBackoff << attempt
+		logrus.WithContext(ctx).WithError(err).WithField("attempt", attempt+1).WithField("backoff", backoff).
+			Warn("retrying postgres query after a transient error")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
 	}
+}
 
-	return New(conn), conn, nil
+// isTransient reports whether a query that failed with the given error may succeed if retried
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		// the caller gave up, not the query
+		return false
+	}
+	// the attempt ran out of time, or failed before anything was sent to the server
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"): // connection exception
+			return true
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization failure, deadlock detected
+			return true
+		case pgErr.Code == "57P01", pgErr.Code == "57014": // admin shutdown, query canceled by the statement timeout
+			return true
+		case pgErr.Code == "53300": // too many connections
+			return true
+		}
+		return false
+	}
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr)
 }
 
-func (p Postgres) WriteRecord(ctx context.Context, record dht.BEP44Record) error {
+// WriteRecord writes the record and replaces its types in the type index in a single statement, so the index never
+// disagrees with the stored record
+func (p *Postgres) WriteRecord(ctx context.Context, record dht.BEP44Record) error {
 	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.WriteRecord")
 	defer span.End()
 
-	queries, db, err := p.connect(ctx)
-	if err != nil {
-		return err
+	// an empty array rather than a null one, since comparing types to a null array would keep every previous type
+	types := make([]int32, 0, len(record.Types()))
+	for _, typ := range record.Types() {
+		types = append(types, int32(typ))
 	}
-	defer db.Close(ctx)
-
-	err = queries.WriteRecord(ctx, WriteRecordParams{
-		Key:   record.Key[:],
-		Value: record.Value[:],
-		Sig:   record.Signature[:],
-		Seq:   record.SequenceNumber,
+	err := p.do(ctx, func(ctx context.Context) error {
+		return p.queries.WriteRecord(ctx, WriteRecordParams{
+			Key:   record.Key[:],
+			Value: record.Value[:],
+			Sig:   record.Signature[:],
+			Seq:   record.SequenceNumber,
+			Salt:  saltOrEmpty(record.Salt),
+			Types: types,
+		})
 	})
 	if err != nil {
 		return err
@@ -85,21 +199,22 @@ func (p Postgres) WriteRecord(ctx context.Context, record dht.BEP44Record) error
 	return nil
 }
 
-func (p Postgres) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, error) {
+func (p *Postgres) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, error) {
 	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ReadRecord")
 	defer span.End()
 
-	queries, db, err := p.connect(ctx)
+	key, salt, err := dht.ParseRecordID(id)
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close(ctx)
-
-	decodedID, err := zbase32.DecodeString(id)
-	if err != nil {
-		return nil, err
+	var row DhtRecord
+	err = p.do(ctx, func(ctx context.Context) error {
+		row, err = p.queries.ReadRecord(ctx, ReadRecordParams{Key: key, Salt: saltOrEmpty(salt)})
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
 	}
-	row, err := queries.ReadRecord(ctx, decodedID)
 	if err != nil {
 		return nil, err
 	}
@@ -112,32 +227,69 @@ func (p Postgres) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record,
 	return record, nil
 }
 
-func (p Postgres) ListRecords(ctx context.Context, nextPageToken []byte, limit int) ([]dht.BEP44Record, []byte, error) {
-	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListRecords")
+// ReadRecords reads the records with the given IDs in a single query
+func (p *Postgres) ReadRecords(ctx context.Context, ids []string) (map[string]dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ReadRecords")
 	defer span.End()
 
-	queries, db, err := p.connect(ctx)
+	params := ReadRecordsParams{Keys: make([][]byte, 0, len(ids)), Salts: make([][]byte, 0, len(ids))}
+	for _, id := range ids {
+		key, salt, err := dht.ParseRecordID(id)
+		if err != nil {
+			return nil, err
+		}
+		params.Keys = append(params.Keys, key)
+		params.Salts = append(params.Salts, saltOrEmpty(salt))
+	}
+	var rows []DhtRecord
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		rows, err = p.queries.ReadRecords(ctx, params)
+		return err
+	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	records := make(map[string]dht.BEP44Record, len(rows))
+	for _, row := range rows {
+		record, err := row.Record()
+		if err != nil {
+			return nil, err
+		}
+		records[record.ID()] = *record
 	}
-	defer db.Close(ctx)
+	return records, nil
+}
+
+func (p *Postgres) ListRecords(ctx context.Context, nextPageToken []byte, limit int) ([]dht.BEP44Record, []byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListRecords")
+	defer span.End()
 
+	// the page token is the key of the last record followed by its salt. Records are listed in key and salt
+	// order, so resuming after the last record neither skips nor repeats records written in between.
+	if nextPageToken != nil && len(nextPageToken) < 32 {
+		return nil, nil, fmt.Errorf("invalid page token")
+	}
 	var rows []DhtRecord
-	if nextPageToken == nil {
-		rows, err = queries.ListRecordsFirstPage(ctx, int32(limit))
-	} else {
-		rows, err = queries.ListRecords(ctx, ListRecordsParams{
-			Key:   nextPageToken,
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		if nextPageToken == nil {
+			rows, err = p.queries.ListRecordsFirstPage(ctx, int32(limit))
+			return err
+		}
+		rows, err = p.queries.ListRecords(ctx, ListRecordsParams{
+			Key:   nextPageToken[:32],
+			Salt:  nextPageToken[32:],
 			Limit: int32(limit),
 		})
-	}
+		return err
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
 	var records []dht.BEP44Record
 	for _, row := range rows {
-		record, err := dht.NewBEP44Record(row.Key, row.Value, row.Sig, row.Seq)
+		record, err := row.Record()
 		if err != nil {
 			// TODO: do something useful if this happens
 			logrus.WithContext(ctx).WithError(err).WithField("record_id", row.ID).Warn("error loading record from database, skipping")
@@ -148,7 +300,8 @@ func (p Postgres) ListRecords(ctx context.Context, nextPageToken []byte, limit i
 	}
 
 	if len(rows) == limit {
-		nextPageToken = rows[len(rows)-1].Key
+		last := rows[len(rows)-1]
+		nextPageToken = append(append([]byte{}, last.Key...), last.Salt...)
 	} else {
 		nextPageToken = nil
 	}
@@ -156,21 +309,188 @@ func (p Postgres) ListRecords(ctx context.Context, nextPageToken []byte, limit i
 	return records, nextPageToken, nil
 }
 
-func (row DhtRecord) Record() (*dht.BEP44Record, error) {
-	return dht.NewBEP44Record(row.Key, row.Value, row.Sig, row.Seq)
+// ListRecordsByType lists a page of the IDs of the records of the query's type, in the order they were first stored
+// unless the query orders them by update time. The page token is the database ID of the last record listed, preceded
+// in update order by its update time.
+func (p *Postgres) ListRecordsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, limit int) ([]string, []byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListRecordsByType")
+	defer span.End()
+
+	since := pgtype.Timestamptz{InfinityModifier: pgtype.NegativeInfinity, Valid: true}
+	if !query.UpdatedSince.IsZero() {
+		since = pgtype.Timestamptz{Time: query.UpdatedSince, Valid: true}
+	}
+	if query.Order == dht.OrderUpdated || query.Order == dht.OrderUpdatedDesc {
+		return p.listRecordsByTypeUpdate(ctx, query, since, nextPageToken, limit)
+	}
+
+	var after int64
+	if nextPageToken != nil {
+		var err error
+		if after, err = strconv.ParseInt(string(nextPageToken), 10, 32); err != nil {
+			return nil, nil, dht.ErrInvalidPageToken
+		}
+	}
+	var rows []ListRecordsByTypeRow
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		rows, err = p.queries.ListRecordsByType(ctx, ListRecordsByTypeParams{
+			Type:         int32(query.Type),
+			After:        int32(after),
+			UpdatedSince: since,
+			Limit:        int32(limit),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, dht.RecordID(row.Key, row.Salt))
+	}
+
+	if len(rows) == limit {
+		nextPageToken = []byte(strconv.Itoa(int(rows[len(rows)-1].ID)))
+	} else {
+		nextPageToken = nil
+	}
+	return ids, nextPageToken, nil
 }
 
-func (p Postgres) RecordCount(ctx context.Context) (int, error) {
-	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.RecordCount")
+// listRecordsByTypeUpdate lists a page of the IDs of the records of the query's type updated since the given time, in
+// update order. Update times in page tokens are Unix microseconds, the precision postgres stores.
+func (p *Postgres) listRecordsByTypeUpdate(ctx context.Context, query dht.TypeQuery, since pgtype.Timestamptz, nextPageToken []byte, limit int) ([]string, []byte, error) {
+	var last struct {
+		updated pgtype.Timestamptz
+		id      int32
+	}
+	if nextPageToken != nil {
+		rawUpdated, rawID, ok := strings.Cut(string(nextPageToken), ":")
+		updated, err := strconv.ParseInt(rawUpdated, 10, 64)
+		if !ok || err != nil {
+			return nil, nil, dht.ErrInvalidPageToken
+		}
+		id, err := strconv.ParseInt(rawID, 10, 32)
+		if err != nil {
+			return nil, nil, dht.ErrInvalidPageToken
+		}
+		last.updated, last.id = pgtype.Timestamptz{Time: time.UnixMicro(updated), Valid: true}, int32(id)
+	}
+
+	var rows []ListRecordsByTypeUpdatedRow
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		if query.Order == dht.OrderUpdated {
+			if nextPageToken == nil {
+				last.updated = pgtype.Timestamptz{InfinityModifier: pgtype.NegativeInfinity, Valid: true}
+			}
+			rows, err = p.queries.ListRecordsByTypeUpdated(ctx, ListRecordsByTypeUpdatedParams{
+				Type:         int32(query.Type),
+				UpdatedSince: since,
+				AfterUpdated: last.updated,
+				AfterID:      last.id,
+				Limit:        int32(limit),
+			})
+			return err
+		}
+		if nextPageToken == nil {
+			last.updated, last.id = pgtype.Timestamptz{InfinityModifier: pgtype.Infinity, Valid: true}, math.MaxInt32
+		}
+		desc, err := p.queries.ListRecordsByTypeUpdatedDesc(ctx, ListRecordsByTypeUpdatedDescParams{
+			Type:          int32(query.Type),
+			UpdatedSince:  since,
+			BeforeUpdated: last.updated,
+			BeforeID:      last.id,
+			Limit:         int32(limit),
+		})
+		rows = make([]ListRecordsByTypeUpdatedRow, 0, len(desc))
+		for _, row := range desc {
+			rows = append(rows, ListRecordsByTypeUpdatedRow(row))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, dht.RecordID(row.Key, row.Salt))
+	}
+
+	if len(rows) == limit {
+		row := rows[len(rows)-1]
+		nextPageToken = fmt.Appendf(nil, "%d:%d", row.Updated.Time.UnixMicro(), row.ID)
+	} else {
+		nextPageToken = nil
+	}
+	return ids, nextPageToken, nil
+}
+
+func (p *Postgres) WriteRecordVersion(ctx context.Context, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.WriteRecordVersion")
 	defer span.End()
 
-	queries, db, err := p.connect(ctx)
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.WriteRecordVersion(ctx, WriteRecordVersionParams{
+			Key:   record.Key[:],
+			Salt:  saltOrEmpty(record.Salt),
+			Seq:   record.SequenceNumber,
+			Value: record.Value[:],
+			Sig:   record.Signature[:],
+		})
+	})
+}
+
+func (p *Postgres) ListRecordVersions(ctx context.Context, id string) ([]dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListRecordVersions")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	var rows []DhtRecordVersion
+	err = p.do(ctx, func(ctx context.Context) error {
+		rows, err = p.queries.ListRecordVersions(ctx, ListRecordVersionsParams{Key: key, Salt: saltOrEmpty(salt)})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []dht.BEP44Record
+	for _, row := range rows {
+		record, err := dht.NewSaltedBEP44Record(row.Key, row.Value, row.Sig, row.Salt, row.Seq)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (row DhtRecord) Record() (*dht.BEP44Record, error) {
+	return dht.NewSaltedBEP44Record(row.Key, row.Value, row.Sig, row.Salt, row.Seq)
+}
+
+// saltOrEmpty returns the salt, or an empty salt for unsalted records since the salt column is not nullable
+func saltOrEmpty(salt []byte) []byte {
+	if salt == nil {
+		return []byte{}
 	}
-	defer db.Close(ctx)
+	return salt
+}
 
-	count, err := queries.RecordCount(ctx)
+func (p *Postgres) RecordCount(ctx context.Context) (int, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.RecordCount")
+	defer span.End()
+
+	var count int64
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		count, err = p.queries.RecordCount(ctx)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -178,19 +498,15 @@ func (p Postgres) RecordCount(ctx context.Context) (int, error) {
 	return int(count), nil
 }
 
-func (p Postgres) WriteFailedRecord(ctx context.Context, id string) error {
+func (p *Postgres) WriteFailedRecord(ctx context.Context, id string) error {
 	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.WriteFailedRecord")
 	defer span.End()
 
-	queries, db, err := p.connect(ctx)
-	if err != nil {
-		return err
-	}
-	defer db.Close(ctx)
-
-	err = queries.WriteFailedRecord(ctx, WriteFailedRecordParams{
-		ID:           []byte(id),
-		FailureCount: 1,
+	err := p.do(ctx, func(ctx context.Context) error {
+		return p.queries.WriteFailedRecord(ctx, WriteFailedRecordParams{
+			ID:           []byte(id),
+			FailureCount: 1,
+		})
 	})
 	if err != nil {
 		return err
@@ -199,17 +515,25 @@ func (p Postgres) WriteFailedRecord(ctx context.Context, id string) error {
 	return nil
 }
 
-func (p Postgres) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error) {
-	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListFailedRecords")
+// DeleteFailedRecord clears the failure count of the record with the given ID
+func (p *Postgres) DeleteFailedRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.DeleteFailedRecord")
 	defer span.End()
 
-	queries, db, err := p.connect(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer db.Close(ctx)
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.DeleteFailedRecords(ctx, [][]byte{[]byte(id)})
+	})
+}
+
+func (p *Postgres) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListFailedRecords")
+	defer span.End()
 
-	rows, err := queries.ListFailedRecords(ctx)
+	var rows []FailedRecord
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		rows, err = p.queries.ListFailedRecords(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -225,25 +549,386 @@ func (p Postgres) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, er
 	return failedRecords, nil
 }
 
-func (p Postgres) FailedRecordCount(ctx context.Context) (int, error) {
+func (p *Postgres) FailedRecordCount(ctx context.Context) (int, error) {
 	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.FailedRecordCount")
 	defer span.End()
 
-	queries, db, err := p.connect(ctx)
+	var count int64
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		count, err = p.queries.FailedRecordCount(ctx)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	defer db.Close(ctx)
 
-	count, err := queries.FailedRecordCount(ctx)
+	return int(count), nil
+}
+
+// TouchRecord marks the record with the given ID as seen now, if it is stored
+func (p *Postgres) TouchRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.TouchRecord")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return err
+	}
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.TouchRecord(ctx, TouchRecordParams{Key: key, Salt: saltOrEmpty(salt)})
+	})
+}
+
+// ReadRecordMetadata returns the metadata of the record with the given ID, or nil if it isn't stored
+func (p *Postgres) ReadRecordMetadata(ctx context.Context, id string) (*dht.RecordMetadata, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ReadRecordMetadata")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return nil, err
+	}
+	var row ReadRecordMetadataRow
+	err = p.do(ctx, func(ctx context.Context) error {
+		row, err = p.queries.ReadRecordMetadata(ctx, ReadRecordMetadataParams{Key: key, Salt: saltOrEmpty(salt)})
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := dht.RecordMetadata{
+		Created:     row.Created.Time,
+		Updated:     row.Updated.Time,
+		LastSeen:    row.LastSeen.Time,
+		Resolutions: row.Resolutions,
+	}
+	if row.LastRepublished.Valid {
+		metadata.LastRepublished = &row.LastRepublished.Time
+	}
+	return &metadata, nil
+}
+
+// MarkRepublished marks the stored records with the given IDs as republished now
+func (p *Postgres) MarkRepublished(ctx context.Context, ids []string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.MarkRepublished")
+	defer span.End()
+
+	params := MarkRepublishedParams{Keys: make([][]byte, 0, len(ids)), Salts: make([][]byte, 0, len(ids))}
+	for _, id := range ids {
+		key, salt, err := dht.ParseRecordID(id)
+		if err != nil {
+			return err
+		}
+		params.Keys = append(params.Keys, key)
+		params.Salts = append(params.Salts, saltOrEmpty(salt))
+	}
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.MarkRepublished(ctx, params)
+	})
+}
+
+// ClaimRepublish claims the stored records with the given IDs for the given holder to republish, unless they were
+// republished since the given time or another holder's claim on them hasn't expired. Claims are rows of a lease
+// table, taken with a single upsert, so replicas claiming the same record at once can't both claim it. Leases are
+// timed by the database's clock.
+func (p *Postgres) ClaimRepublish(ctx context.Context, holder string, ids []string, republishedSince time.Time, lease time.Duration) (*dht.RepublishClaims, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ClaimRepublish")
+	defer span.End()
+
+	params := ClaimRepublishParams{
+		Keys:             make([][]byte, 0, len(ids)),
+		Salts:            make([][]byte, 0, len(ids)),
+		Holder:           holder,
+		LeaseSeconds:     lease.Seconds(),
+		RepublishedSince: pgtype.Timestamptz{Time: republishedSince, Valid: true},
+	}
+	for _, id := range ids {
+		key, salt, err := dht.ParseRecordID(id)
+		if err != nil {
+			return nil, err
+		}
+		params.Keys = append(params.Keys, key)
+		params.Salts = append(params.Salts, saltOrEmpty(salt))
+	}
+	var rows []ClaimRepublishRow
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		rows, err = p.queries.ClaimRepublish(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims := dht.RepublishClaims{Republished: make(map[string]time.Time), Leased: make(map[string]time.Time)}
+	for _, row := range rows {
+		id := dht.RecordID(row.Key, row.Salt)
+		switch {
+		case row.Claimed:
+			claims.Claimed = append(claims.Claimed, id)
+		case row.LastRepublished.Valid && !row.LastRepublished.Time.Before(republishedSince):
+			claims.Republished[id] = row.LastRepublished.Time
+		case row.LeasedUntil.Valid:
+			claims.Leased[id] = row.LeasedUntil.Time
+		}
+	}
+	return &claims, nil
+}
+
+// AddResolutions adds to the resolution counts of the stored records with the given IDs
+func (p *Postgres) AddResolutions(ctx context.Context, counts map[string]int) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.AddResolutions")
+	defer span.End()
+
+	params := AddResolutionsParams{
+		Keys:   make([][]byte, 0, len(counts)),
+		Salts:  make([][]byte, 0, len(counts)),
+		Counts: make([]int64, 0, len(counts)),
+	}
+	for id, count := range counts {
+		key, salt, err := dht.ParseRecordID(id)
+		if err != nil {
+			return err
+		}
+		params.Keys = append(params.Keys, key)
+		params.Salts = append(params.Salts, saltOrEmpty(salt))
+		params.Counts = append(params.Counts, int64(count))
+	}
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.AddResolutions(ctx, params)
+	})
+}
+
+// DeleteStaleRecords deletes the records last seen before the given time, with their versions, failure counts, and
+// type index entries, except for records whose keys are retained. Records are deleted in batches, each in its own
+// transaction.
+func (p *Postgres) DeleteStaleRecords(ctx context.Context, before time.Time, retained [][]byte) (int, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.DeleteStaleRecords")
+	defer span.End()
+
+	// comparing keys to a null array is null rather than false, which would delete nothing
+	if retained == nil {
+		retained = [][]byte{}
+	}
+	var deleted int
+	for {
+		var n int
+		err := p.do(ctx, func(ctx context.Context) (err error) {
+			n, err = p.deleteStaleBatch(ctx, before, retained)
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < deleteBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteStaleBatch deletes a batch of stale records along with their versions and failure counts, returning the
+// number of records deleted
+func (p *Postgres) deleteStaleBatch(ctx context.Context, before time.Time, retained [][]byte) (int, error) {
+	tx, err := p.pool.Begin(ctx)
 	if err != nil {
 		return 0, err
 	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	queries := p.queries.WithTx(tx)
 
-	return int(count), nil
+	rows, err := queries.DeleteStaleRecords(ctx, DeleteStaleRecordsParams{
+		Before:   pgtype.Timestamptz{Time: before, Valid: true},
+		Retained: retained,
+		Limit:    deleteBatchSize,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	keys := make([][]byte, len(rows))
+	salts := make([][]byte, len(rows))
+	ids := make([][]byte, len(rows))
+	for i, row := range rows {
+		keys[i], salts[i] = row.Key, row.Salt
+		ids[i] = []byte(dht.RecordID(row.Key, row.Salt))
+	}
+	if err = queries.DeleteRecordVersions(ctx, DeleteRecordVersionsParams{Keys: keys, Salts: salts}); err != nil {
+		return 0, err
+	}
+	if err = queries.DeleteFailedRecords(ctx, ids); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// DeleteRecord deletes the record with the given ID, with its versions, failure count, metadata, and type index
+// entries, in one transaction
+func (p *Postgres) DeleteRecord(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.DeleteRecord")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return false, err
+	}
+	var stored bool
+	err = p.do(ctx, func(ctx context.Context) (err error) {
+		stored, err = p.deleteRecord(ctx, key, saltOrEmpty(salt))
+		return err
+	})
+	return stored, err
+}
+
+// deleteRecord deletes the record with the given key and salt along with its versions and failure count, returning
+// whether it was stored
+func (p *Postgres) deleteRecord(ctx context.Context, key, salt []byte) (bool, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	queries := p.queries.WithTx(tx)
+
+	if _, err = queries.DeleteRecord(ctx, DeleteRecordParams{Key: key, Salt: salt}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = queries.DeleteRecordVersions(ctx, DeleteRecordVersionsParams{Keys: [][]byte{key}, Salts: [][]byte{salt}}); err != nil {
+		return false, err
+	}
+	if err = queries.DeleteFailedRecords(ctx, [][]byte{[]byte(dht.RecordID(key, salt))}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// WriteRetainedKey marks the records under the given key as retained
+func (p *Postgres) WriteRetainedKey(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.WriteRetainedKey")
+	defer span.End()
+
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.WriteRetainedKey(ctx, k)
+	})
+}
+
+// DeleteRetainedKey stops retaining the records under the given key
+func (p *Postgres) DeleteRetainedKey(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.DeleteRetainedKey")
+	defer span.End()
+
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.DeleteRetainedKey(ctx, k)
+	})
+}
+
+// ListRetainedKeys returns every retained key in sorted order
+func (p *Postgres) ListRetainedKeys(ctx context.Context) ([][]byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListRetainedKeys")
+	defer span.End()
+
+	var keys [][]byte
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		keys, err = p.queries.ListRetainedKeys(ctx)
+		return err
+	})
+	return keys, err
+}
+
+// WriteAPIKey stores the API key, replacing the stored key with its ID, if any
+func (p *Postgres) WriteAPIKey(ctx context.Context, key dht.APIKey) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.WriteAPIKey")
+	defer span.End()
+
+	return p.do(ctx, func(ctx context.Context) error {
+		return p.queries.WriteAPIKey(ctx, WriteAPIKeyParams{
+			ID:                key.ID,
+			Name:              key.Name,
+			Hash:              key.Hash,
+			RequestsPerSecond: key.RequestsPerSecond,
+			Burst:             int32(key.Burst),
+			PublishesPerDay:   int32(key.PublishesPerDay),
+			Created:           pgtype.Timestamptz{Time: key.Created, Valid: true},
+		})
+	})
+}
+
+// ReadAPIKey returns the API key with the given ID, or nil if there is none
+func (p *Postgres) ReadAPIKey(ctx context.Context, id string) (*dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ReadAPIKey")
+	defer span.End()
+
+	var row DhtApiKey
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		row, err = p.queries.ReadAPIKey(ctx, id)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := row.APIKey()
+	return &key, nil
+}
+
+// ListAPIKeys returns every API key in ID order
+func (p *Postgres) ListAPIKeys(ctx context.Context) ([]dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.ListAPIKeys")
+	defer span.End()
+
+	var rows []DhtApiKey
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		rows, err = p.queries.ListAPIKeys(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]dht.APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.APIKey())
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey deletes the API key with the given ID, returning whether it was stored
+func (p *Postgres) DeleteAPIKey(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "postgres.DeleteAPIKey")
+	defer span.End()
+
+	var deleted int64
+	err := p.do(ctx, func(ctx context.Context) (err error) {
+		deleted, err = p.queries.DeleteAPIKey(ctx, id)
+		return err
+	})
+	return deleted > 0, err
+}
+
+// APIKey returns the API key a row stores
+func (row DhtApiKey) APIKey() dht.APIKey {
+	return dht.APIKey{
+		ID:                row.ID,
+		Name:              row.Name,
+		Hash:              row.Hash,
+		RequestsPerSecond: row.RequestsPerSecond,
+		Burst:             int(row.Burst),
+		PublishesPerDay:   int(row.PublishesPerDay),
+		Created:           row.Created.Time,
+	}
 }
 
-func (p Postgres) Close() error {
-	// no-op, postgres connection is closed after each request
+func (p *Postgres) Close() error {
+	p.pool.Close()
 	return nil
 }
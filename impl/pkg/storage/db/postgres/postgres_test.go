@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -13,6 +14,7 @@ import (
 	"github.com/TBD54566975/did-dht/pkg/dht"
 	"github.com/TBD54566975/did-dht/pkg/storage"
 	"github.com/TBD54566975/did-dht/pkg/storage/db/postgres"
+	"github.com/TBD54566975/did-dht/pkg/storage/storagetest"
 )
 
 func getTestDB(t *testing.T) storage.Storage {
@@ -71,6 +73,65 @@ func TestReadWrite(t *testing.T) {
 	assert.Equal(t, beforeCnt+1, afterCnt)
 }
 
+func TestReadRecords(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	unsalted, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+
+	r1, r2 := dht.RecordFromBEP44(unsalted), dht.RecordFromBEP44(salted)
+	require.NoError(t, db.WriteRecord(ctx, r1))
+	require.NoError(t, db.WriteRecord(ctx, r2))
+
+	missing := dht.RecordFromBEP44(salted)
+	missing.Salt = []byte("other")
+
+	records, err := db.ReadRecords(ctx, []string{r1.ID(), missing.ID(), r2.ID()})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, r1.Signature, records[r1.ID()].Signature)
+	assert.Equal(t, r2.Signature, records[r2.ID()].Signature)
+	assert.Equal(t, []byte("salt"), records[r2.ID()].Salt)
+}
+
+func TestRecordVersions(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+
+	// write versions out of order, including a duplicate
+	for _, offset := range []int64{2, 0, 1, 0} {
+		version := *putMsg
+		version.Seq += offset
+		version.Sign(sk)
+		require.NoError(t, db.WriteRecordVersion(ctx, dht.RecordFromBEP44(&version)))
+	}
+
+	versions, err := db.ListRecordVersions(ctx, dht.RecordFromBEP44(putMsg).ID())
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, version := range versions {
+		assert.Equal(t, putMsg.Seq+int64(i), version.SequenceNumber)
+	}
+}
+
 func TestDBPagination(t *testing.T) {
 	db := getTestDB(t)
 	defer db.Close()
@@ -139,3 +200,153 @@ func TestDBPagination(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, beforeCnt+11, afterCnt)
 }
+
+func TestDBPaginationConcurrentWrites(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	newRecord := func() dht.BEP44Record {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		return dht.RecordFromBEP44(putMsg)
+	}
+
+	original := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		r := newRecord()
+		require.NoError(t, db.WriteRecord(ctx, r))
+		original[r.ID()] = true
+	}
+
+	listed := make(map[string]int)
+	var nextPageToken []byte
+	for first := true; first || nextPageToken != nil; first = false {
+		page, next, err := db.ListRecords(ctx, nextPageToken, 5)
+		require.NoError(t, err)
+		for _, r := range page {
+			listed[r.ID()]++
+		}
+		nextPageToken = next
+
+		// records written mid-scan are listed at most once
+		require.NoError(t, db.WriteRecord(ctx, newRecord()))
+	}
+
+	for id, n := range listed {
+		assert.Equal(t, 1, n, "record %s listed %d times", id, n)
+	}
+	for id := range original {
+		assert.Contains(t, listed, id)
+	}
+}
+
+func TestDeleteStaleRecords(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	var records []dht.BEP44Record
+	for i := 0; i < 3; i++ {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		record := dht.RecordFromBEP44(putMsg)
+		require.NoError(t, db.WriteRecord(ctx, record))
+		require.NoError(t, db.WriteRecordVersion(ctx, record))
+		records = append(records, record)
+	}
+	stale, touched, retained := records[0], records[1], records[2]
+	require.NoError(t, db.WriteFailedRecord(ctx, stale.ID()))
+
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, db.TouchRecord(ctx, touched.ID()))
+
+	deleted, err := db.DeleteStaleRecords(ctx, cutoff, [][]byte{retained.Key[:]})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, 1)
+
+	got, err := db.ReadRecord(ctx, stale.ID())
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	versions, err := db.ListRecordVersions(ctx, stale.ID())
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	failed, err := db.ListFailedRecords(ctx)
+	require.NoError(t, err)
+	for _, f := range failed {
+		assert.NotEqual(t, stale.ID(), f.ID)
+	}
+
+	for _, record := range []dht.BEP44Record{touched, retained} {
+		got, err := db.ReadRecord(ctx, record.ID())
+		require.NoError(t, err)
+		assert.Equal(t, record.ID(), got.ID())
+	}
+}
+
+func TestClaimRepublish(t *testing.T) {
+	db := getTestDB(t)
+	claimer, ok := storage.AsRepublishClaimer(storage.Instrument(db))
+	require.True(t, ok)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	unsalted, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+	r1, r2 := dht.RecordFromBEP44(unsalted), dht.RecordFromBEP44(salted)
+	require.NoError(t, db.WriteRecord(ctx, r1))
+	require.NoError(t, db.WriteRecord(ctx, r2))
+	missing := dht.RecordFromBEP44(salted)
+	missing.Salt = []byte("other")
+	ids := []string{r1.ID(), r2.ID(), missing.ID()}
+	since := time.Now().Add(-time.Hour)
+
+	// records are claimed by one replica at a time, which renews its claim
+	claims, err := claimer.ClaimRepublish(ctx, "a", ids, since, time.Minute)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{r1.ID(), r2.ID()}, claims.Claimed)
+	claims, err = claimer.ClaimRepublish(ctx, "b", ids, since, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claims.Claimed)
+	require.Len(t, claims.Leased, 2)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), claims.Leased[r1.ID()], 30*time.Second)
+	claims, err = claimer.ClaimRepublish(ctx, "a", ids, since, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, claims.Claimed, 2)
+
+	// records republished since the given time aren't claimed, and expired claims are taken over
+	require.NoError(t, db.MarkRepublished(ctx, []string{r1.ID()}))
+	claims, err = claimer.ClaimRepublish(ctx, "b", ids, since, -time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claims.Claimed)
+	require.Contains(t, claims.Republished, r1.ID())
+	assert.WithinDuration(t, time.Now(), claims.Republished[r1.ID()], 30*time.Second)
+	// a renewing its claim with a lease already over lets it expire
+	_, err = claimer.ClaimRepublish(ctx, "a", ids, since, -time.Minute)
+	require.NoError(t, err)
+	claims, err = claimer.ClaimRepublish(ctx, "b", ids, since, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []string{r2.ID()}, claims.Claimed)
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		db := getTestDB(t)
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	})
+}
@@ -7,7 +7,188 @@ package postgres
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const addResolutions = `-- name: AddResolutions :exec
+UPDATE dht_record_metadata m SET resolutions = m.resolutions + i.count
+FROM dht_records r, unnest($1::BYTEA[], $2::BYTEA[], $3::BIGINT[]) AS i(key, salt, count)
+WHERE m.record_id = r.id AND r.key = i.key AND r.salt = i.salt
+`
+
+type AddResolutionsParams struct {
+	Keys   [][]byte
+	Salts  [][]byte
+	Counts []int64
+}
+
+func (q *Queries) AddResolutions(ctx context.Context, arg AddResolutionsParams) error {
+	_, err := q.db.Exec(ctx, addResolutions, arg.Keys, arg.Salts, arg.Counts)
+	return err
+}
+
+const claimRepublish = `-- name: ClaimRepublish :many
+WITH requested AS (
+    SELECT r.id, r.key, r.salt, m.last_republished FROM dht_records r
+    JOIN dht_record_metadata m ON m.record_id = r.id
+    JOIN unnest($1::BYTEA[], $2::BYTEA[]) AS i(key, salt) ON r.key = i.key AND r.salt = i.salt
+), claimed AS (
+    INSERT INTO dht_republish_leases(record_id, holder, expires)
+    SELECT id, $3::TEXT, now() + make_interval(secs => $4::FLOAT8) FROM requested
+    WHERE last_republished IS NULL OR last_republished < $5::TIMESTAMPTZ
+    ON CONFLICT (record_id) DO UPDATE SET holder = EXCLUDED.holder, expires = EXCLUDED.expires
+    WHERE dht_republish_leases.expires < now() OR dht_republish_leases.holder = EXCLUDED.holder
+    RETURNING record_id
 )
+SELECT q.key, q.salt, q.last_republished, l.expires AS leased_until, (c.record_id IS NOT NULL)::BOOLEAN AS claimed
+FROM requested q
+LEFT JOIN claimed c ON c.record_id = q.id
+LEFT JOIN dht_republish_leases l ON l.record_id = q.id
+`
+
+type ClaimRepublishParams struct {
+	Keys             [][]byte
+	Salts            [][]byte
+	Holder           string
+	LeaseSeconds     float64
+	RepublishedSince pgtype.Timestamptz
+}
+
+type ClaimRepublishRow struct {
+	Key             []byte
+	Salt            []byte
+	LastRepublished pgtype.Timestamptz
+	LeasedUntil     pgtype.Timestamptz
+	Claimed         bool
+}
+
+func (q *Queries) ClaimRepublish(ctx context.Context, arg ClaimRepublishParams) ([]ClaimRepublishRow, error) {
+	rows, err := q.db.Query(ctx, claimRepublish, arg.Keys, arg.Salts, arg.Holder, arg.LeaseSeconds, arg.RepublishedSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimRepublishRow
+	for rows.Next() {
+		var i ClaimRepublishRow
+		if err := rows.Scan(
+			&i.Key,
+			&i.Salt,
+			&i.LastRepublished,
+			&i.LeasedUntil,
+			&i.Claimed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteAPIKey = `-- name: DeleteAPIKey :execrows
+DELETE FROM dht_api_keys WHERE id = $1
+`
+
+func (q *Queries) DeleteAPIKey(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteAPIKey, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteFailedRecords = `-- name: DeleteFailedRecords :exec
+DELETE FROM failed_records WHERE id = ANY($1::BYTEA[])
+`
+
+func (q *Queries) DeleteFailedRecords(ctx context.Context, ids [][]byte) error {
+	_, err := q.db.Exec(ctx, deleteFailedRecords, ids)
+	return err
+}
+
+const deleteRecord = `-- name: DeleteRecord :one
+DELETE FROM dht_records WHERE key = $1 AND salt = $2 RETURNING id
+`
+
+type DeleteRecordParams struct {
+	Key  []byte
+	Salt []byte
+}
+
+func (q *Queries) DeleteRecord(ctx context.Context, arg DeleteRecordParams) (int32, error) {
+	row := q.db.QueryRow(ctx, deleteRecord, arg.Key, arg.Salt)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteRecordVersions = `-- name: DeleteRecordVersions :exec
+DELETE FROM dht_record_versions v
+USING unnest($1::BYTEA[], $2::BYTEA[]) AS d(key, salt)
+WHERE v.key = d.key AND v.salt = d.salt
+`
+
+type DeleteRecordVersionsParams struct {
+	Keys  [][]byte
+	Salts [][]byte
+}
+
+func (q *Queries) DeleteRecordVersions(ctx context.Context, arg DeleteRecordVersionsParams) error {
+	_, err := q.db.Exec(ctx, deleteRecordVersions, arg.Keys, arg.Salts)
+	return err
+}
+
+const deleteRetainedKey = `-- name: DeleteRetainedKey :exec
+DELETE FROM dht_retained_keys WHERE key = $1
+`
+
+func (q *Queries) DeleteRetainedKey(ctx context.Context, key []byte) error {
+	_, err := q.db.Exec(ctx, deleteRetainedKey, key)
+	return err
+}
+
+const deleteStaleRecords = `-- name: DeleteStaleRecords :many
+DELETE FROM dht_records WHERE id IN (
+    SELECT id FROM dht_records
+    WHERE last_seen < $1 AND NOT (key = ANY($2::BYTEA[]))
+    LIMIT $3
+) RETURNING key, salt
+`
+
+type DeleteStaleRecordsParams struct {
+	Before   pgtype.Timestamptz
+	Retained [][]byte
+	Limit    int32
+}
+
+type DeleteStaleRecordsRow struct {
+	Key  []byte
+	Salt []byte
+}
+
+func (q *Queries) DeleteStaleRecords(ctx context.Context, arg DeleteStaleRecordsParams) ([]DeleteStaleRecordsRow, error) {
+	rows, err := q.db.Query(ctx, deleteStaleRecords, arg.Before, arg.Retained, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeleteStaleRecordsRow
+	for rows.Next() {
+		var i DeleteStaleRecordsRow
+		if err := rows.Scan(&i.Key, &i.Salt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
 
 const failedRecordCount = `-- name: FailedRecordCount :one
 SELECT count(*) AS exact_count FROM failed_records
@@ -20,6 +201,38 @@ func (q *Queries) FailedRecordCount(ctx context.Context) (int64, error) {
 	return exact_count, err
 }
 
+const listAPIKeys = `-- name: ListAPIKeys :many
+SELECT id, name, hash, requests_per_second, burst, publishes_per_day, created FROM dht_api_keys ORDER BY id ASC
+`
+
+func (q *Queries) ListAPIKeys(ctx context.Context) ([]DhtApiKey, error) {
+	rows, err := q.db.Query(ctx, listAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DhtApiKey
+	for rows.Next() {
+		var i DhtApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Hash,
+			&i.RequestsPerSecond,
+			&i.Burst,
+			&i.PublishesPerDay,
+			&i.Created,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listFailedRecords = `-- name: ListFailedRecords :many
 SELECT id, failure_count FROM failed_records
 `
@@ -44,17 +257,53 @@ func (q *Queries) ListFailedRecords(ctx context.Context) ([]FailedRecord, error)
 	return items, nil
 }
 
+const listRecordVersions = `-- name: ListRecordVersions :many
+SELECT key, salt, seq, value, sig FROM dht_record_versions WHERE key = $1 AND salt = $2 ORDER BY seq ASC
+`
+
+type ListRecordVersionsParams struct {
+	Key  []byte
+	Salt []byte
+}
+
+func (q *Queries) ListRecordVersions(ctx context.Context, arg ListRecordVersionsParams) ([]DhtRecordVersion, error) {
+	rows, err := q.db.Query(ctx, listRecordVersions, arg.Key, arg.Salt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DhtRecordVersion
+	for rows.Next() {
+		var i DhtRecordVersion
+		if err := rows.Scan(
+			&i.Key,
+			&i.Salt,
+			&i.Seq,
+			&i.Value,
+			&i.Sig,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listRecords = `-- name: ListRecords :many
-SELECT id, key, value, sig, seq FROM dht_records WHERE id > (SELECT id FROM dht_records WHERE dht_records.key = $1) ORDER BY id ASC LIMIT $2
+SELECT id, key, value, sig, seq, salt, last_seen FROM dht_records WHERE (key, salt) > ($1::BYTEA, $2::BYTEA) ORDER BY key ASC, salt ASC LIMIT $3
 `
 
 type ListRecordsParams struct {
 	Key   []byte
+	Salt  []byte
 	Limit int32
 }
 
 func (q *Queries) ListRecords(ctx context.Context, arg ListRecordsParams) ([]DhtRecord, error) {
-	rows, err := q.db.Query(ctx, listRecords, arg.Key, arg.Limit)
+	rows, err := q.db.Query(ctx, listRecords, arg.Key, arg.Salt, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -68,6 +317,8 @@ func (q *Queries) ListRecords(ctx context.Context, arg ListRecordsParams) ([]Dht
 			&i.Value,
 			&i.Sig,
 			&i.Seq,
+			&i.Salt,
+			&i.LastSeen,
 		); err != nil {
 			return nil, err
 		}
@@ -79,8 +330,134 @@ func (q *Queries) ListRecords(ctx context.Context, arg ListRecordsParams) ([]Dht
 	return items, nil
 }
 
+const listRecordsByType = `-- name: ListRecordsByType :many
+SELECT r.id, r.key, r.salt FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = $1 AND t.record_id > $2 AND m.updated >= $3
+ORDER BY t.record_id ASC LIMIT $4
+`
+
+type ListRecordsByTypeParams struct {
+	Type         int32
+	After        int32
+	UpdatedSince pgtype.Timestamptz
+	Limit        int32
+}
+
+type ListRecordsByTypeRow struct {
+	ID   int32
+	Key  []byte
+	Salt []byte
+}
+
+func (q *Queries) ListRecordsByType(ctx context.Context, arg ListRecordsByTypeParams) ([]ListRecordsByTypeRow, error) {
+	rows, err := q.db.Query(ctx, listRecordsByType, arg.Type, arg.After, arg.UpdatedSince, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRecordsByTypeRow
+	for rows.Next() {
+		var i ListRecordsByTypeRow
+		if err := rows.Scan(&i.ID, &i.Key, &i.Salt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRecordsByTypeUpdated = `-- name: ListRecordsByTypeUpdated :many
+SELECT r.id, r.key, r.salt, m.updated FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = $1 AND m.updated >= $2
+AND (m.updated, r.id) > ($3::TIMESTAMPTZ, $4::INTEGER)
+ORDER BY m.updated ASC, r.id ASC LIMIT $5
+`
+
+type ListRecordsByTypeUpdatedParams struct {
+	Type         int32
+	UpdatedSince pgtype.Timestamptz
+	AfterUpdated pgtype.Timestamptz
+	AfterID      int32
+	Limit        int32
+}
+
+type ListRecordsByTypeUpdatedRow struct {
+	ID      int32
+	Key     []byte
+	Salt    []byte
+	Updated pgtype.Timestamptz
+}
+
+func (q *Queries) ListRecordsByTypeUpdated(ctx context.Context, arg ListRecordsByTypeUpdatedParams) ([]ListRecordsByTypeUpdatedRow, error) {
+	rows, err := q.db.Query(ctx, listRecordsByTypeUpdated, arg.Type, arg.UpdatedSince, arg.AfterUpdated, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRecordsByTypeUpdatedRow
+	for rows.Next() {
+		var i ListRecordsByTypeUpdatedRow
+		if err := rows.Scan(&i.ID, &i.Key, &i.Salt, &i.Updated); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRecordsByTypeUpdatedDesc = `-- name: ListRecordsByTypeUpdatedDesc :many
+SELECT r.id, r.key, r.salt, m.updated FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = $1 AND m.updated >= $2
+AND (m.updated, r.id) < ($3::TIMESTAMPTZ, $4::INTEGER)
+ORDER BY m.updated DESC, r.id DESC LIMIT $5
+`
+
+type ListRecordsByTypeUpdatedDescParams struct {
+	Type          int32
+	UpdatedSince  pgtype.Timestamptz
+	BeforeUpdated pgtype.Timestamptz
+	BeforeID      int32
+	Limit         int32
+}
+
+type ListRecordsByTypeUpdatedDescRow struct {
+	ID      int32
+	Key     []byte
+	Salt    []byte
+	Updated pgtype.Timestamptz
+}
+
+func (q *Queries) ListRecordsByTypeUpdatedDesc(ctx context.Context, arg ListRecordsByTypeUpdatedDescParams) ([]ListRecordsByTypeUpdatedDescRow, error) {
+	rows, err := q.db.Query(ctx, listRecordsByTypeUpdatedDesc, arg.Type, arg.UpdatedSince, arg.BeforeUpdated, arg.BeforeID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRecordsByTypeUpdatedDescRow
+	for rows.Next() {
+		var i ListRecordsByTypeUpdatedDescRow
+		if err := rows.Scan(&i.ID, &i.Key, &i.Salt, &i.Updated); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listRecordsFirstPage = `-- name: ListRecordsFirstPage :many
-SELECT id, key, value, sig, seq FROM dht_records ORDER BY id ASC LIMIT $1
+SELECT id, key, value, sig, seq, salt, last_seen FROM dht_records ORDER BY key ASC, salt ASC LIMIT $1
 `
 
 func (q *Queries) ListRecordsFirstPage(ctx context.Context, limit int32) ([]DhtRecord, error) {
@@ -98,6 +475,8 @@ func (q *Queries) ListRecordsFirstPage(ctx context.Context, limit int32) ([]DhtR
 			&i.Value,
 			&i.Sig,
 			&i.Seq,
+			&i.Salt,
+			&i.LastSeen,
 		); err != nil {
 			return nil, err
 		}
@@ -109,12 +488,76 @@ func (q *Queries) ListRecordsFirstPage(ctx context.Context, limit int32) ([]DhtR
 	return items, nil
 }
 
+const listRetainedKeys = `-- name: ListRetainedKeys :many
+SELECT key FROM dht_retained_keys ORDER BY key ASC
+`
+
+func (q *Queries) ListRetainedKeys(ctx context.Context) ([][]byte, error) {
+	rows, err := q.db.Query(ctx, listRetainedKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items [][]byte
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		items = append(items, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markRepublished = `-- name: MarkRepublished :exec
+UPDATE dht_record_metadata m SET last_republished = now()
+FROM dht_records r, unnest($1::BYTEA[], $2::BYTEA[]) AS i(key, salt)
+WHERE m.record_id = r.id AND r.key = i.key AND r.salt = i.salt
+`
+
+type MarkRepublishedParams struct {
+	Keys  [][]byte
+	Salts [][]byte
+}
+
+func (q *Queries) MarkRepublished(ctx context.Context, arg MarkRepublishedParams) error {
+	_, err := q.db.Exec(ctx, markRepublished, arg.Keys, arg.Salts)
+	return err
+}
+
+const readAPIKey = `-- name: ReadAPIKey :one
+SELECT id, name, hash, requests_per_second, burst, publishes_per_day, created FROM dht_api_keys WHERE id = $1
+`
+
+func (q *Queries) ReadAPIKey(ctx context.Context, id string) (DhtApiKey, error) {
+	row := q.db.QueryRow(ctx, readAPIKey, id)
+	var i DhtApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Hash,
+		&i.RequestsPerSecond,
+		&i.Burst,
+		&i.PublishesPerDay,
+		&i.Created,
+	)
+	return i, err
+}
+
 const readRecord = `-- name: ReadRecord :one
-SELECT id, key, value, sig, seq FROM dht_records WHERE key = $1 LIMIT 1
+SELECT id, key, value, sig, seq, salt, last_seen FROM dht_records WHERE key = $1 AND salt = $2 LIMIT 1
 `
 
-func (q *Queries) ReadRecord(ctx context.Context, key []byte) (DhtRecord, error) {
-	row := q.db.QueryRow(ctx, readRecord, key)
+type ReadRecordParams struct {
+	Key  []byte
+	Salt []byte
+}
+
+func (q *Queries) ReadRecord(ctx context.Context, arg ReadRecordParams) (DhtRecord, error) {
+	row := q.db.QueryRow(ctx, readRecord, arg.Key, arg.Salt)
 	var i DhtRecord
 	err := row.Scan(
 		&i.ID,
@@ -122,10 +565,82 @@ func (q *Queries) ReadRecord(ctx context.Context, key []byte) (DhtRecord, error)
 		&i.Value,
 		&i.Sig,
 		&i.Seq,
+		&i.Salt,
+		&i.LastSeen,
+	)
+	return i, err
+}
+
+const readRecordMetadata = `-- name: ReadRecordMetadata :one
+SELECT m.created, m.updated, r.last_seen, m.last_republished, m.resolutions
+FROM dht_records r JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE r.key = $1 AND r.salt = $2
+`
+
+type ReadRecordMetadataParams struct {
+	Key  []byte
+	Salt []byte
+}
+
+type ReadRecordMetadataRow struct {
+	Created         pgtype.Timestamptz
+	Updated         pgtype.Timestamptz
+	LastSeen        pgtype.Timestamptz
+	LastRepublished pgtype.Timestamptz
+	Resolutions     int64
+}
+
+func (q *Queries) ReadRecordMetadata(ctx context.Context, arg ReadRecordMetadataParams) (ReadRecordMetadataRow, error) {
+	row := q.db.QueryRow(ctx, readRecordMetadata, arg.Key, arg.Salt)
+	var i ReadRecordMetadataRow
+	err := row.Scan(
+		&i.Created,
+		&i.Updated,
+		&i.LastSeen,
+		&i.LastRepublished,
+		&i.Resolutions,
 	)
 	return i, err
 }
 
+const readRecords = `-- name: ReadRecords :many
+SELECT r.id, r.key, r.value, r.sig, r.seq, r.salt, r.last_seen FROM dht_records r
+JOIN unnest($1::BYTEA[], $2::BYTEA[]) AS i(key, salt) ON r.key = i.key AND r.salt = i.salt
+`
+
+type ReadRecordsParams struct {
+	Keys  [][]byte
+	Salts [][]byte
+}
+
+func (q *Queries) ReadRecords(ctx context.Context, arg ReadRecordsParams) ([]DhtRecord, error) {
+	rows, err := q.db.Query(ctx, readRecords, arg.Keys, arg.Salts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DhtRecord
+	for rows.Next() {
+		var i DhtRecord
+		if err := rows.Scan(
+			&i.ID,
+			&i.Key,
+			&i.Value,
+			&i.Sig,
+			&i.Seq,
+			&i.Salt,
+			&i.LastSeen,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const recordCount = `-- name: RecordCount :one
 SELECT count(*) AS exact_count FROM dht_records
 `
@@ -137,6 +652,54 @@ func (q *Queries) RecordCount(ctx context.Context) (int64, error) {
 	return exact_count, err
 }
 
+const touchRecord = `-- name: TouchRecord :exec
+UPDATE dht_records SET last_seen = now() WHERE key = $1 AND salt = $2
+`
+
+type TouchRecordParams struct {
+	Key  []byte
+	Salt []byte
+}
+
+func (q *Queries) TouchRecord(ctx context.Context, arg TouchRecordParams) error {
+	_, err := q.db.Exec(ctx, touchRecord, arg.Key, arg.Salt)
+	return err
+}
+
+const writeAPIKey = `-- name: WriteAPIKey :exec
+INSERT INTO dht_api_keys(id, name, hash, requests_per_second, burst, publishes_per_day, created)
+VALUES($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO UPDATE SET
+    name = excluded.name,
+    hash = excluded.hash,
+    requests_per_second = excluded.requests_per_second,
+    burst = excluded.burst,
+    publishes_per_day = excluded.publishes_per_day
+`
+
+type WriteAPIKeyParams struct {
+	ID                string
+	Name              string
+	Hash              []byte
+	RequestsPerSecond float64
+	Burst             int32
+	PublishesPerDay   int32
+	Created           pgtype.Timestamptz
+}
+
+func (q *Queries) WriteAPIKey(ctx context.Context, arg WriteAPIKeyParams) error {
+	_, err := q.db.Exec(ctx, writeAPIKey,
+		arg.ID,
+		arg.Name,
+		arg.Hash,
+		arg.RequestsPerSecond,
+		arg.Burst,
+		arg.PublishesPerDay,
+		arg.Created,
+	)
+	return err
+}
+
 const writeFailedRecord = `-- name: WriteFailedRecord :exec
 INSERT INTO failed_records(id, failure_count)
 VALUES($1, $2)
@@ -154,7 +717,21 @@ func (q *Queries) WriteFailedRecord(ctx context.Context, arg WriteFailedRecordPa
 }
 
 const writeRecord = `-- name: WriteRecord :exec
-INSERT INTO dht_records(key, value, sig, seq) VALUES($1, $2, $3, $4)
+WITH written AS (
+    INSERT INTO dht_records(key, value, sig, seq, salt) VALUES($1, $2, $3, $4, $5)
+    ON CONFLICT (key, salt) DO UPDATE SET value = EXCLUDED.value, sig = EXCLUDED.sig, seq = EXCLUDED.seq, last_seen = now()
+    WHERE dht_records.seq <= EXCLUDED.seq
+    RETURNING id
+), untyped AS (
+    DELETE FROM dht_record_types t USING written
+    WHERE t.record_id = written.id AND NOT (t.type = ANY($6::INTEGER[]))
+), metadata AS (
+    INSERT INTO dht_record_metadata(record_id) SELECT id FROM written
+    ON CONFLICT (record_id) DO UPDATE SET updated = now()
+)
+INSERT INTO dht_record_types(type, record_id)
+SELECT unnest($6::INTEGER[]), id FROM written
+ON CONFLICT DO NOTHING
 `
 
 type WriteRecordParams struct {
@@ -162,6 +739,8 @@ type WriteRecordParams struct {
 	Value []byte
 	Sig   []byte
 	Seq   int64
+	Salt  []byte
+	Types []int32
 }
 
 func (q *Queries) WriteRecord(ctx context.Context, arg WriteRecordParams) error {
@@ -170,6 +749,41 @@ func (q *Queries) WriteRecord(ctx context.Context, arg WriteRecordParams) error
 		arg.Value,
 		arg.Sig,
 		arg.Seq,
+		arg.Salt,
+		arg.Types,
 	)
 	return err
 }
+
+const writeRecordVersion = `-- name: WriteRecordVersion :exec
+INSERT INTO dht_record_versions(key, salt, seq, value, sig) VALUES($1, $2, $3, $4, $5)
+ON CONFLICT (key, salt, seq) DO NOTHING
+`
+
+type WriteRecordVersionParams struct {
+	Key   []byte
+	Salt  []byte
+	Seq   int64
+	Value []byte
+	Sig   []byte
+}
+
+func (q *Queries) WriteRecordVersion(ctx context.Context, arg WriteRecordVersionParams) error {
+	_, err := q.db.Exec(ctx, writeRecordVersion,
+		arg.Key,
+		arg.Salt,
+		arg.Seq,
+		arg.Value,
+		arg.Sig,
+	)
+	return err
+}
+
+const writeRetainedKey = `-- name: WriteRetainedKey :exec
+INSERT INTO dht_retained_keys(key) VALUES($1) ON CONFLICT DO NOTHING
+`
+
+func (q *Queries) WriteRetainedKey(ctx context.Context, key []byte) error {
+	_, err := q.db.Exec(ctx, writeRetainedKey, key)
+	return err
+}
@@ -1,14 +1,110 @@
 -- name: WriteRecord :exec
-INSERT INTO dht_records(key, value, sig, seq) VALUES($1, $2, $3, $4);
+WITH written AS (
+    INSERT INTO dht_records(key, value, sig, seq, salt) VALUES($1, $2, $3, $4, $5)
+    ON CONFLICT (key, salt) DO UPDATE SET value = EXCLUDED.value, sig = EXCLUDED.sig, seq = EXCLUDED.seq, last_seen = now()
+    WHERE dht_records.seq <= EXCLUDED.seq
+    RETURNING id
+), untyped AS (
+    DELETE FROM dht_record_types t USING written
+    WHERE t.record_id = written.id AND NOT (t.type = ANY(sqlc.arg(types)::INTEGER[]))
+), metadata AS (
+    INSERT INTO dht_record_metadata(record_id) SELECT id FROM written
+    ON CONFLICT (record_id) DO UPDATE SET updated = now()
+)
+INSERT INTO dht_record_types(type, record_id)
+SELECT unnest(sqlc.arg(types)::INTEGER[]), id FROM written
+ON CONFLICT DO NOTHING;
 
 -- name: ReadRecord :one
-SELECT * FROM dht_records WHERE key = $1 LIMIT 1;
+SELECT * FROM dht_records WHERE key = $1 AND salt = $2 LIMIT 1;
+
+-- name: ReadRecords :many
+SELECT r.* FROM dht_records r
+JOIN unnest(sqlc.arg(keys)::BYTEA[], sqlc.arg(salts)::BYTEA[]) AS i(key, salt) ON r.key = i.key AND r.salt = i.salt;
 
 -- name: ListRecords :many
-SELECT * FROM dht_records WHERE id > (SELECT id FROM dht_records WHERE dht_records.key = $1) ORDER BY id ASC LIMIT $2;
+SELECT * FROM dht_records WHERE (key, salt) > (sqlc.arg(key)::BYTEA, sqlc.arg(salt)::BYTEA) ORDER BY key ASC, salt ASC LIMIT sqlc.arg('limit');
 
 -- name: ListRecordsFirstPage :many
-SELECT * FROM dht_records ORDER BY id ASC LIMIT $1;
+SELECT * FROM dht_records ORDER BY key ASC, salt ASC LIMIT $1;
+
+-- name: ListRecordsByType :many
+SELECT r.id, r.key, r.salt FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = sqlc.arg(type) AND t.record_id > sqlc.arg(after) AND m.updated >= sqlc.arg(updated_since)
+ORDER BY t.record_id ASC LIMIT sqlc.arg('limit');
+
+-- name: ListRecordsByTypeUpdated :many
+SELECT r.id, r.key, r.salt, m.updated FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = sqlc.arg(type) AND m.updated >= sqlc.arg(updated_since)
+AND (m.updated, r.id) > (sqlc.arg(after_updated)::TIMESTAMPTZ, sqlc.arg(after_id)::INTEGER)
+ORDER BY m.updated ASC, r.id ASC LIMIT sqlc.arg('limit');
+
+-- name: ListRecordsByTypeUpdatedDesc :many
+SELECT r.id, r.key, r.salt, m.updated FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = sqlc.arg(type) AND m.updated >= sqlc.arg(updated_since)
+AND (m.updated, r.id) < (sqlc.arg(before_updated)::TIMESTAMPTZ, sqlc.arg(before_id)::INTEGER)
+ORDER BY m.updated DESC, r.id DESC LIMIT sqlc.arg('limit');
+
+-- name: TouchRecord :exec
+UPDATE dht_records SET last_seen = now() WHERE key = $1 AND salt = $2;
+
+-- name: ReadRecordMetadata :one
+SELECT m.created, m.updated, r.last_seen, m.last_republished, m.resolutions
+FROM dht_records r JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE r.key = $1 AND r.salt = $2;
+
+-- name: MarkRepublished :exec
+UPDATE dht_record_metadata m SET last_republished = now()
+FROM dht_records r, unnest(sqlc.arg(keys)::BYTEA[], sqlc.arg(salts)::BYTEA[]) AS i(key, salt)
+WHERE m.record_id = r.id AND r.key = i.key AND r.salt = i.salt;
+
+-- name: ClaimRepublish :many
+WITH requested AS (
+    SELECT r.id, r.key, r.salt, m.last_republished FROM dht_records r
+    JOIN dht_record_metadata m ON m.record_id = r.id
+    JOIN unnest(sqlc.arg(keys)::BYTEA[], sqlc.arg(salts)::BYTEA[]) AS i(key, salt) ON r.key = i.key AND r.salt = i.salt
+), claimed AS (
+    INSERT INTO dht_republish_leases(record_id, holder, expires)
+    SELECT id, sqlc.arg(holder)::TEXT, now() + make_interval(secs => sqlc.arg(lease_seconds)::FLOAT8) FROM requested
+    WHERE last_republished IS NULL OR last_republished < sqlc.arg(republished_since)::TIMESTAMPTZ
+    ON CONFLICT (record_id) DO UPDATE SET holder = EXCLUDED.holder, expires = EXCLUDED.expires
+    WHERE dht_republish_leases.expires < now() OR dht_republish_leases.holder = EXCLUDED.holder
+    RETURNING record_id
+)
+SELECT q.key, q.salt, q.last_republished, l.expires AS leased_until, (c.record_id IS NOT NULL)::BOOLEAN AS claimed
+FROM requested q
+LEFT JOIN claimed c ON c.record_id = q.id
+LEFT JOIN dht_republish_leases l ON l.record_id = q.id;
+
+-- name: AddResolutions :exec
+UPDATE dht_record_metadata m SET resolutions = m.resolutions + i.count
+FROM dht_records r, unnest(sqlc.arg(keys)::BYTEA[], sqlc.arg(salts)::BYTEA[], sqlc.arg(counts)::BIGINT[]) AS i(key, salt, count)
+WHERE m.record_id = r.id AND r.key = i.key AND r.salt = i.salt;
+
+-- name: DeleteStaleRecords :many
+DELETE FROM dht_records WHERE id IN (
+    SELECT id FROM dht_records
+    WHERE last_seen < sqlc.arg(before) AND NOT (key = ANY(sqlc.arg(retained)::BYTEA[]))
+    LIMIT sqlc.arg('limit')
+) RETURNING key, salt;
+
+-- name: DeleteRecord :one
+DELETE FROM dht_records WHERE key = $1 AND salt = $2 RETURNING id;
+
+-- name: DeleteRecordVersions :exec
+DELETE FROM dht_record_versions v
+USING unnest(sqlc.arg(keys)::BYTEA[], sqlc.arg(salts)::BYTEA[]) AS d(key, salt)
+WHERE v.key = d.key AND v.salt = d.salt;
+
+-- name: WriteRecordVersion :exec
+INSERT INTO dht_record_versions(key, salt, seq, value, sig) VALUES($1, $2, $3, $4, $5)
+ON CONFLICT (key, salt, seq) DO NOTHING;
+
+-- name: ListRecordVersions :many
+SELECT * FROM dht_record_versions WHERE key = $1 AND salt = $2 ORDER BY seq ASC;
 
 -- name: RecordCount :one
 SELECT count(*) AS exact_count FROM dht_records;
@@ -21,5 +117,36 @@ ON CONFLICT (id) DO UPDATE SET failure_count = failed_records.failure_count + 1;
 -- name: ListFailedRecords :many
 SELECT * FROM failed_records;
 
+-- name: DeleteFailedRecords :exec
+DELETE FROM failed_records WHERE id = ANY(sqlc.arg(ids)::BYTEA[]);
+
 -- name: FailedRecordCount :one
-SELECT count(*) AS exact_count FROM failed_records;
\ No newline at end of file
+SELECT count(*) AS exact_count FROM failed_records;
+
+-- name: WriteRetainedKey :exec
+INSERT INTO dht_retained_keys(key) VALUES($1) ON CONFLICT DO NOTHING;
+
+-- name: DeleteRetainedKey :exec
+DELETE FROM dht_retained_keys WHERE key = $1;
+
+-- name: ListRetainedKeys :many
+SELECT key FROM dht_retained_keys ORDER BY key ASC;
+
+-- name: WriteAPIKey :exec
+INSERT INTO dht_api_keys(id, name, hash, requests_per_second, burst, publishes_per_day, created)
+VALUES($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO UPDATE SET
+    name = excluded.name,
+    hash = excluded.hash,
+    requests_per_second = excluded.requests_per_second,
+    burst = excluded.burst,
+    publishes_per_day = excluded.publishes_per_day;
+
+-- name: ReadAPIKey :one
+SELECT * FROM dht_api_keys WHERE id = $1;
+
+-- name: ListAPIKeys :many
+SELECT * FROM dht_api_keys ORDER BY id ASC;
+
+-- name: DeleteAPIKey :execrows
+DELETE FROM dht_api_keys WHERE id = $1;
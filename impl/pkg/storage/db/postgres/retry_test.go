@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTransient(t *testing.T) {
+	ctx := context.Background()
+
+	assert.True(t, isTransient(ctx, &pgconn.PgError{Code: "40001"}))
+	assert.True(t, isTransient(ctx, &pgconn.PgError{Code: "40P01"}))
+	assert.True(t, isTransient(ctx, &pgconn.PgError{Code: "08006"}))
+	assert.True(t, isTransient(ctx, &pgconn.PgError{Code: "53300"}))
+	assert.True(t, isTransient(ctx, context.DeadlineExceeded))
+
+	assert.False(t, isTransient(ctx, &pgconn.PgError{Code: "23505"}))
+	assert.False(t, isTransient(ctx, pgx.ErrNoRows))
+	assert.False(t, isTransient(ctx, errors.New("boom")))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, isTransient(cancelled, &pgconn.PgError{Code: "40001"}))
+}
+
+func TestDoRetries(t *testing.T) {
+	p := &Postgres{queryTimeout: time.Second, maxRetries: 2}
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		var attempts int
+		err := p.do(context.Background(), func(context.Context) error {
+			attempts++
+			if attempts < 2 {
+				return &pgconn.PgError{Code: "40001"}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("retries give up after max retries", func(t *testing.T) {
+		var attempts int
+		err := p.do(context.Background(), func(context.Context) error {
+			attempts++
+			return &pgconn.PgError{Code: "40001"}
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		var attempts int
+		err := p.do(context.Background(), func(context.Context) error {
+			attempts++
+			return pgx.ErrNoRows
+		})
+		assert.ErrorIs(t, err, pgx.ErrNoRows)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestNewPostgresInvalidOptions(t *testing.T) {
+	_, err := NewPostgres("postgres://localhost/diddht?query_timeout=soon")
+	assert.ErrorContains(t, err, "invalid postgres query_timeout")
+
+	_, err = NewPostgres("postgres://localhost/diddht?max_retries=-1")
+	assert.ErrorContains(t, err, "invalid postgres max_retries")
+}
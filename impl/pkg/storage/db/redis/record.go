@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+var (
+	encoding = base64.RawURLEncoding
+)
+
+// base64BEP44Record is a record as stored in redis, with its binary fields base64url encoded
+type base64BEP44Record struct {
+	V    string `json:"v"`
+	K    string `json:"k"`
+	Sig  string `json:"sig"`
+	Seq  int64  `json:"seq"`
+	Salt string `json:"salt,omitempty"`
+}
+
+func encodeRecord(r dht.BEP44Record) base64BEP44Record {
+	return base64BEP44Record{
+		V:    encoding.EncodeToString(r.Value),
+		K:    encoding.EncodeToString(r.Key[:]),
+		Sig:  encoding.EncodeToString(r.Signature[:]),
+		Seq:  r.SequenceNumber,
+		Salt: encoding.EncodeToString(r.Salt),
+	}
+}
+
+// Decode returns the stored record, verifying its signature
+func (b base64BEP44Record) Decode() (*dht.BEP44Record, error) {
+	v, err := encoding.DecodeString(b.V)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 value field: %v", err)
+	}
+	k, err := encoding.DecodeString(b.K)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 key field: %v", err)
+	}
+	sig, err := encoding.DecodeString(b.Sig)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 sig field: %v", err)
+	}
+	salt, err := encoding.DecodeString(b.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing bep44 salt field: %v", err)
+	}
+	return dht.NewSaltedBEP44Record(k, v, sig, salt, b.Seq)
+}
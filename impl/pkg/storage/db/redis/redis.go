@@ -0,0 +1,855 @@
+package redis
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+const (
+	// ttlParam is the storage URI query parameter setting how long records are kept after they are last written
+	ttlParam = "ttl"
+
+	keyPrefix      = "diddht:"
+	recordsIndex   = keyPrefix + "records"
+	recordIDs      = keyPrefix + "record-ids"
+	failedIndex    = keyPrefix + "failed"
+	recordPrefix   = keyPrefix + "record:"
+	failedPrefix   = keyPrefix + "failed:"
+	versionsPrefix = keyPrefix + "versions:"
+	// typePrefix starts the key of each type's index, a sorted set of the IDs of the records of that type with equal
+	// scores
+	typePrefix = keyPrefix + "type:"
+	// metadataPrefix starts the key of each record's metadata, a hash of the times, as Unix milliseconds, the record
+	// was created, updated, last seen, and last republished, and its resolution count
+	metadataPrefix = keyPrefix + "metadata:"
+	// retainedKeys is the set of retained keys
+	retainedKeys = keyPrefix + "retained"
+	// apiKeys is the hash of the API keys, each the JSON of a storedAPIKey, by ID
+	apiKeys = keyPrefix + "api-keys"
+
+	// maxWriteAttempts is how many times a record write is attempted while concurrent writes to the record win
+	maxWriteAttempts = 5
+)
+
+// Redis is a redis-based implementation of storage.Storage, so several stateless gateway replicas can share one
+// store. Each record is stored under its own key, and sorted set indexes of the record and failed record IDs, scored
+// by when each entry expires, back counting and expiry. Records are listed in ID order from a second index of record
+// IDs with equal scores. With a TTL, entries not written again within the TTL expire; expired entries are pruned from
+// the indexes as they are read.
+type Redis struct {
+	client *goredis.Client
+	ttl    time.Duration
+}
+
+// NewRedis creates a redis-based implementation of storage.Storage from a redis:// or rediss:// URI. The optional
+// ttl query parameter, a Go duration such as 720h, sets how long records are kept after they are last written.
+func NewRedis(uri string) (*Redis, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var ttl time.Duration
+	query := u.Query()
+	if rawTTL := query.Get(ttlParam); rawTTL != "" {
+		if ttl, err = time.ParseDuration(rawTTL); err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid redis %s %q: must be a non-negative duration", ttlParam, rawTTL)
+		}
+	}
+	query.Del(ttlParam)
+	u.RawQuery = query.Encode()
+
+	opts, err := goredis.ParseURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	client := goredis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("error connecting to redis: %v", err)
+	}
+	return &Redis{client: client, ttl: ttl}, nil
+}
+
+// WriteRecord writes the given record to the storage, restarting its TTL, moving it to its types' indexes, and
+// updating its metadata, unless a newer record is stored. The stored record is watched, so a concurrent write between comparing and writing
+// retries the write.
+func (r *Redis) WriteRecord(ctx context.Context, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.WriteRecord")
+	defer span.End()
+
+	recordBytes, err := json.Marshal(encodeRecord(record))
+	if err != nil {
+		return err
+	}
+	id := record.ID()
+	write := func(tx *goredis.Tx) error {
+		storedBytes, err := tx.Get(ctx, recordPrefix+id).Bytes()
+		if err != nil && !errors.Is(err, goredis.Nil) {
+			return err
+		}
+		var previousTypes []int
+		if err == nil {
+			stored, err := decodeRecord(storedBytes)
+			if err != nil {
+				return err
+			}
+			if stored.SequenceNumber > record.SequenceNumber {
+				return nil
+			}
+			previousTypes = stored.Types()
+		}
+		types := record.Types()
+		now := time.Now().UnixMilli()
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(ctx, recordPrefix+id, recordBytes, r.ttl)
+			pipe.HSetNX(ctx, metadataPrefix+id, "created", now)
+			pipe.HSet(ctx, metadataPrefix+id, "updated", now, "seen", now)
+			if r.ttl > 0 {
+				pipe.Expire(ctx, metadataPrefix+id, r.ttl)
+			}
+			pipe.ZAdd(ctx, recordsIndex, goredis.Z{Score: r.expiry(), Member: id})
+			pipe.ZAdd(ctx, recordIDs, goredis.Z{Member: id})
+			for _, typ := range previousTypes {
+				if !slices.Contains(types, typ) {
+					pipe.ZRem(ctx, typeIndex(typ), id)
+				}
+			}
+			for _, typ := range types {
+				pipe.ZAdd(ctx, typeIndex(typ), goredis.Z{Member: id})
+			}
+			return nil
+		})
+		return err
+	}
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		if err = r.client.Watch(ctx, write, recordPrefix+id); !errors.Is(err, goredis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// ReadRecord reads the record with the given id from the storage
+func (r *Redis) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ReadRecord")
+	defer span.End()
+
+	recordBytes, err := r.client.Get(ctx, recordPrefix+id).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(recordBytes)
+}
+
+// ReadRecords reads the records with the given IDs in one pipelined round trip
+func (r *Redis) ReadRecords(ctx context.Context, ids []string) (map[string]dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ReadRecords")
+	defer span.End()
+
+	values, err := r.getMany(ctx, recordPrefix, ids)
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]dht.BEP44Record, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		record, err := decodeRecord(value)
+		if err != nil {
+			return nil, err
+		}
+		records[ids[i]] = *record
+	}
+	return records, nil
+}
+
+// ListRecords lists a page of the stored records, ordered by ID. The page token is the ID of the last record listed,
+// so resuming after it neither skips nor repeats records written in between.
+func (r *Redis) ListRecords(ctx context.Context, nextPageToken []byte, pageSize int) ([]dht.BEP44Record, []byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ListRecords")
+	defer span.End()
+
+	start := "-"
+	if len(nextPageToken) > 0 {
+		start = "(" + string(nextPageToken)
+	} else if err := r.prune(ctx, recordsIndex, recordIDs); err != nil {
+		return nil, nil, err
+	}
+	ids, err := r.client.ZRangeByLex(ctx, recordIDs, &goredis.ZRangeBy{Min: start, Max: "+", Count: int64(pageSize)}).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values, err := r.getMany(ctx, recordPrefix, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := make([]dht.BEP44Record, 0, len(values))
+	for i, value := range values {
+		if value == nil {
+			// expired since the index was pruned
+			continue
+		}
+		record, err := decodeRecord(value)
+		if err != nil {
+			logrus.WithContext(ctx).WithError(err).WithField("record_id", ids[i]).Warn("skipping invalid record in redis")
+			continue
+		}
+		records = append(records, *record)
+	}
+
+	if len(ids) < pageSize {
+		return records, nil, nil
+	}
+	return records, []byte(ids[len(ids)-1]), nil
+}
+
+// ListRecordsByType lists a page of the IDs of the records of the query's type, ordered by ID unless the query orders
+// them by update time. The page token is the last ID listed, preceded in update order by its update time. Listing
+// every record in ID order pages through the type's index; otherwise the whole index is read to filter and sort it.
+func (r *Redis) ListRecordsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, pageSize int) ([]string, []byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ListRecordsByType")
+	defer span.End()
+
+	if !query.UpdatedSince.IsZero() || query.Order == dht.OrderUpdated || query.Order == dht.OrderUpdatedDesc {
+		return r.queryRecordsByType(ctx, query, nextPageToken, pageSize)
+	}
+
+	start := "-"
+	if len(nextPageToken) > 0 {
+		start = "(" + string(nextPageToken)
+	}
+	index := typeIndex(query.Type)
+	ids, err := r.client.ZRangeByLex(ctx, index, &goredis.ZRangeBy{Min: start, Max: "+", Count: int64(pageSize)}).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, nil, err
+	}
+
+	pipe := r.client.Pipeline()
+	exists := make([]*goredis.IntCmd, len(ids))
+	for i, id := range ids {
+		exists[i] = pipe.Exists(ctx, recordPrefix+id)
+	}
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+	live := make([]string, 0, len(ids))
+	var expired []any
+	for i, id := range ids {
+		if exists[i].Val() == 0 {
+			expired = append(expired, id)
+			continue
+		}
+		live = append(live, id)
+	}
+	if len(expired) > 0 {
+		if err = r.client.ZRem(ctx, index, expired...).Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(ids) < pageSize {
+		return live, nil, nil
+	}
+	return live, []byte(ids[len(ids)-1]), nil
+}
+
+// updatedRecord is the ID of a record of a type and when it was last written, as Unix milliseconds
+type updatedRecord struct {
+	updated int64
+	id      string
+}
+
+// queryRecordsByType lists a page of the IDs of the records of the query's type by reading the type's whole index,
+// along with when each record was updated
+func (r *Redis) queryRecordsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, pageSize int) ([]string, []byte, error) {
+	byUpdate := query.Order == dht.OrderUpdated || query.Order == dht.OrderUpdatedDesc
+	compare := func(a, b updatedRecord) int {
+		return strings.Compare(a.id, b.id)
+	}
+	switch query.Order {
+	case dht.OrderUpdated:
+		compare = func(a, b updatedRecord) int {
+			return cmp.Or(cmp.Compare(a.updated, b.updated), strings.Compare(a.id, b.id))
+		}
+	case dht.OrderUpdatedDesc:
+		compare = func(a, b updatedRecord) int {
+			return cmp.Or(cmp.Compare(b.updated, a.updated), strings.Compare(b.id, a.id))
+		}
+	}
+	var last updatedRecord
+	if nextPageToken != nil {
+		last.id = string(nextPageToken)
+		if byUpdate {
+			updated, id, ok := strings.Cut(last.id, ":")
+			after, err := strconv.ParseInt(updated, 10, 64)
+			if !ok || err != nil {
+				return nil, nil, dht.ErrInvalidPageToken
+			}
+			last = updatedRecord{updated: after, id: id}
+		}
+	}
+
+	index := typeIndex(query.Type)
+	ids, err := r.client.ZRange(ctx, index, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, nil, err
+	}
+	pipe := r.client.Pipeline()
+	exists := make([]*goredis.IntCmd, len(ids))
+	updated := make([]*goredis.StringCmd, len(ids))
+	for i, id := range ids {
+		exists[i] = pipe.Exists(ctx, recordPrefix+id)
+		updated[i] = pipe.HGet(ctx, metadataPrefix+id, "updated")
+	}
+	if _, err = pipe.Exec(ctx); err != nil && !errors.Is(err, goredis.Nil) {
+		return nil, nil, err
+	}
+
+	since := int64(math.MinInt64)
+	if !query.UpdatedSince.IsZero() {
+		since = query.UpdatedSince.UnixMilli()
+	}
+	records := make([]updatedRecord, 0, len(ids))
+	var expired []any
+	for i, id := range ids {
+		if exists[i].Val() == 0 {
+			expired = append(expired, id)
+			continue
+		}
+		record := updatedRecord{id: id}
+		if record.updated, err = updated[i].Int64(); err != nil && !errors.Is(err, goredis.Nil) {
+			return nil, nil, fmt.Errorf("invalid record metadata updated %q: %v", updated[i].Val(), err)
+		}
+		if record.updated >= since && (nextPageToken == nil || compare(record, last) > 0) {
+			records = append(records, record)
+		}
+	}
+	if len(expired) > 0 {
+		if err = r.client.ZRem(ctx, index, expired...).Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	slices.SortFunc(records, compare)
+	if len(records) <= pageSize {
+		nextPageToken = nil
+	} else {
+		records = records[:pageSize]
+		last = records[len(records)-1]
+		nextPageToken = []byte(last.id)
+		if byUpdate {
+			nextPageToken = []byte(strconv.FormatInt(last.updated, 10) + ":" + last.id)
+		}
+	}
+	live := make([]string, 0, len(records))
+	for _, record := range records {
+		live = append(live, record.id)
+	}
+	return live, nextPageToken, nil
+}
+
+// typeIndex returns the key of the index of the records of the given type
+func typeIndex(typ int) string {
+	return typePrefix + strconv.Itoa(typ)
+}
+
+// RecordCount returns the number of stored records
+func (r *Redis) RecordCount(ctx context.Context) (int, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.RecordCount")
+	defer span.End()
+
+	return r.count(ctx, recordsIndex, recordIDs)
+}
+
+// WriteRecordVersion stores the record in the history of its ID, keyed by sequence number, restarting the TTL of
+// the history
+func (r *Redis) WriteRecordVersion(ctx context.Context, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.WriteRecordVersion")
+	defer span.End()
+
+	recordBytes, err := json.Marshal(encodeRecord(record))
+	if err != nil {
+		return err
+	}
+	key := versionsPrefix + record.ID()
+	_, err = r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HSetNX(ctx, key, strconv.FormatInt(record.SequenceNumber, 10), recordBytes)
+		if r.ttl > 0 {
+			pipe.Expire(ctx, key, r.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// ListRecordVersions returns every stored version of the record with the given ID, oldest first
+func (r *Redis) ListRecordVersions(ctx context.Context, id string) ([]dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ListRecordVersions")
+	defer span.End()
+
+	versions, err := r.client.HVals(ctx, versionsPrefix+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]dht.BEP44Record, 0, len(versions))
+	for _, version := range versions {
+		record, err := decodeRecord([]byte(version))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].SequenceNumber < records[j].SequenceNumber })
+	return records, nil
+}
+
+// WriteFailedRecord counts a failure to republish the record with the given ID, restarting the TTL of its count
+func (r *Redis) WriteFailedRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.WriteFailedRecord")
+	defer span.End()
+
+	_, err := r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Incr(ctx, failedPrefix+id)
+		if r.ttl > 0 {
+			pipe.Expire(ctx, failedPrefix+id, r.ttl)
+		}
+		pipe.ZAdd(ctx, failedIndex, goredis.Z{Score: r.expiry(), Member: id})
+		return nil
+	})
+	return err
+}
+
+// DeleteFailedRecord clears the failure count of the record with the given ID
+func (r *Redis) DeleteFailedRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.DeleteFailedRecord")
+	defer span.End()
+
+	_, err := r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Del(ctx, failedPrefix+id)
+		pipe.ZRem(ctx, failedIndex, id)
+		return nil
+	})
+	return err
+}
+
+// ListFailedRecords returns every record that failed to be republished, with its failure count
+func (r *Redis) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ListFailedRecords")
+	defer span.End()
+
+	if err := r.prune(ctx, failedIndex, ""); err != nil {
+		return nil, err
+	}
+	ids, err := r.client.ZRange(ctx, failedIndex, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := r.getMany(ctx, failedPrefix, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []dht.FailedRecord
+	for i, count := range counts {
+		if count == nil {
+			continue
+		}
+		n, err := strconv.Atoi(string(count))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dht.FailedRecord{ID: ids[i], Count: n})
+	}
+	return result, nil
+}
+
+// FailedRecordCount returns the number of records that failed to be republished
+func (r *Redis) FailedRecordCount(ctx context.Context) (int, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.FailedRecordCount")
+	defer span.End()
+
+	return r.count(ctx, failedIndex, "")
+}
+
+// TouchRecord marks the record with the given ID as seen now and restarts the TTL of the record and its history, if
+// the record is stored
+func (r *Redis) TouchRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.TouchRecord")
+	defer span.End()
+
+	return r.updateMetadata(ctx, []string{id}, func(pipe goredis.Pipeliner, id string) {
+		pipe.HSet(ctx, metadataPrefix+id, "seen", time.Now().UnixMilli())
+		if r.ttl > 0 {
+			pipe.Expire(ctx, recordPrefix+id, r.ttl)
+			pipe.Expire(ctx, versionsPrefix+id, r.ttl)
+			pipe.Expire(ctx, metadataPrefix+id, r.ttl)
+			pipe.ZAddXX(ctx, recordsIndex, goredis.Z{Score: r.expiry(), Member: id})
+		}
+	})
+}
+
+// ReadRecordMetadata returns the metadata of the record with the given ID, or nil if it isn't stored
+func (r *Redis) ReadRecordMetadata(ctx context.Context, id string) (*dht.RecordMetadata, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ReadRecordMetadata")
+	defer span.End()
+
+	pipe := r.client.Pipeline()
+	exists := pipe.Exists(ctx, recordPrefix+id)
+	fields := pipe.HGetAll(ctx, metadataPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	if exists.Val() == 0 {
+		return nil, nil
+	}
+
+	var metadata dht.RecordMetadata
+	for field, value := range fields.Val() {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record metadata %s %q: %v", field, value, err)
+		}
+		switch field {
+		case "created":
+			metadata.Created = time.UnixMilli(n)
+		case "updated":
+			metadata.Updated = time.UnixMilli(n)
+		case "seen":
+			metadata.LastSeen = time.UnixMilli(n)
+		case "republished":
+			republished := time.UnixMilli(n)
+			metadata.LastRepublished = &republished
+		case "resolutions":
+			metadata.Resolutions = n
+		}
+	}
+	return &metadata, nil
+}
+
+// MarkRepublished marks the stored records with the given IDs as republished now
+func (r *Redis) MarkRepublished(ctx context.Context, ids []string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.MarkRepublished")
+	defer span.End()
+
+	now := time.Now().UnixMilli()
+	return r.updateMetadata(ctx, ids, func(pipe goredis.Pipeliner, id string) {
+		pipe.HSet(ctx, metadataPrefix+id, "republished", now)
+	})
+}
+
+// AddResolutions adds to the resolution counts of the stored records with the given IDs
+func (r *Redis) AddResolutions(ctx context.Context, counts map[string]int) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.AddResolutions")
+	defer span.End()
+
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	return r.updateMetadata(ctx, ids, func(pipe goredis.Pipeliner, id string) {
+		pipe.HIncrBy(ctx, metadataPrefix+id, "resolutions", int64(counts[id]))
+	})
+}
+
+// updateMetadata queues update for each of the records with the given IDs that is stored, and runs the updates in
+// one transaction. The metadata of each record is then set to expire with the record, in case updating created it.
+func (r *Redis) updateMetadata(ctx context.Context, ids []string, update func(pipe goredis.Pipeliner, id string)) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	ttls := make([]*goredis.DurationCmd, len(ids))
+	for i, id := range ids {
+		ttls[i] = pipe.PTTL(ctx, recordPrefix+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	_, err := r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, id := range ids {
+			// a missing key has a TTL of -2, and a key that never expires -1
+			ttl := ttls[i].Val()
+			if ttl == -2 {
+				continue
+			}
+			update(pipe, id)
+			if ttl > 0 {
+				pipe.PExpire(ctx, metadataPrefix+id, ttl)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// DeleteStaleRecords deletes nothing: redis expires records itself, once they go unseen for the storage URI's TTL
+func (r *Redis) DeleteStaleRecords(ctx context.Context, _ time.Time, _ [][]byte) (int, error) {
+	_, span := telemetry.GetTracer().Start(ctx, "redis.DeleteStaleRecords")
+	defer span.End()
+
+	return 0, nil
+}
+
+// DeleteRecord deletes the record with the given ID, with its history, metadata, failure count, and index entries.
+// The stored record is watched, so a concurrent write between reading its types and deleting it retries the delete.
+func (r *Redis) DeleteRecord(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.DeleteRecord")
+	defer span.End()
+
+	var stored bool
+	del := func(tx *goredis.Tx) error {
+		storedBytes, err := tx.Get(ctx, recordPrefix+id).Bytes()
+		if errors.Is(err, goredis.Nil) {
+			stored = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		record, err := decodeRecord(storedBytes)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Del(ctx, recordPrefix+id, versionsPrefix+id, metadataPrefix+id, failedPrefix+id)
+			pipe.ZRem(ctx, recordsIndex, id)
+			pipe.ZRem(ctx, recordIDs, id)
+			pipe.ZRem(ctx, failedIndex, id)
+			for _, typ := range record.Types() {
+				pipe.ZRem(ctx, typeIndex(typ), id)
+			}
+			return nil
+		})
+		stored = err == nil
+		return err
+	}
+	var err error
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		if err = r.client.Watch(ctx, del, recordPrefix+id); !errors.Is(err, goredis.TxFailedErr) {
+			return stored, err
+		}
+	}
+	return false, err
+}
+
+// WriteRetainedKey marks the records under the given key as retained. Redis still expires retained records once
+// they go unseen for the storage URI's TTL.
+func (r *Redis) WriteRetainedKey(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.WriteRetainedKey")
+	defer span.End()
+
+	return r.client.SAdd(ctx, retainedKeys, k).Err()
+}
+
+// DeleteRetainedKey stops retaining the records under the given key
+func (r *Redis) DeleteRetainedKey(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.DeleteRetainedKey")
+	defer span.End()
+
+	return r.client.SRem(ctx, retainedKeys, k).Err()
+}
+
+// ListRetainedKeys returns every retained key in sorted order
+func (r *Redis) ListRetainedKeys(ctx context.Context) ([][]byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ListRetainedKeys")
+	defer span.End()
+
+	members, err := r.client.SMembers(ctx, retainedKeys).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(members)
+	keys := make([][]byte, 0, len(members))
+	for _, member := range members {
+		keys = append(keys, []byte(member))
+	}
+	return keys, nil
+}
+
+// storedAPIKey is an API key as stored, with the hash its JSON leaves out
+type storedAPIKey struct {
+	dht.APIKey
+	Hash []byte `json:"hash"`
+}
+
+// WriteAPIKey stores the API key, replacing the stored key with its ID, if any
+func (r *Redis) WriteAPIKey(ctx context.Context, key dht.APIKey) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.WriteAPIKey")
+	defer span.End()
+
+	keyBytes, err := json.Marshal(storedAPIKey{APIKey: key, Hash: key.Hash})
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, apiKeys, key.ID, keyBytes).Err()
+}
+
+// ReadAPIKey returns the API key with the given ID, or nil if there is none
+func (r *Redis) ReadAPIKey(ctx context.Context, id string) (*dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ReadAPIKey")
+	defer span.End()
+
+	keyBytes, err := r.client.HGet(ctx, apiKeys, id).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := unmarshalAPIKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns every API key in ID order
+func (r *Redis) ListAPIKeys(ctx context.Context) ([]dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.ListAPIKeys")
+	defer span.End()
+
+	stored, err := r.client.HGetAll(ctx, apiKeys).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]dht.APIKey, 0, len(stored))
+	for _, keyJSON := range stored {
+		key, err := unmarshalAPIKey([]byte(keyJSON))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b dht.APIKey) int { return cmp.Compare(a.ID, b.ID) })
+	return keys, nil
+}
+
+// DeleteAPIKey deletes the API key with the given ID, returning whether it was stored
+func (r *Redis) DeleteAPIKey(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "redis.DeleteAPIKey")
+	defer span.End()
+
+	deleted, err := r.client.HDel(ctx, apiKeys, id).Result()
+	return deleted > 0, err
+}
+
+func unmarshalAPIKey(keyBytes []byte) (dht.APIKey, error) {
+	var stored storedAPIKey
+	if err := json.Unmarshal(keyBytes, &stored); err != nil {
+		return dht.APIKey{}, fmt.Errorf("error parsing api key: %v", err)
+	}
+	stored.APIKey.Hash = stored.Hash
+	return stored.APIKey, nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// expiry returns the index score of an entry written now: the Unix time it expires at, or +inf without a TTL
+func (r *Redis) expiry() float64 {
+	if r.ttl <= 0 {
+		return math.Inf(1)
+	}
+	return float64(time.Now().Add(r.ttl).Unix())
+}
+
+// prune removes the entries that have expired from the given expiry index, and from the given ID index if any
+func (r *Redis) prune(ctx context.Context, index, ids string) error {
+	err := r.client.Watch(ctx, func(tx *goredis.Tx) error {
+		expired, err := tx.ZRangeByScore(ctx, index, &goredis.ZRangeBy{
+			Min: "-inf",
+			Max: "(" + strconv.FormatInt(time.Now().Unix(), 10),
+		}).Result()
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+
+		members := make([]any, len(expired))
+		for i, id := range expired {
+			members[i] = id
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.ZRem(ctx, index, members...)
+			if ids != "" {
+				pipe.ZRem(ctx, ids, members...)
+			}
+			return nil
+		})
+		return err
+	}, index)
+	// an entry was written again while pruning; whatever is still expired is pruned on the next read
+	if errors.Is(err, goredis.TxFailedErr) {
+		return nil
+	}
+	return err
+}
+
+// count prunes the given indexes and returns the number of entries left in the expiry index
+func (r *Redis) count(ctx context.Context, index, ids string) (int, error) {
+	if err := r.prune(ctx, index, ids); err != nil {
+		return 0, err
+	}
+	n, err := r.client.ZCard(ctx, index).Result()
+	return int(n), err
+}
+
+// getMany reads the values of the given IDs under the given key prefix in one pipelined round trip. The value of an
+// ID with no key is nil.
+func (r *Redis) getMany(ctx context.Context, prefix string, ids []string) ([][]byte, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	pipe := r.client.Pipeline()
+	cmds := make([]*goredis.StringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Get(ctx, prefix+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, goredis.Nil) {
+		return nil, err
+	}
+
+	values := make([][]byte, len(ids))
+	for i, cmd := range cmds {
+		value, err := cmd.Bytes()
+		if errors.Is(err, goredis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func decodeRecord(recordBytes []byte) (*dht.BEP44Record, error) {
+	var encoded base64BEP44Record
+	if err := json.Unmarshal(recordBytes, &encoded); err != nil {
+		return nil, err
+	}
+	return encoded.Decode()
+}
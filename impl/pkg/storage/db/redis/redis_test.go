@@ -0,0 +1,248 @@
+package redis_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+	"github.com/TBD54566975/did-dht/pkg/storage/db/redis"
+	"github.com/TBD54566975/did-dht/pkg/storage/storagetest"
+)
+
+// getTestDB connects to the redis server at TEST_REDIS, with the ttl query parameter set if ttl is non-empty
+func getTestDB(t *testing.T, ttl string) *redis.Redis {
+	uri := os.Getenv("TEST_REDIS")
+	if uri == "" {
+		t.SkipNow()
+	}
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	if ttl != "" {
+		query := u.Query()
+		query.Set("ttl", ttl)
+		u.RawQuery = query.Encode()
+	}
+
+	db, err := redis.NewRedis(u.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestRecord(t *testing.T) dht.BEP44Record {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	return dht.RecordFromBEP44(putMsg)
+}
+
+func TestReadWrite(t *testing.T) {
+	db := getTestDB(t, "")
+	ctx := context.Background()
+
+	beforeCnt, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+
+	r := newTestRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, r))
+
+	r2, err := db.ReadRecord(ctx, r.ID())
+	require.NoError(t, err)
+	assert.Equal(t, r.Key, r2.Key)
+	assert.Equal(t, r.Value, r2.Value)
+	assert.Equal(t, r.Signature, r2.Signature)
+	assert.Equal(t, r.SequenceNumber, r2.SequenceNumber)
+
+	missing, err := db.ReadRecord(ctx, newTestRecord(t).ID())
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	afterCnt, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, beforeCnt+1, afterCnt)
+}
+
+func TestReadRecords(t *testing.T) {
+	db := getTestDB(t, "")
+	ctx := context.Background()
+
+	r1, r2, missing := newTestRecord(t), newTestRecord(t), newTestRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, r1))
+	require.NoError(t, db.WriteRecord(ctx, r2))
+
+	records, err := db.ReadRecords(ctx, []string{r1.ID(), missing.ID(), r2.ID()})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, r1.Signature, records[r1.ID()].Signature)
+	assert.Equal(t, r2.Signature, records[r2.ID()].Signature)
+}
+
+func TestDBPagination(t *testing.T) {
+	db := getTestDB(t, "")
+	ctx := context.Background()
+
+	beforeCnt, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+
+	written := make(map[string]bool)
+	for i := 0; i < 25; i++ {
+		r := newTestRecord(t)
+		require.NoError(t, db.WriteRecord(ctx, r))
+		written[r.ID()] = true
+	}
+
+	// pages may vary in size, but every record is listed
+	listed := make(map[string]bool)
+	var nextPageToken []byte
+	for {
+		page, next, err := db.ListRecords(ctx, nextPageToken, 10)
+		require.NoError(t, err)
+		for _, r := range page {
+			listed[r.ID()] = true
+		}
+		if next == nil {
+			break
+		}
+		nextPageToken = next
+	}
+	for id := range written {
+		assert.True(t, listed[id], "record %s not listed", id)
+	}
+
+	afterCnt, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, beforeCnt+25, afterCnt)
+}
+
+func TestDBPaginationConcurrentWrites(t *testing.T) {
+	db := getTestDB(t, "")
+	ctx := context.Background()
+
+	original := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		r := newTestRecord(t)
+		require.NoError(t, db.WriteRecord(ctx, r))
+		original[r.ID()] = true
+	}
+
+	listed := make(map[string]int)
+	var nextPageToken []byte
+	for first := true; first || nextPageToken != nil; first = false {
+		page, next, err := db.ListRecords(ctx, nextPageToken, 5)
+		require.NoError(t, err)
+		for _, r := range page {
+			listed[r.ID()]++
+		}
+		nextPageToken = next
+
+		// records written mid-scan are listed at most once
+		require.NoError(t, db.WriteRecord(ctx, newTestRecord(t)))
+	}
+
+	for id, n := range listed {
+		assert.Equal(t, 1, n, "record %s listed %d times", id, n)
+	}
+	for id := range original {
+		assert.Contains(t, listed, id)
+	}
+}
+
+func TestRecordVersions(t *testing.T) {
+	db := getTestDB(t, "")
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+
+	// write versions out of order, including a duplicate
+	for _, offset := range []int64{2, 0, 1, 0} {
+		version := *putMsg
+		version.Seq += offset
+		version.Sign(sk)
+		require.NoError(t, db.WriteRecordVersion(ctx, dht.RecordFromBEP44(&version)))
+	}
+
+	versions, err := db.ListRecordVersions(ctx, dht.RecordFromBEP44(putMsg).ID())
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, version := range versions {
+		assert.Equal(t, putMsg.Seq+int64(i), version.SequenceNumber)
+	}
+}
+
+func TestFailedRecords(t *testing.T) {
+	db := getTestDB(t, "")
+	ctx := context.Background()
+
+	beforeCnt, err := db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+
+	id := newTestRecord(t).ID()
+	require.NoError(t, db.WriteFailedRecord(ctx, id))
+	require.NoError(t, db.WriteFailedRecord(ctx, id))
+
+	failed, err := db.ListFailedRecords(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, failed, dht.FailedRecord{ID: id, Count: 2})
+
+	afterCnt, err := db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, beforeCnt+1, afterCnt)
+}
+
+func TestTTL(t *testing.T) {
+	db := getTestDB(t, "1s")
+	ctx := context.Background()
+
+	beforeCnt, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+
+	r := newTestRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, r))
+	require.NoError(t, db.WriteFailedRecord(ctx, r.ID()))
+
+	// the index entries expire along with the keys
+	assert.Eventually(t, func() bool {
+		got, err := db.ReadRecord(ctx, r.ID())
+		if err != nil || got != nil {
+			return false
+		}
+		afterCnt, err := db.RecordCount(ctx)
+		return err == nil && afterCnt == beforeCnt
+	}, 5*time.Second, 100*time.Millisecond)
+
+	failed, err := db.ListFailedRecords(ctx)
+	require.NoError(t, err)
+	for _, f := range failed {
+		assert.NotEqual(t, r.ID(), f.ID)
+	}
+}
+
+func TestNewRedisInvalidTTL(t *testing.T) {
+	_, err := redis.NewRedis("redis://localhost:6379?ttl=forever")
+	assert.ErrorContains(t, err, `invalid redis ttl "forever"`)
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return getTestDB(t, "")
+	})
+}
@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE dht_records (
+    id INTEGER PRIMARY KEY,
+    key BLOB NOT NULL,
+    value BLOB NOT NULL,
+    sig BLOB NOT NULL,
+    seq INTEGER NOT NULL,
+    salt BLOB NOT NULL DEFAULT x'',
+    last_seen INTEGER NOT NULL,
+    UNIQUE (key, salt)
+);
+CREATE INDEX dht_records_last_seen_idx ON dht_records (last_seen);
+
+CREATE TABLE dht_record_versions (
+    key BLOB NOT NULL,
+    salt BLOB NOT NULL DEFAULT x'',
+    seq INTEGER NOT NULL,
+    value BLOB NOT NULL,
+    sig BLOB NOT NULL,
+    PRIMARY KEY (key, salt, seq)
+);
+
+CREATE TABLE failed_records (
+    id BLOB PRIMARY KEY,
+    failure_count INTEGER NOT NULL
+);
+
+-- +goose Down
+DROP TABLE failed_records;
+DROP TABLE dht_record_versions;
+DROP INDEX dht_records_last_seen_idx;
+DROP TABLE dht_records;
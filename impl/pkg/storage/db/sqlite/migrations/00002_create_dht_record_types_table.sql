@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE dht_record_types (
+    type INTEGER NOT NULL,
+    record_id INTEGER NOT NULL REFERENCES dht_records (id) ON DELETE CASCADE,
+    PRIMARY KEY (type, record_id)
+);
+CREATE INDEX dht_record_types_record_id_idx ON dht_record_types (record_id);
+
+-- +goose Down
+DROP INDEX dht_record_types_record_id_idx;
+DROP TABLE dht_record_types;
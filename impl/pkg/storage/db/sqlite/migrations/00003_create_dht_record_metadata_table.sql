@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE dht_record_metadata (
+    record_id INTEGER PRIMARY KEY REFERENCES dht_records (id) ON DELETE CASCADE,
+    created INTEGER NOT NULL,
+    updated INTEGER NOT NULL,
+    last_republished INTEGER,
+    resolutions INTEGER NOT NULL DEFAULT 0
+);
+-- records stored before metadata was kept are dated from when they were last seen
+INSERT INTO dht_record_metadata (record_id, created, updated) SELECT id, last_seen, last_seen FROM dht_records;
+
+-- +goose Down
+DROP TABLE dht_record_metadata;
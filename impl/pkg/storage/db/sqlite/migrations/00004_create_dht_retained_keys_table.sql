@@ -0,0 +1,7 @@
+-- +goose Up
+CREATE TABLE dht_retained_keys (
+    key BLOB PRIMARY KEY
+);
+
+-- +goose Down
+DROP TABLE dht_retained_keys;
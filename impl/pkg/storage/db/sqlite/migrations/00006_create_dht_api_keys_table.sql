@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE dht_api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    hash BLOB NOT NULL,
+    requests_per_second REAL NOT NULL,
+    burst INTEGER NOT NULL,
+    publishes_per_day INTEGER NOT NULL,
+    created INTEGER NOT NULL
+);
+
+-- +goose Down
+DROP TABLE dht_api_keys;
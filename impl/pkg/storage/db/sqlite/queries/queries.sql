@@ -0,0 +1,129 @@
+-- name: WriteRecord :one
+INSERT INTO dht_records(key, value, sig, seq, salt, last_seen) VALUES(?, ?, ?, ?, ?, ?)
+ON CONFLICT (key, salt) DO UPDATE SET value = excluded.value, sig = excluded.sig, seq = excluded.seq, last_seen = excluded.last_seen
+WHERE dht_records.seq <= excluded.seq
+RETURNING id;
+
+-- name: DeleteRecordTypes :exec
+DELETE FROM dht_record_types WHERE record_id = ?;
+
+-- name: WriteRecordType :exec
+INSERT INTO dht_record_types(type, record_id) VALUES(?, ?)
+ON CONFLICT (type, record_id) DO NOTHING;
+
+-- name: WriteRecordMetadata :exec
+INSERT INTO dht_record_metadata(record_id, created, updated) VALUES(?, ?, ?)
+ON CONFLICT (record_id) DO UPDATE SET updated = excluded.updated;
+
+-- name: ReadRecord :one
+SELECT * FROM dht_records WHERE key = ? AND salt = ? LIMIT 1;
+
+-- name: ReadRecords :many
+SELECT * FROM dht_records WHERE key IN (sqlc.slice('keys')) AND salt IN (sqlc.slice('salts'));
+
+-- name: ListRecords :many
+SELECT * FROM dht_records WHERE (key, salt) > (sqlc.arg(key), sqlc.arg(salt)) ORDER BY key ASC, salt ASC LIMIT sqlc.arg('limit');
+
+-- name: ListRecordsFirstPage :many
+SELECT * FROM dht_records ORDER BY key ASC, salt ASC LIMIT ?;
+
+-- name: ListRecordsByType :many
+SELECT r.id, r.key, r.salt FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = sqlc.arg(type) AND t.record_id > sqlc.arg(after) AND m.updated >= sqlc.arg(updated_since)
+ORDER BY t.record_id ASC LIMIT sqlc.arg('limit');
+
+-- name: ListRecordsByTypeUpdated :many
+SELECT r.id, r.key, r.salt, m.updated FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = sqlc.arg(type) AND m.updated >= sqlc.arg(updated_since)
+AND (m.updated, r.id) > (sqlc.arg(after_updated), sqlc.arg(after_id))
+ORDER BY m.updated ASC, r.id ASC LIMIT sqlc.arg('limit');
+
+-- name: ListRecordsByTypeUpdatedDesc :many
+SELECT r.id, r.key, r.salt, m.updated FROM dht_record_types t
+JOIN dht_records r ON r.id = t.record_id JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE t.type = sqlc.arg(type) AND m.updated >= sqlc.arg(updated_since)
+AND (m.updated, r.id) < (sqlc.arg(before_updated), sqlc.arg(before_id))
+ORDER BY m.updated DESC, r.id DESC LIMIT sqlc.arg('limit');
+
+-- name: TouchRecord :exec
+UPDATE dht_records SET last_seen = ? WHERE key = ? AND salt = ?;
+
+-- name: ReadRecordMetadata :one
+SELECT m.created, m.updated, r.last_seen, m.last_republished, m.resolutions
+FROM dht_records r JOIN dht_record_metadata m ON m.record_id = r.id
+WHERE r.key = ? AND r.salt = ?;
+
+-- name: MarkRepublished :exec
+UPDATE dht_record_metadata SET last_republished = sqlc.arg(republished)
+WHERE record_id = (SELECT id FROM dht_records WHERE key = sqlc.arg(key) AND salt = sqlc.arg(salt));
+
+-- name: AddResolutions :exec
+UPDATE dht_record_metadata SET resolutions = resolutions + sqlc.arg(count)
+WHERE record_id = (SELECT id FROM dht_records WHERE key = sqlc.arg(key) AND salt = sqlc.arg(salt));
+
+-- name: DeleteStaleRecords :many
+DELETE FROM dht_records WHERE id IN (
+    SELECT id FROM dht_records
+    WHERE last_seen < sqlc.arg(before) AND key NOT IN (sqlc.slice('retained'))
+    LIMIT sqlc.arg('limit')
+) RETURNING key, salt;
+
+-- name: DeleteRecord :one
+DELETE FROM dht_records WHERE key = ? AND salt = ? RETURNING id;
+
+-- name: DeleteRecordVersions :exec
+DELETE FROM dht_record_versions WHERE key = ? AND salt = ?;
+
+-- name: WriteRecordVersion :exec
+INSERT INTO dht_record_versions(key, salt, seq, value, sig) VALUES(?, ?, ?, ?, ?)
+ON CONFLICT (key, salt, seq) DO NOTHING;
+
+-- name: ListRecordVersions :many
+SELECT * FROM dht_record_versions WHERE key = ? AND salt = ? ORDER BY seq ASC;
+
+-- name: RecordCount :one
+SELECT count(*) AS exact_count FROM dht_records;
+
+-- name: WriteFailedRecord :exec
+INSERT INTO failed_records(id, failure_count)
+VALUES(?, ?)
+ON CONFLICT (id) DO UPDATE SET failure_count = failed_records.failure_count + 1;
+
+-- name: ListFailedRecords :many
+SELECT * FROM failed_records;
+
+-- name: DeleteFailedRecords :exec
+DELETE FROM failed_records WHERE id IN (sqlc.slice('ids'));
+
+-- name: FailedRecordCount :one
+SELECT count(*) AS exact_count FROM failed_records;
+
+-- name: WriteRetainedKey :exec
+INSERT INTO dht_retained_keys(key) VALUES(?) ON CONFLICT DO NOTHING;
+
+-- name: DeleteRetainedKey :exec
+DELETE FROM dht_retained_keys WHERE key = ?;
+
+-- name: ListRetainedKeys :many
+SELECT key FROM dht_retained_keys ORDER BY key ASC;
+
+-- name: WriteAPIKey :exec
+INSERT INTO dht_api_keys(id, name, hash, requests_per_second, burst, publishes_per_day, created)
+VALUES(?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+    name = excluded.name,
+    hash = excluded.hash,
+    requests_per_second = excluded.requests_per_second,
+    burst = excluded.burst,
+    publishes_per_day = excluded.publishes_per_day;
+
+-- name: ReadAPIKey :one
+SELECT * FROM dht_api_keys WHERE id = ?;
+
+-- name: ListAPIKeys :many
+SELECT * FROM dht_api_keys ORDER BY id ASC;
+
+-- name: DeleteAPIKey :execrows
+DELETE FROM dht_api_keys WHERE id = ?;
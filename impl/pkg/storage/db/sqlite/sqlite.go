@@ -0,0 +1,715 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+const (
+	// busyTimeout is how long a connection waits for another connection's write to finish before failing
+	busyTimeout = 5 * time.Second
+
+	// deleteBatchSize is the most stale records deleted in one transaction
+	deleteBatchSize = 1000
+)
+
+// SQLite is an SQLite-based implementation of storage.Storage, for single-node gateways that want to query their
+// records with SQL. The schema mirrors the postgres backend's.
+type SQLite struct {
+	db      *sql.DB
+	queries *Queries
+}
+
+// NewSQLite opens the SQLite database in the given file, creating it and migrating the schema as needed. The
+// database is opened in WAL mode so reads don't block on writes, and transactions take the write lock up front.
+// Foreign keys are enforced, so deleting a record deletes its type index entries.
+func NewSQLite(path string) (*SQLite, error) {
+	if path == "" {
+		return nil, errors.New("sqlite database path is required")
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate",
+		path, busyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = migrate(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error migrating sqlite database: %v", err)
+	}
+	return &SQLite{db: db, queries: New(db)}, nil
+}
+
+func migrate(db *sql.DB) error {
+	goose.SetBaseFS(migrations)
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		return err
+	}
+
+	if err := goose.Up(db, "migrations"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteRecord writes the record, replaces its types in the type index, and updates its metadata in one transaction
+func (s *SQLite) WriteRecord(ctx context.Context, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.WriteRecord")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	queries := s.queries.WithTx(tx)
+
+	now := time.Now().UnixMilli()
+	id, err := queries.WriteRecord(ctx, WriteRecordParams{
+		Key:      record.Key[:],
+		Value:    record.Value[:],
+		Sig:      record.Signature[:],
+		Seq:      record.SequenceNumber,
+		Salt:     saltOrEmpty(record.Salt),
+		LastSeen: now,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// a newer record is stored
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = queries.DeleteRecordTypes(ctx, id); err != nil {
+		return err
+	}
+	for _, typ := range record.Types() {
+		if err = queries.WriteRecordType(ctx, WriteRecordTypeParams{Type: int64(typ), RecordID: id}); err != nil {
+			return err
+		}
+	}
+	if err = queries.WriteRecordMetadata(ctx, WriteRecordMetadataParams{RecordID: id, Created: now, Updated: now}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ReadRecord")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return nil, err
+	}
+	row, err := s.queries.ReadRecord(ctx, ReadRecordParams{Key: key, Salt: saltOrEmpty(salt)})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.Record()
+}
+
+// ReadRecords reads the records with the given IDs in a single query. Without array parameters the query matches
+// keys and salts separately, so rows pairing a requested key with another requested key's salt are dropped here.
+func (s *SQLite) ReadRecords(ctx context.Context, ids []string) (map[string]dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ReadRecords")
+	defer span.End()
+
+	if len(ids) == 0 {
+		return map[string]dht.BEP44Record{}, nil
+	}
+	requested := make(map[string]bool, len(ids))
+	params := ReadRecordsParams{Keys: make([][]byte, 0, len(ids)), Salts: make([][]byte, 0, len(ids))}
+	for _, id := range ids {
+		key, salt, err := dht.ParseRecordID(id)
+		if err != nil {
+			return nil, err
+		}
+		requested[dht.RecordID(key, salt)] = true
+		params.Keys = append(params.Keys, key)
+		params.Salts = append(params.Salts, saltOrEmpty(salt))
+	}
+	rows, err := s.queries.ReadRecords(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]dht.BEP44Record, len(ids))
+	for _, row := range rows {
+		record, err := row.Record()
+		if err != nil {
+			return nil, err
+		}
+		if id := record.ID(); requested[id] {
+			records[id] = *record
+		}
+	}
+	return records, nil
+}
+
+func (s *SQLite) ListRecords(ctx context.Context, nextPageToken []byte, limit int) ([]dht.BEP44Record, []byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ListRecords")
+	defer span.End()
+
+	// the page token is the key of the last record followed by its salt, as in the postgres backend
+	if nextPageToken != nil && len(nextPageToken) < 32 {
+		return nil, nil, fmt.Errorf("invalid page token")
+	}
+	var rows []DhtRecord
+	var err error
+	if nextPageToken == nil {
+		rows, err = s.queries.ListRecordsFirstPage(ctx, int64(limit))
+	} else {
+		rows, err = s.queries.ListRecords(ctx, ListRecordsParams{
+			Key:   nextPageToken[:32],
+			Salt:  nextPageToken[32:],
+			Limit: int64(limit),
+		})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var records []dht.BEP44Record
+	for _, row := range rows {
+		record, err := row.Record()
+		if err != nil {
+			logrus.WithContext(ctx).WithError(err).WithField("record_id", row.ID).Warn("error loading record from database, skipping")
+			continue
+		}
+		records = append(records, *record)
+	}
+
+	if len(rows) == limit {
+		last := rows[len(rows)-1]
+		nextPageToken = append(bytes.Clone(last.Key), last.Salt...)
+	} else {
+		nextPageToken = nil
+	}
+
+	return records, nextPageToken, nil
+}
+
+// ListRecordsByType lists a page of the IDs of the records of the query's type, in the order they were first stored
+// unless the query orders them by update time. The page token is the database ID of the last record listed, preceded
+// in update order by its update time.
+func (s *SQLite) ListRecordsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, limit int) ([]string, []byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ListRecordsByType")
+	defer span.End()
+
+	since := int64(math.MinInt64)
+	if !query.UpdatedSince.IsZero() {
+		since = query.UpdatedSince.UnixMilli()
+	}
+	if query.Order == dht.OrderUpdated || query.Order == dht.OrderUpdatedDesc {
+		return s.listRecordsByTypeUpdate(ctx, query, since, nextPageToken, limit)
+	}
+
+	var after int64
+	if nextPageToken != nil {
+		var err error
+		if after, err = strconv.ParseInt(string(nextPageToken), 10, 64); err != nil {
+			return nil, nil, dht.ErrInvalidPageToken
+		}
+	}
+	rows, err := s.queries.ListRecordsByType(ctx, ListRecordsByTypeParams{
+		Type:         int64(query.Type),
+		After:        after,
+		UpdatedSince: since,
+		Limit:        int64(limit),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, dht.RecordID(row.Key, row.Salt))
+	}
+
+	if len(rows) == limit {
+		nextPageToken = strconv.AppendInt(nil, rows[len(rows)-1].ID, 10)
+	} else {
+		nextPageToken = nil
+	}
+	return ids, nextPageToken, nil
+}
+
+// listRecordsByTypeUpdate lists a page of the IDs of the records of the query's type updated since the given Unix
+// milliseconds, in update order
+func (s *SQLite) listRecordsByTypeUpdate(ctx context.Context, query dht.TypeQuery, since int64, nextPageToken []byte, limit int) ([]string, []byte, error) {
+	var rows []ListRecordsByTypeUpdatedRow
+	var err error
+	if query.Order == dht.OrderUpdated {
+		params := ListRecordsByTypeUpdatedParams{Type: int64(query.Type), UpdatedSince: since, AfterUpdated: math.MinInt64, Limit: int64(limit)}
+		if nextPageToken != nil {
+			if params.AfterUpdated, params.AfterID, err = parseUpdateToken(nextPageToken); err != nil {
+				return nil, nil, err
+			}
+		}
+		rows, err = s.queries.ListRecordsByTypeUpdated(ctx, params)
+	} else {
+		params := ListRecordsByTypeUpdatedDescParams{Type: int64(query.Type), UpdatedSince: since, BeforeUpdated: math.MaxInt64, BeforeID: math.MaxInt64, Limit: int64(limit)}
+		if nextPageToken != nil {
+			if params.BeforeUpdated, params.BeforeID, err = parseUpdateToken(nextPageToken); err != nil {
+				return nil, nil, err
+			}
+		}
+		var desc []ListRecordsByTypeUpdatedDescRow
+		desc, err = s.queries.ListRecordsByTypeUpdatedDesc(ctx, params)
+		for _, row := range desc {
+			rows = append(rows, ListRecordsByTypeUpdatedRow(row))
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, dht.RecordID(row.Key, row.Salt))
+	}
+
+	if len(rows) == limit {
+		last := rows[len(rows)-1]
+		nextPageToken = fmt.Appendf(nil, "%d:%d", last.Updated, last.ID)
+	} else {
+		nextPageToken = nil
+	}
+	return ids, nextPageToken, nil
+}
+
+// parseUpdateToken parses the update time and database ID of a page token of a listing in update order
+func parseUpdateToken(token []byte) (updated, id int64, err error) {
+	rawUpdated, rawID, ok := strings.Cut(string(token), ":")
+	if !ok {
+		return 0, 0, dht.ErrInvalidPageToken
+	}
+	if updated, err = strconv.ParseInt(rawUpdated, 10, 64); err != nil {
+		return 0, 0, dht.ErrInvalidPageToken
+	}
+	if id, err = strconv.ParseInt(rawID, 10, 64); err != nil {
+		return 0, 0, dht.ErrInvalidPageToken
+	}
+	return updated, id, nil
+}
+
+func (s *SQLite) WriteRecordVersion(ctx context.Context, record dht.BEP44Record) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.WriteRecordVersion")
+	defer span.End()
+
+	return s.queries.WriteRecordVersion(ctx, WriteRecordVersionParams{
+		Key:   record.Key[:],
+		Salt:  saltOrEmpty(record.Salt),
+		Seq:   record.SequenceNumber,
+		Value: record.Value[:],
+		Sig:   record.Signature[:],
+	})
+}
+
+func (s *SQLite) ListRecordVersions(ctx context.Context, id string) ([]dht.BEP44Record, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ListRecordVersions")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.queries.ListRecordVersions(ctx, ListRecordVersionsParams{Key: key, Salt: saltOrEmpty(salt)})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []dht.BEP44Record
+	for _, row := range rows {
+		record, err := dht.NewSaltedBEP44Record(row.Key, row.Value, row.Sig, row.Salt, row.Seq)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (row DhtRecord) Record() (*dht.BEP44Record, error) {
+	return dht.NewSaltedBEP44Record(row.Key, row.Value, row.Sig, row.Salt, row.Seq)
+}
+
+// saltOrEmpty returns the salt, or an empty salt for unsalted records since the salt column is not nullable
+func saltOrEmpty(salt []byte) []byte {
+	if salt == nil {
+		return []byte{}
+	}
+	return salt
+}
+
+func (s *SQLite) RecordCount(ctx context.Context) (int, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.RecordCount")
+	defer span.End()
+
+	count, err := s.queries.RecordCount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (s *SQLite) WriteFailedRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.WriteFailedRecord")
+	defer span.End()
+
+	return s.queries.WriteFailedRecord(ctx, WriteFailedRecordParams{
+		ID:           []byte(id),
+		FailureCount: 1,
+	})
+}
+
+// DeleteFailedRecord clears the failure count of the record with the given ID
+func (s *SQLite) DeleteFailedRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.DeleteFailedRecord")
+	defer span.End()
+
+	return s.queries.DeleteFailedRecords(ctx, [][]byte{[]byte(id)})
+}
+
+func (s *SQLite) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ListFailedRecords")
+	defer span.End()
+
+	rows, err := s.queries.ListFailedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var failedRecords []dht.FailedRecord
+	for _, row := range rows {
+		failedRecords = append(failedRecords, dht.FailedRecord{
+			ID:    string(row.ID),
+			Count: int(row.FailureCount),
+		})
+	}
+	return failedRecords, nil
+}
+
+func (s *SQLite) FailedRecordCount(ctx context.Context) (int, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.FailedRecordCount")
+	defer span.End()
+
+	count, err := s.queries.FailedRecordCount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// TouchRecord marks the record with the given ID as seen now, if it is stored
+func (s *SQLite) TouchRecord(ctx context.Context, id string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.TouchRecord")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return err
+	}
+	return s.queries.TouchRecord(ctx, TouchRecordParams{
+		LastSeen: time.Now().UnixMilli(),
+		Key:      key,
+		Salt:     saltOrEmpty(salt),
+	})
+}
+
+// ReadRecordMetadata returns the metadata of the record with the given ID, or nil if it isn't stored
+func (s *SQLite) ReadRecordMetadata(ctx context.Context, id string) (*dht.RecordMetadata, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ReadRecordMetadata")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return nil, err
+	}
+	row, err := s.queries.ReadRecordMetadata(ctx, ReadRecordMetadataParams{Key: key, Salt: saltOrEmpty(salt)})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := dht.RecordMetadata{
+		Created:     time.UnixMilli(row.Created),
+		Updated:     time.UnixMilli(row.Updated),
+		LastSeen:    time.UnixMilli(row.LastSeen),
+		Resolutions: row.Resolutions,
+	}
+	if row.LastRepublished.Valid {
+		republished := time.UnixMilli(row.LastRepublished.Int64)
+		metadata.LastRepublished = &republished
+	}
+	return &metadata, nil
+}
+
+// MarkRepublished marks the stored records with the given IDs as republished now, in one transaction
+func (s *SQLite) MarkRepublished(ctx context.Context, ids []string) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.MarkRepublished")
+	defer span.End()
+
+	republished := sql.NullInt64{Int64: time.Now().UnixMilli(), Valid: true}
+	return s.updateMetadata(ctx, ids, func(queries *Queries, _ string, key, salt []byte) error {
+		return queries.MarkRepublished(ctx, MarkRepublishedParams{Republished: republished, Key: key, Salt: salt})
+	})
+}
+
+// AddResolutions adds to the resolution counts of the stored records with the given IDs, in one transaction
+func (s *SQLite) AddResolutions(ctx context.Context, counts map[string]int) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.AddResolutions")
+	defer span.End()
+
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	return s.updateMetadata(ctx, ids, func(queries *Queries, id string, key, salt []byte) error {
+		return queries.AddResolutions(ctx, AddResolutionsParams{
+			Count: int64(counts[id]),
+			Key:   key,
+			Salt:  salt,
+		})
+	})
+}
+
+// updateMetadata runs update for each of the records with the given IDs in one transaction, since SQLite has no
+// array parameters to update them in one statement
+func (s *SQLite) updateMetadata(ctx context.Context, ids []string, update func(queries *Queries, id string, key, salt []byte) error) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	queries := s.queries.WithTx(tx)
+
+	for _, id := range ids {
+		key, salt, err := dht.ParseRecordID(id)
+		if err != nil {
+			return err
+		}
+		if err = update(queries, id, key, saltOrEmpty(salt)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteStaleRecords deletes the records last seen before the given time, with their versions, failure counts, and
+// type index entries, except for records whose keys are retained. Records are deleted in batches, each in its own
+// transaction.
+func (s *SQLite) DeleteStaleRecords(ctx context.Context, before time.Time, retained [][]byte) (int, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.DeleteStaleRecords")
+	defer span.End()
+
+	// an empty list expands to NULL, and comparing keys to NULL is null rather than false, which would delete
+	// nothing. No key is empty, so retaining the empty key retains nothing.
+	if len(retained) == 0 {
+		retained = [][]byte{{}}
+	}
+	var deleted int
+	for {
+		n, err := s.deleteStaleBatch(ctx, before, retained)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < deleteBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteStaleBatch deletes a batch of stale records along with their versions and failure counts, returning the
+// number of records deleted
+func (s *SQLite) deleteStaleBatch(ctx context.Context, before time.Time, retained [][]byte) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	queries := s.queries.WithTx(tx)
+
+	rows, err := queries.DeleteStaleRecords(ctx, DeleteStaleRecordsParams{
+		Before:   before.UnixMilli(),
+		Retained: retained,
+		Limit:    deleteBatchSize,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	ids := make([][]byte, len(rows))
+	for i, row := range rows {
+		if err = queries.DeleteRecordVersions(ctx, DeleteRecordVersionsParams{Key: row.Key, Salt: saltOrEmpty(row.Salt)}); err != nil {
+			return 0, err
+		}
+		ids[i] = []byte(dht.RecordID(row.Key, row.Salt))
+	}
+	if err = queries.DeleteFailedRecords(ctx, ids); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// DeleteRecord deletes the record with the given ID, with its versions, failure count, metadata, and type index
+// entries, in one transaction
+func (s *SQLite) DeleteRecord(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.DeleteRecord")
+	defer span.End()
+
+	key, salt, err := dht.ParseRecordID(id)
+	if err != nil {
+		return false, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	queries := s.queries.WithTx(tx)
+
+	if _, err = queries.DeleteRecord(ctx, DeleteRecordParams{Key: key, Salt: saltOrEmpty(salt)}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = queries.DeleteRecordVersions(ctx, DeleteRecordVersionsParams{Key: key, Salt: saltOrEmpty(salt)}); err != nil {
+		return false, err
+	}
+	if err = queries.DeleteFailedRecords(ctx, [][]byte{[]byte(dht.RecordID(key, salt))}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// WriteRetainedKey marks the records under the given key as retained
+func (s *SQLite) WriteRetainedKey(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.WriteRetainedKey")
+	defer span.End()
+
+	return s.queries.WriteRetainedKey(ctx, k)
+}
+
+// DeleteRetainedKey stops retaining the records under the given key
+func (s *SQLite) DeleteRetainedKey(ctx context.Context, k []byte) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.DeleteRetainedKey")
+	defer span.End()
+
+	return s.queries.DeleteRetainedKey(ctx, k)
+}
+
+// ListRetainedKeys returns every retained key in sorted order
+func (s *SQLite) ListRetainedKeys(ctx context.Context) ([][]byte, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ListRetainedKeys")
+	defer span.End()
+
+	return s.queries.ListRetainedKeys(ctx)
+}
+
+// WriteAPIKey stores the API key, replacing the stored key with its ID, if any
+func (s *SQLite) WriteAPIKey(ctx context.Context, key dht.APIKey) error {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.WriteAPIKey")
+	defer span.End()
+
+	return s.queries.WriteAPIKey(ctx, WriteAPIKeyParams{
+		ID:                key.ID,
+		Name:              key.Name,
+		Hash:              key.Hash,
+		RequestsPerSecond: key.RequestsPerSecond,
+		Burst:             int64(key.Burst),
+		PublishesPerDay:   int64(key.PublishesPerDay),
+		Created:           key.Created.UnixMilli(),
+	})
+}
+
+// ReadAPIKey returns the API key with the given ID, or nil if there is none
+func (s *SQLite) ReadAPIKey(ctx context.Context, id string) (*dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ReadAPIKey")
+	defer span.End()
+
+	row, err := s.queries.ReadAPIKey(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := row.APIKey()
+	return &key, nil
+}
+
+// ListAPIKeys returns every API key in ID order
+func (s *SQLite) ListAPIKeys(ctx context.Context) ([]dht.APIKey, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.ListAPIKeys")
+	defer span.End()
+
+	rows, err := s.queries.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]dht.APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.APIKey())
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey deletes the API key with the given ID, returning whether it was stored
+func (s *SQLite) DeleteAPIKey(ctx context.Context, id string) (bool, error) {
+	ctx, span := telemetry.GetTracer().Start(ctx, "sqlite.DeleteAPIKey")
+	defer span.End()
+
+	deleted, err := s.queries.DeleteAPIKey(ctx, id)
+	return deleted > 0, err
+}
+
+// APIKey returns the API key a row stores
+func (row DhtApiKey) APIKey() dht.APIKey {
+	return dht.APIKey{
+		ID:                row.ID,
+		Name:              row.Name,
+		Hash:              row.Hash,
+		RequestsPerSecond: row.RequestsPerSecond,
+		Burst:             int(row.Burst),
+		PublishesPerDay:   int(row.PublishesPerDay),
+		Created:           time.UnixMilli(row.Created),
+	}
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,226 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+	"github.com/TBD54566975/did-dht/pkg/storage/db/sqlite"
+	"github.com/TBD54566975/did-dht/pkg/storage/storagetest"
+)
+
+func getTestDB(t *testing.T) storage.Storage {
+	db, err := sqlite.NewSQLite(filepath.Join(t.TempDir(), "diddht.sqlite"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestRecord(t *testing.T) dht.BEP44Record {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	return dht.RecordFromBEP44(putMsg)
+}
+
+func TestReadWrite(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	r := newTestRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, r))
+
+	r2, err := db.ReadRecord(ctx, r.ID())
+	require.NoError(t, err)
+	assert.Equal(t, r.Key, r2.Key)
+	assert.Equal(t, r.Value, r2.Value)
+	assert.Equal(t, r.Signature, r2.Signature)
+	assert.Equal(t, r.SequenceNumber, r2.SequenceNumber)
+
+	missing, err := db.ReadRecord(ctx, newTestRecord(t).ID())
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	count, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestReadRecords(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	unsalted, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	salted, err := dht.CreateSaltedDNSPublishRequest(sk, []byte("salt"), *packet)
+	require.NoError(t, err)
+
+	r1, r2, other := dht.RecordFromBEP44(unsalted), dht.RecordFromBEP44(salted), newTestRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, r1))
+	require.NoError(t, db.WriteRecord(ctx, r2))
+	require.NoError(t, db.WriteRecord(ctx, other))
+
+	// the salted record matches a requested key and a requested salt, but wasn't requested itself
+	records, err := db.ReadRecords(ctx, []string{r1.ID(), other.ID()})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, r1.Signature, records[r1.ID()].Signature)
+	assert.Equal(t, other.Signature, records[other.ID()].Signature)
+
+	records, err = db.ReadRecords(ctx, []string{r2.ID()})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, []byte("salt"), records[r2.ID()].Salt)
+}
+
+func TestRecordVersions(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+
+	// write versions out of order, including a duplicate
+	for _, offset := range []int64{2, 0, 1, 0} {
+		version := *putMsg
+		version.Seq += offset
+		version.Sign(sk)
+		require.NoError(t, db.WriteRecordVersion(ctx, dht.RecordFromBEP44(&version)))
+	}
+
+	versions, err := db.ListRecordVersions(ctx, dht.RecordFromBEP44(putMsg).ID())
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, version := range versions {
+		assert.Equal(t, putMsg.Seq+int64(i), version.SequenceNumber)
+	}
+}
+
+func TestDBPagination(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	written := make(map[string]bool)
+	for i := 0; i < 11; i++ {
+		r := newTestRecord(t)
+		require.NoError(t, db.WriteRecord(ctx, r))
+		written[r.ID()] = true
+	}
+
+	page, nextPageToken, err := db.ListRecords(ctx, nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, page, 10)
+	require.NotNil(t, nextPageToken)
+
+	last, nextPageToken, err := db.ListRecords(ctx, nextPageToken, 10)
+	require.NoError(t, err)
+	assert.Nil(t, nextPageToken)
+	assert.Len(t, last, 1)
+
+	for _, r := range append(page, last...) {
+		assert.True(t, written[r.ID()])
+		delete(written, r.ID())
+	}
+	assert.Empty(t, written)
+}
+
+func TestFailedRecords(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	id := newTestRecord(t).ID()
+	require.NoError(t, db.WriteFailedRecord(ctx, id))
+	require.NoError(t, db.WriteFailedRecord(ctx, id))
+
+	failed, err := db.ListFailedRecords(ctx)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, dht.FailedRecord{ID: id, Count: 2}, failed[0])
+
+	count, err := db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestDeleteStaleRecords(t *testing.T) {
+	db := getTestDB(t)
+	ctx := context.Background()
+
+	var records []dht.BEP44Record
+	for i := 0; i < 3; i++ {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, []did.TypeIndex{did.Organization}, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		record := dht.RecordFromBEP44(putMsg)
+		require.NoError(t, db.WriteRecord(ctx, record))
+		require.NoError(t, db.WriteRecordVersion(ctx, record))
+		records = append(records, record)
+	}
+	stale, touched, retained := records[0], records[1], records[2]
+	require.NoError(t, db.WriteFailedRecord(ctx, stale.ID()))
+
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, db.TouchRecord(ctx, touched.ID()))
+
+	deleted, err := db.DeleteStaleRecords(ctx, cutoff, [][]byte{retained.Key[:]})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	got, err := db.ReadRecord(ctx, stale.ID())
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	versions, err := db.ListRecordVersions(ctx, stale.ID())
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	failed, err := db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, failed)
+	// the record's type index entries are deleted with it
+	typed, _, err := db.ListRecordsByType(ctx, dht.TypeQuery{Type: int(did.Organization)}, nil, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{touched.ID(), retained.ID()}, typed)
+
+	for _, record := range []dht.BEP44Record{touched, retained} {
+		got, err := db.ReadRecord(ctx, record.ID())
+		require.NoError(t, err)
+		assert.Equal(t, record.ID(), got.ID())
+	}
+
+	// without retained keys every stale record is collected
+	deleted, err = db.DeleteStaleRecords(ctx, time.Now().Add(time.Second), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, getTestDB)
+}
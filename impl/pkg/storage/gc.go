@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/TBD54566975/did-dht/config"
+	dhtint "github.com/TBD54566975/did-dht/internal/dht"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// Collector periodically deletes the stored records that haven't been published or resolved within the retention
+// window, keeping the storage from growing without bound as DIDs are abandoned. Records under retained keys, whether
+// configured or written to the storage by operators, are never deleted.
+type Collector struct {
+	db        Storage
+	retention time.Duration
+	retained  [][]byte
+	scheduler dhtint.Scheduler
+	reclaimed metric.Int64Counter
+}
+
+// NewCollector returns a collector deleting stale records from the given storage on the configured schedule, or nil if
+// collection isn't configured
+func NewCollector(cfg config.GCConfig, db Storage) (*Collector, error) {
+	if cfg.RetentionHours <= 0 {
+		return nil, nil
+	}
+
+	retained := make([][]byte, 0, len(cfg.RetainedDIDs))
+	for _, did := range cfg.RetainedDIDs {
+		k, _, err := dht.ParseRecordID(strings.TrimPrefix(did, "did:dht:"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid retained did %s", did)
+		}
+		retained = append(retained, k)
+	}
+
+	reclaimed, err := telemetry.GetMeter().Int64Counter("storage.gc.reclaimed_records",
+		metric.WithDescription("stale records deleted from storage"))
+	if err != nil {
+		logrus.WithError(err).Error("failed to create reclaimed records counter")
+		reclaimed = noop.Int64Counter{}
+	}
+
+	c := &Collector{
+		db:        db,
+		retention: time.Duration(cfg.RetentionHours) * time.Hour,
+		retained:  retained,
+		scheduler: dhtint.NewScheduler(),
+		reclaimed: reclaimed,
+	}
+	if err = c.scheduler.Schedule(cfg.CRON, c.collect); err != nil {
+		return nil, errors.Wrap(err, "failed to schedule record collection")
+	}
+	return c, nil
+}
+
+// Close stops collecting records
+func (c *Collector) Close() {
+	if c == nil {
+		return
+	}
+	c.scheduler.Stop()
+}
+
+func (c *Collector) collect() {
+	ctx, span := telemetry.GetTracer().Start(context.Background(), "Collector.collect")
+	defer span.End()
+
+	start := time.Now()
+	deleted, err := c.Collect(ctx)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("deleted", deleted).Error("failed to collect stale records")
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"deleted":  deleted,
+		"duration": time.Since(start),
+	}).Info("collected stale records")
+}
+
+// Collect deletes the records that haven't been published or resolved within the retention window, returning the
+// number of records deleted
+func (c *Collector) Collect(ctx context.Context) (int, error) {
+	stored, err := c.db.ListRetainedKeys(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list retained keys")
+	}
+	retained := append(slices.Clone(c.retained), stored...)
+	deleted, err := c.db.DeleteStaleRecords(ctx, time.Now().Add(-c.retention), retained)
+	c.reclaimed.Add(ctx, int64(deleted))
+	return deleted, err
+}
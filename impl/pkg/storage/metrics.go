@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// queryBuckets are the bounds of the storage query latency buckets, in seconds
+var queryBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// instrumented records the latency of every query to the storage it wraps, by operation
+type instrumented struct {
+	db      Storage
+	queries metric.Float64Histogram
+}
+
+var _ Storage = (*instrumented)(nil)
+
+// Instrument wraps the storage so the latency of each of its queries is recorded
+func Instrument(db Storage) Storage {
+	queries, err := telemetry.GetMeter().Float64Histogram("storage.query.duration", metric.WithUnit("s"),
+		metric.WithDescription("latency of storage queries by operation"),
+		metric.WithExplicitBucketBoundaries(queryBuckets...))
+	if err != nil {
+		logrus.WithError(err).Error("failed to create storage query histogram")
+		queries = noop.Float64Histogram{}
+	}
+	return &instrumented{db: db, queries: queries}
+}
+
+// observe records the latency of a query of the given operation that started at the given time
+func (s *instrumented) observe(ctx context.Context, operation string, start time.Time) {
+	s.queries.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("operation", operation)))
+}
+
+func (s *instrumented) WriteRecord(ctx context.Context, record dht.BEP44Record) error {
+	defer s.observe(ctx, "WriteRecord", time.Now())
+	return s.db.WriteRecord(ctx, record)
+}
+
+func (s *instrumented) ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, error) {
+	defer s.observe(ctx, "ReadRecord", time.Now())
+	return s.db.ReadRecord(ctx, id)
+}
+
+func (s *instrumented) ReadRecords(ctx context.Context, ids []string) (map[string]dht.BEP44Record, error) {
+	defer s.observe(ctx, "ReadRecords", time.Now())
+	return s.db.ReadRecords(ctx, ids)
+}
+
+func (s *instrumented) ListRecords(ctx context.Context, nextPageToken []byte, pageSize int) ([]dht.BEP44Record, []byte, error) {
+	defer s.observe(ctx, "ListRecords", time.Now())
+	return s.db.ListRecords(ctx, nextPageToken, pageSize)
+}
+
+func (s *instrumented) RecordCount(ctx context.Context) (int, error) {
+	defer s.observe(ctx, "RecordCount", time.Now())
+	return s.db.RecordCount(ctx)
+}
+
+func (s *instrumented) ListRecordsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, pageSize int) ([]string, []byte, error) {
+	defer s.observe(ctx, "ListRecordsByType", time.Now())
+	return s.db.ListRecordsByType(ctx, query, nextPageToken, pageSize)
+}
+
+func (s *instrumented) WriteRecordVersion(ctx context.Context, record dht.BEP44Record) error {
+	defer s.observe(ctx, "WriteRecordVersion", time.Now())
+	return s.db.WriteRecordVersion(ctx, record)
+}
+
+func (s *instrumented) ListRecordVersions(ctx context.Context, id string) ([]dht.BEP44Record, error) {
+	defer s.observe(ctx, "ListRecordVersions", time.Now())
+	return s.db.ListRecordVersions(ctx, id)
+}
+
+func (s *instrumented) WriteFailedRecord(ctx context.Context, id string) error {
+	defer s.observe(ctx, "WriteFailedRecord", time.Now())
+	return s.db.WriteFailedRecord(ctx, id)
+}
+
+func (s *instrumented) ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error) {
+	defer s.observe(ctx, "ListFailedRecords", time.Now())
+	return s.db.ListFailedRecords(ctx)
+}
+
+func (s *instrumented) FailedRecordCount(ctx context.Context) (int, error) {
+	defer s.observe(ctx, "FailedRecordCount", time.Now())
+	return s.db.FailedRecordCount(ctx)
+}
+
+func (s *instrumented) DeleteFailedRecord(ctx context.Context, id string) error {
+	defer s.observe(ctx, "DeleteFailedRecord", time.Now())
+	return s.db.DeleteFailedRecord(ctx, id)
+}
+
+func (s *instrumented) TouchRecord(ctx context.Context, id string) error {
+	defer s.observe(ctx, "TouchRecord", time.Now())
+	return s.db.TouchRecord(ctx, id)
+}
+
+func (s *instrumented) ReadRecordMetadata(ctx context.Context, id string) (*dht.RecordMetadata, error) {
+	defer s.observe(ctx, "ReadRecordMetadata", time.Now())
+	return s.db.ReadRecordMetadata(ctx, id)
+}
+
+func (s *instrumented) MarkRepublished(ctx context.Context, ids []string) error {
+	defer s.observe(ctx, "MarkRepublished", time.Now())
+	return s.db.MarkRepublished(ctx, ids)
+}
+
+func (s *instrumented) AddResolutions(ctx context.Context, counts map[string]int) error {
+	defer s.observe(ctx, "AddResolutions", time.Now())
+	return s.db.AddResolutions(ctx, counts)
+}
+
+func (s *instrumented) DeleteStaleRecords(ctx context.Context, before time.Time, retained [][]byte) (int, error) {
+	defer s.observe(ctx, "DeleteStaleRecords", time.Now())
+	return s.db.DeleteStaleRecords(ctx, before, retained)
+}
+
+func (s *instrumented) DeleteRecord(ctx context.Context, id string) (bool, error) {
+	defer s.observe(ctx, "DeleteRecord", time.Now())
+	return s.db.DeleteRecord(ctx, id)
+}
+
+func (s *instrumented) WriteRetainedKey(ctx context.Context, k []byte) error {
+	defer s.observe(ctx, "WriteRetainedKey", time.Now())
+	return s.db.WriteRetainedKey(ctx, k)
+}
+
+func (s *instrumented) DeleteRetainedKey(ctx context.Context, k []byte) error {
+	defer s.observe(ctx, "DeleteRetainedKey", time.Now())
+	return s.db.DeleteRetainedKey(ctx, k)
+}
+
+func (s *instrumented) ListRetainedKeys(ctx context.Context) ([][]byte, error) {
+	defer s.observe(ctx, "ListRetainedKeys", time.Now())
+	return s.db.ListRetainedKeys(ctx)
+}
+
+func (s *instrumented) WriteAPIKey(ctx context.Context, key dht.APIKey) error {
+	defer s.observe(ctx, "WriteAPIKey", time.Now())
+	return s.db.WriteAPIKey(ctx, key)
+}
+
+func (s *instrumented) ReadAPIKey(ctx context.Context, id string) (*dht.APIKey, error) {
+	defer s.observe(ctx, "ReadAPIKey", time.Now())
+	return s.db.ReadAPIKey(ctx, id)
+}
+
+func (s *instrumented) ListAPIKeys(ctx context.Context) ([]dht.APIKey, error) {
+	defer s.observe(ctx, "ListAPIKeys", time.Now())
+	return s.db.ListAPIKeys(ctx)
+}
+
+func (s *instrumented) DeleteAPIKey(ctx context.Context, id string) (bool, error) {
+	defer s.observe(ctx, "DeleteAPIKey", time.Now())
+	return s.db.DeleteAPIKey(ctx, id)
+}
+
+func (s *instrumented) Close() error {
+	return s.db.Close()
+}
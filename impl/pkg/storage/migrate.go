@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+)
+
+const migratePageSize = 1000
+
+// MigrateCheckpoint is how far a migration has got, from which a stopped migration is resumed
+type MigrateCheckpoint struct {
+	// NextPageToken is the source page of records to copy next
+	NextPageToken []byte `json:"nextPageToken,omitempty"`
+	// RecordsDone is set once every record is copied, leaving only the failed records
+	RecordsDone bool `json:"recordsDone"`
+}
+
+// MigrateProgress reports how far a migration has got
+type MigrateProgress struct {
+	MigrateCheckpoint
+	// Records is the number of records copied so far in this run
+	Records int
+	// Total is the number of records in the source when the migration started
+	Total int
+}
+
+// Migrate copies every record, with its history, and then every failed record from src to dst, starting from the
+// given checkpoint; the zero checkpoint copies everything. Records are copied a page at a time, and progress is
+// called after each page with the checkpoint to resume from if the migration stops. Copying a record that is already
+// in dst overwrites it.
+//
+// Failed record counts are copied last, by counting each failure again in dst, so they should only be copied into a
+// destination without failed records of its own.
+func Migrate(ctx context.Context, src, dst Storage, from MigrateCheckpoint, progress func(MigrateProgress)) error {
+	total, err := src.RecordCount(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to count source records")
+	}
+
+	report := MigrateProgress{MigrateCheckpoint: from, Total: total}
+	for !report.RecordsDone {
+		records, next, err := src.ListRecords(ctx, report.NextPageToken, migratePageSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to list source records")
+		}
+		for _, record := range records {
+			if err = migrateRecord(ctx, src, dst, record); err != nil {
+				return err
+			}
+		}
+
+		report.Records += len(records)
+		report.NextPageToken = next
+		report.RecordsDone = next == nil
+		if progress != nil {
+			progress(report)
+		}
+	}
+
+	failed, err := src.ListFailedRecords(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list source failed records")
+	}
+	for _, f := range failed {
+		for i := 0; i < f.Count; i++ {
+			if err = dst.WriteFailedRecord(ctx, f.ID); err != nil {
+				return errors.Wrapf(err, "failed to copy failed record %s", f.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateRecord copies the record and its history from src to dst
+func migrateRecord(ctx context.Context, src, dst Storage, record dht.BEP44Record) error {
+	versions, err := src.ListRecordVersions(ctx, record.ID())
+	if err != nil {
+		return errors.Wrapf(err, "failed to list versions of record %s", record.ID())
+	}
+	for _, version := range versions {
+		if err = dst.WriteRecordVersion(ctx, version); err != nil {
+			return errors.Wrapf(err, "failed to copy version %d of record %s", version.SequenceNumber, record.ID())
+		}
+	}
+	if err = dst.WriteRecord(ctx, record); err != nil {
+		return errors.Wrapf(err, "failed to copy record %s", record.ID())
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage/db/postgres"
+)
+
+// RepublishClaimer is implemented by storage that gateway replicas share, so the replicas split republishing the
+// stored records between them rather than each republishing every record
+type RepublishClaimer interface {
+	// ClaimRepublish claims the stored records with the given IDs for the given holder to republish, for the length
+	// of the lease. A record isn't claimed if it was republished since the given time, or if another holder's claim on
+	// it hasn't expired; a holder claiming a record again renews its claim. IDs without a stored record are skipped.
+	ClaimRepublish(ctx context.Context, holder string, ids []string, republishedSince time.Time, lease time.Duration) (*dht.RepublishClaims, error)
+}
+
+var _ RepublishClaimer = (*postgres.Postgres)(nil)
+
+// AsRepublishClaimer returns the storage as a RepublishClaimer, if replicas can share it
+func AsRepublishClaimer(db Storage) (RepublishClaimer, bool) {
+	if s, ok := db.(*instrumented); ok {
+		claimer, ok := s.db.(RepublishClaimer)
+		if !ok {
+			return nil, false
+		}
+		return instrumentedClaimer{instrumented: s, claimer: claimer}, true
+	}
+	claimer, ok := db.(RepublishClaimer)
+	return claimer, ok
+}
+
+// instrumentedClaimer records the latency of the claims made on the instrumented storage
+type instrumentedClaimer struct {
+	*instrumented
+	claimer RepublishClaimer
+}
+
+func (s instrumentedClaimer) ClaimRepublish(ctx context.Context, holder string, ids []string, republishedSince time.Time, lease time.Duration) (*dht.RepublishClaims, error) {
+	defer s.observe(ctx, "ClaimRepublish", time.Now())
+	return s.claimer.ClaimRepublish(ctx, holder, ids, republishedSince, lease)
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/TBD54566975/did-dht/pkg/telemetry"
+)
+
+// resolutionFlushInterval is how often counted resolutions are added to the stored records' metadata
+const resolutionFlushInterval = 10 * time.Second
+
+// ResolutionCounter counts the resolutions of records in memory and periodically adds them to the stored records'
+// metadata, so resolving a record doesn't write to storage every time. Counts not yet flushed are lost if the gateway
+// stops without closing the counter.
+type ResolutionCounter struct {
+	db     Storage
+	mu     sync.Mutex
+	counts map[string]int
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewResolutionCounter returns a counter flushing resolution counts to the given storage in the background
+func NewResolutionCounter(db Storage) *ResolutionCounter {
+	c := &ResolutionCounter{
+		db:     db,
+		counts: make(map[string]int),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Count counts a resolution of the record with the given ID
+func (c *ResolutionCounter) Count(id string) {
+	c.mu.Lock()
+	c.counts[id]++
+	c.mu.Unlock()
+}
+
+// Flush adds the resolutions counted since the last flush to the stored records' metadata. Counts are dropped if
+// they can't be added, rather than growing without bound while storage is unavailable.
+func (c *ResolutionCounter) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	counts := c.counts
+	c.counts = make(map[string]int)
+	c.mu.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+	return c.db.AddResolutions(ctx, counts)
+}
+
+// Close stops flushing in the background and flushes the counts left
+func (c *ResolutionCounter) Close() {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+}
+
+func (c *ResolutionCounter) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(resolutionFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.stop:
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *ResolutionCounter) flush() {
+	ctx, span := telemetry.GetTracer().Start(context.Background(), "ResolutionCounter.flush")
+	defer span.End()
+
+	if err := c.Flush(ctx); err != nil {
+		logrus.WithContext(ctx).WithError(err).Warn("failed to add resolution counts to storage")
+	}
+}
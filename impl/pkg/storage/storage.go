@@ -2,50 +2,209 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/url"
+	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/TBD54566975/did-dht/config"
 	"github.com/TBD54566975/did-dht/pkg/dht"
 	"github.com/TBD54566975/did-dht/pkg/storage/db/bolt"
 	"github.com/TBD54566975/did-dht/pkg/storage/db/postgres"
+	"github.com/TBD54566975/did-dht/pkg/storage/db/redis"
+	"github.com/TBD54566975/did-dht/pkg/storage/db/sqlite"
 )
 
+// Storage is the record store backing a gateway. Implementations are provided by drivers, selected by the scheme of
+// the configured storage URI.
 type Storage interface {
+	// WriteRecord stores the record as the current record for its ID, and indexes the ID under the DID types the
+	// record lists in place of the types of the record it replaces. Sequence numbers never go backwards: writing a
+	// record older than the one stored is a no-op.
 	WriteRecord(ctx context.Context, record dht.BEP44Record) error
 	ReadRecord(ctx context.Context, id string) (*dht.BEP44Record, error)
+	// ReadRecords reads the records with the given IDs in one round trip, returning them keyed by ID. IDs without a
+	// stored record are left out.
+	ReadRecords(ctx context.Context, ids []string) (map[string]dht.BEP44Record, error)
+	// ListRecords returns a page of records in a stable order, along with an opaque token for the next page, which is
+	// nil after the last page. Resuming from a token neither skips nor repeats records written or removed since the
+	// previous page was listed.
 	ListRecords(ctx context.Context, nextPageToken []byte, pageSize int) (records []dht.BEP44Record, nextPage []byte, err error)
 	RecordCount(ctx context.Context) (int, error)
+	// ListRecordsByType returns a page of the IDs of the records indexed under the query's DID type that match the
+	// query, in the query's order, along with an opaque token for the next page, which is nil after the last page.
+	// In update order, a record updated mid-listing moves to its new place, so a listing with the least recently
+	// updated records first lists it again at its end. A token is only valid for the
+	// query it was issued for; malformed tokens are rejected with dht.ErrInvalidPageToken.
+	ListRecordsByType(ctx context.Context, query dht.TypeQuery, nextPageToken []byte, pageSize int) (ids []string, nextPage []byte, err error)
+
+	// WriteRecordVersion stores the record in the history of its ID, keyed by sequence number. Writing a version
+	// that is already stored is a no-op.
+	WriteRecordVersion(ctx context.Context, record dht.BEP44Record) error
+	// ListRecordVersions returns every stored version of the record with the given ID, oldest first
+	ListRecordVersions(ctx context.Context, id string) ([]dht.BEP44Record, error)
 
 	WriteFailedRecord(ctx context.Context, id string) error
 	ListFailedRecords(ctx context.Context) ([]dht.FailedRecord, error)
 	FailedRecordCount(ctx context.Context) (int, error)
+	// DeleteFailedRecord clears the failure count of the record with the given ID, such as once it republishes again.
+	// Clearing a count that isn't stored is a no-op.
+	DeleteFailedRecord(ctx context.Context, id string) error
+
+	// TouchRecord marks the record with the given ID as seen now, so it isn't collected as stale. Writing a record also
+	// marks it as seen. Touching a record that isn't stored is a no-op.
+	TouchRecord(ctx context.Context, id string) error
+	// ReadRecordMetadata returns the metadata of the record with the given ID, or nil if the record isn't stored
+	ReadRecordMetadata(ctx context.Context, id string) (*dht.RecordMetadata, error)
+	// MarkRepublished marks the records with the given IDs as republished now. IDs without a stored record are skipped.
+	MarkRepublished(ctx context.Context, ids []string) error
+	// AddResolutions adds to the resolution counts of the records with the given IDs. IDs without a stored record are
+	// skipped.
+	AddResolutions(ctx context.Context, counts map[string]int) error
+	// DeleteStaleRecords deletes the records last seen before the given time, along with their history and failure
+	// counts, except for records whose keys are retained. It returns the number of records deleted.
+	DeleteStaleRecords(ctx context.Context, before time.Time, retained [][]byte) (int, error)
+	// DeleteRecord deletes the record with the given ID, along with its history, metadata, and failure count,
+	// returning whether it was stored
+	DeleteRecord(ctx context.Context, id string) (bool, error)
+
+	// WriteRetainedKey marks the records under the given key as retained, so they aren't collected as stale. Writing
+	// a key that is already retained is a no-op.
+	WriteRetainedKey(ctx context.Context, k []byte) error
+	// DeleteRetainedKey stops retaining the records under the given key. Deleting a key that isn't retained is a no-op.
+	DeleteRetainedKey(ctx context.Context, k []byte) error
+	// ListRetainedKeys returns every retained key in sorted order
+	ListRetainedKeys(ctx context.Context) ([][]byte, error)
+
+	// WriteAPIKey stores the API key, replacing the stored key with its ID, if any
+	WriteAPIKey(ctx context.Context, key dht.APIKey) error
+	// ReadAPIKey returns the API key with the given ID, or nil if there is none
+	ReadAPIKey(ctx context.Context, id string) (*dht.APIKey, error)
+	// ListAPIKeys returns every API key in ID order
+	ListAPIKeys(ctx context.Context) ([]dht.APIKey, error)
+	// DeleteAPIKey deletes the API key with the given ID, returning whether it was stored
+	DeleteAPIKey(ctx context.Context, id string) (bool, error)
 
 	Close() error
 }
 
+// Driver opens a Storage for a storage URI with the scheme the driver is registered under
+type Driver func(uri *url.URL) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+func init() {
+	Register("bolt", openBolt)
+	Register("postgres", openPostgres)
+	Register("redis", openRedis)
+	Register("rediss", openRedis)
+	Register("sqlite", openSQLite)
+}
+
+// Register makes a driver available for storage URIs with the given scheme. Drivers outside this package register
+// themselves from an init function, so importing the driver's package is enough to enable its scheme. Register panics
+// if the scheme is empty, the driver is nil, or a driver is already registered for the scheme.
+func Register(scheme string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if scheme == "" {
+		panic("storage: Register scheme is empty")
+	}
+	if driver == nil {
+		panic("storage: Register driver is nil")
+	}
+	scheme = strings.ToLower(scheme)
+	if _, dup := drivers[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	drivers[scheme] = driver
+}
+
+// Drivers returns the sorted schemes of the registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NewStorage opens the storage for the given URI with the driver registered for its scheme. A URI without a scheme
+// is a bolt database file.
 func NewStorage(uri string) (Storage, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return nil, err
 	}
-	switch u.Scheme {
-	case "bolt", "":
-		filename := u.Host
-		if u.Path != "" {
-			filename = fmt.Sprintf("%s/%s", filename, u.Path)
-		}
-		logrus.WithField("file", filename).Info("using boltdb for storage")
+	scheme := strings.ToLower(u.Scheme)
+	if scheme == "" {
+		scheme = "bolt"
+	}
+
+	driversMu.RLock()
+	driver, ok := drivers[scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported db type %s (from uri %s), registered types are %s", u.Scheme, uri, strings.Join(Drivers(), ", "))
+	}
+	return driver(u)
+}
+
+func openBolt(u *url.URL) (Storage, error) {
+	filename := u.Host
+	if u.Path != "" {
+		filename = fmt.Sprintf("%s/%s", filename, u.Path)
+	}
+	encodedKey, encrypted := os.LookupEnv(config.StorageEncryptionKey.String())
+	logrus.WithFields(logrus.Fields{
+		"file":      filename,
+		"encrypted": encrypted,
+	}).Info("using boltdb for storage")
+	if !encrypted {
 		return bolt.NewBolt(filename)
-	case "postgres":
-		logrus.WithFields(logrus.Fields{
-			"host":     u.Host,
-			"database": strings.TrimPrefix(u.Path, "/"),
-		}).Info("using postgres for storage")
-		return postgres.NewPostgres(uri)
-	default:
-		return nil, fmt.Errorf("unsupported db type %s (from uri %s)", u.Scheme, uri)
 	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be base64 encoded: %v", config.StorageEncryptionKey, err)
+	}
+	return bolt.NewEncryptedBolt(filename, key)
+}
+
+func openPostgres(u *url.URL) (Storage, error) {
+	logrus.WithFields(logrus.Fields{
+		"host":     u.Host,
+		"database": strings.TrimPrefix(u.Path, "/"),
+	}).Info("using postgres for storage")
+	return postgres.NewPostgres(u.String())
+}
+
+func openRedis(u *url.URL) (Storage, error) {
+	logrus.WithFields(logrus.Fields{
+		"host": u.Host,
+		"ttl":  u.Query().Get("ttl"),
+	}).Info("using redis for storage")
+	return redis.NewRedis(u.String())
+}
+
+func openSQLite(u *url.URL) (Storage, error) {
+	filename := u.Host
+	if u.Path != "" {
+		filename = fmt.Sprintf("%s/%s", filename, u.Path)
+	}
+	logrus.WithField("file", filename).Info("using sqlite for storage")
+	return sqlite.NewSQLite(filename)
 }
@@ -1,16 +1,23 @@
 package storage_test
 
 import (
+	"context"
+	"encoding/base64"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/TBD54566975/did-dht/config"
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
 	"github.com/TBD54566975/did-dht/pkg/storage"
 	"github.com/TBD54566975/did-dht/pkg/storage/db/bolt"
 	"github.com/TBD54566975/did-dht/pkg/storage/db/postgres"
+	"github.com/TBD54566975/did-dht/pkg/storage/db/redis"
 )
 
 func TestNewStoragePostgres(t *testing.T) {
@@ -27,7 +34,19 @@ func TestNewStoragePostgres(t *testing.T) {
 
 	db, err := storage.NewStorage(uri)
 	require.NoError(t, err)
-	assert.IsType(t, postgres.Postgres(""), db)
+	assert.IsType(t, &postgres.Postgres{}, db)
+}
+
+func TestNewStorageRedis(t *testing.T) {
+	uri := os.Getenv("TEST_REDIS")
+	if uri == "" {
+		t.SkipNow()
+	}
+
+	db, err := storage.NewStorage(uri)
+	require.NoError(t, err)
+	assert.IsType(t, &redis.Redis{}, db)
+	assert.NoError(t, db.Close())
 }
 
 func TestNewStorageBolt(t *testing.T) {
@@ -36,8 +55,186 @@ func TestNewStorageBolt(t *testing.T) {
 	assert.IsType(t, &bolt.Bolt{}, db)
 }
 
+func TestNewStorageBoltEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diddht.db")
+
+	t.Setenv(config.StorageEncryptionKey.String(), "not base64!")
+	_, err := storage.NewStorage("bolt://" + path)
+	require.ErrorContains(t, err, "must be base64 encoded")
+
+	t.Setenv(config.StorageEncryptionKey.String(), base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	db, err := storage.NewStorage("bolt://" + path)
+	require.NoError(t, err)
+	assert.IsType(t, &bolt.Bolt{}, db)
+	assert.NoError(t, db.Close())
+}
+
 func TestNewStorageUnsupported(t *testing.T) {
 	db, err := storage.NewStorage("imaginaryDB://a:b@c/d")
-	require.Error(t, err)
+	require.ErrorContains(t, err, "unsupported db type imaginarydb")
 	assert.Nil(t, db)
 }
+
+func TestRegister(t *testing.T) {
+	var opened *url.URL
+	storage.Register("memory-test", func(uri *url.URL) (storage.Storage, error) {
+		opened = uri
+		return nil, nil
+	})
+	assert.Contains(t, storage.Drivers(), "memory-test")
+
+	_, err := storage.NewStorage("MEMORY-TEST://records")
+	require.NoError(t, err)
+	require.NotNil(t, opened)
+	assert.Equal(t, "records", opened.Host)
+
+	assert.Panics(t, func() {
+		storage.Register("memory-test", func(*url.URL) (storage.Storage, error) { return nil, nil })
+	})
+	assert.Panics(t, func() { storage.Register("", func(*url.URL) (storage.Storage, error) { return nil, nil }) })
+	assert.Panics(t, func() { storage.Register("nil-driver", nil) })
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	src, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "src.db"))
+	require.NoError(t, err)
+	defer src.Close()
+
+	// three records, one with history, and a failed record
+	var ids []string
+	for i := 0; i < 3; i++ {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		record := dht.RecordFromBEP44(putMsg)
+		require.NoError(t, src.WriteRecord(ctx, record))
+		require.NoError(t, src.WriteRecordVersion(ctx, record))
+		ids = append(ids, record.ID())
+	}
+	require.NoError(t, src.WriteFailedRecord(ctx, ids[0]))
+	require.NoError(t, src.WriteFailedRecord(ctx, ids[0]))
+
+	t.Run("copies everything", func(t *testing.T) {
+		dst, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "dst.db"))
+		require.NoError(t, err)
+		defer dst.Close()
+
+		var last storage.MigrateProgress
+		require.NoError(t, storage.Migrate(ctx, src, dst, storage.MigrateCheckpoint{}, func(p storage.MigrateProgress) {
+			last = p
+		}))
+		assert.Equal(t, 3, last.Records)
+		assert.Equal(t, 3, last.Total)
+		assert.True(t, last.RecordsDone)
+
+		for _, id := range ids {
+			got, err := dst.ReadRecord(ctx, id)
+			require.NoError(t, err)
+			assert.NotNil(t, got)
+			versions, err := dst.ListRecordVersions(ctx, id)
+			require.NoError(t, err)
+			assert.Len(t, versions, 1)
+		}
+		failed, err := dst.ListFailedRecords(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []dht.FailedRecord{{ID: ids[0], Count: 2}}, failed)
+	})
+
+	t.Run("resumes after the copied records", func(t *testing.T) {
+		dst, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "dst.db"))
+		require.NoError(t, err)
+		defer dst.Close()
+
+		var copied int
+		require.NoError(t, storage.Migrate(ctx, src, dst, storage.MigrateCheckpoint{RecordsDone: true}, func(p storage.MigrateProgress) {
+			copied += p.Records
+		}))
+		assert.Zero(t, copied)
+		count, err := dst.RecordCount(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+		failedCount, err := dst.FailedRecordCount(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, failedCount)
+	})
+}
+
+func TestCollector(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "diddht.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	t.Run("disabled without a retention window", func(t *testing.T) {
+		c, err := storage.NewCollector(config.GCConfig{CRON: "0 4 * * *"}, db)
+		require.NoError(t, err)
+		assert.Nil(t, c)
+		c.Close()
+	})
+
+	t.Run("invalid retained dids are rejected", func(t *testing.T) {
+		_, err := storage.NewCollector(config.GCConfig{
+			RetentionHours: 24,
+			CRON:           "0 4 * * *",
+			RetainedDIDs:   []string{"did:dht:invalid"},
+		}, db)
+		assert.ErrorContains(t, err, "invalid retained did")
+	})
+
+	t.Run("records seen within the retention window are kept", func(t *testing.T) {
+		sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+		require.NoError(t, err)
+		packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+		require.NoError(t, err)
+		putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+		require.NoError(t, err)
+		require.NoError(t, db.WriteRecord(ctx, dht.RecordFromBEP44(putMsg)))
+
+		c, err := storage.NewCollector(config.GCConfig{
+			RetentionHours: 24,
+			CRON:           "0 4 * * *",
+			RetainedDIDs:   []string{doc.ID},
+		}, db)
+		require.NoError(t, err)
+		defer c.Close()
+
+		deleted, err := c.Collect(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, deleted)
+		count, err := db.RecordCount(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestResolutionCounter(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.NewStorage("bolt://" + filepath.Join(t.TempDir(), "diddht.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	packet, err := did.DHT(doc.ID).ToDNSPacket(*doc, nil, nil, nil)
+	require.NoError(t, err)
+	putMsg, err := dht.CreateDNSPublishRequest(sk, *packet)
+	require.NoError(t, err)
+	record := dht.RecordFromBEP44(putMsg)
+	require.NoError(t, db.WriteRecord(ctx, record))
+
+	c := storage.NewResolutionCounter(db)
+	c.Count(record.ID())
+	c.Count(record.ID())
+	require.NoError(t, c.Flush(ctx))
+	c.Count(record.ID())
+	c.Close()
+
+	metadata, err := db.ReadRecordMetadata(ctx, record.ID())
+	require.NoError(t, err)
+	require.NotNil(t, metadata)
+	assert.Equal(t, int64(3), metadata.Resolutions)
+}
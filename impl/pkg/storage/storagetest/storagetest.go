@@ -0,0 +1,554 @@
+// Package storagetest is a conformance suite for storage.Storage implementations. Every storage driver runs it from its
+// tests, so a new backend can't silently diverge from the behavior the gateway relies on:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			return openTestDB(t)
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	didsdk "github.com/TBD54566975/ssi-sdk/did"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TBD54566975/did-dht/internal/did"
+	"github.com/TBD54566975/did-dht/pkg/dht"
+	"github.com/TBD54566975/did-dht/pkg/storage"
+)
+
+// behaviors are the behaviors every storage driver must have, each run against a storage from the driver's opener
+var behaviors = []struct {
+	name string
+	test func(t *testing.T, db storage.Storage)
+}{
+	{"write and read", testWriteRead},
+	{"salted records are stored apart", testSalted},
+	{"read many", testReadRecords},
+	{"sequence numbers never go backwards", testSeqMonotonic},
+	{"list every record once", testList},
+	{"list under concurrent writes", testListConcurrentWrites},
+	{"index by type", testListByType},
+	{"filter and order by type", testListByTypeQuery},
+	{"record versions", testRecordVersions},
+	{"failed records", testFailedRecords},
+	{"record metadata", testRecordMetadata},
+	{"delete a record", testDeleteRecord},
+	{"retained keys", testRetainedKeys},
+	{"api keys", testAPIKeys},
+}
+
+// Run runs the conformance suite, calling open for a storage to test each behavior against. The storage may already
+// hold records, as when tests share a database server, and open should register any cleanup, such as closing the
+// storage, with t.
+func Run(t *testing.T, open func(t *testing.T) storage.Storage) {
+	for _, behavior := range behaviors {
+		t.Run(behavior.name, func(t *testing.T) {
+			behavior.test(t, open(t))
+		})
+	}
+}
+
+// signer publishes the versions of a DID's record
+type signer struct {
+	sk     ed25519.PrivateKey
+	doc    didsdk.Document
+	packet dns.Msg
+}
+
+// newSigner returns a signer for a new DID of the given types
+func newSigner(t *testing.T, types ...did.TypeIndex) signer {
+	sk, doc, err := did.GenerateDIDDHT(did.CreateDIDDHTOpts{})
+	require.NoError(t, err)
+	return signer{sk: sk, doc: *doc}.retype(t, types...)
+}
+
+// retype returns a signer publishing the same DID with the given types
+func (s signer) retype(t *testing.T, types ...did.TypeIndex) signer {
+	packet, err := did.DHT(s.doc.ID).ToDNSPacket(s.doc, types, nil, nil)
+	require.NoError(t, err)
+	s.packet = *packet
+	return s
+}
+
+// record returns the signed record with the given salt and sequence number
+func (s signer) record(t *testing.T, salt []byte, seq int64) dht.BEP44Record {
+	putMsg, err := dht.CreateSaltedDNSPublishRequest(s.sk, salt, s.packet)
+	require.NoError(t, err)
+	putMsg.Seq = seq
+	putMsg.Sign(s.sk)
+	return dht.RecordFromBEP44(putMsg)
+}
+
+func newRecord(t *testing.T) dht.BEP44Record {
+	return newSigner(t).record(t, nil, 1)
+}
+
+func assertSameRecord(t *testing.T, want dht.BEP44Record, got *dht.BEP44Record) {
+	t.Helper()
+	require.NotNil(t, got, "record %s is not stored", want.ID())
+	assert.Equal(t, want.ID(), got.ID())
+	assert.Equal(t, want.Value, got.Value)
+	assert.Equal(t, want.Signature, got.Signature)
+	assert.Equal(t, want.SequenceNumber, got.SequenceNumber)
+}
+
+func testWriteRead(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	before, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+
+	record := newRecord(t)
+	require.NoError(t, db.WriteRecord(ctx, record))
+	got, err := db.ReadRecord(ctx, record.ID())
+	require.NoError(t, err)
+	assertSameRecord(t, record, got)
+
+	// writing the same record again doesn't store it twice
+	require.NoError(t, db.WriteRecord(ctx, record))
+	after, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before+1, after)
+
+	missing, err := db.ReadRecord(ctx, newRecord(t).ID())
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func testSalted(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	s := newSigner(t)
+	unsalted, salted := s.record(t, nil, 1), s.record(t, []byte("salt"), 2)
+	require.NoError(t, db.WriteRecord(ctx, unsalted))
+	require.NoError(t, db.WriteRecord(ctx, salted))
+
+	got, err := db.ReadRecord(ctx, unsalted.ID())
+	require.NoError(t, err)
+	assertSameRecord(t, unsalted, got)
+	assert.Empty(t, got.Salt)
+
+	got, err = db.ReadRecord(ctx, salted.ID())
+	require.NoError(t, err)
+	assertSameRecord(t, salted, got)
+	assert.Equal(t, []byte("salt"), got.Salt)
+}
+
+func testReadRecords(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	s := newSigner(t)
+	unsalted, salted, other := s.record(t, nil, 1), s.record(t, []byte("salt"), 1), newRecord(t)
+	for _, record := range []dht.BEP44Record{unsalted, salted, other} {
+		require.NoError(t, db.WriteRecord(ctx, record))
+	}
+	missing := s.record(t, []byte("missing"), 1)
+
+	records, err := db.ReadRecords(ctx, []string{unsalted.ID(), missing.ID(), other.ID(), unsalted.ID()})
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	for _, record := range []dht.BEP44Record{unsalted, other} {
+		got, ok := records[record.ID()]
+		require.True(t, ok, "record %s is not read", record.ID())
+		assertSameRecord(t, record, &got)
+	}
+
+	records, err = db.ReadRecords(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func testSeqMonotonic(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	s := newSigner(t)
+
+	require.NoError(t, db.WriteRecord(ctx, s.record(t, nil, 2)))
+	newer := s.record(t, nil, 3)
+	require.NoError(t, db.WriteRecord(ctx, newer))
+	got, err := db.ReadRecord(ctx, newer.ID())
+	require.NoError(t, err)
+	assertSameRecord(t, newer, got)
+
+	// an older record arriving late doesn't replace the current one
+	require.NoError(t, db.WriteRecord(ctx, s.record(t, nil, 1)))
+	got, err = db.ReadRecord(ctx, newer.ID())
+	require.NoError(t, err)
+	assertSameRecord(t, newer, got)
+}
+
+// listAll lists every stored record with the given page size, calling between after each page but the last
+func listAll(t *testing.T, db storage.Storage, pageSize int, between func()) map[string]int {
+	listed := make(map[string]int)
+	var nextPageToken []byte
+	for first := true; first || nextPageToken != nil; first = false {
+		page, next, err := db.ListRecords(context.Background(), nextPageToken, pageSize)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), pageSize)
+		for _, record := range page {
+			listed[record.ID()]++
+		}
+		nextPageToken = next
+		if next != nil && between != nil {
+			between()
+		}
+	}
+	return listed
+}
+
+func testList(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	written := make(map[string]bool)
+	for i := 0; i < 11; i++ {
+		s := newSigner(t)
+		for _, record := range []dht.BEP44Record{s.record(t, nil, 1), s.record(t, []byte("salt"), 1)} {
+			require.NoError(t, db.WriteRecord(ctx, record))
+			written[record.ID()] = true
+		}
+	}
+	count, err := db.RecordCount(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, count, len(written))
+
+	listed := listAll(t, db, 5, nil)
+	for id, n := range listed {
+		assert.Equal(t, 1, n, "record %s listed %d times", id, n)
+	}
+	for id := range written {
+		assert.Contains(t, listed, id)
+	}
+}
+
+func testListConcurrentWrites(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	original := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		record := newRecord(t)
+		require.NoError(t, db.WriteRecord(ctx, record))
+		original[record.ID()] = true
+	}
+
+	// records written mid-scan are listed at most once, and none written before the scan are skipped
+	listed := listAll(t, db, 5, func() {
+		require.NoError(t, db.WriteRecord(ctx, newRecord(t)))
+	})
+	for id, n := range listed {
+		assert.Equal(t, 1, n, "record %s listed %d times", id, n)
+	}
+	for id := range original {
+		assert.Contains(t, listed, id)
+	}
+}
+
+// listAllByType lists the IDs of every record of the given type, one record per page, counting how often each is
+// listed
+func listAllByType(t *testing.T, db storage.Storage, typ did.TypeIndex) map[string]int {
+	listed := make(map[string]int)
+	for _, id := range queryAllByType(t, db, dht.TypeQuery{Type: int(typ)}) {
+		listed[id]++
+	}
+	return listed
+}
+
+// queryAllByType lists the IDs of every record matching the query, one record per page, in the order listed
+func queryAllByType(t *testing.T, db storage.Storage, query dht.TypeQuery) []string {
+	var listed []string
+	var nextPageToken []byte
+	for first := true; first || nextPageToken != nil; first = false {
+		ids, next, err := db.ListRecordsByType(context.Background(), query, nextPageToken, 1)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(ids), 1)
+		listed = append(listed, ids...)
+		nextPageToken = next
+	}
+	return listed
+}
+
+func testListByType(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	org, bank := newSigner(t, did.Organization, did.FinancialInstitution), newSigner(t, did.FinancialInstitution)
+	untyped := newSigner(t)
+	for _, record := range []dht.BEP44Record{
+		org.record(t, nil, 2), bank.record(t, nil, 1), untyped.record(t, nil, 1),
+		// salted records don't publish the DID, so aren't indexed
+		untyped.retype(t, did.FinancialInstitution).record(t, []byte("salt"), 1),
+	} {
+		require.NoError(t, db.WriteRecord(ctx, record))
+	}
+	orgID, bankID, untypedID := org.record(t, nil, 1).ID(), bank.record(t, nil, 1).ID(), untyped.record(t, nil, 1).ID()
+
+	listed := listAllByType(t, db, did.FinancialInstitution)
+	for id, n := range listed {
+		assert.Equal(t, 1, n, "record %s listed %d times", id, n)
+	}
+	assert.Contains(t, listed, orgID)
+	assert.Contains(t, listed, bankID)
+	assert.NotContains(t, listed, untypedID)
+	assert.NotContains(t, listed, untyped.record(t, []byte("salt"), 1).ID())
+	assert.Contains(t, listAllByType(t, db, did.Organization), orgID)
+	assert.NotContains(t, listAllByType(t, db, did.Organization), bankID)
+
+	// a newer record replaces the DID's types, and an older one arriving late doesn't restore them
+	require.NoError(t, db.WriteRecord(ctx, org.retype(t, did.Organization).record(t, nil, 3)))
+	require.NoError(t, db.WriteRecord(ctx, org.record(t, nil, 1)))
+	assert.NotContains(t, listAllByType(t, db, did.FinancialInstitution), orgID)
+	assert.Contains(t, listAllByType(t, db, did.Organization), orgID)
+
+	// retyping an untyped DID indexes it
+	require.NoError(t, db.WriteRecord(ctx, untyped.retype(t, did.Corporation).record(t, nil, 2)))
+	assert.Contains(t, listAllByType(t, db, did.Corporation), untypedID)
+}
+
+func testListByTypeQuery(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	// times are stored to the millisecond by some drivers, so writes are spaced apart to order them
+	tick := func() time.Time {
+		time.Sleep(5 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(5 * time.Millisecond)
+		return now
+	}
+	since := tick()
+	a, b, c := newSigner(t, did.Corporation), newSigner(t, did.Corporation), newSigner(t, did.Corporation)
+	for _, s := range []signer{a, b, c} {
+		require.NoError(t, db.WriteRecord(ctx, s.record(t, nil, 1)))
+		tick()
+	}
+	require.NoError(t, db.WriteRecord(ctx, newSigner(t, did.LocalBusiness).record(t, nil, 1)))
+	aID, bID, cID := a.record(t, nil, 1).ID(), b.record(t, nil, 1).ID(), c.record(t, nil, 1).ID()
+
+	assert.ElementsMatch(t, []string{aID, bID, cID}, queryAllByType(t, db, dht.TypeQuery{Type: int(did.Corporation), UpdatedSince: since}))
+	assert.Equal(t, []string{aID, bID, cID}, queryAllByType(t, db, dht.TypeQuery{
+		Type: int(did.Corporation), UpdatedSince: since, Order: dht.OrderUpdated,
+	}))
+	assert.Equal(t, []string{cID, bID, aID}, queryAllByType(t, db, dht.TypeQuery{
+		Type: int(did.Corporation), UpdatedSince: since, Order: dht.OrderUpdatedDesc,
+	}))
+
+	// rewriting a record moves it to the end of the update order
+	resynced := tick()
+	require.NoError(t, db.WriteRecord(ctx, a.record(t, nil, 2)))
+	assert.Equal(t, []string{bID, cID, aID}, queryAllByType(t, db, dht.TypeQuery{
+		Type: int(did.Corporation), UpdatedSince: since, Order: dht.OrderUpdated,
+	}))
+	assert.Equal(t, []string{aID}, queryAllByType(t, db, dht.TypeQuery{
+		Type: int(did.Corporation), UpdatedSince: resynced, Order: dht.OrderUpdated,
+	}))
+	assert.Empty(t, queryAllByType(t, db, dht.TypeQuery{Type: int(did.Corporation), UpdatedSince: time.Now().Add(time.Hour)}))
+
+	_, _, err := db.ListRecordsByType(ctx, dht.TypeQuery{Type: int(did.Corporation), Order: dht.OrderUpdated}, []byte("invalid"), 1)
+	assert.ErrorIs(t, err, dht.ErrInvalidPageToken)
+}
+
+func testRecordVersions(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	s := newSigner(t)
+
+	// write versions out of order, including a duplicate
+	for _, seq := range []int64{3, 1, 2, 1} {
+		require.NoError(t, db.WriteRecordVersion(ctx, s.record(t, nil, seq)))
+	}
+	require.NoError(t, db.WriteRecordVersion(ctx, s.record(t, []byte("salt"), 4)))
+
+	versions, err := db.ListRecordVersions(ctx, s.record(t, nil, 1).ID())
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	for i, version := range versions {
+		assertSameRecord(t, s.record(t, nil, int64(i+1)), &version)
+	}
+
+	missing, err := db.ListRecordVersions(ctx, newRecord(t).ID())
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func testFailedRecords(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	before, err := db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+
+	id := newRecord(t).ID()
+	require.NoError(t, db.WriteFailedRecord(ctx, id))
+	require.NoError(t, db.WriteFailedRecord(ctx, id))
+
+	after, err := db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before+1, after)
+
+	failed, err := db.ListFailedRecords(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, failed, dht.FailedRecord{ID: id, Count: 2})
+
+	// clearing the count drops the record from the list, and clearing it again is a no-op
+	require.NoError(t, db.DeleteFailedRecord(ctx, id))
+	require.NoError(t, db.DeleteFailedRecord(ctx, id))
+	after, err = db.FailedRecordCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	failed, err = db.ListFailedRecords(ctx)
+	require.NoError(t, err)
+	for _, f := range failed {
+		assert.NotEqual(t, id, f.ID)
+	}
+}
+
+func testRecordMetadata(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	s := newSigner(t)
+	id := s.record(t, nil, 1).ID()
+	missing := newRecord(t).ID()
+
+	require.NoError(t, db.WriteRecord(ctx, s.record(t, nil, 1)))
+	written, err := db.ReadRecordMetadata(ctx, id)
+	require.NoError(t, err)
+	require.NotNil(t, written)
+	assert.WithinDuration(t, time.Now(), written.Created, time.Minute)
+	assert.Equal(t, written.Created, written.Updated)
+	assert.Nil(t, written.LastRepublished)
+	assert.Zero(t, written.Resolutions)
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, db.WriteRecord(ctx, s.record(t, nil, 2)))
+	require.NoError(t, db.MarkRepublished(ctx, []string{id, missing}))
+	require.NoError(t, db.AddResolutions(ctx, map[string]int{id: 2, missing: 1}))
+	require.NoError(t, db.AddResolutions(ctx, map[string]int{id: 3}))
+
+	updated, err := db.ReadRecordMetadata(ctx, id)
+	require.NoError(t, err)
+	require.NotNil(t, updated)
+	assert.True(t, updated.Created.Equal(written.Created))
+	assert.True(t, updated.Updated.After(written.Updated))
+	require.NotNil(t, updated.LastRepublished)
+	assert.WithinDuration(t, time.Now(), *updated.LastRepublished, time.Minute)
+	assert.Equal(t, int64(5), updated.Resolutions)
+
+	// updates skip records that aren't stored
+	metadata, err := db.ReadRecordMetadata(ctx, missing)
+	require.NoError(t, err)
+	assert.Nil(t, metadata)
+}
+
+func testDeleteRecord(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	s := newSigner(t, did.TypeIndex(1))
+	record := s.record(t, nil, 1)
+	salted := s.record(t, []byte("salt"), 1)
+	id := record.ID()
+	for _, r := range []dht.BEP44Record{record, salted} {
+		require.NoError(t, db.WriteRecord(ctx, r))
+		require.NoError(t, db.WriteRecordVersion(ctx, r))
+	}
+	require.NoError(t, db.WriteFailedRecord(ctx, id))
+
+	deleted, err := db.DeleteRecord(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	got, err := db.ReadRecord(ctx, id)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	versions, err := db.ListRecordVersions(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	metadata, err := db.ReadRecordMetadata(ctx, id)
+	require.NoError(t, err)
+	assert.Nil(t, metadata)
+	failed, err := db.ListFailedRecords(ctx)
+	require.NoError(t, err)
+	for _, f := range failed {
+		assert.NotEqual(t, id, f.ID)
+	}
+	assert.NotContains(t, listAllByType(t, db, 1), id)
+
+	// records under the same key with other salts are kept
+	got, err = db.ReadRecord(ctx, salted.ID())
+	require.NoError(t, err)
+	assertSameRecord(t, salted, got)
+
+	deleted, err = db.DeleteRecord(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func testRetainedKeys(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	firstRecord, secondRecord := newRecord(t), newRecord(t)
+	first, second := firstRecord.Key[:], secondRecord.Key[:]
+
+	require.NoError(t, db.WriteRetainedKey(ctx, first))
+	require.NoError(t, db.WriteRetainedKey(ctx, second))
+	require.NoError(t, db.WriteRetainedKey(ctx, first))
+	retained, err := db.ListRetainedKeys(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, retained, first)
+	assert.Contains(t, retained, second)
+	assert.IsIncreasing(t, retained)
+
+	require.NoError(t, db.DeleteRetainedKey(ctx, first))
+	require.NoError(t, db.DeleteRetainedKey(ctx, first))
+	retained, err = db.ListRetainedKeys(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, retained, first)
+	assert.Contains(t, retained, second)
+}
+
+func testAPIKeys(t *testing.T, db storage.Storage) {
+	ctx := context.Background()
+	created := time.Now().Truncate(time.Millisecond)
+	first := dht.APIKey{ID: newRecord(t).ID(), Name: "first", Hash: []byte("first hash"), RequestsPerSecond: 2.5, Burst: 5, PublishesPerDay: 100, Created: created}
+	second := dht.APIKey{ID: newRecord(t).ID(), Hash: []byte("second hash"), RequestsPerSecond: 1, Burst: 1, Created: created}
+
+	missing, err := db.ReadAPIKey(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	require.NoError(t, db.WriteAPIKey(ctx, first))
+	require.NoError(t, db.WriteAPIKey(ctx, second))
+	read, err := db.ReadAPIKey(ctx, first.ID)
+	require.NoError(t, err)
+	require.NotNil(t, read)
+	assertAPIKey(t, first, *read)
+
+	// writing a key with a stored ID replaces it
+	first.RequestsPerSecond, first.PublishesPerDay = 10, 0
+	require.NoError(t, db.WriteAPIKey(ctx, first))
+	keys, err := db.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	listed := make(map[string]dht.APIKey, len(keys))
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		listed[key.ID] = key
+		ids = append(ids, key.ID)
+	}
+	assert.IsIncreasing(t, ids)
+	require.Contains(t, listed, first.ID)
+	require.Contains(t, listed, second.ID)
+	assertAPIKey(t, first, listed[first.ID])
+	assertAPIKey(t, second, listed[second.ID])
+
+	deleted, err := db.DeleteAPIKey(ctx, first.ID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = db.DeleteAPIKey(ctx, first.ID)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	read, err = db.ReadAPIKey(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Nil(t, read)
+	read, err = db.ReadAPIKey(ctx, second.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, read)
+}
+
+// assertAPIKey asserts a stored API key is the one written, allowing for the precision storage keeps its creation
+// time to
+func assertAPIKey(t *testing.T, expected, actual dht.APIKey) {
+	t.Helper()
+	assert.True(t, expected.Created.Equal(actual.Created), "created %s, stored %s", expected.Created, actual.Created)
+	expected.Created, actual.Created = time.Time{}, time.Time{}
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,128 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/TBD54566975/did-dht/config"
+)
+
+const (
+	// defaultGRPCEndpoint is where OTLP is sent over gRPC without an endpoint configured
+	defaultGRPCEndpoint = "localhost:4317"
+	// otlpEndpointEnv is the standard variable naming the OTLP collector when the config doesn't
+	otlpEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+	// errorLogInterval is how often export failures are logged, so a collector that is down doesn't flood the logs
+	errorLogInterval = time.Minute
+)
+
+// stdout is where the stdout exporters write, shared so their lines don't interleave
+var stdout = &jsonWriter{w: os.Stdout}
+
+func newTraceExporter(ctx context.Context, name string, cfg config.TelemetryConfig) (sdktrace.SpanExporter, error) {
+	switch name {
+	case config.ExporterOTLPGRPC:
+		conn, err := dialCollector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return otlptrace.New(ctx, newGRPCTraceClient(conn, exportTimeout))
+	case config.ExporterStdout:
+		return otlptrace.New(ctx, &stdoutTraceClient{out: stdout})
+	default:
+		opts := []otlptracehttp.Option{otlptracehttp.WithTimeout(exportTimeout)}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+}
+
+func newMetricExporter(ctx context.Context, name string, cfg config.TelemetryConfig) (sdkmetric.Exporter, error) {
+	switch name {
+	case config.ExporterOTLPGRPC:
+		conn, err := dialCollector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return newGRPCMetricExporter(conn, exportTimeout), nil
+	case config.ExporterStdout:
+		return &stdoutMetricExporter{out: stdout}, nil
+	default:
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithTimeout(exportTimeout)}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+}
+
+// dialCollector creates a client connection to the OTLP collector, which connects lazily so the gateway starts while
+// the collector is down
+func dialCollector(cfg config.TelemetryConfig) (*grpc.ClientConn, error) {
+	endpoint, secure := grpcEndpoint(cfg)
+	creds := insecure.NewCredentials()
+	if secure {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	return grpc.NewClient(endpoint, grpc.WithTransportCredentials(creds))
+}
+
+// grpcEndpoint returns the host:port OTLP is sent to over gRPC, and whether it's sent with TLS. Without an endpoint
+// configured, it's taken from OTEL_EXPORTER_OTLP_ENDPOINT, whose http scheme sends it without TLS.
+func grpcEndpoint(cfg config.TelemetryConfig) (string, bool) {
+	if cfg.Endpoint != "" {
+		return cfg.Endpoint, !cfg.Insecure
+	}
+	env := os.Getenv(otlpEndpointEnv)
+	if env == "" {
+		return defaultGRPCEndpoint, !cfg.Insecure
+	}
+	if u, err := url.Parse(env); err == nil && u.Host != "" {
+		return u.Host, u.Scheme != "http" && !cfg.Insecure
+	}
+	return env, !cfg.Insecure
+}
+
+// exportErrorHandler logs the errors the SDK hits exporting telemetry, at most once per errorLogInterval along with
+// how many were suppressed since the last
+type exportErrorHandler struct {
+	mu         sync.Mutex
+	lastLogged time.Time
+	suppressed int
+}
+
+func (h *exportErrorHandler) Handle(err error) {
+	h.mu.Lock()
+	if time.Since(h.lastLogged) < errorLogInterval {
+		h.suppressed++
+		h.mu.Unlock()
+		return
+	}
+	suppressed := h.suppressed
+	h.lastLogged, h.suppressed = time.Now(), 0
+	h.mu.Unlock()
+
+	logrus.WithError(err).WithField("suppressed", suppressed).Warn("failed to export telemetry")
+}
@@ -0,0 +1,204 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/TBD54566975/did-dht/config"
+)
+
+// collector receives OTLP over gRPC, keeping what it's sent
+type collector struct {
+	coltracepb.UnimplementedTraceServiceServer
+
+	mu      sync.Mutex
+	traces  []*coltracepb.ExportTraceServiceRequest
+	metrics []*colmetricspb.ExportMetricsServiceRequest
+}
+
+func (c *collector) Export(_ context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.traces = append(c.traces, req)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+// metricsService serves the collector's metrics, as its trace service already has the Export method
+type metricsService struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	c *collector
+}
+
+func (m metricsService) Export(_ context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+	m.c.metrics = append(m.c.metrics, req)
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func startCollector(t *testing.T) (*collector, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c := new(collector)
+	s := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(s, c)
+	colmetricspb.RegisterMetricsServiceServer(s, metricsService{c: c})
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+	return c, lis.Addr().String()
+}
+
+// collectMetrics records a counter and a histogram, and collects them
+func collectMetrics(t *testing.T) *metricdata.ResourceMetrics {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	publishes, err := meter.Int64Counter("dht.publishes")
+	require.NoError(t, err)
+	publishes.Add(ctx, 2, metric.WithAttributes(attribute.String("result", "success")))
+	latency, err := meter.Float64Histogram("dht.resolution.duration", metric.WithExplicitBucketBoundaries(0.1, 1))
+	require.NoError(t, err)
+	latency.Record(ctx, 0.5)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	return &rm
+}
+
+func TestGRPCExporters(t *testing.T) {
+	ctx := context.Background()
+	c, endpoint := startCollector(t)
+	cfg := config.TelemetryConfig{Endpoint: endpoint, Insecure: true}
+
+	spans, err := newTraceExporter(ctx, config.ExporterOTLPGRPC, cfg)
+	require.NoError(t, err)
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	_, span := provider.Tracer("test").Start(ctx, "GetDHT")
+	span.End()
+	require.NoError(t, provider.Shutdown(ctx))
+
+	metrics, err := newMetricExporter(ctx, config.ExporterOTLPGRPC, cfg)
+	require.NoError(t, err)
+	require.NoError(t, metrics.Export(ctx, collectMetrics(t)))
+	require.NoError(t, metrics.Shutdown(ctx))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	require.Len(t, c.traces, 1)
+	assert.Equal(t, "GetDHT", c.traces[0].ResourceSpans[0].ScopeSpans[0].Spans[0].Name)
+
+	require.Len(t, c.metrics, 1)
+	got := c.metrics[0].ResourceMetrics[0].ScopeMetrics[0].Metrics
+	require.Len(t, got, 2)
+	assert.Equal(t, "dht.publishes", got[0].Name)
+	sum := got[0].GetSum()
+	require.NotNil(t, sum)
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t, int64(2), sum.DataPoints[0].GetAsInt())
+	assert.Equal(t, "result", sum.DataPoints[0].Attributes[0].Key)
+	assert.Equal(t, "success", sum.DataPoints[0].Attributes[0].Value.GetStringValue())
+	histogram := got[1].GetHistogram()
+	require.NotNil(t, histogram)
+	assert.Equal(t, uint64(1), histogram.DataPoints[0].Count)
+	assert.Equal(t, []float64{0.1, 1}, histogram.DataPoints[0].ExplicitBounds)
+	assert.Equal(t, []uint64{0, 1, 0}, histogram.DataPoints[0].BucketCounts)
+}
+
+func TestGRPCExporterCollectorDown(t *testing.T) {
+	ctx := context.Background()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	endpoint := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	prev := exportTimeout
+	exportTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { exportTimeout = prev })
+
+	// the exporter is created while the collector is down, and its exports fail without blocking past the timeout
+	metrics, err := newMetricExporter(ctx, config.ExporterOTLPGRPC, config.TelemetryConfig{Endpoint: endpoint, Insecure: true})
+	require.NoError(t, err)
+	start := time.Now()
+	assert.Error(t, metrics.Export(ctx, collectMetrics(t)))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	require.NoError(t, metrics.Shutdown(ctx))
+}
+
+func TestStdoutMetricExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := &stdoutMetricExporter{out: &jsonWriter{w: &buf}}
+	require.NoError(t, exporter.Export(context.Background(), collectMetrics(t)))
+
+	var req colmetricspb.ExportMetricsServiceRequest
+	require.NoError(t, protojson.Unmarshal(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), &req))
+	assert.Equal(t, "dht.publishes", req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name)
+}
+
+func TestGRPCEndpoint(t *testing.T) {
+	t.Setenv(otlpEndpointEnv, "")
+	endpoint, secure := grpcEndpoint(config.TelemetryConfig{})
+	assert.Equal(t, defaultGRPCEndpoint, endpoint)
+	assert.True(t, secure)
+
+	endpoint, secure = grpcEndpoint(config.TelemetryConfig{Endpoint: "collector:4317", Insecure: true})
+	assert.Equal(t, "collector:4317", endpoint)
+	assert.False(t, secure)
+
+	t.Setenv(otlpEndpointEnv, "http://collector:4317")
+	endpoint, secure = grpcEndpoint(config.TelemetryConfig{})
+	assert.Equal(t, "collector:4317", endpoint)
+	assert.False(t, secure)
+
+	t.Setenv(otlpEndpointEnv, "https://collector:4317")
+	endpoint, secure = grpcEndpoint(config.TelemetryConfig{})
+	assert.Equal(t, "collector:4317", endpoint)
+	assert.True(t, secure)
+}
+
+func TestExportErrorHandler(t *testing.T) {
+	hook := test.NewGlobal()
+	t.Cleanup(func() { logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks)) })
+
+	h := new(exportErrorHandler)
+	h.Handle(errors.New("connection refused"))
+	h.Handle(errors.New("connection refused"))
+	h.Handle(errors.New("connection refused"))
+	require.Len(t, hook.AllEntries(), 1)
+	assert.Equal(t, 0, hook.LastEntry().Data["suppressed"])
+
+	h.lastLogged = time.Now().Add(-errorLogInterval)
+	h.Handle(errors.New("connection refused"))
+	require.Len(t, hook.AllEntries(), 2)
+	assert.Equal(t, 2, hook.LastEntry().Data["suppressed"])
+}
+
+func TestSetupTelemetryUnknownExporter(t *testing.T) {
+	ctx := context.Background()
+	err := SetupTelemetry(ctx, config.Config{Telemetry: config.TelemetryConfig{Traces: "zipkin"}})
+	assert.ErrorContains(t, err, "unknown traces exporter: zipkin")
+
+	err = SetupTelemetry(ctx, config.Config{Telemetry: config.TelemetryConfig{Traces: config.ExporterPrometheus}})
+	assert.ErrorContains(t, err, "unknown traces exporter: prometheus")
+
+	err = SetupTelemetry(ctx, config.Config{Telemetry: config.TelemetryConfig{Metrics: "statsd"}})
+	assert.ErrorContains(t, err, "unknown metrics exporter: statsd")
+}
@@ -0,0 +1,339 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcTraceClient uploads spans to an OTLP collector over gRPC
+type grpcTraceClient struct {
+	conn    *grpc.ClientConn
+	client  coltracepb.TraceServiceClient
+	timeout time.Duration
+}
+
+var _ otlptrace.Client = (*grpcTraceClient)(nil)
+
+func newGRPCTraceClient(conn *grpc.ClientConn, timeout time.Duration) *grpcTraceClient {
+	return &grpcTraceClient{conn: conn, client: coltracepb.NewTraceServiceClient(conn), timeout: timeout}
+}
+
+// Start does nothing, as the connection is established lazily on the first upload
+func (c *grpcTraceClient) Start(context.Context) error {
+	return nil
+}
+
+func (c *grpcTraceClient) Stop(context.Context) error {
+	return c.conn.Close()
+}
+
+func (c *grpcTraceClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err := c.client.Export(ctx, &coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	return err
+}
+
+// grpcMetricExporter exports metrics to an OTLP collector over gRPC
+type grpcMetricExporter struct {
+	conn    *grpc.ClientConn
+	client  colmetricspb.MetricsServiceClient
+	timeout time.Duration
+}
+
+var _ sdkmetric.Exporter = (*grpcMetricExporter)(nil)
+
+func newGRPCMetricExporter(conn *grpc.ClientConn, timeout time.Duration) *grpcMetricExporter {
+	return &grpcMetricExporter{conn: conn, client: colmetricspb.NewMetricsServiceClient(conn), timeout: timeout}
+}
+
+func (e *grpcMetricExporter) Temporality(k sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(k)
+}
+
+func (e *grpcMetricExporter) Aggregation(k sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(k)
+}
+
+func (e *grpcMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	_, err := e.client.Export(ctx, &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{resourceMetrics(rm)},
+	})
+	return err
+}
+
+func (e *grpcMetricExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (e *grpcMetricExporter) Shutdown(context.Context) error {
+	return e.conn.Close()
+}
+
+// jsonWriter writes OTLP requests to a writer in the OTLP JSON encoding, one per line
+type jsonWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonWriter) write(m proto.Message) error {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(append(b, '\n'))
+	return err
+}
+
+// stdoutTraceClient writes spans to stdout rather than uploading them
+type stdoutTraceClient struct {
+	out *jsonWriter
+}
+
+var _ otlptrace.Client = (*stdoutTraceClient)(nil)
+
+func (c *stdoutTraceClient) Start(context.Context) error {
+	return nil
+}
+
+func (c *stdoutTraceClient) Stop(context.Context) error {
+	return nil
+}
+
+func (c *stdoutTraceClient) UploadTraces(_ context.Context, spans []*tracepb.ResourceSpans) error {
+	return c.out.write(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+}
+
+// stdoutMetricExporter writes metrics to stdout rather than exporting them
+type stdoutMetricExporter struct {
+	out *jsonWriter
+}
+
+var _ sdkmetric.Exporter = (*stdoutMetricExporter)(nil)
+
+func (e *stdoutMetricExporter) Temporality(k sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(k)
+}
+
+func (e *stdoutMetricExporter) Aggregation(k sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(k)
+}
+
+func (e *stdoutMetricExporter) Export(_ context.Context, rm *metricdata.ResourceMetrics) error {
+	return e.out.write(&colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{resourceMetrics(rm)},
+	})
+}
+
+func (e *stdoutMetricExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (e *stdoutMetricExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// resourceMetrics converts collected metrics to their OTLP form. Sums, gauges, and histograms are converted, which are
+// the aggregations the default aggregation selector produces; others are skipped.
+func resourceMetrics(rm *metricdata.ResourceMetrics) *metricspb.ResourceMetrics {
+	out := &metricspb.ResourceMetrics{Resource: otlpResource(rm.Resource)}
+	if rm.Resource != nil {
+		out.SchemaUrl = rm.Resource.SchemaURL()
+	}
+	for _, sm := range rm.ScopeMetrics {
+		scope := &metricspb.ScopeMetrics{
+			Scope: &commonpb.InstrumentationScope{
+				Name:    sm.Scope.Name,
+				Version: sm.Scope.Version,
+			},
+			SchemaUrl: sm.Scope.SchemaURL,
+		}
+		for _, m := range sm.Metrics {
+			if metric := otlpMetric(m); metric != nil {
+				scope.Metrics = append(scope.Metrics, metric)
+			}
+		}
+		out.ScopeMetrics = append(out.ScopeMetrics, scope)
+	}
+	return out
+}
+
+func otlpResource(r *resource.Resource) *resourcepb.Resource {
+	if r == nil {
+		return nil
+	}
+	iter := r.Iter()
+	return &resourcepb.Resource{Attributes: keyValues(&iter)}
+}
+
+// otlpMetric converts a metric to its OTLP form, or returns nil if its aggregation isn't supported
+func otlpMetric(m metricdata.Metrics) *metricspb.Metric {
+	out := &metricspb.Metric{Name: m.Name, Description: m.Description, Unit: m.Unit}
+	switch data := m.Data.(type) {
+	case metricdata.Gauge[int64]:
+		out.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: numberDataPoints(data.DataPoints)}}
+	case metricdata.Gauge[float64]:
+		out.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: numberDataPoints(data.DataPoints)}}
+	case metricdata.Sum[int64]:
+		out.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			DataPoints:             numberDataPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+			IsMonotonic:            data.IsMonotonic,
+		}}
+	case metricdata.Sum[float64]:
+		out.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			DataPoints:             numberDataPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+			IsMonotonic:            data.IsMonotonic,
+		}}
+	case metricdata.Histogram[int64]:
+		out.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			DataPoints:             histogramDataPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+		}}
+	case metricdata.Histogram[float64]:
+		out.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			DataPoints:             histogramDataPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+		}}
+	default:
+		return nil
+	}
+	return out
+}
+
+func temporality(t metricdata.Temporality) metricspb.AggregationTemporality {
+	switch t {
+	case metricdata.DeltaTemporality:
+		return metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	case metricdata.CumulativeTemporality:
+		return metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	default:
+		return metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED
+	}
+}
+
+func numberDataPoints[N int64 | float64](points []metricdata.DataPoint[N]) []*metricspb.NumberDataPoint {
+	out := make([]*metricspb.NumberDataPoint, 0, len(points))
+	for _, p := range points {
+		iter := p.Attributes.Iter()
+		point := &metricspb.NumberDataPoint{
+			Attributes:        keyValues(&iter),
+			StartTimeUnixNano: unixNano(p.StartTime),
+			TimeUnixNano:      unixNano(p.Time),
+		}
+		switch v := any(p.Value).(type) {
+		case int64:
+			point.Value = &metricspb.NumberDataPoint_AsInt{AsInt: v}
+		case float64:
+			point.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: v}
+		}
+		out = append(out, point)
+	}
+	return out
+}
+
+func histogramDataPoints[N int64 | float64](points []metricdata.HistogramDataPoint[N]) []*metricspb.HistogramDataPoint {
+	out := make([]*metricspb.HistogramDataPoint, 0, len(points))
+	for _, p := range points {
+		iter := p.Attributes.Iter()
+		sum := float64(p.Sum)
+		point := &metricspb.HistogramDataPoint{
+			Attributes:        keyValues(&iter),
+			StartTimeUnixNano: unixNano(p.StartTime),
+			TimeUnixNano:      unixNano(p.Time),
+			Count:             p.Count,
+			Sum:               &sum,
+			BucketCounts:      p.BucketCounts,
+			ExplicitBounds:    p.Bounds,
+		}
+		if v, ok := p.Min.Value(); ok {
+			min := float64(v)
+			point.Min = &min
+		}
+		if v, ok := p.Max.Value(); ok {
+			max := float64(v)
+			point.Max = &max
+		}
+		out = append(out, point)
+	}
+	return out
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func keyValues(iter *attribute.Iterator) []*commonpb.KeyValue {
+	out := make([]*commonpb.KeyValue, 0, iter.Len())
+	for iter.Next() {
+		kv := iter.Attribute()
+		out = append(out, &commonpb.KeyValue{Key: string(kv.Key), Value: anyValue(kv.Value)})
+	}
+	return out
+}
+
+func anyValue(v attribute.Value) *commonpb.AnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case attribute.BOOLSLICE:
+		var values []*commonpb.AnyValue
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, anyValue(attribute.BoolValue(b)))
+		}
+		return arrayValue(values)
+	case attribute.INT64SLICE:
+		var values []*commonpb.AnyValue
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, anyValue(attribute.Int64Value(i)))
+		}
+		return arrayValue(values)
+	case attribute.FLOAT64SLICE:
+		var values []*commonpb.AnyValue
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, anyValue(attribute.Float64Value(f)))
+		}
+		return arrayValue(values)
+	case attribute.STRINGSLICE:
+		var values []*commonpb.AnyValue
+		for _, s := range v.AsStringSlice() {
+			values = append(values, anyValue(attribute.StringValue(s)))
+		}
+		return arrayValue(values)
+	default:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.Emit()}}
+	}
+}
+
+func arrayValue(values []*commonpb.AnyValue) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+}
@@ -0,0 +1,256 @@
+package telemetry
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// PrometheusContentType is the content type of the Prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// invalidNameChars are the characters Prometheus metric and label names can't have
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	// unitSuffixes are the suffixes of the names of metrics with each OpenTelemetry unit
+	unitSuffixes = map[string]string{
+		"ns": "nanoseconds",
+		"us": "microseconds",
+		"ms": "milliseconds",
+		"s":  "seconds",
+		"By": "bytes",
+	}
+)
+
+// PrometheusHandler serves the metrics collected since SetupTelemetry, in the Prometheus text exposition format.
+// It responds with a 503 unless SetupTelemetry was called with the metrics endpoint enabled.
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if prometheusReader == nil {
+			http.Error(w, "metrics aren't collected", http.StatusServiceUnavailable)
+			return
+		}
+		servePrometheus(w, r, prometheusReader)
+	})
+}
+
+// servePrometheus collects the reader's metrics and writes them in the Prometheus text exposition format
+func servePrometheus(w http.ResponseWriter, r *http.Request, reader sdkmetric.Reader) {
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(r.Context(), &rm); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("failed to collect metrics")
+		http.Error(w, "failed to collect metrics", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	writePrometheus(&buf, rm)
+	w.Header().Set("Content-Type", PrometheusContentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// metricFamily is the samples of a Prometheus metric, by their labels
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []metricSample
+}
+
+// metricSample is a line of a metric family: the sample of one set of labels, or one of a histogram's series
+type metricSample struct {
+	// labels are the attributes of the data point the sample is of, sorting samples
+	labels string
+	// line is the sample's line, without its trailing newline
+	line string
+}
+
+// writePrometheus writes the metrics in the Prometheus text exposition format: sums as counters if monotonic and as
+// gauges otherwise, gauges, and histograms, named from the metric names and units as OpenTelemetry's Prometheus
+// exporter names them. Metrics of other aggregations are left out. Families are written in name order, and their
+// samples in label order, so the output is stable between scrapes.
+func writePrometheus(buf *bytes.Buffer, rm metricdata.ResourceMetrics) {
+	families := make(map[string]*metricFamily)
+	add := func(name, help, kind string, samples []metricSample) {
+		family, ok := families[name]
+		if !ok {
+			family = &metricFamily{name: name, help: help, kind: kind}
+			families[name] = family
+		}
+		// instruments of different kinds can't share a name, so the first one named keeps it
+		if family.kind == kind {
+			family.samples = append(family.samples, samples...)
+		}
+	}
+
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				name, kind := sumName(m, data.IsMonotonic)
+				add(name, m.Description, kind, pointSamples(name, data.DataPoints))
+			case metricdata.Sum[float64]:
+				name, kind := sumName(m, data.IsMonotonic)
+				add(name, m.Description, kind, pointSamples(name, data.DataPoints))
+			case metricdata.Gauge[int64]:
+				name := prometheusName(m.Name, m.Unit)
+				add(name, m.Description, "gauge", pointSamples(name, data.DataPoints))
+			case metricdata.Gauge[float64]:
+				name := prometheusName(m.Name, m.Unit)
+				add(name, m.Description, "gauge", pointSamples(name, data.DataPoints))
+			case metricdata.Histogram[int64]:
+				name := prometheusName(m.Name, m.Unit)
+				add(name, m.Description, "histogram", histogramSamples(name, data.DataPoints))
+			case metricdata.Histogram[float64]:
+				name := prometheusName(m.Name, m.Unit)
+				add(name, m.Description, "histogram", histogramSamples(name, data.DataPoints))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		family := families[name]
+		if family.help != "" {
+			fmt.Fprintf(buf, "# HELP %s %s\n", name, escapeHelp(family.help))
+		}
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, family.kind)
+		// a stable sort keeps each histogram's series in order
+		slices.SortStableFunc(family.samples, func(a, b metricSample) int { return strings.Compare(a.labels, b.labels) })
+		for _, sample := range family.samples {
+			buf.WriteString(sample.line)
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// sumName returns the name and kind of the Prometheus metric of a sum
+func sumName(m metricdata.Metrics, monotonic bool) (string, string) {
+	name := prometheusName(m.Name, m.Unit)
+	if !monotonic {
+		return name, "gauge"
+	}
+	if !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	return name, "counter"
+}
+
+// pointSamples returns the samples of the data points of a sum or gauge
+func pointSamples[N int64 | float64](name string, points []metricdata.DataPoint[N]) []metricSample {
+	samples := make([]metricSample, 0, len(points))
+	for _, point := range points {
+		labels := formatLabels(point.Attributes)
+		samples = append(samples, metricSample{labels: labels, line: name + labels + " " + formatNumber(point.Value)})
+	}
+	return samples
+}
+
+// histogramSamples returns the cumulative buckets, sum, and count of the data points of a histogram
+func histogramSamples[N int64 | float64](name string, points []metricdata.HistogramDataPoint[N]) []metricSample {
+	var samples []metricSample
+	for _, point := range points {
+		labels := formatLabels(point.Attributes)
+		var cumulative uint64
+		for i, bound := range point.Bounds {
+			cumulative += point.BucketCounts[i]
+			samples = append(samples, metricSample{
+				labels: labels,
+				line:   name + "_bucket" + withLabel(labels, "le", formatFloat(bound)) + " " + strconv.FormatUint(cumulative, 10),
+			})
+		}
+		samples = append(samples,
+			metricSample{labels: labels, line: name + "_bucket" + withLabel(labels, "le", "+Inf") + " " + strconv.FormatUint(point.Count, 10)},
+			metricSample{labels: labels, line: name + "_sum" + labels + " " + formatNumber(point.Sum)},
+			metricSample{labels: labels, line: name + "_count" + labels + " " + strconv.FormatUint(point.Count, 10)},
+		)
+	}
+	return samples
+}
+
+// prometheusName returns the Prometheus name of a metric: its name with the characters Prometheus names can't have
+// replaced, suffixed by its unit
+func prometheusName(name, unit string) string {
+	name = sanitizeName(name)
+	if suffix, ok := unitSuffixes[unit]; ok && !strings.HasSuffix(name, "_"+suffix) {
+		name += "_" + suffix
+	}
+	return name
+}
+
+func sanitizeName(name string) string {
+	name = invalidNameChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// formatLabels returns the attributes as the labels of a sample, in braces, or empty without attributes
+func formatLabels(attrs attribute.Set) string {
+	if attrs.Len() == 0 {
+		return ""
+	}
+	labels := make([]string, 0, attrs.Len())
+	for _, kv := range attrs.ToSlice() {
+		labels = append(labels, sanitizeName(string(kv.Key))+`="`+escapeLabelValue(kv.Value.Emit())+`"`)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// withLabel adds a label to the formatted labels of a sample
+func withLabel(labels, name, value string) string {
+	label := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + label + "}"
+	}
+	return labels[:len(labels)-1] + "," + label + "}"
+}
+
+func formatNumber[N int64 | float64](v N) string {
+	switch v := any(v).(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v)
+	}
+	return ""
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	publishes, err := meter.Int64Counter("dht.publishes", metric.WithDescription("records published\nby result"))
+	require.NoError(t, err)
+	publishes.Add(ctx, 2, metric.WithAttributes(attribute.String("result", "success")))
+	publishes.Add(ctx, 1, metric.WithAttributes(attribute.String("result", `fail"ure`)))
+
+	latency, err := meter.Float64Histogram("dht.resolution.duration", metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.1, 1))
+	require.NoError(t, err)
+	latency.Record(ctx, 0.05, metric.WithAttributes(attribute.String("source", "cache")))
+	latency.Record(ctx, 0.5, metric.WithAttributes(attribute.String("source", "cache")))
+	latency.Record(ctx, 2, metric.WithAttributes(attribute.String("source", "cache")))
+
+	inFlight, err := meter.Int64UpDownCounter("server.in-flight")
+	require.NoError(t, err)
+	inFlight.Add(ctx, 3)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	var buf bytes.Buffer
+	writePrometheus(&buf, rm)
+	assert.Equal(t, `# HELP dht_publishes_total records published\nby result
+# TYPE dht_publishes_total counter
+dht_publishes_total{result="fail\"ure"} 1
+dht_publishes_total{result="success"} 2
+# TYPE dht_resolution_duration_seconds histogram
+dht_resolution_duration_seconds_bucket{source="cache",le="0.1"} 1
+dht_resolution_duration_seconds_bucket{source="cache",le="1"} 2
+dht_resolution_duration_seconds_bucket{source="cache",le="+Inf"} 3
+dht_resolution_duration_seconds_sum{source="cache"} 2.55
+dht_resolution_duration_seconds_count{source="cache"} 3
+# TYPE server_in_flight gauge
+server_in_flight 3
+`, buf.String())
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	reader := sdkmetric.NewManualReader()
+	counter, err := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test").Int64Counter("requests")
+	require.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	w = httptest.NewRecorder()
+	servePrometheus(w, httptest.NewRequest(http.MethodGet, "/metrics", nil), reader)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, PrometheusContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "# TYPE requests_total counter\nrequests_total 1\n", w.Body.String())
+}
@@ -2,13 +2,14 @@ package telemetry
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/contrib/instrumentation/runtime"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
@@ -21,17 +22,52 @@ import (
 
 const (
 	scopeName = "github.com/TBD54566975/did-dht"
+
+	defaultExportTimeout = 10 * time.Second
 )
 
 var (
 	tracer        trace.Tracer
+	tracerOnce    sync.Once
+	meter         metric.Meter
+	meterOnce     sync.Once
 	traceProvider *sdktrace.TracerProvider
 	meterProvider *sdkmetric.MeterProvider
 	propagator    propagation.TextMapPropagator
+	// prometheusReader collects the metrics served by PrometheusHandler, or is nil if they aren't collected
+	prometheusReader *sdkmetric.ManualReader
+	// exportTimeout bounds each export, and flushing the exporters on shutdown
+	exportTimeout = defaultExportTimeout
 )
 
-// SetupTelemetry initializes the OpenTelemetry SDK with the appropriate exporters and propagators.
-func SetupTelemetry(ctx context.Context) error {
+// SetupTelemetry initializes the OpenTelemetry SDK, sending traces and metrics with the exporters the config names,
+// and collecting metrics for Prometheus to scrape through PrometheusHandler when the config serves them. Exporters
+// connect to collectors lazily and bound each export by the export timeout, so a collector that is down never holds
+// up the gateway; export failures are logged instead.
+func SetupTelemetry(ctx context.Context, cfg config.Config) error {
+	tracesExporter, metricsExporter := cfg.TracesExporter(), cfg.MetricsExporter()
+	switch tracesExporter {
+	case config.ExporterNone, config.ExporterOTLPGRPC, config.ExporterOTLPHTTP, config.ExporterStdout:
+	default:
+		return fmt.Errorf("unknown traces exporter: %s", tracesExporter)
+	}
+	switch metricsExporter {
+	case config.ExporterNone, config.ExporterOTLPGRPC, config.ExporterOTLPHTTP, config.ExporterStdout,
+		config.ExporterPrometheus:
+	default:
+		return fmt.Errorf("unknown metrics exporter: %s", metricsExporter)
+	}
+	pushesMetrics := metricsExporter != config.ExporterNone && metricsExporter != config.ExporterPrometheus
+	if tracesExporter == config.ExporterNone && !pushesMetrics && !cfg.ServesMetrics() {
+		return nil
+	}
+
+	exportTimeout = defaultExportTimeout
+	if cfg.Telemetry.ExportTimeoutSeconds > 0 {
+		exportTimeout = time.Duration(cfg.Telemetry.ExportTimeoutSeconds) * time.Second
+	}
+	otel.SetErrorHandler(new(exportErrorHandler))
+
 	r, err := resource.Merge(
 		resource.Default(),
 		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(scopeName)),
@@ -40,20 +76,21 @@ func SetupTelemetry(ctx context.Context) error {
 		return err
 	}
 
-	// setup tracing
-	traceExporter, err := otlptracehttp.New(ctx)
-	if err != nil {
-		return err
-	}
-	traceProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(r))
-	otel.SetTracerProvider(traceProvider)
-
 	// setup metrics
-	metricExporter, err := otlpmetrichttp.New(ctx)
-	if err != nil {
-		return err
+	opts := []sdkmetric.Option{sdkmetric.WithResource(r)}
+	if pushesMetrics {
+		metricExporter, err := newMetricExporter(ctx, metricsExporter, cfg.Telemetry)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter,
+			sdkmetric.WithTimeout(exportTimeout))))
+	}
+	if cfg.ServesMetrics() {
+		prometheusReader = sdkmetric.NewManualReader()
+		opts = append(opts, sdkmetric.WithReader(prometheusReader))
 	}
-	meterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+	meterProvider = sdkmetric.NewMeterProvider(opts...)
 	otel.SetMeterProvider(meterProvider)
 
 	// setup memory metrics
@@ -62,6 +99,21 @@ func SetupTelemetry(ctx context.Context) error {
 		return err
 	}
 
+	if tracesExporter == config.ExporterNone {
+		return nil
+	}
+
+	// setup tracing
+	traceExporter, err := newTraceExporter(ctx, tracesExporter, cfg.Telemetry)
+	if err != nil {
+		return err
+	}
+	traceProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter, sdktrace.WithExportTimeout(exportTimeout)),
+		sdktrace.WithResource(r),
+	)
+	otel.SetTracerProvider(traceProvider)
+
 	// setup propagator
 	propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
 	otel.SetTextMapPropagator(propagator)
@@ -69,8 +121,12 @@ func SetupTelemetry(ctx context.Context) error {
 	return nil
 }
 
-// Shutdown stops the telemetry providers and exporters safely.
+// Shutdown stops the telemetry providers and exporters safely, flushing what they hold for no longer than the export
+// timeout.
 func Shutdown(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
 	if traceProvider != nil {
 		if err := traceProvider.Shutdown(ctx); err != nil {
 			logrus.WithError(err).Error("error shutting down trace provider")
@@ -86,8 +142,16 @@ func Shutdown(ctx context.Context) {
 
 // GetTracer returns the tracer for the application. If the tracer is not yet initialized, it will be created.
 func GetTracer() trace.Tracer {
-	if tracer == nil {
+	tracerOnce.Do(func() {
 		tracer = otel.GetTracerProvider().Tracer(scopeName, trace.WithInstrumentationVersion(config.Version))
-	}
+	})
 	return tracer
 }
+
+// GetMeter returns the meter for the application. If the meter is not yet initialized, it will be created.
+func GetMeter() metric.Meter {
+	meterOnce.Do(func() {
+		meter = otel.GetMeterProvider().Meter(scopeName, metric.WithInstrumentationVersion(config.Version))
+	})
+	return meter
+}